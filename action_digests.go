@@ -0,0 +1,182 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+)
+
+// actionDigests is the list of build statement digests of a module variant or a singleton
+// written by WriteActionDigests.
+type actionDigests struct {
+	Module    string `json:"module,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	Singleton string `json:"singleton,omitempty"`
+
+	Actions []actionDigest `json:"actions"`
+}
+
+type actionDigest struct {
+	Outputs []string `json:"outputs"`
+	Digest  string   `json:"digest"`
+}
+
+// WriteActionDigests writes a JSON list of the build statements of every module variant and
+// singleton to w, with a digest of each one, so that tools can find the actions that changed
+// between two builds or use the digests as keys for caching their results.  Module variants are
+// sorted by name and variant followed by the singletons sorted by name, and the build
+// statements of each are listed in the order they were generated.
+//
+// The digest is a SHA-256 hash of everything that affects the result of the action: the rule,
+// its variables other than "description" with all Ninja variables expanded, the variables and
+// arguments of the build statement, the inputs, implicit inputs and tools, and the outputs.  It
+// doesn't depend on the names given to the global variables and rules in the Ninja file, so it
+// is stable between builds as long as the action doesn't change.  Order-only dependencies and
+// validations are not included.
+//
+// If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) WriteActionDigests(w io.Writer) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
+
+	list := []actionDigests{}
+	add := func(entry actionDigests, buildDefs []*buildDef) error {
+		if len(buildDefs) == 0 {
+			return nil
+		}
+		entry.Actions = make([]actionDigest, 0, len(buildDefs))
+		for _, b := range buildDefs {
+			digest, err := c.actionDigest(b)
+			if err != nil {
+				return err
+			}
+			entry.Actions = append(entry.Actions, digest)
+		}
+		list = append(list, entry)
+		return nil
+	}
+
+	for _, module := range c.sortedModuleInfos() {
+		err := add(actionDigests{Module: module.Name(), Variant: module.variant.name},
+			module.actionDefs.buildDefs)
+		if err != nil {
+			return err
+		}
+	}
+
+	singletons := append([]*singletonInfo(nil), c.singletonInfo...)
+	sort.Slice(singletons, func(i, j int) bool { return singletons[i].name < singletons[j].name })
+	for _, info := range singletons {
+		if err := add(actionDigests{Singleton: info.name}, info.actionDefs.buildDefs); err != nil {
+			return err
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(list)
+}
+
+// actionDigest evaluates a build statement and computes its digest, see WriteActionDigests.
+func (c *Context) actionDigest(b *buildDef) (actionDigest, error) {
+	e := &actionEvaluator{context: c, buildDef: b}
+	d := actionDigester{sha256.New()}
+
+	var err error
+	evalLists := func(lists ...[]ninjaString) []string {
+		var ret []string
+		for _, list := range lists {
+			if err != nil {
+				return nil
+			}
+			var values []string
+			values, err = e.evalList(list)
+			ret = append(ret, values...)
+		}
+		return ret
+	}
+
+	evalVariables := func(field string, variables map[string]ninjaString) {
+		names := make([]string, 0, len(variables))
+		for name := range variables {
+			if name != "description" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err != nil {
+				return
+			}
+			var value string
+			value, err = e.eval(variables[name])
+			d.field(field, name, value)
+		}
+	}
+
+	if b.RuleDef == nil {
+		// Built-in rules like phony have no definition, so their name identifies them.
+		d.field("rule", b.Rule.name())
+	} else {
+		d.field("rule")
+		evalVariables("rule_variable", b.RuleDef.Variables)
+		d.field("tools", evalLists(b.RuleDef.CommandDeps)...)
+	}
+
+	evalVariables("variable", b.Variables)
+
+	args := make(map[string]ninjaString, len(b.Args))
+	for arg, value := range b.Args {
+		args[arg.name()] = value
+	}
+	evalVariables("arg", args)
+
+	d.field("inputs", evalLists(b.Inputs)...)
+	d.field("implicits", evalLists(b.Implicits)...)
+	outputs := evalLists(b.Outputs)
+	implicitOutputs := evalLists(b.ImplicitOutputs)
+	d.field("outputs", outputs...)
+	d.field("implicit_outputs", implicitOutputs...)
+	if err != nil {
+		return actionDigest{}, fmt.Errorf("build statement with rule %q: %s",
+			b.Rule.fullName(c.pkgNames), err)
+	}
+
+	return actionDigest{
+		Outputs: append(outputs, implicitOutputs...),
+		Digest:  hex.EncodeToString(d.h.Sum(nil)),
+	}, nil
+}
+
+// actionDigester writes the fields of an action into a hash in an unambiguous encoding.
+type actionDigester struct {
+	h hash.Hash
+}
+
+func (d actionDigester) field(name string, values ...string) {
+	fmt.Fprintf(d.h, "%s %d\n", name, len(values))
+	for _, v := range values {
+		fmt.Fprintf(d.h, "%d:%s\n", len(v), v)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWriteActionDigests(t *testing.T) {
+	run := func(t *testing.T, bp string, stableNames bool) []actionDigests {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetStableNinjaNames(stableNames)
+		ctx.RegisterModuleType("strict_module", newStrictTestModule)
+		ctx.RegisterSingletonType("build_statements_test", func() Singleton {
+			return &buildStatementsTestSingleton{}
+		})
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+		if err := ctx.WriteActionDigests(&bytes.Buffer{}); err != ErrBuildActionsNotReady {
+			t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+		}
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %s", errs)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteActionDigests(buf); err != nil {
+			t.Fatal(err)
+		}
+		var list []actionDigests
+		if err := json.Unmarshal(buf.Bytes(), &list); err != nil {
+			t.Fatalf("invalid JSON %q: %s", buf.String(), err)
+		}
+		return list
+	}
+
+	const bp = `
+		strict_module {
+			name: "b",
+			flags: "-O2",
+		}
+
+		strict_module {
+			name: "a",
+			loose: true,
+		}
+	`
+
+	list := run(t, bp, false)
+
+	var owners [][3]string
+	for _, entry := range list {
+		owners = append(owners, [3]string{entry.Module, entry.Variant, entry.Singleton})
+		if len(entry.Actions) != 1 || len(entry.Actions[0].Digest) != 64 {
+			t.Errorf("expected one action with a digest for %v, got %v", entry, entry.Actions)
+		}
+	}
+	wantOwners := [][3]string{{"a", "", ""}, {"b", "", ""}, {"", "", "build_statements_test"}}
+	if !reflect.DeepEqual(owners, wantOwners) {
+		t.Fatalf("want modules and singletons %q, got %q", wantOwners, owners)
+	}
+	if g, w := list[1].Actions[0].Outputs, []string{"out/b.o"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("want outputs %q, got %q", w, g)
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		// The digests don't depend on the names of the rules and variables in the Ninja file.
+		if again := run(t, bp, true); !reflect.DeepEqual(list, again) {
+			t.Errorf("digests changed between runs:\n%v\n%v", list, again)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		changed := run(t, `
+			strict_module {
+				name: "b",
+				flags: "-O3",
+			}
+
+			strict_module {
+				name: "a",
+				loose: true,
+			}
+		`, false)
+		if list[0].Actions[0].Digest != changed[0].Actions[0].Digest {
+			t.Errorf("digest of unchanged module a changed")
+		}
+		if list[1].Actions[0].Digest == changed[1].Actions[0].Digest {
+			t.Errorf("digest of module b didn't change when its flags changed")
+		}
+	})
+}
@@ -0,0 +1,471 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// This file implements the analysis cache, which allows a Context to skip parsing and evaluating
+// Blueprints files, and unpacking the properties of the modules defined in them, when the files
+// have not changed since the previous run.
+//
+// Each Blueprints file is keyed by a hash of its contents and of the variables it inherits from
+// the Blueprints files in its parent directories.  When the hash matches the cache the evaluated
+// AST is reused instead of calling parser.ParseAndEval, and the property structs for each module
+// definition are decoded from the cache instead of calling proptools.UnpackProperties.  Cached
+// property structs are only used if the module type and the layout of its property structs
+// have not changed.  Files that use select expressions also record the value of every condition
+// they used, and are only reused if the SelectEvaluator still returns the same values.
+
+// analysisCacheVersion must be incremented whenever the format of the cache file changes.
+const analysisCacheVersion = 2
+
+func init() {
+	// The AST is made up of interfaces, register all the concrete types so they can be encoded.
+	gob.Register(&parser.Assignment{})
+	gob.Register(&parser.Module{})
+	gob.Register(&parser.Bool{})
+	gob.Register(&parser.Int64{})
+	gob.Register(&parser.List{})
+	gob.Register(&parser.Map{})
+	gob.Register(&parser.Operator{})
+	gob.Register(&parser.Select{})
+	gob.Register(&parser.Call{})
+	gob.Register(&parser.String{})
+	gob.Register(&parser.Variable{})
+	gob.Register(parser.NotEvaluated{})
+}
+
+// SetAnalysisCacheFile enables the analysis cache and sets the path of the file used to store it.
+// The cache is read when the parse phase starts and rewritten with the results of the parse
+// phase when it completes without errors.  A missing or unreadable cache file is treated as an
+// empty cache.
+func (c *Context) SetAnalysisCacheFile(file string) {
+	c.checkRegistration("SetAnalysisCacheFile")
+
+	c.analysisCache = newAnalysisCache(file)
+}
+
+type analysisCache struct {
+	file string
+
+	lock sync.Mutex
+	// entries read from the cache file, keyed by the path of the Blueprints file relative to the
+	// root directory.
+	prev map[string]*analysisCacheEntry
+	// entries created during this run, keyed by the path of the Blueprints file relative to the
+	// root directory.
+	next map[string]*analysisCacheEntry
+
+	loaded bool
+
+	// counters used by tests
+	parseHits, moduleHits int
+}
+
+type analysisCacheFile struct {
+	Version int
+	Entries map[string]*analysisCacheEntry
+}
+
+type analysisCacheEntry struct {
+	// Hash of the contents of the Blueprints file and the scope it was evaluated in.
+	Hash string
+	// The evaluated AST of the Blueprints file.
+	File *parser.File
+	// The unpacked properties of each module definition in the file, indexed by the position of
+	// the module definition in File.Defs.  Entries may be nil if the module definition was not
+	// unpacked.
+	Modules map[int]*analysisCacheModule
+	// The values of the select conditions used while evaluating the file.
+	Selects map[string]analysisCacheSelect
+}
+
+type analysisCacheModule struct {
+	Type        string
+	Fingerprint string
+	Properties  []json.RawMessage
+	PropertyPos map[string]scanner.Position
+}
+
+func newAnalysisCache(file string) *analysisCache {
+	return &analysisCache{
+		file: file,
+		next: make(map[string]*analysisCacheEntry),
+	}
+}
+
+// load reads the cache file if it has not already been read.  Errors are ignored, they result
+// in an empty cache.
+func (ac *analysisCache) load() {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if ac.loaded {
+		return
+	}
+	ac.loaded = true
+	ac.prev = make(map[string]*analysisCacheEntry)
+
+	f, err := os.Open(ac.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var cacheFile analysisCacheFile
+	if err := gob.NewDecoder(f).Decode(&cacheFile); err != nil {
+		return
+	}
+	if cacheFile.Version != analysisCacheVersion {
+		return
+	}
+	ac.prev = cacheFile.Entries
+}
+
+// write atomically replaces the cache file with the entries created during this run.
+func (ac *analysisCache) write() error {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(&analysisCacheFile{
+		Version: analysisCacheVersion,
+		Entries: ac.next,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode analysis cache: %s", err)
+	}
+
+	dir := filepath.Dir(ac.file)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("failed to write analysis cache: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(ac.file)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write analysis cache: %s", err)
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), ac.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write analysis cache: %s", err)
+	}
+
+	return nil
+}
+
+type analysisCacheSelect struct {
+	Value string
+	Set   bool
+}
+
+// recordingSelectEvaluator wraps a SelectEvaluator to record the values of the conditions used
+// while evaluating a Blueprints file.
+type recordingSelectEvaluator struct {
+	evaluator parser.SelectEvaluator
+	selects   map[string]analysisCacheSelect
+}
+
+func (r *recordingSelectEvaluator) SelectValue(condition string) (string, bool) {
+	value, set := "", false
+	if r.evaluator != nil {
+		value, set = r.evaluator.SelectValue(condition)
+	}
+	r.selects[condition] = analysisCacheSelect{value, set}
+	return value, set
+}
+
+// selectsMatch returns true if evaluator returns the same values for the select conditions that
+// were recorded when a cache entry was created.
+func selectsMatch(selects map[string]analysisCacheSelect, evaluator parser.SelectEvaluator) bool {
+	for condition, recorded := range selects {
+		value, set := "", false
+		if evaluator != nil {
+			value, set = evaluator.SelectValue(condition)
+		}
+		if recorded != (analysisCacheSelect{value, set}) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAndEval returns the evaluated AST for a Blueprints file, either from the cache or by
+// calling parser.ParseAndEval.  The variables defined by the file are added to scope in either
+// case.
+func (ac *analysisCache) parseAndEval(relBlueprintsFile, filename string, r io.Reader,
+	scope *parser.Scope, evaluator parser.SelectEvaluator) (*parser.File, []error) {
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	hash := sha256.New()
+	hash.Write(contents)
+	hash.Write([]byte{0})
+	io.WriteString(hash, scope.String())
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	ac.lock.Lock()
+	prev := ac.prev[relBlueprintsFile]
+	ac.lock.Unlock()
+
+	var file *parser.File
+	var selects map[string]analysisCacheSelect
+	if prev != nil && prev.Hash == key && selectsMatch(prev.Selects, evaluator) {
+		selects = prev.Selects
+		file = prev.File
+		// Top level "=" assignments hold the final value of each local variable after any "+="
+		// assignments were applied, replay them into the scope.
+		for _, def := range file.Defs {
+			if assignment, ok := def.(*parser.Assignment); ok && assignment.Assigner == "=" {
+				if err := scope.Add(assignment); err != nil {
+					return nil, []error{err}
+				}
+			}
+		}
+		ac.lock.Lock()
+		ac.parseHits++
+		ac.lock.Unlock()
+	} else {
+		recorder := &recordingSelectEvaluator{evaluator, make(map[string]analysisCacheSelect)}
+		scope.SetSelectEvaluator(recorder)
+		var errs []error
+		file, errs = parser.ParseAndEval(filename, bytes.NewReader(contents), scope)
+		scope.SetSelectEvaluator(evaluator)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		selects = recorder.selects
+		prev = nil
+
+		if len(file.Includes) > 0 {
+			// The key doesn't cover the contents of the included files, so neither the file
+			// nor its modules can be cached.
+			return file, nil
+		}
+	}
+
+	entry := &analysisCacheEntry{
+		Hash:    key,
+		File:    file,
+		Modules: make(map[int]*analysisCacheModule),
+		Selects: selects,
+	}
+	if prev != nil {
+		for i, m := range prev.Modules {
+			entry.Modules[i] = m
+		}
+	}
+
+	ac.lock.Lock()
+	ac.next[relBlueprintsFile] = entry
+	ac.lock.Unlock()
+
+	return file, nil
+}
+
+// unpackedModule returns the cached properties for the module definition at index i of the given
+// Blueprints file, or nil if they are not cached or the cached properties do not match the
+// properties structs of the module.
+func (ac *analysisCache) unpackedModule(relBlueprintsFile string, i int,
+	module *moduleInfo) *analysisCacheModule {
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	entry := ac.next[relBlueprintsFile]
+	if entry == nil {
+		return nil
+	}
+
+	cached := entry.Modules[i]
+	if cached == nil || cached.Type != module.typeName ||
+		cached.Fingerprint != propertiesFingerprint(module.properties) ||
+		len(cached.Properties) != len(module.properties) {
+		delete(entry.Modules, i)
+		return nil
+	}
+
+	for j, p := range module.properties {
+		if err := json.Unmarshal(cached.Properties[j], p); err != nil {
+			delete(entry.Modules, i)
+			return nil
+		}
+	}
+
+	ac.moduleHits++
+	return cached
+}
+
+// recordUnpackedModule stores the unpacked properties for the module definition at index i of the
+// given Blueprints file.
+func (ac *analysisCache) recordUnpackedModule(relBlueprintsFile string, i int,
+	module *moduleInfo) {
+
+	cached := &analysisCacheModule{
+		Type:        module.typeName,
+		Fingerprint: propertiesFingerprint(module.properties),
+		PropertyPos: module.propertyPos,
+	}
+	for _, p := range module.properties {
+		data, err := json.Marshal(p)
+		if err != nil {
+			// Not all property structs can be represented in the cache, they will be unpacked
+			// on every run.
+			return
+		}
+		cached.Properties = append(cached.Properties, data)
+	}
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if entry := ac.next[relBlueprintsFile]; entry != nil {
+		entry.Modules[i] = cached
+	}
+}
+
+// propertiesFingerprint returns a string that describes the layout of a list of property structs,
+// including the dynamic types of any interface fields.
+func propertiesFingerprint(properties []interface{}) string {
+	sb := &strings.Builder{}
+	for _, p := range properties {
+		writeValueFingerprint(sb, reflect.ValueOf(p), make(map[reflect.Type]bool))
+		sb.WriteByte(';')
+	}
+	return sb.String()
+}
+
+func writeValueFingerprint(sb *strings.Builder, v reflect.Value, expanded map[reflect.Type]bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			sb.WriteString(v.Type().String())
+			return
+		}
+		writeValueFingerprint(sb, v.Elem(), expanded)
+	case reflect.Ptr:
+		sb.WriteByte('*')
+		if v.IsNil() {
+			// Describe the layout of the type a nil pointer points to, but only once per type to
+			// avoid infinite recursion on self-referential types.
+			if expanded[v.Type()] {
+				sb.WriteString(v.Type().Elem().String())
+				return
+			}
+			expanded[v.Type()] = true
+			writeValueFingerprint(sb, reflect.Zero(v.Type().Elem()), expanded)
+			return
+		}
+		writeValueFingerprint(sb, v.Elem(), expanded)
+	case reflect.Struct:
+		sb.WriteString(v.Type().String())
+		sb.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			sb.WriteString(field.Name)
+			sb.WriteByte(' ')
+			sb.WriteString(string(field.Tag))
+			sb.WriteByte(' ')
+			writeValueFingerprint(sb, v.Field(i), expanded)
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('}')
+	default:
+		sb.WriteString(v.Type().String())
+	}
+}
+
+// processModuleDefWithCache is like processModuleDef, but uses the analysis cache, if enabled, to
+// avoid unpacking the properties of module definitions that have not changed since the previous
+// run.  i is the index of moduleDef in the Defs of the Blueprints file.
+func (c *Context) processModuleDefWithCache(moduleDef *parser.Module, relBlueprintsFile string, i int,
+	scopedModuleFactories map[string]ModuleFactory) (*moduleInfo, []error) {
+
+	aliasedDef, warning := c.resolveModuleTypeAlias(moduleDef)
+	if warning != nil {
+		c.addWarning(WarningClassModuleTypeAlias, warning)
+	}
+
+	module, errs := c.processModuleDefWithCacheInternal(aliasedDef, relBlueprintsFile, i, scopedModuleFactories)
+	if module != nil && c.recordModuleDefinitions {
+		module.def = moduleDef
+	}
+	if module != nil && c.trackPropertyProvenance {
+		module.provenance = newPropertyProvenance(module.factory)
+		c.recordPropertyProvenance(module, PropertySourceFile, relBlueprintsFile, module)
+	}
+	return module, errs
+}
+
+func (c *Context) processModuleDefWithCacheInternal(moduleDef *parser.Module, relBlueprintsFile string, i int,
+	scopedModuleFactories map[string]ModuleFactory) (*moduleInfo, []error) {
+
+	if c.analysisCache == nil || len(c.propertyTagProcessors) > 0 || c.variableExpander != nil {
+		// Property tag processors and variable expanders must see every property as it is
+		// unpacked, so modules can't be restored from the cache when any are registered.
+		return processModuleDef(moduleDef, relBlueprintsFile, c.moduleFactories, scopedModuleFactories,
+			c.propertyTagProcessors, c.variableExpander, c.ignoreUnknownModuleTypes)
+	}
+
+	factory, ok := c.moduleFactories[moduleDef.Type]
+	if !ok && scopedModuleFactories != nil {
+		factory, ok = scopedModuleFactories[moduleDef.Type]
+	}
+	if ok {
+		module := newModule(factory)
+		module.typeName = moduleDef.Type
+		if cached := c.analysisCache.unpackedModule(relBlueprintsFile, i, module); cached != nil {
+			module.relBlueprintsFile = relBlueprintsFile
+			module.pos = moduleDef.TypePos
+			module.propertyPos = cached.PropertyPos
+			return module, nil
+		}
+		// The module is being discarded, drop any load hooks the factory registered for it.
+		pendingHooks.Delete(module.logicModule)
+	}
+
+	module, errs := processModuleDef(moduleDef, relBlueprintsFile, c.moduleFactories, scopedModuleFactories,
+		nil, nil, c.ignoreUnknownModuleTypes)
+	if len(errs) == 0 && module != nil {
+		c.analysisCache.recordUnpackedModule(relBlueprintsFile, i, module)
+	}
+	return module, errs
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalysisCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analysis_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "analysis_cache")
+
+	files := map[string][]byte{
+		"Blueprints": []byte(`
+			deps = ["B"]
+			deps += ["C"]
+			foo_module {
+				name: "A",
+				deps: deps,
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			bar_module {
+				name: "B",
+				deps: deps,
+			}
+			foo_module {
+				name: "C",
+				foo: "c",
+			}
+		`),
+	}
+
+	run := func(files map[string][]byte) (*Context, []string) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetAnalysisCacheFile(cacheFile)
+		ctx.MockFileSystem(files)
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterModuleType("bar_module", newBarModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		var deps []string
+		for _, name := range []string{"A", "B"} {
+			m := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+			deps = append(deps, m.(depsProvider).Deps()...)
+		}
+		return ctx, deps
+	}
+
+	ctx, deps := run(files)
+	if ctx.analysisCache.parseHits != 0 || ctx.analysisCache.moduleHits != 0 {
+		t.Errorf("expected no cache hits on first run, got %d parse hits and %d module hits",
+			ctx.analysisCache.parseHits, ctx.analysisCache.moduleHits)
+	}
+	if want := []string{"B", "C", "B", "C"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("want deps %q, got %q", want, deps)
+	}
+
+	ctx, deps = run(files)
+	if ctx.analysisCache.parseHits != 2 || ctx.analysisCache.moduleHits != 3 {
+		t.Errorf("expected 2 parse hits and 3 module hits, got %d and %d",
+			ctx.analysisCache.parseHits, ctx.analysisCache.moduleHits)
+	}
+	if want := []string{"B", "C", "B", "C"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("want deps %q, got %q", want, deps)
+	}
+	c := ctx.moduleGroupFromName("C", nil).modules.firstModule().logicModule.(*fooModule)
+	if c.Foo() != "c" {
+		t.Errorf("want foo %q, got %q", "c", c.Foo())
+	}
+
+	// Modifying the root Blueprints file changes the scope inherited by dir/Blueprints, so neither
+	// file can be reused from the cache.
+	files["Blueprints"] = []byte(`
+		deps = ["C"]
+		foo_module {
+			name: "A",
+			deps: deps,
+		}
+	`)
+	ctx, deps = run(files)
+	if ctx.analysisCache.parseHits != 0 || ctx.analysisCache.moduleHits != 0 {
+		t.Errorf("expected no cache hits after modification, got %d parse hits and %d module hits",
+			ctx.analysisCache.parseHits, ctx.analysisCache.moduleHits)
+	}
+	if want := []string{"C", "C"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("want deps %q, got %q", want, deps)
+	}
+}
+
+type analysisCacheTestSelectEvaluator map[string]string
+
+func (e analysisCacheTestSelectEvaluator) SelectValue(condition string) (string, bool) {
+	value, ok := e[condition]
+	return value, ok
+}
+
+func TestAnalysisCacheSelect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analysis_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "analysis_cache")
+
+	files := map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				foo: select("arch", {
+					"arm": "a",
+					default: "b",
+				}),
+			}
+		`),
+	}
+
+	run := func(evaluator analysisCacheTestSelectEvaluator) (*Context, string) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetAnalysisCacheFile(cacheFile)
+		ctx.SetSelectEvaluator(evaluator)
+		ctx.MockFileSystem(files)
+		ctx.RegisterModuleType("foo_module", newFooModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		m := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule
+		return ctx, m.(*fooModule).Foo()
+	}
+
+	testCases := []struct {
+		evaluator analysisCacheTestSelectEvaluator
+		hits      int
+		foo       string
+	}{
+		{analysisCacheTestSelectEvaluator{"arch": "arm"}, 0, "a"},
+		{analysisCacheTestSelectEvaluator{"arch": "arm"}, 1, "a"},
+		{analysisCacheTestSelectEvaluator{"arch": "x86"}, 0, "b"},
+		{analysisCacheTestSelectEvaluator{}, 0, "b"},
+		{analysisCacheTestSelectEvaluator{}, 1, "b"},
+	}
+
+	for i, testCase := range testCases {
+		ctx, foo := run(testCase.evaluator)
+		if ctx.analysisCache.parseHits != testCase.hits {
+			t.Errorf("run %d: want %d parse hits, got %d", i, testCase.hits, ctx.analysisCache.parseHits)
+		}
+		if foo != testCase.foo {
+			t.Errorf("run %d: want foo %q, got %q", i, testCase.foo, foo)
+		}
+	}
+}
@@ -145,6 +145,15 @@ var (
 		},
 		"builder", "extra")
 
+	customStage = pctx.StaticRule("customStage",
+		blueprint.RuleParams{
+			Command:     "$tool $args",
+			CommandDeps: []string{"$tool"},
+			Description: "stage $stage $out",
+			Restat:      true,
+		},
+		"tool", "args", "stage")
+
 	// Work around a Ninja issue.  See https://github.com/martine/ninja/pull/634
 	phony = pctx.StaticRule("phony",
 		blueprint.RuleParams{
@@ -688,15 +697,19 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 	var blueprintTools []string
 	ctx.VisitAllModulesIf(isBootstrapBinaryModule,
 		func(module blueprint.Module) {
-			if ctx.PrimaryModule(module) == module {
-				binaryModule := module.(*goBinary)
+			binaryModule := module.(*goBinary)
 
-				if binaryModule.properties.Tool_dir {
-					blueprintTools = append(blueprintTools, binaryModule.InstallPath())
-				}
-				if binaryModule.properties.PrimaryBuilder {
-					primaryBuilders = append(primaryBuilders, binaryModule)
-				}
+			// Only the default variants of a tool are built by blueprint_tools, or the primary
+			// variant if none of its variants were marked as default targets.
+			isDefaultTool := ctx.PrimaryModule(module) == module
+			if ctx.HasDefaultTargets(module) {
+				isDefaultTool = ctx.IsDefaultTarget(module)
+			}
+			if binaryModule.properties.Tool_dir && isDefaultTool {
+				blueprintTools = append(blueprintTools, binaryModule.InstallPath())
+			}
+			if ctx.PrimaryModule(module) == module && binaryModule.properties.PrimaryBuilder {
+				primaryBuilders = append(primaryBuilders, binaryModule)
 			}
 		})
 
@@ -726,6 +739,31 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 	if s.config.stage == StagePrimary {
 		ctx.AddSubninja(s.config.globFile)
 
+		// Run the custom stages, each one after the stages whose outputs it reads.
+		for _, stage := range s.config.customStages {
+			params := blueprint.BuildParams{
+				Outputs:   stage.Outputs,
+				Inputs:    stage.Inputs,
+				Implicits: stageOutputs(s.config.customStages, stage.After),
+			}
+			if stage.Tool == "" {
+				flags := append(append([]string(nil), primaryBuilderCmdlinePrefix...), stage.Args...)
+				params.Rule = generateBuildNinja
+				params.Args = map[string]string{
+					"builder": primaryBuilderFile,
+					"extra":   strings.Join(flags, " "),
+				}
+			} else {
+				params.Rule = customStage
+				params.Args = map[string]string{
+					"tool":  stage.Tool,
+					"args":  strings.Join(stage.Args, " "),
+					"stage": stage.Name,
+				}
+			}
+			ctx.Build(pctx, params)
+		}
+
 		for _, i := range s.config.primaryBuilderInvocations {
 			flags := make([]string, 0)
 			flags = append(flags, primaryBuilderCmdlinePrefix...)
@@ -733,9 +771,10 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 
 			// Build the main build.ninja
 			ctx.Build(pctx, blueprint.BuildParams{
-				Rule:    generateBuildNinja,
-				Outputs: i.Outputs,
-				Inputs:  i.Inputs,
+				Rule:      generateBuildNinja,
+				Outputs:   i.Outputs,
+				Inputs:    i.Inputs,
+				Implicits: allStageOutputs(s.config.customStages),
 				Args: map[string]string{
 					"builder": primaryBuilderFile,
 					"extra":   strings.Join(flags, " "),
@@ -13,53 +13,63 @@ import (
 // Package contains the information about a package relevant to generating documentation.
 type Package struct {
 	// Name is the name of the package.
-	Name string
+	Name string `json:"name"`
 
 	// Path is the full package path of the package as used in the primary builder.
-	Path string
+	Path string `json:"path"`
 
 	// Text is the contents of the package comment documenting the module types in the package.
-	Text string
+	Text string `json:"text,omitempty"`
 
 	// ModuleTypes is a list of ModuleType objects that contain information about each module type that is
 	// defined by the package.
-	ModuleTypes []*ModuleType
+	ModuleTypes []*ModuleType `json:"module_types"`
 }
 
 // ModuleType contains the information about a module type that is relevant to generating documentation.
 type ModuleType struct {
 	// Name is the string that will appear in Blueprints files when defining a new module of
 	// this type.
-	Name string
+	Name string `json:"name"`
 
 	// PkgPath is the full package path of the package that contains the module type factory.
-	PkgPath string
+	PkgPath string `json:"pkg_path"`
 
 	// Text is the contents of the comment documenting the module type.
-	Text template.HTML
+	Text template.HTML `json:"text,omitempty"`
 
 	// PropertyStructs is a list of PropertyStruct objects that contain information about each
 	// property struct that is used by the module type, containing all properties that are valid
 	// for the module type.
-	PropertyStructs []*PropertyStruct
+	PropertyStructs []*PropertyStruct `json:"property_structs"`
 }
 
 type PropertyStruct struct {
-	Name       string
-	Text       string
-	Properties []Property
+	Name       string     `json:"name"`
+	Text       string     `json:"text,omitempty"`
+	Properties []Property `json:"properties"`
 }
 
 type Property struct {
-	Name       string
-	OtherNames []string
-	Type       string
-	Tag        reflect.StructTag
-	Text       template.HTML
-	OtherTexts []template.HTML
-	Properties []Property
-	Default    string
-	Anonymous  bool
+	Name       string            `json:"name"`
+	OtherNames []string          `json:"other_names,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Tag        reflect.StructTag `json:"tag,omitempty"`
+	Text       template.HTML     `json:"text,omitempty"`
+	OtherTexts []template.HTML   `json:"other_texts,omitempty"`
+	Properties []Property        `json:"properties,omitempty"`
+	Default    string            `json:"default,omitempty"`
+	Anonymous  bool              `json:"-"`
+
+	// Required is true if the property is tagged `blueprint:"required"`.
+	Required bool `json:"required,omitempty"`
+	// Values contains the allowed values of a property tagged `blueprint:"enum:..."`.
+	Values []string `json:"values,omitempty"`
+	// Deprecated is true if the property is tagged `blueprint:"deprecated=..."`, with the
+	// message and removal date of the tag in DeprecationMessage and RemovalDate.
+	Deprecated         bool   `json:"deprecated,omitempty"`
+	DeprecationMessage string `json:"deprecation_message,omitempty"`
+	RemovalDate        string `json:"removal_date,omitempty"`
 }
 
 func AllPackages(pkgFiles map[string][]string, moduleTypeNameFactories map[string]reflect.Value,
@@ -213,8 +223,17 @@ func nestedPropertyStructs(s reflect.Value) []nestedProperty {
 			fieldValue := structValue.Field(i)
 
 			switch fieldValue.Kind() {
-			case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint:
+			case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Int64, reflect.Uint:
 				// Nothing
+			case reflect.Map:
+				// The values of maps of structs are documented as nested property structs.
+				elemType := fieldValue.Type().Elem()
+				if elemType.Kind() == reflect.Ptr {
+					elemType = elemType.Elem()
+				}
+				if elemType.Kind() == reflect.Struct {
+					nestStruct(field, reflect.New(elemType).Elem(), field.Name)
+				}
 			case reflect.Struct:
 				nestStruct(field, fieldValue, field.Name)
 			case reflect.Ptr, reflect.Interface:
@@ -112,6 +112,14 @@ func TestAllPackages(t *testing.T) {
 				name: "list_of_nested",
 				typ:  "list of structToNest",
 			},
+			propInfo{
+				name: "map_of_nested",
+				typ:  "map of string to structInMap",
+			},
+			propInfo{
+				name: "map_of_nested.i",
+				typ:  "string",
+			},
 			propInfo{
 				name: "nested_in_other_embedded",
 				typ:  "otherStructToNest",
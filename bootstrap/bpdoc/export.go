@@ -0,0 +1,198 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpdoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteJSON writes the documentation of the packages returned by AllPackages to w as a JSON list.
+// The texts of the module types and properties are HTML, in the same format as in the Package
+// objects.
+func WriteJSON(w io.Writer, pkgs []*Package) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(pkgs)
+}
+
+// MarkdownFileName returns the name of the file that WriteMarkdownFiles writes the documentation
+// of a package to.
+func MarkdownFileName(pkg *Package) string {
+	return strings.ReplaceAll(pkg.Path, "/", "_") + ".md"
+}
+
+// WriteMarkdownFiles writes the documentation of each package to a Markdown file in dir, named by
+// MarkdownFileName.
+func WriteMarkdownFiles(dir string, pkgs []*Package) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		buf := &bytes.Buffer{}
+		if err := WriteMarkdown(buf, pkg); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, MarkdownFileName(pkg)), buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMarkdown writes the documentation of a package to w as Markdown.  Each module type and
+// property is preceded by an HTML anchor that can be linked to, see MarkdownAnchor.
+func WriteMarkdown(w io.Writer, pkg *Package) error {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "# Package %s\n\n", pkg.Name)
+	fmt.Fprintf(buf, "`%s`\n\n", pkg.Path)
+	if text := markdownText(pkg.Text, ""); text != "" {
+		fmt.Fprintf(buf, "%s\n\n", text)
+	}
+
+	for _, mt := range pkg.ModuleTypes {
+		fmt.Fprintf(buf, "<a id=\"%s\"></a>\n", MarkdownAnchor(mt.Name, ""))
+		fmt.Fprintf(buf, "## %s\n\n", mt.Name)
+		if text := markdownText(string(mt.Text), ""); text != "" {
+			fmt.Fprintf(buf, "%s\n\n", text)
+		}
+
+		for _, ps := range mt.PropertyStructs {
+			if len(ps.Properties) == 0 {
+				continue
+			}
+			if text := markdownText(ps.Text, ""); text != "" {
+				fmt.Fprintf(buf, "%s\n\n", text)
+			}
+			writeMarkdownProperties(buf, mt.Name, "", "", ps.Properties)
+			buf.WriteString("\n")
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// MarkdownAnchor returns the id of the HTML anchor written by WriteMarkdown for a module type, or
+// for one of its properties if property is not empty.  The names of nested properties are
+// separated by ".", for example "arch.arm.cflags".
+func MarkdownAnchor(moduleType, property string) string {
+	if property == "" {
+		return moduleType
+	}
+	return moduleType + "." + property
+}
+
+// writeMarkdownProperties writes a nested Markdown list of properties.  prefix is the name of the
+// property that contains them, and indent is the indentation of the list.
+func writeMarkdownProperties(buf *bytes.Buffer, moduleType, prefix, indent string,
+	properties []Property) {
+
+	for _, p := range properties {
+		name := p.Name
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		names := []string{"**" + p.Name + "**"}
+		for _, other := range p.OtherNames {
+			names = append(names, "**"+other+"**")
+		}
+		fmt.Fprintf(buf, "%s* <a id=\"%s\"></a>%s", indent, MarkdownAnchor(moduleType, name),
+			strings.Join(names, ", "))
+		if len(p.Properties) == 0 && p.Type != "" {
+			fmt.Fprintf(buf, " (*%s*)", p.Type)
+		}
+		buf.WriteString("\n")
+
+		childIndent := indent + "  "
+		var details []string
+		if p.Deprecated {
+			deprecated := "**Deprecated**"
+			if p.RemovalDate != "" {
+				deprecated += ", will be removed after " + p.RemovalDate
+			}
+			if p.DeprecationMessage != "" {
+				deprecated += ": " + p.DeprecationMessage
+			}
+			details = append(details, deprecated+".")
+		}
+		if p.Required {
+			details = append(details, "Required.")
+		}
+		if len(p.Values) > 0 {
+			values := make([]string, len(p.Values))
+			for i, v := range p.Values {
+				values[i] = fmt.Sprintf("`%q`", v)
+			}
+			details = append(details, "Values: "+strings.Join(values, ", ")+".")
+		}
+		if p.Default != "" {
+			details = append(details, "Default: `"+p.Default+"`.")
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(buf, "\n%s%s\n", childIndent, strings.Join(details, " "))
+		}
+
+		texts := append([]string{string(p.Text)}, htmlStrings(p.OtherTexts)...)
+		for _, text := range texts {
+			if text := markdownText(text, childIndent); text != "" {
+				fmt.Fprintf(buf, "\n%s\n", text)
+			}
+		}
+
+		if len(p.Properties) > 0 {
+			buf.WriteString("\n")
+			writeMarkdownProperties(buf, moduleType, name, childIndent, p.Properties)
+		}
+	}
+}
+
+// markdownText converts a documentation text in the HTML format produced by formatText to
+// Markdown, with every non-empty line indented by indent.
+func markdownText(text, indent string) string {
+	text = strings.ReplaceAll(text, "\n\n</pre>\n", "\n</pre>\n")
+	text = strings.ReplaceAll(text, "<pre>\n\n", "```\n")
+	text = strings.ReplaceAll(text, "</pre>\n", "```\n")
+	text = html.UnescapeString(strings.TrimSpace(text))
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = indent + line
+		} else {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func htmlStrings(texts []template.HTML) []string {
+	ret := make([]string, len(texts))
+	for i, text := range texts {
+		ret[i] = string(text)
+	}
+	return ret
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpdoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var exportTestPackage = &Package{
+	Name: "cc",
+	Path: "example.com/build/cc",
+	Text: "Package cc defines C++ modules.\n",
+	ModuleTypes: []*ModuleType{{
+		Name:    "cc_library",
+		PkgPath: "example.com/build/cc",
+		Text:    formatText("cc_library builds a library.\n\tcc_library { name: \"a\" }\n"),
+		PropertyStructs: []*PropertyStruct{{
+			Name: "LibraryProperties",
+			Properties: []Property{
+				{
+					Name:     "srcs",
+					Type:     "list of string",
+					Text:     formatText("The source files, like <a>.cc.\n"),
+					Required: true,
+				},
+				{
+					Name:    "stl",
+					Type:    "string",
+					Values:  []string{"libc++", "none"},
+					Default: "libc++",
+
+					Deprecated:         true,
+					DeprecationMessage: "use the stl module",
+					RemovalDate:        "2022-06-30",
+				},
+				{
+					Name:       "arch.arm",
+					OtherNames: []string{"arch.x86"},
+					Properties: []Property{
+						{Name: "cflags", Type: "list of string", Text: formatText("Extra flags.\n")},
+					},
+				},
+			},
+		}},
+	}},
+}
+
+func TestWriteJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteJSON(buf, []*Package{exportTestPackage}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*Package
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %s", buf, err)
+	}
+	if want := []*Package{exportTestPackage}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	for _, want := range []string{
+		`"module_types": [`,
+		`"property_structs": [`,
+		`"name": "arch.arm",`,
+		`"other_names": [`,
+		`"required": true`,
+		`"text": "The source files, like &lt;a&gt;.cc.\n\n"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, buf)
+		}
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteMarkdown(buf, exportTestPackage); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# Package cc\n" +
+		"\n" +
+		"`example.com/build/cc`\n" +
+		"\n" +
+		"Package cc defines C++ modules.\n" +
+		"\n" +
+		"<a id=\"cc_library\"></a>\n" +
+		"## cc_library\n" +
+		"\n" +
+		"cc_library builds a library.\n" +
+		"```\n" +
+		"\tcc_library { name: \"a\" }\n" +
+		"```\n" +
+		"\n" +
+		"* <a id=\"cc_library.srcs\"></a>**srcs** (*list of string*)\n" +
+		"\n" +
+		"  Required.\n" +
+		"\n" +
+		"  The source files, like <a>.cc.\n" +
+		"* <a id=\"cc_library.stl\"></a>**stl** (*string*)\n" +
+		"\n" +
+		"  **Deprecated**, will be removed after 2022-06-30: use the stl module. " +
+		"Values: `\"libc++\"`, `\"none\"`. Default: `libc++`.\n" +
+		"* <a id=\"cc_library.arch.arm\"></a>**arch.arm**, **arch.x86**\n" +
+		"\n" +
+		"  * <a id=\"cc_library.arch.arm.cflags\"></a>**cflags** (*list of string*)\n" +
+		"\n" +
+		"    Extra flags.\n" +
+		"\n"
+	if buf.String() != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, buf)
+	}
+}
+
+func TestWriteMarkdownFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := WriteMarkdownFiles(dir, []*Package{exportTestPackage}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "example.com_build_cc.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Package cc\n") {
+		t.Errorf("unexpected contents:\n%s", data)
+	}
+}
@@ -215,12 +215,19 @@ func structProperties(structType *ast.StructType) (props []Property, err error)
 				return nil, err
 			}
 
+			constraints := proptools.PropertyConstraintsForTag(reflect.StructTag(tag))
+			deprecation, deprecated := proptools.PropertyDeprecationForTag(reflect.StructTag(tag))
 			props = append(props, Property{
-				Name:       name,
-				Type:       typ,
-				Tag:        reflect.StructTag(tag),
-				Text:       formatText(text),
-				Properties: innerProps,
+				Name:               name,
+				Type:               typ,
+				Tag:                reflect.StructTag(tag),
+				Text:               formatText(text),
+				Properties:         innerProps,
+				Required:           constraints.Required,
+				Values:             constraints.Enum,
+				Deprecated:         deprecated,
+				DeprecationMessage: deprecation.Message,
+				RemovalDate:        deprecation.Removal,
 			})
 		}
 	}
@@ -243,6 +250,19 @@ func getType(expr ast.Expr) (typ string, innerProps []Property, err error) {
 			return "", nil, err
 		}
 		typ = "list of " + elt
+	case *ast.MapType:
+		var key, value string
+		if key, _, err = getType(a.Key); err != nil {
+			return "", nil, err
+		}
+		// The properties of a map of structs are documented as the properties of each value.
+		if value, innerProps, err = getType(a.Value); err != nil {
+			return "", nil, err
+		}
+		if value == "" {
+			value = "struct"
+		}
+		typ = "map of " + key + " to " + value
 	case *ast.InterfaceType:
 		typ = "interface"
 	case *ast.Ident:
@@ -58,6 +58,10 @@ type OtherStructToEmbed struct {
 	H string
 }
 
+type structInMap struct {
+	I string
+}
+
 type StructWithEmbedded struct {
 	StructToEmbed
 }
@@ -81,6 +85,8 @@ type complexProps struct {
 	List_of_ints []int
 
 	List_of_nested []structToNest
+
+	Map_of_nested map[string]structInMap
 }
 
 // props docs.
@@ -89,6 +95,13 @@ type props struct {
 	A string
 }
 
+// typedProps docs.
+type typedProps struct {
+	Count int64
+	Env   map[string]string
+	Files []string
+}
+
 // for properties_test.go
 type tagTestProps struct {
 	A string `tag1:"a,b" tag2:"c"`
@@ -171,6 +184,27 @@ func TestPropertyStruct(t *testing.T) {
 	}
 }
 
+func TestPropertyStructTypes(t *testing.T) {
+	r := NewReader(pkgFiles)
+	ps, err := r.PropertyStruct(pkgPath, "typedProps", reflect.ValueOf(typedProps{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"count": "int64",
+		"env":   "map of string to string",
+		"files": "list of string",
+	}
+	got := make(map[string]string)
+	for _, prop := range ps.Properties {
+		got[prop.Name] = prop.Type
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want property types %q, got %q", want, got)
+	}
+}
+
 func TestPackage(t *testing.T) {
 	r := NewReader(pkgFiles)
 	pkg, err := r.Package(pkgPath)
@@ -46,7 +46,7 @@ var (
 func init() {
 	flagSet.Var(&versionMatch, "v", "version number the command line was generated for")
 	flagSet.Var((*patternsArgs)(&globs), "p", "pattern to include in results")
-	flagSet.Var((*excludeArgs)(&globs), "e", "pattern to exclude from results from the most recent pattern")
+	flagSet.Var((*excludeArgs)(&globs), "e", "pattern to exclude from results from the most recent pattern, or to include again if it starts with '!'")
 }
 
 // bpglob is executed through the rules in build-globs.ninja to determine whether soong_build
@@ -196,7 +196,9 @@ func writeErrorOutput(path string, globErr error) {
 // to depFile.
 //
 // The format of glob is either path/*.ext for a single directory glob, or
-// path/**/*.ext for a recursive glob.
+// path/**/*.ext for a recursive glob, optionally with brace expressions like
+// path/*.{c,h}.  Excludes starting with '!' restore files removed by earlier
+// excludes, see pathtools.Glob.
 func globsWithDepFile(fileListFile, depFile string, globs []globArg) error {
 	var results pathtools.MultipleGlobResults
 	for _, glob := range globs {
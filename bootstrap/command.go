@@ -26,6 +26,8 @@ import (
 	"runtime/debug"
 	"runtime/pprof"
 	"runtime/trace"
+	"strconv"
+	"strings"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/deptools"
@@ -36,22 +38,52 @@ type Args struct {
 	GlobFile                 string
 	DepFile                  string
 	DocFile                  string
+	DocFormat                string
 	Cpuprofile               string
 	Memprofile               string
+	ProfileAnalysis          bool
 	DelveListen              string
 	DelvePath                string
 	TraceFile                string
+	EventTraceFile           string
+	ActionManifestFile       string
+	ActionDigestsFile        string
+	GraphFile                string
+	PropertyProvenanceFile   string
+	PropertyOverridesFile    string
+	DiagnosticsFile          string
+	MetadataFile             string
+	ExtractFixture           string
+	FixtureDir               string
+	AnonymizeFixture         bool
+	MutatorSnapshotDir       string
+	AnnotateNinja            bool
+	StableNinjaNames         bool
+	WarningsAsErrors         string
+	SlowestFiles             int
+	Query                    string
+	QueryFormat              string
+	Trace                    string
+	TraceVariant             string
+	TraceRoots               string
 	RunGoTests               bool
 	UseValidations           bool
 	NoGC                     bool
 	EmptyNinjaFile           bool
+	CheckStdin               string
 	BuildDir                 string
 	ModuleListFile           string
+	Discover                 bool
+	DiscoverIgnore           string
 	NinjaBuildDir            string
 	TopFile                  string
 	GeneratingPrimaryBuilder bool
 
 	PrimaryBuilderInvocations []PrimaryBuilderInvocation
+
+	// Stages are the custom stages to run before the main stage, only used when generating the
+	// primary stage's Ninja file.
+	Stages *StageManager
 }
 
 var (
@@ -66,14 +98,40 @@ func init() {
 	flag.StringVar(&CmdlineArgs.NinjaBuildDir, "n", "", "the ninja builddir directory")
 	flag.StringVar(&CmdlineArgs.DepFile, "d", "", "the dependency file to output")
 	flag.StringVar(&CmdlineArgs.DocFile, "docs", "", "build documentation file to output")
+	flag.StringVar(&CmdlineArgs.DocFormat, "docs-format", "html", "format of the build documentation: html, json, or markdown to write a file per package to the -docs directory")
 	flag.StringVar(&CmdlineArgs.Cpuprofile, "cpuprofile", "", "write cpu profile to file")
 	flag.StringVar(&CmdlineArgs.TraceFile, "trace", "", "write trace to file")
+	flag.StringVar(&CmdlineArgs.EventTraceFile, "event-trace", "", "write a Chrome trace of the time spent in each mutator, singleton and module to file")
+	flag.StringVar(&CmdlineArgs.ActionManifestFile, "action-manifest", "", "write a JSON description of the inputs, tools and outputs of every strict build statement to file")
+	flag.StringVar(&CmdlineArgs.ActionDigestsFile, "action-digests", "", "write a JSON list of the build statements of every module and singleton with a digest of each one to file")
+	flag.StringVar(&CmdlineArgs.GraphFile, "graph", "", "write a canonical description of the module graph to file, for comparing runs with bpgraphdiff")
+	flag.StringVar(&CmdlineArgs.PropertyProvenanceFile, "property-provenance", "", "write a JSON description of the sources that set every module property to file")
+	flag.StringVar(&CmdlineArgs.PropertyOverridesFile, "property-overrides", "", "apply the JSON list of module property overrides in file, see blueprint.ParsePropertyOverrides")
+	flag.StringVar(&CmdlineArgs.DiagnosticsFile, "diagnostics", "", "write a JSON description of the errors and warnings reported while processing the Blueprints files to file")
+	flag.StringVar(&CmdlineArgs.MetadataFile, "metadata", "", "write a JSON object of the values recorded by modules with ModuleContext.RecordMetadata to file after the Ninja file")
+	flag.StringVar(&CmdlineArgs.ExtractFixture, "extract-fixture", "", "comma separated list of modules to extract with their dependencies into a standalone tree in -fixture-dir, for reproducing bugs")
+	flag.StringVar(&CmdlineArgs.FixtureDir, "fixture-dir", "fixture", "the directory to write the tree extracted by -extract-fixture to")
+	flag.BoolVar(&CmdlineArgs.AnonymizeFixture, "anonymize-fixture", false, "replace the directory and file names of the tree extracted by -extract-fixture with generated names")
 	flag.StringVar(&CmdlineArgs.Memprofile, "memprofile", "", "write memory profile to file")
+	flag.BoolVar(&CmdlineArgs.ProfileAnalysis, "profile-analysis", false, "write a CPU profile and an execution trace labeled with the phases, mutators and singletons, and a heap profile after each phase, to the "+profileAnalysisDir+" directory in the build directory")
+	flag.StringVar(&CmdlineArgs.MutatorSnapshotDir, "mutator-snapshot-dir", "", "write a snapshot of the module graph after every mutator to directory")
+	flag.StringVar(&CmdlineArgs.Query, "query", "", "print the modules matching a query over the module graph, one of deps(a), rdeps(a), somepath(a, b) or filter(type=t, property=value), and exit")
+	flag.StringVar(&CmdlineArgs.QueryFormat, "query-format", "text", "the output format of -query, one of text, json or dot")
+	flag.StringVar(&CmdlineArgs.Trace, "trace-module", "", "print where a module was defined, which mutators split it into variants and which dependencies reach it, and exit")
+	flag.StringVar(&CmdlineArgs.TraceVariant, "trace-variant", "", "the variant of the module to -trace-module, all variants if empty")
+	flag.StringVar(&CmdlineArgs.TraceRoots, "trace-roots", "", "comma separated list of modules to find dependency paths from for -trace-module, the modules that nothing depends on if empty")
+	flag.IntVar(&CmdlineArgs.SlowestFiles, "slowest-files", 0, "print the given number of Blueprints files that took the longest to process")
 	flag.BoolVar(&CmdlineArgs.NoGC, "nogc", false, "turn off GC for debugging")
+	flag.BoolVar(&CmdlineArgs.AnnotateNinja, "annotate-ninja", false, "annotate each build statement in the Ninja file with the module or singleton and the Go code that generated it")
+	flag.BoolVar(&CmdlineArgs.StableNinjaNames, "stable-ninja-names", false, "name global Ninja variables, pools and rules by a hash of their definitions instead of their Go package")
+	flag.StringVar(&CmdlineArgs.WarningsAsErrors, "warnings-as-errors", "", "comma separated list of warning classes to report as errors, for example module,format")
 	flag.BoolVar(&CmdlineArgs.RunGoTests, "t", false, "build and run go tests during bootstrap")
 	flag.BoolVar(&CmdlineArgs.UseValidations, "use-validations", false, "use validations to depend on go tests")
 	flag.StringVar(&CmdlineArgs.ModuleListFile, "l", "", "file that lists filepaths to parse")
+	flag.BoolVar(&CmdlineArgs.Discover, "discover", false, "find the Blueprints files to parse by walking the source tree instead of reading -l")
+	flag.StringVar(&CmdlineArgs.DiscoverIgnore, "discover-ignore", "**/.*,out", "comma separated list of patterns of directories that -discover doesn't search, relative to the directory of the top level Blueprints file")
 	flag.BoolVar(&CmdlineArgs.EmptyNinjaFile, "empty-ninja-file", false, "write out a 0-byte ninja file")
+	flag.StringVar(&CmdlineArgs.CheckStdin, "check-stdin", "", "check a Blueprints file read from stdin as if it were at the given path, print JSON diagnostics and exit")
 }
 
 func Main(ctx *blueprint.Context, config interface{}, generatingPrimaryBuilder bool) {
@@ -81,6 +139,10 @@ func Main(ctx *blueprint.Context, config interface{}, generatingPrimaryBuilder b
 		flag.Parse()
 	}
 
+	if CmdlineArgs.CheckStdin != "" {
+		os.Exit(CheckBlueprints(ctx, CmdlineArgs.CheckStdin, os.Stdin, os.Stdout))
+	}
+
 	if flag.NArg() != 1 {
 		fatalf("no Blueprints file specified")
 	}
@@ -101,7 +163,11 @@ func PrimaryBuilderExtraFlags(args Args, globFile, mainNinjaFile string) []strin
 		result = append(result, "-t")
 	}
 
-	result = append(result, "-l", args.ModuleListFile)
+	if args.Discover && args.ModuleListFile == "" {
+		result = append(result, "-discover", "-discover-ignore", args.DiscoverIgnore)
+	} else {
+		result = append(result, "-l", args.ModuleListFile)
+	}
 	result = append(result, "-globFile", globFile)
 	result = append(result, "-o", mainNinjaFile)
 
@@ -109,6 +175,22 @@ func PrimaryBuilderExtraFlags(args Args, globFile, mainNinjaFile string) []strin
 		result = append(result, "--empty-ninja-file")
 	}
 
+	if args.MutatorSnapshotDir != "" {
+		result = append(result, "--mutator-snapshot-dir", args.MutatorSnapshotDir)
+	}
+
+	if args.PropertyOverridesFile != "" {
+		result = append(result, "--property-overrides", args.PropertyOverridesFile)
+	}
+
+	if args.MetadataFile != "" {
+		result = append(result, "--metadata", args.MetadataFile)
+	}
+
+	if args.SlowestFiles > 0 {
+		result = append(result, "--slowest-files", strconv.Itoa(args.SlowestFiles))
+	}
+
 	if args.DelveListen != "" {
 		result = append(result, "--delve_listen", args.DelveListen)
 	}
@@ -166,6 +248,63 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 		defer trace.Stop()
 	}
 
+	var profiler *analysisProfiler
+	if args.ProfileAnalysis {
+		if args.Cpuprofile != "" || args.TraceFile != "" {
+			fatalf("-profile-analysis can't be combined with -cpuprofile or -trace")
+		}
+		var err error
+		profiler, err = startAnalysisProfiler(absolutePath(filepath.Join(args.BuildDir, profileAnalysisDir)))
+		if err != nil {
+			fatalf("error starting analysis profile: %s", err)
+		}
+		defer func() {
+			if err := profiler.stop(); err != nil {
+				fatalf("error writing analysis profile: %s", err)
+			}
+		}()
+	}
+
+	if args.MutatorSnapshotDir != "" {
+		ctx.SetMutatorSnapshotDir(absolutePath(args.MutatorSnapshotDir))
+	}
+
+	if args.EventTraceFile != "" {
+		ctx.SetCollectMetrics(true)
+	}
+
+	if args.AnnotateNinja {
+		ctx.SetAnnotateBuildStatements(true)
+	}
+
+	if args.StableNinjaNames {
+		ctx.SetStableNinjaNames(true)
+	}
+
+	if args.WarningsAsErrors != "" {
+		var classes []blueprint.WarningClass
+		for _, class := range strings.Split(args.WarningsAsErrors, ",") {
+			classes = append(classes, blueprint.WarningClass(class))
+		}
+		ctx.SetWarningsAsErrors(classes...)
+	}
+
+	if args.ExtractFixture != "" {
+		ctx.SetRecordModuleDefinitions(true)
+	}
+
+	if args.PropertyProvenanceFile != "" {
+		ctx.SetTrackPropertyProvenance(true)
+	}
+
+	if args.DiagnosticsFile != "" {
+		ctx.SetCollectDiagnostics(true)
+	}
+
+	if args.MetadataFile != "" {
+		ctx.SetMetadataFile(absolutePath(args.MetadataFile))
+	}
+
 	srcDir := filepath.Dir(args.TopFile)
 
 	ninjaDeps := make([]string, 0)
@@ -173,13 +312,41 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 	if args.ModuleListFile != "" {
 		ctx.SetModuleListFile(args.ModuleListFile)
 		ninjaDeps = append(ninjaDeps, args.ModuleListFile)
+	} else if args.Discover {
+		var ignore []string
+		for _, pattern := range strings.Split(args.DiscoverIgnore, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				ignore = append(ignore, pattern)
+			}
+		}
+		ctx.SetModuleDiscovery(blueprint.ModuleDiscovery{
+			FileName:  filepath.Base(args.TopFile),
+			Ignore:    ignore,
+			CacheFile: absolutePath(filepath.Join(args.BuildDir, bootstrapSubDir, "module-discovery.json")),
+		})
 	} else {
-		fatalf("-l <moduleListFile> is required and must be nonempty")
+		fatalf("-l <moduleListFile> or -discover is required")
 	}
+
+	if args.PropertyOverridesFile != "" {
+		f, err := os.Open(args.PropertyOverridesFile)
+		if err != nil {
+			fatalf("error opening property overrides: %s", err)
+		}
+		overrides, err := blueprint.ParsePropertyOverrides(args.PropertyOverridesFile, f)
+		f.Close()
+		if err != nil {
+			fatalf("%s", err)
+		}
+		ctx.AddPropertyOverrides(overrides...)
+		ninjaDeps = append(ninjaDeps, args.PropertyOverridesFile)
+	}
+
 	filesToParse, err := ctx.ListModulePaths(srcDir)
 	if err != nil {
 		fatalf("could not enumerate files: %v\n", err.Error())
 	}
+	ninjaDeps = append(ninjaDeps, ctx.ModuleDiscoveryDeps()...)
 
 	buildDir := config.(BootstrapConfig).BuildDir()
 
@@ -208,6 +375,11 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 		}}
 	}
 
+	customStages, err := args.Stages.Stages()
+	if err != nil {
+		fatalf("%s", err)
+	}
+
 	bootstrapConfig := &Config{
 		stage: stage,
 
@@ -216,32 +388,39 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 		runGoTests:                args.RunGoTests,
 		useValidations:            args.UseValidations,
 		primaryBuilderInvocations: invocations,
+		customStages:              customStages,
 	}
 
-	ctx.RegisterBottomUpMutator("bootstrap_plugin_deps", pluginDeps)
-	ctx.RegisterModuleType("bootstrap_go_package", newGoPackageModuleFactory(bootstrapConfig))
-	ctx.RegisterModuleType("bootstrap_go_binary", newGoBinaryModuleFactory(bootstrapConfig, false))
-	ctx.RegisterModuleType("blueprint_go_binary", newGoBinaryModuleFactory(bootstrapConfig, true))
-	ctx.RegisterSingletonType("bootstrap", newSingletonFactory(bootstrapConfig))
+	registerBootstrapTypes(ctx, bootstrapConfig)
 
-	ctx.RegisterSingletonType("glob", globSingletonFactory(bootstrapConfig, ctx))
-
-	blueprintFiles, errs := ctx.ParseFileList(filepath.Dir(args.TopFile), filesToParse, config)
+	var blueprintFiles []string
+	var errs []error
+	profiler.phase("parse", func() {
+		blueprintFiles, errs = ctx.ParseFileList(filepath.Dir(args.TopFile), filesToParse, config)
+	})
 	if len(errs) > 0 {
+		writeDiagnostics(ctx, args.DiagnosticsFile)
+		printWarnings(ctx.Warnings())
 		fatalErrors(errs)
 	}
 
 	// Add extra ninja file dependencies
 	ninjaDeps = append(ninjaDeps, blueprintFiles...)
 
-	extraDeps, errs := ctx.ResolveDependencies(config)
+	var extraDeps []string
+	profiler.phase("mutators", func() {
+		extraDeps, errs = ctx.ResolveDependencies(config)
+	})
 	if len(errs) > 0 {
+		writeDiagnostics(ctx, args.DiagnosticsFile)
+		printWarnings(ctx.Warnings())
 		fatalErrors(errs)
 	}
 	ninjaDeps = append(ninjaDeps, extraDeps...)
 
 	if args.DocFile != "" {
-		err := writeDocs(ctx, config, absolutePath(args.DocFile))
+		printWarnings(ctx.Warnings())
+		err := writeDocs(ctx, config, absolutePath(args.DocFile), args.DocFormat)
 		if err != nil {
 			fatalErrors([]error{err})
 		}
@@ -250,16 +429,53 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 
 	if c, ok := config.(ConfigStopBefore); ok {
 		if c.StopBefore() == StopBeforePrepareBuildActions {
+			printWarnings(ctx.Warnings())
 			return ninjaDeps
 		}
 	}
 
-	extraDeps, errs = ctx.PrepareBuildActions(config)
+	profiler.phase("generate", func() {
+		extraDeps, errs = ctx.PrepareBuildActions(config)
+	})
+	writeDiagnostics(ctx, args.DiagnosticsFile)
+	printWarnings(ctx.Warnings())
 	if len(errs) > 0 {
 		fatalErrors(errs)
 	}
 	ninjaDeps = append(ninjaDeps, extraDeps...)
 
+	if args.SlowestFiles > 0 {
+		printSlowestFiles(ctx.SlowestBlueprintsFiles(args.SlowestFiles))
+	}
+
+	if args.EventTraceFile != "" {
+		if err := writeEventTrace(ctx, absolutePath(args.EventTraceFile)); err != nil {
+			fatalf("error writing event trace: %s", err)
+		}
+	}
+
+	if args.Query != "" {
+		modules, err := runQuery(ctx, args.Query)
+		if err != nil {
+			fatalf("error running query: %s", err)
+		}
+		if err := writeQueryResult(os.Stdout, modules, args.QueryFormat); err != nil {
+			fatalf("error writing query result: %s", err)
+		}
+		return nil
+	}
+
+	if args.Trace != "" {
+		traces, err := runTrace(ctx, args.Trace, args.TraceVariant, args.TraceRoots)
+		if err != nil {
+			fatalf("error tracing module: %s", err)
+		}
+		if err := writeTraceResult(os.Stdout, traces); err != nil {
+			fatalf("error writing trace: %s", err)
+		}
+		return nil
+	}
+
 	if c, ok := config.(ConfigStopBefore); ok {
 		if c.StopBefore() == StopBeforeWriteNinja {
 			return ninjaDeps
@@ -300,7 +516,9 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 		}
 	}
 
-	err = ctx.WriteBuildFile(out)
+	profiler.phase("write", func() {
+		err = ctx.WriteBuildFile(out)
+	})
 	if err != nil {
 		fatalf("error writing Ninja file contents: %s", err)
 	}
@@ -319,6 +537,41 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 		}
 	}
 
+	if args.ActionManifestFile != "" {
+		if err := writeActionManifest(ctx, absolutePath(args.ActionManifestFile)); err != nil {
+			fatalf("error writing action manifest: %s", err)
+		}
+	}
+
+	if args.ActionDigestsFile != "" {
+		if err := writeActionDigests(ctx, absolutePath(args.ActionDigestsFile)); err != nil {
+			fatalf("error writing action digests: %s", err)
+		}
+	}
+
+	if args.GraphFile != "" {
+		if err := writeGraph(ctx, absolutePath(args.GraphFile)); err != nil {
+			fatalf("error writing module graph: %s", err)
+		}
+	}
+
+	if args.PropertyProvenanceFile != "" {
+		if err := writePropertyProvenance(ctx, absolutePath(args.PropertyProvenanceFile)); err != nil {
+			fatalf("error writing property provenance: %s", err)
+		}
+	}
+
+	if args.ExtractFixture != "" {
+		opts := blueprint.FixtureOptions{AnonymizePaths: args.AnonymizeFixture}
+		fixture, err := ctx.ExtractFixture(opts, strings.Split(args.ExtractFixture, ",")...)
+		if err == nil {
+			err = fixture.Write(absolutePath(args.FixtureDir))
+		}
+		if err != nil {
+			fatalf("error extracting fixture: %s", err)
+		}
+	}
+
 	if c, ok := config.(ConfigRemoveAbandonedFilesUnder); ok {
 		under, except := c.RemoveAbandonedFilesUnder(buildDir)
 		err := removeAbandonedFilesUnder(ctx, srcDir, buildDir, under, except)
@@ -339,6 +592,34 @@ func RunBlueprint(args Args, ctx *blueprint.Context, config interface{}) []strin
 	return ninjaDeps
 }
 
+func registerBootstrapTypes(ctx *blueprint.Context, bootstrapConfig *Config) {
+	ctx.RegisterBottomUpMutator("bootstrap_plugin_deps", pluginDeps)
+	ctx.RegisterModuleType("bootstrap_go_package", newGoPackageModuleFactory(bootstrapConfig))
+	ctx.RegisterModuleType("bootstrap_go_binary", newGoBinaryModuleFactory(bootstrapConfig, false))
+	ctx.RegisterModuleType("blueprint_go_binary", newGoBinaryModuleFactory(bootstrapConfig, true))
+	ctx.RegisterSingletonType("bootstrap", newSingletonFactory(bootstrapConfig))
+
+	ctx.RegisterSingletonType("glob", globSingletonFactory(bootstrapConfig, ctx))
+}
+
+// CheckBlueprints reads a single Blueprints document from r, validates it as if it were located
+// at path against the bootstrap module types and any module types already registered with ctx,
+// and writes the resulting diagnostics to w as a JSON array.  It returns the exit status to use:
+// 0 if the document is valid, or 1 if it is not.  It is used to implement the -check-stdin flag,
+// and allows validation to be embedded in git hooks or editors without a source tree.
+func CheckBlueprints(ctx *blueprint.Context, path string, r io.Reader, w io.Writer) int {
+	registerBootstrapTypes(ctx, &Config{stage: StageMain})
+
+	errs := ctx.CheckBlueprints(path, r)
+	if err := blueprint.WriteDiagnosticsJSON(w, errs, nil); err != nil {
+		fatalf("error writing diagnostics: %s", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
 func fatalf(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
 	fmt.Print("\n")
@@ -362,6 +643,98 @@ func fatalErrors(errs []error) {
 	os.Exit(1)
 }
 
+// writeDiagnostics writes the errors and warnings collected by ctx to the file passed to
+// -diagnostics, if any.
+func writeDiagnostics(ctx *blueprint.Context, path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Create(absolutePath(path))
+	if err != nil {
+		fatalf("error opening diagnostics file: %s", err)
+	}
+	defer f.Close()
+	if err := ctx.WriteDiagnostics(f); err != nil {
+		fatalf("error writing diagnostics: %s", err)
+	}
+}
+
+func printWarnings(warnings []error) {
+	yellow := "\x1b[33m"
+	unyellow := "\x1b[0m"
+
+	for _, warning := range warnings {
+		fmt.Printf("%swarning:%s %s\n", yellow, unyellow, warning.Error())
+	}
+}
+
+func printSlowestFiles(times []blueprint.BlueprintsFileTime) {
+	fmt.Printf("%-12s %-12s %-12s %-12s %s\n", "total", "parse", "modules", "glob", "file")
+	for _, t := range times {
+		fmt.Printf("%-12s %-12s %-12s %-12s %s\n", t.Total(), t.Parse, t.Modules, t.Glob, t.File)
+	}
+}
+
+func writeEventTrace(ctx *blueprint.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ctx.WriteTrace(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeActionManifest(ctx *blueprint.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ctx.WriteActionManifest(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeActionDigests(ctx *blueprint.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ctx.WriteActionDigests(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeGraph(ctx *blueprint.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ctx.SerializeGraph(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writePropertyProvenance(ctx *blueprint.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ctx.WritePropertyProvenance(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func absolutePath(path string) string {
 	if filepath.IsAbs(path) {
 		return path
@@ -139,4 +139,8 @@ type Config struct {
 	useValidations bool
 
 	primaryBuilderInvocations []PrimaryBuilderInvocation
+
+	// customStages are the custom stages run by the primary stage, in the order returned by
+	// StageManager.Stages.
+	customStages []CustomStage
 }
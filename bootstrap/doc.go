@@ -142,9 +142,20 @@
 //        bpglob during incremental builds. These outputs are listed in the
 //        dependency file output by the primary builder.
 //
+// A primary builder can add custom stages to the primary stage, for example to
+// generate code that it reads when generating build.ninja, by passing a
+// StageManager in Args.Stages when generating .bootstrap/build.ninja.  Each
+// custom stage is run after the stages listed in its After field, either by
+// its own tool or by the primary builder, and the main build.ninja is only
+// regenerated after all of them.  The outputs of each stage are implicit
+// inputs of the stages that run after it, so changing the outputs of a stage
+// reruns every stage that follows it.
+//
 // Then the main stage is at <builddir>/build.ninja, and will contain all the
 // rules generated by the primary builder. In addition, the bootstrap code
 // adds a phony rule "blueprint_tools" that depends on all blueprint_go_binary
-// rules (bpfmt, bpmodify, etc).
+// rules (bpfmt, bpmodify, etc).  For binaries with more than one variant only
+// the default targets, see BaseMutatorContext.MarkDefaultTarget, are included,
+// or the primary variant if none of the variants were marked.
 //
 package bootstrap
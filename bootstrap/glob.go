@@ -25,6 +25,7 @@ import (
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
 )
 
 // This file supports globbing source files in Blueprints files.
@@ -72,15 +73,13 @@ type GlobFileContext interface {
 // appropriate dependencies to regenerate the file if and only if the list of matching files has
 // changed.
 func GlobFile(ctx GlobFileContext, pattern string, excludes []string, fileListFile string) {
-	args := `-p "` + pattern + `"`
-	if len(excludes) > 0 {
-		args += " " + joinWithPrefixAndQuote(excludes, "-e ")
-	}
+	args := strings.Builder{}
+	writeGlobArgs(&args, pattern, excludes)
 	ctx.Build(pctx, blueprint.BuildParams{
 		Rule:    GlobRule,
 		Outputs: []string{fileListFile},
 		Args: map[string]string{
-			"args": args,
+			"args": args.String(),
 		},
 		Description: "glob " + pattern,
 	})
@@ -97,14 +96,7 @@ func multipleGlobFilesRule(ctx GlobFileContext, fileListFile string, shard int,
 		if i != 0 {
 			args.WriteString(" ")
 		}
-		args.WriteString(`-p "`)
-		args.WriteString(glob.Pattern)
-		args.WriteString(`"`)
-		for _, exclude := range glob.Excludes {
-			args.WriteString(` -e "`)
-			args.WriteString(exclude)
-			args.WriteString(`"`)
-		}
+		writeGlobArgs(&args, glob.Pattern, glob.Excludes)
 	}
 
 	ctx.Build(pctx, blueprint.BuildParams{
@@ -117,31 +109,16 @@ func multipleGlobFilesRule(ctx GlobFileContext, fileListFile string, shard int,
 	})
 }
 
-func joinWithPrefixAndQuote(strs []string, prefix string) string {
-	if len(strs) == 0 {
-		return ""
-	}
-
-	if len(strs) == 1 {
-		return prefix + `"` + strs[0] + `"`
-	}
-
-	n := len(" ") * (len(strs) - 1)
-	for _, s := range strs {
-		n += len(prefix) + len(s) + len(`""`)
-	}
-
-	ret := make([]byte, 0, n)
-	for i, s := range strs {
-		if i != 0 {
-			ret = append(ret, ' ')
-		}
-		ret = append(ret, prefix...)
-		ret = append(ret, '"')
-		ret = append(ret, s...)
-		ret = append(ret, '"')
+// writeGlobArgs writes the bpglob arguments for a pattern and its excludes.  The patterns are
+// escaped for both Ninja and the shell, as they may contain '$', brace expressions or negative
+// excludes starting with '!'.
+func writeGlobArgs(args *strings.Builder, pattern string, excludes []string) {
+	args.WriteString("-p ")
+	args.WriteString(proptools.ShellEscapeIncludingSpaces(proptools.NinjaEscape(pattern)))
+	for _, exclude := range excludes {
+		args.WriteString(" -e ")
+		args.WriteString(proptools.ShellEscapeIncludingSpaces(proptools.NinjaEscape(exclude)))
 	}
-	return string(ret)
 }
 
 // globSingleton collects any glob patterns that were seen by Context and writes out rules to
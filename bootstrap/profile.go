@@ -0,0 +1,110 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// profileAnalysisDir is the directory in the build directory that -profile-analysis writes the
+// profiles to.
+const profileAnalysisDir = "profile-analysis"
+
+// An analysisProfiler writes the profiles requested by -profile-analysis: a CPU profile and an
+// execution trace of the whole run, and a heap profile at the end of each phase.  The Context
+// attributes the time it spends to its phases, mutators and singletons with pprof labels and
+// trace regions, for example mutator=deps, and the profiler adds a trace region for each phase.
+type analysisProfiler struct {
+	dir       string
+	cpuFile   *os.File
+	traceFile *os.File
+}
+
+// startAnalysisProfiler starts the CPU profile and the execution trace, and creates the files
+// in dir.
+func startAnalysisProfiler(dir string) (*analysisProfiler, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	p := &analysisProfiler{dir: dir}
+	var err error
+	if p.cpuFile, err = os.Create(filepath.Join(dir, "cpu.pprof")); err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(p.cpuFile); err != nil {
+		p.cpuFile.Close()
+		return nil, err
+	}
+	if p.traceFile, err = os.Create(filepath.Join(dir, "trace.out")); err != nil {
+		p.stopCPUProfile()
+		return nil, err
+	}
+	if err := trace.Start(p.traceFile); err != nil {
+		p.traceFile.Close()
+		p.stopCPUProfile()
+		return nil, err
+	}
+	return p, nil
+}
+
+// phase runs f in a trace region named after the phase, and then writes the heap profile to
+// heap-<name>.pprof.  If p is nil it only runs f.
+func (p *analysisProfiler) phase(name string, f func()) {
+	if p == nil {
+		f()
+		return
+	}
+
+	trace.WithRegion(context.Background(), "phase="+name, f)
+
+	// Collect garbage so that the heap profile is up to date.
+	runtime.GC()
+	heapFile, err := os.Create(filepath.Join(p.dir, "heap-"+name+".pprof"))
+	if err == nil {
+		err = pprof.WriteHeapProfile(heapFile)
+		if closeErr := heapFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fatalf("error writing heap profile: %s", err)
+	}
+}
+
+// stop stops the CPU profile and the execution trace, and closes their files.  It does nothing if
+// p is nil.
+func (p *analysisProfiler) stop() error {
+	if p == nil {
+		return nil
+	}
+
+	trace.Stop()
+	err := p.traceFile.Close()
+	if cpuErr := p.stopCPUProfile(); err == nil {
+		err = cpuErr
+	}
+	return err
+}
+
+func (p *analysisProfiler) stopCPUProfile() error {
+	pprof.StopCPUProfile()
+	return p.cpuFile.Close()
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// runQuery evaluates a query over the resolved module graph.  The supported queries are:
+//
+//	deps(a, b)        the transitive dependencies of modules a and b
+//	rdeps(a)          the modules that transitively depend on module a
+//	somepath(a, b)    a shortest dependency path from module a to module b
+//	filter(type=t, p=v)
+//	                  the modules with module type t whose property p has value v
+func runQuery(ctx *blueprint.Context, query string) ([]*blueprint.GraphModule, error) {
+	open := strings.IndexByte(query, '(')
+	if open < 0 || !strings.HasSuffix(query, ")") {
+		return nil, fmt.Errorf("invalid query %q, expected function(arguments)", query)
+	}
+
+	function := strings.TrimSpace(query[:open])
+	var args []string
+	for _, arg := range strings.Split(query[open+1:len(query)-1], ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			args = append(args, arg)
+		}
+	}
+
+	switch function {
+	case "deps":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("deps requires at least one module")
+		}
+		return ctx.QueryDeps(args...)
+	case "rdeps":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("rdeps requires at least one module")
+		}
+		return ctx.QueryReverseDeps(args...)
+	case "somepath":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("somepath requires two modules")
+		}
+		return ctx.QuerySomePath(args[0], args[1])
+	case "filter":
+		filter := blueprint.QueryFilter{Properties: make(map[string]string)}
+		for _, arg := range args {
+			eq := strings.IndexByte(arg, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("invalid filter %q, expected name=value", arg)
+			}
+			name, value := strings.TrimSpace(arg[:eq]), strings.TrimSpace(arg[eq+1:])
+			if name == "type" {
+				filter.ModuleTypes = append(filter.ModuleTypes, value)
+			} else {
+				filter.Properties[name] = value
+			}
+		}
+		return ctx.QueryModules(filter)
+	default:
+		return nil, fmt.Errorf("unknown query function %q, expected deps, rdeps, somepath or filter", function)
+	}
+}
+
+func queryModuleId(m *blueprint.GraphModule) string {
+	if m.Variant == "" {
+		return m.Name
+	}
+	return m.Name + " (" + m.Variant + ")"
+}
+
+type queryJSONModule struct {
+	Name       string            `json:"name"`
+	Variant    string            `json:"variant,omitempty"`
+	Type       string            `json:"type"`
+	Blueprint  string            `json:"blueprint"`
+	Variations map[string]string `json:"variations,omitempty"`
+	Deps       []string          `json:"deps,omitempty"`
+}
+
+// writeQueryResult writes the modules returned by a query as text, json or dot.  The json and dot
+// formats include the dependencies between the returned modules.
+func writeQueryResult(w io.Writer, modules []*blueprint.GraphModule, format string) error {
+	included := make(map[*blueprint.GraphModule]bool, len(modules))
+	for _, m := range modules {
+		included[m] = true
+	}
+
+	switch format {
+	case "", "text":
+		for _, m := range modules {
+			if _, err := fmt.Fprintln(w, queryModuleId(m)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		result := make([]queryJSONModule, 0, len(modules))
+		for _, m := range modules {
+			jm := queryJSONModule{
+				Name:       m.Name,
+				Variant:    m.Variant,
+				Type:       m.Type,
+				Blueprint:  m.Blueprint,
+				Variations: m.Variations,
+			}
+			for _, dep := range m.Deps {
+				if included[dep.Module] {
+					jm.Deps = append(jm.Deps, queryModuleId(dep.Module))
+				}
+			}
+			result = append(result, jm)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "dot":
+		sb := &strings.Builder{}
+		sb.WriteString("digraph blueprint {\n")
+		for _, m := range modules {
+			fmt.Fprintf(sb, "  %s [label=%s];\n", strconv.Quote(queryModuleId(m)),
+				strconv.Quote(queryModuleId(m)+"\n"+m.Type))
+		}
+		for _, m := range modules {
+			for _, dep := range m.Deps {
+				if included[dep.Module] {
+					fmt.Fprintf(sb, "  %s -> %s;\n", strconv.Quote(queryModuleId(m)),
+						strconv.Quote(queryModuleId(dep.Module)))
+				}
+			}
+		}
+		sb.WriteString("}\n")
+		_, err := io.WriteString(w, sb.String())
+		return err
+	default:
+		return fmt.Errorf("unknown query format %q, expected text, json or dot", format)
+	}
+}
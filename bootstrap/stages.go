@@ -0,0 +1,155 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A CustomStage is an extra step of the bootstrap process that runs after the primary builder is
+// built and before the main stage's Ninja file is generated, for example a code generation step
+// whose outputs are read by the primary builder.  The build statements of every custom stage are
+// written to the primary stage's Ninja file, so a stage is rerun by the primary stage whenever
+// its tool, inputs or the outputs of the stages it runs after change.
+type CustomStage struct {
+	// Name identifies the stage in the After list of other stages and in errors.
+	Name string
+
+	// Tool is the path of the tool that runs the stage.  If it is empty the primary builder is run
+	// to generate the stage's outputs in the same way as the main stage's Ninja file, and Args are
+	// passed to it in addition to the bootstrap flags.
+	Tool string
+
+	// Args are the arguments passed to the tool.
+	Args []string
+
+	// Inputs are the files read by the stage.
+	Inputs []string
+
+	// Outputs are the files written by the stage, usually a Ninja file for the first one.  They
+	// are implicit inputs of the stages that run after this one and of the main stage.
+	Outputs []string
+
+	// After lists the names of the stages whose outputs are read by this stage.
+	After []string
+}
+
+// A StageManager collects the custom stages of a bootstrapped build.  It is passed to
+// RunBlueprint in Args.Stages when generating the primary stage's Ninja file, which then contains
+// the build statements to run each custom stage after the stages it depends on, and to generate
+// the main stage's Ninja file after all of them.
+type StageManager struct {
+	stages []*CustomStage
+	byName map[string]*CustomStage
+}
+
+// NewStageManager returns a StageManager without any custom stages.
+func NewStageManager() *StageManager {
+	return &StageManager{
+		byName: make(map[string]*CustomStage),
+	}
+}
+
+// AddStage adds a custom stage.  It panics if the stage has no name or no outputs, or if a stage
+// with the same name was already added.  The stages listed in After may be added later.
+func (m *StageManager) AddStage(stage CustomStage) {
+	if stage.Name == "" {
+		panic("custom stage name must not be empty")
+	}
+	if len(stage.Outputs) == 0 {
+		panic(fmt.Errorf("custom stage %q has no outputs", stage.Name))
+	}
+	if _, present := m.byName[stage.Name]; present {
+		panic(fmt.Errorf("custom stage %q is already added", stage.Name))
+	}
+
+	m.stages = append(m.stages, &stage)
+	m.byName[stage.Name] = &stage
+}
+
+// Stages returns the custom stages in an order where every stage comes after the stages listed
+// in its After field, keeping the order the stages were added in otherwise.  It returns an error
+// if a stage runs after a stage that was never added or if the stages depend on each other in a
+// cycle.
+func (m *StageManager) Stages() ([]CustomStage, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*CustomStage]int, len(m.stages))
+	ret := make([]CustomStage, 0, len(m.stages))
+
+	var visit func(stage *CustomStage, path []string) error
+	visit = func(stage *CustomStage, path []string) error {
+		path = append(path, stage.Name)
+		switch state[stage] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("cycle in the order of custom stages: %s", strings.Join(path, " -> "))
+		}
+
+		state[stage] = visiting
+		for _, name := range stage.After {
+			after, ok := m.byName[name]
+			if !ok {
+				return fmt.Errorf("custom stage %q runs after unknown stage %q", stage.Name, name)
+			}
+			if err := visit(after, path); err != nil {
+				return err
+			}
+		}
+		state[stage] = visited
+		ret = append(ret, *stage)
+		return nil
+	}
+
+	for _, stage := range m.stages {
+		if err := visit(stage, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// stageOutputs returns the outputs of the named custom stages.
+func stageOutputs(stages []CustomStage, names []string) []string {
+	var ret []string
+	for _, stage := range stages {
+		for _, name := range names {
+			if stage.Name == name {
+				ret = append(ret, stage.Outputs...)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// allStageOutputs returns the outputs of all of the custom stages.
+func allStageOutputs(stages []CustomStage) []string {
+	var ret []string
+	for _, stage := range stages {
+		ret = append(ret, stage.Outputs...)
+	}
+	return ret
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// runTrace traces the variants of a module, see blueprint.Context.TraceModule.  roots is a comma
+// separated list of module names.
+func runTrace(ctx *blueprint.Context, module, variant, roots string) ([]*blueprint.ModuleTrace, error) {
+	var rootNames []string
+	for _, root := range strings.Split(roots, ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			rootNames = append(rootNames, root)
+		}
+	}
+	return ctx.TraceModule(module, variant, rootNames...)
+}
+
+// writeTraceResult writes the traces of the variants of a module as text, for example:
+//
+//	libfoo (arm64)
+//	  type:       cc_library
+//	  defined at: foo/Blueprints:12:1
+//	  split by:   arch=arm64 link=shared
+//	  depended on by:
+//	    app (arm64) [*cc.dependencyTag]
+//	  path from roots:
+//	    app (arm64)
+//	    libfoo (arm64) [*cc.dependencyTag]
+func writeTraceResult(w io.Writer, traces []*blueprint.ModuleTrace) error {
+	sb := &strings.Builder{}
+	for i, trace := range traces {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintln(sb, queryModuleId(trace.Module))
+		fmt.Fprintf(sb, "  type:       %s\n", trace.Module.Type)
+		fmt.Fprintf(sb, "  defined at: %s\n", trace.Pos)
+		if trace.CreatedBy != "" {
+			fmt.Fprintf(sb, "  created by: %s\n", trace.CreatedBy)
+		}
+		if len(trace.Splits) > 0 {
+			var splits []string
+			for _, split := range trace.Splits {
+				splits = append(splits, split.Mutator+"="+split.Variation)
+			}
+			fmt.Fprintf(sb, "  split by:   %s\n", strings.Join(splits, " "))
+		}
+		if len(trace.Dependents) > 0 {
+			sb.WriteString("  depended on by:\n")
+			for _, step := range trace.Dependents {
+				fmt.Fprintf(sb, "    %s\n", traceStepString(step))
+			}
+		}
+		if len(trace.Path) > 0 {
+			sb.WriteString("  path from roots:\n")
+			for _, step := range trace.Path {
+				fmt.Fprintf(sb, "    %s\n", traceStepString(step))
+			}
+		} else {
+			sb.WriteString("  not reachable from roots\n")
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func traceStepString(step blueprint.TraceStep) string {
+	if step.Tag == nil {
+		return queryModuleId(step.Module)
+	}
+	return fmt.Sprintf("%s [%T]", queryModuleId(step.Module), step.Tag)
+}
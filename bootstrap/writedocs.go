@@ -75,7 +75,10 @@ func ModuleTypeDocs(ctx *blueprint.Context, config interface{}, factories map[st
 	return bpdoc.AllPackages(pkgFiles, mergedFactories, ctx.ModuleTypePropertyStructs())
 }
 
-func writeDocs(ctx *blueprint.Context, config interface{}, filename string) error {
+// writeDocs writes the documentation of the module types to filename in the given format: "html"
+// or "" for a single HTML file, "json" for a single JSON file, or "markdown" for a Markdown file
+// per package in the directory filename.
+func writeDocs(ctx *blueprint.Context, config interface{}, filename, format string) error {
 	moduleTypeList, err := ModuleTypeDocs(ctx, config, nil)
 	if err != nil {
 		return err
@@ -83,6 +86,19 @@ func writeDocs(ctx *blueprint.Context, config interface{}, filename string) erro
 
 	buf := &bytes.Buffer{}
 
+	switch format {
+	case "", "html":
+	case "json":
+		if err := bpdoc.WriteJSON(buf, moduleTypeList); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+	case "markdown":
+		return bpdoc.WriteMarkdownFiles(filename, moduleTypeList)
+	default:
+		return fmt.Errorf("unknown documentation format %q, expected html, json or markdown", format)
+	}
+
 	unique := 0
 
 	tmpl, err := template.New("file").Funcs(map[string]interface{}{
@@ -174,6 +190,9 @@ const (
           <p>{{.Text}}</p>
           {{range .OtherTexts}}<p>{{.}}</p>{{end}}
           <p><i>Type: {{.Type}}</i></p>
+          {{if .Deprecated}}<p><i>Deprecated{{if .RemovalDate}}, will be removed after {{.RemovalDate}}{{end}}{{if .DeprecationMessage}}: {{.DeprecationMessage}}{{end}}</i></p>{{end}}
+          {{if .Required}}<p><i>Required</i></p>{{end}}
+          {{if .Values}}<p><i>Values: {{range $i, $v := .Values}}{{if $i}}, {{end}}"{{$v}}"{{end}}</i></p>{{end}}
           {{if .Default}}<p><i>Default: {{.Default}}</i></p>{{end}}
         </div>
       {{end}}
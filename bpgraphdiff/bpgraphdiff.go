@@ -0,0 +1,224 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// bpgraphdiff compares two module graphs written by Context.SerializeGraph, or two snapshots
+// written by Context.SetMutatorSnapshotDir, and explains the differences module by module: the
+// module variants that were added or removed, and for the variants in both graphs the changes to
+// their type, Blueprints file, dependencies and provider values.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	exitCode = flag.Bool("exit-code", false, "exit with status 1 if the graphs differ")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bpgraphdiff [flags] <old graph> <new graph>")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+	}
+
+	oldGraph, err := readGraph(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	newGraph, err := readGraph(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if diffGraphs(oldGraph, newGraph, os.Stdout) && *exitCode {
+		os.Exit(1)
+	}
+}
+
+// graphModule is a module variant read from a serialized graph.
+type graphModule struct {
+	typ       string
+	blueprint string
+	deps      map[string]bool
+	providers map[string]string
+}
+
+func newGraphModule() *graphModule {
+	return &graphModule{
+		deps:      make(map[string]bool),
+		providers: make(map[string]string),
+	}
+}
+
+// readGraph reads a serialized graph into a map from module ids to modules.
+func readGraph(file string) (map[string]*graphModule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := make(map[string]*graphModule)
+	module := func(id string) *graphModule {
+		if graph[id] == nil {
+			graph[id] = newGraphModule()
+		}
+		return graph[id]
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line %q", file, i+1, line)
+		}
+		kind, id, rest := fields[0], fields[1], fields[2]
+		switch kind {
+		case "module":
+			m := module(id)
+			m.typ, m.blueprint = splitFirst(rest)
+		case "dep":
+			module(id).deps[rest] = true
+		case "provider":
+			typ, value := splitFirst(rest)
+			module(id).providers[typ] = value
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown line kind %q", file, i+1, kind)
+		}
+	}
+
+	return graph, nil
+}
+
+func splitFirst(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// diffGraphs writes the differences between oldGraph and newGraph to w, and returns true if
+// there are any.
+func diffGraphs(oldGraph, newGraph map[string]*graphModule, w io.Writer) bool {
+	ids := make([]string, 0, len(oldGraph)+len(newGraph))
+	for id := range oldGraph {
+		ids = append(ids, id)
+	}
+	for id := range newGraph {
+		if oldGraph[id] == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	added, removed, changed := 0, 0, 0
+	for _, id := range ids {
+		oldModule, newModule := oldGraph[id], newGraph[id]
+		switch {
+		case oldModule == nil:
+			added++
+			fmt.Fprintf(w, "added %s (%s in %s)\n", id, newModule.typ, newModule.blueprint)
+		case newModule == nil:
+			removed++
+			fmt.Fprintf(w, "removed %s (%s in %s)\n", id, oldModule.typ, oldModule.blueprint)
+		default:
+			if changes := diffModules(oldModule, newModule); len(changes) > 0 {
+				changed++
+				fmt.Fprintf(w, "changed %s\n", id)
+				for _, change := range changes {
+					fmt.Fprintf(w, "    %s\n", change)
+				}
+			}
+		}
+	}
+
+	if added+removed+changed == 0 {
+		return false
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d changed module variants\n", added, removed, changed)
+	return true
+}
+
+// diffModules returns a description of each difference between two variants of the same module.
+func diffModules(oldModule, newModule *graphModule) []string {
+	var changes []string
+	if oldModule.typ != newModule.typ {
+		changes = append(changes, fmt.Sprintf("type %s -> %s", oldModule.typ, newModule.typ))
+	}
+	if oldModule.blueprint != newModule.blueprint {
+		changes = append(changes, fmt.Sprintf("Blueprints file %s -> %s", oldModule.blueprint, newModule.blueprint))
+	}
+
+	for _, dep := range sortedKeys(oldModule.deps) {
+		if !newModule.deps[dep] {
+			changes = append(changes, "- dep "+dep)
+		}
+	}
+	for _, dep := range sortedKeys(newModule.deps) {
+		if !oldModule.deps[dep] {
+			changes = append(changes, "+ dep "+dep)
+		}
+	}
+
+	var providers []string
+	for typ := range oldModule.providers {
+		providers = append(providers, typ)
+	}
+	for typ := range newModule.providers {
+		if _, ok := oldModule.providers[typ]; !ok {
+			providers = append(providers, typ)
+		}
+	}
+	sort.Strings(providers)
+	for _, typ := range providers {
+		oldValue, inOld := oldModule.providers[typ]
+		newValue, inNew := newModule.providers[typ]
+		switch {
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("- provider %s %s", typ, oldValue))
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("+ provider %s %s", typ, newValue))
+		case oldValue != newValue:
+			changes = append(changes, fmt.Sprintf("provider %s %s -> %s", typ, oldValue, newValue))
+		}
+	}
+
+	return changes
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -14,10 +14,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"unicode"
 
-	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/edit"
 )
 
 var (
@@ -30,6 +31,7 @@ var (
 	targetedProperty = new(qualifiedProperty)
 	addIdents        = new(identSet)
 	removeIdents     = new(identSet)
+	moduleTypes      = new(moduleTypeRenames)
 )
 
 func init() {
@@ -38,6 +40,7 @@ func init() {
 	flag.Var(targetedProperty, "property", "fully qualified `name` of property to modify (default \"deps\")")
 	flag.Var(addIdents, "a", "comma or whitespace separated list of identifiers to add")
 	flag.Var(removeIdents, "r", "comma or whitespace separated list of identifiers to remove")
+	flag.Var(moduleTypes, "rename-module-types", "comma or whitespace separated list of `old=new` module types to rename in all modules")
 	flag.Usage = usage
 }
 
@@ -71,9 +74,7 @@ func processFile(filename string, in io.Reader, out io.Writer) error {
 		return err
 	}
 
-	r := bytes.NewBuffer(src)
-
-	file, errs := parser.Parse(filename, r, parser.NewScope(nil))
+	file, errs := edit.Parse(filename, bytes.NewBuffer(src))
 	if len(errs) > 0 {
 		for _, err := range errs {
 			fmt.Fprintln(os.Stderr, err)
@@ -90,7 +91,7 @@ func processFile(filename string, in io.Reader, out io.Writer) error {
 	}
 
 	if modified {
-		res, err := parser.Print(file)
+		res, err := file.Print()
 		if err != nil {
 			return err
 		}
@@ -121,127 +122,40 @@ func processFile(filename string, in io.Reader, out io.Writer) error {
 	return err
 }
 
-func findModules(file *parser.File) (modified bool, errs []error) {
-
-	for _, def := range file.Defs {
-		if module, ok := def.(*parser.Module); ok {
-			for _, prop := range module.Properties {
-				if prop.Name == "name" && prop.Value.Type() == parser.StringType {
-					if targetedModule(prop.Value.Eval().(*parser.String).Value) {
-						m, newErrs := processModule(module, prop.Name, file)
-						errs = append(errs, newErrs...)
-						modified = modified || m
-					}
-				}
-			}
-		}
+func findModules(file *edit.File) (modified bool, errs []error) {
+	if len(moduleTypes.renames) > 0 {
+		modified = file.RenameModuleTypes(moduleTypes.renames)
 	}
 
-	return modified, errs
-}
-
-func processModule(module *parser.Module, moduleName string,
-	file *parser.File) (modified bool, errs []error) {
-	prop, err := getRecursiveProperty(module, targetedProperty.name(), targetedProperty.prefixes())
-	if err != nil {
-		return false, []error{err}
-	}
-	if prop == nil {
-		if len(addIdents.idents) == 0 {
-			// We cannot find an existing prop, and we aren't adding anything to the prop,
-			// which means we must be removing something from a non-existing prop,
-			// which means this is a noop.
-			return false, nil
-		}
-		// Else we are adding something to a non-existing prop, so we need to create it first.
-		prop, modified, err = createRecursiveProperty(module, targetedProperty.name(), targetedProperty.prefixes())
-		if err != nil {
-			// Here should be unreachable, but still handle it for completeness.
-			return false, []error{err}
+	for _, module := range file.Modules() {
+		if targetedModule(module.Name()) {
+			m, newErrs := processModule(module)
+			errs = append(errs, newErrs...)
+			modified = modified || m
 		}
 	}
-	m, errs := processParameter(prop.Value, targetedProperty.String(), moduleName, file)
-	modified = modified || m
-	return modified, errs
-}
 
-func getRecursiveProperty(module *parser.Module, name string, prefixes []string) (prop *parser.Property, err error) {
-	prop, _, err = getOrCreateRecursiveProperty(module, name, prefixes, false)
-	return prop, err
-}
-
-func createRecursiveProperty(module *parser.Module, name string, prefixes []string) (prop *parser.Property, modified bool, err error) {
-	return getOrCreateRecursiveProperty(module, name, prefixes, true)
-}
-
-func getOrCreateRecursiveProperty(module *parser.Module, name string, prefixes []string,
-	createIfNotFound bool) (prop *parser.Property, modified bool, err error) {
-	m := &module.Map
-	for i, prefix := range prefixes {
-		if prop, found := m.GetProperty(prefix); found {
-			if mm, ok := prop.Value.Eval().(*parser.Map); ok {
-				m = mm
-			} else {
-				// We've found a property in the AST and such property is not of type
-				// *parser.Map, which must mean we didn't modify the AST.
-				return nil, false, fmt.Errorf("Expected property %q to be a map, found %s",
-					strings.Join(prefixes[:i+1], "."), prop.Value.Type())
-			}
-		} else if createIfNotFound {
-			mm := &parser.Map{}
-			m.Properties = append(m.Properties, &parser.Property{Name: prefix, Value: mm})
-			m = mm
-			// We've created a new node in the AST. This means the m.GetProperty(name)
-			// check after this for loop must fail, because the node we inserted is an
-			// empty parser.Map, thus this function will return |modified| is true.
-		} else {
-			return nil, false, nil
-		}
-	}
-	if prop, found := m.GetProperty(name); found {
-		// We've found a property in the AST, which must mean we didn't modify the AST.
-		return prop, false, nil
-	} else if createIfNotFound {
-		prop = &parser.Property{Name: name, Value: &parser.List{}}
-		m.Properties = append(m.Properties, prop)
-		return prop, true, nil
-	} else {
-		return nil, false, nil
-	}
+	return modified, errs
 }
 
-func processParameter(value parser.Expression, paramName, moduleName string,
-	file *parser.File) (modified bool, errs []error) {
-	if _, ok := value.(*parser.Variable); ok {
-		return false, []error{fmt.Errorf("parameter %s in module %s is a variable, unsupported",
-			paramName, moduleName)}
-	}
-
-	if _, ok := value.(*parser.Operator); ok {
-		return false, []error{fmt.Errorf("parameter %s in module %s is an expression, unsupported",
-			paramName, moduleName)}
-	}
+func processModule(module *edit.Module) (modified bool, errs []error) {
+	property := targetedProperty.String()
 
-	list, ok := value.(*parser.List)
-	if !ok {
-		return false, []error{fmt.Errorf("expected parameter %s in module %s to be list, found %s",
-			paramName, moduleName, value.Type().String())}
-	}
-
-	wasSorted := parser.ListIsSorted(list)
-
-	for _, a := range addIdents.idents {
-		m := parser.AddStringToList(list, a)
-		modified = modified || m
+	added, err := module.AddToList(property, addIdents.idents...)
+	if err != nil {
+		return false, []error{err}
 	}
 
-	for _, r := range removeIdents.idents {
-		m := parser.RemoveStringFromList(list, r)
-		modified = modified || m
+	removed, err := module.RemoveFromList(property, removeIdents.idents...)
+	if err != nil {
+		return false, []error{err}
 	}
 
-	if (wasSorted || *sortLists) && modified {
-		parser.SortList(file, list)
+	modified = added || removed
+	if *sortLists && modified {
+		if _, err := module.SortList(property); err != nil {
+			return false, []error{err}
+		}
 	}
 
 	return modified, nil
@@ -299,12 +213,12 @@ func main() {
 		return
 	}
 
-	if len(targetedModules.idents) == 0 {
-		report(fmt.Errorf("-m parameter is required"))
+	if len(targetedModules.idents) == 0 && len(moduleTypes.renames) == 0 {
+		report(fmt.Errorf("-m or -rename-module-types parameter is required"))
 		return
 	}
 
-	if len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 {
+	if len(targetedModules.idents) > 0 && len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 {
 		report(fmt.Errorf("-a or -r parameter is required"))
 		return
 	}
@@ -381,14 +295,6 @@ type qualifiedProperty struct {
 
 var _ flag.Getter = (*qualifiedProperty)(nil)
 
-func (p *qualifiedProperty) name() string {
-	return p.parts[len(p.parts)-1]
-}
-
-func (p *qualifiedProperty) prefixes() []string {
-	return p.parts[:len(p.parts)-1]
-}
-
 func (p *qualifiedProperty) String() string {
 	return strings.Join(p.parts, ".")
 }
@@ -409,3 +315,38 @@ func (p *qualifiedProperty) Set(s string) error {
 func (p *qualifiedProperty) Get() interface{} {
 	return p.parts
 }
+
+// moduleTypeRenames is a list of old=new pairs of module types, for example the aliases returned
+// by blueprint.Context.ModuleTypeAliases.
+type moduleTypeRenames struct {
+	renames map[string]string
+}
+
+var _ flag.Getter = (*moduleTypeRenames)(nil)
+
+func (m *moduleTypeRenames) String() string {
+	var pairs []string
+	for oldType, newType := range m.renames {
+		pairs = append(pairs, oldType+"="+newType)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *moduleTypeRenames) Set(s string) error {
+	m.renames = make(map[string]string)
+	for _, pair := range strings.FieldsFunc(s, func(c rune) bool {
+		return unicode.IsSpace(c) || c == ','
+	}) {
+		oldType, newType, ok := strings.Cut(pair, "=")
+		if !ok || oldType == "" || newType == "" {
+			return fmt.Errorf("%q is not a valid module type rename, expected old=new", pair)
+		}
+		m.renames[oldType] = newType
+	}
+	return nil
+}
+
+func (m *moduleTypeRenames) Get() interface{} {
+	return m.renames
+}
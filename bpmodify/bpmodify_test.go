@@ -18,7 +18,7 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/edit"
 )
 
 var testCases = []struct {
@@ -264,7 +264,7 @@ func TestProcessModule(t *testing.T) {
 		addIdents.Set(testCase.addSet)
 		removeIdents.Set(testCase.removeSet)
 
-		inAst, errs := parser.ParseAndEval("", strings.NewReader(testCase.input), parser.NewScope(nil))
+		inFile, errs := edit.Parse("", strings.NewReader(testCase.input))
 		if len(errs) > 0 {
 			t.Errorf("test case %d:", i)
 			for _, err := range errs {
@@ -275,19 +275,19 @@ func TestProcessModule(t *testing.T) {
 			continue
 		}
 
-		if inModule, ok := inAst.Defs[0].(*parser.Module); !ok {
+		if inModules := inFile.Modules(); len(inModules) != 1 {
 			t.Errorf("test case %d:", i)
 			t.Errorf("  input must only contain a single module definition: %s", testCase.input)
 			continue
 		} else {
-			_, errs := processModule(inModule, "", inAst)
+			_, errs := processModule(inModules[0])
 			if len(errs) > 0 {
 				t.Errorf("test case %d:", i)
 				for _, err := range errs {
 					t.Errorf("  %s", err)
 				}
 			}
-			inModuleText, _ := parser.Print(inAst)
+			inModuleText, _ := inFile.Print()
 			inModuleString := string(inModuleText)
 			if simplifyModuleDefinition(inModuleString) != simplifyModuleDefinition(testCase.output) {
 				t.Errorf("test case %d:", i)
@@ -299,3 +299,54 @@ func TestProcessModule(t *testing.T) {
 		}
 	}
 }
+
+func TestRenameModuleTypes(t *testing.T) {
+	defer moduleTypes.Set("")
+	targetedModules.Set("")
+	if err := moduleTypes.Set("cc_foo=cc_library, cc_bar=cc_binary"); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `
+		cc_foo {
+			name: "foo",
+		}
+		cc_bar {
+			srcs: ["bar.c"],
+		}
+		cc_baz {
+			name: "baz",
+		}
+		`
+	output := `
+		cc_library {
+			name: "foo",
+		}
+		cc_binary {
+			srcs: ["bar.c"],
+		}
+		cc_baz {
+			name: "baz",
+		}
+		`
+
+	file, errs := edit.Parse("", strings.NewReader(input))
+	if len(errs) > 0 {
+		t.Fatalf("failed to parse: %q", errs)
+	}
+	modified, errs := findModules(file)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+	if !modified {
+		t.Errorf("expected the file to be modified")
+	}
+	out, _ := file.Print()
+	if simplifyModuleDefinition(string(out)) != simplifyModuleDefinition(output) {
+		t.Errorf("expected:\n%s\ngot:\n%s", output, out)
+	}
+
+	if err := moduleTypes.Set("cc_foo"); err == nil {
+		t.Errorf("expected an error for a rename without a new module type")
+	}
+}
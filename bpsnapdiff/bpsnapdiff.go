@@ -0,0 +1,158 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// bpsnapdiff compares module graph snapshots written by Context.SetMutatorSnapshotDir.  When
+// passed a snapshot directory it prints the changes made by each mutator, and when passed two
+// snapshot files it prints the changes between them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	mutator = flag.String("m", "", "only show changes made by the named mutator")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bpsnapdiff [flags] <snapshot dir>")
+	fmt.Fprintln(os.Stderr, "       bpsnapdiff [flags] <old snapshot> <new snapshot>")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	var err error
+	switch flag.NArg() {
+	case 1:
+		err = diffDir(flag.Arg(0), os.Stdout)
+	case 2:
+		err = diffFiles(flag.Arg(0), flag.Arg(1), os.Stdout)
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// diffDir prints the differences between each consecutive pair of snapshots in dir.
+func diffDir(dir string, w io.Writer) error {
+	snapshots, err := filepath.Glob(filepath.Join(dir, "*.snapshot"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(snapshots)
+
+	for i := 1; i < len(snapshots); i++ {
+		if *mutator != "" && mutatorName(snapshots[i]) != *mutator {
+			continue
+		}
+		if err := diffFiles(snapshots[i-1], snapshots[i], w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func diffFiles(oldFile, newFile string, w io.Writer) error {
+	oldLines, err := readSnapshot(oldFile)
+	if err != nil {
+		return err
+	}
+	newLines, err := readSnapshot(newFile)
+	if err != nil {
+		return err
+	}
+
+	removed, added := diffLines(oldLines, newLines)
+	if len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "=== %s -> %s\n", filepath.Base(oldFile), filepath.Base(newFile))
+	for _, line := range removed {
+		fmt.Fprintf(w, "-%s\n", line)
+	}
+	for _, line := range added {
+		fmt.Fprintf(w, "+%s\n", line)
+	}
+
+	return nil
+}
+
+// diffLines returns the lines that only appear in oldLines and the lines that only appear in
+// newLines, both sorted.
+func diffLines(oldLines, newLines []string) (removed, added []string) {
+	oldSet := make(map[string]bool, len(oldLines))
+	for _, line := range oldLines {
+		oldSet[line] = true
+	}
+	newSet := make(map[string]bool, len(newLines))
+	for _, line := range newLines {
+		newSet[line] = true
+	}
+
+	for _, line := range oldLines {
+		if !newSet[line] {
+			removed = append(removed, line)
+		}
+	}
+	for _, line := range newLines {
+		if !oldSet[line] {
+			added = append(added, line)
+		}
+	}
+
+	sort.Strings(removed)
+	sort.Strings(added)
+	return removed, added
+}
+
+func readSnapshot(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// mutatorName returns the name of the mutator that produced a snapshot file from its file name.
+func mutatorName(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), ".snapshot")
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
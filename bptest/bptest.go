@@ -0,0 +1,407 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bptest is a harness for testing module types, mutators and singletons written for
+// blueprint.  A Fixture collects the registrations and the Blueprints files of a test, runs the
+// phases of a blueprint.Context on them and checks the errors, and the returned Result gives
+// access to the modules, their variants and the build statements they generated:
+//
+//	result := bptest.NewFixture().
+//		RegisterModuleType("cc_library", newLibrary).
+//		RegisterBottomUpMutator("arch", archMutator).
+//		WithBlueprints(`
+//			cc_library {
+//				name: "libfoo",
+//			}
+//		`).
+//		RunTest(t)
+//
+//	statements := result.BuildStatements("libfoo", "arm64")
+package bptest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+// A Phase is a method of blueprint.Context that a Fixture runs.
+type Phase int
+
+const (
+	// PhaseParse runs ParseBlueprintsFiles.
+	PhaseParse Phase = iota
+
+	// PhaseResolveDependencies runs ResolveDependencies, which runs the mutators.
+	PhaseResolveDependencies
+
+	// PhasePrepareBuildActions runs PrepareBuildActions, which runs GenerateBuildActions on every
+	// module and the singletons.
+	PhasePrepareBuildActions
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseParse:
+		return "ParseBlueprintsFiles"
+	case PhaseResolveDependencies:
+		return "ResolveDependencies"
+	case PhasePrepareBuildActions:
+		return "PrepareBuildActions"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// An ExpectedError matches an error returned by one of the phases, see Fixture.ExpectErrors.
+type ExpectedError struct {
+	// Pos is the position of the error, in the form "file:line:column", "file:line" or "file".
+	// An empty Pos matches errors at any position, including errors without one.
+	Pos string
+
+	// Pattern is a regular expression that must match the message of the error, which doesn't
+	// include the position or the module name.
+	Pattern string
+}
+
+func (e ExpectedError) String() string {
+	if e.Pos == "" {
+		return fmt.Sprintf("%q", e.Pattern)
+	}
+	return fmt.Sprintf("%s: %q", e.Pos, e.Pattern)
+}
+
+// ErrorMatching returns an ExpectedError that matches an error at any position whose message
+// matches pattern.
+func ErrorMatching(pattern string) ExpectedError {
+	return ExpectedError{Pattern: pattern}
+}
+
+// ErrorAt returns an ExpectedError that matches an error at pos whose message matches pattern.
+func ErrorAt(pos, pattern string) ExpectedError {
+	return ExpectedError{Pos: pos, Pattern: pattern}
+}
+
+// A Fixture describes a blueprint.Context to create for a test.  The methods that configure it
+// return the Fixture so that calls can be chained, and a Fixture can be reused by several tests
+// as long as they don't configure it concurrently.
+type Fixture struct {
+	preparers      []func(ctx *blueprint.Context)
+	files          map[string][]byte
+	config         interface{}
+	stopAfter      Phase
+	expectedErrors []ExpectedError
+}
+
+// NewFixture returns an empty Fixture that runs every phase and expects no errors.
+func NewFixture() *Fixture {
+	return &Fixture{
+		files:     make(map[string][]byte),
+		stopAfter: PhasePrepareBuildActions,
+	}
+}
+
+// Prepare adds a function that is called with the new blueprint.Context before the Blueprints
+// files are parsed, to register anything that doesn't have a method on Fixture.
+func (f *Fixture) Prepare(preparer func(ctx *blueprint.Context)) *Fixture {
+	f.preparers = append(f.preparers, preparer)
+	return f
+}
+
+// RegisterModuleType registers a module type, see blueprint.Context.RegisterModuleType.
+func (f *Fixture) RegisterModuleType(name string, factory blueprint.ModuleFactory) *Fixture {
+	return f.Prepare(func(ctx *blueprint.Context) {
+		ctx.RegisterModuleType(name, factory)
+	})
+}
+
+// RegisterBottomUpMutator registers a bottom up mutator, see
+// blueprint.Context.RegisterBottomUpMutator.
+func (f *Fixture) RegisterBottomUpMutator(name string, mutator blueprint.BottomUpMutator) *Fixture {
+	return f.Prepare(func(ctx *blueprint.Context) {
+		ctx.RegisterBottomUpMutator(name, mutator)
+	})
+}
+
+// RegisterTopDownMutator registers a top down mutator, see
+// blueprint.Context.RegisterTopDownMutator.
+func (f *Fixture) RegisterTopDownMutator(name string, mutator blueprint.TopDownMutator) *Fixture {
+	return f.Prepare(func(ctx *blueprint.Context) {
+		ctx.RegisterTopDownMutator(name, mutator)
+	})
+}
+
+// RegisterSingletonType registers a singleton, see blueprint.Context.RegisterSingletonType.
+func (f *Fixture) RegisterSingletonType(name string, factory blueprint.SingletonFactory) *Fixture {
+	return f.Prepare(func(ctx *blueprint.Context) {
+		ctx.RegisterSingletonType(name, factory)
+	})
+}
+
+// WithFiles adds files to the mock filesystem of the Context.  If none of the files is named
+// blueprint.MockModuleListFile then every file named Blueprints is parsed.
+func (f *Fixture) WithFiles(files map[string][]byte) *Fixture {
+	for name, contents := range files {
+		f.files[name] = contents
+	}
+	return f
+}
+
+// WithBlueprints sets the contents of the top level Blueprints file.
+func (f *Fixture) WithBlueprints(bp string) *Fixture {
+	f.files["Blueprints"] = []byte(bp)
+	return f
+}
+
+// WithConfig sets the config object passed to the phases.
+func (f *Fixture) WithConfig(config interface{}) *Fixture {
+	f.config = config
+	return f
+}
+
+// StopAfter sets the last phase to run.  By default every phase is run.
+func (f *Fixture) StopAfter(phase Phase) *Fixture {
+	f.stopAfter = phase
+	return f
+}
+
+// ExpectErrors sets the errors that the phases are expected to return.  Running the Fixture
+// stops after the first phase that returns errors, and the test fails unless every expected
+// error matches one of the errors and every error is matched by one of the expected errors.
+func (f *Fixture) ExpectErrors(expected ...ExpectedError) *Fixture {
+	f.expectedErrors = append(f.expectedErrors, expected...)
+	return f
+}
+
+// A Result is the blueprint.Context created by running a Fixture, with helpers to find the
+// modules and build statements that a test checks.
+type Result struct {
+	*blueprint.Context
+
+	// Config is the config object that was passed to the phases.
+	Config interface{}
+
+	// Errors are the errors returned by the last phase that was run.
+	Errors []error
+
+	t testing.TB
+}
+
+// RunTest creates a blueprint.Context, registers everything configured on the Fixture, parses
+// the files and runs the phases.  It fails the test if the errors returned by the phases don't
+// match the expected errors.
+func (f *Fixture) RunTest(t testing.TB) *Result {
+	t.Helper()
+
+	ctx := blueprint.NewContext()
+	for _, preparer := range f.preparers {
+		preparer(ctx)
+	}
+
+	if _, ok := f.files["Blueprints"]; !ok {
+		if _, ok := f.files[blueprint.MockModuleListFile]; !ok {
+			t.Fatalf("bptest: no Blueprints file, call WithBlueprints or WithFiles")
+		}
+	}
+	files := make(map[string][]byte, len(f.files))
+	for name, contents := range f.files {
+		files[name] = contents
+	}
+	ctx.MockFileSystem(files)
+
+	result := &Result{Context: ctx, Config: f.config, t: t}
+
+	phases := []func() []error{
+		func() []error {
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", f.config)
+			return errs
+		},
+		func() []error {
+			_, errs := ctx.ResolveDependencies(f.config)
+			return errs
+		},
+		func() []error {
+			_, errs := ctx.PrepareBuildActions(f.config)
+			return errs
+		},
+	}
+
+	for phase := PhaseParse; phase <= f.stopAfter; phase++ {
+		result.Errors = phases[phase]()
+		if len(result.Errors) > 0 {
+			break
+		}
+	}
+
+	f.checkErrors(t, result.Errors)
+	return result
+}
+
+// checkErrors fails the test if errs don't match the expected errors of the Fixture.
+func (f *Fixture) checkErrors(t testing.TB, errs []error) {
+	t.Helper()
+
+	if len(f.expectedErrors) == 0 {
+		if len(errs) > 0 {
+			t.Fatalf("bptest: unexpected errors:\n%s", errorList(errs))
+		}
+		return
+	}
+
+	matched := make([]bool, len(errs))
+	var missing []string
+	for _, expected := range f.expectedErrors {
+		re, err := regexp.Compile(expected.Pattern)
+		if err != nil {
+			t.Fatalf("bptest: invalid error pattern %q: %s", expected.Pattern, err)
+		}
+		found := false
+		for i, err := range errs {
+			if matchError(expected, re, err) {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, expected.String())
+		}
+	}
+
+	var unexpected []error
+	for i, err := range errs {
+		if !matched[i] {
+			unexpected = append(unexpected, err)
+		}
+	}
+
+	if len(missing) > 0 || len(unexpected) > 0 {
+		var sb strings.Builder
+		if len(missing) > 0 {
+			fmt.Fprintf(&sb, "missing expected errors:\n  %s\n", strings.Join(missing, "\n  "))
+		}
+		if len(unexpected) > 0 {
+			fmt.Fprintf(&sb, "unexpected errors:\n%s", errorList(unexpected))
+		}
+		if len(errs) == 0 {
+			sb.WriteString("no errors were returned\n")
+		}
+		t.Fatalf("bptest: %s", sb.String())
+	}
+}
+
+// matchError returns true if err matches expected, whose pattern was compiled into re.
+func matchError(expected ExpectedError, re *regexp.Regexp, err error) bool {
+	d := blueprint.NewDiagnostic(err)
+	if expected.Pos != "" {
+		pos := d.File
+		if strings.Count(expected.Pos, ":") >= 1 {
+			pos += fmt.Sprintf(":%d", d.Line)
+		}
+		if strings.Count(expected.Pos, ":") >= 2 {
+			pos += fmt.Sprintf(":%d", d.Column)
+		}
+		if pos != expected.Pos {
+			return false
+		}
+	}
+	return re.MatchString(d.Message)
+}
+
+func errorList(errs []error) string {
+	var sb strings.Builder
+	for _, err := range errs {
+		fmt.Fprintf(&sb, "  %s\n", err)
+	}
+	return sb.String()
+}
+
+// ModuleVariants returns the names of the variants of the named module, sorted.  It fails the
+// test if there is no module with that name.
+func (r *Result) ModuleVariants(name string) []string {
+	r.t.Helper()
+
+	var variants []string
+	r.VisitAllModules(func(m blueprint.Module) {
+		if r.ModuleName(m) == name {
+			variants = append(variants, r.ModuleSubDir(m))
+		}
+	})
+	if variants == nil {
+		r.t.Fatalf("bptest: no module named %q", name)
+	}
+	sort.Strings(variants)
+	return variants
+}
+
+// Module returns the variant of the named module, where an empty variant is the only variant
+// of a module that was not split by any mutator.  It fails the test if the variant doesn't
+// exist.
+func (r *Result) Module(name, variant string) blueprint.Module {
+	r.t.Helper()
+
+	var found blueprint.Module
+	var variants []string
+	r.VisitAllModules(func(m blueprint.Module) {
+		if r.ModuleName(m) == name {
+			variants = append(variants, r.ModuleSubDir(m))
+			if r.ModuleSubDir(m) == variant {
+				found = m
+			}
+		}
+	})
+	if found == nil {
+		if variants == nil {
+			r.t.Fatalf("bptest: no module named %q", name)
+		}
+		r.t.Fatalf("bptest: module %q has no variant %q, variants are %q", name, variant, variants)
+	}
+	return found
+}
+
+// BuildStatements returns the build statements generated by the variant of the named module.
+// It fails the test if the variant doesn't exist or PrepareBuildActions was not run.
+func (r *Result) BuildStatements(name, variant string) []blueprint.BuildStatement {
+	r.t.Helper()
+
+	statements, err := r.ModuleBuildStatements(r.Module(name, variant))
+	if err != nil {
+		r.t.Fatalf("bptest: %s", err)
+	}
+	return statements
+}
+
+// BuildStatementForOutput returns the build statement generated by the variant of the named
+// module that has output as one of its outputs or implicit outputs.  It fails the test if there
+// is no such build statement.
+func (r *Result) BuildStatementForOutput(name, variant, output string) blueprint.BuildStatement {
+	r.t.Helper()
+
+	statements := r.BuildStatements(name, variant)
+	var outputs []string
+	for _, statement := range statements {
+		for _, o := range append(append([]string(nil), statement.Outputs...), statement.ImplicitOutputs...) {
+			if o == output {
+				return statement
+			}
+			outputs = append(outputs, o)
+		}
+	}
+	r.t.Fatalf("bptest: module %q variant %q has no build statement for %q, outputs are %q",
+		name, variant, output, outputs)
+	return blueprint.BuildStatement{}
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bptest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+var (
+	pctx = blueprint.NewPackageContext("github.com/google/blueprint/bptest")
+
+	copyRule = pctx.StaticRule("copy",
+		blueprint.RuleParams{
+			Command:     "cp $in $out",
+			Description: "cp $out",
+		})
+)
+
+type copyModule struct {
+	blueprint.SimpleName
+	properties struct {
+		Src  string
+		Deps []string
+	}
+}
+
+func newCopyModule() (blueprint.Module, []interface{}) {
+	m := &copyModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *copyModule) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	out := ctx.ModuleName()
+	if ctx.ModuleSubDir() != "" {
+		out += "." + ctx.ModuleSubDir()
+	}
+	var implicits []string
+	ctx.VisitDirectDeps(func(dep blueprint.Module) {
+		implicits = append(implicits, ctx.OtherModuleName(dep))
+	})
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:      copyRule,
+		Outputs:   []string{out},
+		Inputs:    []string{m.properties.Src},
+		Implicits: implicits,
+	})
+}
+
+func copyDepsMutator(ctx blueprint.BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*copyModule); ok {
+		ctx.AddDependency(m, nil, m.properties.Deps...)
+	}
+}
+
+func archMutator(ctx blueprint.BottomUpMutatorContext) {
+	if _, ok := ctx.Module().(*copyModule); ok {
+		ctx.CreateVariations("arm", "x86")
+	}
+}
+
+func newTestFixture() *Fixture {
+	return NewFixture().
+		RegisterModuleType("copy", newCopyModule).
+		RegisterBottomUpMutator("deps", copyDepsMutator)
+}
+
+func TestFixtureBuildStatements(t *testing.T) {
+	result := newTestFixture().
+		WithBlueprints(`
+			copy {
+				name: "a",
+				src: "a.in",
+				deps: ["b"],
+			}
+
+			copy {
+				name: "b",
+				src: "b.in",
+			}
+		`).
+		RunTest(t)
+
+	if g, w := result.ModuleVariants("a"), []string{""}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected variants %q, got %q", w, g)
+	}
+
+	statement := result.BuildStatementForOutput("a", "", "a")
+	if g, w := statement.Rule, "g.bptest.copy"; g != w {
+		t.Errorf("expected rule %q, got %q", w, g)
+	}
+	if g, w := statement.Inputs, []string{"a.in"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected inputs %q, got %q", w, g)
+	}
+	if g, w := statement.Implicits, []string{"b"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected implicits %q, got %q", w, g)
+	}
+}
+
+func TestFixtureVariants(t *testing.T) {
+	result := newTestFixture().
+		RegisterBottomUpMutator("arch", archMutator).
+		WithFiles(map[string][]byte{
+			"Blueprints": []byte(`
+				copy {
+					name: "a",
+					src: "a.in",
+				}
+			`),
+		}).
+		RunTest(t)
+
+	if g, w := result.ModuleVariants("a"), []string{"arm", "x86"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected variants %q, got %q", w, g)
+	}
+	if g, w := len(result.BuildStatements("a", "x86")), 1; g != w {
+		t.Errorf("expected %d build statements, got %d", w, g)
+	}
+	result.BuildStatementForOutput("a", "arm", "a.arm")
+}
+
+func TestFixtureStopAfter(t *testing.T) {
+	result := newTestFixture().
+		WithBlueprints(`
+			copy {
+				name: "a",
+			}
+		`).
+		StopAfter(PhaseResolveDependencies).
+		RunTest(t)
+
+	if _, err := result.ModuleBuildStatements(result.Module("a", "")); err != blueprint.ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+	}
+}
+
+func TestFixtureExpectErrors(t *testing.T) {
+	newFixture := func() *Fixture {
+		return newTestFixture().
+			WithBlueprints(`
+				copy {
+					name: "a",
+					deps: ["missing"],
+				}
+			`)
+	}
+
+	t.Run("position and pattern", func(t *testing.T) {
+		result := newFixture().
+			ExpectErrors(ErrorAt("Blueprints:2:5", `depends on undefined module "missing"`)).
+			RunTest(t)
+		if len(result.Errors) != 1 {
+			t.Errorf("expected 1 error, got %q", result.Errors)
+		}
+	})
+
+	t.Run("file and line", func(t *testing.T) {
+		newFixture().
+			ExpectErrors(ErrorAt("Blueprints:2", `undefined module`)).
+			RunTest(t)
+	})
+
+	t.Run("any position", func(t *testing.T) {
+		newFixture().
+			ExpectErrors(ErrorMatching(`undefined module`)).
+			RunTest(t)
+	})
+
+	tests := []struct {
+		name     string
+		expected []ExpectedError
+	}{
+		{
+			name:     "no expected errors",
+			expected: nil,
+		},
+		{
+			name:     "wrong position",
+			expected: []ExpectedError{ErrorAt("Blueprints:3:5", `undefined module`)},
+		},
+		{
+			name:     "wrong pattern",
+			expected: []ExpectedError{ErrorMatching(`unknown property`)},
+		},
+		{
+			name: "missing error",
+			expected: []ExpectedError{
+				ErrorMatching(`undefined module`),
+				ErrorMatching(`unknown property`),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := &fakeTB{TB: t}
+			func() {
+				defer func() {
+					if r := recover(); r != nil && r != errFakeFatal {
+						panic(r)
+					}
+				}()
+				newFixture().ExpectErrors(test.expected...).RunTest(ft)
+			}()
+			if !ft.failed {
+				t.Errorf("expected the test to fail")
+			}
+		})
+	}
+}
+
+var errFakeFatal = fmt.Errorf("fatal")
+
+// fakeTB records failures instead of failing the test, so that the tests can check that a
+// Fixture fails when it should.
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+	panic(errFakeFatal)
+}
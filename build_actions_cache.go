@@ -0,0 +1,593 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// This file implements the build actions cache, which allows a Context to skip calling
+// GenerateBuildActions for modules whose inputs have not changed since the previous run.
+//
+// Only modules that implement CacheableModule are cached.  Each one is keyed by a hash of the
+// primary builder binary, the module's identity and properties, the provider values set on it by
+// mutators, the configuration values it declares with CacheConfigDependencies, and the identity,
+// dependency tag and provider values of each of its direct dependencies.  The cached value is the
+// list of calls the module made to ModuleContext.Variable, Rule and Build, the providers it set,
+// and the phony targets, Ninja file dependencies, declared paths and metadata it added.  On a hit the
+// calls are replayed on a new ModuleContext instead of calling GenerateBuildActions.
+
+// buildActionsCacheVersion must be incremented whenever the format of the cache file changes.
+const buildActionsCacheVersion = 2
+
+// A CacheableModule is a Module whose GenerateBuildActions can be skipped when its inputs have not
+// changed since the previous run, see Context.SetBuildActionsCacheFile.  By implementing it a
+// module promises that GenerateBuildActions only depends on the module's properties, the
+// configuration values returned by CacheConfigDependencies, the names, types, directories,
+// dependency tags and provider values of its direct dependencies, and the provider values set on
+// the module by mutators.  Its only effects must be the build statements, variables, rules,
+// providers, phony targets, Ninja file dependencies, declared paths and metadata it creates
+// through the ModuleContext; other modules must not read fields of the Go object set by
+// GenerateBuildActions.
+//
+// Modules that call GlobWithDeps, Fs, DirectoryMetadata, TopLevelVariable, Warningf or
+// PropertyWarningf during GenerateBuildActions, or methods that look at modules other than the
+// direct dependencies like WalkDeps, VisitDepsDepthFirst, OtherModuleExists or
+// VisitAllModuleVariants, or that set providers whose values don't survive a round trip through
+// encoding/json, are not cached.
+type CacheableModule interface {
+	Module
+
+	// CacheConfigDependencies returns the values read from config by GenerateBuildActions, as
+	// strings that are part of the cache key.  It is called before GenerateBuildActions, so it
+	// must return the same values whether or not GenerateBuildActions is called.
+	CacheConfigDependencies(config interface{}) []string
+}
+
+// SetBuildActionsCacheFile enables the build actions cache and sets the path of the file used to
+// store it.  The cache is read when PrepareBuildActions starts and rewritten with the build
+// actions of every cacheable module when it completes without errors.  A missing or unreadable
+// cache file is treated as an empty cache.  The cache is not used when build statements are
+// annotated, see SetAnnotateBuildStatements.
+func (c *Context) SetBuildActionsCacheFile(file string) {
+	c.buildActionsCache = newBuildActionsCache(file)
+}
+
+type buildActionsCache struct {
+	file string
+
+	lock sync.Mutex
+	// entries read from the cache file, keyed by moduleCacheName
+	prev map[string]*cachedBuildActions
+	// entries created during this run, keyed by moduleCacheName
+	next map[string]*cachedBuildActions
+
+	loaded bool
+
+	// toolHash is a hash of the running binary, so that changes to the code of
+	// GenerateBuildActions invalidate the cache.  Caching is disabled if it can't be computed.
+	toolHash string
+
+	// counters used by tests
+	hits, misses int
+}
+
+type buildActionsCacheFile struct {
+	Version int
+	Entries map[string]*cachedBuildActions
+}
+
+type cachedBuildActions struct {
+	Key string
+
+	// The calls to ModuleContext.Variable, Rule and Build in the order they were made.
+	Calls []cachedBuildActionsCall
+
+	Providers       []cachedProvider
+	Phonies         []cachedPhony
+	NinjaFileDeps   []string
+	DeclaredInputs  []string
+	DeclaredOutputs []string
+	Metadata        []cachedMetadata
+}
+
+type cachedBuildActionsCall struct {
+	Pkg string
+
+	Variable *cachedVariable
+	Rule     *cachedRule
+	Build    *cachedBuild
+}
+
+type cachedVariable struct {
+	Name, Value string
+}
+
+type cachedRule struct {
+	Name     string
+	Params   RuleParams
+	Pool     cachedRef
+	ArgNames []string
+}
+
+type cachedBuild struct {
+	Params     BuildParams
+	Rule, Pool cachedRef
+}
+
+// cachedRef refers to a rule or pool.  Local is the index plus one of a rule created by the
+// module's own calls to Rule, Builtin is the name of a built-in rule or pool, otherwise Pkg and
+// Name identify a rule or pool defined by a PackageContext.
+type cachedRef struct {
+	Local     int
+	Builtin   string
+	Pkg, Name string
+}
+
+type cachedProvider struct {
+	ID    int
+	Type  string
+	Value []byte
+}
+
+type cachedMetadata struct {
+	Key   string
+	Value []byte
+}
+
+type cachedPhony struct {
+	Name        string
+	Deps        []string
+	Description string
+}
+
+func newBuildActionsCache(file string) *buildActionsCache {
+	return &buildActionsCache{
+		file: file,
+		next: make(map[string]*cachedBuildActions),
+	}
+}
+
+// load reads the cache file if it has not already been read.  Errors are ignored, they result
+// in an empty cache.
+func (bc *buildActionsCache) load() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if bc.loaded {
+		return
+	}
+	bc.loaded = true
+	bc.prev = make(map[string]*cachedBuildActions)
+	bc.next = make(map[string]*cachedBuildActions)
+
+	if exe, err := os.Executable(); err == nil {
+		if f, err := os.Open(exe); err == nil {
+			h := sha256.New()
+			if _, err := io.Copy(h, f); err == nil {
+				bc.toolHash = hex.EncodeToString(h.Sum(nil))
+			}
+			f.Close()
+		}
+	}
+
+	f, err := os.Open(bc.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var cacheFile buildActionsCacheFile
+	if err := gob.NewDecoder(f).Decode(&cacheFile); err != nil {
+		return
+	}
+	if cacheFile.Version != buildActionsCacheVersion {
+		return
+	}
+	bc.prev = cacheFile.Entries
+}
+
+// write atomically replaces the cache file with the entries created during this run.
+func (bc *buildActionsCache) write() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(&buildActionsCacheFile{
+		Version: buildActionsCacheVersion,
+		Entries: bc.next,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode build actions cache: %s", err)
+	}
+
+	dir := filepath.Dir(bc.file)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("failed to write build actions cache: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(bc.file)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write build actions cache: %s", err)
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), bc.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write build actions cache: %s", err)
+	}
+
+	return nil
+}
+
+// lookup returns the cached build actions of a module if they were stored with the given key.
+func (bc *buildActionsCache) lookup(name, key string) *cachedBuildActions {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if cached := bc.prev[name]; cached != nil && cached.Key == key {
+		bc.hits++
+		return cached
+	}
+	bc.misses++
+	return nil
+}
+
+// store records the build actions of a module for the next run.
+func (bc *buildActionsCache) store(name string, cached *cachedBuildActions) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.next[name] = cached
+}
+
+// moduleCacheName returns the name a module variant is stored under in the cache.
+func moduleCacheName(module *moduleInfo) string {
+	return module.relBlueprintsFile + ":" + module.Name() + ":" + module.variant.name
+}
+
+// encodeProviderValue returns the JSON encoding of a provider value, or false if decoding it
+// doesn't produce an equal value.
+func encodeProviderValue(value interface{}) ([]byte, bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	decoded := reflect.New(reflect.TypeOf(value))
+	if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+		return nil, false
+	}
+	if !reflect.DeepEqual(decoded.Elem().Interface(), value) {
+		return nil, false
+	}
+	return data, true
+}
+
+// writeProvidersDigest writes the provider values of a module to h, and returns false if any of
+// them can't be encoded.
+func writeProvidersDigest(h hash.Hash, providers []interface{}) bool {
+	for id, value := range providers {
+		if value == nil {
+			continue
+		}
+		data, ok := encodeProviderValue(value)
+		if !ok {
+			return false
+		}
+		fmt.Fprintf(h, "provider %d %T %d\n", id, value, len(data))
+		h.Write(data)
+	}
+	return true
+}
+
+// setProvidersDigest records a digest of the provider values of a module after its build
+// actions were generated, which is part of the cache key of the modules that depend on it.
+func (c *Context) setProvidersDigest(module *moduleInfo) {
+	h := sha256.New()
+	if writeProvidersDigest(h, module.providers) {
+		module.providersDigest = hex.EncodeToString(h.Sum(nil))
+	} else {
+		module.providersDigest = ""
+	}
+}
+
+// buildActionsCacheKey returns the cache key of a module, or false if it can't be cached.
+func (c *Context) buildActionsCacheKey(module *moduleInfo, config interface{}) (string, bool) {
+	cacheable, ok := module.logicModule.(CacheableModule)
+	if !ok || module.missingDeps != nil || c.buildActionsCache.toolHash == "" {
+		return "", false
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\ntool %s\n", buildActionsCacheVersion, c.buildActionsCache.toolHash)
+	fmt.Fprintf(h, "module %q %q %q %q %T\n", module.Name(), module.variant.name, module.typeName,
+		module.relBlueprintsFile, module.logicModule)
+	fmt.Fprintf(h, "properties %s\n", c.modulePropertiesHash(module))
+	for _, value := range cacheable.CacheConfigDependencies(config) {
+		fmt.Fprintf(h, "config %q\n", value)
+	}
+	if !writeProvidersDigest(h, module.providers) {
+		return "", false
+	}
+
+	for _, dep := range module.directDeps {
+		if dep.module.providersDigest == "" {
+			return "", false
+		}
+		fmt.Fprintf(h, "dep %q %q %q %q %T %+v %s\n", dep.module.Name(), dep.module.variant.name,
+			dep.module.typeName, dep.module.dir(), dep.tag, dep.tag, dep.module.providersDigest)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// buildActionsRecorder records the calls made by a module to ModuleContext.Variable, Rule and
+// Build while generating its build actions.
+type buildActionsRecorder struct {
+	calls      []cachedBuildActionsCall
+	localRules []Rule
+
+	// uncacheable is set when the module does something the cache can't replay.
+	uncacheable bool
+}
+
+func (r *buildActionsRecorder) variable(pctx PackageContext, name, value string) {
+	r.calls = append(r.calls, cachedBuildActionsCall{
+		Pkg:      pctx.(*packageContext).pkgPath,
+		Variable: &cachedVariable{Name: name, Value: value},
+	})
+}
+
+func (r *buildActionsRecorder) rule(pctx PackageContext, name string, params RuleParams,
+	argNames []string, rule Rule) {
+
+	pool, ok := r.poolRef(params.Pool)
+	if !ok {
+		r.uncacheable = true
+		return
+	}
+	params.Pool = nil
+	r.localRules = append(r.localRules, rule)
+	r.calls = append(r.calls, cachedBuildActionsCall{
+		Pkg:  pctx.(*packageContext).pkgPath,
+		Rule: &cachedRule{Name: name, Params: params, Pool: pool, ArgNames: argNames},
+	})
+}
+
+func (r *buildActionsRecorder) build(pctx PackageContext, params BuildParams) {
+	rule, ruleOk := r.ruleRef(params.Rule)
+	pool, poolOk := r.poolRef(params.Pool)
+	if !ruleOk || !poolOk {
+		r.uncacheable = true
+		return
+	}
+	params.Rule, params.Pool = nil, nil
+	r.calls = append(r.calls, cachedBuildActionsCall{
+		Pkg:   pctx.(*packageContext).pkgPath,
+		Build: &cachedBuild{Params: params, Rule: rule, Pool: pool},
+	})
+}
+
+func (r *buildActionsRecorder) ruleRef(rule Rule) (cachedRef, bool) {
+	switch rule := rule.(type) {
+	case nil:
+		return cachedRef{}, true
+	case *builtinRule:
+		return cachedRef{Builtin: rule.name()}, true
+	case *localRule:
+		for i, local := range r.localRules {
+			if local == rule {
+				return cachedRef{Local: i + 1}, true
+			}
+		}
+		return cachedRef{}, false
+	}
+	pctx := rule.packageContext()
+	if pctx == nil || packageContexts[pctx.pkgPath] != pctx || pctx.scope.rules[rule.name()] != rule {
+		return cachedRef{}, false
+	}
+	return cachedRef{Pkg: pctx.pkgPath, Name: rule.name()}, true
+}
+
+func (r *buildActionsRecorder) poolRef(pool Pool) (cachedRef, bool) {
+	switch pool := pool.(type) {
+	case nil:
+		return cachedRef{}, true
+	case *builtinPool:
+		return cachedRef{Builtin: pool.name()}, true
+	}
+	pctx := pool.packageContext()
+	if pctx == nil || packageContexts[pctx.pkgPath] != pctx || pctx.scope.pools[pool.name()] != pool {
+		return cachedRef{}, false
+	}
+	return cachedRef{Pkg: pctx.pkgPath, Name: pool.name()}, true
+}
+
+// finish returns the cached build actions of a module after GenerateBuildActions completed
+// successfully, or nil if they can't be cached.  providersBefore are the provider values the
+// module had before GenerateBuildActions was called.
+func (r *buildActionsRecorder) finish(mctx *moduleContext, key string,
+	providersBefore []interface{}) *cachedBuildActions {
+
+	if r.uncacheable || mctx.uncacheable {
+		return nil
+	}
+
+	module := mctx.module
+	cached := &cachedBuildActions{
+		Key:             key,
+		Calls:           r.calls,
+		NinjaFileDeps:   mctx.ninjaFileDeps,
+		DeclaredInputs:  module.declaredInputs,
+		DeclaredOutputs: module.declaredOutputs,
+	}
+
+	for id, value := range module.providers {
+		if value == nil || (id < len(providersBefore) && providersBefore[id] != nil) {
+			continue
+		}
+		provider := providerRegistry[id]
+		if provider.typ.Kind() == reflect.Interface {
+			return nil
+		}
+		data, ok := encodeProviderValue(value)
+		if !ok {
+			return nil
+		}
+		cached.Providers = append(cached.Providers, cachedProvider{
+			ID:    id,
+			Type:  provider.typ.String(),
+			Value: data,
+		})
+	}
+
+	for _, phony := range module.phonies {
+		cached.Phonies = append(cached.Phonies, cachedPhony{
+			Name:        phony.name,
+			Deps:        phony.deps,
+			Description: phony.description,
+		})
+	}
+
+	for _, entry := range module.metadata {
+		cached.Metadata = append(cached.Metadata, cachedMetadata{
+			Key:   entry.key,
+			Value: entry.value,
+		})
+	}
+
+	return cached
+}
+
+// replayBuildActions makes the calls recorded in cached on mctx instead of calling
+// GenerateBuildActions.  It returns false if the cached build actions can't be replayed, in
+// which case mctx must be discarded.
+func (c *Context) replayBuildActions(mctx *moduleContext, cached *cachedBuildActions) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	lookupPackage := func(pkgPath string) *packageContext {
+		pctx := packageContexts[pkgPath]
+		if pctx == nil {
+			panic(fmt.Errorf("unknown package %q", pkgPath))
+		}
+		return pctx
+	}
+
+	var localRules []Rule
+	lookupRule := func(ref cachedRef) Rule {
+		switch {
+		case ref.Local > 0:
+			return localRules[ref.Local-1]
+		case ref.Builtin == Phony.name():
+			return Phony
+		case ref.Builtin != "":
+			return NewBuiltinRule(ref.Builtin)
+		case ref.Name != "":
+			if rule := lookupPackage(ref.Pkg).scope.rules[ref.Name]; rule != nil {
+				return rule
+			}
+			panic(fmt.Errorf("unknown rule %s.%s", ref.Pkg, ref.Name))
+		}
+		return nil
+	}
+	lookupPool := func(ref cachedRef) Pool {
+		switch {
+		case ref.Builtin == Console.name():
+			return Console
+		case ref.Builtin != "":
+			return NewBuiltinPool(ref.Builtin)
+		case ref.Name != "":
+			if pool := lookupPackage(ref.Pkg).scope.pools[ref.Name]; pool != nil {
+				return pool
+			}
+			panic(fmt.Errorf("unknown pool %s.%s", ref.Pkg, ref.Name))
+		}
+		return nil
+	}
+
+	// Decode the providers before making any calls so that a provider that can't be decoded
+	// falls back to GenerateBuildActions before the module has any build actions.
+	providers := make([]interface{}, len(cached.Providers))
+	for i, p := range cached.Providers {
+		if p.ID >= len(providerRegistry) || providerRegistry[p.ID].typ.String() != p.Type {
+			return false
+		}
+		value := reflect.New(providerRegistry[p.ID].typ)
+		if err := json.Unmarshal(p.Value, value.Interface()); err != nil {
+			return false
+		}
+		providers[i] = value.Elem().Interface()
+	}
+
+	for _, call := range cached.Calls {
+		pctx := lookupPackage(call.Pkg)
+		switch {
+		case call.Variable != nil:
+			mctx.Variable(pctx, call.Variable.Name, call.Variable.Value)
+		case call.Rule != nil:
+			params := call.Rule.Params
+			params.Pool = lookupPool(call.Rule.Pool)
+			localRules = append(localRules, mctx.Rule(pctx, call.Rule.Name, params, call.Rule.ArgNames...))
+		case call.Build != nil:
+			params := call.Build.Params
+			params.Rule = lookupRule(call.Build.Rule)
+			params.Pool = lookupPool(call.Build.Pool)
+			mctx.Build(pctx, params)
+		}
+	}
+
+	for i, p := range cached.Providers {
+		c.setProvider(mctx.module, providerRegistry[p.ID], providers[i])
+	}
+	for _, phony := range cached.Phonies {
+		mctx.module.phonies = append(mctx.module.phonies, phonyDecl{
+			name:        phony.Name,
+			deps:        phony.Deps,
+			description: phony.Description,
+		})
+	}
+	mctx.ninjaFileDeps = append(mctx.ninjaFileDeps, cached.NinjaFileDeps...)
+	mctx.module.declaredInputs = append(mctx.module.declaredInputs, cached.DeclaredInputs...)
+	mctx.module.declaredOutputs = append(mctx.module.declaredOutputs, cached.DeclaredOutputs...)
+	for _, entry := range cached.Metadata {
+		mctx.module.metadata = append(mctx.module.metadata, metadataEntry{
+			key:   entry.Key,
+			value: entry.Value,
+		})
+	}
+
+	return true
+}
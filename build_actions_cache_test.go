@@ -0,0 +1,226 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	buildActionsCacheTestPctx = NewPackageContext("github.com/google/blueprint/build_actions_cache_test")
+	buildActionsCacheTestRule = buildActionsCacheTestPctx.StaticRule("cc", RuleParams{
+		Command: "cc $flags $in -o $out",
+	}, "flags")
+)
+
+type buildActionsCacheTestInfo struct {
+	Output string
+	Flags  []string
+}
+
+var buildActionsCacheTestInfoProvider = NewProvider(&buildActionsCacheTestInfo{})
+
+type buildActionsCacheTestConfig struct {
+	optimize string
+}
+
+type buildActionsCacheTestModule struct {
+	SimpleName
+	properties struct {
+		Deps   []string
+		Cflags []string
+		Phony  string
+		Walk   bool
+	}
+	generated *buildActionsCacheTestGenerated
+}
+
+// buildActionsCacheTestGenerated records the modules whose GenerateBuildActions was called.
+type buildActionsCacheTestGenerated struct {
+	lock  sync.Mutex
+	names []string
+}
+
+func (g *buildActionsCacheTestGenerated) list() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	sort.Strings(g.names)
+	return g.names
+}
+
+func (m *buildActionsCacheTestModule) Deps() []string       { return m.properties.Deps }
+func (m *buildActionsCacheTestModule) IgnoreDeps() []string { return nil }
+
+func (m *buildActionsCacheTestModule) CacheConfigDependencies(config interface{}) []string {
+	return []string{config.(*buildActionsCacheTestConfig).optimize}
+}
+
+func (m *buildActionsCacheTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated.lock.Lock()
+	m.generated.names = append(m.generated.names, ctx.ModuleName())
+	m.generated.lock.Unlock()
+
+	flags := append([]string{ctx.Config().(*buildActionsCacheTestConfig).optimize}, m.properties.Cflags...)
+	inputs := []string{ctx.ModuleName() + ".c"}
+	visit := ctx.VisitDirectDeps
+	if m.properties.Walk {
+		visit = ctx.VisitDepsDepthFirst
+	}
+	visit(func(dep Module) {
+		info := ctx.OtherModuleProvider(dep, buildActionsCacheTestInfoProvider).(*buildActionsCacheTestInfo)
+		inputs = append(inputs, info.Output)
+		flags = append(flags, info.Flags...)
+	})
+
+	output := ctx.ModuleName() + ".o"
+	ctx.Variable(buildActionsCacheTestPctx, "flags", strings.Join(flags, " "))
+	copyRule := ctx.Rule(buildActionsCacheTestPctx, "copy", RuleParams{Command: "cp $in $out"})
+	ctx.Build(buildActionsCacheTestPctx, BuildParams{
+		Rule:    buildActionsCacheTestRule,
+		Inputs:  inputs,
+		Outputs: []string{output},
+		Args:    map[string]string{"flags": "${flags}"},
+	})
+	ctx.Build(buildActionsCacheTestPctx, BuildParams{
+		Rule:    copyRule,
+		Inputs:  []string{output},
+		Outputs: []string{"out/" + output},
+	})
+	if m.properties.Phony != "" {
+		ctx.Phony(m.properties.Phony, "out/"+output)
+	}
+	ctx.SetProvider(buildActionsCacheTestInfoProvider, &buildActionsCacheTestInfo{
+		Output: output,
+		Flags:  m.properties.Cflags,
+	})
+}
+
+func TestBuildActionsCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "build_actions_cache")
+
+	run := func(t *testing.T, bp string, config *buildActionsCacheTestConfig,
+		cacheFile string) (string, []string) {
+
+		t.Helper()
+		generated := &buildActionsCacheTestGenerated{}
+		ctx := NewContext()
+		ctx.RegisterModuleType("cache_module", func() (Module, []interface{}) {
+			m := &buildActionsCacheTestModule{generated: generated}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		if cacheFile != "" {
+			ctx.SetBuildActionsCacheFile(cacheFile)
+		}
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", config)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(config)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(config)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %s", errs)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), generated.list()
+	}
+
+	bp := `
+		cache_module {
+			name: "a",
+			deps: ["b"],
+			phony: "all",
+		}
+
+		cache_module {
+			name: "b",
+			deps: ["c"],
+			cflags: ["-DB"],
+		}
+
+		cache_module {
+			name: "c",
+		}
+
+		cache_module {
+			name: "walk",
+			deps: ["b"],
+			walk: true,
+		}
+	`
+	config := &buildActionsCacheTestConfig{optimize: "-O2"}
+
+	tests := []struct {
+		name   string
+		bp     string
+		config *buildActionsCacheTestConfig
+		want   []string
+	}{
+		{
+			name:   "first run",
+			bp:     bp,
+			config: config,
+			want:   []string{"a", "b", "c", "walk"},
+		},
+		{
+			// Only the module that visits its transitive dependencies is not cached.
+			name:   "unchanged",
+			bp:     bp,
+			config: config,
+			want:   []string{"walk"},
+		},
+		{
+			// A changed provider value of b invalidates a, but not c.
+			name:   "changed dependency",
+			bp:     strings.Replace(bp, `"-DB"`, `"-DB2"`, 1),
+			config: config,
+			want:   []string{"a", "b", "walk"},
+		},
+		{
+			name:   "changed config",
+			bp:     strings.Replace(bp, `"-DB"`, `"-DB2"`, 1),
+			config: &buildActionsCacheTestConfig{optimize: "-O0"},
+			want:   []string{"a", "b", "c", "walk"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, generated := run(t, test.bp, test.config, cacheFile)
+			if !reflect.DeepEqual(generated, test.want) {
+				t.Errorf("want GenerateBuildActions called for %q, got %q", test.want, generated)
+			}
+
+			// The Ninja file must be the same as the one written without the cache.
+			uncachedOut, _ := run(t, test.bp, test.config, "")
+			if out != uncachedOut {
+				t.Errorf("want Ninja file:\n%s\ngot:\n%s", uncachedOut, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// A BuildStatement describes a Ninja build statement generated by a module or a singleton, for
+// tests and tools that inspect the generated build actions.  Every string is the text that is
+// written to the Ninja file, so it may contain references to Ninja variables.
+type BuildStatement struct {
+	Comment string
+
+	// Rule is the full name of the rule, for example "g.bootstrap.compile" or "phony".
+	Rule string
+
+	Outputs         []string
+	ImplicitOutputs []string
+	Inputs          []string
+
+	// Implicits includes the CommandDeps of the rule, and OrderOnly its CommandOrderOnly, the
+	// same way as they are written to the Ninja file.
+	Implicits   []string
+	OrderOnly   []string
+	Validations []string
+
+	// Args holds the variables set by the build statement, including the arguments of the rule
+	// and variables like "description", keyed by their name in the Ninja file.
+	Args map[string]string
+
+	// Pool is the full name of the pool set by the build statement, or empty if it runs in the
+	// pool of its rule.
+	Pool string
+
+	Optional bool
+}
+
+// ModuleBuildStatements returns the build statements generated by the GenerateBuildActions
+// method of a module variant, in the order they were generated.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) ModuleBuildStatements(logicModule Module) ([]BuildStatement, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return nil, fmt.Errorf("unknown module %v", logicModule)
+	}
+	return c.buildStatements(module.actionDefs.buildDefs), nil
+}
+
+// SingletonBuildStatements returns the build statements generated by the singleton registered
+// with the given name, in the order they were generated.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) SingletonBuildStatements(name string) ([]BuildStatement, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+	for _, info := range c.singletonInfo {
+		if info.name == name {
+			return c.buildStatements(info.actionDefs.buildDefs), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown singleton %q", name)
+}
+
+func (c *Context) buildStatements(buildDefs []*buildDef) []BuildStatement {
+	values := func(strs []ninjaString) []string {
+		if len(strs) == 0 {
+			return nil
+		}
+		ret := make([]string, len(strs))
+		for i, s := range strs {
+			ret[i] = s.Value(c.pkgNames)
+		}
+		return ret
+	}
+
+	statements := make([]BuildStatement, 0, len(buildDefs))
+	for _, def := range buildDefs {
+		implicits, orderOnly := def.Implicits, def.OrderOnly
+		if def.RuleDef != nil {
+			implicits = append(append([]ninjaString(nil), def.RuleDef.CommandDeps...), implicits...)
+			orderOnly = append(append([]ninjaString(nil), def.RuleDef.CommandOrderOnly...), orderOnly...)
+		}
+
+		statement := BuildStatement{
+			Comment:         def.Comment,
+			Rule:            def.Rule.fullName(c.pkgNames),
+			Outputs:         values(def.Outputs),
+			ImplicitOutputs: values(def.ImplicitOutputs),
+			Inputs:          values(def.Inputs),
+			Implicits:       values(implicits),
+			OrderOnly:       values(orderOnly),
+			Validations:     values(def.Validations),
+			Optional:        def.Optional,
+		}
+		if def.Pool != nil {
+			statement.Pool = def.Pool.fullName(c.pkgNames)
+		}
+		if len(def.Args)+len(def.Variables) > 0 {
+			statement.Args = make(map[string]string, len(def.Args)+len(def.Variables))
+			for name, value := range def.Variables {
+				statement.Args[name] = value.Value(c.pkgNames)
+			}
+			for arg, value := range def.Args {
+				statement.Args[arg.fullName(c.pkgNames)] = value.Value(c.pkgNames)
+			}
+		}
+		statements = append(statements, statement)
+	}
+	return statements
+}
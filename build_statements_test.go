@@ -0,0 +1,215 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type buildStatementsTestSingleton struct{}
+
+func (s *buildStatementsTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(strictTestPctx, BuildParams{
+		Rule:     Phony,
+		Outputs:  []string{"all"},
+		Inputs:   []string{"out/a.o"},
+		Optional: true,
+	})
+}
+
+func TestBuildStatements(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("strict_module", newStrictTestModule)
+	ctx.RegisterSingletonType("build_statements_test", func() Singleton {
+		return &buildStatementsTestSingleton{}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			strict_module {
+				name: "a",
+				flags: "-O2",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %s", errs)
+	}
+
+	a := ctx.moduleGroupFromName("a", nil).modules.firstModule().logicModule
+	if _, err := ctx.ModuleBuildStatements(a); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	// The mutators replace the logic module, look it up again.
+	a = ctx.moduleGroupFromName("a", nil).modules.firstModule().logicModule
+	statements, err := ctx.ModuleBuildStatements(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BuildStatement{
+		{
+			Rule:      "g.strict_test.strict_cc",
+			Outputs:   []string{"out/a.o"},
+			Inputs:    []string{"src/a.c"},
+			Implicits: []string{"${g.strict_test.cc}", "include/common/a.h"},
+			Args:      map[string]string{"flags": "-O2"},
+		},
+	}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("incorrect module build statements:\nwant %#v\n got %#v", want, statements)
+	}
+
+	statements, err = ctx.SingletonBuildStatements("build_statements_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []BuildStatement{
+		{
+			Rule:     "phony",
+			Outputs:  []string{"all"},
+			Inputs:   []string{"out/a.o"},
+			Optional: true,
+		},
+	}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("incorrect singleton build statements:\nwant %#v\n got %#v", want, statements)
+	}
+
+	if _, err := ctx.SingletonBuildStatements("missing"); err == nil {
+		t.Errorf("expected an error for an unknown singleton")
+	}
+}
+
+var (
+	buildPoolTestPctx = NewPackageContext("github.com/google/blueprint/build_pool_test")
+
+	buildPoolTestHeavyPool = buildPoolTestPctx.StaticPool("heavy", PoolParams{Depth: 2})
+	buildPoolTestRule      = buildPoolTestPctx.StaticRule("cc", RuleParams{Command: "cc $in -o $out"})
+)
+
+type buildPoolTestModule struct {
+	SimpleName
+	properties struct {
+		Pool string
+	}
+}
+
+func newBuildPoolTestModule() (Module, []interface{}) {
+	m := &buildPoolTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *buildPoolTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := buildPoolTestRule
+	var pool Pool
+	switch m.properties.Pool {
+	case "heavy":
+		pool = buildPoolTestHeavyPool
+	case "console":
+		pool = Console
+	case "local_console":
+		rule = ctx.Rule(buildPoolTestPctx, "interactive", RuleParams{
+			Command: "run $in",
+			Pool:    Console,
+		})
+	}
+	ctx.Build(buildPoolTestPctx, BuildParams{
+		Rule:    rule,
+		Outputs: []string{ctx.ModuleName() + ".out"},
+		Inputs:  []string{ctx.ModuleName() + ".in"},
+		Pool:    pool,
+	})
+}
+
+func TestBuildParamsPool(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("pool_module", newBuildPoolTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			pool_module {
+				name: "default",
+			}
+
+			pool_module {
+				name: "heavy",
+				pool: "heavy",
+			}
+
+			pool_module {
+				name: "console",
+				pool: "console",
+			}
+
+			pool_module {
+				name: "local_console",
+				pool: "local_console",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	pools := make(map[string]string)
+	for _, name := range []string{"default", "heavy", "console", "local_console"} {
+		module := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+		statements, err := ctx.ModuleBuildStatements(module)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pools[name] = statements[0].Pool
+	}
+	want := map[string]string{
+		"default":       "",
+		"heavy":         "g.build_pool_test.heavy",
+		"console":       "console",
+		"local_console": "",
+	}
+	if !reflect.DeepEqual(pools, want) {
+		t.Errorf("incorrect pools\nwant %q\n got %q", want, pools)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"pool g.build_pool_test.heavy\n    depth = 2\n",
+		"build heavy.out: g.build_pool_test.cc heavy.in\n    pool = g.build_pool_test.heavy\n",
+		"build console.out: g.build_pool_test.cc console.in\n    pool = console\n",
+		"    pool = console\n    command = run ${in}\n",
+		"build default.out: g.build_pool_test.cc default.in\ndefault default.out\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("missing %q in Ninja file:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCanceled is returned by ParseBlueprintsFiles, ParseFileList, ResolveDependencies and
+// PrepareBuildActions when the context.Context embedded in the Context is canceled or its
+// deadline is exceeded before the phase completes.  The returned error matches ErrCanceled with
+// errors.Is, and also matches the error returned by the context.Context's Err method, for example
+// context.DeadlineExceeded.
+//
+// Modules whose visitors were already running when the cancellation was noticed are allowed to
+// finish, but no new visitors are started.  The state of a Context whose analysis was canceled is
+// incomplete, and it must not be used for any further phases.
+var ErrCanceled = errors.New("blueprint analysis canceled")
+
+type canceledError struct {
+	cause error
+}
+
+func (e canceledError) Error() string {
+	return ErrCanceled.Error() + ": " + e.cause.Error()
+}
+
+func (e canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e canceledError) Unwrap() error {
+	return e.cause
+}
+
+// checkCanceled returns an error matching ErrCanceled if ctx has been canceled or its deadline
+// has been exceeded, otherwise nil.
+func checkCanceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return canceledError{err}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCancellation(t *testing.T) {
+	bp := map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+		`),
+	}
+
+	newContext := func(ctx context.Context) *Context {
+		c := NewContext()
+		c.Context = ctx
+		c.RegisterModuleType("foo_module", newFooModule)
+		c.RegisterBottomUpMutator("deps", depsMutator)
+		c.MockFileSystem(bp)
+		return c
+	}
+
+	checkCanceledErrs := func(t *testing.T, errs []error, cause error) {
+		t.Helper()
+		if len(errs) != 1 {
+			t.Fatalf("expected a single error, got %q", errs)
+		}
+		if !errors.Is(errs[0], ErrCanceled) {
+			t.Errorf("expected ErrCanceled, got %q", errs[0])
+		}
+		if !errors.Is(errs[0], cause) {
+			t.Errorf("expected %q, got %q", cause, errs[0])
+		}
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c := newContext(ctx)
+		_, errs := c.ParseBlueprintsFiles("Blueprints", nil)
+		checkCanceledErrs(t, errs, context.Canceled)
+	})
+
+	t.Run("mutators", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := newContext(ctx)
+		c.RegisterBottomUpMutator("cancel", func(BottomUpMutatorContext) {
+			cancel()
+		}).Parallel()
+		ranAfterCancel := false
+		c.RegisterBottomUpMutator("after", func(BottomUpMutatorContext) {
+			ranAfterCancel = true
+		})
+
+		_, errs := c.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %q", errs)
+		}
+		_, errs = c.ResolveDependencies(nil)
+		checkCanceledErrs(t, errs, context.Canceled)
+		if ranAfterCancel {
+			t.Errorf("expected mutators after the cancellation not to run")
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+		defer cancel()
+		c := newContext(context.Background())
+
+		_, errs := c.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %q", errs)
+		}
+		c.Context = ctx
+		_, errs = c.PrepareBuildActions(nil)
+		checkCanceledErrs(t, errs, context.DeadlineExceeded)
+	})
+}
@@ -15,6 +15,7 @@
 package blueprint
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -23,16 +24,19 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"text/scanner"
 	"text/template"
+	"time"
 
 	"github.com/google/blueprint/parser"
 	"github.com/google/blueprint/pathtools"
@@ -69,31 +73,136 @@ const MockModuleListFile = "bplist"
 // write phase generates the Ninja manifest text based on the generated build
 // actions.
 type Context struct {
+	// Context is used for profiler labels, and canceling it or exceeding its deadline aborts the
+	// parse and generate phases with an error matching ErrCanceled.
 	context.Context
 
 	// set at instantiation
 	moduleFactories     map[string]ModuleFactory
+	defaultsModuleTypes map[string]bool
+	moduleTypeAliases   map[string]string // set by RegisterModuleTypeAlias
 	nameInterface       NameInterface
 	moduleGroups        []*moduleGroup
 	moduleInfo          map[Module]*moduleInfo
 	modulesSorted       []*moduleInfo
 	preSingletonInfo    []*singletonInfo
 	singletonInfo       []*singletonInfo
+	finalChecks         []*finalCheckInfo
 	mutatorInfo         []*mutatorInfo
 	earlyMutatorInfo    []*mutatorInfo
+	postMutatorInfo     []*postMutatorInfo
 	variantMutatorNames []string
 
+	// the interned variations of the module variants, see variationMap
+	variationMaps *variationMapInterner
+
+	// the literal ninja strings shared by build statements, see literalNinjaStringCache
+	literalNinjaStrings *literalNinjaStringCache
+
 	depsModified uint32 // positive if a mutator modified the dependencies
 
 	dependenciesReady bool // set to true on a successful ResolveDependencies
 	buildActionsReady bool // set to true on a successful PrepareBuildActions
 
+	// set atomically to 1 when ParseFileList is first called, after which the registration
+	// methods panic.  See checkRegistration.
+	parseStarted uint32
+
+	// the name of the phase method that is currently running, used to reject concurrent calls.
+	// See startPhase.
+	phaseLock    sync.Mutex
+	runningPhase string
+
 	// set by SetIgnoreUnknownModuleTypes
 	ignoreUnknownModuleTypes bool
 
 	// set by SetAllowMissingDependencies
 	allowMissingDependencies bool
 
+	// set by SetDetectDuplicateOutputs
+	detectDuplicateOutputs bool
+
+	// set by SetIgnoreSymlinkLoops
+	ignoreSymlinkLoops bool
+
+	// set by SetTrackPaths
+	trackPaths bool
+
+	// set by SetDetectSharedProviders
+	detectSharedProviders bool
+
+	// set by SetAnnotateBuildStatements
+	annotateBuildStatements bool
+
+	// set by SetStableNinjaNames
+	stableNinjaNames bool
+
+	// set by SetFormatCheck
+	formatCheck FormatCheck
+
+	// set by SetRecordModuleDefinitions
+	recordModuleDefinitions bool
+
+	// set by SetTrackPropertyProvenance
+	trackPropertyProvenance bool
+
+	// set by AddPropertyOverrides
+	propertyOverrides []PropertyOverride
+
+	// set by SetVisitParallelism and SetHeavyVisitParallelism
+	visitParallelism      int
+	heavyVisitParallelism int
+
+	// set by RegisterPropertyTagProcessor
+	propertyTagProcessors map[string]PropertyTagProcessor
+
+	// set by SetVariableExpander
+	variableExpander VariableExpander
+
+	// set by RegisterTopLevelVariable, and filled in while parsing
+	topLevelVariableTypes map[string]parser.Type
+	topLevelVariablesLock sync.Mutex
+	topLevelVariables     map[string]map[string]TopLevelVariable
+
+	// set while parsing, see FileInclusions
+	fileInclusionsLock sync.Mutex
+	fileInclusions     map[string]FileInclusions
+
+	// set by RegisterDirectoryMetadata, and filled in lazily by lookupDirectoryMetadata
+	directoryMetadataParsers map[string]DirectoryMetadataParser
+	directoryMetadataLock    sync.Mutex
+	directoryMetadata        map[directoryMetadataKey]*directoryMetadataEntry
+
+	// set while parsing by the package modules, see PackageDefaults
+	packagesLock sync.Mutex
+	packages     map[string]*packageInfo
+
+	// set by RegisterFileParser
+	fileParsers map[string]FileParser
+
+	// set by SetCollectMetrics, see WriteTrace
+	metrics *metrics
+
+	// set by runMutator, see MutatorStats
+	mutatorStats []MutatorStats
+
+	// set while parsing and by module globs, see SlowestBlueprintsFiles
+	blueprintsFileTimesLock sync.Mutex
+	blueprintsFileTimes     map[string]*BlueprintsFileTime
+
+	// reported by the Warningf methods of module, mutator and singleton contexts, see Warnings
+	warningsLock     sync.Mutex
+	warnings         []error
+	promotedWarnings []error
+	warningsAsErrors map[WarningClass]bool // set by SetWarningsAsErrors
+
+	// set by SetCollectDiagnostics, and filled in at the end of each phase, see Diagnostics
+	collectDiagnostics bool
+	diagnostics        []Diagnostic
+
+	// filled in at the end of each phase, see DependencyCycles
+	dependencyCycles []*DependencyCycle
+
 	// set during PrepareBuildActions
 	pkgNames        map[*packageContext]string
 	liveGlobals     *liveTracker
@@ -109,6 +218,11 @@ type Context struct {
 
 	subninjas []string
 
+	// set atomically to 1 by recordNinjaFeatures when a build statement that uses
+	// BuildParams.Dyndep or BuildParams.Validations is generated
+	usesDyndep      uint32
+	usesValidations uint32
+
 	// set lazily by sortedModuleGroups
 	cachedSortedModuleGroups []*moduleGroup
 	// cache deps modified to determine whether cachedSortedModuleGroups needs to be recalculated
@@ -117,10 +231,61 @@ type Context struct {
 	globs    map[globKey]pathtools.GlobResult
 	globLock sync.Mutex
 
+	// ASTs of parsed Blueprints files that can be reused for identical files, keyed by
+	// sharedASTKey
+	sharedASTs    map[string]*sharedAST
+	sharedASTLock sync.Mutex
+
+	// set by SetGlobCacheFile
+	globCacheFile string
+	globCacheOnce sync.Once
+	globCache     map[globKey]globCacheEntry // read from globCacheFile
+	globModTimes  map[globKey][]int64        // the dependency modification times of globs
+
 	srcDir         string
 	fs             pathtools.FileSystem
 	moduleListFile string
 
+	// set by SetModuleDiscovery, and the directories searched by the last ListModulePaths
+	moduleDiscovery     *ModuleDiscovery
+	moduleDiscoveryDeps []string
+
+	// set by SetAnalysisCacheFile
+	analysisCache *analysisCache
+
+	// set by SetBuildActionsCacheFile
+	buildActionsCache *buildActionsCache
+
+	// set by SetMutatorSnapshotDir
+	mutatorSnapshotDir string
+
+	// set by SetSelectEvaluator
+	selectEvaluator parser.SelectEvaluator
+
+	// set by SetModuleFragmentDir
+	moduleFragmentDir string
+
+	// set by SetDisabledDependencyBehavior
+	disabledDependencyBehavior DisabledDependencyBehavior
+
+	// set by findPrebuiltsWithoutSource, see PrebuiltModule
+	prebuiltsWithoutSource map[string]*moduleGroup
+
+	// set by SetRootModulePredicate
+	rootModulePredicate RootModulePredicate
+
+	// set by SetPhonyHelpTarget
+	phonyHelpTarget string
+
+	// set by SetMetadataFile
+	metadataFile string
+
+	// set during PrepareBuildActions if any phony targets were declared, see Phony
+	phonyInfo *singletonInfo
+
+	// set by pruneUnreachableVariants, see PruneStats
+	pruneStats PruneStats
+
 	// Mutators indexed by the ID of the provider associated with them.  Not all mutators will
 	// have providers, and not all providers will have a mutator, or if they do the mutator may
 	// not be registered in this Context.
@@ -168,6 +333,35 @@ func (e *PropertyError) Error() string {
 	return fmt.Sprintf("%s: %s: %s: %s", e.Pos, e.module, e.property, e.Err)
 }
 
+// Unwrap returns the underlying error, so that errors.Is and errors.As can match the kind of the
+// error (see ErrMissingDependency and friends) or any error wrapped by a BlueprintError,
+// ModuleError or PropertyError.
+func (e *BlueprintError) Unwrap() error {
+	return e.Err
+}
+
+// As allows errors.As to find the BlueprintError embedded in a ModuleError.
+func (e *ModuleError) As(target interface{}) bool {
+	if t, ok := target.(**BlueprintError); ok {
+		*t = &e.BlueprintError
+		return true
+	}
+	return false
+}
+
+// As allows errors.As to find the ModuleError and BlueprintError embedded in a PropertyError.
+func (e *PropertyError) As(target interface{}) bool {
+	switch t := target.(type) {
+	case **ModuleError:
+		*t = &e.ModuleError
+		return true
+	case **BlueprintError:
+		*t = &e.BlueprintError
+		return true
+	}
+	return false
+}
+
 type localBuildActions struct {
 	variables []*localVariable
 	rules     []*localRule
@@ -247,6 +441,13 @@ type moduleInfo struct {
 	propertyPos       map[string]scanner.Position
 	createdBy         *moduleInfo
 
+	// set during Parse if SetRecordModuleDefinitions was called, see ExtractFixture
+	def *parser.Module
+
+	// set during Parse if SetTrackPropertyProvenance was called, and updated whenever the
+	// properties may have changed
+	provenance *proptools.Provenance
+
 	variant variant
 
 	logicModule Module
@@ -254,8 +455,25 @@ type moduleInfo struct {
 	properties  []interface{}
 
 	// set during ResolveDependencies
-	missingDeps   []string
-	newDirectDeps []depInfo
+	missingDeps         []string
+	missingOptionalDeps []string
+	newDirectDeps       []depInfo
+
+	// set by BaseMutatorContext.Disable or after mutators if the module implements
+	// DisableableModule and is not enabled
+	disabled bool
+
+	// set after mutators if a RootModulePredicate was set and the module is not reachable from
+	// any root
+	pruned bool
+
+	// set by BaseMutatorContext.MarkHeavy or before GenerateBuildActions if the module implements
+	// HeavyModule and is heavy
+	heavy bool
+
+	// set by BaseMutatorContext.MarkDefaultTarget and BaseMutatorContext.MarkDistTarget
+	defaultTarget bool
+	distTarget    bool
 
 	// set during updateDependencies
 	reverseDeps []*moduleInfo
@@ -268,9 +486,26 @@ type moduleInfo struct {
 	// set during each runMutator
 	splitModules modulesOrAliases
 
+	// set by the top down pass of a transition mutator, and consumed by its bottom up pass
+	transitionVariations []string
+
 	// set during PrepareBuildActions
 	actionDefs localBuildActions
 
+	// set by ModuleContext.InputFile and ModuleContext.OutputFile
+	declaredInputs  []string
+	declaredOutputs []string
+
+	// set by ModuleContext.Phony and ModuleContext.DescribePhony
+	phonies []phonyDecl
+
+	// set by ModuleContext.RecordMetadata
+	metadata []metadataEntry
+
+	// set during PrepareBuildActions when the build actions cache is enabled, a digest of the
+	// provider values of the module, or empty if they can't be encoded
+	providersDigest string
+
 	providers []interface{}
 
 	startedMutator  *mutatorInfo
@@ -289,6 +524,9 @@ type variant struct {
 type depInfo struct {
 	module *moduleInfo
 	tag    DependencyTag
+
+	// mutator is the mutator that added the dependency, used to explain dependency cycles.
+	mutator *mutatorInfo
 }
 
 func (module *moduleInfo) Name() string {
@@ -316,6 +554,12 @@ func (module *moduleInfo) String() string {
 	return s
 }
 
+// dir returns the directory of the Blueprints file that defined the module as a clean
+// slash-separated path relative to the source root, "." for the root directory.
+func (module *moduleInfo) dir() string {
+	return path.Dir(module.relBlueprintsFile)
+}
+
 func (module *moduleInfo) namespace() Namespace {
 	return module.group.namespace
 }
@@ -331,44 +575,19 @@ type Variation struct {
 	Variation string
 }
 
-// A variationMap stores a map of Mutator to Variation to specify a variant of a module.
-type variationMap map[string]string
-
-func (vm variationMap) clone() variationMap {
-	if vm == nil {
-		return nil
-	}
-	newVm := make(variationMap)
-	for k, v := range vm {
-		newVm[k] = v
-	}
-
-	return newVm
-}
-
-// Compare this variationMap to another one.  Returns true if the every entry in this map
-// exists and has the same value in the other map.
-func (vm variationMap) subsetOf(other variationMap) bool {
-	for k, v1 := range vm {
-		if v2, ok := other[k]; !ok || v1 != v2 {
-			return false
-		}
-	}
-	return true
-}
-
-func (vm variationMap) equal(other variationMap) bool {
-	return reflect.DeepEqual(vm, other)
-}
-
 type singletonInfo struct {
 	// set during RegisterSingletonType
 	factory   SingletonFactory
 	singleton Singleton
 	name      string
+	parallel  bool
+
+	// set during SingletonRunsAfter
+	after []*singletonInfo
 
 	// set during PrepareBuildActions
 	actionDefs localBuildActions
+	phonies    []phonyDecl
 }
 
 type mutatorInfo struct {
@@ -377,21 +596,27 @@ type mutatorInfo struct {
 	bottomUpMutator BottomUpMutator
 	name            string
 	parallel        bool
+
+	// set by MutatorHandle.Before and MutatorHandle.After
+	before []string
+	after  []string
 }
 
 func newContext() *Context {
 	return &Context{
-		Context:            context.Background(),
-		moduleFactories:    make(map[string]ModuleFactory),
-		nameInterface:      NewSimpleNameInterface(),
-		moduleInfo:         make(map[Module]*moduleInfo),
-		globs:              make(map[globKey]pathtools.GlobResult),
-		fs:                 pathtools.OsFs,
-		finishedMutators:   make(map[*mutatorInfo]bool),
-		ninjaBuildDir:      nil,
-		requiredNinjaMajor: 1,
-		requiredNinjaMinor: 7,
-		requiredNinjaMicro: 0,
+		Context:             context.Background(),
+		moduleFactories:     make(map[string]ModuleFactory),
+		nameInterface:       NewSimpleNameInterface(),
+		moduleInfo:          make(map[Module]*moduleInfo),
+		globs:               make(map[globKey]pathtools.GlobResult),
+		fs:                  pathtools.OsFs,
+		finishedMutators:    make(map[*mutatorInfo]bool),
+		variationMaps:       newVariationMapInterner(),
+		literalNinjaStrings: newLiteralNinjaStringCache(),
+		ninjaBuildDir:       nil,
+		requiredNinjaMajor:  1,
+		requiredNinjaMinor:  7,
+		requiredNinjaMicro:  0,
 	}
 }
 
@@ -474,12 +699,86 @@ type ModuleFactory func() (m Module, propertyStructs []interface{})
 // The factory function may be called from multiple goroutines.  Any accesses
 // to global variables must be synchronized.
 func (c *Context) RegisterModuleType(name string, factory ModuleFactory) {
+	c.checkRegistration("RegisterModuleType")
+
 	if _, present := c.moduleFactories[name]; present {
 		panic(errors.New("module type name is already registered"))
 	}
+	if _, present := c.moduleTypeAliases[name]; present {
+		panic(errors.New("module type name is already registered as an alias"))
+	}
+	if name == packageModuleType {
+		panic(fmt.Errorf("module type name %q is reserved for package modules", name))
+	}
 	c.moduleFactories[name] = factory
 }
 
+// RegisterModuleTypeAlias registers oldName as an alias of the module type newName, which must
+// already be registered, to allow renaming a module type without breaking the Blueprints files
+// that use the old name.  Modules defined with the old name are created by the factory of newName
+// and have newName as their type, and a warning of class WarningClassModuleTypeAlias is reported
+// at the position of each of them.  The aliases are returned by ModuleTypeAliases, so that tools
+// can rewrite the Blueprints files to use the new names, see edit.File.RenameModuleTypes.
+func (c *Context) RegisterModuleTypeAlias(oldName, newName string) {
+	c.checkRegistration("RegisterModuleTypeAlias")
+
+	if _, present := c.moduleFactories[oldName]; present || oldName == packageModuleType {
+		panic(fmt.Errorf("module type %q is already registered", oldName))
+	}
+	if _, present := c.moduleTypeAliases[oldName]; present {
+		panic(fmt.Errorf("module type alias %q is already registered", oldName))
+	}
+	if _, present := c.moduleFactories[newName]; !present {
+		panic(fmt.Errorf("module type %q of alias %q is not registered", newName, oldName))
+	}
+	if c.moduleTypeAliases == nil {
+		c.moduleTypeAliases = make(map[string]string)
+	}
+	c.moduleTypeAliases[oldName] = newName
+}
+
+// resolveModuleTypeAlias returns moduleDef if its type is not an alias registered with
+// RegisterModuleTypeAlias.  Otherwise it returns a copy of moduleDef with the type the alias
+// refers to, and the warning to report for it.
+func (c *Context) resolveModuleTypeAlias(moduleDef *parser.Module) (*parser.Module, error) {
+	newName, ok := c.moduleTypeAliases[moduleDef.Type]
+	if !ok {
+		return moduleDef, nil
+	}
+
+	warning := &BlueprintError{
+		Err: fmt.Errorf("module type %q is deprecated, use %q instead", moduleDef.Type, newName),
+		Pos: moduleDef.TypePos,
+	}
+	aliased := *moduleDef
+	aliased.Type = newName
+	return &aliased, warning
+}
+
+// SetSelectEvaluator sets the SelectEvaluator that provides the values of the conditions used by
+// select expressions in Blueprints files, for example the target OS or architecture from the
+// config.  It must be called before parsing.  If it is not called every condition is treated as
+// unset, and select expressions evaluate to their default case.
+func (c *Context) SetSelectEvaluator(evaluator parser.SelectEvaluator) {
+	c.checkRegistration("SetSelectEvaluator")
+
+	c.selectEvaluator = evaluator
+}
+
+// RegisterDefaultsModuleType registers a module type that is used to hold default property values
+// for other modules.  It behaves like RegisterModuleType, except that modules that implement
+// DefaultableModule can list modules of this type in their defaults, and the property structs of
+// those defaults modules will be applied to them before any mutators run.  See DefaultableModule
+// for the details of how defaults are applied.
+func (c *Context) RegisterDefaultsModuleType(name string, factory ModuleFactory) {
+	c.checkRegistration("RegisterDefaultsModuleType")
+	c.RegisterModuleType(name, factory)
+	if c.defaultsModuleTypes == nil {
+		c.defaultsModuleTypes = make(map[string]bool)
+	}
+	c.defaultsModuleTypes[name] = true
+}
+
 // A SingletonFactory function creates a new Singleton object.  See the
 // Context.RegisterSingletonType method for details about how a registered
 // SingletonFactory is used by a Context.
@@ -488,12 +787,19 @@ type SingletonFactory func() Singleton
 // RegisterSingletonType registers a singleton type that will be invoked to
 // generate build actions.  Each registered singleton type is instantiated and
 // and invoked exactly once as part of the generate phase.  Each registered
-// singleton is invoked in registration order.
+// singleton is invoked in registration order, after all the singletons
+// registered before it have finished and before any singleton registered after
+// it starts, see RegisterParallelSingletonType.
 //
 // The singleton type names given here must be unique for the context.  The
 // factory function should be a named function so that its package and name can
 // be included in the generated Ninja file for debugging purposes.
 func (c *Context) RegisterSingletonType(name string, factory SingletonFactory) {
+	c.checkRegistration("RegisterSingletonType")
+	c.registerSingletonType(name, factory, false)
+}
+
+func (c *Context) registerSingletonType(name string, factory SingletonFactory, parallel bool) {
 	for _, s := range c.singletonInfo {
 		if s.name == name {
 			panic(errors.New("singleton name is already registered"))
@@ -504,6 +810,7 @@ func (c *Context) RegisterSingletonType(name string, factory SingletonFactory) {
 		factory:   factory,
 		singleton: factory(),
 		name:      name,
+		parallel:  parallel,
 	})
 }
 
@@ -516,6 +823,8 @@ func (c *Context) RegisterSingletonType(name string, factory SingletonFactory) {
 // factory function should be a named function so that its package and name can
 // be included in the generated Ninja file for debugging purposes.
 func (c *Context) RegisterPreSingletonType(name string, factory SingletonFactory) {
+	c.checkRegistration("RegisterPreSingletonType")
+
 	for _, s := range c.preSingletonInfo {
 		if s.name == name {
 			panic(errors.New("presingleton name is already registered"))
@@ -529,11 +838,128 @@ func (c *Context) RegisterPreSingletonType(name string, factory SingletonFactory
 	})
 }
 
+// A PropertyTagProcessor is called while the properties of a module are unpacked from a
+// Blueprints file, once for each property that is set in the file and is unpacked into a struct
+// field that has the struct tag key the processor was registered for.  It receives the struct
+// field, the value of the tag and the value of the field after the property was unpacked into
+// it, and may modify the value.  An error returned by the processor is reported at the property.
+// Blueprints files are parsed in parallel, so the processor may be called from multiple
+// goroutines at once.
+type PropertyTagProcessor func(ctx PropertyTagContext, field reflect.StructField, tagValue string,
+	value reflect.Value) error
+
+// PropertyTagContext is passed to a PropertyTagProcessor or a VariableExpander.
+type PropertyTagContext interface {
+	// Module returns the module whose properties are being unpacked.  Its properties may only be
+	// partially unpacked, and it has not been given a name or added to the Context yet.
+	Module() Module
+
+	// ModuleType returns the module type of the module.
+	ModuleType() string
+
+	// BlueprintsFile returns the path of the Blueprints file that defines the module, relative to
+	// the root source directory.
+	BlueprintsFile() string
+
+	// PropertyName returns the full name of the property being unpacked, for example "foo.bar".
+	PropertyName() string
+}
+
+type propertyTagContext struct {
+	module       *moduleInfo
+	propertyName string
+}
+
+func (ctx *propertyTagContext) Module() Module {
+	return ctx.module.logicModule
+}
+
+func (ctx *propertyTagContext) ModuleType() string {
+	return ctx.module.typeName
+}
+
+func (ctx *propertyTagContext) BlueprintsFile() string {
+	return ctx.module.relBlueprintsFile
+}
+
+func (ctx *propertyTagContext) PropertyName() string {
+	return ctx.propertyName
+}
+
+// RegisterPropertyTagProcessor registers a PropertyTagProcessor that is called for each property
+// set in a Blueprints file that is unpacked into a struct field with a tag of the form
+// `tagKey:"..."`, for example `android:"arch_variant"`.  Only one processor can be registered
+// for each tag key.  When a field has multiple tags with registered processors they are called
+// in order of the tag keys.
+func (c *Context) RegisterPropertyTagProcessor(tagKey string, processor PropertyTagProcessor) {
+	c.checkRegistration("RegisterPropertyTagProcessor")
+
+	if _, present := c.propertyTagProcessors[tagKey]; present {
+		panic(fmt.Errorf("property tag processor for %q is already registered", tagKey))
+	}
+	if c.propertyTagProcessors == nil {
+		c.propertyTagProcessors = make(map[string]PropertyTagProcessor)
+	}
+	c.propertyTagProcessors[tagKey] = processor
+}
+
+// checkRegistration panics with a descriptive error if a method that configures the Context
+// is called after parsing has begun.  Module types, singletons, mutators and the other
+// registration state are read without locking once parsing starts, so changing them later
+// would race with the parse and mutator goroutines.  The Register* methods and
+// SingletonRunsAfter call it, and so do the Set* and Mock* methods whose settings are used by
+// parsing.  Settings that are only read by later phases, for example SetAllowMissingDependencies,
+// SetVisitParallelism, SetWarningsAsErrors, SetBuildActionsCacheFile and AddPropertyOverrides,
+// can still be changed between phases; like every other method of the Context they must not be
+// called while a phase is running.
+func (c *Context) checkRegistration(method string) {
+	if atomic.LoadUint32(&c.parseStarted) != 0 {
+		panic(fmt.Errorf("%s called after parsing began", method))
+	}
+}
+
+// startPhase marks the Context as running the given phase method, and returns an error if
+// another phase method is already running.  A Context must not be used from multiple goroutines
+// at once; calls to ParseFileList, ResolveDependencies, PrepareBuildActions and WriteBuildFile
+// must happen one at a time.  The caller must call endPhase when the phase completes.
+func (c *Context) startPhase(method string) error {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+
+	if c.runningPhase != "" {
+		return fmt.Errorf("%s called while %s is still running; a Context cannot be used concurrently",
+			method, c.runningPhase)
+	}
+	c.runningPhase = method
+	return nil
+}
+
+func (c *Context) endPhase() {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	c.runningPhase = ""
+}
+
+// profileRegion runs f with the pprof label key=value added to the labels of ctx, inside a
+// runtime/trace region with the same name, so that the time spent in f can be attributed to it
+// in both CPU profiles and execution traces.
+func profileRegion(ctx context.Context, key, value string, f func(ctx context.Context)) {
+	pprof.Do(ctx, pprof.Labels(key, value), func(ctx context.Context) {
+		trace.WithRegion(ctx, key+"="+value, func() {
+			f(ctx)
+		})
+	})
+}
+
 func (c *Context) SetNameInterface(i NameInterface) {
+	c.checkRegistration("SetNameInterface")
+
 	c.nameInterface = i
 }
 
 func (c *Context) SetSrcDir(path string) {
+	c.checkRegistration("SetSrcDir")
+
 	c.srcDir = path
 	c.fs = pathtools.NewOsFs(path)
 }
@@ -560,8 +986,9 @@ func singletonTypeName(singleton Singleton) string {
 
 // RegisterTopDownMutator registers a mutator that will be invoked to propagate dependency info
 // top-down between Modules.  Each registered mutator is invoked in registration order (mixing
-// TopDownMutators and BottomUpMutators) once per Module, and the invocation on any module will
-// have returned before it is in invoked on any of its dependencies.
+// TopDownMutators and BottomUpMutators), unless constrained with MutatorHandle.Before or
+// MutatorHandle.After, once per Module, and the invocation on any module will have returned
+// before it is in invoked on any of its dependencies.
 //
 // The mutator type names given here must be unique to all top down mutators in
 // the Context.
@@ -569,6 +996,8 @@ func singletonTypeName(singleton Singleton) string {
 // Returns a MutatorHandle, on which Parallel can be called to set the mutator to visit modules in
 // parallel while maintaining ordering.
 func (c *Context) RegisterTopDownMutator(name string, mutator TopDownMutator) MutatorHandle {
+	c.checkRegistration("RegisterTopDownMutator")
+
 	for _, m := range c.mutatorInfo {
 		if m.name == name && m.topDownMutator != nil {
 			panic(fmt.Errorf("mutator name %s is already registered", name))
@@ -587,7 +1016,8 @@ func (c *Context) RegisterTopDownMutator(name string, mutator TopDownMutator) Mu
 
 // RegisterBottomUpMutator registers a mutator that will be invoked to split Modules into variants.
 // Each registered mutator is invoked in registration order (mixing TopDownMutators and
-// BottomUpMutators) once per Module, will not be invoked on a module until the invocations on all
+// BottomUpMutators), unless constrained with MutatorHandle.Before or MutatorHandle.After, once
+// per Module, will not be invoked on a module until the invocations on all
 // of the modules dependencies have returned.
 //
 // The mutator type names given here must be unique to all bottom up or early
@@ -596,6 +1026,8 @@ func (c *Context) RegisterTopDownMutator(name string, mutator TopDownMutator) Mu
 // Returns a MutatorHandle, on which Parallel can be called to set the mutator to visit modules in
 // parallel while maintaining ordering.
 func (c *Context) RegisterBottomUpMutator(name string, mutator BottomUpMutator) MutatorHandle {
+	c.checkRegistration("RegisterBottomUpMutator")
+
 	for _, m := range c.variantMutatorNames {
 		if m == name {
 			panic(fmt.Errorf("mutator name %s is already registered", name))
@@ -618,6 +1050,15 @@ type MutatorHandle interface {
 	// method on the mutator context is thread-safe, but the mutator must handle synchronization
 	// for any modifications to global state or any modules outside the one it was invoked on.
 	Parallel() MutatorHandle
+
+	// Before constrains the mutator to run before the mutators with the given names, instead of
+	// in registration order.  The mutators are sorted to satisfy all the constraints at the start
+	// of ResolveDependencies, keeping registration order where it is not constrained, and a
+	// constraint that names an unknown mutator or a cycle of constraints is reported as an error.
+	Before(names ...string) MutatorHandle
+
+	// After constrains the mutator to run after the mutators with the given names, see Before.
+	After(names ...string) MutatorHandle
 }
 
 func (mutator *mutatorInfo) Parallel() MutatorHandle {
@@ -625,6 +1066,16 @@ func (mutator *mutatorInfo) Parallel() MutatorHandle {
 	return mutator
 }
 
+func (mutator *mutatorInfo) Before(names ...string) MutatorHandle {
+	mutator.before = append(mutator.before, names...)
+	return mutator
+}
+
+func (mutator *mutatorInfo) After(names ...string) MutatorHandle {
+	mutator.after = append(mutator.after, names...)
+	return mutator
+}
+
 // RegisterEarlyMutator registers a mutator that will be invoked to split
 // Modules into multiple variant Modules before any dependencies have been
 // created.  Each registered mutator is invoked in registration order once
@@ -641,6 +1092,8 @@ func (mutator *mutatorInfo) Parallel() MutatorHandle {
 // EarlyMutator and BottomUpMutator is that EarlyMutator runs before the
 // deprecated DynamicDependencies.
 func (c *Context) RegisterEarlyMutator(name string, mutator EarlyMutator) {
+	c.checkRegistration("RegisterEarlyMutator")
+
 	for _, m := range c.variantMutatorNames {
 		if m == name {
 			panic(fmt.Errorf("mutator name %s is already registered", name))
@@ -666,6 +1119,8 @@ func (c *Context) RegisterEarlyMutator(name string, mutator EarlyMutator) {
 // This method should generally not be used.  It exists to facilitate the
 // bootstrapping process.
 func (c *Context) SetIgnoreUnknownModuleTypes(ignoreUnknownModuleTypes bool) {
+	c.checkRegistration("SetIgnoreUnknownModuleTypes")
+
 	c.ignoreUnknownModuleTypes = ignoreUnknownModuleTypes
 }
 
@@ -677,11 +1132,133 @@ func (c *Context) SetAllowMissingDependencies(allowMissingDependencies bool) {
 	c.allowMissingDependencies = allowMissingDependencies
 }
 
+// SetDetectDuplicateOutputs enables a check at the end of PrepareBuildActions that reports an
+// error naming both definers when two build statements, from any modules or singletons, declare
+// the same output or implicit output.  Without it the problem is only reported by ninja.
+func (c *Context) SetDetectDuplicateOutputs(detectDuplicateOutputs bool) {
+	c.detectDuplicateOutputs = detectDuplicateOutputs
+}
+
+// SetIgnoreSymlinkLoops changes the behavior of Blueprint when a recursive glob, for example one
+// used by subdirs, follows a symlink that leads back to a directory that is already being
+// searched.  By default a pathtools.SymlinkLoopError that lists the loop is reported.  If this
+// method is called with ignoreSymlinkLoops set to true the symlink is silently skipped.
+func (c *Context) SetIgnoreSymlinkLoops(ignoreSymlinkLoops bool) {
+	c.checkRegistration("SetIgnoreSymlinkLoops")
+
+	c.ignoreSymlinkLoops = ignoreSymlinkLoops
+}
+
+// SetTrackPaths enables a check at the end of PrepareBuildActions that every input, implicit
+// input and order-only dependency of the build statements of a module is either a source file
+// declared with ModuleContext.InputFile, an output of one of the module's own build statements,
+// or a file declared with ModuleContext.OutputFile by one of its direct dependencies.  It also
+// checks that every file declared with ModuleContext.OutputFile is built by the module.  Only
+// modules that declare at least one file are checked, so that module types can be converted to
+// declare their files one at a time.
+func (c *Context) SetTrackPaths(trackPaths bool) {
+	c.trackPaths = trackPaths
+}
+
+// SetDetectSharedProviders enables a warning when a mutator splits a module into variants after
+// a provider was set on it, the provider doesn't have a clone function registered with
+// SetProviderClone, and its value contains pointers, maps or slices that are now shared between
+// the variants.  Modifying such a value for one variant would silently affect the others.
+func (c *Context) SetDetectSharedProviders(detectSharedProviders bool) {
+	c.detectSharedProviders = detectSharedProviders
+}
+
+// SetAnnotateBuildStatements adds a comment above each build statement in the Ninja file that
+// names the module and variant, or the singleton, that generated it and the file and line of the
+// Go code that called Build, so that a bad build statement can be traced back to the code that
+// produced it.  It is intended for debugging, as the file and line change with every edit to the
+// Go code.
+func (c *Context) SetAnnotateBuildStatements(annotateBuildStatements bool) {
+	c.annotateBuildStatements = annotateBuildStatements
+}
+
+// SetVisitParallelism sets the maximum number of modules that are visited at the same time by
+// parallel mutators, GenerateBuildActions and post mutators.  The default is 1000.
+func (c *Context) SetVisitParallelism(limit int) {
+	c.visitParallelism = limit
+}
+
+// SetHeavyVisitParallelism sets the maximum number of heavy modules, see HeavyModule, whose
+// GenerateBuildActions runs at the same time.  Heavy modules run in their own pool of workers, so
+// that a few modules doing expensive work don't starve the others.  The default is the number of
+// CPUs.
+func (c *Context) SetHeavyVisitParallelism(limit int) {
+	c.heavyVisitParallelism = limit
+}
+
+func (c *Context) visitLimit() int {
+	if c.visitParallelism > 0 {
+		return c.visitParallelism
+	}
+	return parallelVisitLimit
+}
+
+func (c *Context) heavyVisitLimit() int {
+	if c.heavyVisitParallelism > 0 {
+		return c.heavyVisitParallelism
+	}
+	return runtime.NumCPU()
+}
+
+// FormatCheck controls how Blueprints files that don't match the canonical formatting of bpfmt
+// are reported while parsing.
+type FormatCheck int
+
+const (
+	// FormatCheckNone doesn't check the formatting of Blueprints files.  This is the default.
+	FormatCheckNone FormatCheck = iota
+
+	// FormatCheckWarning reports formatting differences as warnings, see Context.Warnings.
+	FormatCheckWarning
+
+	// FormatCheckError reports formatting differences as errors from ParseBlueprintsFiles.
+	FormatCheckError
+)
+
+// SetFormatCheck enables checking that each Blueprints file that is parsed matches the output of
+// bpfmt, so that a project can enforce formatting without a separate walk over its Blueprints
+// files.  Each block of lines that differs is reported at its first line, see parser.CheckFormat.
+func (c *Context) SetFormatCheck(check FormatCheck) {
+	c.checkRegistration("SetFormatCheck")
+
+	c.formatCheck = check
+}
+
+// annotateBuildDef adds the comment described in SetAnnotateBuildStatements to a build statement
+// generated by origin.  skip is the number of stack frames between the caller of Build and
+// annotateBuildDef.
+func annotateBuildDef(def *buildDef, origin string, skip int) {
+	annotation := "Generated by " + origin
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		annotation += fmt.Sprintf(" at %s:%d", file, line)
+	}
+	if def.Comment != "" {
+		def.Comment += "\n" + annotation
+	} else {
+		def.Comment = annotation
+	}
+}
+
 func (c *Context) SetModuleListFile(listFile string) {
+	c.checkRegistration("SetModuleListFile")
+
 	c.moduleListFile = listFile
 }
 
+// ListModulePaths returns the paths of the Blueprints files to parse, from the module list file
+// set by SetModuleListFile with each path relative to baseDir, or by walking baseDir if
+// SetModuleDiscovery was called.
 func (c *Context) ListModulePaths(baseDir string) (paths []string, err error) {
+	if c.moduleDiscovery != nil {
+		paths, c.moduleDiscoveryDeps, err = c.discoverModulePaths(baseDir)
+		return paths, err
+	}
+
 	reader, err := c.fs.Open(c.moduleListFile)
 	if err != nil {
 		return nil, err
@@ -733,7 +1310,8 @@ func (c *Context) ParseBlueprintsFiles(rootFile string,
 	if err != nil {
 		return nil, []error{err}
 	}
-	return c.ParseFileList(baseDir, pathsToParse, config)
+	deps, errs = c.ParseFileList(baseDir, pathsToParse, config)
+	return append(deps, c.moduleDiscoveryDeps...), errs
 }
 
 func (c *Context) ParseFileList(rootDir string, filePaths []string,
@@ -743,8 +1321,31 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 		return nil, []error{fmt.Errorf("no paths provided to parse")}
 	}
 
+	if err := c.startPhase("ParseFileList"); err != nil {
+		return nil, []error{err}
+	}
+	defer c.endPhaseWithWarnings(&errs)
+
+	profileRegion(c.Context, "blueprint", "ParseFileList", func(context.Context) {
+		deps, errs = c.parseFileList(rootDir, filePaths, config)
+	})
+	return deps, errs
+}
+
+func (c *Context) parseFileList(rootDir string, filePaths []string,
+	config interface{}) (deps []string, errs []error) {
+
+	if err := checkCanceled(c.Context); err != nil {
+		return nil, []error{err}
+	}
+	atomic.StoreUint32(&c.parseStarted, 1)
+
 	c.dependenciesReady = false
 
+	if c.analysisCache != nil {
+		c.analysisCache.load()
+	}
+
 	type newModuleInfo struct {
 		*moduleInfo
 		added chan<- struct{}
@@ -758,7 +1359,7 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 
 	// handler must be reentrant
 	handleOneFile := func(file *parser.File) {
-		if atomic.LoadUint32(&numErrs) > maxErrors {
+		if atomic.LoadUint32(&numErrs) > maxErrors || c.Context.Err() != nil {
 			return
 		}
 
@@ -788,10 +1389,26 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 			return nil
 		}
 
+		// Add the package module first, so that the load hooks of the modules in the same file
+		// see its defaults.
 		for _, def := range file.Defs {
+			if def, ok := def.(*parser.Module); ok && def.Type == packageModuleType {
+				if errs := c.addPackage(def, file.Name); len(errs) > 0 {
+					atomic.AddUint32(&numErrs, uint32(len(errs)))
+					errsCh <- errs
+				}
+			}
+		}
+
+		for i, def := range file.Defs {
 			switch def := def.(type) {
 			case *parser.Module:
-				module, errs := processModuleDef(def, file.Name, c.moduleFactories, scopedModuleFactories, c.ignoreUnknownModuleTypes)
+				if def.Type == packageModuleType {
+					continue
+				}
+				start := time.Now()
+				module, errs := c.processModuleDefWithCache(def, file.Name, i, scopedModuleFactories)
+				c.addBlueprintsFileTime(file.Name, 0, time.Since(start), 0)
 				if len(errs) == 0 && module != nil {
 					errs = addModule(module)
 				}
@@ -801,7 +1418,7 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 					errsCh <- errs
 				}
 
-			case *parser.Assignment:
+			case *parser.Assignment, *parser.Include:
 				// Already handled via Scope object
 			default:
 				panic("unknown definition type")
@@ -841,6 +1458,12 @@ loop:
 		}
 	}
 
+	if c.analysisCache != nil && len(errs) == 0 {
+		if err := c.analysisCache.write(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	return deps, errs
 }
 
@@ -865,6 +1488,9 @@ type FileHandler func(*parser.File)
 // visitor will be called asynchronously, and will only be called once visitor for each
 // ancestor directory has completed.
 //
+// If the context.Context embedded in the Context is canceled no further files are parsed, and an
+// error matching ErrCanceled is returned.
+//
 // WalkBlueprintsFiles will not return until all calls to visitor have returned.
 func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	visitor FileHandler) (deps []string, errs []error) {
@@ -954,6 +1580,8 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	// begin parsing any files that have no ancestors
 	startParseDescendants(fileParseContext{"", parser.NewScope(nil), nil, nil})
 
+	ctxDoneCh := c.Context.Done()
+
 loop:
 	for {
 		if len(errs) > maxErrors {
@@ -965,6 +1593,12 @@ loop:
 			errs = append(errs, newErrs...)
 		case dep := <-depsCh:
 			deps = append(deps, dep)
+		case <-ctxDoneCh:
+			// Stop parsing new files, but wait for the files already being parsed.
+			ctxDoneCh = nil
+			errs = append(errs, checkCanceled(c.Context))
+			tooManyErrors = true
+			pending = nil
 		case blueprint := <-blueprintsCh:
 			if tooManyErrors {
 				continue
@@ -998,6 +1632,8 @@ loop:
 // MockFileSystem causes the Context to replace all reads with accesses to the provided map of
 // filenames to contents stored as a byte slice.
 func (c *Context) MockFileSystem(files map[string][]byte) {
+	c.checkRegistration("MockFileSystem")
+
 	// look for a module list file
 	_, ok := files[MockModuleListFile]
 	if !ok {
@@ -1021,6 +1657,8 @@ func (c *Context) MockFileSystem(files map[string][]byte) {
 }
 
 func (c *Context) SetFs(fs pathtools.FileSystem) {
+	c.checkRegistration("SetFs")
+
 	c.fs = fs
 }
 
@@ -1038,7 +1676,10 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 			if isSymlink {
 				err = fmt.Errorf("could not open symlink %v : %v", filename, err)
 				target, readlinkErr := os.Readlink(filename)
-				if readlinkErr == nil {
+				_, evalErr := pathtools.EvalSymlinks(c.fs, filename)
+				if loopErr, ok := evalErr.(*pathtools.SymlinkLoopError); ok {
+					err = fmt.Errorf("could not open symlink %v: %s", filename, loopErr)
+				} else if readlinkErr == nil {
 					_, targetStatsErr := c.fs.Lstat(target)
 					if targetStatsErr != nil {
 						err = fmt.Errorf("could not open symlink %v; its target (%v) cannot be opened", filename, target)
@@ -1068,6 +1709,7 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 	for _, b := range subBlueprints {
 		deps = append(deps, b.fileName)
 	}
+	deps = append(deps, file.Includes...)
 
 	return file, subBlueprints, deps, nil
 }
@@ -1090,11 +1732,33 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	if err != nil {
 		return nil, nil, []error{err}
 	}
+	// Module APIs return paths derived from relBlueprintsFile, keep them the same on all platforms.
+	relBlueprintsFile = pathtools.NormalizePath(relBlueprintsFile)
 
 	scope.Remove("subdirs")
 	scope.Remove("optional_subdirs")
 	scope.Remove("build")
-	file, errs = parser.ParseAndEval(filename, reader, scope)
+	c.removeTopLevelVariables(scope)
+	scope.SetSelectEvaluator(c.selectEvaluator)
+	scope.SetIncludeResolver(includeResolver{c.fs})
+	parseStart := time.Now()
+	fileParser := c.fileParserFor(filename)
+	var contents []byte
+	if c.formatCheck != FormatCheckNone && fileParser == nil {
+		contents, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		reader = bytes.NewReader(contents)
+	}
+	if fileParser != nil {
+		file, errs = c.parseWithFileParser(fileParser, filename, reader, scope)
+	} else if c.analysisCache != nil {
+		file, errs = c.analysisCache.parseAndEval(relBlueprintsFile, filename, reader, scope, c.selectEvaluator)
+	} else {
+		file, errs = c.parseAndEvalShared(relBlueprintsFile, filename, reader, scope)
+	}
+	c.addBlueprintsFileTime(relBlueprintsFile, time.Since(parseStart), 0, 0)
 	if len(errs) > 0 {
 		for i, err := range errs {
 			if parseErr, ok := err.(*parser.ParseError); ok {
@@ -1110,33 +1774,49 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 		// result.
 		return nil, nil, errs
 	}
-	file.Name = relBlueprintsFile
-
-	build, buildPos, err := getLocalStringListFromScope(scope, "build")
-	if err != nil {
-		errs = append(errs, err)
+	// Files returned by parseAndEvalShared are already named, and may be shared with other
+	// goroutines that are copying them, so they must not be written to.
+	if file.Name != relBlueprintsFile {
+		file.Name = relBlueprintsFile
 	}
-	for _, buildEntry := range build {
-		if strings.Contains(buildEntry, "/") {
-			errs = append(errs, &BlueprintError{
-				Err: fmt.Errorf("illegal value %v. The '/' character is not permitted", buildEntry),
-				Pos: buildPos,
-			})
+
+	if c.formatCheck != FormatCheckNone && fileParser == nil {
+		for _, formatErr := range parser.CheckFormat(filename, contents) {
+			if parseErr, ok := formatErr.(*parser.ParseError); ok {
+				formatErr = &BlueprintError{
+					Err: parseErr.Err,
+					Pos: parseErr.Pos,
+				}
+			}
+			if c.formatCheck == FormatCheckWarning {
+				c.addWarning(WarningClassFormat, formatErr)
+			} else {
+				errs = append(errs, formatErr)
+			}
 		}
 	}
 
+	inclusions, inclusionErrs := fileInclusionsFromScope(scope)
+	errs = append(errs, inclusionErrs...)
+	c.recordFileInclusions(relBlueprintsFile, inclusions)
+	build, buildPos := inclusions.Build, inclusions.BuildPos
+
 	subBlueprintsName, _, err := getStringFromScope(scope, "subname")
 	if err != nil {
 		errs = append(errs, err)
 	}
 
+	errs = append(errs, c.recordTopLevelVariables(relBlueprintsFile, scope)...)
+
 	if subBlueprintsName == "" {
 		subBlueprintsName = "Blueprints"
 	}
 
 	var blueprints []string
 
+	globStart := time.Now()
 	newBlueprints, newErrs := c.findBuildBlueprints(filepath.Dir(filename), build, buildPos)
+	c.addBlueprintsFileTime(relBlueprintsFile, 0, 0, time.Since(globStart))
 	blueprints = append(blueprints, newBlueprints...)
 	errs = append(errs, newErrs...)
 
@@ -1240,23 +1920,23 @@ func getLocalStringListFromScope(scope *parser.Scope, v string) ([]string, scann
 			ret := make([]string, 0, len(value.Values))
 
 			for _, listValue := range value.Values {
-				s, ok := listValue.(*parser.String)
+				s, ok := listValue.Eval().(*parser.String)
 				if !ok {
-					// The parser should not produce this.
-					panic("non-string value found in list")
+					return nil, scanner.Position{}, &BlueprintError{
+						Err: fmt.Errorf("%q must be a list of strings", v),
+						Pos: assignment.EqualsPos,
+					}
 				}
 
 				ret = append(ret, s.Value)
 			}
 
 			return ret, assignment.EqualsPos, nil
-		case *parser.Bool, *parser.String:
+		default:
 			return nil, scanner.Position{}, &BlueprintError{
 				Err: fmt.Errorf("%q must be a list of strings", v),
 				Pos: assignment.EqualsPos,
 			}
-		default:
-			panic(fmt.Errorf("unknown value type: %d", assignment.Value.Type()))
 		}
 	}
 }
@@ -1299,7 +1979,7 @@ func (c *Context) cloneLogicModule(origModule *moduleInfo) (Module, []interface{
 	return newLogicModule, newProperties
 }
 
-func newVariant(module *moduleInfo, mutatorName string, variationName string,
+func (c *Context) newVariant(module *moduleInfo, mutatorName string, variationName string,
 	local bool) variant {
 
 	newVariantName := module.variant.name
@@ -1311,25 +1991,18 @@ func newVariant(module *moduleInfo, mutatorName string, variationName string,
 		}
 	}
 
-	newVariations := module.variant.variations.clone()
-	if newVariations == nil {
-		newVariations = make(variationMap)
-	}
-	newVariations[mutatorName] = variationName
+	newVariations := c.variationMaps.set(module.variant.variations, mutatorName, variationName)
 
-	newDependencyVariations := module.variant.dependencyVariations.clone()
+	newDependencyVariations := module.variant.dependencyVariations
 	if !local {
-		if newDependencyVariations == nil {
-			newDependencyVariations = make(variationMap)
-		}
-		newDependencyVariations[mutatorName] = variationName
+		newDependencyVariations = c.variationMaps.set(newDependencyVariations, mutatorName, variationName)
 	}
 
 	return variant{newVariantName, newVariations, newDependencyVariations}
 }
 
 func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
-	defaultVariationName *string, variationNames []string, local bool) (modulesOrAliases, []error) {
+	depChooser depChooser, variationNames []string, local bool) (modulesOrAliases, []error) {
 
 	if len(variationNames) == 0 {
 		panic(fmt.Errorf("mutator %q passed zero-length variation list for module %q",
@@ -1359,18 +2032,25 @@ func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
 		newModule.reverseDeps = nil
 		newModule.forwardDeps = nil
 		newModule.logicModule = newLogicModule
-		newModule.variant = newVariant(origModule, mutatorName, variationName, local)
+		newModule.variant = c.newVariant(origModule, mutatorName, variationName, local)
 		newModule.properties = newProperties
-		newModule.providers = append([]interface{}(nil), origModule.providers...)
+		newModule.providers = copyProviders(origModule.providers, i > 0)
+		if origModule.provenance != nil {
+			newModule.provenance = origModule.provenance.Clone()
+		}
 
 		newModules = append(newModules, newModule)
 
-		newErrs := c.convertDepsToVariation(newModule, mutatorName, variationName, defaultVariationName)
+		newErrs := c.convertDepsToVariation(newModule, variationName, depChooser)
 		if len(newErrs) > 0 {
 			errs = append(errs, newErrs...)
 		}
 	}
 
+	if c.detectSharedProviders && len(newModules) > 1 {
+		c.checkSharedProviders(origModule, mutatorName)
+	}
+
 	// Mark original variant as invalid.  Modules that depend on this module will still
 	// depend on origModule, but we'll fix it when the mutator is called on them.
 	origModule.logicModule = nil
@@ -1381,31 +2061,60 @@ func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
 	return newModules, errs
 }
 
-func (c *Context) convertDepsToVariation(module *moduleInfo,
-	mutatorName, variationName string, defaultVariationName *string) (errs []error) {
+// depChooser returns the variant of a dependency that a new variant of a module should depend on
+// after the dependency was split by the current mutator.  If no variant is suitable it returns nil
+// and the name of the variation it was looking for.
+type depChooser func(source *moduleInfo, variationName string, dep depInfo) (*moduleInfo, string)
 
-	for i, dep := range module.directDeps {
-		if dep.module.logicModule == nil {
-			var newDep *moduleInfo
-			for _, m := range dep.module.splitModules {
-				if m.moduleOrAliasVariant().variations[mutatorName] == variationName {
-					newDep = m.moduleOrAliasTarget()
-					break
-				}
-			}
-			if newDep == nil && defaultVariationName != nil {
-				// give it a second chance; match with defaultVariationName
-				for _, m := range dep.module.splitModules {
-					if m.moduleOrAliasVariant().variations[mutatorName] == *defaultVariationName {
-						newDep = m.moduleOrAliasTarget()
-						break
-					}
-				}
+// chooseDep returns the candidate whose variation for the given mutator is variationName, or
+// failing that defaultVariationName if it is not nil.
+func chooseDep(candidates modulesOrAliases, mutatorName, variationName string,
+	defaultVariationName *string) (*moduleInfo, string) {
+
+	for _, m := range candidates {
+		if m.moduleOrAliasVariant().variations.get(mutatorName) == variationName {
+			return m.moduleOrAliasTarget(), ""
+		}
+	}
+
+	if defaultVariationName != nil {
+		// give it a second chance; match with defaultVariationName
+		for _, m := range candidates {
+			if m.moduleOrAliasVariant().variations.get(mutatorName) == *defaultVariationName {
+				return m.moduleOrAliasTarget(), ""
 			}
+		}
+	}
+
+	return nil, variationName
+}
+
+// chooseDepInherit returns a depChooser that picks the variant of the dependency with the same
+// variation as the depending module, which is how CreateVariations propagates variations.
+func chooseDepInherit(mutatorName string, defaultVariationName *string) depChooser {
+	return func(source *moduleInfo, variationName string, dep depInfo) (*moduleInfo, string) {
+		return chooseDep(dep.module.splitModules, mutatorName, variationName, defaultVariationName)
+	}
+}
+
+// chooseDepExplicit returns a depChooser that always picks the given variation of the
+// dependency, regardless of the variation of the depending module.
+func chooseDepExplicit(mutatorName, variationName string, defaultVariationName *string) depChooser {
+	return func(source *moduleInfo, _ string, dep depInfo) (*moduleInfo, string) {
+		return chooseDep(dep.module.splitModules, mutatorName, variationName, defaultVariationName)
+	}
+}
+
+func (c *Context) convertDepsToVariation(module *moduleInfo,
+	variationName string, depChooser depChooser) (errs []error) {
+
+	for i, dep := range module.directDeps {
+		if dep.module.logicModule == nil {
+			newDep, missingVariation := depChooser(module, variationName, dep)
 			if newDep == nil {
 				errs = append(errs, &BlueprintError{
-					Err: fmt.Errorf("failed to find variation %q for module %q needed by %q",
-						variationName, dep.module.Name(), module.Name()),
+					Err: errorWithKind(ErrMissingVariant, fmt.Errorf("failed to find variation %q for module %q needed by %q",
+						missingVariation, dep.module.Name(), module.Name())),
 					Pos: module.pos,
 				})
 				continue
@@ -1418,9 +2127,9 @@ func (c *Context) convertDepsToVariation(module *moduleInfo,
 }
 
 func (c *Context) prettyPrintVariant(variations variationMap) string {
-	names := make([]string, 0, len(variations))
+	names := make([]string, 0, variations.len())
 	for _, m := range c.variantMutatorNames {
-		if v, ok := variations[m]; ok {
+		if v, ok := variations.lookup(m); ok {
 			names = append(names, m+":"+v)
 		}
 	}
@@ -1455,7 +2164,9 @@ func newModule(factory ModuleFactory) *moduleInfo {
 }
 
 func processModuleDef(moduleDef *parser.Module,
-	relBlueprintsFile string, moduleFactories, scopedModuleFactories map[string]ModuleFactory, ignoreUnknownModuleTypes bool) (*moduleInfo, []error) {
+	relBlueprintsFile string, moduleFactories, scopedModuleFactories map[string]ModuleFactory,
+	tagProcessors map[string]PropertyTagProcessor, expander VariableExpander,
+	ignoreUnknownModuleTypes bool) (*moduleInfo, []error) {
 
 	factory, ok := moduleFactories[moduleDef.Type]
 	if !ok && scopedModuleFactories != nil {
@@ -1468,7 +2179,7 @@ func processModuleDef(moduleDef *parser.Module,
 
 		return nil, []error{
 			&BlueprintError{
-				Err: fmt.Errorf("unrecognized module type %q", moduleDef.Type),
+				Err: errorWithKind(ErrUnknownModuleType, fmt.Errorf("unrecognized module type %q", moduleDef.Type)),
 				Pos: moduleDef.TypePos,
 			},
 		}
@@ -1479,7 +2190,22 @@ func processModuleDef(moduleDef *parser.Module,
 
 	module.relBlueprintsFile = relBlueprintsFile
 
-	propertyMap, errs := proptools.UnpackProperties(moduleDef.Properties, module.properties...)
+	var tagHandlers map[string]proptools.PropertyTagHandler
+	if len(tagProcessors) > 0 {
+		tagHandlers = make(map[string]proptools.PropertyTagHandler, len(tagProcessors))
+		for key, processor := range tagProcessors {
+			processor := processor
+			tagHandlers[key] = func(propertyName string, field reflect.StructField, tagValue string,
+				fieldValue reflect.Value) error {
+				ctx := &propertyTagContext{module: module, propertyName: propertyName}
+				return processor(ctx, field, tagValue, fieldValue)
+			}
+		}
+	}
+
+	// Required properties are checked by checkRequiredProperties after defaults have been applied.
+	propertyMap, errs := proptools.UnpackPropertiesIgnoringRequired(moduleDef.Properties, tagHandlers,
+		module.properties...)
 	if len(errs) > 0 {
 		for i, err := range errs {
 			if unpackErr, ok := err.(*proptools.UnpackError); ok {
@@ -1488,11 +2214,32 @@ func processModuleDef(moduleDef *parser.Module,
 					Pos: unpackErr.Pos,
 				}
 				errs[i] = err
+			} else if constraintErr, ok := err.(*proptools.PropertyConstraintError); ok {
+				pos := constraintErr.Pos
+				if !pos.IsValid() {
+					pos = moduleDef.TypePos
+				}
+				errs[i] = &PropertyError{
+					ModuleError: ModuleError{
+						BlueprintError: BlueprintError{
+							Err: constraintErr.Err,
+							Pos: pos,
+						},
+						module: module,
+					},
+					property: constraintErr.Property,
+				}
 			}
 		}
 		return nil, errs
 	}
 
+	if expander != nil {
+		if errs := expandModuleVariables(module, propertyMap, expander); len(errs) > 0 {
+			return nil, errs
+		}
+	}
+
 	module.pos = moduleDef.TypePos
 	module.propertyPos = make(map[string]scanner.Position)
 	for name, propertyDef := range propertyMap {
@@ -1541,11 +2288,28 @@ func (c *Context) addModule(module *moduleInfo) []error {
 // the modules depended upon are defined and that no circular dependencies
 // exist.
 func (c *Context) ResolveDependencies(config interface{}) (deps []string, errs []error) {
+	if err := c.startPhase("ResolveDependencies"); err != nil {
+		return nil, []error{err}
+	}
+	defer c.endPhaseWithWarnings(&errs)
+
 	return c.resolveDependencies(c.Context, config)
 }
 
 func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (deps []string, errs []error) {
-	pprof.Do(ctx, pprof.Labels("blueprint", "ResolveDependencies"), func(ctx context.Context) {
+	start := c.metrics.begin()
+	c.metrics.snapshotMemory()
+	defer func() {
+		c.metrics.snapshotMemory()
+		c.metrics.end(metricsPhase, "ResolveDependencies", start, nil)
+	}()
+
+	profileRegion(ctx, "blueprint", "ResolveDependencies", func(ctx context.Context) {
+		errs = c.sortMutators()
+		if len(errs) > 0 {
+			return
+		}
+
 		c.initProviders()
 
 		c.liveGlobals = newLiveTracker(config)
@@ -1560,6 +2324,33 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 			return
 		}
 
+		errs = c.applyDefaults()
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.applyPropertyOverrides()
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.checkRequiredProperties()
+		if len(errs) > 0 {
+			return
+		}
+
+		c.checkDeprecatedProperties()
+
+		errs = c.checkVisibilityRules()
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.findPrebuiltsWithoutSource()
+		if len(errs) > 0 {
+			return
+		}
+
 		var mutatorDeps []string
 		mutatorDeps, errs = c.runMutators(ctx, config)
 		if len(errs) > 0 {
@@ -1567,10 +2358,39 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 		}
 		deps = append(deps, mutatorDeps...)
 
+		errs = c.applyOverrides(config)
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.applyPrebuilts(config)
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.handleDisabledModules()
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.checkIncomingDependencies()
+		if len(errs) > 0 {
+			return
+		}
+
 		if !c.skipCloneModulesAfterMutators {
 			c.cloneModules()
 		}
 
+		var postMutatorDeps []string
+		postMutatorDeps, errs = c.runPostMutators(config)
+		if len(errs) > 0 {
+			return
+		}
+		deps = append(deps, postMutatorDeps...)
+
+		c.pruneUnreachableVariants()
+
 		c.dependenciesReady = true
 	})
 
@@ -1581,6 +2401,133 @@ func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (
 	return deps, nil
 }
 
+// DisabledDependencyBehavior controls how dependencies from enabled modules onto disabled modules
+// are handled.
+type DisabledDependencyBehavior int
+
+const (
+	// DisabledDependencyError reports an error for every dependency from an enabled module onto a
+	// disabled module.  This is the default.
+	DisabledDependencyError DisabledDependencyBehavior = iota
+
+	// DisabledDependencyPrune silently removes dependencies onto disabled modules.
+	DisabledDependencyPrune
+)
+
+// SetDisabledDependencyBehavior sets how dependencies from enabled modules onto disabled modules
+// are handled once all mutators have run.
+func (c *Context) SetDisabledDependencyBehavior(behavior DisabledDependencyBehavior) {
+	c.disabledDependencyBehavior = behavior
+}
+
+// ModuleEnabled returns false if the module was disabled by a mutator or its BlueprintEnabled
+// method.  It panics if the module was not created by this Context.
+func (c *Context) ModuleEnabled(logicModule Module) bool {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		panic(fmt.Sprintf("Can't check if %T is enabled, it is not a module of this Context",
+			logicModule))
+	}
+	return !module.disabled
+}
+
+// handleDisabledModules finalizes the disabled state of every module after all mutators have run,
+// and then either reports or prunes dependencies from enabled modules onto disabled modules.
+func (c *Context) handleDisabledModules() (errs []error) {
+	anyDisabled := false
+	for _, module := range c.modulesSorted {
+		if d, ok := module.logicModule.(DisableableModule); ok && !d.BlueprintEnabled() {
+			module.disabled = true
+		}
+		anyDisabled = anyDisabled || module.disabled
+	}
+
+	if !anyDisabled {
+		return nil
+	}
+
+	pruned := false
+	for _, module := range c.modulesSorted {
+		if module.disabled {
+			continue
+		}
+
+		directDeps := module.directDeps[:0]
+		for _, dep := range module.directDeps {
+			if !dep.module.disabled {
+				directDeps = append(directDeps, dep)
+				continue
+			}
+
+			switch c.disabledDependencyBehavior {
+			case DisabledDependencyPrune:
+				pruned = true
+			default:
+				directDeps = append(directDeps, dep)
+				errs = append(errs, &ModuleError{
+					BlueprintError: BlueprintError{
+						Err: errorWithKind(ErrDisabledDependency, fmt.Errorf("depends on disabled module %q", dep.module.Name())),
+						Pos: module.pos,
+					},
+					module: module,
+				})
+				if len(errs) > maxErrors {
+					return errs
+				}
+			}
+		}
+		module.directDeps = directDeps
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if pruned {
+		return c.updateDependencies()
+	}
+
+	return nil
+}
+
+// checkIncomingDependencies calls CheckIncomingDependency on every module that implements
+// IncomingDependencyCheckerModule for each direct dependency on it, and reports any rejected
+// dependencies as errors in the depending module.
+func (c *Context) checkIncomingDependencies() (errs []error) {
+	for _, module := range c.modulesSorted {
+		for _, dep := range module.directDeps {
+			checker, ok := dep.module.logicModule.(IncomingDependencyCheckerModule)
+			if !ok {
+				continue
+			}
+
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						in := fmt.Sprintf("CheckIncomingDependency for %s from %s", dep.module, module)
+						errs = append(errs, newPanicErrorf(r, in))
+					}
+				}()
+				if err := checker.CheckIncomingDependency(dep.tag, module.logicModule); err != nil {
+					errs = append(errs, &ModuleError{
+						BlueprintError: BlueprintError{
+							Err: fmt.Errorf("dependency on %s rejected: %s", dep.module, err),
+							Pos: module.pos,
+						},
+						module: module,
+					})
+				}
+			}()
+
+			if len(errs) > maxErrors {
+				return errs
+			}
+		}
+	}
+
+	return errs
+}
+
 // Default dependencies handling.  If the module implements the (deprecated)
 // DynamicDependerModule interface then this set consists of the union of those
 // module names returned by its DynamicDependencies method and those added by calling
@@ -1607,8 +2554,8 @@ func blueprintDepsMutator(ctx BottomUpMutatorContext) {
 // findExactVariantOrSingle searches the moduleGroup for a module with the same variant as module,
 // and returns the matching module, or nil if one is not found.  A group with exactly one module
 // is always considered matching.
-func findExactVariantOrSingle(module *moduleInfo, possible *moduleGroup, reverse bool) *moduleInfo {
-	found, _ := findVariant(module, possible, nil, false, reverse)
+func (c *Context) findExactVariantOrSingle(module *moduleInfo, possible *moduleGroup, reverse bool) *moduleInfo {
+	found, _ := c.findVariant(module, possible, nil, false, reverse)
 	if found == nil {
 		for _, moduleOrAlias := range possible.modules {
 			if m := moduleOrAlias.module(); m != nil {
@@ -1630,18 +2577,21 @@ func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName s
 
 	if depName == module.Name() {
 		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("%q depends on itself", depName),
+			Err: errorWithKind(ErrDependencyCycle, fmt.Errorf("%q depends on itself", depName)),
 			Pos: module.pos,
 		}}
 	}
 
-	possibleDeps := c.moduleGroupFromName(depName, module.namespace())
+	possibleDeps := c.dependencyGroupFromName(depName, module.namespace())
 	if possibleDeps == nil {
-		return nil, c.discoveredMissingDependencies(module, depName, nil)
+		return nil, c.discoveredMissingDependencies(module, depName, variationMap{})
 	}
 
-	if m := findExactVariantOrSingle(module, possibleDeps, false); m != nil {
-		module.newDirectDeps = append(module.newDirectDeps, depInfo{m, tag})
+	if m := c.findExactVariantOrSingle(module, possibleDeps, false); m != nil {
+		if err := c.checkVisibility(module, m); err != nil {
+			return nil, []error{err}
+		}
+		module.newDirectDeps = append(module.newDirectDeps, depInfo{m, tag, c.startedMutator})
 		atomic.AddUint32(&c.depsModified, 1)
 		return m, nil
 	}
@@ -1652,18 +2602,37 @@ func (c *Context) addDependency(module *moduleInfo, tag DependencyTag, depName s
 	}
 
 	return nil, []error{&BlueprintError{
-		Err: fmt.Errorf("dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
+		Err: errorWithKind(ErrMissingVariant, fmt.Errorf("dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
 			depName, module.Name(),
 			c.prettyPrintVariant(module.variant.dependencyVariations),
-			c.prettyPrintGroupVariants(possibleDeps)),
+			c.prettyPrintGroupVariants(possibleDeps))),
 		Pos: module.pos,
 	}}
 }
 
+// addOptionalDependency calls addDep to add a dependency on depName if a module with that name
+// exists, otherwise it records depName as a missing optional dependency of module.
+func (c *Context) addOptionalDependency(module *moduleInfo, depName string,
+	addDep func() (*moduleInfo, []error)) (*moduleInfo, []error) {
+
+	if c.dependencyGroupFromName(depName, module.namespace()) == nil {
+		for _, missing := range module.missingOptionalDeps {
+			if missing == depName {
+				return nil, nil
+			}
+		}
+		// Variants created by splitting the module share the slice, never append in place.
+		missing := module.missingOptionalDeps
+		module.missingOptionalDeps = append(missing[:len(missing):len(missing)], depName)
+		return nil, nil
+	}
+	return addDep()
+}
+
 func (c *Context) findReverseDependency(module *moduleInfo, destName string) (*moduleInfo, []error) {
 	if destName == module.Name() {
 		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("%q depends on itself", destName),
+			Err: errorWithKind(ErrDependencyCycle, fmt.Errorf("%q depends on itself", destName)),
 			Pos: module.pos,
 		}}
 	}
@@ -1671,13 +2640,16 @@ func (c *Context) findReverseDependency(module *moduleInfo, destName string) (*m
 	possibleDeps := c.moduleGroupFromName(destName, module.namespace())
 	if possibleDeps == nil {
 		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("%q has a reverse dependency on undefined module %q",
-				module.Name(), destName),
+			Err: errorWithKind(ErrMissingDependency, fmt.Errorf("%q has a reverse dependency on undefined module %q",
+				module.Name(), destName)),
 			Pos: module.pos,
 		}}
 	}
 
-	if m := findExactVariantOrSingle(module, possibleDeps, true); m != nil {
+	if m := c.findExactVariantOrSingle(module, possibleDeps, true); m != nil {
+		if err := c.checkVisibility(m, module); err != nil {
+			return nil, []error{err}
+		}
 		return m, nil
 	}
 
@@ -1687,15 +2659,15 @@ func (c *Context) findReverseDependency(module *moduleInfo, destName string) (*m
 	}
 
 	return nil, []error{&BlueprintError{
-		Err: fmt.Errorf("reverse dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
+		Err: errorWithKind(ErrMissingVariant, fmt.Errorf("reverse dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
 			destName, module.Name(),
 			c.prettyPrintVariant(module.variant.dependencyVariations),
-			c.prettyPrintGroupVariants(possibleDeps)),
+			c.prettyPrintGroupVariants(possibleDeps))),
 		Pos: module.pos,
 	}}
 }
 
-func findVariant(module *moduleInfo, possibleDeps *moduleGroup, variations []Variation, far bool, reverse bool) (*moduleInfo, variationMap) {
+func (c *Context) findVariant(module *moduleInfo, possibleDeps *moduleGroup, variations []Variation, far bool, reverse bool) (*moduleInfo, variationMap) {
 	// We can't just append variant.Variant to module.dependencyVariant.variantName and
 	// compare the strings because the result won't be in mutator registration order.
 	// Create a new map instead, and then deep compare the maps.
@@ -1704,17 +2676,14 @@ func findVariant(module *moduleInfo, possibleDeps *moduleGroup, variations []Var
 		if !reverse {
 			// For forward dependency, ignore local variants by matching against
 			// dependencyVariant which doesn't have the local variants
-			newVariant = module.variant.dependencyVariations.clone()
+			newVariant = module.variant.dependencyVariations
 		} else {
 			// For reverse dependency, use all the variants
-			newVariant = module.variant.variations.clone()
+			newVariant = module.variant.variations
 		}
 	}
 	for _, v := range variations {
-		if newVariant == nil {
-			newVariant = make(variationMap)
-		}
-		newVariant[v.Mutator] = v.Variation
+		newVariant = c.variationMaps.set(newVariant, v.Mutator, v.Variation)
 	}
 
 	check := func(variant variationMap) bool {
@@ -1742,12 +2711,12 @@ func (c *Context) addVariationDependency(module *moduleInfo, variations []Variat
 		panic("BaseDependencyTag is not allowed to be used directly!")
 	}
 
-	possibleDeps := c.moduleGroupFromName(depName, module.namespace())
+	possibleDeps := c.dependencyGroupFromName(depName, module.namespace())
 	if possibleDeps == nil {
-		return nil, c.discoveredMissingDependencies(module, depName, nil)
+		return nil, c.discoveredMissingDependencies(module, depName, variationMap{})
 	}
 
-	foundDep, newVariant := findVariant(module, possibleDeps, variations, far, false)
+	foundDep, newVariant := c.findVariant(module, possibleDeps, variations, far, false)
 
 	if foundDep == nil {
 		if c.allowMissingDependencies {
@@ -1755,17 +2724,17 @@ func (c *Context) addVariationDependency(module *moduleInfo, variations []Variat
 			return nil, c.discoveredMissingDependencies(module, depName, newVariant)
 		}
 		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
+			Err: errorWithKind(ErrMissingVariant, fmt.Errorf("dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
 				depName, module.Name(),
 				c.prettyPrintVariant(newVariant),
-				c.prettyPrintGroupVariants(possibleDeps)),
+				c.prettyPrintGroupVariants(possibleDeps))),
 			Pos: module.pos,
 		}}
 	}
 
 	if module == foundDep {
 		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("%q depends on itself", depName),
+			Err: errorWithKind(ErrDependencyCycle, fmt.Errorf("%q depends on itself", depName)),
 			Pos: module.pos,
 		}}
 	}
@@ -1774,11 +2743,14 @@ func (c *Context) addVariationDependency(module *moduleInfo, variations []Variat
 	// run GenerateBuildActions in order for the variants of a module
 	if foundDep.group == module.group && beforeInModuleList(module, foundDep, module.group.modules) {
 		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("%q depends on later version of itself", depName),
+			Err: errorWithKind(ErrDependencyCycle, fmt.Errorf("%q depends on later version of itself", depName)),
 			Pos: module.pos,
 		}}
 	}
-	module.newDirectDeps = append(module.newDirectDeps, depInfo{foundDep, tag})
+	if err := c.checkVisibility(module, foundDep); err != nil {
+		return nil, []error{err}
+	}
+	module.newDirectDeps = append(module.newDirectDeps, depInfo{foundDep, tag, c.startedMutator})
 	atomic.AddUint32(&c.depsModified, 1)
 	return foundDep, nil
 }
@@ -1809,7 +2781,7 @@ func (c *Context) addInterVariantDependency(origModule *moduleInfo, tag Dependen
 			origModule.Name()))
 	}
 
-	fromInfo.newDirectDeps = append(fromInfo.newDirectDeps, depInfo{toInfo, tag})
+	fromInfo.newDirectDeps = append(fromInfo.newDirectDeps, depInfo{toInfo, tag, c.startedMutator})
 	atomic.AddUint32(&c.depsModified, 1)
 	return toInfo
 }
@@ -1935,23 +2907,49 @@ type unpause chan struct{}
 
 const parallelVisitLimit = 1000
 
+// visitPool limits the number of visitors of a set of modules that run at the same time.
+type visitPool struct {
+	limit  int
+	active int // Number of visitors running, not counting paused visitors.
+
+	backlog        []*moduleInfo // Visitors that are ready to start but backlogged due to limit.
+	unpauseBacklog []pauseSpec   // Visitors that are ready to unpause but backlogged due to limit.
+}
+
 // Calls visit on each module, guaranteeing that visit is not called on a module until visit on all
 // of its dependencies has finished.  A visit function can write a pauseSpec to the pause channel
 // to wait for another dependency to be visited.  If a visit function returns true to cancel
 // while another visitor is paused, the paused visitor will never be resumed and its goroutine
-// will stay paused forever.
-func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
+// will stay paused forever.  If ctx is canceled no further visitors are started, and once the
+// running visitors have finished an error matching ErrCanceled is returned.
+func parallelVisit(ctx context.Context, modules []*moduleInfo, order visitOrderer, limit int,
 	visit func(module *moduleInfo, pause chan<- pauseSpec) bool) []error {
+	return parallelVisitWithHeavyLimit(ctx, modules, order, limit, 0, visit)
+}
+
+// parallelVisitWithHeavyLimit is parallelVisit, except that if heavyLimit is positive the
+// visitors of modules marked as heavy run in a separate pool of at most heavyLimit visitors
+// instead of counting towards limit.
+func parallelVisitWithHeavyLimit(ctx context.Context, modules []*moduleInfo, order visitOrderer,
+	limit, heavyLimit int, visit func(module *moduleInfo, pause chan<- pauseSpec) bool) []error {
 
 	doneCh := make(chan *moduleInfo)
 	cancelCh := make(chan bool)
 	pauseCh := make(chan pauseSpec)
+	ctxDoneCh := ctx.Done()
 	cancel := false
+	var canceledErr error
 
-	var backlog []*moduleInfo      // Visitors that are ready to start but backlogged due to limit.
-	var unpauseBacklog []pauseSpec // Visitors that are ready to unpause but backlogged due to limit.
+	mainPool := &visitPool{limit: limit}
+	heavyPool := &visitPool{limit: heavyLimit}
+	pools := []*visitPool{mainPool, heavyPool}
+	poolFor := func(module *moduleInfo) *visitPool {
+		if module.heavy && heavyLimit > 0 {
+			return heavyPool
+		}
+		return mainPool
+	}
 
-	active := 0  // Number of visitors running, not counting paused visitors.
 	visited := 0 // Number of finished visitors.
 
 	pauseMap := make(map[*moduleInfo][]pauseSpec)
@@ -1963,8 +2961,9 @@ func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
 	// Call the visitor on a module if there are fewer active visitors than the parallelism
 	// limit, otherwise add it to the backlog.
 	startOrBacklog := func(module *moduleInfo) {
-		if active < limit {
-			active++
+		pool := poolFor(module)
+		if pool.active < pool.limit {
+			pool.active++
 			go func() {
 				ret := visit(module, pauseCh)
 				if ret {
@@ -1973,38 +2972,45 @@ func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
 				doneCh <- module
 			}()
 		} else {
-			backlog = append(backlog, module)
+			pool.backlog = append(pool.backlog, module)
 		}
 	}
 
 	// Unpause the already-started but paused  visitor on a module if there are fewer active
 	// visitors than the parallelism limit, otherwise add it to the backlog.
 	unpauseOrBacklog := func(pauseSpec pauseSpec) {
-		if active < limit {
-			active++
+		pool := poolFor(pauseSpec.paused)
+		if pool.active < pool.limit {
+			pool.active++
 			close(pauseSpec.unpause)
 		} else {
-			unpauseBacklog = append(unpauseBacklog, pauseSpec)
+			pool.unpauseBacklog = append(pool.unpauseBacklog, pauseSpec)
 		}
 	}
 
 	// Start any modules in the backlog up to the parallelism limit.  Unpause paused modules first
 	// since they may already be holding resources.
 	unpauseOrStartFromBacklog := func() {
-		for active < limit && len(unpauseBacklog) > 0 {
-			unpause := unpauseBacklog[0]
-			unpauseBacklog = unpauseBacklog[1:]
-			unpauseOrBacklog(unpause)
-		}
-		for active < limit && len(backlog) > 0 {
-			toVisit := backlog[0]
-			backlog = backlog[1:]
-			startOrBacklog(toVisit)
+		for _, pool := range pools {
+			for pool.active < pool.limit && len(pool.unpauseBacklog) > 0 {
+				unpause := pool.unpauseBacklog[0]
+				pool.unpauseBacklog = pool.unpauseBacklog[1:]
+				unpauseOrBacklog(unpause)
+			}
+			for pool.active < pool.limit && len(pool.backlog) > 0 {
+				toVisit := pool.backlog[0]
+				pool.backlog = pool.backlog[1:]
+				startOrBacklog(toVisit)
+			}
 		}
 	}
 
 	toVisit := len(modules)
 
+	if err := checkCanceled(ctx); err != nil {
+		return []error{err}
+	}
+
 	// Start or backlog any modules that are not waiting for any other modules.
 	for _, module := range modules {
 		if module.waitingCount == 0 {
@@ -2012,13 +3018,21 @@ func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
 		}
 	}
 
-	for active > 0 {
+	for mainPool.active+heavyPool.active > 0 {
 		select {
 		case <-cancelCh:
 			cancel = true
-			backlog = nil
+			mainPool.backlog = nil
+			heavyPool.backlog = nil
+		case <-ctxDoneCh:
+			// Stop selecting on the closed channel, the running visitors still need to finish.
+			ctxDoneCh = nil
+			canceledErr = checkCanceled(ctx)
+			cancel = true
+			mainPool.backlog = nil
+			heavyPool.backlog = nil
 		case doneModule := <-doneCh:
-			active--
+			poolFor(doneModule).active--
 			if !cancel {
 				// Mark this module as done.
 				doneModule.waitingCount = -1
@@ -2055,77 +3069,83 @@ func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
 
 				// Don't count paused visitors as active so that this can't deadlock
 				// if 1000 visitors are paused simultaneously.
-				active--
+				poolFor(pauseSpec.paused).active--
 				unpauseOrStartFromBacklog()
 			}
 		}
 	}
 
+	if canceledErr != nil {
+		return []error{canceledErr}
+	}
+
 	if !cancel {
-		// Invariant check: no backlogged modules, these weren't waiting on anything except
-		// the parallelism limit so they should have run.
-		if len(backlog) > 0 {
-			panic(fmt.Errorf("parallelVisit finished with %d backlogged visitors", len(backlog)))
-		}
+		for _, pool := range pools {
+			// Invariant check: no backlogged modules, these weren't waiting on anything except
+			// the parallelism limit so they should have run.
+			if len(pool.backlog) > 0 {
+				panic(fmt.Errorf("parallelVisit finished with %d backlogged visitors", len(pool.backlog)))
+			}
 
-		// Invariant check: no backlogged paused modules, these weren't waiting on anything
-		// except the parallelism limit so they should have run.
-		if len(unpauseBacklog) > 0 {
-			panic(fmt.Errorf("parallelVisit finished with %d backlogged unpaused visitors", len(unpauseBacklog)))
+			// Invariant check: no backlogged paused modules, these weren't waiting on anything
+			// except the parallelism limit so they should have run.
+			if len(pool.unpauseBacklog) > 0 {
+				panic(fmt.Errorf("parallelVisit finished with %d backlogged unpaused visitors", len(pool.unpauseBacklog)))
+			}
 		}
 
 		if len(pauseMap) > 0 {
-			// Probably a deadlock due to a newly added dependency cycle. Start from each module in
-			// the order of the input modules list and perform a depth-first search for the module
-			// it is paused on, ignoring modules that are marked as done.  Note this traverses from
-			// modules to the modules that would have been unblocked when that module finished, i.e
-			// the reverse of the visitOrderer.
-
-			// In order to reduce duplicated work, once a module has been checked and determined
-			// not to be part of a cycle add it and everything that depends on it to the checked
-			// map.
-			checked := make(map[*moduleInfo]struct{})
-
-			var check func(module, end *moduleInfo) []*moduleInfo
-			check = func(module, end *moduleInfo) []*moduleInfo {
-				if module.waitingCount == -1 {
-					// This module was finished, it can't be part of a loop.
-					return nil
-				}
-				if module == end {
-					// This module is the end of the loop, start rolling up the cycle.
-					return []*moduleInfo{module}
-				}
-
-				if _, alreadyChecked := checked[module]; alreadyChecked {
-					return nil
+			// Probably a deadlock due to a newly added dependency cycle.  Find the elementary cycles
+			// among the modules that didn't finish, following the edges from each module to the
+			// modules that would have been unblocked when that module finished, i.e the reverse of
+			// the visitOrderer, and the modules paused on it.
+			var unfinished []*moduleInfo
+			for _, module := range modules {
+				if module.waitingCount != -1 {
+					unfinished = append(unfinished, module)
 				}
+			}
 
-				for _, dep := range order.propagate(module) {
-					cycle := check(dep, end)
-					if cycle != nil {
-						return append([]*moduleInfo{module}, cycle...)
-					}
-				}
-				for _, depPauseSpec := range pauseMap[module] {
-					cycle := check(depPauseSpec.paused, end)
-					if cycle != nil {
-						return append([]*moduleInfo{module}, cycle...)
+			edges := func(module *moduleInfo) []*moduleInfo {
+				ret := append([]*moduleInfo(nil), order.propagate(module)...)
+			outer:
+				for _, pauseSpec := range pauseMap[module] {
+					for _, existing := range ret {
+						if pauseSpec.paused == existing {
+							continue outer
+						}
 					}
+					ret = append(ret, pauseSpec.paused)
 				}
+				return ret
+			}
 
-				checked[module] = struct{}{}
-				return nil
+			index := make(map[*moduleInfo]int, len(modules))
+			for i, module := range modules {
+				index[module] = i
 			}
 
-			// Iterate over the modules list instead of pauseMap to provide deterministic ordering.
-			for _, module := range modules {
-				for _, pauseSpec := range pauseMap[module] {
-					cycle := check(pauseSpec.paused, pauseSpec.until)
-					if len(cycle) > 0 {
-						return cycleError(cycle)
+			var errs []error
+			for _, cycle := range elementaryCycles(unfinished, edges, maxReportedCycles) {
+				// Start the cycle at the module paused on the earliest module in the modules list
+				// that has paused modules in the cycle, to provide deterministic ordering.
+				until := -1
+				for i, module := range cycle {
+					next := cycle[(i+1)%len(cycle)]
+					for _, pauseSpec := range pauseMap[module] {
+						if pauseSpec.paused == next && (until == -1 || index[module] < index[cycle[until]]) {
+							until = i
+						}
 					}
 				}
+				if until != -1 {
+					start := (until + 1) % len(cycle)
+					cycle = append(append([]*moduleInfo(nil), cycle[start:]...), cycle[:start]...)
+				}
+				errs = append(errs, cycleError(cycle)...)
+			}
+			if len(errs) > 0 {
+				return errs
 			}
 		}
 
@@ -2148,8 +3168,9 @@ func parallelVisit(modules []*moduleInfo, order visitOrderer, limit int,
 func cycleError(cycle []*moduleInfo) (errs []error) {
 	// The cycle list is in reverse order because all the 'check' calls append
 	// their own module to the list.
+	dependencyCycle := newDependencyCycle(cycle)
 	errs = append(errs, &BlueprintError{
-		Err: fmt.Errorf("encountered dependency cycle:"),
+		Err: errorWithKind(ErrDependencyCycle, dependencyCycle),
 		Pos: cycle[len(cycle)-1].pos,
 	})
 
@@ -2158,8 +3179,8 @@ func cycleError(cycle []*moduleInfo) (errs []error) {
 	for i := len(cycle) - 1; i >= 0; i-- {
 		nextModule := cycle[i]
 		errs = append(errs, &BlueprintError{
-			Err: fmt.Errorf("    %s depends on %s",
-				curModule, nextModule),
+			Err: fmt.Errorf("    %s depends on %s%s",
+				curModule, nextModule, dependencyCycle.Edges[len(cycle)-1-i].annotation()),
 			Pos: curModule.pos,
 		})
 		curModule = nextModule
@@ -2285,7 +3306,7 @@ type jsonModule struct {
 }
 
 func toJsonVariationMap(vm variationMap) jsonVariationMap {
-	return jsonVariationMap(vm)
+	return jsonVariationMap(vm.toMap())
 }
 
 func jsonModuleNameFromModuleInfo(m *moduleInfo) *jsonModuleName {
@@ -2305,9 +3326,12 @@ func jsonModuleFromModuleInfo(m *moduleInfo) *jsonModule {
 	}
 }
 
-func (c *Context) PrintJSONGraph(w io.Writer) {
-	modules := make([]*jsonModule, 0)
-	for _, m := range c.modulesSorted {
+// PrintJSONGraph writes every module variant and its direct dependencies to w as a single JSON
+// array.  See WriteJSONGraph for a filtered and versioned format.
+func (c *Context) PrintJSONGraph(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString("[")
+	for i, m := range c.modulesSorted {
 		jm := jsonModuleFromModuleInfo(m)
 		for _, d := range m.directDeps {
 			jm.Deps = append(jm.Deps, jsonDep{
@@ -2316,10 +3340,18 @@ func (c *Context) PrintJSONGraph(w io.Writer) {
 			})
 		}
 
-		modules = append(modules, jm)
+		data, err := json.Marshal(jm)
+		if err != nil {
+			return fmt.Errorf("failed to encode module %s: %w", m, err)
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(data)
 	}
+	buf.WriteString("]\n")
 
-	json.NewEncoder(w).Encode(modules)
+	return buf.Flush()
 }
 
 // PrepareBuildActions generates an internal representation of all the build
@@ -2342,7 +3374,19 @@ func (c *Context) PrintJSONGraph(w io.Writer) {
 // methods.
 
 func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs []error) {
-	pprof.Do(c.Context, pprof.Labels("blueprint", "PrepareBuildActions"), func(ctx context.Context) {
+	if err := c.startPhase("PrepareBuildActions"); err != nil {
+		return nil, []error{err}
+	}
+	defer c.endPhaseWithWarnings(&errs)
+
+	start := c.metrics.begin()
+	c.metrics.snapshotMemory()
+	defer func() {
+		c.metrics.snapshotMemory()
+		c.metrics.end(metricsPhase, "PrepareBuildActions", start, nil)
+	}()
+
+	profileRegion(c.Context, "blueprint", "PrepareBuildActions", func(ctx context.Context) {
 		c.buildActionsReady = false
 
 		if !c.dependenciesReady {
@@ -2366,8 +3410,22 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			return
 		}
 
+		var depsPhonies []string
+		depsPhonies, errs = c.generatePhonyBuildActions(config, c.liveGlobals)
+		if len(errs) > 0 {
+			return
+		}
+
 		deps = append(deps, depsModules...)
 		deps = append(deps, depsSingletons...)
+		deps = append(deps, depsPhonies...)
+
+		if atomic.LoadUint32(&c.usesDyndep) != 0 {
+			c.requireNinjaVersion(1, 10, 0)
+		}
+		if atomic.LoadUint32(&c.usesValidations) != 0 {
+			c.requireNinjaVersion(1, 11, 0)
+		}
 
 		if c.ninjaBuildDir != nil {
 			err := c.liveGlobals.addNinjaStringDeps(c.ninjaBuildDir)
@@ -2381,16 +3439,61 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 
 		deps = append(deps, depsPackages...)
 
+		// The full names are memoized in the global entities, forget any stable names assigned to
+		// them by a previous Context.
+		clearFullNames(c.liveGlobals)
 		c.memoizeFullNames(c.liveGlobals, pkgNames)
 
 		// This will panic if it finds a problem since it's a programming error.
 		c.checkForVariableReferenceCycles(c.liveGlobals.variables, pkgNames)
 
+		if c.stableNinjaNames {
+			c.memoizeStableNames(c.liveGlobals, pkgNames)
+		}
+
 		c.pkgNames = pkgNames
 		c.globalVariables = c.liveGlobals.variables
 		c.globalPools = c.liveGlobals.pools
 		c.globalRules = c.liveGlobals.rules
 
+		if c.detectDuplicateOutputs {
+			errs = c.checkDuplicateOutputs()
+			if len(errs) > 0 {
+				return
+			}
+		}
+
+		if c.trackPaths {
+			errs = c.checkDeclaredPaths()
+			if len(errs) > 0 {
+				return
+			}
+		}
+
+		errs = c.checkStrictActions()
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.runFinalChecks(config)
+		if len(errs) > 0 {
+			return
+		}
+
+		if c.globCacheFile != "" {
+			if err := c.writeGlobCache(); err != nil {
+				errs = []error{err}
+				return
+			}
+		}
+
+		if c.buildActionsCache != nil {
+			if err := c.buildActionsCache.write(); err != nil {
+				errs = []error{err}
+				return
+			}
+		}
+
 		c.buildActionsReady = true
 	})
 
@@ -2404,12 +3507,29 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []string, errs []error) {
 	var mutators []*mutatorInfo
 
-	pprof.Do(ctx, pprof.Labels("blueprint", "runMutators"), func(ctx context.Context) {
+	profileRegion(ctx, "blueprint", "runMutators", func(ctx context.Context) {
 		mutators = append(mutators, c.earlyMutatorInfo...)
 		mutators = append(mutators, c.mutatorInfo...)
 
-		for _, mutator := range mutators {
-			pprof.Do(ctx, pprof.Labels("mutator", mutator.name), func(context.Context) {
+		if c.mutatorSnapshotDir != "" {
+			if err := c.writeMutatorSnapshot(0, "initial"); err != nil {
+				errs = append(errs, err)
+				return
+			}
+		}
+
+		for i, mutator := range mutators {
+			if err := checkCanceled(ctx); err != nil {
+				errs = []error{err}
+				return
+			}
+			profileRegion(ctx, "mutator", mutator.name, func(context.Context) {
+				start := c.metrics.begin()
+				defer func() {
+					c.metrics.end(metricsMutator, mutator.name, start, nil)
+					c.metrics.snapshotMemory()
+				}()
+
 				var newDeps []string
 				if mutator.topDownMutator != nil {
 					newDeps, errs = c.runMutator(config, mutator, topDownMutator)
@@ -2426,6 +3546,12 @@ func (c *Context) runMutators(ctx context.Context, config interface{}) (deps []s
 			if len(errs) > 0 {
 				return
 			}
+			if c.mutatorSnapshotDir != "" {
+				if err := c.writeMutatorSnapshot(i+1, mutator.name); err != nil {
+					errs = append(errs, err)
+					return
+				}
+			}
 		}
 	})
 
@@ -2500,8 +3626,14 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 	var rename []rename
 	var replace []replace
 	var newModules []*moduleInfo
+	var lazyVariants []*lazyVariant
 
-	errsCh := make(chan []error)
+	stats := MutatorStats{
+		Name:       mutator.name,
+		Variations: make(map[string]int),
+	}
+
+	errsCh := make(chan []error)
 	globalStateCh := make(chan globalStateChange)
 	newVariationsCh := make(chan modulesOrAliases)
 	done := make(chan bool)
@@ -2524,6 +3656,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		}
 
 		module.startedMutator = mutator
+		start := c.metrics.begin()
 
 		func() {
 			defer func() {
@@ -2541,6 +3674,19 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		}()
 
 		module.finishedMutator = mutator
+		c.metrics.end(metricsModule, module.String(), start, map[string]interface{}{"mutator": mutator.name})
+
+		if c.trackPropertyProvenance {
+			if module.splitModules != nil {
+				for _, moduleOrAlias := range module.splitModules {
+					if variant := moduleOrAlias.module(); variant != nil {
+						c.recordPropertyProvenance(variant, PropertySourceMutator, mutator.name, nil)
+					}
+				}
+			} else {
+				c.recordPropertyProvenance(module, PropertySourceMutator, mutator.name, nil)
+			}
+		}
 
 		if len(mctx.errs) > 0 {
 			errsCh <- mctx.errs
@@ -2582,6 +3728,10 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 				for _, moduleOrAlias := range newVariations {
 					if m := moduleOrAlias.module(); m != nil {
 						newModuleInfo[m.logicModule] = m
+						stats.VariantsCreated++
+						stats.Variations[m.variant.variations.get(mutator.name)]++
+					} else if lazy, ok := moduleOrAlias.(*lazyVariant); ok {
+						lazyVariants = append(lazyVariants, lazy)
 					}
 				}
 			case <-done:
@@ -2594,18 +3744,26 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 
 	var visitErrs []error
 	if mutator.parallel {
-		visitErrs = parallelVisit(c.modulesSorted, direction.orderer(), parallelVisitLimit, visit)
+		visitErrs = parallelVisit(c.Context, c.modulesSorted, direction.orderer(), c.visitLimit(), visit)
 	} else {
 		direction.orderer().visit(c.modulesSorted, visit)
 	}
 
+	done <- true
+
 	if len(visitErrs) > 0 {
 		return nil, visitErrs
 	}
 
 	c.finishedMutators[mutator] = true
 
-	done <- true
+	createdVariants, lazyErrs := resolveLazyVariants(lazyVariants)
+	errs = append(errs, lazyErrs...)
+	for _, m := range createdVariants {
+		newModuleInfo[m.logicModule] = m
+		stats.VariantsCreated++
+		stats.Variations[m.variant.variations.get(mutator.name)]++
+	}
 
 	if len(errs) > 0 {
 		return nil, errs
@@ -2639,6 +3797,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 			}
 
 			// Add in any new direct dependencies that were added by the mutator
+			stats.DependenciesAdded += len(module.newDirectDeps)
 			module.directDeps = append(module.directDeps, module.newDirectDeps...)
 			module.newDirectDeps = nil
 		}
@@ -2675,6 +3834,7 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 
 	// Add in any new reverse dependencies that were added by the mutator
 	for module, deps := range reverseDeps {
+		stats.DependenciesAdded += len(deps)
 		sort.Sort(depSorter(deps))
 		module.directDeps = append(module.directDeps, deps...)
 		c.depsModified++
@@ -2705,9 +3865,111 @@ func (c *Context) runMutator(config interface{}, mutator *mutatorInfo,
 		}
 	}
 
+	stats.ModulesCreated = len(newModules)
+	stats.Renames = len(rename)
+	stats.DependencyReplacements = len(replace)
+	c.mutatorStats = append(c.mutatorStats, stats)
+
 	return deps, errs
 }
 
+// MutatorStats describes the changes that a mutator made to the module graph.
+type MutatorStats struct {
+	// Name is the name the mutator was registered with.
+	Name string
+
+	// VariantsCreated is the number of variants created by the mutator splitting modules, including
+	// the first variant of each split module, which reuses the original module.
+	VariantsCreated int
+
+	// Variations is the number of variants created for each variation name.
+	Variations map[string]int
+
+	// ModulesCreated is the number of modules created with CreateModule.
+	ModulesCreated int
+
+	// DependenciesAdded is the number of dependencies added, including reverse dependencies.
+	DependenciesAdded int
+
+	// DependencyReplacements is the number of calls to ReplaceDependencies and
+	// ReplaceDependenciesIf.
+	DependencyReplacements int
+
+	// Renames is the number of calls to Rename.
+	Renames int
+}
+
+// MutatorStats returns statistics about the changes each mutator made to the module graph during
+// ResolveDependencies, in the order the mutators ran, including early mutators and the mutators
+// registered on behalf of transition mutators, so that the growth of the graph can be attributed
+// to individual mutators.
+func (c *Context) MutatorStats() []MutatorStats {
+	return append([]MutatorStats(nil), c.mutatorStats...)
+}
+
+// BlueprintsFileTime is the time spent processing a Blueprints file.
+type BlueprintsFileTime struct {
+	// File is the path to the Blueprints file relative to the root directory.
+	File string
+
+	// Parse is the time spent parsing the file and evaluating its variables.
+	Parse time.Duration
+
+	// Modules is the time spent creating the modules defined in the file from their properties.
+	Modules time.Duration
+
+	// Glob is the time spent evaluating the globs in the build variable of the file and the globs
+	// requested through GlobWithDeps by the modules it defines.
+	Glob time.Duration
+}
+
+// Total returns the total time spent processing the Blueprints file.
+func (t BlueprintsFileTime) Total() time.Duration {
+	return t.Parse + t.Modules + t.Glob
+}
+
+// addBlueprintsFileTime adds to the time spent processing a Blueprints file.
+func (c *Context) addBlueprintsFileTime(relBlueprintsFile string, parse, modules, glob time.Duration) {
+	c.blueprintsFileTimesLock.Lock()
+	defer c.blueprintsFileTimesLock.Unlock()
+
+	if c.blueprintsFileTimes == nil {
+		c.blueprintsFileTimes = make(map[string]*BlueprintsFileTime)
+	}
+	t := c.blueprintsFileTimes[relBlueprintsFile]
+	if t == nil {
+		t = &BlueprintsFileTime{File: relBlueprintsFile}
+		c.blueprintsFileTimes[relBlueprintsFile] = t
+	}
+	t.Parse += parse
+	t.Modules += modules
+	t.Glob += glob
+}
+
+// SlowestBlueprintsFiles returns the n Blueprints files that took the longest to process, sorted
+// by decreasing total time, to help find files with pathological contents such as huge lists or
+// expensive globs.  If n is less than or equal to zero all files are returned.
+func (c *Context) SlowestBlueprintsFiles(n int) []BlueprintsFileTime {
+	c.blueprintsFileTimesLock.Lock()
+	defer c.blueprintsFileTimesLock.Unlock()
+
+	times := make([]BlueprintsFileTime, 0, len(c.blueprintsFileTimes))
+	for _, t := range c.blueprintsFileTimes {
+		times = append(times, *t)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		if times[i].Total() != times[j].Total() {
+			return times[i].Total() > times[j].Total()
+		}
+		return times[i].File < times[j].File
+	})
+
+	if n > 0 && len(times) > n {
+		times = times[:n]
+	}
+	return times
+}
+
 // Replaces every build logic module with a clone of itself.  Prevents introducing problems where
 // a mutator sets a non-property member variable on a module, which works until a later mutator
 // creates variants of that module.
@@ -2719,7 +3981,8 @@ func (c *Context) cloneModules() {
 	ch := make(chan update)
 	doneCh := make(chan bool)
 	go func() {
-		errs := parallelVisit(c.modulesSorted, unorderedVisitorImpl{}, parallelVisitLimit,
+		// Cloning can't be left half done, so it ignores cancellation of the Context.
+		errs := parallelVisit(context.Background(), c.modulesSorted, unorderedVisitorImpl{}, c.visitLimit(),
 			func(m *moduleInfo, pause chan<- pauseSpec) bool {
 				origLogicModule := m.logicModule
 				m.logicModule, m.properties = c.cloneLogicModule(m)
@@ -2792,46 +4055,95 @@ func (c *Context) generateModuleBuildActions(config interface{},
 		}
 	}()
 
-	visitErrs := parallelVisit(c.modulesSorted, bottomUpVisitor, parallelVisitLimit,
+	for _, module := range c.modulesSorted {
+		if h, ok := module.logicModule.(HeavyModule); ok && h.Heavy() {
+			module.heavy = true
+		}
+	}
+
+	useCache := c.buildActionsCache != nil && !c.annotateBuildStatements
+	if useCache {
+		c.buildActionsCache.load()
+	}
+
+	visitErrs := parallelVisitWithHeavyLimit(c.Context, c.modulesSorted, bottomUpVisitor, c.visitLimit(), c.heavyVisitLimit(),
 		func(module *moduleInfo, pause chan<- pauseSpec) bool {
+			if module.disabled || module.pruned {
+				module.startedGenerateBuildActions = true
+				module.finishedGenerateBuildActions = true
+				if useCache {
+					c.setProvidersDigest(module)
+				}
+				return false
+			}
+
 			uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
 			sanitizedName := toNinjaName(uniqueName)
 
 			prefix := moduleNamespacePrefix(sanitizedName + "_" + module.variant.name)
 
-			// The parent scope of the moduleContext's local scope gets overridden to be that of the
-			// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
-			// just set it to nil.
-			scope := newLocalScope(nil, prefix)
-
-			mctx := &moduleContext{
-				baseModuleContext: baseModuleContext{
-					context: c,
-					config:  config,
-					module:  module,
-				},
-				scope:              scope,
-				handledMissingDeps: module.missingDeps == nil,
+			newModuleContext := func() *moduleContext {
+				// The parent scope of the moduleContext's local scope gets overridden to be that of the
+				// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
+				// just set it to nil.
+				scope := newLocalScope(nil, prefix)
+				scope.literals = c.literalNinjaStrings
+
+				return &moduleContext{
+					baseModuleContext: baseModuleContext{
+						context: c,
+						config:  config,
+						module:  module,
+					},
+					scope:              scope,
+					handledMissingDeps: module.missingDeps == nil,
+				}
 			}
+			mctx := newModuleContext()
 
 			mctx.module.startedGenerateBuildActions = true
+			mctx.module.phonies = nil
+			mctx.module.metadata = nil
+			start := c.metrics.begin()
+
+			var cacheKey string
+			cacheable, replayed := false, false
+			var providersBefore []interface{}
+			if useCache {
+				cacheKey, cacheable = c.buildActionsCacheKey(module, config)
+			}
+			if cacheable {
+				if cached := c.buildActionsCache.lookup(moduleCacheName(module), cacheKey); cached != nil {
+					if c.replayBuildActions(mctx, cached) {
+						replayed = true
+						c.buildActionsCache.store(moduleCacheName(module), cached)
+					} else {
+						mctx = newModuleContext()
+					}
+				}
+				providersBefore = append([]interface{}(nil), module.providers...)
+				mctx.recorder = &buildActionsRecorder{}
+			}
 
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						in := fmt.Sprintf("GenerateBuildActions for %s", module)
-						if err, ok := r.(panicError); ok {
-							err.addIn(in)
-							mctx.error(err)
-						} else {
-							mctx.error(newPanicErrorf(r, in))
+			if !replayed {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							in := fmt.Sprintf("GenerateBuildActions for %s", module)
+							if err, ok := r.(panicError); ok {
+								err.addIn(in)
+								mctx.error(err)
+							} else {
+								mctx.error(newPanicErrorf(r, in))
+							}
 						}
-					}
+					}()
+					mctx.module.logicModule.GenerateBuildActions(mctx)
 				}()
-				mctx.module.logicModule.GenerateBuildActions(mctx)
-			}()
+			}
 
 			mctx.module.finishedGenerateBuildActions = true
+			c.metrics.end(metricsModule, module.String(), start, map[string]interface{}{"phase": "GenerateBuildActions"})
 
 			if len(mctx.errs) > 0 {
 				errsCh <- mctx.errs
@@ -2855,6 +4167,15 @@ func (c *Context) generateModuleBuildActions(config interface{},
 				errsCh <- newErrs
 				return true
 			}
+
+			if cacheable && !replayed {
+				if cached := mctx.recorder.finish(mctx, cacheKey, providersBefore); cached != nil {
+					c.buildActionsCache.store(moduleCacheName(module), cached)
+				}
+			}
+			if useCache {
+				c.setProvidersDigest(module)
+			}
 			return false
 		})
 
@@ -2869,57 +4190,83 @@ func (c *Context) generateModuleBuildActions(config interface{},
 func (c *Context) generateSingletonBuildActions(config interface{},
 	singletons []*singletonInfo, liveGlobals *liveTracker) ([]string, []error) {
 
-	var deps []string
-	var errs []error
+	order, err := singletonOrder(singletons)
+	if err != nil {
+		return nil, []error{err}
+	}
 
-	for _, info := range singletons {
-		// The parent scope of the singletonContext's local scope gets overridden to be that of the
-		// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
-		// just set it to nil.
-		scope := newLocalScope(nil, singletonNamespacePrefix(info.name))
+	deps := make([][]string, len(singletons))
+	errs := make([][]error, len(singletons))
+	sctxs := make([]*singletonContext, len(singletons))
 
-		sctx := &singletonContext{
-			name:    info.name,
-			context: c,
-			config:  config,
-			scope:   scope,
-			globals: liveGlobals,
+	c.runSingletons(order, func(i int) int {
+		sctxs[i], deps[i], errs[i] = c.generateOneSingletonBuildActions(config, singletons[i],
+			liveGlobals)
+		return len(errs[i])
+	})
+
+	// Merge the results in registration order so that they don't depend on the order in which
+	// parallel singletons finished.
+	var allDeps []string
+	var allErrs []error
+	for i := range singletons {
+		if sctxs[i] != nil {
+			sctxs[i].applyNinjaSettings()
 		}
+		allDeps = append(allDeps, deps[i]...)
+		allErrs = append(allErrs, errs[i]...)
+	}
 
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					in := fmt.Sprintf("GenerateBuildActions for singleton %s", info.name)
-					if err, ok := r.(panicError); ok {
-						err.addIn(in)
-						sctx.error(err)
-					} else {
-						sctx.error(newPanicErrorf(r, in))
-					}
+	return allDeps, allErrs
+}
+
+func (c *Context) generateOneSingletonBuildActions(config interface{}, info *singletonInfo,
+	liveGlobals *liveTracker) (*singletonContext, []string, []error) {
+
+	// The parent scope of the singletonContext's local scope gets overridden to be that of the
+	// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
+	// just set it to nil.
+	scope := newLocalScope(nil, singletonNamespacePrefix(info.name))
+	scope.literals = c.literalNinjaStrings
+
+	sctx := &singletonContext{
+		name:    info.name,
+		context: c,
+		config:  config,
+		scope:   scope,
+		globals: liveGlobals,
+	}
+
+	start := c.metrics.begin()
+	profileRegion(c.Context, "singleton", info.name, func(context.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				in := fmt.Sprintf("GenerateBuildActions for singleton %s", info.name)
+				if err, ok := r.(panicError); ok {
+					err.addIn(in)
+					sctx.error(err)
+				} else {
+					sctx.error(newPanicErrorf(r, in))
 				}
-			}()
-			info.singleton.GenerateBuildActions(sctx)
+			}
 		}()
+		info.singleton.GenerateBuildActions(sctx)
+	})
+	c.metrics.end(metricsSingleton, info.name, start, nil)
+	c.metrics.snapshotMemory()
 
-		if len(sctx.errs) > 0 {
-			errs = append(errs, sctx.errs...)
-			if len(errs) > maxErrors {
-				break
-			}
-			continue
-		}
+	if len(sctx.errs) > 0 {
+		return sctx, nil, sctx.errs
+	}
 
-		deps = append(deps, sctx.ninjaFileDeps...)
+	info.phonies = sctx.phonies
 
-		newErrs := c.processLocalBuildActions(&info.actionDefs,
-			&sctx.actionDefs, liveGlobals)
-		errs = append(errs, newErrs...)
-		if len(errs) > maxErrors {
-			break
-		}
+	errs := c.processLocalBuildActions(&info.actionDefs, &sctx.actionDefs, liveGlobals)
+	if len(errs) > 0 {
+		return sctx, nil, errs
 	}
 
-	return deps, errs
+	return sctx, sctx.ninjaFileDeps, nil
 }
 
 func (c *Context) processLocalBuildActions(out, in *localBuildActions,
@@ -3064,7 +4411,7 @@ func (c *Context) handleReplacements(replacements []replace) []error {
 }
 
 func (c *Context) discoveredMissingDependencies(module *moduleInfo, depName string, depVariations variationMap) (errs []error) {
-	if depVariations != nil {
+	if depVariations.len() > 0 {
 		depName = depName + "{" + c.prettyPrintVariant(depVariations) + "}"
 	}
 	if c.allowMissingDependencies {
@@ -3077,8 +4424,23 @@ func (c *Context) discoveredMissingDependencies(module *moduleInfo, depName stri
 func (c *Context) missingDependencyError(module *moduleInfo, depName string) (errs error) {
 	err := c.nameInterface.MissingDependencyError(module.Name(), module.namespace(), depName)
 
+	if suggester, ok := c.nameInterface.(NameSuggester); ok {
+		// Strip the variations added by discoveredMissingDependencies.
+		name := depName
+		if i := strings.Index(name, "{"); i >= 0 {
+			name = name[:i]
+		}
+		if suggestions := suggester.SuggestModuleNames(name, module.namespace()); len(suggestions) > 0 {
+			quoted := make([]string, len(suggestions))
+			for i, suggestion := range suggestions {
+				quoted[i] = fmt.Sprintf("%q", suggestion)
+			}
+			err = fmt.Errorf("%w\ndid you mean %s?", err, strings.Join(quoted, " or "))
+		}
+	}
+
 	return &BlueprintError{
-		Err: err,
+		Err: errorWithKind(ErrMissingDependency, err),
 		Pos: module.pos,
 	}
 }
@@ -3182,6 +4544,17 @@ func (c *Context) requireNinjaVersion(major, minor, micro int) {
 	}
 }
 
+// recordNinjaFeatures records the Ninja features used by a build statement, so that
+// PrepareBuildActions can raise ninja_required_version to a version that supports them.
+func (c *Context) recordNinjaFeatures(params *BuildParams) {
+	if params.Dyndep != "" {
+		atomic.StoreUint32(&c.usesDyndep, 1)
+	}
+	if len(params.Validations) > 0 {
+		atomic.StoreUint32(&c.usesValidations, 1)
+	}
+}
+
 func (c *Context) setNinjaBuildDir(value ninjaString) {
 	if c.ninjaBuildDir == nil {
 		c.ninjaBuildDir = value
@@ -3339,41 +4712,265 @@ func (c *Context) checkForVariableReferenceCycles(
 // targets all'.  If this is called before PrepareBuildActions successfully
 // completes then ErrbuildActionsNotReady is returned.
 func (c *Context) AllTargets() (map[string]string, error) {
+	targetInfos, err := c.AllTargetsWithOrigin()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]string, len(targetInfos))
+	for target, info := range targetInfos {
+		targets[target] = info.Rule
+	}
+
+	return targets, nil
+}
+
+// TargetInfo describes a build target returned by AllTargetsWithOrigin.
+type TargetInfo struct {
+	// Rule is the name of the rule used to build the target.
+	Rule string
+
+	// Singleton is true if the target was generated by a singleton, and false if it was
+	// generated by a module.
+	Singleton bool
+
+	// OriginName is the name of the module or the registered name of the singleton that
+	// generated the target.
+	OriginName string
+
+	// OriginType is the module type of the module, or the Go type of the singleton, that
+	// generated the target.
+	OriginType string
+
+	// OriginVariant is the variant name of the module that generated the target.  It is
+	// empty for singletons.
+	OriginVariant string
+}
+
+// AllTargetsWithOrigin returns a map of all the build target names to the rule used to build them
+// and the module or singleton that generated them, so that targets can be grouped by where they
+// came from.  If this is called before PrepareBuildActions successfully completes then
+// ErrbuildActionsNotReady is returned.
+func (c *Context) AllTargetsWithOrigin() (map[string]TargetInfo, error) {
 	if !c.buildActionsReady {
 		return nil, ErrBuildActionsNotReady
 	}
 
-	targets := map[string]string{}
+	return c.allTargetsWithOrigin()
+}
 
-	// Collect all the module build targets.
-	for _, module := range c.moduleInfo {
-		for _, buildDef := range module.actionDefs.buildDefs {
-			ruleName := buildDef.Rule.fullName(c.pkgNames)
+func (c *Context) allTargetsWithOrigin() (map[string]TargetInfo, error) {
+	targets := map[string]TargetInfo{}
+
+	addTargets := func(buildDefs []*buildDef, info TargetInfo) error {
+		for _, buildDef := range buildDefs {
+			info.Rule = buildDef.Rule.fullName(c.pkgNames)
 			for _, output := range append(buildDef.Outputs, buildDef.ImplicitOutputs...) {
 				outputValue, err := output.Eval(c.globalVariables)
 				if err != nil {
-					return nil, err
+					return err
 				}
-				targets[outputValue] = ruleName
+				targets[outputValue] = info
 			}
 		}
+		return nil
+	}
+
+	// Collect all the module build targets.
+	for _, module := range c.moduleInfo {
+		err := addTargets(module.actionDefs.buildDefs, TargetInfo{
+			OriginName:    module.Name(),
+			OriginType:    module.typeName,
+			OriginVariant: module.variant.name,
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Collect all the singleton build targets.
 	for _, info := range c.singletonInfo {
-		for _, buildDef := range info.actionDefs.buildDefs {
-			ruleName := buildDef.Rule.fullName(c.pkgNames)
+		err := addTargets(info.actionDefs.buildDefs, TargetInfo{
+			Singleton:  true,
+			OriginName: info.name,
+			OriginType: singletonTypeName(info.singleton),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return targets, nil
+}
+
+// checkDuplicateOutputs returns an error for each output or implicit output that is declared by
+// more than one build statement.  Modules are checked in sorted order followed by singletons in
+// registration order, and each error names the first definer of the output.
+func (c *Context) checkDuplicateOutputs() []error {
+	type definer struct {
+		module    *moduleInfo
+		singleton *singletonInfo
+	}
+
+	describe := func(d definer) string {
+		if d.module != nil {
+			return d.module.String()
+		}
+		return fmt.Sprintf("singleton %q", d.singleton.name)
+	}
+
+	var errs []error
+	outputs := make(map[string]definer)
+
+	report := func(d definer, err error) {
+		if d.module != nil {
+			errs = append(errs, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: err,
+					Pos: d.module.pos,
+				},
+				module: d.module,
+			})
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %s", describe(d), err))
+		}
+	}
+
+	check := func(buildDefs []*buildDef, d definer) {
+		for _, buildDef := range buildDefs {
+			statementOutputs := make(map[string]bool)
 			for _, output := range append(buildDef.Outputs, buildDef.ImplicitOutputs...) {
 				outputValue, err := output.Eval(c.globalVariables)
 				if err != nil {
-					return nil, err
+					errs = append(errs, err)
+					continue
+				}
+
+				if statementOutputs[outputValue] {
+					report(d, fmt.Errorf("output %q is listed more than once in the same build statement",
+						outputValue))
+					continue
+				}
+				statementOutputs[outputValue] = true
+
+				first, exists := outputs[outputValue]
+				if !exists {
+					outputs[outputValue] = d
+					continue
 				}
-				targets[outputValue] = ruleName
+
+				report(d, fmt.Errorf("output %q is also built by %s", outputValue, describe(first)))
 			}
 		}
 	}
 
-	return targets, nil
+	for _, module := range c.sortedModuleInfos() {
+		check(module.actionDefs.buildDefs, definer{module: module})
+	}
+
+	for _, info := range c.singletonInfo {
+		check(info.actionDefs.buildDefs, definer{singleton: info})
+	}
+
+	return errs
+}
+
+// checkDeclaredPaths returns an error for each dependency of a build statement of a module that
+// was not declared as allowed by the module, and for each output file declared by a module that
+// the module doesn't build.  See SetTrackPaths.
+func (c *Context) checkDeclaredPaths() []error {
+	// Build statements may refer to variables local to the module, which are not in
+	// c.globalVariables, so collect the values of the variables each path refers to.
+	var collectVariables func(path ninjaString, variables map[Variable]ninjaString) error
+	collectVariables = func(path ninjaString, variables map[Variable]ninjaString) error {
+		for _, v := range path.Variables() {
+			if _, exists := variables[v]; exists {
+				continue
+			}
+			if local, ok := v.(*localVariable); ok {
+				variables[v] = local.value_
+			} else if value, ok := c.globalVariables[v]; ok {
+				variables[v] = value
+			} else {
+				return fmt.Errorf("no such global variable: %s", v)
+			}
+			if err := collectVariables(variables[v], variables); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	evalPath := func(path ninjaString) (string, error) {
+		variables := make(map[Variable]ninjaString)
+		if err := collectVariables(path, variables); err != nil {
+			return "", err
+		}
+		return path.Eval(variables)
+	}
+
+	var errs []error
+	for _, module := range c.sortedModuleInfos() {
+		if len(module.declaredInputs) == 0 && len(module.declaredOutputs) == 0 {
+			continue
+		}
+
+		moduleErrorf := func(format string, args ...interface{}) {
+			errs = append(errs, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf(format, args...),
+					Pos: module.pos,
+				},
+				module: module,
+			})
+		}
+
+		allowed := make(map[string]bool)
+		for _, input := range module.declaredInputs {
+			allowed[input] = true
+		}
+		for _, dep := range module.directDeps {
+			for _, output := range dep.module.declaredOutputs {
+				allowed[output] = true
+			}
+		}
+
+		built := make(map[string]bool)
+		for _, buildDef := range module.actionDefs.buildDefs {
+			for _, outputs := range [][]ninjaString{buildDef.Outputs, buildDef.ImplicitOutputs} {
+				for _, output := range outputs {
+					value, err := evalPath(output)
+					if err != nil {
+						errs = append(errs, err)
+						continue
+					}
+					built[value] = true
+					allowed[value] = true
+				}
+			}
+		}
+
+		for _, buildDef := range module.actionDefs.buildDefs {
+			for _, deps := range [][]ninjaString{buildDef.Inputs, buildDef.Implicits, buildDef.OrderOnly} {
+				for _, dep := range deps {
+					value, err := evalPath(dep)
+					if err != nil {
+						errs = append(errs, err)
+					} else if !allowed[value] {
+						moduleErrorf("input %q is not a declared input file, an output of the module "+
+							"or a declared output file of a direct dependency", value)
+					}
+				}
+			}
+		}
+
+		for _, output := range module.declaredOutputs {
+			if !built[output] {
+				moduleErrorf("declared output file %q is not built by the module", output)
+			}
+		}
+	}
+
+	return errs
 }
 
 func (c *Context) NinjaBuildDir() (string, error) {
@@ -3403,13 +5000,25 @@ func (c *Context) ModuleTypeFactories() map[string]ModuleFactory {
 	return ret
 }
 
+// ModuleTypeAliases returns a map from each alias registered with RegisterModuleTypeAlias to the
+// name of the module type it refers to.
+func (c *Context) ModuleTypeAliases() map[string]string {
+	ret := make(map[string]string, len(c.moduleTypeAliases))
+	for k, v := range c.moduleTypeAliases {
+		ret[k] = v
+	}
+	return ret
+}
+
 func (c *Context) ModuleName(logicModule Module) string {
 	module := c.moduleInfo[logicModule]
 	return module.Name()
 }
 
+// ModuleDir returns the directory of the Blueprints file that defined the module as a clean
+// slash-separated path relative to the source root, "." for the root directory.
 func (c *Context) ModuleDir(logicModule Module) string {
-	return filepath.Dir(c.BlueprintFile(logicModule))
+	return c.moduleInfo[logicModule].dir()
 }
 
 func (c *Context) ModuleSubDir(logicModule Module) string {
@@ -3426,21 +5035,41 @@ func (c *Context) ModuleType(logicModule Module) string {
 // provider was not set it returns the zero value of the type of the provider, which means the
 // return value can always be type-asserted to the type of the provider.  The return value should
 // always be considered read-only.  It panics if called before the appropriate mutator or
-// GenerateBuildActions pass for the provider on the module.  The value returned may be a deep
-// copy of the value originally passed to SetProvider.
+// GenerateBuildActions pass for the provider on the module, or if the module was not created by
+// this Context.  The value returned may be a deep copy of the value originally passed to
+// SetProvider.
 func (c *Context) ModuleProvider(logicModule Module, provider ProviderKey) interface{} {
-	module := c.moduleInfo[logicModule]
+	module := c.providerModule(logicModule, provider)
 	value, _ := c.provider(module, provider)
 	return value
 }
 
 // ModuleHasProvider returns true if the provider for the given module has been set.
 func (c *Context) ModuleHasProvider(logicModule Module, provider ProviderKey) bool {
-	module := c.moduleInfo[logicModule]
+	module := c.providerModule(logicModule, provider)
 	_, ok := c.provider(module, provider)
 	return ok
 }
 
+// ModulePropertiesHash returns a stable hash of the current values of all of the property structs
+// of a module.  See ModuleContext.PropertiesHash.
+func (c *Context) ModulePropertiesHash(logicModule Module) string {
+	return c.modulePropertiesHash(c.moduleInfo[logicModule])
+}
+
+func (c *Context) modulePropertiesHash(module *moduleInfo) string {
+	hash, err := proptools.HashProperties(module.properties...)
+	if err != nil {
+		// Property structs are validated when they are unpacked, so this should never happen.
+		panic(fmt.Errorf("failed to hash properties of module %s: %s", module, err))
+	}
+	return hash
+}
+
+func (c *Context) otherModuleProvider(logicModule Module, provider ProviderKey) (interface{}, bool) {
+	return c.provider(c.providerModule(logicModule, provider), provider)
+}
+
 func (c *Context) BlueprintFile(logicModule Module) string {
 	module := c.moduleInfo[logicModule]
 	return module.relBlueprintsFile
@@ -3456,6 +5085,32 @@ func (c *Context) ModuleErrorf(logicModule Module, format string,
 	}
 }
 
+// Warnings returns the warnings that have been reported so far through the Warningf methods of
+// module, mutator and singleton contexts, and by the checks of the Context, sorted by location
+// and then by message.  Each warning is a *Warning.  Warnings do not cause any phase to fail
+// unless their class was promoted to errors with SetWarningsAsErrors, and may be retrieved after
+// each phase.
+func (c *Context) Warnings() []error {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	warnings := append([]error(nil), c.warnings...)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := newDiagnostic(warnings[i]), newDiagnostic(warnings[j])
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return a.Message < b.Message
+	})
+	return warnings
+}
+
 func (c *Context) VisitAllModules(visit func(Module)) {
 	c.visitAllModules(visit)
 }
@@ -3466,6 +5121,87 @@ func (c *Context) VisitAllModulesIf(pred func(Module) bool,
 	c.visitAllModulesIf(pred, visit)
 }
 
+// ModuleGroupInfo describes a module defined in a Blueprints file and all of the variants that were
+// created from it by mutators.
+type ModuleGroupInfo struct {
+	// Name is the name of the module.
+	Name string
+
+	// Namespace is the namespace that contains the module.
+	Namespace Namespace
+
+	// Variants contains the variants of the module, in the order they were created.
+	Variants []ModuleVariantInfo
+
+	// Aliases contains the aliases to variants of the module that were created by mutators.
+	Aliases []ModuleAliasInfo
+}
+
+// ModuleVariantInfo describes one variant of a module.
+type ModuleVariantInfo struct {
+	// Module is the variant of the module.
+	Module Module
+
+	// Name is the unique name of the variant, as returned by ModuleSubDir.
+	Name string
+
+	// Variations maps each mutator that split the module to the variation of this variant.
+	Variations map[string]string
+}
+
+// ModuleAliasInfo describes an alias to a variant of a module.
+type ModuleAliasInfo struct {
+	// Name is the unique name of the alias.
+	Name string
+
+	// Variations maps each mutator that split the module to the variation of the alias.
+	Variations map[string]string
+
+	// Target is the variant of the module that dependencies on the alias resolve to.
+	Target Module
+}
+
+// VisitAllModuleGroups calls visit for each module, with a description of all of its variants
+// and aliases, in order of module name.  The ModuleGroupInfo is a copy and may be retained.
+func (c *Context) VisitAllModuleGroups(visit func(ModuleGroupInfo)) {
+	var group *moduleGroup
+
+	defer func() {
+		if r := recover(); r != nil {
+			in := fmt.Sprintf("VisitAllModuleGroups(%s)", funcName(visit))
+			if group != nil {
+				in += " for " + group.name
+			}
+			panic(newPanicErrorf(r, "%s", in))
+		}
+	}()
+
+	for _, group = range c.sortedModuleGroups() {
+		info := ModuleGroupInfo{
+			Name:      group.name,
+			Namespace: group.namespace,
+		}
+		for _, moduleOrAlias := range group.modules {
+			if module := moduleOrAlias.module(); module != nil {
+				info.Variants = append(info.Variants, ModuleVariantInfo{
+					Module:     module.logicModule,
+					Name:       module.variant.name,
+					Variations: module.variant.variations.toMap(),
+				})
+			} else if alias := moduleOrAlias.alias(); alias != nil {
+				info.Aliases = append(info.Aliases, ModuleAliasInfo{
+					Name:       alias.variant.name,
+					Variations: alias.variant.variations.toMap(),
+					Target:     alias.target.logicModule,
+				})
+			}
+		}
+		if len(info.Variants) > 0 {
+			visit(info)
+		}
+	}
+}
+
 func (c *Context) VisitDirectDeps(module Module, visit func(Module)) {
 	topModule := c.moduleInfo[module]
 
@@ -3550,6 +5286,41 @@ func (c *Context) FinalModule(module Module) Module {
 	return c.moduleInfo[module].group.modules.lastModule().logicModule
 }
 
+// IsDefaultTarget returns true if the given variant was marked with
+// BaseMutatorContext.MarkDefaultTarget, or if no variant of its module was marked.  The build
+// statements of variants that aren't default targets are not built when Ninja is run without
+// targets, and singletons that create phony targets aggregating modules should only include
+// the default targets.
+func (c *Context) IsDefaultTarget(module Module) bool {
+	return c.isDefaultTarget(c.moduleInfo[module])
+}
+
+func (c *Context) isDefaultTarget(module *moduleInfo) bool {
+	return module.defaultTarget || !c.hasDefaultTargets(module)
+}
+
+// HasDefaultTargets returns true if any variant of the given module was marked with
+// BaseMutatorContext.MarkDefaultTarget.  It can be used to fall back to the primary variant
+// for modules that didn't mark any variant, where IsDefaultTarget is true for all of them.
+func (c *Context) HasDefaultTargets(module Module) bool {
+	return c.hasDefaultTargets(c.moduleInfo[module])
+}
+
+func (c *Context) hasDefaultTargets(module *moduleInfo) bool {
+	for _, moduleOrAlias := range module.group.modules {
+		if variant := moduleOrAlias.module(); variant != nil && variant.defaultTarget {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDistTarget returns true if the given variant was marked with
+// BaseMutatorContext.MarkDistTarget.
+func (c *Context) IsDistTarget(module Module) bool {
+	return c.moduleInfo[module].distTarget
+}
+
 func (c *Context) VisitAllModuleVariants(module Module,
 	visit func(Module)) {
 
@@ -3576,11 +5347,30 @@ func (c *Context) SingletonName(singleton Singleton) string {
 }
 
 // WriteBuildFile writes the Ninja manifeset text for the generated build
-// actions to w.  If this is called before PrepareBuildActions successfully
+// actions to w, and then writes the metadata file if one was set with
+// SetMetadataFile.  If this is called before PrepareBuildActions successfully
 // completes then ErrBuildActionsNotReady is returned.
 func (c *Context) WriteBuildFile(w io.StringWriter) error {
+	return c.writeBuildFile("WriteBuildFile", w, func(nw *ninjaWriter) error {
+		if c.moduleFragmentDir != "" {
+			return c.writeModuleFragments(nw)
+		}
+		return c.writeAllModuleActions(nw)
+	})
+}
+
+// writeBuildFile writes the Ninja manifest for WriteBuildFile and WriteBuildFileSharded, using
+// writeModuleActions to write the module build actions, and then writes the metadata file.
+func (c *Context) writeBuildFile(phase string, w io.StringWriter,
+	writeModuleActions func(nw *ninjaWriter) error) error {
+
+	if err := c.startPhase(phase); err != nil {
+		return err
+	}
+	defer c.endPhase()
+
 	var err error
-	pprof.Do(c.Context, pprof.Labels("blueprint", "WriteBuildFile"), func(ctx context.Context) {
+	profileRegion(c.Context, "blueprint", phase, func(ctx context.Context) {
 		if !c.buildActionsReady {
 			err = ErrBuildActionsNotReady
 			return
@@ -3625,7 +5415,7 @@ func (c *Context) WriteBuildFile(w io.StringWriter) error {
 			return
 		}
 
-		err = c.writeAllModuleActions(nw)
+		err = writeModuleActions(nw)
 		if err != nil {
 			return
 		}
@@ -3640,7 +5430,7 @@ func (c *Context) WriteBuildFile(w io.StringWriter) error {
 		return err
 	}
 
-	return nil
+	return c.writeMetadataFile()
 }
 
 type pkgAssociation struct {
@@ -3666,6 +5456,64 @@ func (s *pkgAssociationSorter) Swap(i, j int) {
 	s.pkgs[i], s.pkgs[j] = s.pkgs[j], s.pkgs[i]
 }
 
+// WriteBuildFileSharded writes the Ninja manifest text for the generated build
+// actions to w like WriteBuildFile, except that the module build actions are
+// split into one file per top-level source directory in shardDir, which are
+// included from the main manifest with subninja statements.  This keeps the
+// main manifest small and allows each shard to be regenerated independently.
+// Modules defined in the top-level Blueprints file are written to
+// "_root.ninja", and the shards of top-level directories whose name starts
+// with an underscore get another underscore prefixed so that they can't
+// collide with it.  The shards are written like the fragments of
+// SetModuleFragmentDir, which is ignored by WriteBuildFileSharded: shardDir is
+// used both to write the shard files and in the subninja statements, so it
+// must either be absolute or be relative to both the current directory and the
+// directory Ninja runs in, a shard file is only rewritten if its contents have
+// changed, and shards written by a previous call that are no longer needed are
+// removed.  If this is called before PrepareBuildActions successfully
+// completes then ErrBuildActionsNotReady is returned.
+func (c *Context) WriteBuildFileSharded(w io.StringWriter, shardDir string) error {
+	return c.writeBuildFile("WriteBuildFileSharded", w, func(nw *ninjaWriter) error {
+		return c.writeModuleActionShards(nw, shardDir)
+	})
+}
+
+// moduleActionsShard returns the name of the shard file that the build
+// actions for a module are written to by WriteBuildFileSharded.
+func moduleActionsShard(module *moduleInfo) string {
+	dir := module.dir()
+	if dir == "." {
+		return "_root.ninja"
+	}
+	if i := strings.IndexByte(dir, '/'); i >= 0 {
+		dir = dir[:i]
+	}
+	if strings.HasPrefix(dir, "_") {
+		// Escape the name so that a directory named _root doesn't use the shard of the
+		// top-level Blueprints file.
+		dir = "_" + dir
+	}
+	return dir + ".ninja"
+}
+
+// writeModuleActionShards writes the module build actions into shard files
+// in shardDir, and writes subninja statements for them to nw.
+func (c *Context) writeModuleActionShards(nw *ninjaWriter, shardDir string) error {
+	shardModules := make(map[string][]*moduleInfo)
+	for _, module := range c.sortedModuleInfos() {
+		shard := moduleActionsShard(module)
+		shardModules[shard] = append(shardModules[shard], module)
+	}
+
+	shards := make([]moduleActionsFile, 0, len(shardModules))
+	for shard, modules := range shardModules {
+		shards = append(shards, moduleActionsFile{shard, modules})
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].name < shards[j].name })
+
+	return c.writeModuleActionsFiles(nw, shardDir, shards, true)
+}
+
 func (c *Context) writeBuildFileHeader(nw *ninjaWriter) error {
 	headerTemplate := template.New("fileHeader")
 	_, err := headerTemplate.Parse(fileHeaderTemplate)
@@ -3767,6 +5615,7 @@ func (s *globalEntitySorter) Swap(i, j int) {
 
 func (c *Context) writeGlobalVariables(nw *ninjaWriter) error {
 	visited := make(map[Variable]bool)
+	written := make(map[string]bool)
 
 	var walk func(v Variable) error
 	walk = func(v Variable) error {
@@ -3783,7 +5632,14 @@ func (c *Context) writeGlobalVariables(nw *ninjaWriter) error {
 			}
 		}
 
-		err := nw.Assign(v.fullName(c.pkgNames), value.Value(c.pkgNames))
+		name := v.fullName(c.pkgNames)
+		if written[name] {
+			// An identical variable from another package, see SetStableNinjaNames.
+			return nil
+		}
+		written[name] = true
+
+		err := nw.Assign(name, value.Value(c.pkgNames))
 		if err != nil {
 			return err
 		}
@@ -3824,9 +5680,15 @@ func (c *Context) writeGlobalPools(nw *ninjaWriter) error {
 
 	sort.Sort(&globalEntitySorter{c.pkgNames, globalPools})
 
+	written := make(map[string]bool)
 	for _, entity := range globalPools {
 		pool := entity.(Pool)
 		name := pool.fullName(c.pkgNames)
+		if written[name] {
+			// An identical pool from another package, see SetStableNinjaNames.
+			continue
+		}
+		written[name] = true
 		def := c.globalPools[pool]
 		err := def.WriteTo(nw, name)
 		if err != nil {
@@ -3850,9 +5712,15 @@ func (c *Context) writeGlobalRules(nw *ninjaWriter) error {
 
 	sort.Sort(&globalEntitySorter{c.pkgNames, globalRules})
 
+	written := make(map[string]bool)
 	for _, entity := range globalRules {
 		rule := entity.(Rule)
 		name := rule.fullName(c.pkgNames)
+		if written[name] {
+			// An identical rule from another package, see SetStableNinjaNames.
+			continue
+		}
+		written[name] = true
 		def := c.globalRules[rule]
 		err := def.WriteTo(nw, name, c.pkgNames)
 		if err != nil {
@@ -3920,7 +5788,37 @@ func (s moduleSorter) Swap(i, j int) {
 	s.modules[i], s.modules[j] = s.modules[j], s.modules[i]
 }
 
+func (c *Context) sortedModuleInfos() []*moduleInfo {
+	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
+	for _, module := range c.moduleInfo {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter{modules, c.nameInterface})
+	return modules
+}
+
 func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
+	return c.writeModuleActions(nw, c.sortedModuleInfos())
+}
+
+// moduleActionsBufferPool holds the buffers that module actions are rendered into by
+// writeModuleActions.
+var moduleActionsBufferPool = sync.Pool{
+	New: func() interface{} { return &bytes.Buffer{} },
+}
+
+type moduleActionsResult struct {
+	buf              *bytes.Buffer
+	justDidBlankLine bool
+	err              error
+	panic            interface{}
+}
+
+// writeModuleActions writes the build actions of modules to nw in order.  The ninja text for each
+// module is rendered in parallel into a per-module buffer, and the buffers are written to nw in
+// order as they complete.  The number of rendered buffers waiting to be written is bounded to
+// limit the memory used.
+func (c *Context) writeModuleActions(nw *ninjaWriter, modules []*moduleInfo) error {
 	headerTemplate := template.New("moduleHeader")
 	_, err := headerTemplate.Parse(moduleHeaderTemplate)
 	if err != nil {
@@ -3928,65 +5826,107 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 		panic(err)
 	}
 
-	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
-	for _, module := range c.moduleInfo {
-		modules = append(modules, module)
-	}
-	sort.Sort(moduleSorter{modules, c.nameInterface})
+	workers := runtime.GOMAXPROCS(0)
+	pending := make(chan chan moduleActionsResult, 4*workers)
+	done := make(chan struct{})
+	defer close(done)
 
-	buf := bytes.NewBuffer(nil)
+	go func() {
+		defer close(pending)
+		limit := make(chan struct{}, workers)
+		for _, module := range modules {
+			if len(module.actionDefs.variables)+len(module.actionDefs.rules)+len(module.actionDefs.buildDefs) == 0 {
+				continue
+			}
 
-	for _, module := range modules {
-		if len(module.actionDefs.variables)+len(module.actionDefs.rules)+len(module.actionDefs.buildDefs) == 0 {
-			continue
+			resultCh := make(chan moduleActionsResult, 1)
+			select {
+			case pending <- resultCh:
+			case <-done:
+				return
+			}
+			limit <- struct{}{}
+			go func(module *moduleInfo) {
+				defer func() { <-limit }()
+				resultCh <- c.renderModuleActions(module, headerTemplate)
+			}(module)
 		}
+	}()
 
-		buf.Reset()
-
-		// In order to make the bootstrap build manifest independent of the
-		// build dir we need to output the Blueprints file locations in the
-		// comments as paths relative to the source directory.
-		relPos := module.pos
-		relPos.Filename = module.relBlueprintsFile
-
-		// Get the name and location of the factory function for the module.
-		factoryFunc := runtime.FuncForPC(reflect.ValueOf(module.factory).Pointer())
-		factoryName := factoryFunc.Name()
-
-		infoMap := map[string]interface{}{
-			"name":      module.Name(),
-			"typeName":  module.typeName,
-			"goFactory": factoryName,
-			"pos":       relPos,
-			"variant":   module.variant.name,
+	for resultCh := range pending {
+		result := <-resultCh
+		if result.panic != nil {
+			panic(result.panic)
 		}
-		err = headerTemplate.Execute(buf, infoMap)
-		if err != nil {
-			return err
+		if result.err != nil {
+			return result.err
 		}
-
-		err = nw.Comment(buf.String())
+		_, err = nw.writer.WriteString(result.buf.String())
+		nw.justDidBlankLine = result.justDidBlankLine
+		result.buf.Reset()
+		moduleActionsBufferPool.Put(result.buf)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = nw.BlankLine()
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		err = c.writeLocalBuildActions(nw, &module.actionDefs)
-		if err != nil {
-			return err
-		}
+// renderModuleActions renders the header comment and build actions of a module into a buffer
+// from moduleActionsBufferPool.
+func (c *Context) renderModuleActions(module *moduleInfo,
+	headerTemplate *template.Template) (result moduleActionsResult) {
 
-		err = nw.BlankLine()
-		if err != nil {
-			return err
+	defer func() {
+		if r := recover(); r != nil {
+			result.panic = r
 		}
+	}()
+
+	result.buf = moduleActionsBufferPool.Get().(*bytes.Buffer)
+	nw := newNinjaWriter(result.buf)
+
+	// In order to make the bootstrap build manifest independent of the
+	// build dir we need to output the Blueprints file locations in the
+	// comments as paths relative to the source directory.
+	relPos := module.pos
+	relPos.Filename = module.relBlueprintsFile
+
+	// Get the name and location of the factory function for the module.
+	factoryFunc := runtime.FuncForPC(reflect.ValueOf(module.factory).Pointer())
+	factoryName := factoryFunc.Name()
+
+	infoMap := map[string]interface{}{
+		"name":      module.Name(),
+		"typeName":  module.typeName,
+		"goFactory": factoryName,
+		"pos":       relPos,
+		"variant":   module.variant.name,
+	}
+	header := &strings.Builder{}
+	if result.err = headerTemplate.Execute(header, infoMap); result.err != nil {
+		return result
 	}
 
-	return nil
+	if result.err = nw.Comment(header.String()); result.err != nil {
+		return result
+	}
+
+	if result.err = nw.BlankLine(); result.err != nil {
+		return result
+	}
+
+	if result.err = c.writeLocalBuildActions(nw, &module.actionDefs); result.err != nil {
+		return result
+	}
+
+	if result.err = nw.BlankLine(); result.err != nil {
+		return result
+	}
+
+	result.justDidBlankLine = nw.justDidBlankLine
+	return result
 }
 
 func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
@@ -3999,7 +5939,12 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 
 	buf := bytes.NewBuffer(nil)
 
-	for _, info := range c.singletonInfo {
+	singletons := c.singletonInfo
+	if c.phonyInfo != nil {
+		singletons = append(singletons[:len(singletons):len(singletons)], c.phonyInfo)
+	}
+
+	for _, info := range singletons {
 		if len(info.actionDefs.variables)+len(info.actionDefs.rules)+len(info.actionDefs.buildDefs) == 0 {
 			continue
 		}
@@ -4147,6 +6092,10 @@ func (p panicError) Error() string {
 	return fmt.Sprintf("panic in %s\n%s\n%s\n", p.in, p.panic, p.stack)
 }
 
+func (p panicError) Is(target error) bool {
+	return target == ErrPanic
+}
+
 func (p *panicError) addIn(in string) {
 	p.in += " in " + in
 }
@@ -16,15 +16,27 @@ package blueprint
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/pathtools"
 )
 
 type Walker interface {
@@ -476,6 +488,70 @@ func createTestMutator(ctx TopDownMutatorContext) {
 	})
 }
 
+type restrictedModule struct {
+	barModule
+}
+
+func newRestrictedModule() (Module, []interface{}) {
+	m := &restrictedModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (r *restrictedModule) CheckIncomingDependency(tag DependencyTag, from Module) error {
+	if _, ok := from.(*fooModule); ok {
+		return fmt.Errorf("foo_module may not depend on restricted_module")
+	}
+	return nil
+}
+
+func TestCheckIncomingDependency(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["C"],
+			}
+
+			bar_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			restricted_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterModuleType("restricted_module", newRestrictedModule)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+
+	expectedErrs := []string{
+		`Blueprints:2:4: module "A": dependency on module "C" rejected: foo_module may not depend on restricted_module`,
+	}
+	var gotErrs []string
+	for _, err := range errs {
+		gotErrs = append(gotErrs, err.Error())
+	}
+	if !reflect.DeepEqual(gotErrs, expectedErrs) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", expectedErrs, gotErrs)
+	}
+}
+
 func TestWalkFileOrder(t *testing.T) {
 	// Run the test once to see how long it normally takes
 	start := time.Now()
@@ -608,16 +684,19 @@ func TestParseFailsForModuleWithoutName(t *testing.T) {
 }
 
 func Test_findVariant(t *testing.T) {
+	ctx := NewContext()
+	newVariationMap := ctx.variationMaps.newVariationMap
+
 	module := &moduleInfo{
 		variant: variant{
 			name: "normal_local",
-			variations: variationMap{
+			variations: newVariationMap(map[string]string{
 				"normal": "normal",
 				"local":  "local",
-			},
-			dependencyVariations: variationMap{
+			}),
+			dependencyVariations: newVariationMap(map[string]string{
 				"normal": "normal",
-			},
+			}),
 		},
 	}
 
@@ -676,9 +755,9 @@ func Test_findVariant(t *testing.T) {
 				&moduleInfo{
 					variant: variant{
 						name: "normal",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"normal": "normal",
-						},
+						}),
 					},
 				},
 			),
@@ -694,19 +773,19 @@ func Test_findVariant(t *testing.T) {
 				alias{
 					variant: variant{
 						name: "normal",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"normal": "normal",
-						},
+						}),
 					},
 					target: 1,
 				},
 				&moduleInfo{
 					variant: variant{
 						name: "normal_a",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"normal": "normal",
 							"a":      "a",
-						},
+						}),
 					},
 				},
 			),
@@ -722,10 +801,10 @@ func Test_findVariant(t *testing.T) {
 				&moduleInfo{
 					variant: variant{
 						name: "normal_a",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"normal": "normal",
 							"a":      "a",
-						},
+						}),
 					},
 				},
 			),
@@ -741,15 +820,15 @@ func Test_findVariant(t *testing.T) {
 				&moduleInfo{
 					variant: variant{
 						name:       "",
-						variations: nil,
+						variations: variationMap{},
 					},
 				},
 				&moduleInfo{
 					variant: variant{
 						name: "far",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"far": "far",
-						},
+						}),
 					},
 				},
 			),
@@ -765,28 +844,28 @@ func Test_findVariant(t *testing.T) {
 				alias{
 					variant: variant{
 						name: "far",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"far": "far",
-						},
+						}),
 					},
 					target: 2,
 				},
 				&moduleInfo{
 					variant: variant{
 						name: "far_a",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"far": "far",
 							"a":   "a",
-						},
+						}),
 					},
 				},
 				&moduleInfo{
 					variant: variant{
 						name: "far_b",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"far": "far",
 							"b":   "b",
-						},
+						}),
 					},
 				},
 			),
@@ -802,19 +881,19 @@ func Test_findVariant(t *testing.T) {
 				alias{
 					variant: variant{
 						name: "far",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"far": "far",
-						},
+						}),
 					},
 					target: 1,
 				},
 				&moduleInfo{
 					variant: variant{
 						name: "far_a",
-						variations: variationMap{
+						variations: newVariationMap(map[string]string{
 							"far": "far",
 							"a":   "a",
-						},
+						}),
 					},
 				},
 			),
@@ -826,7 +905,7 @@ func Test_findVariant(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := findVariant(module, tt.possibleDeps, tt.variations, tt.far, tt.reverse)
+			got, _ := ctx.findVariant(module, tt.possibleDeps, tt.variations, tt.far, tt.reverse)
 			if g, w := got == nil, tt.want == "nil"; g != w {
 				t.Fatalf("findVariant() got = %v, want %v", got, tt.want)
 			}
@@ -841,7 +920,7 @@ func Test_findVariant(t *testing.T) {
 
 func Test_parallelVisit(t *testing.T) {
 	addDep := func(from, to *moduleInfo) {
-		from.directDeps = append(from.directDeps, depInfo{to, nil})
+		from.directDeps = append(from.directDeps, depInfo{to, nil, nil})
 		from.forwardDeps = append(from.forwardDeps, to)
 		to.reverseDeps = append(to.reverseDeps, from)
 	}
@@ -869,7 +948,7 @@ func Test_parallelVisit(t *testing.T) {
 	addDep(moduleB, moduleC)
 
 	t.Run("no modules", func(t *testing.T) {
-		errs := parallelVisit(nil, bottomUpVisitorImpl{}, 1,
+		errs := parallelVisit(context.Background(), nil, bottomUpVisitorImpl{}, 1,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				panic("unexpected call to visitor")
 			})
@@ -879,7 +958,7 @@ func Test_parallelVisit(t *testing.T) {
 	})
 	t.Run("bottom up", func(t *testing.T) {
 		order := ""
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 1,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 1,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				order += module.group.name
 				return false
@@ -893,7 +972,7 @@ func Test_parallelVisit(t *testing.T) {
 	})
 	t.Run("pause", func(t *testing.T) {
 		order := ""
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 1,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 1,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				if module == moduleC {
 					// Pause module C on module D
@@ -913,7 +992,7 @@ func Test_parallelVisit(t *testing.T) {
 	})
 	t.Run("cancel", func(t *testing.T) {
 		order := ""
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 1,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 1,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				order += module.group.name
 				// Cancel in module B
@@ -928,7 +1007,7 @@ func Test_parallelVisit(t *testing.T) {
 	})
 	t.Run("pause and cancel", func(t *testing.T) {
 		order := ""
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 1,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 1,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				if module == moduleC {
 					// Pause module C on module D
@@ -949,7 +1028,7 @@ func Test_parallelVisit(t *testing.T) {
 	})
 	t.Run("parallel", func(t *testing.T) {
 		order := ""
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 3,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 3,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				order += module.group.name
 				return false
@@ -963,7 +1042,7 @@ func Test_parallelVisit(t *testing.T) {
 	})
 	t.Run("pause existing", func(t *testing.T) {
 		order := ""
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 3,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 3,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				if module == moduleA {
 					// Pause module A on module B (an existing dependency)
@@ -982,7 +1061,7 @@ func Test_parallelVisit(t *testing.T) {
 		}
 	})
 	t.Run("cycle", func(t *testing.T) {
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 3,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 3,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				if module == moduleC {
 					// Pause module C on module A (a dependency cycle)
@@ -1012,7 +1091,7 @@ func Test_parallelVisit(t *testing.T) {
 		}
 	})
 	t.Run("pause cycle", func(t *testing.T) {
-		errs := parallelVisit([]*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 3,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 3,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				if module == moduleC {
 					// Pause module C on module D
@@ -1056,7 +1135,7 @@ func Test_parallelVisit(t *testing.T) {
 			moduleD: moduleE,
 			moduleE: moduleF,
 		}
-		errs := parallelVisit([]*moduleInfo{moduleD, moduleE, moduleF, moduleG}, bottomUpVisitorImpl{}, 4,
+		errs := parallelVisit(context.Background(), []*moduleInfo{moduleD, moduleE, moduleF, moduleG}, bottomUpVisitorImpl{}, 4,
 			func(module *moduleInfo, pause chan<- pauseSpec) bool {
 				if dep, ok := pauseDeps[module]; ok {
 					unpause := make(chan struct{})
@@ -1083,4 +1162,1793 @@ func Test_parallelVisit(t *testing.T) {
 			}
 		}
 	})
+	t.Run("heavy", func(t *testing.T) {
+		light1, light2 := create("light1"), create("light2")
+		heavy1, heavy2 := create("heavy1"), create("heavy2")
+		heavy1.heavy = true
+		heavy2.heavy = true
+
+		// The visitors for both light modules and one heavy module must run at the same time,
+		// the second heavy module can only start once the first one has finished.
+		var started, activeHeavy, maxHeavy int32
+		release := make(chan struct{})
+		errs := parallelVisitWithHeavyLimit(context.Background(), []*moduleInfo{light1, light2, heavy1, heavy2}, bottomUpVisitorImpl{}, 2, 1,
+			func(module *moduleInfo, pause chan<- pauseSpec) bool {
+				if module.heavy {
+					active := atomic.AddInt32(&activeHeavy, 1)
+					defer atomic.AddInt32(&activeHeavy, -1)
+					for {
+						prev := atomic.LoadInt32(&maxHeavy)
+						if active <= prev || atomic.CompareAndSwapInt32(&maxHeavy, prev, active) {
+							break
+						}
+					}
+				}
+				if atomic.AddInt32(&started, 1) == 3 {
+					close(release)
+				}
+				select {
+				case <-release:
+				case <-time.After(10 * time.Second):
+					t.Errorf("%s: timed out waiting for 3 visitors to run at the same time", module.group.name)
+					return true
+				}
+				return false
+			})
+		if errs != nil {
+			t.Errorf("expected no errors, got %q", errs)
+		}
+		if g := atomic.LoadInt32(&started); g != 4 {
+			t.Errorf("expected 4 visitors, got %d", g)
+		}
+		if g := atomic.LoadInt32(&maxHeavy); g != 1 {
+			t.Errorf("expected at most 1 heavy visitor at a time, got %d", g)
+		}
+	})
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var visited []string
+		errs := parallelVisit(ctx, []*moduleInfo{moduleA, moduleB, moduleC}, bottomUpVisitorImpl{}, 1,
+			func(module *moduleInfo, pause chan<- pauseSpec) bool {
+				visited = append(visited, module.group.name)
+				if module == moduleC {
+					cancel()
+				}
+				return false
+			})
+		if want := []string{"C"}; !reflect.DeepEqual(visited, want) {
+			t.Errorf("expected visited %q, got %q", want, visited)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrCanceled) || !errors.Is(errs[0], context.Canceled) {
+			t.Errorf("expected a single ErrCanceled error, got %q", errs)
+		}
+	})
+}
+
+var shardTestPctx = NewPackageContext("github.com/google/blueprint/shard_test")
+
+type shardTestModule struct {
+	SimpleName
+}
+
+func newShardTestModule() (Module, []interface{}) {
+	m := &shardTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *shardTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{ctx.ModuleName()},
+	})
+}
+
+func TestWriteBuildFileSharded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := func(fs map[string][]byte) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("shard_module", newShardTestModule)
+		ctx.MockFileSystem(fs)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors:")
+			for _, err := range errs {
+				t.Errorf("  %s", err)
+			}
+			t.FailNow()
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFileSharded(buf, dir); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	fs := map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["*"]
+			shard_module { name: "root" }
+		`),
+		"a/Blueprints": []byte(`
+			subdirs = ["*"]
+			shard_module { name: "a" }
+		`),
+		"a/b/Blueprints":   []byte(`shard_module { name: "ab" }`),
+		"c/Blueprints":     []byte(`shard_module { name: "c" }`),
+		"_root/Blueprints": []byte(`shard_module { name: "underscore_root" }`),
+	}
+
+	// Write a file that wasn't written by WriteBuildFileSharded, which should be left alone.
+	other := filepath.Join(dir, "other.ninja")
+	if err := ioutil.WriteFile(other, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := run(fs)
+	for _, shard := range []string{"_root.ninja", "__root.ninja", "a.ninja", "c.ninja"} {
+		if !strings.Contains(manifest, "subninja "+filepath.Join(dir, shard)+"\n") {
+			t.Errorf("missing subninja for %s in:\n%s", shard, manifest)
+		}
+	}
+	if strings.Contains(manifest, "build ") {
+		t.Errorf("unexpected build statement in main manifest:\n%s", manifest)
+	}
+
+	shardContents := func(shard string) string {
+		t.Helper()
+		data, err := ioutil.ReadFile(filepath.Join(dir, shard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	a := shardContents("a.ninja")
+	if !strings.Contains(a, "build a: phony\n") || !strings.Contains(a, "build ab: phony\n") {
+		t.Errorf("expected a.ninja to contain modules a and ab, got:\n%s", a)
+	}
+	if c := shardContents("c.ninja"); !strings.Contains(c, "build c: phony\n") || strings.Contains(c, "build a:") {
+		t.Errorf("expected c.ninja to only contain module c, got:\n%s", c)
+	}
+	if root := shardContents("_root.ninja"); !strings.Contains(root, "build root: phony\n") ||
+		strings.Contains(root, "build underscore_root:") {
+		t.Errorf("expected _root.ninja to only contain module root, got:\n%s", root)
+	}
+	if u := shardContents("__root.ninja"); !strings.Contains(u, "build underscore_root: phony\n") {
+		t.Errorf("expected __root.ninja to contain module underscore_root, got:\n%s", u)
+	}
+
+	// Unchanged shards should not be rewritten, and shards that are no longer needed should be
+	// removed.
+	before, err := os.Stat(filepath.Join(dir, "a.ninja"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := before.ModTime().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.ninja"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	fs["c/Blueprints"] = nil
+	run(fs)
+	after, err := os.Stat(filepath.Join(dir, "a.ninja"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(old) {
+		t.Errorf("expected unchanged shard not to be rewritten")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.ninja")); !os.IsNotExist(err) {
+		t.Errorf("expected stale shard to be removed, got %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("expected file not written by WriteBuildFileSharded to be kept, got %v", err)
+	}
+}
+
+type disableableTestModule struct {
+	fooModule
+	properties struct {
+		Enabled *bool
+	}
+	generated bool
+}
+
+func newDisableableTestModule() (Module, []interface{}) {
+	m := &disableableTestModule{}
+	return m, []interface{}{&m.fooModule.properties, &m.properties, &m.SimpleName.Properties}
+}
+
+func (m *disableableTestModule) BlueprintEnabled() bool {
+	return m.properties.Enabled == nil || *m.properties.Enabled
+}
+
+func (m *disableableTestModule) GenerateBuildActions(ModuleContext) {
+	m.generated = true
+}
+
+func TestDisabledModules(t *testing.T) {
+	run := func(t *testing.T, behavior DisabledDependencyBehavior) (*Context, []error) {
+		ctx := NewContext()
+		ctx.SetDisabledDependencyBehavior(behavior)
+		ctx.RegisterModuleType("disableable_module", newDisableableTestModule)
+		ctx.RegisterBottomUpMutator("disable", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "C" {
+				ctx.Disable()
+			}
+		})
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				disableable_module {
+					name: "A",
+					deps: ["B", "C", "D"],
+				}
+
+				disableable_module {
+					name: "B",
+					enabled: false,
+				}
+
+				disableable_module {
+					name: "C",
+				}
+
+				disableable_module {
+					name: "D",
+				}
+
+				disableable_module {
+					name: "E",
+					enabled: false,
+					deps: ["C"],
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.PrepareBuildActions(nil)
+		return ctx, errs
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, errs := run(t, DisabledDependencyError)
+		want := []string{
+			`Blueprints:2:5: module "A": depends on disabled module "B"`,
+			`Blueprints:2:5: module "A": depends on disabled module "C"`,
+		}
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		ctx, errs := run(t, DisabledDependencyPrune)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		module := func(name string) *disableableTestModule {
+			return ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*disableableTestModule)
+		}
+
+		var deps []string
+		ctx.VisitDirectDeps(module("A"), func(m Module) {
+			deps = append(deps, ctx.ModuleName(m))
+		})
+		if want := []string{"D"}; !reflect.DeepEqual(deps, want) {
+			t.Errorf("want A deps %q, got %q", want, deps)
+		}
+
+		for _, name := range []string{"A", "B", "C", "D", "E"} {
+			enabled := name == "A" || name == "D"
+			if g := ctx.ModuleEnabled(module(name)); g != enabled {
+				t.Errorf("want %s enabled %t, got %t", name, enabled, g)
+			}
+			if g := module(name).generated; g != enabled {
+				t.Errorf("want %s GenerateBuildActions called %t, got %t", name, enabled, g)
+			}
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("expected a panic for a module that isn't in the Context")
+				}
+			}()
+			ctx.ModuleEnabled(&disableableTestModule{})
+		}()
+	})
+}
+
+type writeActionsTestModule struct {
+	SimpleName
+}
+
+func newWriteActionsTestModule() (Module, []interface{}) {
+	m := &writeActionsTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *writeActionsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(shardTestPctx, "flags", "-D"+ctx.ModuleName())
+	rule := ctx.Rule(shardTestPctx, "cp", RuleParams{
+		Command: "cp $in $out $flags $extra",
+	}, "extra")
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:    rule,
+		Inputs:  []string{ctx.ModuleName() + ".in"},
+		Outputs: []string{ctx.ModuleName() + ".out"},
+		Args:    map[string]string{"extra": ctx.ModuleName()},
+	})
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{ctx.ModuleName()},
+		Inputs:  []string{ctx.ModuleName() + ".out"},
+	})
+}
+
+func TestWriteModuleActionsParallel(t *testing.T) {
+	bp := &strings.Builder{}
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(bp, "test { name: \"m%03d\" }\n", i)
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newWriteActionsTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp.String())})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	headerTemplate := template.Must(template.New("moduleHeader").Parse(moduleHeaderTemplate))
+	want := &strings.Builder{}
+	for _, module := range ctx.sortedModuleInfos() {
+		result := ctx.renderModuleActions(module, headerTemplate)
+		if result.err != nil {
+			t.Fatal(result.err)
+		}
+		want.WriteString(result.buf.String())
+	}
+
+	for i := 0; i < 5; i++ {
+		got := &strings.Builder{}
+		if err := ctx.writeAllModuleActions(newNinjaWriter(got)); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Fatalf("parallel module actions differ from serial module actions:\n%s", got.String())
+		}
+	}
+	if !strings.Contains(want.String(), "build m499.out: m.m499_.cp m499.in\n    extra = m499\n") {
+		t.Errorf("missing build statement for m499 in:\n%s", want.String())
+	}
+}
+
+type rspfileTestModule struct {
+	SimpleName
+}
+
+func newRspfileTestModule() (Module, []interface{}) {
+	m := &rspfileTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *rspfileTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := ctx.Rule(shardTestPctx, "link", RuleParams{
+		Command: "ld @$rspfile -o $out",
+	})
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:          rule,
+		Outputs:       []string{"out"},
+		Rspfile:       "out.rsp",
+		RspfileInputs: []string{"a.o", "b c.o", "$$d.o"},
+	})
+}
+
+func TestBuildRspfileInputs(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newRspfileTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test { name: "m" }`)})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	headerTemplate := template.Must(template.New("moduleHeader").Parse(moduleHeaderTemplate))
+	result := ctx.renderModuleActions(ctx.moduleGroupFromName("m", nil).modules.firstModule(), headerTemplate)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	want := "rule m.m_.link\n" +
+		"    command = ld @${rspfile} -o ${out}\n\n" +
+		"build out: m.m_.link | a.o b$ c.o $$d.o\n" +
+		"    rspfile = out.rsp\n" +
+		"    rspfile_content = a.o 'b c.o' '$$d.o'\n"
+	if got := result.buf.String(); !strings.Contains(got, want) {
+		t.Errorf("missing build statement\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestBuildRspfileInputsWithoutRspfile(t *testing.T) {
+	_, err := parseBuildParams(newLocalScope(nil, ""), &BuildParams{
+		Rule:          Phony,
+		Outputs:       []string{"out"},
+		RspfileInputs: []string{"a.o"},
+	})
+	if err == nil || err.Error() != "RspfileInputs param requires the Rspfile param" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type dyndepTestModule struct {
+	SimpleName
+}
+
+func newDyndepTestModule() (Module, []interface{}) {
+	m := &dyndepTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *dyndepTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := ctx.Rule(shardTestPctx, "fc", RuleParams{
+		Command: "fc -c $in -o $out",
+	})
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:      rule,
+		Outputs:   []string{"a.o"},
+		Inputs:    []string{"a.f90"},
+		OrderOnly: []string{"b.mod"},
+		Dyndep:    "a.dd",
+	})
+}
+
+func TestBuildDyndep(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newDyndepTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test { name: "m" }`)})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	headerTemplate := template.Must(template.New("moduleHeader").Parse(moduleHeaderTemplate))
+	result := ctx.renderModuleActions(ctx.moduleGroupFromName("m", nil).modules.firstModule(), headerTemplate)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	want := "build a.o: m.m_.fc a.f90 || b.mod a.dd\n" +
+		"    dyndep = a.dd\n"
+	if got := result.buf.String(); !strings.Contains(got, want) {
+		t.Errorf("missing build statement\nwant: %q\n got: %q", want, got)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ninja_required_version = 1.10.0\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, buf.String())
+	}
+}
+
+type validationsTestModule struct {
+	SimpleName
+}
+
+func newValidationsTestModule() (Module, []interface{}) {
+	m := &validationsTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *validationsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := ctx.Rule(shardTestPctx, "cp", RuleParams{
+		Command: "cp $in $out",
+	})
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:        rule,
+		Outputs:     []string{"a.out"},
+		Inputs:      []string{"a.in"},
+		Validations: []string{"a.lint"},
+	})
+}
+
+func TestBuildValidations(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newValidationsTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test { name: "m" }`)})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ninja_required_version = 1.11.0\n",
+		"build a.out: m.m_.cp a.in |@ a.lint\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, buf.String())
+		}
+	}
+}
+
+type msvcDepsTestModule struct {
+	SimpleName
+}
+
+func newMsvcDepsTestModule() (Module, []interface{}) {
+	m := &msvcDepsTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *msvcDepsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := ctx.Rule(shardTestPctx, "cl", RuleParams{
+		Command:        "cl /showIncludes /c $in /Fo$out",
+		Deps:           DepsMSVC,
+		MsvcDepsPrefix: "Note: including file:",
+	})
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:    rule,
+		Outputs: []string{"a.obj"},
+		Inputs:  []string{"a.cc"},
+	})
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:           rule,
+		Outputs:        []string{"b.obj"},
+		Inputs:         []string{"b.cc"},
+		MsvcDepsPrefix: "Remarque : inclusion du fichier :",
+	})
+}
+
+func TestBuildMsvcDeps(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newMsvcDepsTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test { name: "m" }`)})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"    deps = msvc\n    msvc_deps_prefix = Note: including file:\n",
+		"build b.obj: m.m_.cl b.cc\n    msvc_deps_prefix = Remarque : inclusion du fichier :\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMsvcDepsErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule RuleParams
+		err  string
+	}{
+		{
+			name: "depfile",
+			rule: RuleParams{Command: "cl", Deps: DepsMSVC, Depfile: "$out.d"},
+			err:  "Depfile can't be used with DepsMSVC, which reads the dependencies from the output of the command",
+		},
+		{
+			name: "prefix without msvc",
+			rule: RuleParams{Command: "cc", Deps: DepsGCC, MsvcDepsPrefix: "Note:"},
+			err:  "MsvcDepsPrefix requires DepsMSVC, not gcc",
+		},
+		{
+			name: "prefix without deps",
+			rule: RuleParams{Command: "cc", MsvcDepsPrefix: "Note:"},
+			err:  "MsvcDepsPrefix requires DepsMSVC, not none",
+		},
+		{
+			name: "invalid deps",
+			rule: RuleParams{Command: "cc", Deps: Deps(10)},
+			err:  "invalid Deps value 10",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := parseRuleParams(newLocalScope(nil, ""), &testCase.rule)
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("want error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+
+	_, err := parseBuildParams(newLocalScope(nil, ""), &BuildParams{
+		Rule:           Phony,
+		Outputs:        []string{"out"},
+		Deps:           DepsGCC,
+		MsvcDepsPrefix: "Note:",
+	})
+	if want := "MsvcDepsPrefix requires DepsMSVC, not gcc"; err == nil || err.Error() != want {
+		t.Errorf("want error %q, got %v", want, err)
+	}
+}
+
+func TestAnnotateBuildStatements(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newWriteActionsTestModule)
+	ctx.RegisterSingletonType("all_targets_test", newAllTargetsTestSingleton)
+	ctx.SetAnnotateBuildStatements(true)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test { name: "m" }`)})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Long paths to the test file may be wrapped onto the next comment line.
+	for _, pattern := range []string{
+		`# Generated by module "m" at\s(# )?\S*context_test.go:\d+\nbuild m.out:`,
+		`# Generated by module "m" at\s(# )?\S*context_test.go:\d+\nbuild m:`,
+		`# Generated by singleton "all_targets_test" at\s(# )?\S*context_test.go:\d+\nbuild everything:`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(buf.String()) {
+			t.Errorf("missing %q in:\n%s", pattern, buf.String())
+		}
+	}
+}
+
+func TestRegistrationAfterParse(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`foo_module { name: "A" }`),
+	})
+	if _, errs := ctx.ParseBlueprintsFiles("Blueprints", nil); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	testCases := []struct {
+		name     string
+		register func()
+	}{
+		{"RegisterModuleType", func() { ctx.RegisterModuleType("bar_module", newBarModule) }},
+		{"RegisterDefaultsModuleType", func() { ctx.RegisterDefaultsModuleType("defaults", newBarModule) }},
+		{"RegisterSingletonType", func() { ctx.RegisterSingletonType("s", func() Singleton { return nil }) }},
+		{"RegisterBottomUpMutator", func() { ctx.RegisterBottomUpMutator("m", func(BottomUpMutatorContext) {}) }},
+		{"RegisterTopDownMutator", func() { ctx.RegisterTopDownMutator("m", func(TopDownMutatorContext) {}) }},
+		{"RegisterPostMutator", func() { ctx.RegisterPostMutator("m", func(PostMutatorContext) {}) }},
+		{"RegisterDirectoryMetadata", func() { ctx.RegisterDirectoryMetadata("OWNERS", parseTestOwners) }},
+		{"SetFs", func() { ctx.SetFs(pathtools.MockFs(nil)) }},
+		{"SetModuleListFile", func() { ctx.SetModuleListFile("bplist") }},
+		{"SetFormatCheck", func() { ctx.SetFormatCheck(FormatCheckError) }},
+		{"SetGlobCacheFile", func() { ctx.SetGlobCacheFile("globs") }},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatal("expected panic")
+				}
+				want := testCase.name + " called after parsing began"
+				if err, ok := r.(error); !ok || err.Error() != want {
+					t.Errorf("expected panic %q, got %q", want, r)
+				}
+			}()
+			testCase.register()
+		})
+	}
+
+	// Settings that are only read by later phases can still be changed after parsing.
+	ctx.SetAllowMissingDependencies(true)
+	ctx.SetVisitParallelism(1)
+	ctx.SetDisabledDependencyBehavior(DisabledDependencyPrune)
+	ctx.SetMetadataFile("metadata.json")
+	ctx.SetWarningsAsErrors(WarningClassModule)
+	ctx.AddPropertyOverrides(PropertyOverride{Module: "A", Property: "foo", Value: "overridden"})
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if foo := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*fooModule).properties.Foo; foo != "overridden" {
+		t.Errorf("expected the property override added after parsing to be applied, got %q", foo)
+	}
+}
+
+func TestConcurrentContextUse(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", func() (Module, []interface{}) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		return newFooModule()
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`foo_module { name: "A" }`),
+	})
+
+	parseErrs := make(chan []error)
+	go func() {
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		parseErrs <- errs
+	}()
+
+	<-started
+	_, errs := ctx.PrepareBuildActions(nil)
+	close(release)
+
+	want := "PrepareBuildActions called while ParseFileList is still running; a Context cannot be used concurrently"
+	if len(errs) != 1 || errs[0].Error() != want {
+		t.Errorf("expected error %q, got %v", want, errs)
+	}
+
+	if errs := <-parseErrs; len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Errorf("unexpected errors after parsing finished: %v", errs)
+	}
+}
+
+type allTargetsTestSingleton struct{}
+
+func newAllTargetsTestSingleton() Singleton {
+	return &allTargetsTestSingleton{}
+}
+
+func (s *allTargetsTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{"everything"},
+		Inputs:  []string{"m.out"},
+	})
+}
+
+func TestAllTargetsWithOrigin(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newWriteActionsTestModule)
+	ctx.RegisterSingletonType("all_targets_test", newAllTargetsTestSingleton)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test { name: "m" }`)})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	got, err := ctx.AllTargetsWithOrigin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	allTargets, err := ctx.AllTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moduleOrigin := TargetInfo{OriginName: "m", OriginType: "test"}
+	singletonOrigin := TargetInfo{
+		Singleton:  true,
+		OriginName: "all_targets_test",
+		OriginType: "github.com/google/blueprint.allTargetsTestSingleton",
+	}
+	want := map[string]TargetInfo{
+		"m.out":      moduleOrigin,
+		"m":          moduleOrigin,
+		"everything": singletonOrigin,
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("expected %d targets, got %d: %v", len(want), len(got), got)
+	}
+	for target, wantInfo := range want {
+		gotInfo, ok := got[target]
+		if !ok {
+			t.Errorf("missing target %q", target)
+			continue
+		}
+		if gotInfo.Rule != allTargets[target] {
+			t.Errorf("target %q: expected rule %q from AllTargets, got %q", target, allTargets[target], gotInfo.Rule)
+		}
+		wantInfo.Rule = gotInfo.Rule
+		if gotInfo != wantInfo {
+			t.Errorf("target %q: expected %+v, got %+v", target, wantInfo, gotInfo)
+		}
+	}
+}
+
+type duplicateOutputsTestModule struct {
+	SimpleName
+	properties struct {
+		Outs []string
+	}
+}
+
+func newDuplicateOutputsTestModule() (Module, []interface{}) {
+	m := &duplicateOutputsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *duplicateOutputsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for _, out := range m.properties.Outs {
+		ctx.Build(shardTestPctx, BuildParams{
+			Rule:    Phony,
+			Outputs: []string{out},
+		})
+	}
+}
+
+type duplicateOutputsTestSingleton struct{}
+
+func (s *duplicateOutputsTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:            Phony,
+		Outputs:         []string{"s.out"},
+		ImplicitOutputs: []string{"b.out", "s.out"},
+	})
+}
+
+func TestDetectDuplicateOutputs(t *testing.T) {
+	bp := `
+		test {
+			name: "A",
+			outs: ["a.out", "b.out"],
+		}
+
+		test {
+			name: "B",
+			outs: ["a.out", "c.out"],
+		}
+	`
+
+	run := func(detect bool) []error {
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newDuplicateOutputsTestModule)
+		ctx.RegisterSingletonType("dup", func() Singleton { return &duplicateOutputsTestSingleton{} })
+		ctx.SetDetectDuplicateOutputs(detect)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		return errs
+	}
+
+	if errs := run(false); len(errs) > 0 {
+		t.Errorf("unexpected errors without SetDetectDuplicateOutputs: %v", errs)
+	}
+
+	var got []string
+	for _, err := range run(true) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`Blueprints:7:3: module "B": output "a.out" is also built by module "A"`,
+		`singleton "dup": output "b.out" is also built by module "A"`,
+		`singleton "dup": output "s.out" is listed more than once in the same build statement`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+}
+
+type propertyTagTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs   []string `test:"arch_variant"`
+		Cflags []string
+	}
+}
+
+func newPropertyTagTestModule() (Module, []interface{}) {
+	m := &propertyTagTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *propertyTagTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+func TestPropertyTagProcessor(t *testing.T) {
+	var calls []string
+	var callsLock sync.Mutex
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newPropertyTagTestModule)
+	ctx.RegisterPropertyTagProcessor("test", func(ptctx PropertyTagContext, field reflect.StructField,
+		tagValue string, value reflect.Value) error {
+
+		callsLock.Lock()
+		defer callsLock.Unlock()
+		calls = append(calls, fmt.Sprintf("%s %s %s %s=%s", ptctx.BlueprintsFile(), ptctx.ModuleType(),
+			field.Name, ptctx.PropertyName(), tagValue))
+		if _, ok := ptctx.Module().(*propertyTagTestModule); !ok {
+			t.Errorf("unexpected module %T", ptctx.Module())
+		}
+		for i := 0; i < value.Len(); i++ {
+			if value.Index(i).String() == "bad.c" {
+				return fmt.Errorf("%s is not allowed", value.Index(i).String())
+			}
+		}
+		return nil
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "A",
+				srcs: ["a.c"],
+				cflags: ["-a"],
+			}
+
+			test {
+				name: "B",
+				srcs: ["bad.c"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+
+	want := []string{"Blueprints:10:9: bad.c is not allowed"}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+
+	sort.Strings(calls)
+	wantCalls := []string{
+		"Blueprints test Srcs srcs=arch_variant",
+		"Blueprints test Srcs srcs=arch_variant",
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("incorrect processor calls\nwant: %q\n got: %q", wantCalls, calls)
+	}
+}
+
+type warningTestSingleton struct{}
+
+func (s *warningTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Warningf("checked %d modules", len(ctx.(*singletonContext).context.moduleInfo))
+}
+
+func TestWarningf(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("warn", func(ctx BottomUpMutatorContext) {
+		ctx.Warningf("%s looks suspicious", ctx.ModuleName())
+	}).Parallel()
+	ctx.RegisterSingletonType("warn", func() Singleton { return &warningTestSingleton{} })
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module { name: "B" }
+			foo_module { name: "A" }
+		`),
+	})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var got []string
+	for _, warning := range ctx.Warnings() {
+		got = append(got, warning.Error())
+	}
+	want := []string{
+		`singleton "warn": checked 2 modules`,
+		`Blueprints:2:4: module "B": B looks suspicious`,
+		`Blueprints:3:4: module "A": A looks suspicious`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect warnings\nwant: %q\n got: %q", want, got)
+	}
+}
+
+type trackPathsTestModule struct {
+	SimpleName
+	properties struct {
+		Deps   []string
+		Srcs   []string
+		Inputs []string
+		Out    string
+		Outs   []string
+	}
+}
+
+func newTrackPathsTestModule() (Module, []interface{}) {
+	m := &trackPathsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *trackPathsTestModule) Deps() []string {
+	return m.properties.Deps
+}
+
+func (m *trackPathsTestModule) IgnoreDeps() []string {
+	return nil
+}
+
+func (m *trackPathsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	var inputs []string
+	for _, src := range m.properties.Srcs {
+		inputs = append(inputs, ctx.InputFile(src))
+	}
+	for _, out := range m.properties.Outs {
+		ctx.OutputFile(out)
+	}
+	ctx.Variable(shardTestPctx, "outDir", "out")
+	ctx.Build(shardTestPctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{"$outDir/" + m.properties.Out},
+		Inputs:  append(inputs, m.properties.Inputs...),
+	})
+}
+
+func TestTrackPaths(t *testing.T) {
+	bp := `
+		test {
+			name: "A",
+			srcs: ["a.c"],
+			out: "a",
+			outs: ["out/a"],
+		}
+
+		test {
+			name: "B",
+			deps: ["A"],
+			srcs: ["b.c"],
+			inputs: ["out/a", "out/c", "c.c", "out/b"],
+			out: "b",
+			outs: ["out/b", "out/missing"],
+		}
+
+		test {
+			name: "C",
+			deps: ["B"],
+			inputs: ["out/a", "undeclared.c"],
+			out: "c",
+		}
+	`
+
+	run := func(trackPaths bool) []error {
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newTrackPathsTestModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.SetTrackPaths(trackPaths)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		return errs
+	}
+
+	if errs := run(false); len(errs) > 0 {
+		t.Errorf("unexpected errors without SetTrackPaths: %v", errs)
+	}
+
+	// C doesn't declare any files, so it is not checked.
+	var got []string
+	for _, err := range run(true) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`Blueprints:9:3: module "B": input "out/c" is not a declared input file, an output of the module or a declared output file of a direct dependency`,
+		`Blueprints:9:3: module "B": input "c.c" is not a declared input file, an output of the module or a declared output file of a direct dependency`,
+		`Blueprints:9:3: module "B": declared output file "out/missing" is not built by the module`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestMutatorStats(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTopDownMutator("create", func(ctx TopDownMutatorContext) {
+		if ctx.ModuleName() == "B" {
+			ctx.CreateModule(newFooModule, &struct{ Name string }{Name: "C"})
+		}
+	})
+	ctx.RegisterBottomUpMutator("split", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("x", "y")
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	stats := make(map[string]MutatorStats)
+	for _, s := range ctx.MutatorStats() {
+		stats[s.Name] = s
+	}
+
+	want := map[string]MutatorStats{
+		"deps": {
+			Name:              "deps",
+			Variations:        map[string]int{},
+			DependenciesAdded: 1,
+		},
+		"split": {
+			Name:            "split",
+			VariantsCreated: 6,
+			Variations:      map[string]int{"x": 3, "y": 3},
+		},
+		"create": {
+			Name:           "create",
+			Variations:     map[string]int{},
+			ModulesCreated: 1,
+		},
+	}
+	for name, w := range want {
+		if g := stats[name]; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect stats for %s\nwant: %+v\n got: %+v", name, w, g)
+		}
+	}
+}
+
+func TestVisitAllModuleGroups(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newModuleCtxTestModule)
+	ctx.RegisterBottomUpMutator("1", createAliasMutator("bar"))
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "foo",
+			}
+
+			test {
+				name: "bar",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var groups []ModuleGroupInfo
+	ctx.VisitAllModuleGroups(func(group ModuleGroupInfo) {
+		groups = append(groups, group)
+	})
+
+	if len(groups) != 2 || groups[0].Name != "bar" || groups[1].Name != "foo" {
+		t.Fatalf("expected groups bar and foo, got %v", groups)
+	}
+
+	bar := groups[0]
+	barA := ctx.moduleGroupFromName("bar", nil).moduleByVariantName("a").logicModule
+	barB := ctx.moduleGroupFromName("bar", nil).moduleByVariantName("b").logicModule
+
+	wantVariants := []ModuleVariantInfo{
+		{Module: barA, Name: "a", Variations: map[string]string{"1": "a"}},
+		{Module: barB, Name: "b", Variations: map[string]string{"1": "b"}},
+	}
+	if !reflect.DeepEqual(bar.Variants, wantVariants) {
+		t.Errorf("expected variants %v, got %v", wantVariants, bar.Variants)
+	}
+
+	wantAliases := []ModuleAliasInfo{
+		{Name: "c", Variations: map[string]string{"1": "c"}, Target: barA},
+		{Name: "d", Variations: map[string]string{"1": "d"}, Target: barB},
+		{Name: "e", Variations: map[string]string{"1": "e"}, Target: barA},
+	}
+	if !reflect.DeepEqual(bar.Aliases, wantAliases) {
+		t.Errorf("expected aliases %v, got %v", wantAliases, bar.Aliases)
+	}
+
+	foo := groups[1]
+	if len(foo.Variants) != 1 || foo.Variants[0].Name != "" || len(foo.Aliases) != 0 {
+		t.Errorf("expected foo to have a single variant and no aliases, got %v", foo)
+	}
+}
+
+type globTestModule struct {
+	SimpleName
+}
+
+func newGlobTestModule() (Module, []interface{}) {
+	m := &globTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *globTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if _, err := ctx.GlobWithDeps(ctx.ModuleDir()+"/*.c", nil); err != nil {
+		ctx.ModuleErrorf("%s", err)
+	}
+}
+
+func TestSlowestBlueprintsFiles(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("glob_module", newGlobTestModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["*"]
+			foo_module { name: "root" }
+		`),
+		"a/Blueprints": []byte(`glob_module { name: "a" }`),
+		"a/a.c":        nil,
+		"b/Blueprints": []byte(`foo_module { name: "b" }`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	times := ctx.SlowestBlueprintsFiles(0)
+	var files []string
+	for i, fileTime := range times {
+		files = append(files, fileTime.File)
+		if i > 0 && fileTime.Total() > times[i-1].Total() {
+			t.Errorf("expected files sorted by decreasing time, got %v", times)
+		}
+		if fileTime.Parse <= 0 || fileTime.Modules <= 0 {
+			t.Errorf("expected parse and module times for %s, got %+v", fileTime.File, fileTime)
+		}
+		if fileTime.File == "a/Blueprints" && fileTime.Glob <= 0 {
+			t.Errorf("expected glob time for a/Blueprints, got %+v", fileTime)
+		}
+	}
+	sort.Strings(files)
+	if g, w := files, []string{"Blueprints", "a/Blueprints", "b/Blueprints"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected files %q, got %q", w, g)
+	}
+
+	if g := ctx.SlowestBlueprintsFiles(2); len(g) != 2 || g[0] != times[0] || g[1] != times[1] {
+		t.Errorf("expected the 2 slowest files %v, got %v", times[:2], g)
+	}
+}
+
+func TestFormatCheck(t *testing.T) {
+	files := map[string][]byte{
+		"Blueprints": []byte(`subdirs = ["*"]
+`),
+		"a/Blueprints": []byte(`foo_module {
+    name: "a",
+}
+`),
+		"b/Blueprints": []byte(`foo_module {
+  name: "b",
+}
+`),
+	}
+	want := []string{`b/Blueprints:2:1: formatting differs from bpfmt, expected "    name: \"b\","`}
+
+	run := func(check FormatCheck) (*Context, []string) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.SetFormatCheck(check)
+		ctx.MockFileSystem(files)
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		return ctx, got
+	}
+
+	t.Run("none", func(t *testing.T) {
+		ctx, errs := run(FormatCheckNone)
+		if len(errs) > 0 || len(ctx.Warnings()) > 0 {
+			t.Errorf("unexpected errors %q and warnings %q", errs, ctx.Warnings())
+		}
+	})
+
+	t.Run("warning", func(t *testing.T) {
+		ctx, errs := run(FormatCheckWarning)
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors: %q", errs)
+		}
+		var got []string
+		for _, warning := range ctx.Warnings() {
+			got = append(got, warning.Error())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect warnings\nwant: %q\n got: %q", want, got)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, errs := run(FormatCheckError)
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("incorrect errors\nwant: %q\n got: %q", want, errs)
+		}
+	})
+}
+
+type heavyTestModule struct {
+	fooModule
+}
+
+func newHeavyTestModule() (Module, []interface{}) {
+	m := &heavyTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *heavyTestModule) Heavy() bool {
+	return true
+}
+
+func TestHeavyModules(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("heavy_module", newHeavyTestModule)
+	ctx.RegisterBottomUpMutator("heavy", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "B" {
+			ctx.MarkHeavy()
+		}
+	})
+	ctx.RegisterBottomUpMutator("split", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("x", "y")
+	})
+	ctx.SetVisitParallelism(1)
+	ctx.SetHeavyVisitParallelism(1)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			heavy_module { name: "A" }
+			foo_module { name: "B" }
+			foo_module { name: "C" }
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var got []string
+	for _, m := range ctx.modulesSorted {
+		if m.heavy {
+			got = append(got, m.Name()+" "+m.variant.name)
+		}
+	}
+	sort.Strings(got)
+	want := []string{"A x", "A y", "B x", "B y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect heavy modules\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestDefaultTargets(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("write_actions_module", newWriteActionsTestModule)
+	ctx.RegisterBottomUpMutator("split", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("x", "y")
+	})
+	ctx.RegisterBottomUpMutator("mark", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "B" && ctx.Module() == ctx.FinalModule() {
+			ctx.MarkDefaultTarget()
+		}
+		if ctx.ModuleName() == "A" && ctx.Module() == ctx.PrimaryModule() {
+			ctx.MarkDistTarget()
+		}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			write_actions_module { name: "A" }
+			write_actions_module { name: "B" }
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var defaults, hasDefaults, dists, optional []string
+	for _, m := range ctx.modulesSorted {
+		name := m.Name() + " " + m.variant.name
+		if ctx.IsDefaultTarget(m.logicModule) {
+			defaults = append(defaults, name)
+		}
+		if ctx.HasDefaultTargets(m.logicModule) {
+			hasDefaults = append(hasDefaults, name)
+		}
+		if ctx.IsDistTarget(m.logicModule) {
+			dists = append(dists, name)
+		}
+		for _, def := range m.actionDefs.buildDefs {
+			if def.Optional {
+				optional = append(optional, name)
+			}
+		}
+	}
+	sort.Strings(defaults)
+	sort.Strings(hasDefaults)
+	sort.Strings(dists)
+	sort.Strings(optional)
+
+	if want := []string{"A x", "A y", "B y"}; !reflect.DeepEqual(defaults, want) {
+		t.Errorf("incorrect default targets\nwant: %q\n got: %q", want, defaults)
+	}
+	if want := []string{"B x", "B y"}; !reflect.DeepEqual(hasDefaults, want) {
+		t.Errorf("incorrect modules with default targets\nwant: %q\n got: %q", want, hasDefaults)
+	}
+	if want := []string{"A x"}; !reflect.DeepEqual(dists, want) {
+		t.Errorf("incorrect dist targets\nwant: %q\n got: %q", want, dists)
+	}
+	if want := []string{"B x", "B x"}; !reflect.DeepEqual(optional, want) {
+		t.Errorf("incorrect optional build statements\nwant: %q\n got: %q", want, optional)
+	}
+}
+
+func TestOptionalDependencies(t *testing.T) {
+	var missing = make(map[string][]string)
+	var lock sync.Mutex
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("split", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			mctx.CreateVariations("x", "y")
+		}
+	})
+	ctx.RegisterBottomUpMutator("optional_deps", func(mctx BottomUpMutatorContext) {
+		if m, ok := mctx.Module().(*fooModule); ok {
+			mctx.AddOptionalDependency(m, nil, m.properties.Deps...)
+			if mctx.ModuleName() == "A" {
+				mctx.AddOptionalDependency(m, nil, "plugin_"+mctx.(*mutatorContext).module.variant.name)
+			}
+			if mctx.ModuleName() == "C" {
+				mctx.AddVariationOptionalDependency([]Variation{{"split", "x"}}, nil, "A", "missing_variation")
+			}
+		}
+	}).Parallel()
+	ctx.RegisterBottomUpMutator("check", func(mctx BottomUpMutatorContext) {
+		lock.Lock()
+		defer lock.Unlock()
+		name := mctx.ModuleName() + " " + mctx.(*mutatorContext).module.variant.name
+		missing[name] = mctx.MissingOptionalDependencies()
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B", "missing", "missing"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+
+			foo_module {
+				name: "C",
+			}
+
+			foo_module {
+				name: "plugin_y",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	want := map[string][]string{
+		"A x":       {"missing", "plugin_x"},
+		"A y":       {"missing"},
+		"B ":        nil,
+		"C ":        {"missing_variation"},
+		"plugin_y ": nil,
+	}
+	if !reflect.DeepEqual(missing, want) {
+		t.Errorf("incorrect missing optional dependencies\nwant: %q\n got: %q", want, missing)
+	}
+
+	deps := func(name, variant string) []string {
+		var ret []string
+		for _, m := range ctx.moduleGroupFromName(name, nil).modules {
+			if m := m.module(); m != nil && m.variant.name == variant {
+				for _, dep := range m.directDeps {
+					ret = append(ret, dep.module.Name()+" "+dep.module.variant.name)
+				}
+			}
+		}
+		return ret
+	}
+	if g, w := deps("A", "y"), []string{"B ", "plugin_y "}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect dependencies of A y\nwant: %q\n got: %q", w, g)
+	}
+	if g, w := deps("C", ""), []string{"A x"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("incorrect dependencies of C\nwant: %q\n got: %q", w, g)
+	}
+}
+
+func TestMissingDependencySuggestions(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "libfoo",
+				deps: ["libbarr", "libfo", "xyz"],
+			}
+
+			foo_module { name: "libbar" }
+			foo_module { name: "libbaz" }
+			foo_module { name: "libbaz2" }
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	want := []string{
+		"Blueprints:2:4: \"libfoo\" depends on undefined module \"libbarr\"\ndid you mean \"libbar\" or \"libbaz\" or \"libbaz2\"?",
+		"Blueprints:2:4: \"libfoo\" depends on undefined module \"libfo\"\ndid you mean \"libfoo\"?",
+		"Blueprints:2:4: \"libfoo\" depends on undefined module \"xyz\"",
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want errors %q, got %q", want, got)
+	}
+	if len(errs) > 0 && !errors.Is(errs[0], ErrMissingDependency) {
+		t.Errorf("expected an ErrMissingDependency, got %q", errs[0])
+	}
+}
+
+func TestModuleTypeAlias(t *testing.T) {
+	bp := `
+		foo_module {
+			name: "A",
+		}
+
+		old_foo_module {
+			name: "B",
+		}
+	`
+
+	newCtx := func() *Context {
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterModuleTypeAlias("old_foo_module", "foo_module")
+		return ctx
+	}
+
+	t.Run("warning", func(t *testing.T) {
+		ctx := newCtx()
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %q", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected dep errors: %q", errs)
+		}
+
+		b := ctx.moduleGroupFromName("B", nil).modules.firstModule().logicModule
+		if g, w := ctx.ModuleType(b), "foo_module"; g != w {
+			t.Errorf("want module type %q, got %q", w, g)
+		}
+
+		var got []string
+		for _, warning := range ctx.Warnings() {
+			got = append(got, warning.Error())
+		}
+		want := []string{`Blueprints:6:3: module type "old_foo_module" is deprecated, use "foo_module" instead`}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("want warnings %q, got %q", want, got)
+		}
+
+		if g, w := ctx.ModuleTypeAliases(), map[string]string{"old_foo_module": "foo_module"}; !reflect.DeepEqual(g, w) {
+			t.Errorf("want aliases %q, got %q", w, g)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ctx := newCtx()
+		ctx.SetWarningsAsErrors(WarningClassModuleTypeAlias)
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		var warning *Warning
+		if len(errs) != 1 || !errors.As(errs[0], &warning) || warning.Class != WarningClassModuleTypeAlias {
+			t.Errorf("expected a module type alias error, got %q", errs)
+		}
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected a panic for an alias of an unregistered module type")
+			}
+		}()
+		NewContext().RegisterModuleTypeAlias("old_bar_module", "bar_module")
+	})
+}
+
+var (
+	retainedMemoryBenchmarkPctx = NewPackageContext("github.com/google/blueprint/retained_memory_benchmark")
+	retainedMemoryBenchmarkRule = retainedMemoryBenchmarkPctx.StaticRule("cp", RuleParams{
+		Command: "cp $in $out",
+	})
+)
+
+type retainedMemoryBenchmarkModule struct {
+	SimpleName
+}
+
+func newRetainedMemoryBenchmarkModule() (Module, []interface{}) {
+	m := &retainedMemoryBenchmarkModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *retainedMemoryBenchmarkModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(retainedMemoryBenchmarkPctx, BuildParams{
+		Rule:    retainedMemoryBenchmarkRule,
+		Outputs: []string{"out/" + ctx.ModuleName() + "/" + ctx.ModuleSubDir() + "/" + ctx.ModuleName()},
+		Inputs:  []string{ctx.ModuleName()},
+	})
+}
+
+// readRSS returns the resident set size of the process in kB, or 0 if it is not available.
+func readRSS() int64 {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "VmRSS:") {
+			kb, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(line[len("VmRSS:"):]), " kB"), 10, 64)
+			return kb
+		}
+	}
+	return 0
+}
+
+// BenchmarkContextRetainedMemory runs a Context whose variants and outputs are different from
+// those of every other run, like the Contexts of a long running process that regenerates the
+// build after the source tree changed, and reports the memory that is still used by the process
+// after all of them were released.
+func BenchmarkContextRetainedMemory(b *testing.B) {
+	bp := &strings.Builder{}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(bp, "retained_memory_module { name: \"m%d\" }\n", i)
+	}
+	files := map[string][]byte{"Blueprints": []byte(bp.String())}
+
+	measure := func() (heap uint64, rss int64) {
+		runtime.GC()
+		debug.FreeOSMemory()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc, readRSS()
+	}
+
+	heapBefore, rssBefore := measure()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		run := strconv.Itoa(n)
+		ctx := NewContext()
+		ctx.RegisterModuleType("retained_memory_module", newRetainedMemoryBenchmarkModule)
+		ctx.RegisterBottomUpMutator("run", func(ctx BottomUpMutatorContext) {
+			ctx.CreateVariations("run"+run+"_a", "run"+run+"_b")
+		}).Parallel()
+		ctx.MockFileSystem(files)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			b.Fatalf("unexpected errors: %s", errs)
+		}
+	}
+	b.StopTimer()
+	heapAfter, rssAfter := measure()
+
+	b.ReportMetric(float64(int64(heapAfter)-int64(heapBefore))/float64(b.N), "retained-B/op")
+	if rssBefore > 0 {
+		b.ReportMetric(float64(rssAfter-rssBefore), "retained-RSS-kB")
+	}
 }
@@ -0,0 +1,265 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"text/scanner"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A DefaultableModule is a Module that can inherit property values from defaults modules, which
+// are modules whose type was registered with Context.RegisterDefaultsModuleType.  Before any
+// mutators run, the Context applies the property structs of each defaults module listed by
+// Defaults to every property struct of the module that has the same type, using
+// proptools.ApplyDefaults.  The result is as if the properties of the defaults modules were
+// appended in the order they are listed followed by the properties of the module itself: lists are
+// concatenated in that order, and a value set by a later defaults module overrides one set by an
+// earlier defaults module, while values set directly on the module override all of them.  The
+// "name" and "defaults" properties are never inherited.  Defaults modules may themselves be
+// defaultable, in which case their own defaults are applied to them first.
+type DefaultableModule interface {
+	Module
+
+	// Defaults returns the names of the defaults modules to apply to this module.
+	Defaults() []string
+}
+
+// SimpleDefaultable is an embeddable object that implements the Defaults method of
+// DefaultableModule using a "defaults" property.  The Properties field must be returned from the
+// module factory.
+type SimpleDefaultable struct {
+	Properties struct {
+		Defaults []string
+	}
+}
+
+func (d *SimpleDefaultable) Defaults() []string {
+	return d.Properties.Defaults
+}
+
+// defaultsFilter prevents the "name" and "defaults" properties from being inherited from defaults
+// modules.
+func defaultsFilter(property string, dstField, srcField reflect.StructField,
+	dstValue, srcValue interface{}) (bool, error) {
+
+	return property != "name" && property != "defaults", nil
+}
+
+// applyDefaults applies the properties of the defaults modules listed by every DefaultableModule.
+func (c *Context) applyDefaults() (errs []error) {
+	const (
+		notApplied = iota
+		applying
+		applied
+	)
+	state := make(map[*moduleInfo]int)
+
+	var apply func(module *moduleInfo, chain []*moduleInfo)
+	apply = func(module *moduleInfo, chain []*moduleInfo) {
+		if state[module] == applied {
+			return
+		}
+		if state[module] == applying {
+			errs = append(errs, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf("defaults cycle: %s", defaultsCycleString(chain, module)),
+					Pos: module.pos,
+				},
+				module: module,
+			})
+			return
+		}
+
+		defaultable, ok := module.logicModule.(DefaultableModule)
+		if !ok {
+			state[module] = applied
+			return
+		}
+
+		state[module] = applying
+		chain = append(chain, module)
+
+		names := defaultable.Defaults()
+		// Prepend the defaults in reverse order so that lists from the first defaults module listed
+		// end up first, and pointer values from later defaults modules take priority.
+		for i := len(names) - 1; i >= 0; i-- {
+			name := names[i]
+			pos := module.pos
+			if p, ok := module.propertyPos["defaults"]; ok {
+				pos = p
+			}
+			propertyError := func(format string, args ...interface{}) {
+				errs = append(errs, &PropertyError{
+					ModuleError: ModuleError{
+						BlueprintError: BlueprintError{
+							Err: fmt.Errorf(format, args...),
+							Pos: pos,
+						},
+						module: module,
+					},
+					property: "defaults",
+				})
+			}
+
+			group := c.moduleGroupFromName(name, module.namespace())
+			if group == nil {
+				propertyError("defaults module %q not found", name)
+				continue
+			}
+			defaults := group.modules.firstModule()
+			if !c.defaultsModuleTypes[defaults.typeName] {
+				propertyError("module %q of type %q is not a defaults module", name, defaults.typeName)
+				continue
+			}
+
+			apply(defaults, chain)
+
+			err := proptools.ApplyDefaults(module.properties, defaults.properties, defaultsFilter)
+			c.recordPropertyProvenance(module, PropertySourceDefaults, name, defaults)
+			if err != nil {
+				if propertyErr, ok := err.(*proptools.ExtendPropertyError); ok {
+					errs = append(errs, &PropertyError{
+						ModuleError: ModuleError{
+							BlueprintError: BlueprintError{
+								Err: fmt.Errorf("failed to apply defaults from %q: %s", name, propertyErr.Err),
+								Pos: pos,
+							},
+							module: module,
+						},
+						property: propertyErr.Property,
+					})
+				} else {
+					propertyError("failed to apply defaults from %q: %s", name, err)
+				}
+			}
+		}
+
+		state[module] = applied
+	}
+
+	for _, group := range c.sortedModuleGroups() {
+		for _, moduleOrAlias := range group.modules {
+			if module := moduleOrAlias.module(); module != nil {
+				apply(module, nil)
+			}
+		}
+		if len(errs) > maxErrors {
+			break
+		}
+	}
+
+	return errs
+}
+
+func defaultsCycleString(chain []*moduleInfo, module *moduleInfo) string {
+	s := ""
+	start := false
+	for _, m := range chain {
+		if m == module {
+			start = true
+		}
+		if start {
+			s += fmt.Sprintf("%q -> ", m.Name())
+		}
+	}
+	return s + fmt.Sprintf("%q", module.Name())
+}
+
+// checkRequiredProperties reports the properties tagged `blueprint:"required"` that are not set in
+// the definition of a module or of any of the defaults modules it inherits from.  Defaults modules
+// and modules created by load hooks are not checked.
+func (c *Context) checkRequiredProperties() (errs []error) {
+	var isSet func(module *moduleInfo, property string) bool
+	isSet = func(module *moduleInfo, property string) bool {
+		if _, ok := module.propertyPos[property]; ok {
+			return true
+		}
+		if defaultable, ok := module.logicModule.(DefaultableModule); ok {
+			for _, name := range defaultable.Defaults() {
+				if group := c.moduleGroupFromName(name, module.namespace()); group != nil {
+					if isSet(group.modules.firstModule(), property) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	for _, group := range c.sortedModuleGroups() {
+		for _, moduleOrAlias := range group.modules {
+			module := moduleOrAlias.module()
+			if module == nil || module.createdBy != nil || c.defaultsModuleTypes[module.typeName] {
+				continue
+			}
+
+			missing := proptools.RequiredPropertiesNotSet(func(property string) bool {
+				return isSet(module, property)
+			}, module.properties...)
+			for _, property := range missing {
+				errs = append(errs, &PropertyError{
+					ModuleError: ModuleError{
+						BlueprintError: BlueprintError{
+							Err: proptools.ErrRequiredPropertyNotSet,
+							Pos: module.pos,
+						},
+						module: module,
+					},
+					property: property,
+				})
+			}
+		}
+		if len(errs) > maxErrors {
+			break
+		}
+	}
+
+	return errs
+}
+
+// checkDeprecatedProperties reports a warning for each property tagged as deprecated, see
+// proptools.PropertyDeprecation, that is set in the definition of a module, of a defaults module
+// or by a property override.
+func (c *Context) checkDeprecatedProperties() {
+	for _, group := range c.sortedModuleGroups() {
+		for _, moduleOrAlias := range group.modules {
+			module := moduleOrAlias.module()
+			if module == nil || len(module.propertyPos) == 0 {
+				continue
+			}
+
+			warnings := proptools.DeprecatedPropertiesSet(func(property string) (scanner.Position, bool) {
+				pos, ok := module.propertyPos[property]
+				return pos, ok
+			}, module.properties...)
+			for _, warning := range warnings {
+				c.addWarning(WarningClassDeprecatedProperty, &PropertyError{
+					ModuleError: ModuleError{
+						BlueprintError: BlueprintError{
+							Err: errors.New(warning.Description()),
+							Pos: warning.Pos,
+						},
+						module: module,
+					},
+					property: warning.Property,
+				})
+			}
+		}
+	}
+}
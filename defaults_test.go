@@ -0,0 +1,331 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type defaultsTestProperties struct {
+	Srcs  []string
+	Cflag *string
+}
+
+type defaultableTestModule struct {
+	SimpleName
+	SimpleDefaultable
+	properties defaultsTestProperties
+}
+
+func newDefaultableTestModule() (Module, []interface{}) {
+	m := &defaultableTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleDefaultable.Properties}
+}
+
+func (m *defaultableTestModule) GenerateBuildActions(ModuleContext) {}
+
+func runDefaultsTest(t *testing.T, bp string) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	ctx.RegisterModuleType("test_module", newDefaultableTestModule)
+	ctx.RegisterDefaultsModuleType("test_defaults", newDefaultableTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	return ctx, errs
+}
+
+func TestDefaults(t *testing.T) {
+	ctx, errs := runDefaultsTest(t, `
+		test_defaults {
+			name: "base_defaults",
+			srcs: ["base.c"],
+			cflag: "-Obase",
+		}
+
+		test_defaults {
+			name: "d1",
+			defaults: ["base_defaults"],
+			srcs: ["d1.c"],
+		}
+
+		test_defaults {
+			name: "d2",
+			srcs: ["d2.c"],
+			cflag: "-Od2",
+		}
+
+		test_module {
+			name: "m",
+			defaults: ["d1", "d2"],
+			srcs: ["m.c"],
+		}
+
+		test_module {
+			name: "override",
+			defaults: ["d2"],
+			cflag: "-Om",
+		}
+	`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	props := func(name string) defaultsTestProperties {
+		return ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*defaultableTestModule).properties
+	}
+
+	m := props("m")
+	if g, w := m.Srcs, []string{"base.c", "d1.c", "d2.c", "m.c"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("want m srcs %q, got %q", w, g)
+	}
+	if g, w := *m.Cflag, "-Od2"; g != w {
+		t.Errorf("want m cflag %q, got %q", w, g)
+	}
+
+	if g, w := *props("override").Cflag, "-Om"; g != w {
+		t.Errorf("want override cflag %q, got %q", w, g)
+	}
+
+	if g, w := ctx.moduleGroupFromName("m", nil).name, "m"; g != w {
+		t.Errorf("want name %q, got %q", w, g)
+	}
+}
+
+func TestDefaultsErrors(t *testing.T) {
+	_, errs := runDefaultsTest(t, `
+		test_defaults {
+			name: "a",
+			defaults: ["b"],
+		}
+
+		test_defaults {
+			name: "b",
+			defaults: ["a"],
+		}
+
+		test_module {
+			name: "m",
+			defaults: ["missing", "n"],
+		}
+
+		test_module {
+			name: "n",
+		}
+	`)
+
+	want := []string{
+		`Blueprints:2:3: module "a": defaults cycle: "a" -> "b" -> "a"`,
+		`Blueprints:14:12: module "m": defaults: module "n" of type "test_module" is not a defaults module`,
+		`Blueprints:14:12: module "m": defaults: defaults module "missing" not found`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+	}
+}
+
+type requiredTestModule struct {
+	SimpleName
+	SimpleDefaultable
+	properties struct {
+		Stem *string `blueprint:"required"`
+		Link *string `blueprint:"enum:shared,static"`
+	}
+}
+
+func newRequiredTestModule() (Module, []interface{}) {
+	m := &requiredTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleDefaultable.Properties}
+}
+
+func (m *requiredTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestRequiredProperties(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test_module", newRequiredTestModule)
+	ctx.RegisterDefaultsModuleType("test_defaults", newRequiredTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`
+		test_defaults {
+			name: "stem_defaults",
+			stem: "foo",
+		}
+
+		test_defaults {
+			name: "other_defaults",
+		}
+
+		test_module {
+			name: "a",
+			stem: "a",
+		}
+
+		test_module {
+			name: "b",
+			defaults: ["other_defaults", "stem_defaults"],
+		}
+
+		test_module {
+			name: "c",
+			defaults: ["other_defaults"],
+		}
+	`)})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+
+	want := []string{
+		`Blueprints:21:3: module "c": stem: required property is not set`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestPropertyConstraintErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test_module", newRequiredTestModule)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`
+		test_module {
+			name: "a",
+			stem: "a",
+			link: "dynamic",
+		}
+	`)})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+
+	want := []string{
+		`Blueprints:5:10: module "a": link: invalid value "dynamic", must be one of "shared", "static"`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+	}
+}
+
+type deprecatedTestModule struct {
+	SimpleName
+	SimpleDefaultable
+	properties struct {
+		Srcs     []string
+		Old_srcs []string `blueprint:"deprecated=use srcs instead,removal=2022-06-30"`
+		Arch     struct {
+			Arm struct {
+				Old_flags []string `blueprint:"deprecated"`
+			}
+		}
+	}
+}
+
+func newDeprecatedTestModule() (Module, []interface{}) {
+	m := &deprecatedTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleDefaultable.Properties}
+}
+
+func (m *deprecatedTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestDeprecatedProperties(t *testing.T) {
+	run := func(t *testing.T, strict bool) (warnings, errs []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test_module", newDeprecatedTestModule)
+		ctx.RegisterDefaultsModuleType("test_defaults", newDeprecatedTestModule)
+		if strict {
+			ctx.SetWarningsAsErrors(WarningClassDeprecatedProperty)
+		}
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`
+			test_defaults {
+				name: "defaults",
+				old_srcs: ["b.c"],
+			}
+
+			test_module {
+				name: "a",
+				defaults: ["defaults"],
+				srcs: ["a.c"],
+				arch: {
+					arm: {
+						old_flags: ["-DARM"],
+					},
+				},
+			}
+		`)})
+
+		_, errs = ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx.Warnings(), errs
+	}
+
+	want := []string{
+		`Blueprints:4:13: module "defaults": old_srcs: property is deprecated and will be removed after 2022-06-30: use srcs instead`,
+		`Blueprints:13:16: module "a": arch.arm.old_flags: property is deprecated`,
+	}
+
+	t.Run("warnings", func(t *testing.T) {
+		warnings, errs := run(t, false)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		var got []string
+		for _, warning := range warnings {
+			var w *Warning
+			if !errors.As(warning, &w) || w.Class != WarningClassDeprecatedProperty {
+				t.Errorf("want a %q warning, got %q", WarningClassDeprecatedProperty, warning)
+			}
+			got = append(got, warning.Error())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect warnings:\nwant: %q\n got: %q", want, got)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		warnings, errs := run(t, true)
+		if len(warnings) > 0 {
+			t.Errorf("unexpected warnings: %v", warnings)
+		}
+		// Errors are reported in module order, warnings are sorted by position.
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if want := []string{want[1], want[0]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+		}
+	})
+}
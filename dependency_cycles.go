@@ -0,0 +1,215 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxReportedCycles is the maximum number of elementary cycles reported when a parallel mutator
+// deadlocks.
+const maxReportedCycles = 10
+
+// A DependencyCycle is a cycle in the dependency graph, or a cycle of modules in a parallel
+// mutator that are waiting for each other.  The first error reported for each cycle wraps its
+// DependencyCycle, use errors.As to get it, and Context.DependencyCycles returns all of the cycles
+// reported by the last phase.
+type DependencyCycle struct {
+	// Edges are the dependencies that form the cycle, each one from the module that the previous
+	// one depends on, and ending at the module the first one starts from.
+	Edges []DependencyCycleEdge
+}
+
+// A DependencyCycleEdge is one of the dependencies that form a DependencyCycle.
+type DependencyCycleEdge struct {
+	// Module and ModuleVariant are the name and variant of the module that depends on the
+	// dependency.
+	Module, ModuleVariant string
+
+	// Dependency and DependencyVariant are the name and variant of the dependency.
+	Dependency, DependencyVariant string
+
+	// Tag is the tag of the dependency, or nil if the module doesn't depend on the dependency
+	// directly, for example if the dependency is an earlier variant of the same module or a
+	// parallel mutator is waiting for it.
+	Tag DependencyTag
+
+	// Mutator is the name of the mutator that added the dependency, or empty if Tag is nil or
+	// the dependency was added outside of a mutator.
+	Mutator string
+
+	// EarlierVariant is true if the dependency is an earlier variant of the same module, which
+	// every variant implicitly depends on.
+	EarlierVariant bool
+}
+
+func (e DependencyCycleEdge) String() string {
+	s := fmt.Sprintf("module %q", e.Module)
+	if e.ModuleVariant != "" {
+		s += fmt.Sprintf(" variant %q", e.ModuleVariant)
+	}
+	s += fmt.Sprintf(" depends on module %q", e.Dependency)
+	if e.DependencyVariant != "" {
+		s += fmt.Sprintf(" variant %q", e.DependencyVariant)
+	}
+	return s + e.annotation()
+}
+
+// annotation returns the explanation of the edge appended to its line in dependency cycle errors.
+func (e DependencyCycleEdge) annotation() string {
+	switch {
+	case e.Tag != nil && e.Mutator != "":
+		return fmt.Sprintf(" (tag %T added by mutator %q)", e.Tag, e.Mutator)
+	case e.Tag != nil:
+		return fmt.Sprintf(" (tag %T)", e.Tag)
+	case e.EarlierVariant:
+		return " (earlier variant)"
+	}
+	return ""
+}
+
+func (c *DependencyCycle) Error() string {
+	return "encountered dependency cycle:"
+}
+
+// newDependencyCycle returns the DependencyCycle for a list of modules in which each module
+// depends on the one before it, and the first one depends on the last one.
+func newDependencyCycle(cycle []*moduleInfo) *DependencyCycle {
+	ret := &DependencyCycle{Edges: make([]DependencyCycleEdge, 0, len(cycle))}
+
+	module := cycle[0]
+	for i := len(cycle) - 1; i >= 0; i-- {
+		dep := cycle[i]
+		edge := DependencyCycleEdge{
+			Module:            module.Name(),
+			ModuleVariant:     module.variant.name,
+			Dependency:        dep.Name(),
+			DependencyVariant: dep.variant.name,
+		}
+		for _, directDep := range module.directDeps {
+			if directDep.module == dep {
+				edge.Tag = directDep.tag
+				if directDep.mutator != nil {
+					edge.Mutator = directDep.mutator.name
+				}
+				break
+			}
+		}
+		if edge.Tag == nil && module.group != nil && module.group == dep.group {
+			edge.EarlierVariant = true
+		}
+		ret.Edges = append(ret.Edges, edge)
+		module = dep
+	}
+
+	return ret
+}
+
+// DependencyCycles returns the dependency cycles reported as errors by the last call to
+// ParseBlueprintsFiles, ResolveDependencies or PrepareBuildActions.
+func (c *Context) DependencyCycles() []*DependencyCycle {
+	return c.dependencyCycles
+}
+
+// recordDependencyCycles saves the dependency cycles reported as errs for DependencyCycles.
+func (c *Context) recordDependencyCycles(errs []error) {
+	c.dependencyCycles = nil
+	for _, err := range errs {
+		var cycle *DependencyCycle
+		if errors.As(err, &cycle) {
+			c.dependencyCycles = append(c.dependencyCycles, cycle)
+		}
+	}
+}
+
+// elementaryCycles returns up to limit elementary cycles in the graph of the given nodes, using
+// Johnson's algorithm.  Each cycle starts at the node that comes first in nodes.  Edges to nodes
+// that are not in nodes are ignored.
+func elementaryCycles(nodes []*moduleInfo, edges func(*moduleInfo) []*moduleInfo,
+	limit int) [][]*moduleInfo {
+
+	index := make(map[*moduleInfo]int, len(nodes))
+	for i, node := range nodes {
+		index[node] = i
+	}
+
+	var cycles [][]*moduleInfo
+	var stack []*moduleInfo
+	blocked := make(map[*moduleInfo]bool)
+	blockedBy := make(map[*moduleInfo][]*moduleInfo)
+
+	var unblock func(node *moduleInfo)
+	unblock = func(node *moduleInfo) {
+		blocked[node] = false
+		for _, other := range blockedBy[node] {
+			if blocked[other] {
+				unblock(other)
+			}
+		}
+		blockedBy[node] = nil
+	}
+
+	// circuit finds the cycles through start that only contain nodes that come after start.
+	var circuit func(node, start *moduleInfo) bool
+	circuit = func(node, start *moduleInfo) bool {
+		found := false
+		stack = append(stack, node)
+		blocked[node] = true
+
+		for _, next := range edges(node) {
+			if len(cycles) >= limit {
+				break
+			}
+			if i, ok := index[next]; !ok || i < index[start] {
+				continue
+			}
+			if next == start {
+				cycles = append(cycles, append([]*moduleInfo(nil), stack...))
+				found = true
+			} else if !blocked[next] && circuit(next, start) {
+				found = true
+			}
+		}
+
+		if found {
+			unblock(node)
+		} else {
+			for _, next := range edges(node) {
+				if i, ok := index[next]; ok && i >= index[start] {
+					blockedBy[next] = append(blockedBy[next], node)
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		return found
+	}
+
+	for _, start := range nodes {
+		if len(cycles) >= limit {
+			break
+		}
+		for k := range blocked {
+			delete(blocked, k)
+		}
+		for k := range blockedBy {
+			delete(blockedBy, k)
+		}
+		circuit(start, start)
+	}
+
+	return cycles
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDependencyCycles(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["A"],
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) == 0 {
+		t.Fatal("expected a dependency cycle error")
+	}
+
+	var cycle *DependencyCycle
+	if !errors.As(errs[0], &cycle) {
+		t.Fatalf("expected the first error to be a *DependencyCycle, got %q", errs[0])
+	}
+	if !errors.Is(errs[0], ErrDependencyCycle) {
+		t.Errorf("expected the first error to be an ErrDependencyCycle, got %q", errs[0])
+	}
+
+	tag := walkerDepsTag{follow: true}
+	want := []DependencyCycleEdge{
+		{Module: "A", Dependency: "B", Tag: tag, Mutator: "deps"},
+		{Module: "B", Dependency: "A", Tag: tag, Mutator: "deps"},
+	}
+	if len(cycle.Edges) > 0 && cycle.Edges[0].Module == "B" {
+		want[0], want[1] = want[1], want[0]
+	}
+	if !reflect.DeepEqual(cycle.Edges, want) {
+		t.Errorf("want edges %v, got %v", want, cycle.Edges)
+	}
+
+	if g := ctx.DependencyCycles(); len(g) != 1 || g[0] != cycle {
+		t.Errorf("want DependencyCycles to return the reported cycle, got %v", g)
+	}
+
+	wantLine := `(tag blueprint.walkerDepsTag added by mutator "deps")`
+	if len(errs) < 2 || !strings.Contains(errs[1].Error(), wantLine) {
+		t.Errorf("expected the second error to contain %s, got %q", wantLine, errs)
+	}
+}
+
+func TestParallelVisitReportsAllCycles(t *testing.T) {
+	create := func(name string) *moduleInfo {
+		m := &moduleInfo{
+			group: &moduleGroup{
+				name: name,
+			},
+		}
+		m.group.modules = modulesOrAliases{m}
+		return m
+	}
+	moduleA, moduleB, moduleC, moduleD := create("A"), create("B"), create("C"), create("D")
+
+	// A and B wait for each other, and so do C and D.
+	pauseDeps := map[*moduleInfo]*moduleInfo{
+		moduleA: moduleB,
+		moduleB: moduleA,
+		moduleC: moduleD,
+		moduleD: moduleC,
+	}
+	errs := parallelVisit(context.Background(), []*moduleInfo{moduleA, moduleB, moduleC, moduleD}, bottomUpVisitorImpl{}, 4,
+		func(module *moduleInfo, pause chan<- pauseSpec) bool {
+			unpause := make(chan struct{})
+			pause <- pauseSpec{module, pauseDeps[module], unpause}
+			<-unpause
+			return false
+		})
+
+	want := []string{
+		`encountered dependency cycle`,
+		`module "B" depends on module "A"`,
+		`module "A" depends on module "B"`,
+		`encountered dependency cycle`,
+		`module "D" depends on module "C"`,
+		`module "C" depends on module "D"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("want %d errors, got %q", len(want), errs)
+	}
+	for i := range want {
+		if !strings.Contains(errs[i].Error(), want[i]) {
+			t.Errorf("expected error %s, got %s", want[i], errs[i])
+		}
+	}
+}
+
+func TestElementaryCycles(t *testing.T) {
+	var nodes []*moduleInfo
+	for _, name := range []string{"A", "B", "C", "D"} {
+		nodes = append(nodes, &moduleInfo{group: &moduleGroup{name: name}})
+	}
+
+	// Every node has an edge to every other node.
+	edges := func(node *moduleInfo) []*moduleInfo {
+		var ret []*moduleInfo
+		for _, other := range nodes {
+			if other != node {
+				ret = append(ret, other)
+			}
+		}
+		return ret
+	}
+
+	names := func(cycles [][]*moduleInfo) []string {
+		var ret []string
+		for _, cycle := range cycles {
+			s := ""
+			for _, node := range cycle {
+				s += node.Name()
+			}
+			ret = append(ret, s)
+		}
+		return ret
+	}
+
+	want := []string{"AB", "ABC", "ABCD", "ABD", "ABDC", "AC", "ACB", "ACBD", "ACD", "ACDB",
+		"AD", "ADB", "ADBC", "ADC", "ADCB", "BC", "BCD", "BD", "BDC", "CD"}
+	if g := names(elementaryCycles(nodes, edges, 100)); !reflect.DeepEqual(g, want) {
+		t.Errorf("want cycles %q, got %q", want, g)
+	}
+
+	if g := names(elementaryCycles(nodes, edges, 3)); !reflect.DeepEqual(g, want[:3]) {
+		t.Errorf("want cycles %q, got %q", want[:3], g)
+	}
+}
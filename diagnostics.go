@@ -0,0 +1,162 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// A Diagnostic is a machine readable description of an error or warning reported by a Context,
+// for tools that present errors somewhere other than a terminal.
+type Diagnostic struct {
+	// Severity is "error" for errors and "warning" for warnings.
+	Severity string `json:"severity"`
+
+	// File, Line and Column are the location in a Blueprints file that the error refers to.
+	// They are empty if the error is not associated with a location.
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+
+	// Module is the name of the module the error refers to, if any.
+	Module string `json:"module,omitempty"`
+
+	// Property is the name of the property the error refers to, if any.
+	Property string `json:"property,omitempty"`
+
+	// Message is the description of the error, without the location, module or property.
+	Message string `json:"message"`
+
+	// Phase is the method of the Context that returned the error, for example
+	// "ResolveDependencies", if it was collected by the Context, see SetCollectDiagnostics.
+	Phase string `json:"phase,omitempty"`
+}
+
+// NewDiagnostic returns a Diagnostic for an error returned by a Context.  The location, module
+// and property are extracted from BlueprintError, ModuleError, PropertyError and
+// parser.ParseError values; any other error only fills in Message.
+func NewDiagnostic(err error) Diagnostic {
+	d := newDiagnostic(err)
+	d.Severity = "error"
+	return d
+}
+
+// NewWarningDiagnostic returns a Diagnostic for a warning returned by Context.Warnings.
+func NewWarningDiagnostic(warning error) Diagnostic {
+	d := newDiagnostic(warning)
+	d.Severity = "warning"
+	return d
+}
+
+func newDiagnostic(err error) Diagnostic {
+	setPos := func(d *Diagnostic, pos scanner.Position) {
+		d.File = pos.Filename
+		d.Line = pos.Line
+		d.Column = pos.Column
+	}
+
+	var d Diagnostic
+	switch err := err.(type) {
+	case *Warning:
+		return newDiagnostic(err.Err)
+	case *PropertyError:
+		setPos(&d, err.Pos)
+		if err.module != nil {
+			d.Module = err.module.Name()
+		}
+		d.Property = err.property
+		d.Message = err.Err.Error()
+	case *ModuleError:
+		setPos(&d, err.Pos)
+		if err.module != nil {
+			d.Module = err.module.Name()
+		}
+		d.Message = err.Err.Error()
+	case *BlueprintError:
+		setPos(&d, err.Pos)
+		d.Message = err.Err.Error()
+	case *parser.ParseError:
+		setPos(&d, err.Pos)
+		d.Message = err.Err.Error()
+	default:
+		d.Message = err.Error()
+	}
+	return d
+}
+
+// WriteDiagnosticsJSON writes a JSON array containing a Diagnostic for each error in errs followed
+// by one for each warning in warnings to w.  An empty list of diagnostics is written as an empty
+// array.
+func WriteDiagnosticsJSON(w io.Writer, errs []error, warnings []error) error {
+	diagnostics := make([]Diagnostic, 0, len(errs)+len(warnings))
+	for _, err := range errs {
+		diagnostics = append(diagnostics, NewDiagnostic(err))
+	}
+	for _, warning := range warnings {
+		diagnostics = append(diagnostics, NewWarningDiagnostic(warning))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diagnostics)
+}
+
+// SetCollectDiagnostics sets whether the Context keeps a Diagnostic for every error returned by
+// ParseBlueprintsFiles, ParseFileList, ResolveDependencies and PrepareBuildActions, so that they
+// can be written out together with the warnings by WriteDiagnostics.  The errors are still
+// returned as usual.
+func (c *Context) SetCollectDiagnostics(collect bool) {
+	c.checkRegistration("SetCollectDiagnostics")
+
+	c.collectDiagnostics = collect
+}
+
+// recordDiagnostics keeps a Diagnostic for each error returned by the phase if
+// SetCollectDiagnostics was called.
+func (c *Context) recordDiagnostics(phase string, errs []error) {
+	if !c.collectDiagnostics {
+		return
+	}
+	for _, err := range errs {
+		d := NewDiagnostic(err)
+		d.Phase = phase
+		c.diagnostics = append(c.diagnostics, d)
+	}
+}
+
+// Diagnostics returns the errors collected so far, see SetCollectDiagnostics, followed by the
+// warnings returned by Warnings.
+func (c *Context) Diagnostics() []Diagnostic {
+	warnings := c.Warnings()
+	diagnostics := make([]Diagnostic, 0, len(c.diagnostics)+len(warnings))
+	diagnostics = append(diagnostics, c.diagnostics...)
+	for _, warning := range warnings {
+		diagnostics = append(diagnostics, NewWarningDiagnostic(warning))
+	}
+	return diagnostics
+}
+
+// WriteDiagnostics writes a JSON array containing the diagnostics returned by Diagnostics to w.
+func (c *Context) WriteDiagnostics(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c.Diagnostics())
+}
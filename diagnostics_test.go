@@ -0,0 +1,179 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+func TestNewDiagnostic(t *testing.T) {
+	pos := scanner.Position{Filename: "dir/Blueprints", Line: 3, Column: 5}
+	module := &moduleInfo{group: &moduleGroup{name: "foo"}}
+
+	testCases := []struct {
+		name string
+		err  error
+		want Diagnostic
+	}{
+		{
+			name: "plain",
+			err:  errors.New("oops"),
+			want: Diagnostic{Severity: "error", Message: "oops"},
+		},
+		{
+			name: "parse error",
+			err:  &parser.ParseError{Err: errors.New("oops"), Pos: pos},
+			want: Diagnostic{Severity: "error", File: "dir/Blueprints", Line: 3, Column: 5, Message: "oops"},
+		},
+		{
+			name: "blueprint error",
+			err:  &BlueprintError{Err: errors.New("oops"), Pos: pos},
+			want: Diagnostic{Severity: "error", File: "dir/Blueprints", Line: 3, Column: 5, Message: "oops"},
+		},
+		{
+			name: "module error",
+			err: &ModuleError{
+				BlueprintError: BlueprintError{Err: errors.New("oops"), Pos: pos},
+				module:         module,
+			},
+			want: Diagnostic{Severity: "error", File: "dir/Blueprints", Line: 3, Column: 5, Module: "foo", Message: "oops"},
+		},
+		{
+			name: "property error",
+			err: &PropertyError{
+				ModuleError: ModuleError{
+					BlueprintError: BlueprintError{Err: errors.New("oops"), Pos: pos},
+					module:         module,
+				},
+				property: "srcs",
+			},
+			want: Diagnostic{Severity: "error", File: "dir/Blueprints", Line: 3, Column: 5, Module: "foo", Property: "srcs",
+				Message: "oops"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := NewDiagnostic(testCase.err); got != testCase.want {
+				t.Errorf("expected %+v, got %+v", testCase.want, got)
+			}
+		})
+	}
+
+	warning := NewWarningDiagnostic(&BlueprintError{Err: errors.New("hmm"), Pos: pos})
+	want := Diagnostic{Severity: "warning", File: "dir/Blueprints", Line: 3, Column: 5, Message: "hmm"}
+	if warning != want {
+		t.Errorf("expected %+v, got %+v", want, warning)
+	}
+}
+
+func TestWriteDiagnosticsJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteDiagnosticsJSON(buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "[]\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	err := WriteDiagnosticsJSON(buf, []error{
+		&BlueprintError{
+			Err: errors.New("a <-- b"),
+			Pos: scanner.Position{Filename: "Blueprints", Line: 1, Column: 2},
+		},
+	}, []error{
+		errors.New("unused"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "severity": "error",
+    "file": "Blueprints",
+    "line": 1,
+    "column": 2,
+    "message": "a <-- b"
+  },
+  {
+    "severity": "warning",
+    "message": "unused"
+  }
+]
+`
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetCollectDiagnostics(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("warn", func(ctx BottomUpMutatorContext) {
+		ctx.Warningf("deprecated")
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+foo_module {
+    name: "a",
+    deps: ["missing"],
+}
+`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) != 1 {
+		t.Fatalf("want 1 error, got %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteDiagnostics(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "severity": "error",
+    "file": "Blueprints",
+    "line": 2,
+    "column": 1,
+    "message": "\"a\" depends on undefined module \"missing\"",
+    "phase": "ResolveDependencies"
+  },
+  {
+    "severity": "warning",
+    "file": "Blueprints",
+    "line": 2,
+    "column": 1,
+    "module": "a",
+    "message": "deprecated"
+  }
+]
+`
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"text/scanner"
+)
+
+// A DirectoryMetadataParser parses a per-directory metadata file, for example an OWNERS file.  dir
+// is the directory that contains the file relative to the root of the source tree, contents is
+// the contents of the file and parent is the metadata of the parent directory, or nil for the root
+// directory.  The returned value is the metadata of dir, and can be built on parent to implement
+// inheritance.  A directory that does not contain the file uses the metadata of its parent.
+type DirectoryMetadataParser func(dir string, contents []byte, parent interface{}) (interface{}, error)
+
+type directoryMetadataKey struct {
+	filename string
+	dir      string
+}
+
+// A directoryMetadataEntry is the metadata of a directory, which is computed once by the first
+// lookup for it.
+type directoryMetadataEntry struct {
+	once     sync.Once
+	metadata *directoryMetadata
+}
+
+type directoryMetadata struct {
+	value interface{}
+	err   error
+
+	// the metadata files that were read to compute value, which are dependencies of the modules
+	// that use it
+	files []string
+}
+
+// RegisterDirectoryMetadata registers a parser for per-directory metadata files with the given
+// name.  The metadata that applies to a module is returned by the DirectoryMetadata method of the
+// module's contexts.  Each directory is only parsed once, when the first module in it or in one of
+// its subdirectories requests the metadata.
+func (c *Context) RegisterDirectoryMetadata(filename string, parser DirectoryMetadataParser) {
+	c.checkRegistration("RegisterDirectoryMetadata")
+
+	if _, exists := c.directoryMetadataParsers[filename]; exists {
+		panic(fmt.Errorf("directory metadata file %s is already registered", filename))
+	}
+
+	if c.directoryMetadataParsers == nil {
+		c.directoryMetadataParsers = make(map[string]DirectoryMetadataParser)
+		c.directoryMetadata = make(map[directoryMetadataKey]*directoryMetadataEntry)
+	}
+	c.directoryMetadataParsers[filename] = parser
+}
+
+// lookupDirectoryMetadata returns the metadata from the files with the given name that applies to
+// dir.  directoryMetadataLock is only held to find the entry for a directory, the files are read
+// and parsed without holding it so that lookups for different directories can run in parallel.
+func (c *Context) lookupDirectoryMetadata(filename, dir string) *directoryMetadata {
+	parser, ok := c.directoryMetadataParsers[filename]
+	if !ok {
+		panic(fmt.Errorf("directory metadata file %s is not registered", filename))
+	}
+
+	return c.lookupDirectoryMetadataForDir(filename, parser, filepath.Clean(dir))
+}
+
+func (c *Context) lookupDirectoryMetadataForDir(filename string, parser DirectoryMetadataParser,
+	dir string) *directoryMetadata {
+
+	key := directoryMetadataKey{filename, dir}
+
+	c.directoryMetadataLock.Lock()
+	entry, exists := c.directoryMetadata[key]
+	if !exists {
+		entry = &directoryMetadataEntry{}
+		c.directoryMetadata[key] = entry
+	}
+	c.directoryMetadataLock.Unlock()
+
+	entry.once.Do(func() {
+		defer func() {
+			if entry.metadata == nil {
+				// The parser panicked, make later lookups report an error instead of returning
+				// nil.  The panic continues to the caller of this lookup.
+				entry.metadata = &directoryMetadata{
+					err: fmt.Errorf("parsing %s panicked", filepath.Join(dir, filename)),
+				}
+			}
+		}()
+
+		parent := &directoryMetadata{}
+		if dir != "." {
+			parent = c.lookupDirectoryMetadataForDir(filename, parser, filepath.Dir(dir))
+		}
+
+		metadata := parent
+		if parent.err == nil {
+			metadata = c.parseDirectoryMetadata(filename, parser, dir, parent)
+		}
+		entry.metadata = metadata
+	})
+	return entry.metadata
+}
+
+func (c *Context) parseDirectoryMetadata(filename string, parser DirectoryMetadataParser,
+	dir string, parent *directoryMetadata) *directoryMetadata {
+
+	path := filepath.Join(c.srcDir, dir, filename)
+	metadataError := func(err error) *directoryMetadata {
+		return &directoryMetadata{
+			err: &BlueprintError{
+				Err: err,
+				Pos: scanner.Position{Filename: path},
+			},
+			files: parent.files,
+		}
+	}
+
+	// Use a glob to check whether the file exists so that adding it causes the primary builder to
+	// rerun.
+	matches, err := c.glob(path, nil)
+	if err != nil {
+		return metadataError(err)
+	}
+	if len(matches) == 0 {
+		return parent
+	}
+
+	f, err := c.fs.Open(path)
+	if err != nil {
+		return metadataError(err)
+	}
+	defer f.Close()
+
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		return metadataError(err)
+	}
+
+	files := append(parent.files[:len(parent.files):len(parent.files)], path)
+
+	value, err := parser(dir, contents, parent.value)
+	if err != nil {
+		metadata := metadataError(err)
+		metadata.files = files
+		return metadata
+	}
+
+	return &directoryMetadata{
+		value: value,
+		files: files,
+	}
+}
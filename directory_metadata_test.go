@@ -0,0 +1,191 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// parseTestOwners appends the owners listed in contents to the owners of the parent directory.
+func parseTestOwners(dir string, contents []byte, parent interface{}) (interface{}, error) {
+	var owners []string
+	if parent != nil {
+		owners = append(owners, parent.([]string)...)
+	}
+	for _, line := range strings.Fields(string(contents)) {
+		if line == "!" {
+			return nil, errors.New("invalid owner")
+		}
+		owners = append(owners, dir+":"+line)
+	}
+	return owners, nil
+}
+
+func TestDirectoryMetadata(t *testing.T) {
+	owners := make(map[string][]string)
+	var ownersLock sync.Mutex
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterDirectoryMetadata("OWNERS", parseTestOwners)
+	ctx.RegisterBottomUpMutator("owners", func(ctx BottomUpMutatorContext) {
+		value := ctx.DirectoryMetadata("OWNERS")
+		ownersLock.Lock()
+		defer ownersLock.Unlock()
+		if value != nil {
+			owners[ctx.ModuleName()] = value.([]string)
+		}
+	}).Parallel()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["a/b", "c"]
+			foo_module { name: "root" }
+		`),
+		"OWNERS":         []byte("root"),
+		"a/OWNERS":       []byte("alice"),
+		"a/b/OWNERS":     []byte("bob"),
+		"a/b/Blueprints": []byte(`foo_module { name: "ab" }`),
+		"c/Blueprints":   []byte(`foo_module { name: "c" }`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	deps, errs := ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	wantOwners := map[string][]string{
+		"root": {".:root"},
+		"ab":   {".:root", "a:alice", "a/b:bob"},
+		"c":    {".:root"},
+	}
+	if !reflect.DeepEqual(owners, wantOwners) {
+		t.Errorf("incorrect owners\nwant: %q\n got: %q", wantOwners, owners)
+	}
+
+	sort.Strings(deps)
+	var gotDeps []string
+	for i, dep := range deps {
+		if i == 0 || deps[i-1] != dep {
+			gotDeps = append(gotDeps, dep)
+		}
+	}
+	wantDeps := []string{"OWNERS", "a/OWNERS", "a/b/OWNERS"}
+	if !reflect.DeepEqual(gotDeps, wantDeps) {
+		t.Errorf("incorrect deps\nwant: %q\n got: %q", wantDeps, gotDeps)
+	}
+
+	// The missing c/OWNERS file is a glob so that adding it reruns the primary builder.
+	foundGlob := false
+	for _, glob := range ctx.Globs() {
+		if glob.Pattern == "c/OWNERS" {
+			foundGlob = true
+		}
+	}
+	if !foundGlob {
+		t.Errorf("expected a glob for c/OWNERS")
+	}
+}
+
+func TestDirectoryMetadataError(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterDirectoryMetadata("OWNERS", parseTestOwners)
+	ctx.RegisterBottomUpMutator("owners", func(ctx BottomUpMutatorContext) {
+		ctx.DirectoryMetadata("OWNERS")
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["a/b"]
+		`),
+		"a/OWNERS":       []byte("!"),
+		"a/b/Blueprints": []byte(`foo_module { name: "ab" }`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{"a/OWNERS: invalid owner"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestDirectoryMetadataParallel(t *testing.T) {
+	started := map[string]chan struct{}{
+		"a": make(chan struct{}),
+		"b": make(chan struct{}),
+	}
+
+	ctx := NewContext()
+	// The parser for each directory waits until the parser for the other one has started, which
+	// only completes if directories are parsed in parallel.
+	ctx.RegisterDirectoryMetadata("OWNERS", func(dir string, contents []byte, parent interface{}) (interface{}, error) {
+		if ch, ok := started[dir]; ok {
+			close(ch)
+			other := map[string]string{"a": "b", "b": "a"}[dir]
+			select {
+			case <-started[other]:
+			case <-time.After(10 * time.Second):
+				return nil, errors.New("timed out waiting for " + other)
+			}
+		}
+		return parseTestOwners(dir, contents, parent)
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": nil,
+		"OWNERS":     []byte("root"),
+		"a/OWNERS":   []byte("alice"),
+		"b/OWNERS":   []byte("bob"),
+	})
+
+	var wg sync.WaitGroup
+	results := make([]*directoryMetadata, 2)
+	for i, dir := range []string{"a", "b"} {
+		i, dir := i, dir
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = ctx.lookupDirectoryMetadata("OWNERS", dir)
+		}()
+	}
+	wg.Wait()
+
+	for i, want := range [][]string{{".:root", "a:alice"}, {".:root", "b:bob"}} {
+		if results[i].err != nil {
+			t.Errorf("unexpected error: %s", results[i].err)
+		} else if !reflect.DeepEqual(results[i].value, want) {
+			t.Errorf("incorrect owners\nwant: %q\n got: %q", want, results[i].value)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/google/blueprint/pathtools"
+)
+
+// ModuleDiscovery configures how ListModulePaths finds the Blueprints files by walking the source
+// tree, see Context.SetModuleDiscovery.
+type ModuleDiscovery struct {
+	// FileName is the name of the Blueprints files to find, "Blueprints" if it is empty.
+	FileName string
+
+	// Ignore is a list of patterns of directories that are not searched, relative to the directory
+	// that is walked, for example "out" or "**/.git".  The patterns support the same syntax as
+	// pathtools.Match.
+	Ignore []string
+
+	// CacheFile is the path of a file that stores the list of Blueprints files between runs, or
+	// empty to walk the source tree on every run.  The stored list is reused if the modification
+	// times of all the searched directories are unchanged, which means that no file or directory
+	// was added to or removed from them.
+	CacheFile string
+}
+
+// SetModuleDiscovery causes ListModulePaths, and so ParseBlueprintsFiles, to find the Blueprints
+// files by walking the source tree instead of reading the module list file set by
+// SetModuleListFile.  The directories that were searched are returned by ModuleDiscoveryDeps, and
+// are included in the dependencies returned by ParseBlueprintsFiles, so that adding or removing a
+// Blueprints file causes the primary builder to rerun.  Symlinks to directories are not followed.
+func (c *Context) SetModuleDiscovery(discovery ModuleDiscovery) {
+	c.checkRegistration("SetModuleDiscovery")
+
+	if discovery.FileName == "" {
+		discovery.FileName = "Blueprints"
+	}
+	c.moduleDiscovery = &discovery
+}
+
+// ModuleDiscoveryDeps returns the directories that were searched for Blueprints files by the last
+// call to ListModulePaths if SetModuleDiscovery was called, or nil otherwise.
+func (c *Context) ModuleDiscoveryDeps() []string {
+	return append([]string(nil), c.moduleDiscoveryDeps...)
+}
+
+// moduleDiscoveryCacheVersion must be incremented whenever the format of the module discovery
+// cache file changes.
+const moduleDiscoveryCacheVersion = 1
+
+type moduleDiscoveryCacheFile struct {
+	Version  int
+	BaseDir  string
+	FileName string
+	Ignore   []string
+
+	Paths []string
+	Dirs  []string
+
+	// DirModTimes contains the modification time in nanoseconds of each of the Dirs, or -1 if it
+	// didn't exist.
+	DirModTimes []int64
+}
+
+// discoverModulePaths returns the Blueprints files found under baseDir, and the directories that
+// were searched for them.
+func (c *Context) discoverModulePaths(baseDir string) (paths, dirs []string, err error) {
+	discovery := c.moduleDiscovery
+
+	if paths, dirs, ok := c.cachedModulePaths(baseDir); ok {
+		return paths, dirs, nil
+	}
+
+	// The modification times are read before each directory, so that a change while it is being
+	// read invalidates the cache on the next run.
+	var modTimes []int64
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		if rel != "." {
+			for _, pattern := range discovery.Ignore {
+				if match, err := pathtools.Match(pattern, rel); err != nil {
+					return err
+				} else if match {
+					return nil
+				}
+			}
+		}
+
+		dir := filepath.Join(baseDir, rel)
+		dirs = append(dirs, dir)
+		modTimes = append(modTimes, c.globDepModTimes([]string{dir})...)
+		names, err := c.fs.ReadDirNames(dir)
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			info, err := c.fs.Lstat(path)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if err := walk(filepath.Join(rel, name)); err != nil {
+					return err
+				}
+			} else if name == discovery.FileName {
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	}
+
+	if err := walk("."); err != nil {
+		return nil, nil, fmt.Errorf("error discovering %s files: %s", discovery.FileName, err)
+	}
+
+	if discovery.CacheFile != "" {
+		if err := c.writeModuleDiscoveryCache(baseDir, paths, dirs, modTimes); err != nil {
+			return nil, nil, err
+		}
+	}
+	return paths, dirs, nil
+}
+
+// cachedModulePaths returns the Blueprints files and searched directories from the module
+// discovery cache file, if it was written for the same configuration and none of the directories
+// have changed since.
+func (c *Context) cachedModulePaths(baseDir string) (paths, dirs []string, ok bool) {
+	discovery := c.moduleDiscovery
+	if discovery.CacheFile == "" {
+		return nil, nil, false
+	}
+
+	data, err := ioutil.ReadFile(discovery.CacheFile)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	var cacheFile moduleDiscoveryCacheFile
+	if err := json.Unmarshal(data, &cacheFile); err != nil ||
+		cacheFile.Version != moduleDiscoveryCacheVersion ||
+		cacheFile.BaseDir != baseDir ||
+		cacheFile.FileName != discovery.FileName ||
+		!reflect.DeepEqual(cacheFile.Ignore, discovery.Ignore) ||
+		!reflect.DeepEqual(c.globDepModTimes(cacheFile.Dirs), cacheFile.DirModTimes) {
+		return nil, nil, false
+	}
+
+	return cacheFile.Paths, cacheFile.Dirs, true
+}
+
+// writeModuleDiscoveryCache writes the result of walking the source tree to the module discovery
+// cache file.
+func (c *Context) writeModuleDiscoveryCache(baseDir string, paths, dirs []string, modTimes []int64) error {
+	discovery := c.moduleDiscovery
+	data, err := json.Marshal(moduleDiscoveryCacheFile{
+		Version:     moduleDiscoveryCacheVersion,
+		BaseDir:     baseDir,
+		FileName:    discovery.FileName,
+		Ignore:      discovery.Ignore,
+		Paths:       paths,
+		Dirs:        dirs,
+		DirModTimes: modTimes,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that an interrupted write can't leave a
+	// truncated cache file behind.
+	tmpFile := discovery.CacheFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(discovery.CacheFile), 0777); err != nil {
+		return fmt.Errorf("error writing module discovery cache: %s", err)
+	}
+	if err := ioutil.WriteFile(tmpFile, data, 0666); err != nil {
+		return fmt.Errorf("error writing module discovery cache: %s", err)
+	}
+	if err := os.Rename(tmpFile, discovery.CacheFile); err != nil {
+		return fmt.Errorf("error writing module discovery cache: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestModuleDiscovery(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints":             []byte(`foo_module { name: "root" }`),
+		"a/Blueprints":           []byte(`foo_module { name: "a" }`),
+		"a/b/Blueprints":         []byte(`foo_module { name: "b" }`),
+		"a/b/Android.bp":         nil,
+		"a/skip/Blueprints":      []byte(`foo_module { name: "skip" }`),
+		"c/d/Blueprints":         []byte(`foo_module { name: "d" }`),
+		"out/Blueprints":         []byte(`foo_module { name: "out" }`),
+		"out/soong/x/Blueprints": []byte(`foo_module { name: "x" }`),
+	})
+	ctx.SetModuleDiscovery(ModuleDiscovery{
+		Ignore: []string{"out", "**/skip"},
+	})
+
+	deps, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+
+	var modules []string
+	ctx.VisitAllModules(func(m Module) {
+		modules = append(modules, ctx.ModuleName(m))
+	})
+	sort.Strings(modules)
+	if g, w := modules, []string{"a", "b", "d", "root"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("want modules %q, got %q", w, g)
+	}
+
+	wantDirs := []string{".", "a", "a/b", "c", "c/d"}
+	if g := ctx.ModuleDiscoveryDeps(); !reflect.DeepEqual(g, wantDirs) {
+		t.Errorf("want discovery deps %q, got %q", wantDirs, g)
+	}
+	depSet := make(map[string]bool)
+	for _, dep := range deps {
+		depSet[dep] = true
+	}
+	for _, dir := range wantDirs {
+		if !depSet[dir] {
+			t.Errorf("want dependency on %q in %q", dir, deps)
+		}
+	}
+}
+
+func TestModuleDiscoveryCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheFile := filepath.Join(t.TempDir(), "discovery.json")
+	writeFile := func(name string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setModTime := func(name string, modTime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listModulePaths := func() []string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetModuleDiscovery(ModuleDiscovery{
+			CacheFile: cacheFile,
+		})
+		paths, err := ctx.ListModulePaths(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paths
+	}
+
+	modTime := time.Now().Add(-time.Hour)
+	writeFile("Blueprints")
+	writeFile("a/Blueprints")
+	setModTime("a", modTime)
+
+	want := []string{filepath.Join(dir, "Blueprints"), filepath.Join(dir, "a", "Blueprints")}
+	if g := listModulePaths(); !reflect.DeepEqual(g, want) {
+		t.Errorf("want paths %q, got %q", want, g)
+	}
+
+	// A new file in a directory with an unchanged modification time uses the cached list.
+	writeFile("a/b/Blueprints")
+	setModTime("a", modTime)
+	if g := listModulePaths(); !reflect.DeepEqual(g, want) {
+		t.Errorf("want cached paths %q, got %q", want, g)
+	}
+
+	setModTime("a", modTime.Add(time.Minute))
+	want = append(want, filepath.Join(dir, "a", "b", "Blueprints"))
+	if g := listModulePaths(); !reflect.DeepEqual(g, want) {
+		t.Errorf("want paths %q, got %q", want, g)
+	}
+}
@@ -49,7 +49,16 @@
 //
 // The modules from the top level Blueprints file and recursively through any
 // subdirectories listed by the "subdirs" variable are read by Blueprint, and
-// their properties are stored into property structs by module type.  Once
+// their properties are stored into property structs by module type.  A
+// Blueprints file can also list other Blueprints files in its own directory to
+// read with the "build" variable, and subdirectories that may not exist with
+// the "optional_subdirs" variable.  The values of these variables in each file
+// are available through Context.FileInclusions and ReadFileInclusions.  A
+// Blueprints file can share variable assignments with other files with an
+// include directive, for example include "build/defs.bpi", which adds the
+// assignments of the file at the given path relative to its own directory to
+// its scope.  Included files may include other files, but may not define
+// modules, and are dependencies of the generated Ninja file.  Once
 // all modules are read, Blueprint calls any registered Mutators, in
 // registration order.  Mutators can visit each module top-down or bottom-up,
 // and modify them as necessary.  Common modifications include setting
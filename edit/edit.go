@@ -0,0 +1,378 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package edit provides structured edits of the module definitions in Blueprints files.  It
+// operates on the parsed syntax tree, so comments and formatting outside of the edited properties
+// are preserved when the file is printed.
+//
+// Property names passed to the Module methods are fully qualified, with the names of nested maps
+// separated by dots, for example "arch.arm.deps".
+package edit
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+)
+
+// File is a parsed Blueprints file that can be edited.
+type File struct {
+	name     string
+	file     *parser.File
+	modified bool
+}
+
+// Parse parses the Blueprints file with the given name from r.
+func Parse(filename string, r io.Reader) (*File, []error) {
+	file, errs := parser.Parse(filename, r, parser.NewScope(nil))
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &File{name: filename, file: file}, nil
+}
+
+// ReadFile reads and parses the Blueprints file with the given name.
+func ReadFile(filename string) (*File, []error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return Parse(filename, strings.NewReader(string(data)))
+}
+
+// Name returns the name of the file.
+func (f *File) Name() string {
+	return f.name
+}
+
+// AST returns the syntax tree of the file.  Changes made directly to the syntax tree are not
+// reflected in Modified.
+func (f *File) AST() *parser.File {
+	return f.file
+}
+
+// Modified returns true if any edit has changed the file since it was parsed.
+func (f *File) Modified() bool {
+	return f.modified
+}
+
+// Print returns the formatted contents of the file, including any edits.
+func (f *File) Print() ([]byte, error) {
+	return parser.Print(f.file)
+}
+
+// WriteFile writes the formatted contents of the file, including any edits, to the file with the
+// given name.
+func (f *File) WriteFile(filename string) error {
+	data, err := f.Print()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// Modules returns the modules in the file that have a literal name property, in the order they
+// are defined.
+func (f *File) Modules() []*Module {
+	var modules []*Module
+	for _, def := range f.file.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		if prop, found := module.GetProperty("name"); found && prop.Value.Type() == parser.StringType {
+			if name, ok := prop.Value.Eval().(*parser.String); ok {
+				modules = append(modules, &Module{file: f, module: module, name: name.Value})
+			}
+		}
+	}
+	return modules
+}
+
+// Module returns the module in the file with the given name, or nil if there is no such module.
+func (f *File) Module(name string) *Module {
+	for _, module := range f.Modules() {
+		if module.name == name {
+			return module
+		}
+	}
+	return nil
+}
+
+// RenameModuleTypes changes the type of every module definition in the file whose type is a key of
+// renames to the corresponding value, for example to replace the aliases returned by
+// blueprint.Context.ModuleTypeAliases with the names of the module types they refer to.  Modules
+// without a literal name are renamed too.  It returns true if any module was renamed.
+func (f *File) RenameModuleTypes(renames map[string]string) (modified bool) {
+	for _, def := range f.file.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		if newType, ok := renames[module.Type]; ok && newType != module.Type {
+			module.Type = newType
+			modified = true
+		}
+	}
+
+	f.modified = f.modified || modified
+	return modified
+}
+
+// Module is a module definition in a File.
+type Module struct {
+	file   *File
+	module *parser.Module
+	name   string
+}
+
+// Name returns the name of the module.
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Type returns the module type of the module.
+func (m *Module) Type() string {
+	return m.module.Type
+}
+
+// AST returns the syntax tree of the module definition.
+func (m *Module) AST() *parser.Module {
+	return m.module
+}
+
+// Property returns the property with the given name, or nil if it is not set.
+func (m *Module) Property(property string) (*parser.Property, error) {
+	prop, _, err := m.getOrCreateProperty(property, nil)
+	return prop, err
+}
+
+// AddToList adds each of the values that is not already present to the list property with the
+// given name, creating the property if it is not set.  If the list was sorted it remains sorted.
+// It returns true if the list was modified.
+func (m *Module) AddToList(property string, values ...string) (modified bool, err error) {
+	if len(values) == 0 {
+		return false, nil
+	}
+
+	list, err := m.getOrCreateList(property, true)
+	if err != nil {
+		return false, err
+	}
+
+	wasSorted := parser.ListIsSorted(list)
+	for _, value := range values {
+		if parser.AddStringToList(list, value) {
+			modified = true
+		}
+	}
+	if modified && wasSorted {
+		parser.SortList(m.file.file, list)
+	}
+
+	m.file.modified = m.file.modified || modified
+	return modified, nil
+}
+
+// RemoveFromList removes each of the values from the list property with the given name.  It
+// returns true if the list was modified.
+func (m *Module) RemoveFromList(property string, values ...string) (modified bool, err error) {
+	list, err := m.getOrCreateList(property, false)
+	if err != nil || list == nil {
+		return false, err
+	}
+
+	for _, value := range values {
+		if parser.RemoveStringFromList(list, value) {
+			modified = true
+		}
+	}
+
+	m.file.modified = m.file.modified || modified
+	return modified, nil
+}
+
+// SortList sorts the list property with the given name, keeping comments with the values they
+// precede.  It returns true if the list was modified.
+func (m *Module) SortList(property string) (modified bool, err error) {
+	list, err := m.getOrCreateList(property, false)
+	if err != nil || list == nil || parser.ListIsSorted(list) {
+		return false, err
+	}
+
+	parser.SortList(m.file.file, list)
+
+	m.file.modified = true
+	return true, nil
+}
+
+// SetString sets the string property with the given name to value, creating the property if it is
+// not set.  It returns true if the property was modified.
+func (m *Module) SetString(property, value string) (modified bool, err error) {
+	return m.setValue(property, parser.StringType, &parser.String{Value: value},
+		func(old parser.Expression) bool {
+			return old.(*parser.String).Value == value
+		})
+}
+
+// SetBool sets the bool property with the given name to value, creating the property if it is not
+// set.  It returns true if the property was modified.
+func (m *Module) SetBool(property string, value bool) (modified bool, err error) {
+	return m.setValue(property, parser.BoolType, &parser.Bool{Value: value},
+		func(old parser.Expression) bool {
+			return old.(*parser.Bool).Value == value
+		})
+}
+
+// RemoveProperty removes the property with the given name.  It returns true if the property was
+// set.
+func (m *Module) RemoveProperty(property string) (modified bool, err error) {
+	parts, err := splitProperty(property)
+	if err != nil {
+		return false, err
+	}
+
+	parent, err := m.getMap(parts[:len(parts)-1], false)
+	if err != nil || parent == nil {
+		return false, err
+	}
+
+	modified = parent.RemoveProperty(parts[len(parts)-1])
+	m.file.modified = m.file.modified || modified
+	return modified, nil
+}
+
+func (m *Module) setValue(property string, typ parser.Type, value parser.Expression,
+	equal func(old parser.Expression) bool) (modified bool, err error) {
+
+	prop, created, err := m.getOrCreateProperty(property, value)
+	if err != nil {
+		return false, err
+	}
+
+	if !created {
+		if err := m.checkLiteral(property, prop.Value, typ); err != nil {
+			return false, err
+		}
+		if equal(prop.Value) {
+			return false, nil
+		}
+		prop.Value = value
+	}
+
+	m.file.modified = true
+	return true, nil
+}
+
+func (m *Module) getOrCreateList(property string, create bool) (*parser.List, error) {
+	var newValue parser.Expression
+	if create {
+		newValue = &parser.List{}
+	}
+
+	prop, _, err := m.getOrCreateProperty(property, newValue)
+	if err != nil || prop == nil {
+		return nil, err
+	}
+
+	if err := m.checkLiteral(property, prop.Value, parser.ListType); err != nil {
+		return nil, err
+	}
+
+	list := prop.Value.(*parser.List)
+	for _, value := range list.Values {
+		if _, ok := value.(*parser.String); !ok {
+			return nil, fmt.Errorf("expected property %s in module %s to be a list of strings, found %s",
+				property, m.name, value.Type())
+		}
+	}
+	return list, nil
+}
+
+// checkLiteral returns an error if value is not a literal of the given type.
+func (m *Module) checkLiteral(property string, value parser.Expression, typ parser.Type) error {
+	switch value.(type) {
+	case *parser.Variable:
+		return fmt.Errorf("property %s in module %s is a variable, unsupported", property, m.name)
+	case *parser.Operator:
+		return fmt.Errorf("property %s in module %s is an expression, unsupported", property, m.name)
+	}
+	if value.Type() != typ {
+		return fmt.Errorf("expected property %s in module %s to be %s, found %s",
+			property, m.name, typ, value.Type())
+	}
+	return nil
+}
+
+// getOrCreateProperty returns the property with the given name.  If it is not set and newValue is
+// not nil the property and any maps that contain it are created, and created is true.
+func (m *Module) getOrCreateProperty(property string,
+	newValue parser.Expression) (prop *parser.Property, created bool, err error) {
+
+	parts, err := splitProperty(property)
+	if err != nil {
+		return nil, false, err
+	}
+
+	parent, err := m.getMap(parts[:len(parts)-1], newValue != nil)
+	if err != nil || parent == nil {
+		return nil, false, err
+	}
+
+	name := parts[len(parts)-1]
+	if prop, found := parent.GetProperty(name); found {
+		return prop, false, nil
+	} else if newValue != nil {
+		prop = &parser.Property{Name: name, Value: newValue}
+		parent.Properties = append(parent.Properties, prop)
+		return prop, true, nil
+	}
+	return nil, false, nil
+}
+
+// getMap returns the nested map property with the given prefixes, creating it if create is true.
+// It returns nil if the map is not set and create is false.
+func (m *Module) getMap(prefixes []string, create bool) (*parser.Map, error) {
+	parent := &m.module.Map
+	for i, prefix := range prefixes {
+		if prop, found := parent.GetProperty(prefix); found {
+			mm, ok := prop.Value.Eval().(*parser.Map)
+			if !ok {
+				return nil, fmt.Errorf("expected property %s in module %s to be a map, found %s",
+					strings.Join(prefixes[:i+1], "."), m.name, prop.Value.Type())
+			}
+			parent = mm
+		} else if create {
+			mm := &parser.Map{}
+			parent.Properties = append(parent.Properties, &parser.Property{Name: prefix, Value: mm})
+			parent = mm
+		} else {
+			return nil, nil
+		}
+	}
+	return parent, nil
+}
+
+func splitProperty(property string) ([]string, error) {
+	parts := strings.Split(property, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("%q is not a valid property name", property)
+		}
+	}
+	return parts, nil
+}
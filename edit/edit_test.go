@@ -0,0 +1,314 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package edit
+
+import (
+	"strings"
+	"testing"
+)
+
+const testInput = `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+        "a",
+        "c",
+    ],
+    enabled: false,
+}
+
+var = ["x"]
+
+cc_bar {
+    name: "bar",
+    srcs: var,
+    arch: "arm",
+}
+`
+
+func TestEdit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		edit     func(m *Module) (bool, error)
+		module   string
+		modified bool
+		err      string
+		output   string
+	}{
+		{
+			name:   "add to sorted list",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.AddToList("deps", "b", "a")
+			},
+			modified: true,
+			output: `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+        "a",
+        "b",
+        "c",
+    ],
+    enabled: false,
+}
+`,
+		},
+		{
+			name:   "add existing",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.AddToList("deps", "a")
+			},
+		},
+		{
+			name:   "remove from list",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.RemoveFromList("deps", "a", "z")
+			},
+			modified: true,
+			output: `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+
+        "c",
+    ],
+    enabled: false,
+}
+`,
+		},
+		{
+			name:   "remove from missing list",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.RemoveFromList("shared_libs", "a")
+			},
+		},
+		{
+			name:   "add to nested list",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.AddToList("target.host.deps", "d")
+			},
+			modified: true,
+			output: `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+        "a",
+        "c",
+    ],
+    enabled: false,
+    target: {
+        host: {
+            deps: ["d"],
+        },
+    },
+}
+`,
+		},
+		{
+			name:   "set string",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.SetString("stem", "foo2")
+			},
+			modified: true,
+			output: `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+        "a",
+        "c",
+    ],
+    enabled: false,
+    stem: "foo2",
+}
+`,
+		},
+		{
+			name:   "set bool",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.SetBool("enabled", true)
+			},
+			modified: true,
+			output: `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+        "a",
+        "c",
+    ],
+    enabled: true,
+}
+`,
+		},
+		{
+			name:   "set bool unchanged",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.SetBool("enabled", false)
+			},
+		},
+		{
+			name:   "remove property",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.RemoveProperty("enabled")
+			},
+			modified: true,
+			output: `// Comment before foo
+cc_foo {
+    name: "foo",
+    // Comment in deps
+    deps: [
+        "a",
+        "c",
+    ],
+
+}
+`,
+		},
+		{
+			name:   "list is a variable",
+			module: "bar",
+			edit: func(m *Module) (bool, error) {
+				return m.AddToList("srcs", "b.c")
+			},
+			err: "property srcs in module bar is a variable, unsupported",
+		},
+		{
+			name:   "wrong type",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.SetString("enabled", "true")
+			},
+			err: "expected property enabled in module foo to be string, found bool",
+		},
+		{
+			name:   "prefix not a map",
+			module: "bar",
+			edit: func(m *Module) (bool, error) {
+				return m.AddToList("arch.arm.srcs", "b.c")
+			},
+			err: "expected property arch in module bar to be a map, found string",
+		},
+		{
+			name:   "invalid property name",
+			module: "foo",
+			edit: func(m *Module) (bool, error) {
+				return m.SetBool("target..enabled", true)
+			},
+			err: `"target..enabled" is not a valid property name`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			file, errs := Parse("Blueprints", strings.NewReader(testInput))
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %q", errs)
+			}
+
+			module := file.Module(testCase.module)
+			if module == nil {
+				t.Fatalf("missing module %q", testCase.module)
+			}
+
+			modified, err := testCase.edit(module)
+			if testCase.err != "" {
+				if err == nil || err.Error() != testCase.err {
+					t.Fatalf("expected error %q, got %v", testCase.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if modified != testCase.modified || file.Modified() != testCase.modified {
+				t.Errorf("expected modified %t, got %t and file.Modified() %t",
+					testCase.modified, modified, file.Modified())
+			}
+
+			if testCase.output != "" {
+				out, err := file.Print()
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				// Only compare up to the end of foo, the rest of the file is unchanged
+				got := string(out)
+				if i := strings.Index(got, "\nvar = "); i >= 0 {
+					got = got[:i]
+				}
+				if got != testCase.output {
+					t.Errorf("expected:\n%s\ngot:\n%s", testCase.output, got)
+				}
+			}
+		})
+	}
+}
+
+func TestModules(t *testing.T) {
+	file, errs := Parse("Blueprints", strings.NewReader(testInput))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var names []string
+	for _, module := range file.Modules() {
+		names = append(names, module.Type()+":"+module.Name())
+	}
+	if g, w := strings.Join(names, ","), "cc_foo:foo,cc_bar:bar"; g != w {
+		t.Errorf("expected modules %q, got %q", w, g)
+	}
+
+	if file.Module("baz") != nil {
+		t.Errorf("expected no module baz")
+	}
+}
+
+func TestRenameModuleTypes(t *testing.T) {
+	file, errs := Parse("Blueprints", strings.NewReader(testInput))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	if file.RenameModuleTypes(map[string]string{"cc_baz": "cc_qux"}) {
+		t.Errorf("expected no modules to be renamed")
+	}
+	if !file.RenameModuleTypes(map[string]string{"cc_bar": "cc_library", "cc_baz": "cc_qux"}) {
+		t.Errorf("expected a module to be renamed")
+	}
+	if !file.Modified() {
+		t.Errorf("expected the file to be modified")
+	}
+
+	out, err := file.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := string(out), strings.Replace(testInput, "cc_bar {", "cc_library {", 1); g != w {
+		t.Errorf("expected:\n%s\ngot:\n%s", w, g)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import "errors"
+
+// These errors describe the kind of some of the errors reported by the Context.  They are never
+// returned directly, use errors.Is to check whether an error is of one of these kinds, and
+// errors.As to get the *BlueprintError, *ModuleError or *PropertyError that holds its position:
+//
+//	for _, err := range errs {
+//		var blueprintErr *blueprint.BlueprintError
+//		if errors.Is(err, blueprint.ErrMissingDependency) && errors.As(err, &blueprintErr) {
+//			fmt.Println("missing dependency at", blueprintErr.Pos)
+//		}
+//	}
+var (
+	// ErrUnknownModuleType is the kind of the error reported for a module definition whose type
+	// was not registered.
+	ErrUnknownModuleType = errors.New("unknown module type")
+
+	// ErrDuplicateModule is the kind of the error reported when two modules have the same name.
+	ErrDuplicateModule = errors.New("duplicate module")
+
+	// ErrMissingDependency is the kind of the error reported when a module depends on a module
+	// that doesn't exist.
+	ErrMissingDependency = errors.New("missing dependency")
+
+	// ErrMissingVariant is the kind of the error reported when a module depends on a variant of a
+	// module that doesn't exist.
+	ErrMissingVariant = errors.New("missing variant")
+
+	// ErrDisabledDependency is the kind of the error reported when an enabled module depends on a
+	// disabled module.
+	ErrDisabledDependency = errors.New("dependency on disabled module")
+
+	// ErrNotVisible is the kind of the error reported when a module depends on a module whose
+	// visibility rules don't allow it, see VisibilityModule.
+	ErrNotVisible = errors.New("dependency not visible")
+
+	// ErrDependencyCycle is the kind of the errors reported for a cycle in the dependency graph,
+	// including a module that depends on itself.
+	ErrDependencyCycle = errors.New("dependency cycle")
+
+	// ErrPanic is the kind of the error reported when a module, mutator or singleton panics.
+	ErrPanic = errors.New("panic")
+)
+
+// kindError wraps an error to make errors.Is match its kind, without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func errorWithKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
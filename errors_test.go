@@ -0,0 +1,153 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	testCases := []struct {
+		name    string
+		bp      string
+		mutator func(BottomUpMutatorContext)
+		kind    error
+		line    int
+	}{
+		{
+			name: "unknown module type",
+			bp: `
+				unknown_module {
+					name: "A",
+				}
+			`,
+			kind: ErrUnknownModuleType,
+			line: 2,
+		},
+		{
+			name: "duplicate module",
+			bp: `
+				provider_module {
+					name: "A",
+				}
+
+				provider_module {
+					name: "A",
+				}
+			`,
+			kind: ErrDuplicateModule,
+			line: 6,
+		},
+		{
+			name: "missing dependency",
+			bp: `
+				provider_module {
+					name: "A",
+					deps: ["B"],
+				}
+			`,
+			kind: ErrMissingDependency,
+			line: 2,
+		},
+		{
+			name: "dependency cycle",
+			bp: `
+				provider_module {
+					name: "A",
+					deps: ["A"],
+				}
+			`,
+			kind: ErrDependencyCycle,
+			line: 2,
+		},
+		{
+			name: "panic",
+			bp: `
+				provider_module {
+					name: "A",
+				}
+			`,
+			mutator: func(ctx BottomUpMutatorContext) {
+				panic("oops")
+			},
+			kind: ErrPanic,
+		},
+	}
+
+	kinds := []error{ErrUnknownModuleType, ErrDuplicateModule, ErrMissingDependency,
+		ErrMissingVariant, ErrDisabledDependency, ErrDependencyCycle, ErrPanic}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("provider_module", newProviderTestModule)
+			ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+			if testCase.mutator != nil {
+				ctx.RegisterBottomUpMutator("test", testCase.mutator)
+			}
+			ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(testCase.bp)})
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) == 0 {
+				_, errs = ctx.ResolveDependencies(nil)
+			}
+			if len(errs) == 0 {
+				t.Fatal("missing error")
+			}
+			err := errs[0]
+
+			for _, kind := range kinds {
+				if g, w := errors.Is(err, kind), kind == testCase.kind; g != w {
+					t.Errorf("errors.Is(%q, %q) = %v, want %v", err, kind, g, w)
+				}
+			}
+
+			if testCase.line != 0 {
+				var blueprintErr *BlueprintError
+				if !errors.As(err, &blueprintErr) {
+					t.Fatalf("errors.As(%q) found no BlueprintError", err)
+				}
+				if blueprintErr.Pos.Line != testCase.line {
+					t.Errorf("expected error on line %d, got %s", testCase.line, blueprintErr.Pos)
+				}
+			}
+		})
+	}
+}
+
+func TestPropertyErrorAs(t *testing.T) {
+	underlying := errors.New("bad value")
+	var err error = &PropertyError{
+		ModuleError: ModuleError{
+			BlueprintError: BlueprintError{Err: underlying},
+		},
+		property: "foo",
+	}
+
+	var moduleErr *ModuleError
+	if !errors.As(err, &moduleErr) || moduleErr != &err.(*PropertyError).ModuleError {
+		t.Errorf("errors.As did not find the embedded ModuleError")
+	}
+
+	var blueprintErr *BlueprintError
+	if !errors.As(err, &blueprintErr) || blueprintErr != &err.(*PropertyError).BlueprintError {
+		t.Errorf("errors.As did not find the embedded BlueprintError")
+	}
+
+	if !errors.Is(err, underlying) {
+		t.Errorf("errors.Is did not find the underlying error")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/pathtools"
+)
+
+// FileInclusions are the values of the variables in a Blueprints file that include other
+// Blueprints files:
+//
+//	build = ["a.bp", "b*.bp"]          globs relative to the directory of the Blueprints file that
+//	                                   match other Blueprints files to parse.  The entries can't
+//	                                   contain a "/".
+//	subdirs = ["a", "b/*"]             globs relative to the directory of the Blueprints file that
+//	                                   match subdirectories containing Blueprints files
+//	optional_subdirs = ["c"]           like subdirs, but the subdirectories don't have to exist
+//
+// Only build is interpreted by the Context, the Blueprints files to parse are otherwise listed in
+// the module list file.  The values are available to tools that manipulate directory inclusion,
+// for example to keep subdirs consistent with the module list file.
+type FileInclusions struct {
+	// Build is the value of the build variable, and BuildPos is the position of its assignment.
+	Build    []string
+	BuildPos scanner.Position
+
+	// Subdirs is the value of the subdirs variable, and SubdirsPos is the position of its
+	// assignment.
+	Subdirs    []string
+	SubdirsPos scanner.Position
+
+	// OptionalSubdirs is the value of the optional_subdirs variable, and OptionalSubdirsPos is
+	// the position of its assignment.
+	OptionalSubdirs    []string
+	OptionalSubdirsPos scanner.Position
+}
+
+// fileInclusionsFromScope returns the values of the inclusion variables assigned in the scope of a
+// parsed Blueprints file.  Values inherited from the parent Blueprints file are ignored.
+func fileInclusionsFromScope(scope *parser.Scope) (FileInclusions, []error) {
+	var inclusions FileInclusions
+	var errs []error
+
+	get := func(name string, values *[]string, pos *scanner.Position) {
+		var err error
+		*values, *pos, err = getLocalStringListFromScope(scope, name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	get("build", &inclusions.Build, &inclusions.BuildPos)
+	get("subdirs", &inclusions.Subdirs, &inclusions.SubdirsPos)
+	get("optional_subdirs", &inclusions.OptionalSubdirs, &inclusions.OptionalSubdirsPos)
+
+	for _, buildEntry := range inclusions.Build {
+		if strings.Contains(buildEntry, "/") {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("illegal value %v. The '/' character is not permitted", buildEntry),
+				Pos: inclusions.BuildPos,
+			})
+		}
+	}
+
+	return inclusions, errs
+}
+
+// ReadFileInclusions parses a Blueprints file and returns the values of the variables that
+// include other Blueprints files.  The filename is only used for reporting errors.
+func ReadFileInclusions(filename string, r io.Reader) (FileInclusions, []error) {
+	scope := parser.NewScope(nil)
+	_, errs := parser.ParseAndEval(filename, r, scope)
+	if len(errs) > 0 {
+		return FileInclusions{}, errs
+	}
+	return fileInclusionsFromScope(scope)
+}
+
+// FileInclusions returns the values of the variables that include other Blueprints files in a
+// Blueprints file parsed by the Context, with the given path relative to the root directory.  It
+// returns false if the file was not parsed.
+func (c *Context) FileInclusions(blueprintsFile string) (FileInclusions, bool) {
+	c.fileInclusionsLock.Lock()
+	defer c.fileInclusionsLock.Unlock()
+	inclusions, ok := c.fileInclusions[blueprintsFile]
+	return inclusions, ok
+}
+
+func (c *Context) recordFileInclusions(relBlueprintsFile string, inclusions FileInclusions) {
+	c.fileInclusionsLock.Lock()
+	defer c.fileInclusionsLock.Unlock()
+	if c.fileInclusions == nil {
+		c.fileInclusions = make(map[string]FileInclusions)
+	}
+	c.fileInclusions[relBlueprintsFile] = inclusions
+}
+
+// includeResolver reads the files included by include directives in Blueprints files parsed by
+// the Context.  Included paths are relative to the directory of the file that includes them.
+type includeResolver struct {
+	fs pathtools.FileSystem
+}
+
+func (r includeResolver) ResolveInclude(from, path string) (string, []byte, error) {
+	if filepath.IsAbs(path) {
+		return "", nil, fmt.Errorf("path must be relative to the directory of %s", from)
+	}
+	name := filepath.Join(filepath.Dir(from), path)
+	f, err := r.fs.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, contents, nil
+}
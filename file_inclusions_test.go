@@ -0,0 +1,155 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileInclusions(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["a"]
+			optional_subdirs = ["b", "c"]
+			build = ["other.bp"]
+		`),
+		"other.bp": []byte(""),
+		"a/Blueprints": []byte(`
+			subdirs = ["*"]
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	root, ok := ctx.FileInclusions("Blueprints")
+	if !ok {
+		t.Fatalf("missing inclusions for Blueprints")
+	}
+	if g, w := root.Subdirs, []string{"a"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected subdirs %q, got %q", w, g)
+	}
+	if g, w := root.OptionalSubdirs, []string{"b", "c"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected optional_subdirs %q, got %q", w, g)
+	}
+	if g, w := root.Build, []string{"other.bp"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected build %q, got %q", w, g)
+	}
+	if g, w := root.SubdirsPos.String(), "Blueprints:2:12"; g != w {
+		t.Errorf("expected subdirs at %s, got %s", w, g)
+	}
+
+	// Inclusions are not inherited from the parent Blueprints file.
+	a, ok := ctx.FileInclusions("a/Blueprints")
+	if !ok {
+		t.Fatalf("missing inclusions for a/Blueprints")
+	}
+	if g, w := a.Subdirs, []string{"*"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected subdirs %q, got %q", w, g)
+	}
+	if a.OptionalSubdirs != nil || a.Build != nil {
+		t.Errorf("expected no optional_subdirs or build, got %q and %q", a.OptionalSubdirs, a.Build)
+	}
+
+	if _, ok := ctx.FileInclusions("missing/Blueprints"); ok {
+		t.Errorf("expected no inclusions for a file that was not parsed")
+	}
+}
+
+func TestReadFileInclusions(t *testing.T) {
+	inclusions, errs := ReadFileInclusions("Blueprints", strings.NewReader(`
+		dirs = ["a"]
+		subdirs = dirs + ["b"]
+	`))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+	if g, w := inclusions.Subdirs, []string{"a", "b"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected subdirs %q, got %q", w, g)
+	}
+
+	_, errs = ReadFileInclusions("Blueprints", strings.NewReader(`optional_subdirs = [1]`))
+	if len(errs) != 1 || errs[0].Error() != `Blueprints:1:18: "optional_subdirs" must be a list of strings` {
+		t.Errorf("unexpected errors: %q", errs)
+	}
+}
+
+func TestIncludeDirective(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			build = ["other.bp"]
+			include "build/defs.bpi"
+			foo_module {
+				name: "a",
+				foo: prefix + "a",
+			}
+		`),
+		"other.bp": []byte(`
+			foo_module {
+				name: "b",
+				foo: prefix + "b",
+			}
+		`),
+		"build/defs.bpi": []byte(`
+			include "names.bpi"
+			prefix = base + "/"
+		`),
+		"build/names.bpi": []byte(`
+			base = "lib"
+		`),
+	})
+
+	deps, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	// Variables from included files are visible to the Blueprints files included with build.
+	for name, want := range map[string]string{"a": "lib/a", "b": "lib/b"} {
+		foo := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*fooModule)
+		if foo.properties.Foo != want {
+			t.Errorf("expected foo %q for %s, got %q", want, name, foo.properties.Foo)
+		}
+	}
+
+	for _, dep := range []string{"build/defs.bpi", "build/names.bpi"} {
+		found := false
+		for _, d := range deps {
+			found = found || d == dep
+		}
+		if !found {
+			t.Errorf("expected %s in deps %q", dep, deps)
+		}
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`include "a.bpi"`),
+			"a.bpi":      []byte(`include "Blueprints"`),
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "include cycle: Blueprints -> a.bpi -> Blueprints") {
+			t.Errorf("expected an include cycle error, got %q", errs)
+		}
+	})
+}
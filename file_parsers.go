@@ -0,0 +1,104 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+)
+
+// A FileParser converts a file in an alternate format, for example module descriptions generated
+// as JSON by another system, into the same representation as a parsed Blueprints file.  filename
+// is the path of the file and r reads its contents.
+//
+// The returned file must only contain *parser.Module and *parser.Assignment definitions whose
+// values are literals, as if they had been evaluated by parser.ParseAndEval.  Assignments are
+// added to the scope of the file, so the "build" variable can be used to include other files.  The
+// positions in the definitions are used to report errors, so they should point into filename.
+// Errors should be *parser.ParseError so that they are reported with their position.
+type FileParser func(filename string, r io.Reader) (*parser.File, []error)
+
+// RegisterFileParser registers a parser for the files whose name ends with ext, for example
+// ".json", that are listed in the file list or included with the "build" variable.  The modules
+// in those files are processed the same way as the modules in Blueprints files, including load
+// hooks, namespaces and error reporting.
+func (c *Context) RegisterFileParser(ext string, parser FileParser) {
+	c.checkRegistration("RegisterFileParser")
+
+	if ext == "" {
+		panic(fmt.Errorf("file parser extension must not be empty"))
+	}
+
+	if _, exists := c.fileParsers[ext]; exists {
+		panic(fmt.Errorf("file parser for %s is already registered", ext))
+	}
+
+	if c.fileParsers == nil {
+		c.fileParsers = make(map[string]FileParser)
+	}
+	c.fileParsers[ext] = parser
+}
+
+// fileParserFor returns the parser registered for filename, or nil if filename should be parsed
+// as a Blueprints file.  If more than one registered extension matches the longest one is used.
+func (c *Context) fileParserFor(filename string) FileParser {
+	base := filepath.Base(filename)
+	var match string
+	for ext := range c.fileParsers {
+		if strings.HasSuffix(base, ext) && len(ext) > len(match) {
+			match = ext
+		}
+	}
+	if match == "" {
+		return nil
+	}
+	return c.fileParsers[match]
+}
+
+// parseWithFileParser parses filename with an alternate file parser and adds the assignments it
+// returns to scope.
+func (c *Context) parseWithFileParser(fileParser FileParser, filename string, r io.Reader,
+	scope *parser.Scope) (*parser.File, []error) {
+
+	file, errs := fileParser(filename, r)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if file == nil {
+		file = &parser.File{}
+	}
+
+	for _, def := range file.Defs {
+		switch def := def.(type) {
+		case *parser.Module:
+		case *parser.Assignment:
+			if err := scope.Add(def); err != nil {
+				errs = append(errs, &BlueprintError{Err: err, Pos: def.NamePos})
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: unsupported definition %T returned by file parser",
+				filename, def))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return file, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// jsonLinesTestParser parses files with one JSON object per line, each describing a module with a
+// "type" key and string or string list properties.
+func jsonLinesTestParser(filename string, r io.Reader) (*parser.File, []error) {
+	file := &parser.File{Name: filename}
+	var errs []error
+	lines := bufio.NewScanner(r)
+	for line := 1; lines.Scan(); line++ {
+		pos := scanner.Position{Filename: filename, Line: line, Column: 1}
+		var values map[string]interface{}
+		if err := json.Unmarshal(lines.Bytes(), &values); err != nil {
+			errs = append(errs, &parser.ParseError{Err: err, Pos: pos})
+			continue
+		}
+
+		module := &parser.Module{TypePos: pos}
+		var names []string
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var value parser.Expression
+			switch v := values[name].(type) {
+			case string:
+				if name == "type" {
+					module.Type = v
+					continue
+				}
+				value = &parser.String{LiteralPos: pos, Value: v}
+			case []interface{}:
+				list := &parser.List{LBracePos: pos, RBracePos: pos}
+				for _, s := range v {
+					list.Values = append(list.Values, &parser.String{LiteralPos: pos, Value: s.(string)})
+				}
+				value = list
+			default:
+				errs = append(errs, &parser.ParseError{Err: fmt.Errorf("unsupported value for %q", name), Pos: pos})
+				continue
+			}
+			module.Properties = append(module.Properties, &parser.Property{
+				Name:     name,
+				NamePos:  pos,
+				ColonPos: pos,
+				Value:    value,
+			})
+		}
+		file.Defs = append(file.Defs, module)
+	}
+	return file, errs
+}
+
+func fileParserErrorStrings(errs []error) []string {
+	var ret []string
+	for _, err := range errs {
+		ret = append(ret, err.Error())
+	}
+	return ret
+}
+
+func TestRegisterFileParser(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterFileParser(".json", jsonLinesTestParser)
+
+	ctx.MockFileSystem(map[string][]byte{
+		MockModuleListFile: []byte("Blueprints\ndir/Blueprints.json"),
+		"Blueprints": []byte(`
+			foo_module {
+				name: "a",
+				deps: ["b"],
+			}
+		`),
+		"dir/Blueprints.json": []byte(`{"type": "bar_module", "name": "b", "deps": ["c"]}
+{"type": "foo_module", "name": "c", "foo": "generated"}
+`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var deps []string
+	ctx.VisitDepsDepthFirst(ctx.moduleGroupFromName("a", nil).modules.firstModule().logicModule, func(m Module) {
+		deps = append(deps, m.Name()+":"+ctx.BlueprintFile(m))
+	})
+	if want := []string{"c:dir/Blueprints.json", "b:dir/Blueprints.json"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("want deps %q, got %q", want, deps)
+	}
+
+	c := ctx.moduleGroupFromName("c", nil).modules.firstModule()
+	if got := c.logicModule.(*fooModule).properties.Foo; got != "generated" {
+		t.Errorf("want foo %q, got %q", "generated", got)
+	}
+	if got, want := c.pos.String(), "dir/Blueprints.json:2:1"; got != want {
+		t.Errorf("want position %q, got %q", want, got)
+	}
+}
+
+func TestRegisterFileParserErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterFileParser(".json", jsonLinesTestParser)
+
+	ctx.MockFileSystem(map[string][]byte{
+		MockModuleListFile: []byte("Blueprints.json"),
+		"Blueprints.json": []byte(`{"type": "foo_module", "name": "a"}
+{"type": "foo_module"
+`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints.json", nil)
+	if got, want := fileParserErrorStrings(errs), []string{
+		"Blueprints.json:2:1: unexpected end of JSON input",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want parse errors %q, got %q", want, got)
+	}
+
+	ctx = NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterFileParser(".json", jsonLinesTestParser)
+	ctx.MockFileSystem(map[string][]byte{
+		MockModuleListFile: []byte("Blueprints.json"),
+		"Blueprints.json":  []byte(`{"type": "foo_module", "name": "b", "unknown": "x"}`),
+	})
+
+	_, errs = ctx.ParseBlueprintsFiles("Blueprints.json", nil)
+	if got, want := fileParserErrorStrings(errs), []string{
+		`Blueprints.json:1:1: unrecognized property "unknown"`,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want unpack errors %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A FinalCheck validates an invariant that spans the whole build, for example that every module
+// that sets a provider is a dependency of a particular module, once all the build actions have
+// been generated and before the Ninja file is written.  It reports problems with the Errorf and
+// ModuleErrorf methods of the FinalCheckContext.
+type FinalCheck func(ctx FinalCheckContext)
+
+// FinalCheckContext gives a FinalCheck read-only access to the modules and build targets.
+type FinalCheckContext interface {
+	// Config returns the config object that was passed to Context.PrepareBuildActions.
+	Config() interface{}
+
+	// Name returns the name of the check passed to Context.RegisterFinalCheck.
+	Name() string
+
+	// ModuleName returns the name of the given Module.  See BaseModuleContext.ModuleName for more information.
+	ModuleName(module Module) string
+
+	// ModuleDir returns the directory of the given Module.  See BaseModuleContext.ModuleDir for more information.
+	ModuleDir(module Module) string
+
+	// ModuleType returns the type of the given Module.  See BaseModuleContext.ModuleType for more information.
+	ModuleType(module Module) string
+
+	// BlueprintFile returns the path of the Blueprint file that defined the given module.
+	BlueprintFile(module Module) string
+
+	// ModuleProvider returns the value, if any, for the provider for a module.  See
+	// SingletonContext.ModuleProvider for more information.
+	ModuleProvider(module Module, provider ProviderKey) interface{}
+
+	// ModuleHasProvider returns true if the provider for the given module has been set.
+	ModuleHasProvider(m Module, provider ProviderKey) bool
+
+	// ModuleErrorf reports an error at the line number of the module type in the module definition.
+	ModuleErrorf(module Module, format string, args ...interface{})
+
+	// Errorf reports an error that is not associated with a module.
+	Errorf(format string, args ...interface{})
+
+	// Failed returns true if any errors have been reported by this check.
+	Failed() bool
+
+	// VisitAllModules calls visit for each defined variant of each module in an unspecified order.
+	VisitAllModules(visit func(Module))
+
+	// VisitAllModulesIf calls pred for each defined variant of each module in an unspecified order,
+	// and if pred returns true calls visit.
+	VisitAllModulesIf(pred func(Module) bool, visit func(Module))
+
+	// VisitDirectDeps calls visit for each direct dependency of the Module.
+	VisitDirectDeps(module Module, visit func(Module))
+
+	// VisitDepsDepthFirst calls visit for each transitive dependency, traversing the dependency
+	// tree in depth first order.
+	VisitDepsDepthFirst(module Module, visit func(Module))
+
+	// SampleModules returns k module variants chosen deterministically from seed, so that an
+	// expensive check can audit a different part of the tree on each run.  See
+	// Context.SampleModules.
+	SampleModules(seed string, k int) []Module
+
+	// Targets returns a map of all the build targets to the rule used to build them and the module
+	// or singleton that generated them.  See Context.AllTargetsWithOrigin.
+	Targets() map[string]TargetInfo
+}
+
+type finalCheckInfo struct {
+	name  string
+	check FinalCheck
+}
+
+type finalCheckContext struct {
+	*singletonContext
+	targets func() map[string]TargetInfo
+}
+
+var _ FinalCheckContext = (*finalCheckContext)(nil)
+
+func (f *finalCheckContext) Targets() map[string]TargetInfo {
+	return f.targets()
+}
+
+func (f *finalCheckContext) SampleModules(seed string, k int) []Module {
+	return f.context.SampleModules(seed, k)
+}
+
+func (f *finalCheckContext) Errorf(format string, args ...interface{}) {
+	f.error(fmt.Errorf("final check %q: %s", f.name, fmt.Sprintf(format, args...)))
+}
+
+// RegisterFinalCheck registers a check that is run at the end of PrepareBuildActions, after all
+// the build actions have been generated and the other checks of the Context have passed.  All the
+// registered checks are run in registration order, and the errors reported by all of them are
+// returned together by PrepareBuildActions.
+//
+// The check names given here must be unique for the context.
+func (c *Context) RegisterFinalCheck(name string, check FinalCheck) {
+	c.checkRegistration("RegisterFinalCheck")
+
+	for _, info := range c.finalChecks {
+		if info.name == name {
+			panic(errors.New("final check name is already registered"))
+		}
+	}
+
+	c.finalChecks = append(c.finalChecks, &finalCheckInfo{
+		name:  name,
+		check: check,
+	})
+}
+
+// runFinalChecks runs the checks registered with RegisterFinalCheck and returns the errors
+// reported by all of them.
+func (c *Context) runFinalChecks(config interface{}) []error {
+	var targets map[string]TargetInfo
+	var targetsErr error
+	getTargets := func() map[string]TargetInfo {
+		if targets == nil && targetsErr == nil {
+			targets, targetsErr = c.allTargetsWithOrigin()
+		}
+		return targets
+	}
+
+	var errs []error
+	for _, info := range c.finalChecks {
+		ctx := &finalCheckContext{
+			singletonContext: &singletonContext{
+				name:    info.name,
+				context: c,
+				config:  config,
+				scope:   newLocalScope(nil, singletonNamespacePrefix(info.name)),
+				globals: c.liveGlobals,
+			},
+			targets: getTargets,
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					in := fmt.Sprintf("final check %s", info.name)
+					if err, ok := r.(panicError); ok {
+						err.addIn(in)
+						ctx.error(err)
+					} else {
+						ctx.error(newPanicErrorf(r, in))
+					}
+				}
+			}()
+			info.check(ctx)
+		}()
+
+		ctx.applyNinjaSettings()
+		errs = append(errs, ctx.errs...)
+	}
+
+	if targetsErr != nil {
+		errs = append(errs, targetsErr)
+	}
+
+	return errs
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFinalChecks(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	var ran []string
+
+	// Every module must be a transitive dependency of the module named "all".
+	ctx.RegisterFinalCheck("reachable_from_all", func(ctx FinalCheckContext) {
+		ran = append(ran, ctx.Name())
+
+		var all Module
+		ctx.VisitAllModules(func(m Module) {
+			if ctx.ModuleName(m) == "all" {
+				all = m
+			}
+		})
+
+		reachable := map[Module]bool{all: true}
+		ctx.VisitDepsDepthFirst(all, func(dep Module) {
+			reachable[dep] = true
+		})
+
+		ctx.VisitAllModules(func(m Module) {
+			if !reachable[m] {
+				ctx.ModuleErrorf(m, "not reachable from %q", "all")
+			}
+		})
+	})
+
+	ctx.RegisterFinalCheck("targets", func(ctx FinalCheckContext) {
+		ran = append(ran, ctx.Name())
+		if _, ok := ctx.Targets()["everything"]; !ok {
+			ctx.Errorf("missing target %q", "everything")
+		}
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "all",
+				deps: ["A"],
+			}
+
+			provider_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			provider_module {
+				name: "B",
+			}
+
+			provider_module {
+				name: "C",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`Blueprints:16:4: not reachable from "all"`,
+		`final check "targets": missing target "everything"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+
+	if want := []string{"reachable_from_all", "targets"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("incorrect checks run\nwant: %q\n got: %q", want, ran)
+	}
+
+	if _, err := ctx.AllTargets(); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady after failed final checks, got %v", err)
+	}
+}
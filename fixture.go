@@ -0,0 +1,423 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+)
+
+// FixtureModuleListFile is the name of the file written by Fixture.Write that lists the
+// Blueprints files of the fixture, one per line, in the format expected by
+// Context.SetModuleListFile.
+const FixtureModuleListFile = "Blueprints.list"
+
+// FixtureOptions controls the fixture returned by Context.ExtractFixture.
+type FixtureOptions struct {
+	// AnonymizePaths replaces the names of the directories and files in the fixture with generated
+	// names, keeping only the names of the Blueprints files and the extensions of other files.
+	// Strings in the module definitions that refer to a file or directory of the fixture, relative
+	// to the directory of the module, are rewritten to match.
+	AnonymizePaths bool
+}
+
+// A Fixture is a standalone, minimal tree of Blueprints files that defines a set of modules and
+// their transitive dependencies, as returned by Context.ExtractFixture.  It is intended to be
+// attached to bug reports so that a problem can be reproduced without the full source tree.
+type Fixture struct {
+	// Blueprints maps the path of each Blueprints file of the fixture to its contents.
+	Blueprints map[string][]byte
+
+	// Files lists the source files read by the build statements of the modules of the fixture,
+	// relative to the top of the tree, sorted.  It is only filled in if PrepareBuildActions had
+	// completed when the fixture was extracted.
+	Files []string
+}
+
+// BlueprintsFiles returns the paths of the Blueprints files of the fixture, sorted, which can be
+// passed to Context.ParseFileList.
+func (f *Fixture) BlueprintsFiles() []string {
+	files := make([]string, 0, len(f.Blueprints))
+	for file := range f.Blueprints {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Write writes the fixture into dir: the Blueprints files, an empty placeholder for each of the
+// referenced source files, and a FixtureModuleListFile listing the Blueprints files.
+func (f *Fixture) Write(dir string) error {
+	writeFile := func(file string, contents []byte) error {
+		file = filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			return err
+		}
+		return os.WriteFile(file, contents, 0666)
+	}
+
+	blueprintsFiles := f.BlueprintsFiles()
+	for _, file := range blueprintsFiles {
+		if err := writeFile(file, f.Blueprints[file]); err != nil {
+			return err
+		}
+	}
+	for _, file := range f.Files {
+		if _, isBlueprints := f.Blueprints[file]; isBlueprints {
+			continue
+		}
+		if err := writeFile(file, nil); err != nil {
+			return err
+		}
+	}
+
+	list := strings.Join(blueprintsFiles, "\n") + "\n"
+	return writeFile(FixtureModuleListFile, []byte(list))
+}
+
+// SetRecordModuleDefinitions sets whether the evaluated definition of each module is kept after
+// parsing, which is required by ExtractFixture.  It must be called before the Blueprints files
+// are parsed.  Keeping the definitions uses more memory, so it is disabled by default.
+func (c *Context) SetRecordModuleDefinitions(record bool) {
+	c.checkRegistration("SetRecordModuleDefinitions")
+
+	c.recordModuleDefinitions = record
+}
+
+// ExtractFixture returns a Fixture containing the definitions of the named modules and of all the
+// modules they transitively depend on, along with the source files read by their build
+// statements.  Modules created by load hooks or mutators are represented by the definition of the
+// module that created them.
+//
+// The definitions are written out as they were evaluated while parsing, so variables, operators,
+// select expressions and calls to builtin functions are replaced by their values.  Module
+// definitions must have been recorded with SetRecordModuleDefinitions, and ResolveDependencies
+// must have completed.
+func (c *Context) ExtractFixture(opts FixtureOptions, names ...string) (*Fixture, error) {
+	if !c.recordModuleDefinitions {
+		return nil, fmt.Errorf("module definitions were not recorded, SetRecordModuleDefinitions " +
+			"must be called before parsing")
+	}
+	if !c.dependenciesReady {
+		return nil, fmt.Errorf("ExtractFixture called before ResolveDependencies")
+	}
+
+	visited := make(map[*moduleInfo]bool)
+	var visit func(module *moduleInfo)
+	visit = func(module *moduleInfo) {
+		if visited[module] {
+			return
+		}
+		visited[module] = true
+		for _, dep := range module.directDeps {
+			visit(dep.module)
+		}
+		if module.createdBy != nil {
+			visit(module.createdBy)
+		}
+	}
+
+	for _, name := range names {
+		group := c.moduleGroupFromName(name, nil)
+		if group == nil {
+			return nil, fmt.Errorf("no module named %q", name)
+		}
+		for _, moduleOrAlias := range group.modules {
+			if module := moduleOrAlias.module(); module != nil {
+				visit(module)
+			}
+		}
+	}
+
+	// All the variants of a module share its definition, and modules created by load hooks or
+	// mutators have none.
+	seenDefs := make(map[*parser.Module]bool)
+	defsByFile := make(map[string][]*parser.Module)
+	for module := range visited {
+		if module.def == nil {
+			continue
+		}
+		if seenDefs[module.def] {
+			continue
+		}
+		seenDefs[module.def] = true
+		defsByFile[module.relBlueprintsFile] = append(defsByFile[module.relBlueprintsFile], module.def)
+	}
+
+	// Keep the package modules that apply to the modules, as they affect their visibility.
+	for module := range visited {
+		for dir := module.dir(); ; dir = path.Dir(dir) {
+			if pkg := c.packages[dir]; pkg != nil && !seenDefs[pkg.def] {
+				seenDefs[pkg.def] = true
+				defsByFile[pkg.relBlueprintsFile] = append(defsByFile[pkg.relBlueprintsFile], pkg.def)
+			}
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+
+	files, err := c.fixtureFiles(visited)
+	if err != nil {
+		return nil, err
+	}
+
+	var anonymizer *fixtureAnonymizer
+	if opts.AnonymizePaths {
+		anonymizer = newFixtureAnonymizer(defsByFile, files)
+		for i, file := range files {
+			files[i] = anonymizer.file(file)
+		}
+		sort.Strings(files)
+	}
+
+	fixture := &Fixture{
+		Blueprints: make(map[string][]byte, len(defsByFile)),
+		Files:      files,
+	}
+
+	for file, defs := range defsByFile {
+		sort.Slice(defs, func(i, j int) bool {
+			return defs[i].TypePos.Offset < defs[j].TypePos.Offset
+		})
+
+		var rewrite func(s string) string
+		if anonymizer != nil {
+			dir := path.Dir(file)
+			rewrite = func(s string) string { return anonymizer.relPath(dir, s) }
+			file = path.Join(anonymizer.dir(dir), path.Base(file))
+		}
+
+		fixtureFile := &parser.File{Name: file}
+		for _, def := range defs {
+			properties, err := fixtureProperties(def.Properties, rewrite)
+			if err != nil {
+				return nil, &BlueprintError{Err: err, Pos: def.TypePos}
+			}
+			fixtureFile.Defs = append(fixtureFile.Defs, &parser.Module{
+				Type: def.Type,
+				Map:  parser.Map{Properties: properties},
+			})
+		}
+
+		contents, err := parser.Print(fixtureFile)
+		if err != nil {
+			return nil, err
+		}
+		fixture.Blueprints[file] = contents
+	}
+
+	return fixture, nil
+}
+
+// fixtureFiles returns the source files read by the build statements of the modules, which are
+// the files declared with ModuleContext.InputFile and the inputs that aren't built by any build
+// statement or inside the Ninja build directory.
+func (c *Context) fixtureFiles(modules map[*moduleInfo]bool) ([]string, error) {
+	if !c.buildActionsReady {
+		return nil, nil
+	}
+
+	targets, err := c.allTargetsWithOrigin()
+	if err != nil {
+		return nil, err
+	}
+	buildDir, err := c.NinjaBuildDir()
+	if err != nil {
+		return nil, err
+	}
+	buildDir = path.Clean(buildDir)
+
+	fileSet := make(map[string]bool)
+	addFile := func(file string) {
+		if file == "" || path.IsAbs(file) {
+			return
+		}
+		file = path.Clean(file)
+		if _, built := targets[file]; built {
+			return
+		}
+		if buildDir != "." && (file == buildDir || strings.HasPrefix(file, buildDir+"/")) {
+			return
+		}
+		fileSet[file] = true
+	}
+
+	for module := range modules {
+		for _, file := range module.declaredInputs {
+			addFile(file)
+		}
+		for _, def := range module.actionDefs.buildDefs {
+			e := &actionEvaluator{context: c, buildDef: def}
+			for _, list := range [][]ninjaString{def.Inputs, def.Implicits, def.OrderOnly} {
+				inputs, err := e.evalList(list)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", module, err)
+				}
+				for _, input := range inputs {
+					addFile(input)
+				}
+			}
+		}
+	}
+
+	files := make([]string, 0, len(fileSet))
+	for file := range fileSet {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// fixtureProperties returns a copy of the properties with every expression replaced by its
+// evaluated value, and with no positions so that they print as if freshly formatted.  If rewrite
+// is not nil it is applied to every string value.
+func fixtureProperties(properties []*parser.Property,
+	rewrite func(string) string) ([]*parser.Property, error) {
+
+	ret := make([]*parser.Property, 0, len(properties))
+	for _, property := range properties {
+		value, err := fixtureExpression(property.Value, rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", property.Name, err)
+		}
+		ret = append(ret, &parser.Property{Name: property.Name, Value: value})
+	}
+	return ret, nil
+}
+
+func fixtureExpression(expression parser.Expression,
+	rewrite func(string) string) (parser.Expression, error) {
+
+	switch v := expression.Eval().(type) {
+	case *parser.String:
+		value := v.Value
+		if rewrite != nil {
+			value = rewrite(value)
+		}
+		return &parser.String{Value: value}, nil
+	case *parser.Int64:
+		return &parser.Int64{Value: v.Value, Token: v.Token}, nil
+	case *parser.Bool:
+		return &parser.Bool{Value: v.Value, Token: v.Token}, nil
+	case *parser.List:
+		list := &parser.List{Values: make([]parser.Expression, 0, len(v.Values))}
+		for _, value := range v.Values {
+			value, err := fixtureExpression(value, rewrite)
+			if err != nil {
+				return nil, err
+			}
+			list.Values = append(list.Values, value)
+		}
+		return list, nil
+	case *parser.Map:
+		properties, err := fixtureProperties(v.Properties, rewrite)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.Map{Properties: properties}, nil
+	default:
+		return nil, fmt.Errorf("can't extract unevaluated %s expression", expression.Type())
+	}
+}
+
+// fixtureAnonymizer generates the anonymized names of the directories and files of a fixture.
+// Directories are named dir1, dir2, ... and files file1.ext, file2.ext, ... in the order of their
+// original paths within each anonymized directory, so the same tree always gives the same names.
+type fixtureAnonymizer struct {
+	dirs  map[string]string
+	files map[string]string
+
+	// the number of directories and files named so far in each anonymized directory
+	dirCounts  map[string]int
+	fileCounts map[string]int
+}
+
+func newFixtureAnonymizer(defsByFile map[string][]*parser.Module, files []string) *fixtureAnonymizer {
+	a := &fixtureAnonymizer{
+		dirs:       make(map[string]string),
+		files:      make(map[string]string),
+		dirCounts:  make(map[string]int),
+		fileCounts: make(map[string]int),
+	}
+
+	var dirs []string
+	for file := range defsByFile {
+		dirs = append(dirs, path.Dir(file))
+	}
+	for _, file := range files {
+		dirs = append(dirs, path.Dir(file))
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		a.dir(dir)
+	}
+	for _, file := range files {
+		a.file(file)
+	}
+	return a
+}
+
+func (a *fixtureAnonymizer) dir(dir string) string {
+	if dir == "." || dir == "/" {
+		return dir
+	}
+	if name, ok := a.dirs[dir]; ok {
+		return name
+	}
+	parent := a.dir(path.Dir(dir))
+	a.dirCounts[parent]++
+	name := path.Join(parent, fmt.Sprintf("dir%d", a.dirCounts[parent]))
+	a.dirs[dir] = name
+	return name
+}
+
+func (a *fixtureAnonymizer) file(file string) string {
+	if name, ok := a.files[file]; ok {
+		return name
+	}
+	parent := a.dir(path.Dir(file))
+	a.fileCounts[parent]++
+	name := path.Join(parent, fmt.Sprintf("file%d%s", a.fileCounts[parent], path.Ext(file)))
+	a.files[file] = name
+	return name
+}
+
+// relPath rewrites s if it is a path relative to dir that refers to one of the files or
+// directories of the fixture, and returns it unchanged otherwise.
+func (a *fixtureAnonymizer) relPath(dir, s string) string {
+	if s == "" || path.IsAbs(s) {
+		return s
+	}
+	p := path.Join(dir, s)
+	name, ok := a.files[p]
+	if !ok {
+		name, ok = a.dirs[p]
+	}
+	if !ok {
+		return s
+	}
+	rel, err := filepath.Rel(a.dir(dir), name)
+	if err != nil {
+		return s
+	}
+	return filepath.ToSlash(rel)
+}
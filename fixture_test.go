@@ -0,0 +1,199 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"path"
+	"reflect"
+	"testing"
+)
+
+type fixtureTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+		Srcs []string
+	}
+}
+
+func newFixtureTestModule() (Module, []interface{}) {
+	m := &fixtureTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *fixtureTestModule) Deps() []string {
+	return m.properties.Deps
+}
+
+func (m *fixtureTestModule) IgnoreDeps() []string {
+	return nil
+}
+
+func (m *fixtureTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for _, src := range m.properties.Srcs {
+		ctx.InputFile(path.Join(ctx.ModuleDir(), src))
+	}
+}
+
+func TestExtractFixture(t *testing.T) {
+	newContext := func() *Context {
+		ctx := NewContext()
+		ctx.RegisterModuleType("fixture_module", newFixtureTestModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.SetRecordModuleDefinitions(true)
+		return ctx
+	}
+
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["*"]
+		`),
+		"a/Blueprints": []byte(`
+			srcs = ["src/a.c"]
+
+			fixture_module {
+				name: "unused",
+			}
+
+			fixture_module {
+				name: "A",
+				deps: ["B"],
+				srcs: srcs + ["src/b.c"],
+			}
+		`),
+		"b/Blueprints": []byte(`
+			fixture_module {
+				name: "B",
+				srcs: ["b.c"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		fixture, err := ctx.ExtractFixture(FixtureOptions{}, "A")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"a/Blueprints": `fixture_module {
+    name: "A",
+    deps: ["B"],
+    srcs: [
+        "src/a.c",
+        "src/b.c",
+    ],
+}
+`,
+			"b/Blueprints": `fixture_module {
+    name: "B",
+    srcs: ["b.c"],
+}
+`,
+		}
+		checkFixtureBlueprints(t, fixture, expected)
+
+		expectedFiles := []string{"a/src/a.c", "a/src/b.c", "b/b.c"}
+		if !reflect.DeepEqual(fixture.Files, expectedFiles) {
+			t.Errorf("incorrect files:\nwant %q\n got %q", expectedFiles, fixture.Files)
+		}
+
+		// The fixture must be buildable on its own.
+		fixtureCtx := newContext()
+		fixtureCtx.MockFileSystem(fixtureMockFS(fixture))
+		_, errs := fixtureCtx.ParseFileList(".", fixture.BlueprintsFiles(), nil)
+		if len(errs) == 0 {
+			_, errs = fixtureCtx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors building fixture: %q", errs)
+		}
+		if group := fixtureCtx.moduleGroupFromName("unused", nil); group != nil {
+			t.Errorf("unexpected module %q in fixture", "unused")
+		}
+	})
+
+	t.Run("anonymized", func(t *testing.T) {
+		fixture, err := ctx.ExtractFixture(FixtureOptions{AnonymizePaths: true}, "A")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"dir1/Blueprints": `fixture_module {
+    name: "A",
+    deps: ["B"],
+    srcs: [
+        "dir1/file1.c",
+        "dir1/file2.c",
+    ],
+}
+`,
+			"dir2/Blueprints": `fixture_module {
+    name: "B",
+    srcs: ["file1.c"],
+}
+`,
+		}
+		checkFixtureBlueprints(t, fixture, expected)
+
+		expectedFiles := []string{"dir1/dir1/file1.c", "dir1/dir1/file2.c", "dir2/file1.c"}
+		if !reflect.DeepEqual(fixture.Files, expectedFiles) {
+			t.Errorf("incorrect files:\nwant %q\n got %q", expectedFiles, fixture.Files)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := ctx.ExtractFixture(FixtureOptions{}, "missing"); err == nil {
+			t.Errorf("expected error for missing module")
+		}
+
+		unrecorded := NewContext()
+		if _, err := unrecorded.ExtractFixture(FixtureOptions{}, "A"); err == nil {
+			t.Errorf("expected error when module definitions were not recorded")
+		}
+	})
+}
+
+func checkFixtureBlueprints(t *testing.T, fixture *Fixture, expected map[string]string) {
+	t.Helper()
+	got := make(map[string]string, len(fixture.Blueprints))
+	for file, contents := range fixture.Blueprints {
+		got[file] = string(contents)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect Blueprints files:\nwant %q\n got %q", expected, got)
+	}
+}
+
+func fixtureMockFS(fixture *Fixture) map[string][]byte {
+	fs := make(map[string][]byte)
+	for file, contents := range fixture.Blueprints {
+		fs[file] = contents
+	}
+	for _, file := range fixture.Files {
+		fs[file] = nil
+	}
+	return fs
+}
@@ -15,7 +15,11 @@
 package blueprint
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -57,16 +61,31 @@ func (c *Context) glob(pattern string, excludes []string) ([]string, error) {
 		return append([]string(nil), g.Matches...), nil
 	}
 
-	// Get a globbed file list
-	result, err := c.fs.Glob(pattern, excludes, pathtools.FollowSymlinks)
-	if err != nil {
-		return nil, err
+	// Reuse the result from a previous run if none of its dependencies have changed, otherwise get
+	// a globbed file list
+	result, depModTimes, cached := c.cachedGlob(key)
+	if !cached {
+		fs := c.fs
+		if c.ignoreSymlinkLoops {
+			fs = pathtools.IgnoreSymlinkLoops(fs)
+		}
+		var err error
+		result, err = fs.Glob(pattern, excludes, pathtools.FollowSymlinks)
+		if err != nil {
+			return nil, err
+		}
+		if c.globCacheFile != "" {
+			depModTimes = c.globDepModTimes(result.Deps)
+		}
 	}
 
 	// Store the results
 	c.globLock.Lock()
 	if g, exists = c.globs[key]; !exists {
 		c.globs[key] = result
+		if c.globCacheFile != "" {
+			c.globModTimes[key] = depModTimes
+		}
 	}
 	c.globLock.Unlock()
 
@@ -114,3 +133,123 @@ type globKey struct {
 func globToKey(pattern string, excludes []string) globKey {
 	return globKey{pattern, strings.Join(excludes, "|")}
 }
+
+// SetGlobCacheFile sets the path of a file that stores the results of globs between runs.  If it
+// is set the file is read when the first glob is performed, and a glob whose result is in the file
+// reuses that result instead of walking the directories again if the modification times of all the
+// files and directories the result depends on are unchanged.  The file is written with the results
+// of all globs at the end of a successful PrepareBuildActions.  Reading the file is best effort, a
+// missing or unreadable cache file causes all globs to be performed.
+func (c *Context) SetGlobCacheFile(path string) {
+	c.checkRegistration("SetGlobCacheFile")
+
+	c.globCacheFile = path
+	c.globModTimes = make(map[globKey][]int64)
+}
+
+// globCacheVersion must be incremented whenever the format of the glob cache file changes.
+const globCacheVersion = 1
+
+type globCacheFile struct {
+	Version int
+	Entries []globCacheEntry
+}
+
+type globCacheEntry struct {
+	Result pathtools.GlobResult
+
+	// DepModTimes contains the modification time in nanoseconds of each of the Result.Deps, or -1
+	// if it didn't exist.
+	DepModTimes []int64
+}
+
+// cachedGlob returns the result of the glob with the given key from the glob cache file, and the
+// modification times of its dependencies, if the result is still valid.
+func (c *Context) cachedGlob(key globKey) (pathtools.GlobResult, []int64, bool) {
+	if c.globCacheFile == "" {
+		return pathtools.GlobResult{}, nil, false
+	}
+
+	c.globCacheOnce.Do(c.readGlobCache)
+
+	entry, exists := c.globCache[key]
+	if !exists {
+		return pathtools.GlobResult{}, nil, false
+	}
+
+	depModTimes := c.globDepModTimes(entry.Result.Deps)
+	for i := range depModTimes {
+		if depModTimes[i] != entry.DepModTimes[i] {
+			return pathtools.GlobResult{}, nil, false
+		}
+	}
+
+	return entry.Result, depModTimes, true
+}
+
+// globDepModTimes returns the modification times of the dependencies of a glob result.
+func (c *Context) globDepModTimes(deps []string) []int64 {
+	modTimes := make([]int64, len(deps))
+	for i, dep := range deps {
+		if info, err := c.fs.Stat(dep); err == nil {
+			modTimes[i] = info.ModTime().UnixNano()
+		} else {
+			modTimes[i] = -1
+		}
+	}
+	return modTimes
+}
+
+// readGlobCache reads the glob cache file, ignoring it if it can't be read or was written by a
+// different version of Blueprint.
+func (c *Context) readGlobCache() {
+	c.globCache = make(map[globKey]globCacheEntry)
+
+	data, err := ioutil.ReadFile(c.globCacheFile)
+	if err != nil {
+		return
+	}
+
+	var cacheFile globCacheFile
+	if err := json.Unmarshal(data, &cacheFile); err != nil || cacheFile.Version != globCacheVersion {
+		return
+	}
+
+	for _, entry := range cacheFile.Entries {
+		if len(entry.DepModTimes) != len(entry.Result.Deps) {
+			continue
+		}
+		c.globCache[globToKey(entry.Result.Pattern, entry.Result.Excludes)] = entry
+	}
+}
+
+// writeGlobCache writes the results of all globs performed by the Context to the glob cache file.
+func (c *Context) writeGlobCache() error {
+	cacheFile := globCacheFile{Version: globCacheVersion}
+	for _, result := range c.Globs() {
+		key := globToKey(result.Pattern, result.Excludes)
+		cacheFile.Entries = append(cacheFile.Entries, globCacheEntry{
+			Result:      result,
+			DepModTimes: c.globModTimes[key],
+		})
+	}
+
+	data, err := json.Marshal(cacheFile)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that an interrupted write can't leave a
+	// truncated cache file behind.
+	tmpFile := c.globCacheFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(c.globCacheFile), 0777); err != nil {
+		return fmt.Errorf("error writing glob cache: %s", err)
+	}
+	if err := ioutil.WriteFile(tmpFile, data, 0666); err != nil {
+		return fmt.Errorf("error writing glob cache: %s", err)
+	}
+	if err := os.Rename(tmpFile, c.globCacheFile); err != nil {
+		return fmt.Errorf("error writing glob cache: %s", err)
+	}
+	return nil
+}
@@ -14,7 +14,13 @@
 
 package blueprint
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestGlobCache(t *testing.T) {
 	ctx := NewContext()
@@ -53,3 +59,71 @@ func TestGlobCache(t *testing.T) {
 		t.Error(`expected ["a/a"], got`, matches)
 	}
 }
+
+func TestPersistentGlobCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheFile := filepath.Join(dir, "out", "globs.json")
+	srcDir := filepath.Join(dir, "src")
+	for _, file := range []string{"a/a", "a/b"} {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pattern := filepath.Join(srcDir, "a", "*")
+
+	glob := func() (*Context, []string) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetGlobCacheFile(cacheFile)
+		matches, err := ctx.glob(pattern, nil)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if err := ctx.writeGlobCache(); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		return ctx, matches
+	}
+
+	_, matches := glob()
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %q", matches)
+	}
+
+	// Add a file without changing the modification time of the directory, the cached result
+	// should be used.
+	aDir := filepath.Join(srcDir, "a")
+	info, err := os.Stat(aDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(aDir, "c"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(aDir, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, matches := glob()
+	if len(matches) != 2 {
+		t.Errorf("expected cached 2 matches, got %q", matches)
+	}
+	if _, cached := ctx.globCache[globToKey(pattern, nil)]; !cached {
+		t.Errorf("expected glob to be read from the cache file")
+	}
+
+	// Change the modification time of the directory, the glob should be performed again.
+	newTime := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(aDir, newTime, newTime); err != nil {
+		t.Fatal(err)
+	}
+
+	_, matches = glob()
+	if len(matches) != 3 {
+		t.Errorf("expected 3 matches after invalidation, got %q", matches)
+	}
+}
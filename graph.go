@@ -0,0 +1,423 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A GraphModule is a single variant of a module in the resolved dependency graph returned by
+// Context.Graph.
+type GraphModule struct {
+	// Module is the logic module for this variant.
+	Module Module
+
+	// Name is the name of the module, shared by all of its variants.
+	Name string
+
+	// Type is the module type name the module was created with.
+	Type string
+
+	// Blueprint is the path to the Blueprints file that defined the module, relative to the
+	// source root.
+	Blueprint string
+
+	// Variant is the name of the variant, which is also used as the module's subdirectory.
+	Variant string
+
+	// Variations maps each mutator that split the module to the variation this variant was
+	// assigned.
+	Variations map[string]string
+
+	// Deps contains the direct dependencies of this variant in the order they were added.
+	Deps []GraphDep
+
+	// ReverseDeps contains the modules that have a direct dependency on this variant.
+	ReverseDeps []*GraphModule
+
+	context *Context
+	info    *moduleInfo
+}
+
+// A GraphDep is a direct dependency edge in the graph returned by Context.Graph.
+type GraphDep struct {
+	Module *GraphModule
+	Tag    DependencyTag
+}
+
+// Provider returns the value, if any, for the provider for the module.  The second return value
+// is false if the provider was not set.  The return value should always be considered read-only.
+func (m *GraphModule) Provider(provider ProviderKey) (interface{}, bool) {
+	return m.context.provider(m.info, provider)
+}
+
+// Graph returns every module variant in the resolved dependency graph, sorted so that each
+// module appears after all of its dependencies.  It allows tools embedded in the primary builder
+// to walk the final graph, including variants, dependency tags and providers, without going through
+// PrintJSONGraph.  If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) Graph() ([]*GraphModule, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	nodes := make(map[*moduleInfo]*GraphModule, len(c.modulesSorted))
+	graph := make([]*GraphModule, 0, len(c.modulesSorted))
+	for _, m := range c.modulesSorted {
+		node := &GraphModule{
+			Module:     m.logicModule,
+			Name:       m.Name(),
+			Type:       m.typeName,
+			Blueprint:  m.relBlueprintsFile,
+			Variant:    m.variant.name,
+			Variations: make(map[string]string, m.variant.variations.len()),
+			context:    c,
+			info:       m,
+		}
+		for _, e := range m.variant.variations.entries() {
+			node.Variations[e.mutator] = e.variation
+		}
+		nodes[m] = node
+		graph = append(graph, node)
+	}
+
+	for _, node := range graph {
+		for _, dep := range node.info.directDeps {
+			depNode := nodes[dep.module]
+			node.Deps = append(node.Deps, GraphDep{Module: depNode, Tag: dep.tag})
+			depNode.ReverseDeps = append(depNode.ReverseDeps, node)
+		}
+	}
+
+	return graph, nil
+}
+
+// DotOptions configures the output of Context.PrintDotGraph.
+type DotOptions struct {
+	// GraphML selects GraphML output instead of Graphviz DOT.
+	GraphML bool
+
+	// ModuleTypes, if not empty, limits the graph to modules with one of the listed module types.
+	ModuleTypes []string
+
+	// Variations, if not empty, limits the graph to module variants that have all of the listed
+	// mutator variations.
+	Variations map[string]string
+
+	// DependencyTagFilter, if not nil, is called for each dependency and limits the graph to the
+	// dependencies for which it returns true.
+	DependencyTagFilter func(DependencyTag) bool
+
+	// ClusterByDirectory groups modules into subgraphs by the directory of the Blueprints file
+	// that defined them.  It is ignored for GraphML output.
+	ClusterByDirectory bool
+}
+
+// PrintDotGraph writes the module dependency graph to w as Graphviz DOT or GraphML, filtered
+// according to opts.  Dependencies are only included if both ends of the edge pass the filters.
+func (c *Context) PrintDotGraph(w io.Writer, opts DotOptions) error {
+	moduleTypes := make(map[string]bool, len(opts.ModuleTypes))
+	for _, t := range opts.ModuleTypes {
+		moduleTypes[t] = true
+	}
+	variations := c.variationMaps.newVariationMap(opts.Variations)
+
+	included := make(map[*moduleInfo]bool)
+	var modules []*moduleInfo
+	for _, m := range c.modulesSorted {
+		if len(moduleTypes) > 0 && !moduleTypes[m.typeName] {
+			continue
+		}
+		if !variations.subsetOf(m.variant.variations) {
+			continue
+		}
+		included[m] = true
+		modules = append(modules, m)
+	}
+
+	var edges []graphEdge
+	for _, m := range modules {
+		for _, dep := range m.directDeps {
+			if !included[dep.module] {
+				continue
+			}
+			if opts.DependencyTagFilter != nil && !opts.DependencyTagFilter(dep.tag) {
+				continue
+			}
+			edges = append(edges, graphEdge{m, dep})
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	if opts.GraphML {
+		writeGraphML(buf, modules, edges)
+	} else {
+		writeDotGraph(buf, modules, edges, opts.ClusterByDirectory)
+	}
+	return buf.Flush()
+}
+
+type graphEdge struct {
+	from *moduleInfo
+	dep  depInfo
+}
+
+func graphNodeId(m *moduleInfo) string {
+	if m.variant.name == "" {
+		return m.Name()
+	}
+	return m.Name() + " (" + m.variant.name + ")"
+}
+
+func graphTagLabel(tag DependencyTag) string {
+	if tag == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", tag)
+}
+
+func writeDotGraph(w io.Writer, modules []*moduleInfo, edges []graphEdge, cluster bool) {
+	fmt.Fprintln(w, "digraph blueprint {")
+
+	writeNode := func(indent string, m *moduleInfo) {
+		fmt.Fprintf(w, "%s%s [label=%s];\n", indent, strconv.Quote(graphNodeId(m)),
+			strconv.Quote(graphNodeId(m)+"\n"+m.typeName))
+	}
+
+	if cluster {
+		var dirs []string
+		byDir := make(map[string][]*moduleInfo)
+		for _, m := range modules {
+			dir := m.dir()
+			if _, ok := byDir[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			byDir[dir] = append(byDir[dir], m)
+		}
+		sort.Strings(dirs)
+
+		for _, dir := range dirs {
+			fmt.Fprintf(w, "  subgraph %s {\n", strconv.Quote("cluster_"+dir))
+			fmt.Fprintf(w, "    label=%s;\n", strconv.Quote(dir))
+			for _, m := range byDir[dir] {
+				writeNode("    ", m)
+			}
+			fmt.Fprintln(w, "  }")
+		}
+	} else {
+		for _, m := range modules {
+			writeNode("  ", m)
+		}
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(w, "  %s -> %s", strconv.Quote(graphNodeId(e.from)), strconv.Quote(graphNodeId(e.dep.module)))
+		if label := graphTagLabel(e.dep.tag); label != "" {
+			fmt.Fprintf(w, " [label=%s]", strconv.Quote(label))
+		}
+		fmt.Fprintln(w, ";")
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+func writeGraphML(w io.Writer, modules []*moduleInfo, edges []graphEdge) {
+	escape := func(s string) string {
+		buf := &strings.Builder{}
+		xml.EscapeText(buf, []byte(s))
+		return buf.String()
+	}
+
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(w, `  <key id="type" for="node" attr.name="type" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="blueprint" for="node" attr.name="blueprint" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="tag" for="edge" attr.name="tag" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <graph id="blueprint" edgedefault="directed">`)
+
+	for _, m := range modules {
+		fmt.Fprintf(w, "    <node id=\"%s\">\n", escape(graphNodeId(m)))
+		fmt.Fprintf(w, "      <data key=\"type\">%s</data>\n", escape(m.typeName))
+		fmt.Fprintf(w, "      <data key=\"blueprint\">%s</data>\n", escape(m.relBlueprintsFile))
+		fmt.Fprintln(w, "    </node>")
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\">\n",
+			escape(graphNodeId(e.from)), escape(graphNodeId(e.dep.module)))
+		if label := graphTagLabel(e.dep.tag); label != "" {
+			fmt.Fprintf(w, "      <data key=\"tag\">%s</data>\n", escape(label))
+		}
+		fmt.Fprintln(w, "    </edge>")
+	}
+
+	fmt.Fprintln(w, "  </graph>")
+	fmt.Fprintln(w, "</graphml>")
+}
+
+// JSONGraphVersion is the version of the format written by Context.WriteJSONGraph.  It is
+// incremented whenever a field is removed or its meaning changes, adding fields doesn't change it.
+const JSONGraphVersion = 1
+
+// JSONGraphOptions configures the output of Context.WriteJSONGraph.
+type JSONGraphOptions struct {
+	// Roots, if not empty, limits the graph to the listed modules and the modules that are
+	// reachable from them through dependencies that pass DependencyTagFilter.
+	Roots []Module
+
+	// DependencyTagFilter, if not nil, is called for each dependency and limits the graph to the
+	// dependencies for which it returns true.
+	DependencyTagFilter func(DependencyTag) bool
+
+	// IncludeProviders adds the types of the providers that have been set on each module.
+	IncludeProviders bool
+
+	// IncludeActionCounts adds the number of build statements generated by each module.  The
+	// counts are only meaningful after PrepareBuildActions.
+	IncludeActionCounts bool
+}
+
+// jsonGraphModule is a module in the output of WriteJSONGraph.
+type jsonGraphModule struct {
+	Name       string            `json:"name"`
+	Variant    string            `json:"variant,omitempty"`
+	Type       string            `json:"type"`
+	Blueprint  string            `json:"blueprint"`
+	Variations map[string]string `json:"variations,omitempty"`
+	Deps       []jsonGraphDep    `json:"deps"`
+	Providers  []string          `json:"providers,omitempty"`
+	Actions    *int              `json:"actions,omitempty"`
+}
+
+// jsonGraphDep is a dependency of a module in the output of WriteJSONGraph.
+type jsonGraphDep struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+}
+
+// WriteJSONGraph writes the module dependency graph to w as a JSON object, filtered according to
+// opts.  Modules are written one at a time as they are encoded, so the whole graph is never held
+// in memory, and the first error encoding a module or writing to w is returned.  The object has
+// the form:
+//
+//	{
+//	  "version": 1,
+//	  "modules": [
+//	    {
+//	      "name": "libfoo",
+//	      "variant": "arm64",
+//	      "type": "cc_library",
+//	      "blueprint": "foo/Blueprints",
+//	      "variations": {"arch": "arm64"},
+//	      "deps": [{"name": "libbar", "variant": "arm64", "tag": "cc.dependencyTag"}],
+//	      "providers": ["cc.LinkInfo"],
+//	      "actions": 3
+//	    }
+//	  ]
+//	}
+//
+// The version is JSONGraphVersion.  Modules are sorted so that each module appears after all of
+// its dependencies, and a module is identified by its name and variant.  "variant",
+// "variations" and "tag" are omitted when they are empty, "providers" and "actions" are only
+// present when requested by IncludeProviders and IncludeActionCounts.
+func (c *Context) WriteJSONGraph(w io.Writer, opts JSONGraphOptions) error {
+	var included map[*moduleInfo]bool
+	if len(opts.Roots) > 0 {
+		included = make(map[*moduleInfo]bool)
+		var visit func(m *moduleInfo)
+		visit = func(m *moduleInfo) {
+			if included[m] {
+				return
+			}
+			included[m] = true
+			for _, dep := range m.directDeps {
+				if opts.DependencyTagFilter == nil || opts.DependencyTagFilter(dep.tag) {
+					visit(dep.module)
+				}
+			}
+		}
+		for _, root := range opts.Roots {
+			m := c.moduleInfo[root]
+			if m == nil {
+				return fmt.Errorf("root %v is not a module of this context", root)
+			}
+			visit(m)
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "{\"version\":%d,\"modules\":[", JSONGraphVersion)
+	first := true
+	for _, m := range c.modulesSorted {
+		if included != nil && !included[m] {
+			continue
+		}
+
+		jm := jsonGraphModule{
+			Name:       m.Name(),
+			Variant:    m.variant.name,
+			Type:       m.typeName,
+			Blueprint:  m.relBlueprintsFile,
+			Variations: m.variant.variations.toMap(),
+			Deps:       make([]jsonGraphDep, 0, len(m.directDeps)),
+		}
+		for _, dep := range m.directDeps {
+			if opts.DependencyTagFilter != nil && !opts.DependencyTagFilter(dep.tag) {
+				continue
+			}
+			jm.Deps = append(jm.Deps, jsonGraphDep{
+				Name:    dep.module.Name(),
+				Variant: dep.module.variant.name,
+				Tag:     graphTagLabel(dep.tag),
+			})
+		}
+		if opts.IncludeProviders {
+			for id, value := range m.providers {
+				if value != nil {
+					jm.Providers = append(jm.Providers, providerRegistry[id].typ.String())
+				}
+			}
+		}
+		if opts.IncludeActionCounts {
+			actions := len(m.actionDefs.buildDefs)
+			jm.Actions = &actions
+		}
+
+		data, err := json.Marshal(jm)
+		if err != nil {
+			return fmt.Errorf("failed to encode module %s: %w", m, err)
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		buf.WriteString("\n")
+		// Stop early if w has failed, bufio.Writer keeps returning the first error.
+		if _, err := buf.Write(data); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("\n]}\n")
+
+	return buf.Flush()
+}
@@ -0,0 +1,331 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type graphTestDepTag struct {
+	BaseDependencyTag
+	name string
+}
+
+func graphTestDepsMutator(ctx BottomUpMutatorContext) {
+	if p, ok := ctx.Module().(*providerTestModule); ok {
+		ctx.AddDependency(ctx.Module(), graphTestDepTag{name: "dep"}, p.properties.Deps...)
+	}
+}
+
+func graphTestSplitMutator(ctx BottomUpMutatorContext) {
+	if ctx.ModuleName() == "B" {
+		ctx.CreateVariations("x", "y")
+	}
+}
+
+func TestGraph(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "A",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "C",
+			}
+		`),
+	})
+
+	if _, err := ctx.Graph(); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	graph, err := ctx.Graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	nodes := make(map[string]*GraphModule)
+	for _, node := range graph {
+		id := node.Name + ":" + node.Variant
+		names = append(names, id)
+		nodes[id] = node
+	}
+	if g, w := len(names), 4; g != w {
+		t.Fatalf("expected %d modules, got %d: %q", w, g, names)
+	}
+	if names[0] != "C:" {
+		t.Errorf("expected dependency C to be sorted first, got %q", names)
+	}
+
+	by := nodes["B:y"]
+	if by == nil {
+		t.Fatalf("missing variant B:y in %q", names)
+	}
+	if g, w := by.Variations, map[string]string{"split": "y"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected B:y variations %q, got %q", w, g)
+	}
+	if g, w := by.Type, "provider_module"; g != w {
+		t.Errorf("expected B:y type %q, got %q", w, g)
+	}
+	if len(by.Deps) != 1 || by.Deps[0].Module != nodes["C:"] ||
+		by.Deps[0].Tag != (graphTestDepTag{name: "dep"}) {
+		t.Errorf("expected B:y to depend on C with tag dep, got %+v", by.Deps)
+	}
+
+	if g, w := len(nodes["C:"].ReverseDeps), 3; g != w {
+		t.Errorf("expected C to have %d reverse deps, got %d", w, g)
+	}
+
+	p, ok := by.Provider(providerTestGenerateBuildActionsInfoProvider)
+	if !ok {
+		t.Fatalf("expected provider to be set on B:y")
+	}
+	if g, w := p.(*providerTestGenerateBuildActionsInfo).Value, "B"; g != w {
+		t.Errorf("expected provider value %q, got %q", w, g)
+	}
+}
+
+func TestPrintDotGraph(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			foo_module {
+				name: "F",
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			provider_module {
+				name: "C",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	buf := &bytes.Buffer{}
+	err := ctx.PrintDotGraph(buf, DotOptions{
+		ModuleTypes:        []string{"provider_module"},
+		ClusterByDirectory: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `digraph blueprint {
+  subgraph "cluster_." {
+    label=".";
+    "B (x)" [label="B (x)\nprovider_module"];
+    "B (y)" [label="B (y)\nprovider_module"];
+  }
+  subgraph "cluster_dir" {
+    label="dir";
+    "C" [label="C\nprovider_module"];
+  }
+  "B (x)" -> "C" [label="blueprint.graphTestDepTag"];
+  "B (y)" -> "C" [label="blueprint.graphTestDepTag"];
+}
+`
+	if g := sortedDotLines(buf.String()); g != sortedDotLines(expected) {
+		t.Errorf("incorrect DOT output:\nwant:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	err = ctx.PrintDotGraph(buf, DotOptions{
+		GraphML:    true,
+		Variations: map[string]string{"split": "y"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="type" for="node" attr.name="type" attr.type="string"/>
+  <key id="blueprint" for="node" attr.name="blueprint" attr.type="string"/>
+  <key id="tag" for="edge" attr.name="tag" attr.type="string"/>
+  <graph id="blueprint" edgedefault="directed">
+    <node id="B (y)">
+      <data key="type">provider_module</data>
+      <data key="blueprint">Blueprints</data>
+    </node>
+  </graph>
+</graphml>
+`
+	if g := buf.String(); g != expected {
+		t.Errorf("incorrect GraphML output:\nwant:\n%s\ngot:\n%s", expected, g)
+	}
+}
+
+// sortedDotLines sorts the lines of a DOT graph so that tests don't depend on the order of
+// modules with no dependencies between them.
+func sortedDotLines(s string) string {
+	lines := strings.Split(s, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+type graphTestFailingWriter struct{}
+
+func (graphTestFailingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteJSONGraph(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterModuleType("test", newWriteActionsTestModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "A",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "B",
+			}
+
+			provider_module {
+				name: "C",
+				deps: ["W"],
+			}
+
+			test {
+				name: "W",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var a Module
+	ctx.VisitAllModules(func(m Module) {
+		if ctx.ModuleName(m) == "A" {
+			a = m
+		}
+	})
+
+	t.Run("roots", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := ctx.WriteJSONGraph(buf, JSONGraphOptions{
+			Roots:               []Module{a},
+			IncludeProviders:    true,
+			IncludeActionCounts: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{"version":1,"modules":[
+{"name":"W","type":"test","blueprint":"Blueprints","deps":[],"actions":2},
+{"name":"C","type":"provider_module","blueprint":"Blueprints","deps":[{"name":"W","tag":"blueprint.graphTestDepTag"}],"providers":["*blueprint.providerTestGenerateBuildActionsInfo"],"actions":0},
+{"name":"A","type":"provider_module","blueprint":"Blueprints","deps":[{"name":"C","tag":"blueprint.graphTestDepTag"}],"providers":["*blueprint.providerTestGenerateBuildActionsInfo"],"actions":0}
+]}
+`
+		if g := buf.String(); g != want {
+			t.Errorf("incorrect JSON graph\nwant:\n%s\ngot:\n%s", want, g)
+		}
+	})
+
+	t.Run("tag filter", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := ctx.WriteJSONGraph(buf, JSONGraphOptions{
+			Roots:               []Module{a},
+			DependencyTagFilter: func(DependencyTag) bool { return false },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{"version":1,"modules":[
+{"name":"A","type":"provider_module","blueprint":"Blueprints","deps":[]}
+]}
+`
+		if g := buf.String(); g != want {
+			t.Errorf("incorrect JSON graph\nwant:\n%s\ngot:\n%s", want, g)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		if err := ctx.WriteJSONGraph(graphTestFailingWriter{}, JSONGraphOptions{}); err == nil {
+			t.Errorf("expected error")
+		}
+		if err := ctx.PrintJSONGraph(graphTestFailingWriter{}); err == nil {
+			t.Errorf("expected error from PrintJSONGraph")
+		}
+	})
+}
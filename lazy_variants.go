@@ -0,0 +1,163 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sync"
+)
+
+// lazyVariant is a variant created by BottomUpMutatorContext.CreateLazyVariations that is only
+// turned into a moduleInfo the first time another module selects it as a dependency.
+type lazyVariant struct {
+	variant variant
+	origin  *moduleInfo
+
+	once   sync.Once
+	create func() (*moduleInfo, []error)
+
+	// set by materialize
+	target *moduleInfo
+	errs   []error
+}
+
+func (l *lazyVariant) alias() *moduleAlias           { return nil }
+func (l *lazyVariant) module() *moduleInfo           { return nil }
+func (l *lazyVariant) moduleOrAliasVariant() variant { return l.variant }
+
+func (l *lazyVariant) moduleOrAliasTarget() *moduleInfo {
+	return l.materialize()
+}
+
+// materialize creates the moduleInfo for the variant if it hasn't been created yet.  It is safe to
+// call from multiple goroutines.
+func (l *lazyVariant) materialize() *moduleInfo {
+	l.once.Do(func() {
+		l.target, l.errs = l.create()
+	})
+	return l.target
+}
+
+// createLazyVariations splits origModule like createVariations, but only the first variant is
+// created immediately.  The others are created from a copy of origModule taken before the split
+// when a dependency first resolves to them, and init is called on each variant once it exists.
+func (c *Context) createLazyVariations(origModule *moduleInfo, mutatorName string,
+	depChooser depChooser, variationNames []string,
+	init func(variationName string, module Module)) (modulesOrAliases, []error) {
+
+	if len(variationNames) == 0 {
+		panic(fmt.Errorf("mutator %q passed zero-length variation list for module %q",
+			mutatorName, origModule.Name()))
+	}
+
+	// Snapshot the module before the first variant, which reuses origModule's logic module and
+	// properties, can be modified by init or the mutator.
+	var template *moduleInfo
+	if len(variationNames) > 1 {
+		m := *origModule
+		template = &m
+		template.logicModule, template.properties = c.cloneLogicModule(origModule)
+		template.directDeps = append([]depInfo(nil), origModule.directDeps...)
+		template.reverseDeps = nil
+		template.forwardDeps = nil
+		template.providers = copyProviders(origModule.providers, true)
+		if origModule.provenance != nil {
+			template.provenance = origModule.provenance.Clone()
+		}
+	}
+
+	lazyVariants := make(modulesOrAliases, 0, len(variationNames)-1)
+	for _, variationName := range variationNames[1:] {
+		variationName := variationName
+		lazy := &lazyVariant{
+			variant: c.newVariant(origModule, mutatorName, variationName, false),
+			origin:  origModule,
+		}
+		lazy.create = func() (newModule *moduleInfo, errs []error) {
+			defer func() {
+				if r := recover(); r != nil {
+					errs = append(errs, newPanicErrorf(r, "creating variant %q of %s",
+						lazy.variant.name, origModule))
+				}
+			}()
+
+			newLogicModule, newProperties := c.cloneLogicModule(template)
+
+			m := *template
+			newModule = &m
+			newModule.directDeps = append([]depInfo(nil), template.directDeps...)
+			newModule.logicModule = newLogicModule
+			newModule.properties = newProperties
+			newModule.variant = lazy.variant
+			newModule.providers = copyProviders(template.providers, true)
+			if template.provenance != nil {
+				newModule.provenance = template.provenance.Clone()
+			}
+			// The variant is materialized after the mutator that created it has finished running
+			// on origModule, so the mutator is both started and finished for it rather than
+			// whatever state the template was snapshotted in.
+			newModule.startedMutator = c.startedMutator
+			newModule.finishedMutator = c.startedMutator
+
+			errs = c.convertDepsToVariation(newModule, variationName, depChooser)
+
+			init(variationName, newLogicModule)
+			c.recordPropertyProvenance(newModule, PropertySourceMutator, mutatorName, nil)
+			return newModule, errs
+		}
+		lazyVariants = append(lazyVariants, lazy)
+	}
+
+	newModules, errs := c.createVariations(origModule, mutatorName, depChooser, variationNames[:1], false)
+	init(variationNames[0], newModules.firstModule().logicModule)
+
+	origModule.splitModules = append(origModule.splitModules, lazyVariants...)
+	newModules = append(newModules, lazyVariants...)
+
+	return newModules, errs
+}
+
+// resolveLazyVariants is called at the end of a mutator pass to replace the lazy variants in the
+// split modules of the modules that created them with the variants that were materialized, and
+// to discard the ones that were never used.  It returns the materialized variants and any errors
+// encountered while creating them.
+func resolveLazyVariants(lazyVariants []*lazyVariant) (created []*moduleInfo, errs []error) {
+	origins := make(map[*moduleInfo]bool)
+	for _, lazy := range lazyVariants {
+		if lazy.target != nil {
+			created = append(created, lazy.target)
+		}
+		errs = append(errs, lazy.errs...)
+
+		if origins[lazy.origin] {
+			continue
+		}
+		origins[lazy.origin] = true
+
+		var splitModules modulesOrAliases
+		for _, moduleOrAlias := range lazy.origin.splitModules {
+			if l, ok := moduleOrAlias.(*lazyVariant); ok {
+				if l.target == nil {
+					continue
+				}
+				moduleOrAlias = l.target
+			}
+			splitModules = append(splitModules, moduleOrAlias)
+		}
+		lazy.origin.splitModules = splitModules
+	}
+
+	return created, errs
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func TestCreateLazyVariations(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetTrackPropertyProvenance(true)
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	var lock sync.Mutex
+	var initialized []string
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		switch mctx.ModuleName() {
+		case "A":
+			mctx.CreateLazyVariations(func(variationName string, module Module) {
+				lock.Lock()
+				defer lock.Unlock()
+				initialized = append(initialized, variationName)
+				m := module.(*providerTestModule)
+				m.properties.Deps = append(m.properties.Deps, "init_"+variationName)
+			}, "arm", "arm64", "x86", "x86_64")
+		case "B":
+			mctx.CreateVariations("x86", "arm")
+		case "C":
+			mctx.CreateVariations("x86")
+		}
+	}).Parallel()
+
+	// B and C both depend on the x86 variant of A, B also depends on the arm variant.
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "A",
+			}
+
+			provider_module {
+				name: "B",
+				deps: ["A"],
+			}
+
+			provider_module {
+				name: "C",
+				deps: ["A"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	sort.Strings(initialized)
+	if want := []string{"arm", "x86"}; !reflect.DeepEqual(initialized, want) {
+		t.Errorf("incorrect initialized variants\nwant: %q\n got: %q", want, initialized)
+	}
+
+	var variants []string
+	for _, moduleOrAlias := range ctx.moduleGroupFromName("A", nil).modules {
+		m := moduleOrAlias.module()
+		if m == nil {
+			t.Fatalf("unexpected alias or lazy variant %q", moduleOrAlias.moduleOrAliasVariant().name)
+		}
+		if ctx.moduleInfo[m.logicModule] != m {
+			t.Errorf("variant %q missing from the context", m.variant.name)
+		}
+		variants = append(variants, m.variant.name)
+	}
+	if want := []string{"arm", "x86"}; !reflect.DeepEqual(variants, want) {
+		t.Errorf("incorrect variants of A\nwant: %q\n got: %q", want, variants)
+	}
+
+	// Each variant has its own provenance, which records the changes made by init.  The lazily
+	// created x86 variant was materialized after the arch mutator finished for A, so it has
+	// finished the mutator too.
+	var archMutator *mutatorInfo
+	for _, mutator := range ctx.mutatorInfo {
+		if mutator.name == "arch" {
+			archMutator = mutator
+		}
+	}
+	for _, moduleOrAlias := range ctx.moduleGroupFromName("A", nil).modules {
+		m := moduleOrAlias.module()
+		if m.variant.name == "x86" && (m.startedMutator != archMutator || m.finishedMutator != archMutator) {
+			t.Errorf("expected variant %q to have started and finished the arch mutator", m.variant.name)
+		}
+		sources := m.provenance.Sources("deps")
+		want := proptools.PropertySource{
+			Kind:  PropertySourceMutator,
+			Name:  "arch",
+			Value: []string{"init_" + m.variant.name},
+		}
+		if len(sources) != 1 || !reflect.DeepEqual(sources[0], want) {
+			t.Errorf("incorrect provenance of deps of variant %q\nwant: [%+v]\n got: %+v",
+				m.variant.name, want, sources)
+		}
+	}
+
+	for _, name := range []string{"B", "C"} {
+		for _, moduleOrAlias := range ctx.moduleGroupFromName(name, nil).modules {
+			m := moduleOrAlias.module()
+			if len(m.directDeps) != 1 {
+				t.Fatalf("expected 1 dependency of %s, got %d", m, len(m.directDeps))
+			}
+			if g, w := m.directDeps[0].module.variant.name, m.variant.name; g != w {
+				t.Errorf("%s depends on variant %q of A, want %q", m, g, w)
+			}
+		}
+	}
+}
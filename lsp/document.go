@@ -0,0 +1,218 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/google/blueprint/parser"
+)
+
+// document is the text of a Blueprints file and its syntax tree.
+type document struct {
+	uri        DocumentURI
+	filename   string
+	text       string
+	lineStarts []int
+
+	// file is the syntax tree of the document, or nil if it could not be parsed.
+	file *parser.File
+}
+
+func newDocument(uri DocumentURI, text string) *document {
+	d := &document{
+		uri:        uri,
+		filename:   uriToFilename(uri),
+		text:       text,
+		lineStarts: []int{0},
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	d.file = parse(d.filename, text)
+	return d
+}
+
+func parse(filename, text string) *parser.File {
+	file, errs := parser.Parse(filename, strings.NewReader(text), parser.NewScope(nil))
+	if len(errs) > 0 {
+		return nil
+	}
+	return file
+}
+
+// uriToFilename converts a file:// URI to a path, which is used to report errors.  Other URIs are
+// used unmodified.
+func uriToFilename(uri DocumentURI) string {
+	return strings.TrimPrefix(string(uri), "file://")
+}
+
+// offset returns the byte offset in the document of a position, clamped to the bounds of the
+// line and the document.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	} else if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	offset := d.lineStarts[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16.RuneLen(r)
+		offset += size
+	}
+	return offset
+}
+
+// position returns the position of a byte offset in the document.
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := 0
+	for line+1 < len(d.lineStarts) && d.lineStarts[line+1] <= offset {
+		line++
+	}
+	character := 0
+	for _, r := range d.text[d.lineStarts[line]:offset] {
+		character += utf16.RuneLen(r)
+	}
+	return Position{Line: line, Character: character}
+}
+
+func (d *document) rangeOf(start, end int) Range {
+	return Range{Start: d.position(start), End: d.position(end)}
+}
+
+func (d *document) nodeRange(node parser.Node) Range {
+	return d.rangeOf(node.Pos().Offset, node.End().Offset)
+}
+
+// syntaxContext describes the syntax tree around an offset in a document.
+type syntaxContext struct {
+	// module is the module definition containing the offset, if any.
+	module *parser.Module
+
+	// onModuleType is true if the offset is on the type of the module.
+	onModuleType bool
+
+	// path is the names of the properties whose map values contain the offset, outermost first.
+	path []string
+
+	// propertyMap is the innermost map of properties containing the offset.
+	propertyMap *parser.Map
+
+	// property is set if the offset is on the name of a property in propertyMap.
+	property *parser.Property
+
+	// str is set if the offset is inside a string literal.
+	str *parser.String
+}
+
+// propertyName returns the dotted name of the property the offset is on.
+func (s *syntaxContext) propertyName() string {
+	return propertyPath(s.path, s.property.Name)
+}
+
+// propertyPath returns the dotted name of the property with the given name in the map with the
+// given path of property names.
+func propertyPath(path []string, name string) string {
+	return strings.Join(append(append([]string(nil), path...), name), ".")
+}
+
+// syntaxAt returns the syntax context of an offset in a parsed file.
+func syntaxAt(file *parser.File, offset int) *syntaxContext {
+	s := &syntaxContext{}
+	for _, def := range file.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok || !contains(module, offset) {
+			continue
+		}
+		s.module = module
+		if offset < module.TypePos.Offset+len(module.Type) {
+			s.onModuleType = true
+		} else {
+			s.findInMap(&module.Map, nil, offset)
+		}
+		break
+	}
+	return s
+}
+
+func (s *syntaxContext) findInMap(m *parser.Map, path []string, offset int) {
+	if offset <= m.LBracePos.Offset || offset > m.RBracePos.Offset {
+		return
+	}
+	s.propertyMap = m
+	s.path = path
+	for _, property := range m.Properties {
+		if offset >= property.NamePos.Offset && offset < property.NamePos.Offset+len(property.Name) {
+			s.property = property
+			return
+		}
+		if contains(property.Value, offset) {
+			s.findInExpression(property.Value, append(path, property.Name), offset)
+			return
+		}
+	}
+}
+
+func (s *syntaxContext) findInExpression(e parser.Expression, path []string, offset int) {
+	switch e := e.(type) {
+	case *parser.Map:
+		s.findInMap(e, path, offset)
+	case *parser.String:
+		s.str = e
+	case *parser.List:
+		for _, value := range e.Values {
+			if contains(value, offset) {
+				s.findInExpression(value, path, offset)
+			}
+		}
+	case *parser.Operator:
+		for _, arg := range e.Args {
+			if contains(arg, offset) {
+				s.findInExpression(arg, path, offset)
+			}
+		}
+	case *parser.Select:
+		for _, c := range e.Cases {
+			if c.Value != nil && contains(c.Value, offset) {
+				s.findInExpression(c.Value, path, offset)
+			}
+		}
+	}
+}
+
+func contains(node parser.Node, offset int) bool {
+	return offset >= node.Pos().Offset && offset < node.End().Offset
+}
+
+// moduleName returns the value of the name property of a module definition and the string
+// literal that sets it, or an empty string and nil if it is not set to a string literal.
+func moduleName(module *parser.Module) (string, *parser.String) {
+	for _, property := range module.Properties {
+		if property.Name == "name" {
+			if s, ok := property.Value.(*parser.String); ok {
+				return s.Value, s
+			}
+		}
+	}
+	return "", nil
+}
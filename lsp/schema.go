@@ -0,0 +1,128 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+)
+
+// property describes a property that can be set on a module type, built from the property
+// structs returned by the module type's factory.
+type property struct {
+	name string
+	typ  string
+
+	// properties are the nested properties of a property whose value is a map, sorted by name.
+	properties []*property
+}
+
+// moduleTypeProperties returns the properties of the module type created by factory, sorted by
+// name.
+func moduleTypeProperties(factory blueprint.ModuleFactory) []*property {
+	_, propertyStructs := factory()
+	var properties []*property
+	for _, propertyStruct := range propertyStructs {
+		v := reflect.ValueOf(propertyStruct)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			properties = structProperties(properties, v)
+		}
+	}
+	sortProperties(properties)
+	return properties
+}
+
+// structProperties appends the properties of the fields of structValue to properties, merging
+// nested properties with any existing property of the same name.
+func structProperties(properties []*property, structValue reflect.Value) []*property {
+	structType := structValue.Type()
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" || proptools.HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		fieldValue := structValue.Field(i)
+		if fieldValue.Kind() == reflect.Interface {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		}
+		if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+			if fieldValue.IsNil() {
+				fieldValue = reflect.Zero(fieldValue.Type().Elem())
+			} else {
+				fieldValue = fieldValue.Elem()
+			}
+		}
+
+		if field.Anonymous && fieldValue.Kind() == reflect.Struct {
+			properties = structProperties(properties, fieldValue)
+			continue
+		}
+
+		name := proptools.PropertyNameForField(field.Name)
+		p := findProperty(properties, name)
+		if p == nil {
+			p = &property{name: name, typ: propertyTypeName(fieldValue.Type())}
+			properties = append(properties, p)
+		}
+		if fieldValue.Kind() == reflect.Struct {
+			p.properties = structProperties(p.properties, fieldValue)
+		}
+	}
+	return properties
+}
+
+func propertyTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return propertyTypeName(t.Elem())
+	case reflect.Slice:
+		return "list of " + propertyTypeName(t.Elem())
+	case reflect.Struct:
+		return "map"
+	case reflect.Bool, reflect.String, reflect.Int64:
+		return t.Kind().String()
+	default:
+		return t.String()
+	}
+}
+
+func findProperty(properties []*property, name string) *property {
+	for _, p := range properties {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func sortProperties(properties []*property) {
+	sort.Slice(properties, func(i, j int) bool { return properties[i].name < properties[j].name })
+	for _, p := range properties {
+		sortProperties(p.properties)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+
+	"github.com/google/blueprint"
+)
+
+// ServerName is the name the Server reports to the editor.
+const ServerName = "blueprint-lsp"
+
+// JSON-RPC error codes used by the Server.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// errExitWithoutShutdown is returned by Serve when the editor sends the exit notification
+// without a preceding shutdown request.
+var errExitWithoutShutdown = errors.New("exit notification received without shutdown request")
+
+// Server answers Language Server Protocol requests for Blueprints files using a Workspace.  It
+// supports full document synchronization, go to definition, hover, completion and publishes
+// diagnostics whenever a document is opened or changed.
+type Server struct {
+	workspace *Workspace
+	w         io.Writer
+	shutdown  bool
+}
+
+// NewServer returns a Server that answers requests using workspace.
+func NewServer(workspace *Workspace) *Server {
+	return &Server{workspace: workspace}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   responseError   `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// Serve reads Language Server Protocol messages from r and writes the responses and
+// notifications to w until the editor sends the exit notification or r is closed.  It returns
+// an error if reading or writing fails, or if the editor exits without shutting the server down.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	reader := bufio.NewReader(r)
+	for {
+		body, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.writeError(json.RawMessage("null"), codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, code, err := s.handle(req)
+		if req.ID == nil {
+			// Notifications don't have responses, even if they failed.
+			continue
+		}
+		if err != nil {
+			err = s.writeError(req.ID, code, err.Error())
+		} else {
+			err = s.write(response{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a single request or notification, and returns the result or the error code
+// and error.
+func (s *Server) handle(req request) (interface{}, int, error) {
+	if s.shutdown {
+		return nil, codeInvalidRequest, fmt.Errorf("server is shut down")
+	}
+
+	switch req.Method {
+	case "initialize":
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   1, // Full
+				DefinitionProvider: true,
+				HoverProvider:      true,
+				CompletionProvider: &completionOptions{TriggerCharacters: []string{`"`}},
+			},
+			ServerInfo: serverInfo{Name: ServerName, Version: blueprint.Version()},
+		}, 0, nil
+
+	case "initialized":
+		return nil, 0, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, 0, nil
+
+	case "textDocument/didOpen":
+		var params didOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, codeInvalidParams, err
+		}
+		s.workspace.Update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, 0, s.publishDiagnostics(params.TextDocument.URI, s.workspace.Diagnostics(params.TextDocument.URI))
+
+	case "textDocument/didChange":
+		var params didChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, codeInvalidParams, err
+		}
+		// Only full document synchronization is supported, so the last change with no range is
+		// the new contents of the document.
+		for i := len(params.ContentChanges) - 1; i >= 0; i-- {
+			if params.ContentChanges[i].Range == nil {
+				s.workspace.Update(params.TextDocument.URI, params.ContentChanges[i].Text)
+				break
+			}
+		}
+		return nil, 0, s.publishDiagnostics(params.TextDocument.URI, s.workspace.Diagnostics(params.TextDocument.URI))
+
+	case "textDocument/didClose":
+		var params didCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, codeInvalidParams, err
+		}
+		// The document stays in the Workspace so that its modules can still be found by
+		// Definition, but its diagnostics are no longer shown.
+		return nil, 0, s.publishDiagnostics(params.TextDocument.URI, []Diagnostic{})
+
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, codeInvalidParams, err
+		}
+		return s.workspace.Definition(params.TextDocument.URI, params.Position), 0, nil
+
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, codeInvalidParams, err
+		}
+		if hover := s.workspace.Hover(params.TextDocument.URI, params.Position); hover != nil {
+			return hover, 0, nil
+		}
+		return nil, 0, nil
+
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, codeInvalidParams, err
+		}
+		items := s.workspace.Completion(params.TextDocument.URI, params.Position)
+		if items == nil {
+			items = []CompletionItem{}
+		}
+		return items, 0, nil
+
+	default:
+		return nil, codeMethodNotFound, fmt.Errorf("method %q not found", req.Method)
+	}
+}
+
+func (s *Server) publishDiagnostics(uri DocumentURI, diagnostics []Diagnostic) error {
+	return s.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+func (s *Server) writeError(id json.RawMessage, code int, message string) error {
+	return s.write(errorResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   responseError{Code: code, Message: message},
+	})
+}
+
+func (s *Server) write(message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.w.Write(body)
+	return err
+}
+
+// readMessage reads the body of a single message, which is preceded by a Content-Length header.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err == io.EOF && len(header) == 0 {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read message header: %w", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+	return body, nil
+}
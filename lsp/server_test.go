@@ -0,0 +1,99 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	input := &bytes.Buffer{}
+	send := func(message string) {
+		fmt.Fprintf(input, "Content-Length: %d\r\n\r\n%s", len(message), message)
+	}
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"initialized","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":` +
+		`{"uri":"file:///src/Blueprints","languageId":"blueprint","version":1,` +
+		`"text":"test_module {\n    name: \"foo\",\n    unknown: true,\n}\n"}}}`)
+	send(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":` +
+		`{"textDocument":{"uri":"file:///src/Blueprints"},"position":{"line":0,"character":2}}}`)
+	send(`{"jsonrpc":"2.0","id":3,"method":"textDocument/unknown","params":{}}`)
+	send(`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`)
+	send(`{"jsonrpc":"2.0","method":"exit"}`)
+
+	output := &bytes.Buffer{}
+	if err := NewServer(newTestWorkspace()).Serve(input, output); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var messages []map[string]interface{}
+	reader := bufio.NewReader(output)
+	for {
+		body, err := readMessage(reader)
+		if err != nil {
+			break
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatalf("invalid message %q: %s", body, err)
+		}
+		messages = append(messages, message)
+	}
+
+	if len(messages) != 5 {
+		t.Fatalf("want 5 messages, got %d: %v", len(messages), messages)
+	}
+
+	initialize := messages[0]["result"].(map[string]interface{})
+	if name := initialize["serverInfo"].(map[string]interface{})["name"]; name != ServerName {
+		t.Errorf("want server name %q, got %q", ServerName, name)
+	}
+
+	if method := messages[1]["method"]; method != "textDocument/publishDiagnostics" {
+		t.Errorf("want publishDiagnostics notification, got %q", method)
+	} else {
+		diagnostics := messages[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+		if len(diagnostics) != 1 || !strings.Contains(fmt.Sprint(diagnostics[0]), `unrecognized property "unknown"`) {
+			t.Errorf("want a single unrecognized property diagnostic, got %v", diagnostics)
+		}
+	}
+
+	hover := messages[2]["result"].(map[string]interface{})["contents"].(map[string]interface{})
+	if value := hover["value"]; value != "A module for testing." {
+		t.Errorf("want hover %q, got %q", "A module for testing.", value)
+	}
+
+	if code := messages[3]["error"].(map[string]interface{})["code"]; code != float64(codeMethodNotFound) {
+		t.Errorf("want error code %d for unknown method, got %v", codeMethodNotFound, code)
+	}
+
+	if result, ok := messages[4]["result"]; !ok || result != nil {
+		t.Errorf("want null result for shutdown, got %v", messages[4])
+	}
+}
+
+func TestServerExitWithoutShutdown(t *testing.T) {
+	message := `{"jsonrpc":"2.0","method":"exit"}`
+	input := strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(message), message))
+	if err := NewServer(newTestWorkspace()).Serve(input, &bytes.Buffer{}); err != errExitWithoutShutdown {
+		t.Errorf("want error %q, got %v", errExitWithoutShutdown, err)
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lsp provides the foundation of a language server for Blueprints files.  A Workspace
+// holds the Blueprints files being edited and answers the queries an editor makes: go to the
+// definition of a module referenced by name, completion of module types and property names, hover
+// documentation and diagnostics.  Module types and their properties come from the module
+// factories registered with a blueprint.Context, and documentation from bpdoc.
+//
+// Server speaks the Language Server Protocol over a pair of streams using a Workspace, so a
+// primary builder can offer a language server by registering its module types and calling
+// Serve on stdin and stdout.
+package lsp
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/scanner"
+	"unicode/utf8"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/bootstrap/bpdoc"
+	"github.com/google/blueprint/parser"
+)
+
+// DiagnosticSource is the source reported with every Diagnostic.
+const DiagnosticSource = "blueprint"
+
+// Workspace is a set of Blueprints documents checked against a set of module types.  It is safe
+// to use from multiple goroutines.
+type Workspace struct {
+	lock sync.Mutex
+
+	factories  map[string]blueprint.ModuleFactory
+	properties map[string][]*property
+	docs       map[string]*bpdoc.ModuleType
+	documents  map[DocumentURI]*document
+}
+
+// NewWorkspace returns a Workspace for Blueprints files containing the module types created by
+// the given factories, usually the result of blueprint.Context.ModuleTypeFactories.
+func NewWorkspace(moduleFactories map[string]blueprint.ModuleFactory) *Workspace {
+	return &Workspace{
+		factories:  moduleFactories,
+		properties: make(map[string][]*property),
+		docs:       make(map[string]*bpdoc.ModuleType),
+		documents:  make(map[DocumentURI]*document),
+	}
+}
+
+// SetModuleTypeDocs sets the documentation of the module types and their properties shown by
+// Hover and Completion, for example the result of bootstrap.ModuleTypeDocs.
+func (w *Workspace) SetModuleTypeDocs(packages []*bpdoc.Package) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.docs = make(map[string]*bpdoc.ModuleType)
+	for _, pkg := range packages {
+		for _, moduleType := range pkg.ModuleTypes {
+			w.docs[moduleType.Name] = moduleType
+		}
+	}
+}
+
+// Update sets the contents of a document, adding it to the Workspace if necessary.  Every
+// document in the Workspace is searched for module definitions by Definition, not just the ones
+// open in an editor.
+func (w *Workspace) Update(uri DocumentURI, text string) {
+	d := newDocument(uri, text)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.documents[uri] = d
+}
+
+// Remove removes a document from the Workspace.
+func (w *Workspace) Remove(uri DocumentURI) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.documents, uri)
+}
+
+func (w *Workspace) document(uri DocumentURI) *document {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.documents[uri]
+}
+
+// Diagnostics returns the syntax errors and the unknown module types and properties in a
+// document, as reported by blueprint.CheckBlueprintSyntax.
+func (w *Workspace) Diagnostics(uri DocumentURI) []Diagnostic {
+	d := w.document(uri)
+	if d == nil {
+		return nil
+	}
+
+	diagnostics := []Diagnostic{}
+	for _, err := range blueprint.CheckBlueprintSyntax(w.factories, d.filename, d.text) {
+		var pos scanner.Position
+		message := err.Error()
+		switch err := err.(type) {
+		case *parser.ParseError:
+			pos, message = err.Pos, err.Err.Error()
+		case *blueprint.BlueprintError:
+			pos, message = err.Pos, err.Err.Error()
+		case *blueprint.ModuleError:
+			pos, message = err.Pos, err.Err.Error()
+		case *blueprint.PropertyError:
+			pos, message = err.Pos, err.Err.Error()
+		}
+		start := 0
+		if pos.IsValid() {
+			start = pos.Offset
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.rangeOf(start, wordEnd(d.text, start)),
+			Severity: SeverityError,
+			Source:   DiagnosticSource,
+			Message:  message,
+		})
+	}
+	return diagnostics
+}
+
+// wordEnd returns the offset of the end of the identifier starting at offset, or of the character
+// at offset if there is no identifier there, so that the range of a diagnostic is never empty.
+func wordEnd(text string, offset int) int {
+	end := offset
+	for end < len(text) && isIdentifierByte(text[end]) {
+		end++
+	}
+	if end == offset && end < len(text) && text[end] != '\n' {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	return end
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Definition returns the locations of the definitions of the module whose name is the string
+// literal at a position in a document.  References may use the ":name" and "//path:name" forms,
+// and may have an "{tag}" suffix.
+func (w *Workspace) Definition(uri DocumentURI, pos Position) []Location {
+	d := w.document(uri)
+	if d == nil || d.file == nil {
+		return nil
+	}
+	s := syntaxAt(d.file, d.offset(pos))
+	if s.str == nil {
+		return nil
+	}
+	name := referencedModuleName(s.str.Value)
+	if name == "" {
+		return nil
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var locations []Location
+	for _, other := range w.sortedDocuments() {
+		if other.file == nil {
+			continue
+		}
+		for _, def := range other.file.Defs {
+			module, ok := def.(*parser.Module)
+			if !ok {
+				continue
+			}
+			if moduleName, nameString := moduleName(module); moduleName == name {
+				locations = append(locations, Location{
+					URI:   other.uri,
+					Range: other.nodeRange(nameString),
+				})
+			}
+		}
+	}
+	return locations
+}
+
+// referencedModuleName returns the name of the module referenced by a string property value.
+func referencedModuleName(reference string) string {
+	if i := strings.IndexByte(reference, '{'); i >= 0 {
+		reference = reference[:i]
+	}
+	if i := strings.LastIndexByte(reference, ':'); i >= 0 {
+		reference = reference[i+1:]
+	}
+	return reference
+}
+
+func (w *Workspace) sortedDocuments() []*document {
+	documents := make([]*document, 0, len(w.documents))
+	for _, d := range w.documents {
+		documents = append(documents, d)
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].uri < documents[j].uri })
+	return documents
+}
+
+// Completion returns the completions at a position in a document: module types outside of
+// module definitions, the properties of the module type that are not already set inside module
+// definitions, and the names of the modules in the Workspace inside string literals.
+func (w *Workspace) Completion(uri DocumentURI, pos Position) []CompletionItem {
+	d := w.document(uri)
+	if d == nil {
+		return nil
+	}
+	offset := d.offset(pos)
+
+	// Inside a string literal, complete module names.
+	if d.file != nil {
+		if s := syntaxAt(d.file, offset); s.str != nil {
+			prefix := d.text[s.str.LiteralPos.Offset+1 : offset]
+			return w.moduleNameCompletions(prefix)
+		}
+	}
+
+	// The identifier being typed is usually a syntax error, so blank it out before parsing.
+	start := offset
+	for start > 0 && isIdentifierByte(d.text[start-1]) {
+		start--
+	}
+	prefix := d.text[start:offset]
+	file := parse(d.filename, d.text[:start]+strings.Repeat(" ", len(prefix))+d.text[offset:])
+	if file == nil {
+		return nil
+	}
+
+	s := syntaxAt(file, offset)
+	var items []CompletionItem
+	if s.module == nil {
+		for _, moduleType := range w.moduleTypes() {
+			if strings.HasPrefix(moduleType, prefix) {
+				items = append(items, CompletionItem{
+					Label:         moduleType,
+					Kind:          CompletionKindClass,
+					Documentation: w.moduleTypeDoc(moduleType),
+				})
+			}
+		}
+	} else if s.propertyMap != nil && s.str == nil {
+		set := make(map[string]bool)
+		for _, property := range s.propertyMap.Properties {
+			set[property.Name] = true
+		}
+		for _, p := range w.propertiesAt(s.module.Type, s.path) {
+			if !set[p.name] && strings.HasPrefix(p.name, prefix) {
+				items = append(items, CompletionItem{
+					Label:         p.name,
+					Kind:          CompletionKindProperty,
+					Detail:        p.typ,
+					Documentation: w.propertyDoc(s.module.Type, propertyPath(s.path, p.name)),
+				})
+			}
+		}
+	}
+	return items
+}
+
+func (w *Workspace) moduleNameCompletions(prefix string) []CompletionItem {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var items []CompletionItem
+	seen := make(map[string]bool)
+	for _, d := range w.sortedDocuments() {
+		if d.file == nil {
+			continue
+		}
+		for _, def := range d.file.Defs {
+			if module, ok := def.(*parser.Module); ok {
+				name, _ := moduleName(module)
+				if name != "" && !seen[name] && strings.HasPrefix(name, prefix) {
+					seen[name] = true
+					items = append(items, CompletionItem{
+						Label:  name,
+						Kind:   CompletionKindModule,
+						Detail: module.Type,
+					})
+				}
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func (w *Workspace) moduleTypes() []string {
+	moduleTypes := make([]string, 0, len(w.factories))
+	for moduleType := range w.factories {
+		moduleTypes = append(moduleTypes, moduleType)
+	}
+	sort.Strings(moduleTypes)
+	return moduleTypes
+}
+
+// propertiesAt returns the properties of a module type that can be set in the map with the
+// given path of property names.
+func (w *Workspace) propertiesAt(moduleType string, path []string) []*property {
+	w.lock.Lock()
+	properties, ok := w.properties[moduleType]
+	w.lock.Unlock()
+	if !ok {
+		factory := w.factories[moduleType]
+		if factory == nil {
+			return nil
+		}
+		properties = moduleTypeProperties(factory)
+		w.lock.Lock()
+		w.properties[moduleType] = properties
+		w.lock.Unlock()
+	}
+
+	for _, name := range path {
+		p := findProperty(properties, name)
+		if p == nil {
+			return nil
+		}
+		properties = p.properties
+	}
+	return properties
+}
+
+// Hover returns the documentation of the module type or the property name at a position in a
+// document, or nil if there is none.
+func (w *Workspace) Hover(uri DocumentURI, pos Position) *Hover {
+	d := w.document(uri)
+	if d == nil || d.file == nil {
+		return nil
+	}
+	s := syntaxAt(d.file, d.offset(pos))
+
+	var contents *MarkupContent
+	var r Range
+	switch {
+	case s.onModuleType:
+		contents = w.moduleTypeDoc(s.module.Type)
+		r = d.rangeOf(s.module.TypePos.Offset, s.module.TypePos.Offset+len(s.module.Type))
+	case s.property != nil:
+		contents = w.propertyDoc(s.module.Type, s.propertyName())
+		r = d.rangeOf(s.property.NamePos.Offset, s.property.NamePos.Offset+len(s.property.Name))
+	}
+	if contents == nil {
+		return nil
+	}
+	return &Hover{Contents: *contents, Range: &r}
+}
+
+func (w *Workspace) moduleTypeDoc(moduleType string) *MarkupContent {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	doc := w.docs[moduleType]
+	if doc == nil || doc.Text == "" {
+		return nil
+	}
+	return &MarkupContent{Kind: PlainText, Value: plainText(doc.Text)}
+}
+
+func (w *Workspace) propertyDoc(moduleType, name string) *MarkupContent {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	doc := w.docs[moduleType]
+	if doc == nil {
+		return nil
+	}
+	for _, propertyStruct := range doc.PropertyStructs {
+		if p := propertyStruct.GetByName(name); p != nil && p.Text != "" {
+			value := plainText(p.Text)
+			if p.Type != "" {
+				value = p.Type + "\n\n" + value
+			}
+			return &MarkupContent{Kind: PlainText, Value: value}
+		}
+	}
+	return nil
+}
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// plainText converts the HTML formatted documentation produced by bpdoc to plain text.
+func plainText(text template.HTML) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRegexp.ReplaceAllString(string(text), "")))
+}
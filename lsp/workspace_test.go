@@ -0,0 +1,244 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/bootstrap/bpdoc"
+)
+
+type testModule struct {
+	blueprint.SimpleName
+	properties struct {
+		Srcs    []string
+		Deps    []string
+		Enabled *bool
+		Target  struct {
+			Host struct {
+				Cflags []string
+			}
+		}
+	}
+}
+
+func newTestModule() (blueprint.Module, []interface{}) {
+	m := &testModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *testModule) GenerateBuildActions(blueprint.ModuleContext) {}
+
+const testURI = DocumentURI("file:///src/Blueprints")
+const otherURI = DocumentURI("file:///src/lib/Blueprints")
+
+func newTestWorkspace() *Workspace {
+	w := NewWorkspace(map[string]blueprint.ModuleFactory{
+		"test_module":  newTestModule,
+		"test_library": newTestModule,
+	})
+	w.SetModuleTypeDocs([]*bpdoc.Package{{
+		ModuleTypes: []*bpdoc.ModuleType{{
+			Name: "test_module",
+			Text: "<p>A module for testing.</p>",
+			PropertyStructs: []*bpdoc.PropertyStruct{{
+				Properties: []bpdoc.Property{
+					{Name: "srcs", Type: "list of string", Text: "<p>The source files &amp; headers.</p>"},
+					{Name: "target", Properties: []bpdoc.Property{
+						{Name: "host", Properties: []bpdoc.Property{
+							{Name: "cflags", Type: "list of string", Text: "Flags for the host."},
+						}},
+					}},
+				},
+			}},
+		}},
+	}})
+	w.Update(otherURI, `test_library {
+    name: "libfoo",
+}
+`)
+	return w
+}
+
+// cursor returns the text with the "|" marker removed and the position of the marker.
+func cursor(t *testing.T, text string) (string, Position) {
+	t.Helper()
+	i := strings.Index(text, "|")
+	if i < 0 {
+		t.Fatalf("missing cursor in %q", text)
+	}
+	d := newDocument(testURI, text[:i]+text[i+1:])
+	return d.text, d.position(i)
+}
+
+func TestDefinition(t *testing.T) {
+	w := newTestWorkspace()
+	text, pos := cursor(t, `test_module {
+    name: "foo",
+    deps: [":lib|foo"],
+}
+`)
+	w.Update(testURI, text)
+
+	got := w.Definition(testURI, pos)
+	want := []Location{{
+		URI:   otherURI,
+		Range: Range{Start: Position{1, 10}, End: Position{1, 18}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want definition %v, got %v", want, got)
+	}
+
+	if got := w.Definition(testURI, Position{0, 2}); got != nil {
+		t.Errorf("want no definition for the module type, got %v", got)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	labels := func(items []CompletionItem) []string {
+		var ret []string
+		for _, item := range items {
+			ret = append(ret, item.Label)
+		}
+		return ret
+	}
+
+	testCases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "module types",
+			text: "test_m|",
+			want: []string{"test_module"},
+		},
+		{
+			name: "properties",
+			text: `test_module {
+    name: "foo",
+    s|
+}`,
+			want: []string{"srcs"},
+		},
+		{
+			name: "unset properties",
+			text: `test_module {
+    name: "foo",
+    srcs: [],
+    |
+}`,
+			want: []string{"deps", "enabled", "target"},
+		},
+		{
+			name: "nested properties",
+			text: `test_module {
+    target: {
+        host: {
+            |
+        },
+    },
+}`,
+			want: []string{"cflags"},
+		},
+		{
+			name: "module names",
+			text: `test_module {
+    name: "foo",
+    deps: ["li|"],
+}`,
+			want: []string{"libfoo"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			w := newTestWorkspace()
+			text, pos := cursor(t, testCase.text)
+			w.Update(testURI, text)
+			if got := labels(w.Completion(testURI, pos)); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("want completions %q, got %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestHover(t *testing.T) {
+	w := newTestWorkspace()
+	w.Update(testURI, `test_module {
+    srcs: ["a.c"],
+    target: {
+        host: {
+            cflags: ["-DHOST"],
+        },
+    },
+}
+`)
+
+	testCases := []struct {
+		name string
+		pos  Position
+		want string
+	}{
+		{"module type", Position{0, 3}, "A module for testing."},
+		{"property", Position{1, 5}, "list of string\n\nThe source files & headers."},
+		{"nested property", Position{4, 14}, "list of string\n\nFlags for the host."},
+		{"value", Position{1, 12}, ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			hover := w.Hover(testURI, testCase.pos)
+			got := ""
+			if hover != nil {
+				got = hover.Contents.Value
+			}
+			if got != testCase.want {
+				t.Errorf("want hover %q, got %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	w := newTestWorkspace()
+	w.Update(testURI, `test_module {
+    name: "foo",
+    unknown: true,
+}
+
+bad_module {
+}
+`)
+	got := w.Diagnostics(testURI)
+	want := []Diagnostic{
+		{
+			Range:    Range{Start: Position{2, 11}, End: Position{2, 12}},
+			Severity: SeverityError,
+			Source:   DiagnosticSource,
+			Message:  `unrecognized property "unknown"`,
+		},
+		{
+			Range:    Range{Start: Position{5, 0}, End: Position{5, 10}},
+			Severity: SeverityError,
+			Source:   DiagnosticSource,
+			Message:  `unrecognized module type "bad_module"`,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect diagnostics:\nwant %+v\n got %+v", want, got)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// metadataEntry is a call to ModuleContext.RecordMetadata, with the value already encoded so that
+// later changes to it by the module are not recorded.
+type metadataEntry struct {
+	key   string
+	value json.RawMessage
+}
+
+// moduleMetadata is a value recorded under a key by a module variant, in the format it is written
+// to the metadata file.
+type moduleMetadata struct {
+	Module  string          `json:"module"`
+	Variant string          `json:"variant,omitempty"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// SetMetadataFile sets the path of the file that WriteBuildFile and WriteBuildFileSharded write
+// the values recorded with ModuleContext.RecordMetadata to, after the Ninja manifest was written
+// successfully.  No metadata file is written if path is empty, which is the default.
+func (c *Context) SetMetadataFile(path string) {
+	c.metadataFile = path
+}
+
+// WriteMetadata writes the values recorded with ModuleContext.RecordMetadata to w as a JSON
+// object that maps each key to the list of values recorded under it.  Each value is an object
+// with the name and variant of the module that recorded it, sorted by name and then by variant.
+// If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) WriteMetadata(w io.Writer) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
+
+	metadata := make(map[string][]moduleMetadata)
+	for _, module := range c.modulesSorted {
+		for _, entry := range module.metadata {
+			metadata[entry.key] = append(metadata[entry.key], moduleMetadata{
+				Module:  module.Name(),
+				Variant: module.variant.name,
+				Value:   entry.value,
+			})
+		}
+	}
+
+	for _, values := range metadata {
+		sort.SliceStable(values, func(i, j int) bool {
+			if values[i].Module != values[j].Module {
+				return values[i].Module < values[j].Module
+			}
+			return values[i].Variant < values[j].Variant
+		})
+	}
+
+	// encoding/json sorts the keys of maps, which makes the output deterministic.
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeMetadataFile writes the metadata file set by SetMetadataFile, if any.  The file is written
+// to a temporary file and renamed so that an interrupted write can't leave a truncated file
+// behind.
+func (c *Context) writeMetadataFile() error {
+	if c.metadataFile == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(c.metadataFile)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("error writing metadata file: %s", err)
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(c.metadataFile)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error writing metadata file: %s", err)
+	}
+	err = c.WriteMetadata(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.metadataFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing metadata file: %s", err)
+	}
+	return nil
+}
+
+func (m *moduleContext) RecordMetadata(key string, value interface{}) {
+	for _, entry := range m.module.metadata {
+		if entry.key == key {
+			m.ModuleErrorf("metadata %q already recorded", key)
+			return
+		}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		m.ModuleErrorf("failed to encode metadata %q: %s", key, err)
+		return
+	}
+	m.module.metadata = append(m.module.metadata, metadataEntry{key: key, value: data})
+}
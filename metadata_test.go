@@ -0,0 +1,202 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type metadataTestModule struct {
+	SimpleName
+	properties struct {
+		Owner     string
+		Variants  []string
+		Duplicate bool
+	}
+}
+
+func newMetadataTestModule() (Module, []interface{}) {
+	m := &metadataTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *metadataTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if m.properties.Owner != "" {
+		ctx.RecordMetadata("owners", m.properties.Owner)
+	}
+	ctx.RecordMetadata("variants", map[string]interface{}{
+		"type":    ctx.ModuleType(),
+		"variant": ctx.ModuleSubDir(),
+	})
+	if m.properties.Duplicate {
+		ctx.RecordMetadata("variants", nil)
+	}
+}
+
+func (m *metadataTestModule) CacheConfigDependencies(config interface{}) []string {
+	return nil
+}
+
+func metadataTestMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*metadataTestModule); ok && len(m.properties.Variants) > 0 {
+		ctx.CreateVariations(m.properties.Variants...)
+	}
+}
+
+func runMetadataTest(t *testing.T, bp string, setup func(ctx *Context)) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	ctx.RegisterModuleType("metadata_module", newMetadataTestModule)
+	ctx.RegisterBottomUpMutator("variants", metadataTestMutator)
+	if setup != nil {
+		setup(ctx)
+	}
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	return ctx, errs
+}
+
+const metadataTestBlueprints = `
+	metadata_module {
+		name: "b",
+		owner: "owner_b",
+		variants: ["y", "x"],
+	}
+
+	metadata_module {
+		name: "a",
+		owner: "owner_a",
+	}
+`
+
+const metadataTestWant = `{
+  "owners": [
+    {
+      "module": "a",
+      "value": "owner_a"
+    },
+    {
+      "module": "b",
+      "variant": "x",
+      "value": "owner_b"
+    },
+    {
+      "module": "b",
+      "variant": "y",
+      "value": "owner_b"
+    }
+  ],
+  "variants": [
+    {
+      "module": "a",
+      "value": {
+        "type": "metadata_module",
+        "variant": ""
+      }
+    },
+    {
+      "module": "b",
+      "variant": "x",
+      "value": {
+        "type": "metadata_module",
+        "variant": "x"
+      }
+    },
+    {
+      "module": "b",
+      "variant": "y",
+      "value": {
+        "type": "metadata_module",
+        "variant": "y"
+      }
+    }
+  ]
+}
+`
+
+func TestRecordMetadata(t *testing.T) {
+	metadataFile := filepath.Join(t.TempDir(), "out", "metadata.json")
+	ctx, errs := runMetadataTest(t, metadataTestBlueprints, func(ctx *Context) {
+		ctx.SetMetadataFile(metadataFile)
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	if err := ctx.WriteBuildFile(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(metadataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := string(data), metadataTestWant; g != w {
+		t.Errorf("want metadata file:\n%s\ngot:\n%s", w, g)
+	}
+}
+
+func TestRecordMetadataBuildActionsCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "build_actions_cache")
+	for _, run := range []string{"first run", "cached"} {
+		t.Run(run, func(t *testing.T) {
+			ctx, errs := runMetadataTest(t, metadataTestBlueprints, func(ctx *Context) {
+				ctx.SetBuildActionsCacheFile(cacheFile)
+			})
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %s", errs)
+			}
+
+			buf := &bytes.Buffer{}
+			if err := ctx.WriteMetadata(buf); err != nil {
+				t.Fatal(err)
+			}
+			if g, w := buf.String(), metadataTestWant; g != w {
+				t.Errorf("want metadata:\n%s\ngot:\n%s", w, g)
+			}
+			if run == "cached" && ctx.buildActionsCache.hits != 3 {
+				t.Errorf("want 3 cache hits, got %d", ctx.buildActionsCache.hits)
+			}
+		})
+	}
+}
+
+func TestRecordMetadataErrors(t *testing.T) {
+	_, errs := runMetadataTest(t, `
+		metadata_module {
+			name: "a",
+			duplicate: true,
+		}
+	`, nil)
+	want := `metadata "variants" already recorded`
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), want) {
+		t.Errorf("want a single error containing %q, got %q", want, errs)
+	}
+
+	ctx := NewContext()
+	if err := ctx.WriteMetadata(&bytes.Buffer{}); err != ErrBuildActionsNotReady {
+		t.Errorf("want ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+}
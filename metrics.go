@@ -0,0 +1,199 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Categories of the events recorded by metrics.
+const (
+	metricsPhase     = "phase"
+	metricsMutator   = "mutator"
+	metricsSingleton = "singleton"
+	metricsModule    = "module"
+	metricsMemory    = "memory"
+)
+
+// metrics records the wall time of the phases, mutators, singletons and modules processed by a
+// Context, and snapshots of its memory use, if enabled with SetCollectMetrics.  All methods are
+// no-ops on a nil *metrics, so callers don't need to check whether metrics are enabled.
+type metrics struct {
+	lock   sync.Mutex
+	start  time.Time
+	events []metricsEvent
+}
+
+type metricsEvent struct {
+	category string
+	name     string
+	start    time.Time
+	end      time.Time
+	args     map[string]interface{}
+}
+
+func newMetrics() *metrics {
+	return &metrics{start: time.Now()}
+}
+
+// begin returns the start time of an event that will be passed to end.
+func (m *metrics) begin() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// end records an event of the given category and name that started at start.
+func (m *metrics) end(category, name string, start time.Time, args map[string]interface{}) {
+	if m == nil {
+		return
+	}
+	m.add(metricsEvent{
+		category: category,
+		name:     name,
+		start:    start,
+		end:      time.Now(),
+		args:     args,
+	})
+}
+
+// snapshotMemory records the current memory use of the process.  It stops the world, so it is
+// only called between mutators, singletons and phases.
+func (m *metrics) snapshotMemory() {
+	if m == nil {
+		return
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	now := time.Now()
+	m.add(metricsEvent{
+		category: metricsMemory,
+		name:     "memory",
+		start:    now,
+		end:      now,
+		args: map[string]interface{}{
+			"heap_alloc": stats.HeapAlloc,
+			"heap_sys":   stats.HeapSys,
+			"sys":        stats.Sys,
+		},
+	})
+}
+
+func (m *metrics) add(event metricsEvent) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.events = append(m.events, event)
+}
+
+// SetCollectMetrics sets whether the Context records the wall time of each mutator, singleton and
+// module, and snapshots of its memory use, during ResolveDependencies and PrepareBuildActions.
+// The metrics can be written with WriteTrace.
+func (c *Context) SetCollectMetrics(collectMetrics bool) {
+	c.checkRegistration("SetCollectMetrics")
+	if collectMetrics {
+		c.metrics = newMetrics()
+	} else {
+		c.metrics = nil
+	}
+}
+
+// traceEvent is an event in the Chrome trace event format, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU.
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur,omitempty"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// WriteTrace writes the metrics collected by the Context in the Chrome trace event JSON format,
+// which can be viewed in chrome://tracing or https://ui.perfetto.dev.  Phases, mutators and
+// singletons are on the first thread, the modules that were processed in parallel are spread over
+// the following threads and memory snapshots are counters.  SetCollectMetrics must have been
+// called before parsing.
+func (c *Context) WriteTrace(w io.Writer) error {
+	if c.metrics == nil {
+		return errors.New("metrics collection is not enabled, call SetCollectMetrics")
+	}
+
+	c.metrics.lock.Lock()
+	events := append([]metricsEvent(nil), c.metrics.events...)
+	c.metrics.lock.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].start.Before(events[j].start)
+	})
+
+	microseconds := func(t time.Time) int64 {
+		return t.Sub(c.metrics.start).Microseconds()
+	}
+
+	// Modules processed in parallel can overlap, give each one the first thread that is free when
+	// it starts so that the threads contain no overlapping events.
+	var threadEnds []time.Time
+	moduleThread := func(event metricsEvent) int {
+		for i, end := range threadEnds {
+			if !end.After(event.start) {
+				threadEnds[i] = event.end
+				return i + 1
+			}
+		}
+		threadEnds = append(threadEnds, event.end)
+		return len(threadEnds)
+	}
+
+	trace := traceFile{
+		TraceEvents:     make([]traceEvent, 0, len(events)),
+		DisplayTimeUnit: "ms",
+	}
+	for _, event := range events {
+		e := traceEvent{
+			Name:      event.name,
+			Category:  event.category,
+			Timestamp: microseconds(event.start),
+			Args:      event.args,
+		}
+		switch event.category {
+		case metricsMemory:
+			e.Phase = "C"
+		case metricsModule:
+			e.Phase = "X"
+			e.Duration = event.end.Sub(event.start).Microseconds()
+			e.Tid = moduleThread(event)
+		default:
+			e.Phase = "X"
+			e.Duration = event.end.Sub(event.start).Microseconds()
+		}
+		trace.TraceEvents = append(trace.TraceEvents, e)
+	}
+
+	return json.NewEncoder(w).Encode(trace)
+}
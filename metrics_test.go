@@ -0,0 +1,103 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type metricsTestSingleton struct{}
+
+func (s *metricsTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+}
+
+func TestWriteTrace(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetCollectMetrics(true)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("test_mutator", func(ctx BottomUpMutatorContext) {}).Parallel()
+	ctx.RegisterSingletonType("test_singleton", func() Singleton { return &metricsTestSingleton{} })
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteTrace(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var trace traceFile
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("invalid trace: %s\n%s", err, buf.String())
+	}
+
+	found := make(map[string]int)
+	threadEnds := make(map[int]int64)
+	for _, event := range trace.TraceEvents {
+		found[event.Category+" "+event.Name]++
+		if event.Category == metricsModule {
+			if event.Tid == 0 {
+				t.Errorf("expected module event %q to not be on the first thread", event.Name)
+			}
+			if event.Timestamp < threadEnds[event.Tid] {
+				t.Errorf("module event %q overlaps the previous event on thread %d", event.Name, event.Tid)
+			}
+			threadEnds[event.Tid] = event.Timestamp + event.Duration
+		}
+	}
+
+	for _, want := range []string{
+		"phase ResolveDependencies",
+		"phase PrepareBuildActions",
+		"mutator test_mutator",
+		"singleton test_singleton",
+		`module module "A"`,
+		`module module "B"`,
+	} {
+		if found[want] == 0 {
+			t.Errorf("missing event %q in trace:\n%s", want, buf.String())
+		}
+	}
+	if found["memory memory"] == 0 {
+		t.Errorf("missing memory snapshots in trace:\n%s", buf.String())
+	}
+}
+
+func TestWriteTraceDisabled(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.WriteTrace(&bytes.Buffer{}); err == nil {
+		t.Errorf("expected an error when metrics collection is not enabled")
+	}
+}
@@ -16,10 +16,11 @@ package blueprint
 
 import (
 	"fmt"
-	"path/filepath"
+	"io"
 	"strings"
 	"sync"
 	"text/scanner"
+	"time"
 
 	"github.com/google/blueprint/parser"
 	"github.com/google/blueprint/pathtools"
@@ -123,6 +124,45 @@ type DynamicDependerModule interface {
 	DynamicDependencies(DynamicDependerModuleContext) []string
 }
 
+// A DisableableModule is a Module that can be disabled, usually by a property.  Any Module that
+// implements this interface will have its BlueprintEnabled method called by the Context after all
+// mutators have run.  Disabled modules do not have GenerateBuildActions called on them, and
+// dependencies onto them from enabled modules are handled according to
+// Context.SetDisabledDependencyBehavior.  The method is not named Enabled so that module types
+// that already have an Enabled method for their own purposes are not disabled by accident.
+type DisableableModule interface {
+	Module
+
+	// BlueprintEnabled returns false if the module should be disabled.
+	BlueprintEnabled() bool
+}
+
+// A HeavyModule is a Module whose GenerateBuildActions does expensive work, for example parsing
+// protobuf files or merging manifests.  The Context calls the Heavy method of any Module that
+// implements this interface before GenerateBuildActions, and runs GenerateBuildActions for heavy
+// modules in a smaller pool of workers, see Context.SetHeavyVisitParallelism.  A mutator can
+// also mark a module as heavy with BaseMutatorContext.MarkHeavy.
+type HeavyModule interface {
+	Module
+
+	// Heavy returns true if GenerateBuildActions for the module is expensive.
+	Heavy() bool
+}
+
+// An IncomingDependencyCheckerModule is a Module that validates the dependencies that other modules
+// have on it.  Any Module that implements this interface will have its CheckIncomingDependency method
+// called by the Context once for each direct dependency on it after all mutators have run.  This allows
+// library-like modules to enforce constraints on how they are used, for example API levels, visibility or
+// linkage, with errors reported at the position of the depending module.
+type IncomingDependencyCheckerModule interface {
+	Module
+
+	// CheckIncomingDependency is called with the tag and the depending module for each direct
+	// dependency on the IncomingDependencyCheckerModule.  A non-nil error rejects the dependency and is
+	// reported as an error in the depending module.
+	CheckIncomingDependency(tag DependencyTag, from Module) error
+}
+
 type EarlyModuleContext interface {
 	// Module returns the current module as a Module.  It should rarely be necessary, as the module already has a
 	// reference to itself.
@@ -132,7 +172,9 @@ type EarlyModuleContext interface {
 	// the module was created, but may have been modified by calls to BaseMutatorContext.Rename.
 	ModuleName() string
 
-	// ModuleDir returns the path to the directory that contains the defintion of the module.
+	// ModuleDir returns the path to the directory that contains the defintion of the module.  It is
+	// always a clean slash-separated path relative to the source root, "." for the root directory,
+	// on every platform.
 	ModuleDir() string
 
 	// ModuleType returns the name of the module type that was used to create the module, as specified in
@@ -140,7 +182,7 @@ type EarlyModuleContext interface {
 	ModuleType() string
 
 	// BlueprintFile returns the name of the blueprint file that contains the definition of this
-	// module.
+	// module, as a clean slash-separated path relative to the source root.
 	BlueprintsFile() string
 
 	// Config returns the config object that was passed to Context.PrepareBuildActions.
@@ -149,6 +191,11 @@ type EarlyModuleContext interface {
 	// ContainsProperty returns true if the specified property name was set in the module definition.
 	ContainsProperty(name string) bool
 
+	// PropertyProvenance returns the sources that changed the value of the given property of the
+	// module, oldest first, if Context.SetTrackPropertyProvenance was enabled, or nil otherwise.
+	// Changes made by the currently running load hook or mutator are not included yet.
+	PropertyProvenance(name string) []proptools.PropertySource
+
 	// Errorf reports an error at the specified position of the module definition file.
 	Errorf(pos scanner.Position, fmt string, args ...interface{})
 
@@ -158,6 +205,29 @@ type EarlyModuleContext interface {
 	// PropertyErrorf reports an error at the line number of a property in the module definition.
 	PropertyErrorf(property, fmt string, args ...interface{})
 
+	// Warningf reports a warning at the line number of the module type in the module definition.
+	// Warnings do not cause the build to fail, and are returned by Context.Warnings.
+	Warningf(fmt string, args ...interface{})
+
+	// PropertyWarningf reports a warning at the line number of a property in the module
+	// definition.
+	PropertyWarningf(property, fmt string, args ...interface{})
+
+	// DirectoryMetadata returns the metadata from the per-directory metadata files with the given
+	// name that applies to the directory of the module, or nil if there are none.  The metadata
+	// files are added as dependencies of the primary builder.  An error parsing one of the files is
+	// reported as an error of the module.  See Context.RegisterDirectoryMetadata.
+	DirectoryMetadata(filename string) interface{}
+
+	// PackageDefaults returns the defaults set by the package modules that apply to the directory
+	// of the module, see PackageDefaults.  The lists in the returned value must not be modified.
+	PackageDefaults() PackageDefaults
+
+	// TopLevelVariable returns the value of a top-level variable registered with
+	// Context.RegisterTopLevelVariable in the Blueprints file that defines the module, and false if
+	// the file does not assign the variable.
+	TopLevelVariable(name string) (TopLevelVariable, bool)
+
 	// Failed returns true if any errors have been reported.  In most cases the module can continue with generating
 	// build rules after an error, allowing it to report additional errors in a single run, but in cases where the error
 	// has prevented the module from creating necessary data it can return early when Failed returns true.
@@ -307,15 +377,29 @@ type BaseModuleContext interface {
 	// dependency on with the same argument.
 	OtherModuleReverseDependencyVariantExists(name string) bool
 
+	// MissingOptionalDependencies returns the names passed to AddOptionalDependency or
+	// AddVariationOptionalDependency for the current module that did not match any module, in the
+	// order they were first added.  Optional dependencies on modules that exist are visited by
+	// VisitDirectDeps like any other dependency.
+	MissingOptionalDependencies() []string
+
 	// OtherModuleProvider returns the value for a provider for the given module.  If the value is
 	// not set it returns the zero value of the type of the provider, so the return value can always
-	// be type asserted to the type of the provider.  The value returned may be a deep copy of the
-	// value originally passed to SetProvider.
+	// be type asserted to the type of the provider.  It panics if called before the provider is
+	// final for the given module, which is after the mutator associated with the provider has
+	// finished for the module, or after its GenerateBuildActions has finished for providers that
+	// are not associated with a mutator.  During GenerateBuildActions that means the provider
+	// values of dependencies can be read, but not those of dependents.  It also panics if the
+	// given module was not obtained from this Context, for example through VisitDirectDeps.  The
+	// value returned may be a deep copy of the value originally passed to SetProvider.
 	OtherModuleProvider(m Module, provider ProviderKey) interface{}
 
-	// OtherModuleHasProvider returns true if the provider for the given module has been set.
+	// OtherModuleHasProvider returns true if the provider for the given module has been set.  It
+	// panics in the same cases as OtherModuleProvider.
 	OtherModuleHasProvider(m Module, provider ProviderKey) bool
 
+	otherModuleProvider(m Module, provider ProviderKey) (interface{}, bool)
+
 	// Provider returns the value for a provider for the current module.  If the value is
 	// not set it returns the zero value of the type of the provider, so the return value can always
 	// be type asserted to the type of the provider.  It panics if called before the appropriate
@@ -326,10 +410,14 @@ type BaseModuleContext interface {
 	// HasProvider returns true if the provider for the current module has been set.
 	HasProvider(provider ProviderKey) bool
 
-	// SetProvider sets the value for a provider for the current module.  It panics if not called
-	// during the appropriate mutator or GenerateBuildActions pass for the provider, if the value
-	// is not of the appropriate type, or if the value has already been set.  The value should not
-	// be modified after being passed to SetProvider.
+	// SetProvider sets the value for a provider for the current module.  A module can only set
+	// its own provider values, and only while the mutator associated with the provider is running
+	// for the module, or during its GenerateBuildActions for providers that are not associated
+	// with a mutator.  It panics if not called during the appropriate mutator or
+	// GenerateBuildActions pass for the provider, if the value is not of the appropriate type, if
+	// the value has already been set, or if the current module was split into variants by the
+	// current mutator, in which case SetVariationProvider must be used on the new variants.  The
+	// value should not be modified after being passed to SetProvider.
 	SetProvider(provider ProviderKey, value interface{})
 }
 
@@ -352,10 +440,45 @@ type ModuleContext interface {
 	// Build creates a new ninja build statement.
 	Build(pctx PackageContext, params BuildParams)
 
+	// Phony declares a phony target with the given name that depends on deps.  Unlike a build
+	// statement with the Phony rule, the same phony target can be declared by multiple modules and
+	// singletons, and it will depend on the deps of all of them.  It is an error to declare a phony
+	// target with the name of an output of a build statement.  Phony targets are not built by
+	// default.
+	Phony(name string, deps ...string)
+
+	// DescribePhony sets the description of a phony target, which is printed by the help target
+	// set with Context.SetPhonyHelpTarget.  It is an error for two modules or singletons to give
+	// the same phony target different descriptions.
+	DescribePhony(name, description string)
+
+	// InputFile declares that path is a source file used by the build statements of the module, and
+	// returns it as a clean slash-separated path, with any '\' treated as a separator.  Declared
+	// files are only checked if Context.SetTrackPaths is enabled, where they are compared to the
+	// inputs of the build statements after Ninja variables are expanded.  An empty path is
+	// reported as an error.
+	InputFile(path string) string
+
+	// OutputFile declares that path is built by one of the build statements of the module and may
+	// be used as an input by the modules that depend on it directly, and returns it normalized the
+	// same way as InputFile.  Declared files are only checked if Context.SetTrackPaths is enabled.
+	OutputFile(path string) string
+
+	// RecordMetadata records value under key in the metadata file set with
+	// Context.SetMetadataFile, which merges the values recorded by all modules under each key.
+	// The value is encoded with encoding/json when RecordMetadata is called.  It is an error for a
+	// module to record the same key more than once, or to record a value that can't be encoded.
+	RecordMetadata(key string, value interface{})
+
 	// GetMissingDependencies returns the list of dependencies that were passed to AddDependencies or related methods,
 	// but do not exist.  It can be used with Context.SetAllowMissingDependencies to allow the primary builder to
 	// handle missing dependencies on its own instead of having Blueprint treat them as an error.
 	GetMissingDependencies() []string
+
+	// PropertiesHash returns a stable hash of the current values of all of the module's property
+	// structs, computed with proptools.HashProperties.  It changes whenever the value of any
+	// property of this variant changes, and can be used as a cache key or for change detection.
+	PropertiesHash() string
 }
 
 var _ BaseModuleContext = (*baseModuleContext)(nil)
@@ -368,6 +491,9 @@ type baseModuleContext struct {
 	visitingParent *moduleInfo
 	visitingDep    depInfo
 	ninjaFileDeps  []string
+
+	// set by the methods whose results the build actions cache can't key on, see CacheableModule
+	uncacheable bool
 }
 
 func (d *baseModuleContext) moduleInfo() *moduleInfo {
@@ -391,8 +517,12 @@ func (d *baseModuleContext) ContainsProperty(name string) bool {
 	return ok
 }
 
+func (d *baseModuleContext) PropertyProvenance(name string) []proptools.PropertySource {
+	return d.context.propertyProvenance(d.module, name)
+}
+
 func (d *baseModuleContext) ModuleDir() string {
-	return filepath.Dir(d.module.relBlueprintsFile)
+	return d.module.dir()
 }
 
 func (d *baseModuleContext) BlueprintsFile() string {
@@ -451,16 +581,75 @@ func (d *baseModuleContext) PropertyErrorf(property, format string,
 	})
 }
 
+func (d *baseModuleContext) Warningf(format string, args ...interface{}) {
+	d.uncacheable = true
+	d.context.addWarning(WarningClassModule, &ModuleError{
+		BlueprintError: BlueprintError{
+			Err: fmt.Errorf(format, args...),
+			Pos: d.module.pos,
+		},
+		module: d.module,
+	})
+}
+
+func (d *baseModuleContext) PropertyWarningf(property, format string, args ...interface{}) {
+	d.uncacheable = true
+	pos := d.module.propertyPos[property]
+
+	if !pos.IsValid() {
+		pos = d.module.pos
+	}
+
+	d.context.addWarning(WarningClassModule, &PropertyError{
+		ModuleError: ModuleError{
+			BlueprintError: BlueprintError{
+				Err: fmt.Errorf(format, args...),
+				Pos: pos,
+			},
+			module: d.module,
+		},
+		property: property,
+	})
+}
+
+func (d *baseModuleContext) DirectoryMetadata(filename string) interface{} {
+	d.uncacheable = true
+	metadata := d.context.lookupDirectoryMetadata(filename, d.ModuleDir())
+	d.AddNinjaFileDeps(metadata.files...)
+	if metadata.err != nil {
+		d.error(metadata.err)
+		return nil
+	}
+	return metadata.value
+}
+
+func (d *baseModuleContext) PackageDefaults() PackageDefaults {
+	d.uncacheable = true
+	defaults, _ := d.context.packageDefaults(d.ModuleDir())
+	return defaults
+}
+
+func (d *baseModuleContext) TopLevelVariable(name string) (TopLevelVariable, bool) {
+	d.uncacheable = true
+	return d.context.TopLevelVariable(d.module.relBlueprintsFile, name)
+}
+
 func (d *baseModuleContext) Failed() bool {
 	return len(d.errs) > 0
 }
 
 func (d *baseModuleContext) GlobWithDeps(pattern string,
 	excludes []string) ([]string, error) {
+	d.uncacheable = true
+	start := time.Now()
+	defer func() {
+		d.context.addBlueprintsFileTime(d.module.relBlueprintsFile, 0, 0, time.Since(start))
+	}()
 	return d.context.glob(pattern, excludes)
 }
 
 func (d *baseModuleContext) Fs() pathtools.FileSystem {
+	d.uncacheable = true
 	return d.context.fs
 }
 
@@ -475,6 +664,9 @@ type moduleContext struct {
 	scope              *localScope
 	actionDefs         localBuildActions
 	handledMissingDeps bool
+
+	// set when the build actions of the module are being recorded for the build actions cache
+	recorder *buildActionsRecorder
 }
 
 func (m *baseModuleContext) OtherModuleName(logicModule Module) string {
@@ -484,7 +676,7 @@ func (m *baseModuleContext) OtherModuleName(logicModule Module) string {
 
 func (m *baseModuleContext) OtherModuleDir(logicModule Module) string {
 	module := m.context.moduleInfo[logicModule]
-	return filepath.Dir(module.relBlueprintsFile)
+	return module.dir()
 }
 
 func (m *baseModuleContext) OtherModuleSubDir(logicModule Module) string {
@@ -525,37 +717,48 @@ func (m *baseModuleContext) OtherModuleDependencyTag(logicModule Module) Depende
 	return nil
 }
 
+func (m *baseModuleContext) MissingOptionalDependencies() []string {
+	return m.module.missingOptionalDeps
+}
+
 func (m *baseModuleContext) OtherModuleExists(name string) bool {
+	m.uncacheable = true
 	_, exists := m.context.nameInterface.ModuleFromName(name, m.module.namespace())
 	return exists
 }
 
 func (m *baseModuleContext) OtherModuleDependencyVariantExists(variations []Variation, name string) bool {
+	m.uncacheable = true
 	possibleDeps := m.context.moduleGroupFromName(name, m.module.namespace())
 	if possibleDeps == nil {
 		return false
 	}
-	found, _ := findVariant(m.module, possibleDeps, variations, false, false)
+	found, _ := m.context.findVariant(m.module, possibleDeps, variations, false, false)
 	return found != nil
 }
 
 func (m *baseModuleContext) OtherModuleReverseDependencyVariantExists(name string) bool {
+	m.uncacheable = true
 	possibleDeps := m.context.moduleGroupFromName(name, m.module.namespace())
 	if possibleDeps == nil {
 		return false
 	}
-	found, _ := findVariant(m.module, possibleDeps, nil, false, true)
+	found, _ := m.context.findVariant(m.module, possibleDeps, nil, false, true)
 	return found != nil
 }
 
 func (m *baseModuleContext) OtherModuleProvider(logicModule Module, provider ProviderKey) interface{} {
-	module := m.context.moduleInfo[logicModule]
+	module := m.context.providerModule(logicModule, provider)
 	value, _ := m.context.provider(module, provider)
 	return value
 }
 
+func (m *baseModuleContext) otherModuleProvider(logicModule Module, provider ProviderKey) (interface{}, bool) {
+	return m.context.provider(m.context.providerModule(logicModule, provider), provider)
+}
+
 func (m *baseModuleContext) OtherModuleHasProvider(logicModule Module, provider ProviderKey) bool {
-	module := m.context.moduleInfo[logicModule]
+	module := m.context.providerModule(logicModule, provider)
 	_, ok := m.context.provider(module, provider)
 	return ok
 }
@@ -643,6 +846,7 @@ func (m *baseModuleContext) VisitDirectDepsIf(pred func(Module) bool, visit func
 }
 
 func (m *baseModuleContext) VisitDepsDepthFirst(visit func(Module)) {
+	m.uncacheable = true
 	defer func() {
 		if r := recover(); r != nil {
 			panic(newPanicErrorf(r, "VisitDepsDepthFirst(%s, %s) for dependency %s",
@@ -662,6 +866,7 @@ func (m *baseModuleContext) VisitDepsDepthFirst(visit func(Module)) {
 
 func (m *baseModuleContext) VisitDepsDepthFirstIf(pred func(Module) bool,
 	visit func(Module)) {
+	m.uncacheable = true
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -683,6 +888,7 @@ func (m *baseModuleContext) VisitDepsDepthFirstIf(pred func(Module) bool,
 }
 
 func (m *baseModuleContext) WalkDeps(visit func(child, parent Module) bool) {
+	m.uncacheable = true
 	m.context.walkDeps(m.module, true, func(dep depInfo, parent *moduleInfo) bool {
 		m.visitingParent = parent
 		m.visitingDep = dep
@@ -694,14 +900,17 @@ func (m *baseModuleContext) WalkDeps(visit func(child, parent Module) bool) {
 }
 
 func (m *baseModuleContext) PrimaryModule() Module {
+	m.uncacheable = true
 	return m.module.group.modules.firstModule().logicModule
 }
 
 func (m *baseModuleContext) FinalModule() Module {
+	m.uncacheable = true
 	return m.module.group.modules.lastModule().logicModule
 }
 
 func (m *baseModuleContext) VisitAllModuleVariants(visit func(Module)) {
+	m.uncacheable = true
 	m.context.visitAllModuleVariants(m.module, visit)
 }
 
@@ -730,6 +939,10 @@ func (m *moduleContext) Variable(pctx PackageContext, name, value string) {
 	}
 
 	m.actionDefs.variables = append(m.actionDefs.variables, v)
+
+	if m.recorder != nil {
+		m.recorder.variable(pctx, name, value)
+	}
 }
 
 func (m *moduleContext) Rule(pctx PackageContext, name string,
@@ -744,6 +957,10 @@ func (m *moduleContext) Rule(pctx PackageContext, name string,
 
 	m.actionDefs.rules = append(m.actionDefs.rules, r)
 
+	if m.recorder != nil {
+		m.recorder.rule(pctx, name, params, argNames, r)
+	}
+
 	return r
 }
 
@@ -755,7 +972,46 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 		panic(err)
 	}
 
+	m.context.recordNinjaFeatures(&params)
+
+	if !m.context.isDefaultTarget(m.module) {
+		def.Optional = true
+	}
+
+	if m.context.annotateBuildStatements {
+		annotateBuildDef(def, m.module.String(), 1)
+	}
+
 	m.actionDefs.buildDefs = append(m.actionDefs.buildDefs, def)
+
+	if m.recorder != nil {
+		m.recorder.build(pctx, params)
+	}
+}
+
+func (m *moduleContext) InputFile(path string) string {
+	path = m.declaredPath("InputFile", path)
+	if path != "" {
+		m.module.declaredInputs = append(m.module.declaredInputs, path)
+	}
+	return path
+}
+
+func (m *moduleContext) OutputFile(path string) string {
+	path = m.declaredPath("OutputFile", path)
+	if path != "" {
+		m.module.declaredOutputs = append(m.module.declaredOutputs, path)
+	}
+	return path
+}
+
+// declaredPath validates and normalizes a path passed to InputFile or OutputFile.
+func (m *moduleContext) declaredPath(method, path string) string {
+	if path == "" {
+		m.ModuleErrorf("%s called with an empty path", method)
+		return ""
+	}
+	return pathtools.NormalizePath(path)
 }
 
 func (m *moduleContext) GetMissingDependencies() []string {
@@ -763,6 +1019,10 @@ func (m *moduleContext) GetMissingDependencies() []string {
 	return m.module.missingDeps
 }
 
+func (m *moduleContext) PropertiesHash() string {
+	return m.context.modulePropertiesHash(m.module)
+}
+
 //
 // MutatorContext
 //
@@ -788,6 +1048,28 @@ type BaseMutatorContext interface {
 
 	// MutatorName returns the name that this mutator was registered with.
 	MutatorName() string
+
+	// Disable marks the current variant of the module as disabled.  Variants created from it by later
+	// mutators are also disabled.  Disabled modules do not have GenerateBuildActions called on them,
+	// and dependencies onto them from enabled modules are handled according to
+	// Context.SetDisabledDependencyBehavior.
+	Disable()
+
+	// MarkHeavy marks the current variant of the module as heavy, see HeavyModule.  Variants
+	// created from it by later mutators are also heavy.
+	MarkHeavy()
+
+	// MarkDefaultTarget marks the current variant of the module as a default target.  Once any
+	// variant of a module is marked, the build statements of its other variants are written
+	// without a Ninja default statement, as if BuildParams.Optional was set, so that only the
+	// marked variants are built when Ninja is run without targets.  Variants created from it by
+	// later mutators are also marked.  See Context.IsDefaultTarget.
+	MarkDefaultTarget()
+
+	// MarkDistTarget marks the current variant of the module as a dist target, whose outputs
+	// singletons should include in the distribution of the build.  Variants created from it by
+	// later mutators are also marked.  See Context.IsDistTarget.
+	MarkDistTarget()
 }
 
 type EarlyMutatorContext interface {
@@ -837,6 +1119,14 @@ type BottomUpMutatorContext interface {
 	// be ordered correctly for all future mutator passes.
 	AddDependency(module Module, tag DependencyTag, name ...string) []Module
 
+	// Pause waits until the current mutator has finished running on the given module, so that
+	// the current module can read providers or properties that the mutator set on it even though
+	// the module is not a dependency of the current module.  It is only supported by parallel
+	// mutators (see MutatorHandle.Parallel); it returns true after waiting, or false without
+	// waiting if the mutator is not parallel.  If waiting on the module would cause a cycle the
+	// mutator pass fails with an error describing the cycle.
+	Pause(until Module) bool
+
 	// AddReverseDependency adds a dependency from the destination to the given module.
 	// Does not affect the ordering of the current mutator pass, but will be ordered
 	// correctly for all future mutator passes.  All reverse dependencies for a destination module are
@@ -866,6 +1156,19 @@ type BottomUpMutatorContext interface {
 	// that contains all the non-local variations.
 	CreateLocalVariations(...string) []Module
 
+	// CreateLazyVariations splits a module into multiple variants like CreateVariations, but only
+	// the first variant is created immediately.  Each of the other variants is created from a copy of
+	// the module as it was before the split the first time a dependency on the module resolves to it
+	// during the current mutator pass.  Variants that nothing depends on by the end of the pass are
+	// never created.  init is called with the variation name and the new module for each variant
+	// that is created, and should make any per-variant changes that CreateVariations callers would
+	// make to the returned modules.
+	//
+	// CreateLazyVariations is intended for mutators that split modules into many variants of which
+	// only a few are used, for example one per supported target.  Use CreateVariations for variants
+	// that must exist even if nothing depends on them.
+	CreateLazyVariations(init func(variationName string, module Module), variationNames ...string)
+
 	// SetDependencyVariation sets all dangling dependencies on the current module to point to the variation
 	// with given name. This function ignores the default variation set by SetDefaultDependencyVariation.
 	SetDependencyVariation(string)
@@ -900,6 +1203,16 @@ type BottomUpMutatorContext interface {
 	// be ordered correctly for all future mutator passes.
 	AddFarVariationDependencies([]Variation, DependencyTag, ...string) []Module
 
+	// AddOptionalDependency is like AddDependency, but a name that doesn't match any module is
+	// skipped instead of reported as an error, and is returned by MissingOptionalDependencies.
+	// The entry in the returned slice for a skipped name is nil.  Other errors, for example a
+	// missing variant of a module that exists, are still reported.
+	AddOptionalDependency(module Module, tag DependencyTag, name ...string) []Module
+
+	// AddVariationOptionalDependency is like AddVariationDependencies, but a name that doesn't
+	// match any module is skipped the same way as by AddOptionalDependency.
+	AddVariationOptionalDependency(variations []Variation, tag DependencyTag, name ...string) []Module
+
 	// AddInterVariantDependency adds a dependency between two variants of the same module.  Variants are always
 	// ordered in the same orderas they were listed in CreateVariations, and AddInterVariantDependency does not change
 	// that ordering, but it associates a DependencyTag with the dependency and makes it visible to VisitDirectDeps,
@@ -976,12 +1289,50 @@ func (mctx *mutatorContext) MutatorName() string {
 	return mctx.name
 }
 
+func (mctx *mutatorContext) Disable() {
+	mctx.module.disabled = true
+}
+
+func (mctx *mutatorContext) MarkHeavy() {
+	mctx.module.heavy = true
+}
+
+func (mctx *mutatorContext) MarkDefaultTarget() {
+	mctx.module.defaultTarget = true
+}
+
+func (mctx *mutatorContext) MarkDistTarget() {
+	mctx.module.distTarget = true
+}
+
 func (mctx *mutatorContext) CreateVariations(variationNames ...string) []Module {
-	return mctx.createVariations(variationNames, false)
+	depChooser := chooseDepInherit(mctx.name, mctx.defaultVariation)
+	return mctx.createVariations(variationNames, depChooser, false)
+}
+
+func (mctx *mutatorContext) createVariationsWithTransition(transition transitionFunc, variationNames ...string) []Module {
+	return mctx.createVariations(variationNames, chooseDepByTransition(mctx.name, transition), false)
 }
 
 func (mctx *mutatorContext) CreateLocalVariations(variationNames ...string) []Module {
-	return mctx.createVariations(variationNames, true)
+	depChooser := chooseDepInherit(mctx.name, mctx.defaultVariation)
+	return mctx.createVariations(variationNames, depChooser, true)
+}
+
+func (mctx *mutatorContext) CreateLazyVariations(init func(variationName string, module Module),
+	variationNames ...string) {
+
+	depChooser := chooseDepInherit(mctx.name, mctx.defaultVariation)
+	modules, errs := mctx.context.createLazyVariations(mctx.module, mctx.name, depChooser,
+		variationNames, init)
+	if len(errs) > 0 {
+		mctx.errs = append(mctx.errs, errs...)
+	}
+
+	if mctx.newVariations != nil {
+		panic("module already has variations from this mutator")
+	}
+	mctx.newVariations = modules
 }
 
 func (mctx *mutatorContext) SetVariationProvider(module Module, provider ProviderKey, value interface{}) {
@@ -999,9 +1350,9 @@ type pendingAlias struct {
 	target      *moduleInfo
 }
 
-func (mctx *mutatorContext) createVariations(variationNames []string, local bool) []Module {
+func (mctx *mutatorContext) createVariations(variationNames []string, depChooser depChooser, local bool) []Module {
 	ret := []Module{}
-	modules, errs := mctx.context.createVariations(mctx.module, mctx.name, mctx.defaultVariation, variationNames, local)
+	modules, errs := mctx.context.createVariations(mctx.module, mctx.name, depChooser, variationNames, local)
 	if len(errs) > 0 {
 		mctx.errs = append(mctx.errs, errs...)
 	}
@@ -1032,7 +1383,7 @@ func (mctx *mutatorContext) AliasVariation(variationName string) {
 	}
 
 	for _, variant := range mctx.newVariations {
-		if variant.moduleOrAliasVariant().variations[mctx.name] == variationName {
+		if variant.moduleOrAliasVariant().variations.get(mctx.name) == variationName {
 			alias := &moduleAlias{
 				variant: mctx.module.variant,
 				target:  variant.moduleOrAliasTarget(),
@@ -1046,13 +1397,13 @@ func (mctx *mutatorContext) AliasVariation(variationName string) {
 
 	var foundVariations []string
 	for _, variant := range mctx.newVariations {
-		foundVariations = append(foundVariations, variant.moduleOrAliasVariant().variations[mctx.name])
+		foundVariations = append(foundVariations, variant.moduleOrAliasVariant().variations.get(mctx.name))
 	}
 	panic(fmt.Errorf("no %q variation in module variations %q", variationName, foundVariations))
 }
 
 func (mctx *mutatorContext) CreateAliasVariation(aliasVariationName, targetVariationName string) {
-	newVariant := newVariant(mctx.module, mctx.name, aliasVariationName, false)
+	newVariant := mctx.context.newVariant(mctx.module, mctx.name, aliasVariationName, false)
 
 	for _, moduleOrAlias := range mctx.module.splitModules {
 		if moduleOrAlias.moduleOrAliasVariant().variations.equal(newVariant.variations) {
@@ -1065,7 +1416,7 @@ func (mctx *mutatorContext) CreateAliasVariation(aliasVariationName, targetVaria
 	}
 
 	for _, variant := range mctx.newVariations {
-		if variant.moduleOrAliasVariant().variations[mctx.name] == targetVariationName {
+		if variant.moduleOrAliasVariant().variations.get(mctx.name) == targetVariationName {
 			// Append the alias here so that it comes after any aliases created by AliasVariation.
 			mctx.module.splitModules = append(mctx.module.splitModules, &moduleAlias{
 				variant: newVariant,
@@ -1077,13 +1428,14 @@ func (mctx *mutatorContext) CreateAliasVariation(aliasVariationName, targetVaria
 
 	var foundVariations []string
 	for _, variant := range mctx.newVariations {
-		foundVariations = append(foundVariations, variant.moduleOrAliasVariant().variations[mctx.name])
+		foundVariations = append(foundVariations, variant.moduleOrAliasVariant().variations.get(mctx.name))
 	}
 	panic(fmt.Errorf("no %q variation in module variations %q", targetVariationName, foundVariations))
 }
 
 func (mctx *mutatorContext) SetDependencyVariation(variationName string) {
-	mctx.context.convertDepsToVariation(mctx.module, mctx.name, variationName, nil)
+	mctx.context.convertDepsToVariation(mctx.module, variationName,
+		chooseDepExplicit(mctx.name, variationName, nil))
 }
 
 func (mctx *mutatorContext) SetDefaultDependencyVariation(variationName *string) {
@@ -1111,6 +1463,17 @@ func (mctx *mutatorContext) AddDependency(module Module, tag DependencyTag, deps
 	return depInfos
 }
 
+func (mctx *mutatorContext) Pause(until Module) bool {
+	untilInfo := mctx.context.moduleInfo[until]
+	if untilInfo == nil {
+		panic(fmt.Errorf("Pause called on a module that is not known to the Context"))
+	}
+	if untilInfo == mctx.module {
+		panic(fmt.Errorf("module %q cannot Pause on itself", mctx.ModuleName()))
+	}
+	return mctx.pause(untilInfo)
+}
+
 func (mctx *mutatorContext) AddReverseDependency(module Module, tag DependencyTag, destName string) {
 	if _, ok := tag.(BaseDependencyTag); ok {
 		panic("BaseDependencyTag is not allowed to be used directly!")
@@ -1124,7 +1487,7 @@ func (mctx *mutatorContext) AddReverseDependency(module Module, tag DependencyTa
 
 	mctx.reverseDeps = append(mctx.reverseDeps, reverseDep{
 		destModule,
-		depInfo{mctx.context.moduleInfo[module], tag},
+		depInfo{mctx.context.moduleInfo[module], tag, mctx.context.startedMutator},
 	})
 }
 
@@ -1164,6 +1527,47 @@ func (mctx *mutatorContext) AddFarVariationDependencies(variations []Variation,
 	return depInfos
 }
 
+func (mctx *mutatorContext) AddOptionalDependency(module Module, tag DependencyTag,
+	deps ...string) []Module {
+
+	depInfos := make([]Module, 0, len(deps))
+	for _, dep := range deps {
+		modInfo := mctx.context.moduleInfo[module]
+		depInfo, errs := mctx.context.addOptionalDependency(modInfo, dep, func() (*moduleInfo, []error) {
+			return mctx.context.addDependency(modInfo, tag, dep)
+		})
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+		}
+		if !mctx.pause(depInfo) {
+			// Pausing not supported by this mutator, new dependencies can't be returned.
+			depInfo = nil
+		}
+		depInfos = append(depInfos, maybeLogicModule(depInfo))
+	}
+	return depInfos
+}
+
+func (mctx *mutatorContext) AddVariationOptionalDependency(variations []Variation, tag DependencyTag,
+	deps ...string) []Module {
+
+	depInfos := make([]Module, 0, len(deps))
+	for _, dep := range deps {
+		depInfo, errs := mctx.context.addOptionalDependency(mctx.module, dep, func() (*moduleInfo, []error) {
+			return mctx.context.addVariationDependency(mctx.module, variations, tag, dep, false)
+		})
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+		}
+		if !mctx.pause(depInfo) {
+			// Pausing not supported by this mutator, new dependencies can't be returned.
+			depInfo = nil
+		}
+		depInfos = append(depInfos, maybeLogicModule(depInfo))
+	}
+	return depInfos
+}
+
 func (mctx *mutatorContext) AddInterVariantDependency(tag DependencyTag, from, to Module) {
 	mctx.context.addInterVariantDependency(mctx.module, tag, from, to)
 }
@@ -1200,12 +1604,17 @@ func (mctx *mutatorContext) CreateModule(factory ModuleFactory, props ...interfa
 	module.propertyPos = mctx.module.propertyPos
 	module.createdBy = mctx.module
 
+	if mctx.context.trackPropertyProvenance {
+		module.provenance = proptools.NewProvenance(module.properties...)
+	}
+
 	for _, p := range props {
 		err := proptools.AppendMatchingProperties(module.properties, p, nil)
 		if err != nil {
 			panic(err)
 		}
 	}
+	mctx.context.recordPropertyProvenance(module, PropertySourceCreated, mctx.module.Name(), nil)
 
 	mctx.newModules = append(mctx.newModules, module)
 
@@ -1267,12 +1676,17 @@ func (l *loadHookContext) CreateModule(factory ModuleFactory, props ...interface
 	module.propertyPos = l.module.propertyPos
 	module.createdBy = l.module
 
+	if l.context.trackPropertyProvenance {
+		module.provenance = proptools.NewProvenance(module.properties...)
+	}
+
 	for _, p := range props {
 		err := proptools.AppendMatchingProperties(module.properties, p, nil)
 		if err != nil {
 			panic(err)
 		}
 	}
+	l.context.recordPropertyProvenance(module, PropertySourceCreated, l.module.Name(), nil)
 
 	l.newModules = append(l.newModules, module)
 
@@ -1342,6 +1756,7 @@ func runAndRemoveLoadHooks(ctx *Context, config interface{}, module *moduleInfo,
 			errs = append(errs, mctx.errs...)
 		}
 		pendingHooks.Delete(module.logicModule)
+		ctx.recordPropertyProvenance(module, PropertySourceLoadHook, "", nil)
 
 		return newModules, errs
 	}
@@ -1353,7 +1768,8 @@ func runAndRemoveLoadHooks(ctx *Context, config interface{}, module *moduleInfo,
 //
 // This is intended to perform a quick syntactic check for generated blueprint
 // code, where syntactically correct means:
-// * No variable definitions.
+// * No variable definitions, except for the build, subdirs and optional_subdirs variables.
+// * No include directives.
 // * Valid module types.
 // * Valid property names.
 // * Valid values for the property type.
@@ -1365,6 +1781,7 @@ func runAndRemoveLoadHooks(ctx *Context, config interface{}, module *moduleInfo,
 // * Parses the contents.
 // * Invokes relevant factory to create Module instances.
 // * Unpacks the properties into the Module.
+// * Checks the values of the build, subdirs and optional_subdirs variables.
 // * Does not invoke load hooks or any mutators.
 //
 // The filename is only used for reporting errors.
@@ -1378,14 +1795,109 @@ func CheckBlueprintSyntax(moduleFactories map[string]ModuleFactory, filename str
 	for _, def := range file.Defs {
 		switch def := def.(type) {
 		case *parser.Module:
-			_, moduleErrs := processModuleDef(def, filename, moduleFactories, nil, false)
+			if def.Type == packageModuleType {
+				_, packageErrs := processPackageDef(def, filename)
+				errs = append(errs, packageErrs...)
+				continue
+			}
+			_, moduleErrs := processModuleDef(def, filename, moduleFactories, nil, nil, nil, false)
 			errs = append(errs, moduleErrs...)
 
+		case *parser.Assignment:
+			switch def.Name {
+			case "build", "subdirs", "optional_subdirs":
+				// Checked below once all the assignments have been evaluated.
+			default:
+				errs = append(errs, &BlueprintError{
+					Err: fmt.Errorf("variable %q is not allowed, only build, subdirs and optional_subdirs can be assigned", def.Name),
+					Pos: def.NamePos,
+				})
+			}
+
+		case *parser.Include:
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("include directives are not allowed"),
+				Pos: def.IncludePos,
+			})
+
 		default:
 			panic(fmt.Errorf("unknown definition type: %T", def))
 		}
 	}
 
+	_, inclusionErrs := fileInclusionsFromScope(scope)
+	errs = append(errs, inclusionErrs...)
+
+	return errs
+}
+
+// CheckBlueprints parses a single Blueprints document read from r and validates it against the
+// module types registered with the Context, without adding its modules to the Context.  It is
+// intended for one-off validation of a file that may not exist on disk yet, for example one read
+// from stdin.
+//
+// The filename is a virtual path that is only used for reporting errors.  In addition to the
+// syntax errors and property errors reported by CheckBlueprintSyntax, it evaluates select
+// expressions with the Context's SelectEvaluator and reports modules without names and modules
+// that are defined more than once in the document.
+func (c *Context) CheckBlueprints(filename string, r io.Reader) []error {
+	scope := parser.NewScope(nil)
+	scope.SetSelectEvaluator(c.selectEvaluator)
+	scope.SetIncludeResolver(includeResolver{c.fs})
+	file, errs := parser.ParseAndEval(filename, r, scope)
+	if len(errs) > 0 {
+		for i, err := range errs {
+			if parseErr, ok := err.(*parser.ParseError); ok {
+				errs[i] = &BlueprintError{
+					Err: parseErr.Err,
+					Pos: parseErr.Pos,
+				}
+			}
+		}
+		return errs
+	}
+
+	names := make(map[string]scanner.Position)
+	for _, def := range file.Defs {
+		moduleDef, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+
+		if moduleDef.Type == packageModuleType {
+			_, packageErrs := processPackageDef(moduleDef, filename)
+			errs = append(errs, packageErrs...)
+			continue
+		}
+
+		// Modules defined with an alias of their module type are accepted, CheckBlueprints doesn't
+		// report warnings.
+		moduleDef, _ = c.resolveModuleTypeAlias(moduleDef)
+		module, moduleErrs := processModuleDef(moduleDef, filename, c.moduleFactories, nil,
+			c.propertyTagProcessors, c.variableExpander, c.ignoreUnknownModuleTypes)
+		errs = append(errs, moduleErrs...)
+		if module == nil {
+			continue
+		}
+
+		name := module.logicModule.Name()
+		if name == "" {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("property 'name' is missing from a module"),
+				Pos: module.pos,
+			})
+		} else if prev, exists := names[name]; exists {
+			errs = append(errs, &BlueprintError{
+				// seven characters at the start of the second line to align with the string "error: "
+				Err: errorWithKind(ErrDuplicateModule, fmt.Errorf("module %q already defined\n"+
+					"       %s <-- previous definition here", name, prev)),
+				Pos: module.pos,
+			})
+		} else {
+			names[name] = module.pos
+		}
+	}
+
 	return errs
 }
 
@@ -18,6 +18,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type moduleCtxTestModule struct {
@@ -530,4 +531,370 @@ test2 {
 			`path/Blueprint:6:1: unrecognized module type "test2"`,
 		)
 	})
+
+	t.Run("inclusions", func(t *testing.T) {
+		errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+subdirs = ["a"]
+optional_subdirs = ["b"]
+build = ["c.bp"]
+`)
+		expectedErrors(t, errs)
+	})
+
+	t.Run("invalid inclusions", func(t *testing.T) {
+		errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+subdirs = "a"
+build = ["c/d.bp"]
+foo = ["e"]
+`)
+
+		expectedErrors(t, errs,
+			`path/Blueprint:4:1: variable "foo" is not allowed, only build, subdirs and optional_subdirs can be assigned`,
+			`path/Blueprint:2:9: "subdirs" must be a list of strings`,
+			`path/Blueprint:3:7: illegal value c/d.bp. The '/' character is not permitted`,
+		)
+	})
+}
+
+func TestContextCheckBlueprints(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newModuleCtxTestModule)
+
+	t.Run("valid", func(t *testing.T) {
+		errs := ctx.CheckBlueprints("path/Blueprints", strings.NewReader(`
+test {
+	name: "a",
+}
+
+test {
+	name: "b",
+}
+`))
+		expectedErrors(t, errs)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		errs := ctx.CheckBlueprints("path/Blueprints", strings.NewReader(`
+test {
+	name: "a",
+`))
+		expectedErrors(t, errs, `path/Blueprints:4:1: expected "}", found EOF`)
+	})
+
+	t.Run("semantic errors", func(t *testing.T) {
+		errs := ctx.CheckBlueprints("path/Blueprints", strings.NewReader(`
+test {
+	name: "a",
+}
+
+test {
+	name: "a",
+}
+
+test {
+}
+
+test {
+	nam: "c",
+}
+`))
+		expectedErrors(t, errs,
+			"path/Blueprints:6:1: module \"a\" already defined\n"+
+				"       path/Blueprints:2:1 <-- previous definition here",
+			`path/Blueprints:10:1: property 'name' is missing from a module`,
+			`path/Blueprints:14:5: unrecognized property "nam"`,
+		)
+	})
+
+	// CheckBlueprints doesn't add modules to the Context.
+	if len(ctx.moduleGroups) != 0 {
+		t.Errorf("expected no modules in the Context, found %d", len(ctx.moduleGroups))
+	}
+}
+
+type propertiesHashTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs []string
+		Env  map[string]string
+	}
+	hash string
+}
+
+func newPropertiesHashTestModule() (Module, []interface{}) {
+	m := &propertiesHashTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *propertiesHashTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.hash = ctx.PropertiesHash()
+}
+
+func TestPropertiesHash(t *testing.T) {
+	run := func(bp string) map[string]string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newPropertiesHashTestModule)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		hashes := make(map[string]string)
+		for _, name := range []string{"A", "B"} {
+			m := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+			hash := ctx.ModulePropertiesHash(m)
+			if hash != m.(*propertiesHashTestModule).hash {
+				t.Errorf("%s: Context.ModulePropertiesHash returned %q, ModuleContext.PropertiesHash returned %q",
+					name, hash, m.(*propertiesHashTestModule).hash)
+			}
+			hashes[name] = hash
+		}
+		return hashes
+	}
+
+	first := run(`
+		test {
+			name: "A",
+			srcs: ["a.c", "b.c"],
+			env: { X: "1", Y: "2" },
+		}
+		test {
+			name: "B",
+			srcs: ["a.c", "b.c"],
+		}
+	`)
+
+	// The order of the map entries in the Blueprints file doesn't change the hash.
+	second := run(`
+		test {
+			name: "A",
+			srcs: ["a.c", "b.c"],
+			env: { Y: "2", X: "1" },
+		}
+		test {
+			name: "B",
+			srcs: ["a.c", "c.c"],
+		}
+	`)
+
+	if first["A"] != second["A"] {
+		t.Errorf("hash of A changed from %q to %q", first["A"], second["A"])
+	}
+	if first["B"] == second["B"] {
+		t.Errorf("hash of B did not change when its srcs changed")
+	}
+	if first["A"] == first["B"] {
+		t.Errorf("A and B have the same hash")
+	}
+}
+
+type pauseTestInfo struct {
+	Value string
+}
+
+var pauseTestProvider = NewMutatorProvider(pauseTestInfo{}, "pause")
+
+func TestMutatorPause(t *testing.T) {
+	run := func(t *testing.T, parallel bool, mutator BottomUpMutator) []error {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		handle := ctx.RegisterBottomUpMutator("pause", mutator)
+		if parallel {
+			handle.Parallel()
+		}
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`
+				test { name: "A" }
+				test { name: "B" }
+			`),
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		return errs
+	}
+
+	otherModule := func(mctx BottomUpMutatorContext, name string) Module {
+		return mctx.(*mutatorContext).context.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+	}
+
+	t.Run("parallel", func(t *testing.T) {
+		var got interface{}
+		var paused bool
+		errs := run(t, true, func(mctx BottomUpMutatorContext) {
+			switch mctx.ModuleName() {
+			case "A":
+				b := otherModule(mctx, "B")
+				paused = mctx.Pause(b)
+				got = mctx.OtherModuleProvider(b, pauseTestProvider)
+			case "B":
+				// Give A a chance to read the provider too early if Pause didn't wait.
+				time.Sleep(10 * time.Millisecond)
+				mctx.SetProvider(pauseTestProvider, pauseTestInfo{"B"})
+			}
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if !paused {
+			t.Errorf("expected Pause to return true for a parallel mutator")
+		}
+		if want := (pauseTestInfo{"B"}); got != want {
+			t.Errorf("expected provider %v, got %v", want, got)
+		}
+	})
+
+	t.Run("not parallel", func(t *testing.T) {
+		paused := true
+		errs := run(t, false, func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "A" {
+				paused = mctx.Pause(otherModule(mctx, "B"))
+			}
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if paused {
+			t.Errorf("expected Pause to return false for a mutator that is not parallel")
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		errs := run(t, true, func(mctx BottomUpMutatorContext) {
+			switch mctx.ModuleName() {
+			case "A":
+				mctx.Pause(otherModule(mctx, "B"))
+			case "B":
+				mctx.Pause(otherModule(mctx, "A"))
+			}
+		})
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "encountered dependency cycle") {
+			t.Errorf("expected dependency cycle error, got %v", errs)
+		}
+	})
+}
+
+type pathsTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+		Srcs []string
+		Outs []string
+	}
+
+	dir, blueprintsFile string
+	depDirs             []string
+	inputs, outputs     []string
+}
+
+func newPathsTestModule() (Module, []interface{}) {
+	m := &pathsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *pathsTestModule) Deps() []string {
+	return m.properties.Deps
+}
+
+func (m *pathsTestModule) IgnoreDeps() []string {
+	return nil
+}
+
+func (m *pathsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.dir = ctx.ModuleDir()
+	m.blueprintsFile = ctx.BlueprintsFile()
+	ctx.VisitDirectDeps(func(dep Module) {
+		m.depDirs = append(m.depDirs, ctx.OtherModuleDir(dep))
+	})
+	for _, src := range m.properties.Srcs {
+		m.inputs = append(m.inputs, ctx.InputFile(src))
+	}
+	for _, out := range m.properties.Outs {
+		m.outputs = append(m.outputs, ctx.OutputFile(out))
+	}
+}
+
+func TestModulePaths(t *testing.T) {
+	run := func(files map[string][]byte) (*Context, []string) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newPathsTestModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.MockFileSystem(files)
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		return ctx, got
+	}
+
+	ctx, errs := run(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["dir"]
+
+			test {
+				name: "A",
+				deps: ["B"],
+				srcs: ["a.c", "src\\a\\..\\b.c", ".\\c.c"],
+				outs: ["out\\\\a", "out/b/"],
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			subdirs = ["*"]
+		`),
+		"dir/sub/Blueprints": []byte(`
+			test {
+				name: "B",
+			}
+		`),
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*pathsTestModule)
+	b := ctx.moduleGroupFromName("B", nil).modules.firstModule().logicModule
+
+	check := func(name string, got, want interface{}) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect %s\nwant: %q\n got: %q", name, want, got)
+		}
+	}
+
+	check("ModuleDir", a.dir, ".")
+	check("BlueprintsFile", a.blueprintsFile, "Blueprints")
+	check("OtherModuleDir", a.depDirs, []string{"dir/sub"})
+	check("Context.ModuleDir", ctx.ModuleDir(b), "dir/sub")
+	check("Context.BlueprintFile", ctx.BlueprintFile(b), "dir/sub/Blueprints")
+	check("InputFile", a.inputs, []string{"a.c", "src/b.c", "c.c"})
+	check("OutputFile", a.outputs, []string{"out/a", "out/b"})
+	check("declared inputs", ctx.moduleInfo[a].declaredInputs, a.inputs)
+
+	_, errs = run(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "C",
+				srcs: [""],
+			}
+		`),
+	})
+	want := []string{`Blueprints:2:4: module "C": InputFile called with an empty path`}
+	check("errors", errs, want)
 }
@@ -0,0 +1,190 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// moduleFragmentIndexFile is the name of the file in the module fragment directory, or in the
+// shard directory of WriteBuildFileSharded, that records the content hash of every file written
+// by the previous run.
+const moduleFragmentIndexFile = "fragments.index"
+
+// SetModuleFragmentDir causes WriteBuildFile to write the build actions for each module to its
+// own Ninja fragment file in dir and include them from the main manifest, instead of writing all
+// the module build actions into the main manifest.  A content hash of every fragment is recorded in
+// dir, and fragments whose hash has not changed since the previous run are not rewritten, which
+// significantly reduces the amount of I/O for incremental runs.  Fragments for modules that no
+// longer exist are removed, so dir should not be used for anything else.  dir is used both to
+// write the fragments and in the include statements, so it must either be absolute or be relative
+// to both the current directory and the directory Ninja runs in.  WriteBuildFileSharded ignores
+// dir and splits the module build actions by top-level directory instead.
+func (c *Context) SetModuleFragmentDir(dir string) {
+	c.moduleFragmentDir = dir
+}
+
+// moduleFragmentFile returns the name of the fragment file for a module.  It contains a sanitized
+// version of the module name to make it easier to find, and a hash of the unique name and variant
+// to make it unique.
+func (c *Context) moduleFragmentFile(module *moduleInfo) string {
+	uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
+	hash := sha256.Sum256([]byte(uniqueName + "\x00" + module.variant.name))
+	name := toNinjaName(module.Name())
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name + "." + hex.EncodeToString(hash[:8]) + ".ninja"
+}
+
+// writeModuleFragments writes the build actions for each module that has any to a fragment file in
+// the module fragment directory, and writes include statements for them to nw.
+func (c *Context) writeModuleFragments(nw *ninjaWriter) error {
+	var files []moduleActionsFile
+	for _, module := range c.sortedModuleInfos() {
+		files = append(files, moduleActionsFile{c.moduleFragmentFile(module), []*moduleInfo{module}})
+	}
+	return c.writeModuleActionsFiles(nw, c.moduleFragmentDir, files, false)
+}
+
+// A moduleActionsFile is a file that the build actions of a group of modules are written to by
+// writeModuleActionsFiles.
+type moduleActionsFile struct {
+	name    string
+	modules []*moduleInfo
+}
+
+// writeModuleActionsFiles writes the build actions of the modules of each file that has any to
+// the file in dir, and writes include statements, or subninja statements if subninja is true, for
+// them to nw.  A content hash of every file is recorded in an index in dir, and files whose hash
+// has not changed since the previous run are not rewritten.  Files recorded in the index of the
+// previous run that are not written by this one are removed, any other file in dir is left alone.
+func (c *Context) writeModuleActionsFiles(nw *ninjaWriter, dir string, files []moduleActionsFile,
+	subninja bool) error {
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	oldHashes := readModuleFragmentIndex(filepath.Join(dir, moduleFragmentIndexFile))
+	newHashes := make(map[string]string)
+
+	buf := &bytes.Buffer{}
+	for _, file := range files {
+		buf.Reset()
+		err := c.writeModuleActions(newNinjaWriter(buf), file.modules)
+		if err != nil {
+			return err
+		}
+		if buf.Len() == 0 {
+			continue
+		}
+
+		if _, exists := newHashes[file.name]; exists {
+			return fmt.Errorf("duplicate module actions file %q for %s", file.name, file.modules[0])
+		}
+		hash := sha256.Sum256(buf.Bytes())
+		newHashes[file.name] = hex.EncodeToString(hash[:])
+
+		path := filepath.Join(dir, file.name)
+		if oldHashes[file.name] != newHashes[file.name] || !fileExists(path) {
+			err = ioutil.WriteFile(path, buf.Bytes(), 0666)
+			if err != nil {
+				return err
+			}
+		}
+
+		if subninja {
+			err = nw.Subninja(path)
+		} else {
+			err = nw.Include(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for file := range oldHashes {
+		if _, ok := newHashes[file]; !ok {
+			err := os.Remove(filepath.Join(dir, file))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	err = writeModuleFragmentIndex(filepath.Join(dir, moduleFragmentIndexFile), newHashes)
+	if err != nil {
+		return err
+	}
+
+	return nw.BlankLine()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readModuleFragmentIndex reads the fragment hashes written by writeModuleFragmentIndex.  A
+// missing or corrupt index is treated as empty, which causes every fragment to be rewritten.
+func readModuleFragmentIndex(file string) map[string]string {
+	hashes := make(map[string]string)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return hashes
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return make(map[string]string)
+		}
+		hashes[fields[0]] = fields[1]
+	}
+	if scanner.Err() != nil {
+		return make(map[string]string)
+	}
+
+	return hashes
+}
+
+func writeModuleFragmentIndex(file string, hashes map[string]string) error {
+	files := make([]string, 0, len(hashes))
+	for f := range hashes {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	buf := &bytes.Buffer{}
+	for _, f := range files {
+		fmt.Fprintf(buf, "%s %s\n", f, hashes[f])
+	}
+
+	return ioutil.WriteFile(file, buf.Bytes(), 0666)
+}
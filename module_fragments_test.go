@@ -0,0 +1,118 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModuleFragments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fragments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := func(bp string) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetModuleFragmentDir(dir)
+		ctx.RegisterModuleType("shard_module", newShardTestModule)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	includes := func(manifest string) []string {
+		var files []string
+		for _, line := range strings.Split(manifest, "\n") {
+			if strings.HasPrefix(line, "include ") {
+				files = append(files, strings.TrimPrefix(line, "include "))
+			}
+		}
+		return files
+	}
+
+	manifest := run(`
+		shard_module { name: "a" }
+		shard_module { name: "b" }
+	`)
+	if strings.Contains(manifest, "build ") {
+		t.Errorf("unexpected build statement in main manifest:\n%s", manifest)
+	}
+	files := includes(manifest)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 included fragments, got %q", files)
+	}
+	for i, name := range []string{"a", "b"} {
+		data, err := ioutil.ReadFile(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "build "+name+": phony\n") {
+			t.Errorf("expected fragment %s to contain module %s, got:\n%s", files[i], name, data)
+		}
+	}
+
+	// Backdate the fragments so that rewrites can be detected.
+	old := time.Now().Add(-time.Hour)
+	for _, file := range files {
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest = run(`
+		shard_module { name: "a" }
+		shard_module { name: "c" }
+	`)
+	newFiles := includes(manifest)
+	if len(newFiles) != 2 || newFiles[0] != files[0] {
+		t.Fatalf("expected fragment for a to be reused, got %q", newFiles)
+	}
+	if info, err := os.Stat(files[0]); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(old) {
+		t.Errorf("expected unchanged fragment for a not to be rewritten")
+	}
+	if _, err := os.Stat(files[1]); !os.IsNotExist(err) {
+		t.Errorf("expected fragment for removed module b to be deleted, got %v", err)
+	}
+
+	fragments, err := filepath.Glob(filepath.Join(dir, "*.ninja"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fragments) != 2 {
+		t.Errorf("expected 2 fragments on disk, got %q", fragments)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sortMutators sorts the registered mutators so that the constraints set with MutatorHandle.Before
+// and MutatorHandle.After are satisfied.  Mutators that are not ordered by a constraint keep their
+// registration order.  Early mutators always run first and can't be constrained.
+func (c *Context) sortMutators() []error {
+	mutators := c.mutatorInfo
+
+	byName := make(map[string][]int)
+	for i, m := range mutators {
+		byName[m.name] = append(byName[m.name], i)
+	}
+
+	// edges[i] contains the indexes of the mutators that must run after mutator i.
+	edges := make([][]int, len(mutators))
+	inDegree := make([]int, len(mutators))
+	addEdge := func(from, to int) {
+		edges[from] = append(edges[from], to)
+		inDegree[to]++
+	}
+
+	var errs []error
+	for i, m := range mutators {
+		for _, name := range m.before {
+			others, ok := byName[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("mutator %q must run before unknown mutator %q", m.name, name))
+			}
+			for _, j := range others {
+				addEdge(i, j)
+			}
+		}
+		for _, name := range m.after {
+			others, ok := byName[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("mutator %q must run after unknown mutator %q", m.name, name))
+			}
+			for _, j := range others {
+				addEdge(j, i)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// Kahn's algorithm, always picking the ready mutator that was registered first.
+	sorted := make([]*mutatorInfo, 0, len(mutators))
+	done := make([]bool, len(mutators))
+	for len(sorted) < len(mutators) {
+		next := -1
+		for i := range mutators {
+			if !done[i] && inDegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return []error{fmt.Errorf("mutator ordering constraints form a cycle: %s",
+				mutatorCycle(mutators, edges, done))}
+		}
+		done[next] = true
+		sorted = append(sorted, mutators[next])
+		for _, j := range edges[next] {
+			inDegree[j]--
+		}
+	}
+
+	c.mutatorInfo = sorted
+
+	// Keep the names of the variant mutators in the order the mutators run.
+	variantMutatorNames := make([]string, 0, len(c.variantMutatorNames))
+	for _, m := range c.earlyMutatorInfo {
+		variantMutatorNames = append(variantMutatorNames, m.name)
+	}
+	for _, m := range sorted {
+		if m.bottomUpMutator != nil {
+			variantMutatorNames = append(variantMutatorNames, m.name)
+		}
+	}
+	c.variantMutatorNames = variantMutatorNames
+
+	return nil
+}
+
+// mutatorCycle returns a description of a cycle in the constraints between the mutators that
+// could not be sorted.
+func mutatorCycle(mutators []*mutatorInfo, edges [][]int, done []bool) string {
+	// Every remaining mutator has an incoming edge from another remaining mutator, so walking
+	// backwards from any of them eventually repeats a mutator.
+	predecessor := make([]int, len(mutators))
+	for i := range predecessor {
+		predecessor[i] = -1
+	}
+	start := -1
+	for from := range mutators {
+		if done[from] {
+			continue
+		}
+		for _, to := range edges[from] {
+			if !done[to] && predecessor[to] < 0 {
+				predecessor[to] = from
+			}
+		}
+		if start < 0 {
+			start = from
+		}
+	}
+
+	visited := make(map[int]int)
+	var path []int
+	for i := start; ; i = predecessor[i] {
+		if pos, ok := visited[i]; ok {
+			path = path[pos:]
+			break
+		}
+		visited[i] = len(path)
+		path = append(path, i)
+	}
+
+	// Reverse the path into run order and start it at the mutator that was registered first.
+	cycle := make([]int, 0, len(path))
+	first := 0
+	for j := len(path) - 1; j >= 0; j-- {
+		if path[j] < path[len(path)-1-first] {
+			first = len(cycle)
+		}
+		cycle = append(cycle, path[j])
+	}
+
+	names := make([]string, 0, len(cycle)+1)
+	for j := range cycle {
+		names = append(names, mutators[cycle[(first+j)%len(cycle)]].name)
+	}
+	names = append(names, names[0])
+	return strings.Join(names, " -> ")
+}
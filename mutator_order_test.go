@@ -0,0 +1,105 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMutatorOrder(t *testing.T) {
+	testCases := []struct {
+		name     string
+		register func(ctx *Context, mutator func(name string) BottomUpMutator)
+		want     []string
+		errs     []string
+	}{
+		{
+			name: "registration order",
+			register: func(ctx *Context, mutator func(string) BottomUpMutator) {
+				ctx.RegisterBottomUpMutator("a", mutator("a"))
+				ctx.RegisterBottomUpMutator("b", mutator("b"))
+				ctx.RegisterTopDownMutator("c", func(TopDownMutatorContext) {})
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "before and after",
+			register: func(ctx *Context, mutator func(string) BottomUpMutator) {
+				ctx.RegisterBottomUpMutator("a", mutator("a")).After("c")
+				ctx.RegisterBottomUpMutator("b", mutator("b"))
+				ctx.RegisterBottomUpMutator("c", mutator("c")).Parallel()
+				ctx.RegisterBottomUpMutator("d", mutator("d")).Before("b")
+			},
+			want: []string{"c", "a", "d", "b"},
+		},
+		{
+			name: "unknown mutator",
+			register: func(ctx *Context, mutator func(string) BottomUpMutator) {
+				ctx.RegisterBottomUpMutator("a", mutator("a")).After("missing")
+			},
+			errs: []string{`mutator "a" must run after unknown mutator "missing"`},
+		},
+		{
+			name: "cycle",
+			register: func(ctx *Context, mutator func(string) BottomUpMutator) {
+				ctx.RegisterBottomUpMutator("a", mutator("a"))
+				ctx.RegisterBottomUpMutator("b", mutator("b")).After("c")
+				ctx.RegisterBottomUpMutator("c", mutator("c")).After("d")
+				ctx.RegisterBottomUpMutator("d", mutator("d")).After("b")
+			},
+			errs: []string{`mutator ordering constraints form a cycle: b -> d -> c -> b`},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("foo_module", newFooModule)
+
+			var got []string
+			testCase.register(ctx, func(name string) BottomUpMutator {
+				return func(ctx BottomUpMutatorContext) {
+					got = append(got, name)
+				}
+			})
+
+			ctx.MockFileSystem(map[string][]byte{
+				"Blueprints": []byte(`
+					foo_module {
+						name: "A",
+					}
+				`),
+			})
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected parse errors: %q", errs)
+			}
+			_, errs = ctx.ResolveDependencies(nil)
+
+			var gotErrs []string
+			for _, err := range errs {
+				gotErrs = append(gotErrs, err.Error())
+			}
+			if !reflect.DeepEqual(gotErrs, testCase.errs) {
+				t.Errorf("incorrect errors\nwant: %q\n got: %q", testCase.errs, gotErrs)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("incorrect mutator order\nwant: %q\n got: %q", testCase.want, got)
+			}
+		})
+	}
+}
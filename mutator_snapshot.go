@@ -0,0 +1,133 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SetMutatorSnapshotDir enables writing a snapshot of the module graph to dir before the first
+// mutator and after every mutator runs.  Snapshots are named with the index and name of the
+// mutator that produced them, for example "003_deps.snapshot", so that a regression introduced by
+// a specific mutator can be found by comparing consecutive snapshots with bpsnapdiff.  This is a
+// debugging aid and slows down ResolveDependencies significantly on large graphs.
+func (c *Context) SetMutatorSnapshotDir(dir string) {
+	c.mutatorSnapshotDir = dir
+}
+
+// writeMutatorSnapshot writes the lines returned by graphLines for the current graph to a file
+// named after the mutator in the mutator snapshot directory.
+func (c *Context) writeMutatorSnapshot(index int, mutator string) error {
+	lines, err := c.graphLines(nil)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.mutatorSnapshotDir, 0777)
+	if err != nil {
+		return fmt.Errorf("failed to create mutator snapshot directory: %s", err)
+	}
+
+	name := fmt.Sprintf("%03d_%s.snapshot", index, strings.Replace(mutator, string(filepath.Separator), "_", -1))
+	file := filepath.Join(c.mutatorSnapshotDir, name)
+	content := strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0666); err != nil {
+		return fmt.Errorf("failed to write mutator snapshot: %s", err)
+	}
+
+	return nil
+}
+
+// SerializeGraph writes a canonical description of every module variant, dependency edge and
+// the values of the given providers to w, so that the graphs produced by two runs, for example
+// before and after refactoring a mutator, can be compared with bpgraphdiff.  The output only
+// depends on the graph, not on the order in which modules were created or visited, and it
+// uses the same format as the snapshots written by SetMutatorSnapshotDir with an additional
+// kind of line for providers:
+//
+//	provider <id> <provider type> <value as JSON>
+//
+// Providers that were not set on a module are omitted.  Provider values should only contain
+// exported fields and no pointers to data that changes between runs, the values are compared as
+// encoded by encoding/json.
+func (c *Context) SerializeGraph(w io.Writer, providers ...ProviderKey) error {
+	lines, err := c.graphLines(providers)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	return buf.Flush()
+}
+
+// graphLines returns a sorted, line oriented description of every module variant and
+// dependency edge currently in the graph, and of the values of the given providers.  Each line
+// is one of
+//
+//	module <id> <type> <Blueprints file>
+//	dep <id> <dependency id> <tag>
+//	provider <id> <provider type> <value>
+//
+// where ids are the module name followed by its variations in braces.
+func (c *Context) graphLines(providers []ProviderKey) ([]string, error) {
+	var lines []string
+	for _, group := range c.moduleGroups {
+		for _, moduleOrAlias := range group.modules {
+			m := moduleOrAlias.module()
+			if m == nil {
+				continue
+			}
+			id := snapshotModuleId(m)
+			lines = append(lines, fmt.Sprintf("module %s %s %s", id, m.typeName, m.relBlueprintsFile))
+			for _, dep := range m.directDeps {
+				lines = append(lines, fmt.Sprintf("dep %s %s %T %+v",
+					id, snapshotModuleId(dep.module), dep.tag, dep.tag))
+			}
+			for _, provider := range providers {
+				if len(m.providers) <= provider.id || m.providers[provider.id] == nil {
+					continue
+				}
+				value, err := json.Marshal(m.providers[provider.id])
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode provider %s of %s: %s", provider.typ, m, err)
+				}
+				lines = append(lines, fmt.Sprintf("provider %s %s %s", id, provider.typ, value))
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
+
+func snapshotModuleId(m *moduleInfo) string {
+	var variations []string
+	for _, e := range m.variant.variations.entries() {
+		variations = append(variations, e.mutator+":"+e.variation)
+	}
+	sort.Strings(variations)
+	return m.Name() + "{" + strings.Join(variations, ",") + "}"
+}
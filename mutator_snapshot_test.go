@@ -0,0 +1,148 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMutatorSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutator_snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := NewContext()
+	ctx.SetMutatorSnapshotDir(dir)
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "C",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	expectedFiles := []string{
+		"000_initial.snapshot",
+		"001_blueprint_deps.snapshot",
+		"002_split.snapshot",
+		"003_deps.snapshot",
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("want snapshots %q, got %q", expectedFiles, files)
+	}
+
+	split, err := ioutil.ReadFile(filepath.Join(dir, "002_split.snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "module B{split:x} provider_module Blueprints\n" +
+		"module B{split:y} provider_module Blueprints\n" +
+		"module C{} provider_module Blueprints\n"
+	if string(split) != expected {
+		t.Errorf("incorrect split snapshot:\nwant:\n%s\ngot:\n%s", expected, split)
+	}
+
+	deps, err := ioutil.ReadFile(filepath.Join(dir, "003_deps.snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "dep B{split:x} C{} blueprint.graphTestDepTag {BaseDependencyTag:{} name:dep}\n" +
+		"dep B{split:y} C{} blueprint.graphTestDepTag {BaseDependencyTag:{} name:dep}\n" +
+		expected
+	if string(deps) != expected {
+		t.Errorf("incorrect deps snapshot:\nwant:\n%s\ngot:\n%s", expected, deps)
+	}
+}
+
+func TestSerializeGraph(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "B",
+				deps: ["C"],
+			}
+		`),
+		"dir/Blueprints": []byte(`
+			provider_module {
+				name: "C",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.SerializeGraph(buf, providerTestGenerateBuildActionsInfoProvider, providerTestMutatorInfoProvider); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "dep B{split:x} C{} blueprint.graphTestDepTag {BaseDependencyTag:{} name:dep}\n" +
+		"dep B{split:y} C{} blueprint.graphTestDepTag {BaseDependencyTag:{} name:dep}\n" +
+		"module B{split:x} provider_module Blueprints\n" +
+		"module B{split:y} provider_module Blueprints\n" +
+		"module C{} provider_module dir/Blueprints\n" +
+		`provider B{split:x} *blueprint.providerTestGenerateBuildActionsInfo {"Value":"B"}` + "\n" +
+		`provider B{split:y} *blueprint.providerTestGenerateBuildActionsInfo {"Value":"B"}` + "\n" +
+		`provider C{} *blueprint.providerTestGenerateBuildActionsInfo {"Value":"C"}` + "\n"
+	if g := buf.String(); g != expected {
+		t.Errorf("incorrect serialized graph:\nwant:\n%s\ngot:\n%s", expected, g)
+	}
+}
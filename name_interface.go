@@ -74,6 +74,15 @@ type NameInterface interface {
 	UniqueName(ctx NamespaceContext, name string) (unique string)
 }
 
+// A NameSuggester is an optional interface for a NameInterface that can suggest the names of
+// existing modules when a module depends on a name that doesn't exist.  The suggestions are
+// appended to the error returned by MissingDependencyError as "did you mean ...?".
+type NameSuggester interface {
+	// SuggestModuleNames returns the names, as they would be written by a module in the given
+	// namespace, of the existing modules whose names are close to moduleName, best first.
+	SuggestModuleNames(moduleName string, namespace Namespace) []string
+}
+
 // A NamespaceContext stores the information given to a NameInterface to enable the NameInterface
 // to choose the namespace for any given module
 type NamespaceContext interface {
@@ -108,8 +117,8 @@ func (s *SimpleNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup,
 	if group, present := s.modules[name]; present {
 		return nil, []error{
 			// seven characters at the start of the second line to align with the string "error: "
-			fmt.Errorf("module %q already defined\n"+
-				"       %s <-- previous definition here", name, group.modules.firstModule().pos),
+			errorWithKind(ErrDuplicateModule, fmt.Errorf("module %q already defined\n"+
+				"       %s <-- previous definition here", name, group.modules.firstModule().pos)),
 		}
 	}
 
@@ -128,9 +137,9 @@ func (s *SimpleNameInterface) Rename(oldName string, newName string, namespace N
 	if exists {
 		return []error{
 			// seven characters at the start of the second line to align with the string "error: "
-			fmt.Errorf("renaming module %q to %q conflicts with existing module\n"+
+			errorWithKind(ErrDuplicateModule, fmt.Errorf("renaming module %q to %q conflicts with existing module\n"+
 				"       %s <-- existing module defined here",
-				oldName, newName, existingGroup.modules.firstModule().pos),
+				oldName, newName, existingGroup.modules.firstModule().pos)),
 		}
 	}
 
@@ -171,6 +180,14 @@ func (s *SimpleNameInterface) MissingDependencyError(depender string, dependerNa
 	return fmt.Errorf("%q depends on undefined module %q", depender, dependency)
 }
 
+func (s *SimpleNameInterface) SuggestModuleNames(moduleName string, namespace Namespace) []string {
+	names := make([]string, 0, len(s.modules))
+	for name := range s.modules {
+		names = append(names, name)
+	}
+	return suggestNames(moduleName, names)
+}
+
 func (s *SimpleNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
 	return nil
 }
@@ -178,3 +195,86 @@ func (s *SimpleNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
 func (s *SimpleNameInterface) UniqueName(ctx NamespaceContext, name string) (unique string) {
 	return name
 }
+
+// maxNameSuggestions is the maximum number of names returned by suggestNames.
+const maxNameSuggestions = 3
+
+// suggestNames returns the candidates that are within an edit distance of a third of the length
+// of name, closest first.
+func suggestNames(name string, candidates []string) []string {
+	maxDistance := len(name) / 3
+	if maxDistance == 0 {
+		return nil
+	}
+
+	type suggestion struct {
+		name     string
+		distance int
+	}
+	var suggestions []suggestion
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if candidate == name || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		if d := editDistance(name, candidate, maxDistance); d <= maxDistance {
+			suggestions = append(suggestions, suggestion{candidate, d})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].name < suggestions[j].name
+	})
+	if len(suggestions) > maxNameSuggestions {
+		suggestions = suggestions[:maxNameSuggestions]
+	}
+
+	ret := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		ret[i] = s.name
+	}
+	return ret
+}
+
+// editDistance returns the Levenshtein distance between a and b, or a value greater than max if
+// it is greater than max.
+func editDistance(a, b string, max int) int {
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,421 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint/pathtools"
+)
+
+// NamespaceModuleType is the module type name that NamespaceModuleFactory is conventionally
+// registered as.
+const NamespaceModuleType = "blueprint_namespace"
+
+// A NamespaceNameInterface is a NameInterface that supports hierarchical namespaces.  A
+// Blueprints file declares a namespace for its directory and the directories below it with a
+// module created by NamespaceModuleFactory, which must be the first module in the file:
+//
+//	blueprint_namespace {
+//	    imports: ["path/to/other/namespace"],
+//	}
+//
+// Every module belongs to the namespace of the nearest directory at or above its Blueprints file
+// that declares one, or to the root namespace if there is none, and modules in different
+// namespaces may have the same name.  A name used by a module is looked up in the namespace of
+// the module, then in the namespaces it imports, in order, and then in the namespaces of the
+// parent directories up to the root namespace.  A name of the form "//path/to/namespace:name"
+// refers to the module called name in the namespace declared in path/to/namespace, and
+// "//:name" to the module called name in the root namespace.
+//
+// To use it, pass it to Context.SetNameInterface and register NamespaceModuleFactory:
+//
+//	ctx.SetNameInterface(blueprint.NewNamespaceNameInterface())
+//	ctx.RegisterModuleType(blueprint.NamespaceModuleType, blueprint.NamespaceModuleFactory)
+type NamespaceNameInterface struct {
+	root       *blueprintNamespace
+	namespaces map[string]*blueprintNamespace
+
+	// the module groups of the namespace modules, in the order they were declared
+	namespaceModules []ModuleGroup
+
+	// the Blueprints files that have defined at least one module
+	filesWithModules map[string]bool
+}
+
+// NewNamespaceNameInterface returns a NamespaceNameInterface containing only the root namespace.
+func NewNamespaceNameInterface() *NamespaceNameInterface {
+	r := &NamespaceNameInterface{
+		filesWithModules: make(map[string]bool),
+	}
+	r.root = r.newNamespace(".", nil)
+	r.namespaces = map[string]*blueprintNamespace{".": r.root}
+	return r
+}
+
+// blueprintNamespace is the Namespace returned by NamespaceNameInterface.
+type blueprintNamespace struct {
+	NamespaceMarker
+
+	// the directory that declared the namespace, "." for the root namespace
+	path   string
+	parent *blueprintNamespace
+
+	resolver *NamespaceNameInterface
+
+	// set when a namespace module declares the namespace
+	declared bool
+
+	modules map[string]ModuleGroup
+
+	// set from the imports property of the namespace module, and resolved the first time they
+	// are needed, after all the namespaces have been declared
+	importPaths []string
+	importsOnce sync.Once
+	imports     []*blueprintNamespace
+	importErrs  []error
+}
+
+func (r *NamespaceNameInterface) newNamespace(path string, parent *blueprintNamespace) *blueprintNamespace {
+	return &blueprintNamespace{
+		path:     path,
+		parent:   parent,
+		resolver: r,
+		modules:  make(map[string]ModuleGroup),
+	}
+}
+
+func (ns *blueprintNamespace) String() string {
+	if ns.path == "." {
+		return "//"
+	}
+	return "//" + ns.path
+}
+
+// moduleNames returns the names of the modules in ns.
+func (ns *blueprintNamespace) moduleNames() []string {
+	names := make([]string, 0, len(ns.modules))
+	for name := range ns.modules {
+		names = append(names, name)
+	}
+	return names
+}
+
+// resolvedImports returns the namespaces imported by ns, and errors for the imports that don't
+// refer to a namespace.
+func (ns *blueprintNamespace) resolvedImports() ([]*blueprintNamespace, []error) {
+	ns.importsOnce.Do(func() {
+		for _, importPath := range ns.importPaths {
+			imported, ok := ns.resolver.namespaces[namespacePath(importPath)]
+			if !ok {
+				ns.importErrs = append(ns.importErrs,
+					fmt.Errorf("namespace %s imports %q, which is not a namespace", ns, importPath))
+				continue
+			}
+			ns.imports = append(ns.imports, imported)
+		}
+	})
+	return ns.imports, ns.importErrs
+}
+
+// namespacePath converts a namespace path as written in a Blueprints file, with or without a
+// leading "//", to the directory that declared the namespace.
+func namespacePath(p string) string {
+	p = strings.TrimPrefix(p, "//")
+	if p == "" {
+		return "."
+	}
+	return pathtools.NormalizePath(p)
+}
+
+// namespaceModule is the module that declares a namespace, see NamespaceNameInterface.
+type namespaceModule struct {
+	properties struct {
+		// the paths of the namespaces searched for module names after this namespace
+		Imports []string
+	}
+}
+
+// NamespaceModuleFactory is the factory for the module type that declares a namespace, see
+// NamespaceNameInterface.
+func NamespaceModuleFactory() (Module, []interface{}) {
+	m := &namespaceModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *namespaceModule) Name() string {
+	return NamespaceModuleType
+}
+
+func (m *namespaceModule) GenerateBuildActions(ctx ModuleContext) {
+	ns, ok := ctx.Namespace().(*blueprintNamespace)
+	if !ok {
+		return
+	}
+	_, errs := ns.resolvedImports()
+	for _, err := range errs {
+		ctx.PropertyErrorf("imports", "%s", err)
+	}
+}
+
+// namespaceDir returns the directory of the Blueprints file of the module being added.
+func namespaceDir(ctx NamespaceContext) string {
+	return path.Dir(pathtools.NormalizePath(ctx.ModulePath()))
+}
+
+// findNamespace returns the namespace declared in the nearest directory at or above dir.
+func (r *NamespaceNameInterface) findNamespace(dir string) *blueprintNamespace {
+	for dir != "." && dir != "/" {
+		if ns, ok := r.namespaces[dir]; ok {
+			return ns
+		}
+		dir = path.Dir(dir)
+	}
+	return r.root
+}
+
+func (r *NamespaceNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup, module Module) (namespace Namespace, err []error) {
+	file := pathtools.NormalizePath(ctx.ModulePath())
+	dir := path.Dir(file)
+
+	if m, ok := module.(*namespaceModule); ok {
+		if r.filesWithModules[file] {
+			return nil, []error{fmt.Errorf("%s must be the first module in %s", NamespaceModuleType, file)}
+		}
+		r.filesWithModules[file] = true
+
+		ns, exists := r.namespaces[dir]
+		if !exists {
+			ns = r.newNamespace(dir, r.findNamespace(path.Dir(dir)))
+			r.namespaces[dir] = ns
+		} else if ns.declared {
+			return nil, []error{fmt.Errorf("namespace %s is already declared", ns)}
+		}
+		ns.declared = true
+		ns.importPaths = append([]string{}, m.properties.Imports...)
+		r.namespaceModules = append(r.namespaceModules, group)
+		return ns, nil
+	}
+	r.filesWithModules[file] = true
+
+	ns := r.findNamespace(dir)
+	name := group.name
+	if existing, present := ns.modules[name]; present {
+		return nil, []error{
+			// seven characters at the start of the second line to align with the string "error: "
+			errorWithKind(ErrDuplicateModule, fmt.Errorf("module %q already defined in namespace %s\n"+
+				"       %s <-- previous definition here", name, ns, existing.modules.firstModule().pos)),
+		}
+	}
+	ns.modules[name] = group
+
+	return ns, nil
+}
+
+// visibleNamespaces returns the namespaces searched for a name used in ns, in order.
+func (r *NamespaceNameInterface) visibleNamespaces(ns *blueprintNamespace) []*blueprintNamespace {
+	imports, _ := ns.resolvedImports()
+	visible := append([]*blueprintNamespace{ns}, imports...)
+	for parent := ns.parent; parent != nil; parent = parent.parent {
+		visible = append(visible, parent)
+	}
+
+	seen := make(map[*blueprintNamespace]bool, len(visible))
+	ret := visible[:0]
+	for _, v := range visible {
+		if !seen[v] {
+			seen[v] = true
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// parseFullyQualifiedName splits a name of the form "//path:name" into the namespace path and
+// the module name.
+func parseFullyQualifiedName(name string) (nsPath, moduleName string, ok bool) {
+	if !strings.HasPrefix(name, "//") {
+		return "", "", false
+	}
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return namespacePath(name[:i]), name[i+1:], true
+}
+
+func (r *NamespaceNameInterface) ModuleFromName(moduleName string, namespace Namespace) (group ModuleGroup, found bool) {
+	if nsPath, name, ok := parseFullyQualifiedName(moduleName); ok {
+		ns, exists := r.namespaces[nsPath]
+		if !exists {
+			return ModuleGroup{}, false
+		}
+		group, found = ns.modules[name]
+		return group, found
+	}
+
+	ns, _ := namespace.(*blueprintNamespace)
+	if ns == nil {
+		ns = r.root
+	}
+	for _, visible := range r.visibleNamespaces(ns) {
+		if group, found = visible.modules[moduleName]; found {
+			return group, true
+		}
+	}
+	return ModuleGroup{}, false
+}
+
+func (r *NamespaceNameInterface) MissingDependencyError(depender string, dependerNamespace Namespace, depName string) (err error) {
+	ns, _ := dependerNamespace.(*blueprintNamespace)
+	if ns == nil {
+		ns = r.root
+	}
+
+	msg := fmt.Sprintf("%q depends on undefined module %q", depender, depName)
+
+	if nsPath, _, ok := parseFullyQualifiedName(depName); ok {
+		if _, exists := r.namespaces[nsPath]; !exists {
+			msg += fmt.Sprintf("\nnamespace %s does not exist", "//"+nsPath)
+		}
+		return fmt.Errorf("%s", msg)
+	}
+
+	var visible []string
+	for _, v := range r.visibleNamespaces(ns) {
+		visible = append(visible, v.String())
+	}
+	msg += fmt.Sprintf("\nmodule %q is in namespace %s, which can see namespaces %s", depender, ns,
+		strings.Join(visible, ", "))
+
+	_, importErrs := ns.resolvedImports()
+	for _, importErr := range importErrs {
+		msg += "\n" + importErr.Error()
+	}
+
+	var candidates []string
+	for _, candidate := range r.sortedNamespaces() {
+		if _, ok := candidate.modules[depName]; ok {
+			candidates = append(candidates, candidate.String())
+		}
+	}
+	if len(candidates) > 0 {
+		msg += fmt.Sprintf("\nmodule %q is defined in namespaces %s, import one of them or use a "+
+			"fully qualified name like \"%s:%s\"", depName, strings.Join(candidates, ", "),
+			candidates[0], depName)
+	}
+
+	return fmt.Errorf("%s", msg)
+}
+
+// SuggestModuleNames suggests the modules in the namespaces visible from namespace, or in the
+// namespace named by a fully qualified moduleName.
+func (r *NamespaceNameInterface) SuggestModuleNames(moduleName string, namespace Namespace) []string {
+	if nsPath, name, ok := parseFullyQualifiedName(moduleName); ok {
+		ns, exists := r.namespaces[nsPath]
+		if !exists {
+			return nil
+		}
+		var ret []string
+		for _, suggestion := range suggestNames(name, ns.moduleNames()) {
+			ret = append(ret, ns.String()+":"+suggestion)
+		}
+		return ret
+	}
+
+	ns, _ := namespace.(*blueprintNamespace)
+	if ns == nil {
+		ns = r.root
+	}
+	var names []string
+	for _, visible := range r.visibleNamespaces(ns) {
+		names = append(names, visible.moduleNames()...)
+	}
+	return suggestNames(moduleName, names)
+}
+
+func (r *NamespaceNameInterface) Rename(oldName string, newName string, namespace Namespace) (errs []error) {
+	ns, _ := namespace.(*blueprintNamespace)
+	if ns == nil {
+		ns = r.root
+	}
+
+	existingGroup, exists := ns.modules[newName]
+	if exists {
+		return []error{
+			// seven characters at the start of the second line to align with the string "error: "
+			errorWithKind(ErrDuplicateModule, fmt.Errorf("renaming module %q to %q conflicts with existing module\n"+
+				"       %s <-- existing module defined here",
+				oldName, newName, existingGroup.modules.firstModule().pos)),
+		}
+	}
+
+	group, exists := ns.modules[oldName]
+	if !exists {
+		return []error{fmt.Errorf("module %q to renamed to %q doesn't exist in namespace %s", oldName, newName, ns)}
+	}
+	ns.modules[newName] = group
+	delete(ns.modules, group.name)
+	group.name = newName
+	return nil
+}
+
+// sortedNamespaces returns the namespaces sorted by path, with the root namespace first.
+func (r *NamespaceNameInterface) sortedNamespaces() []*blueprintNamespace {
+	namespaces := make([]*blueprintNamespace, 0, len(r.namespaces))
+	for _, ns := range r.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].String() < namespaces[j].String()
+	})
+	return namespaces
+}
+
+func (r *NamespaceNameInterface) AllModules() []ModuleGroup {
+	groups := append([]ModuleGroup{}, r.namespaceModules...)
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].modules.firstModule().relBlueprintsFile <
+			groups[j].modules.firstModule().relBlueprintsFile
+	})
+
+	for _, ns := range r.sortedNamespaces() {
+		start := len(groups)
+		for _, group := range ns.modules {
+			groups = append(groups, group)
+		}
+		nsGroups := groups[start:]
+		sort.Slice(nsGroups, func(i, j int) bool {
+			return nsGroups[i].name < nsGroups[j].name
+		})
+	}
+	return groups
+}
+
+func (r *NamespaceNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
+	return r.findNamespace(namespaceDir(ctx))
+}
+
+func (r *NamespaceNameInterface) UniqueName(ctx NamespaceContext, name string) (unique string) {
+	ns := r.findNamespace(namespaceDir(ctx))
+	if ns == r.root {
+		return name
+	}
+	return ns.path + ":" + name
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newNamespaceTestContext(fs map[string][]byte) (*Context, []error) {
+	ctx := NewContext()
+	ctx.SetNameInterface(NewNamespaceNameInterface())
+	ctx.RegisterModuleType(NamespaceModuleType, NamespaceModuleFactory)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.MockFileSystem(fs)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	return ctx, errs
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx, errs := newNamespaceTestContext(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["*"]
+
+			foo_module { name: "common" }
+			foo_module { name: "dup" }
+		`),
+		"a/Blueprints": []byte(`
+			subdirs = ["*"]
+
+			blueprint_namespace {}
+
+			foo_module { name: "dup" }
+			foo_module {
+				name: "A",
+				deps: ["dup", "common"],
+			}
+		`),
+		"a/sub/Blueprints": []byte(`
+			foo_module {
+				name: "sub",
+				deps: ["dup"],
+			}
+		`),
+		"b/Blueprints": []byte(`
+			blueprint_namespace {
+				imports: ["a"],
+			}
+
+			foo_module {
+				name: "B",
+				deps: ["A", "//:dup"],
+			}
+		`),
+		"c/Blueprints": []byte(`
+			blueprint_namespace {}
+
+			foo_module {
+				name: "C",
+				deps: ["//a:A"],
+			}
+		`),
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	deps := make(map[string][]string)
+	for _, module := range ctx.modulesSorted {
+		if _, ok := module.logicModule.(*namespaceModule); ok {
+			continue
+		}
+		var moduleDeps []string
+		for _, dep := range module.directDeps {
+			moduleDeps = append(moduleDeps, dep.module.relBlueprintsFile+":"+dep.module.Name())
+		}
+		sort.Strings(moduleDeps)
+		deps[module.relBlueprintsFile+":"+module.Name()] = moduleDeps
+	}
+
+	expected := map[string][]string{
+		"Blueprints:common":    nil,
+		"Blueprints:dup":       nil,
+		"a/Blueprints:dup":     nil,
+		"a/Blueprints:A":       {"Blueprints:common", "a/Blueprints:dup"},
+		"a/sub/Blueprints:sub": {"a/Blueprints:dup"},
+		"b/Blueprints:B":       {"Blueprints:dup", "a/Blueprints:A"},
+		"c/Blueprints:C":       {"a/Blueprints:A"},
+	}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Errorf("incorrect dependencies:\nwant %q\n got %q", expected, deps)
+	}
+
+	var names []string
+	for _, group := range ctx.nameInterface.AllModules() {
+		names = append(names, group.modules.firstModule().relBlueprintsFile+":"+group.name)
+	}
+	expectedNames := []string{
+		"a/Blueprints:blueprint_namespace",
+		"b/Blueprints:blueprint_namespace",
+		"c/Blueprints:blueprint_namespace",
+		"Blueprints:common",
+		"Blueprints:dup",
+		"a/Blueprints:A",
+		"a/Blueprints:dup",
+		"a/sub/Blueprints:sub",
+		"b/Blueprints:B",
+		"c/Blueprints:C",
+	}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("incorrect AllModules:\nwant %q\n got %q", expectedNames, names)
+	}
+}
+
+func TestNamespaceErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fs       map[string][]byte
+		expected []string
+	}{
+		{
+			name: "missing dependency",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					subdirs = ["*"]
+
+					foo_module {
+						name: "root",
+						deps: ["A"],
+					}
+				`),
+				"a/Blueprints": []byte(`
+					blueprint_namespace {}
+
+					foo_module { name: "A" }
+				`),
+			},
+			expected: []string{
+				`"root" depends on undefined module "A"`,
+				`module "root" is in namespace //, which can see namespaces //`,
+				`module "A" is defined in namespaces //a, import one of them or use a fully qualified name like "//a:A"`,
+			},
+		},
+		{
+			name: "missing namespace",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					foo_module {
+						name: "root",
+						deps: ["//a:A"],
+					}
+				`),
+			},
+			expected: []string{
+				`"root" depends on undefined module "//a:A"`,
+				`namespace //a does not exist`,
+			},
+		},
+		{
+			name: "bad import",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					blueprint_namespace {
+						imports: ["missing"],
+					}
+				`),
+			},
+			expected: []string{
+				`imports: namespace // imports "missing", which is not a namespace`,
+			},
+		},
+		{
+			name: "namespace not first",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					foo_module { name: "root" }
+					blueprint_namespace {}
+				`),
+			},
+			expected: []string{
+				`blueprint_namespace must be the first module in Blueprints`,
+			},
+		},
+		{
+			name: "duplicate in namespace",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					subdirs = ["*"]
+				`),
+				"a/Blueprints": []byte(`
+					blueprint_namespace {}
+
+					foo_module { name: "A" }
+					foo_module { name: "A" }
+				`),
+			},
+			expected: []string{
+				`module "A" already defined in namespace //a`,
+			},
+		},
+		{
+			name: "misspelled dependency",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					subdirs = ["*"]
+
+					foo_module {
+						name: "root",
+						deps: ["libfooo"],
+					}
+
+					foo_module { name: "libfoo" }
+				`),
+				"a/Blueprints": []byte(`
+					blueprint_namespace {}
+
+					foo_module { name: "libfoo2" }
+				`),
+			},
+			expected: []string{
+				`"root" depends on undefined module "libfooo"`,
+				`did you mean "libfoo"?`,
+			},
+		},
+		{
+			name: "misspelled fully qualified dependency",
+			fs: map[string][]byte{
+				"Blueprints": []byte(`
+					subdirs = ["*"]
+
+					foo_module {
+						name: "root",
+						deps: ["//a:libbar"],
+					}
+				`),
+				"a/Blueprints": []byte(`
+					blueprint_namespace {}
+
+					foo_module { name: "libbaz" }
+					foo_module { name: "libbar2" }
+				`),
+			},
+			expected: []string{
+				`did you mean "//a:libbar2" or "//a:libbaz"?`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, errs := newNamespaceTestContext(testCase.fs)
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 error, got %q", errs)
+			}
+			for _, expected := range testCase.expected {
+				if !strings.Contains(errs[0].Error(), expected) {
+					t.Errorf("expected error to contain %q, got %q", expected, errs[0])
+				}
+			}
+		})
+	}
+}
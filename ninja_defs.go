@@ -20,6 +20,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/google/blueprint/proptools"
 )
 
 // A Deps value indicates the dependency file format that Ninja should expect to
@@ -45,6 +47,27 @@ func (d Deps) String() string {
 	}
 }
 
+// validateDeps checks that the Deps, Depfile and MsvcDepsPrefix params of a rule or build
+// statement are consistent.  A build statement with DepsNone uses the Deps of its rule, so it
+// may set MsvcDepsPrefix.
+func validateDeps(deps Deps, depfile, msvcDepsPrefix string, build bool) error {
+	switch deps {
+	case DepsNone, DepsGCC:
+	case DepsMSVC:
+		if depfile != "" {
+			return fmt.Errorf("Depfile can't be used with DepsMSVC, which reads the dependencies " +
+				"from the output of the command")
+		}
+	default:
+		return fmt.Errorf("invalid Deps value %d", deps)
+	}
+
+	if msvcDepsPrefix != "" && deps != DepsMSVC && !(build && deps == DepsNone) {
+		return fmt.Errorf("MsvcDepsPrefix requires DepsMSVC, not %s", deps)
+	}
+	return nil
+}
+
 // A PoolParams object contains the set of parameters that make up a Ninja pool
 // definition.
 type PoolParams struct {
@@ -67,10 +90,20 @@ type RuleParams struct {
 	RspfileContent string   // The response file content.
 	SymlinkOutputs []string // The list of Outputs or ImplicitOutputs that are symlinks.
 
+	// MsvcDepsPrefix is the prefix of the lines printed by the compiler that name included
+	// headers, which Ninja strips to find the dependencies of the command when Deps is DepsMSVC.
+	// It is only needed for compilers whose output is localized, and requires Deps to be DepsMSVC.
+	MsvcDepsPrefix string
+
 	// These fields are used internally in Blueprint
 	CommandDeps      []string // Command-specific implicit dependencies to prepend to builds
 	CommandOrderOnly []string // Command-specific order-only dependencies to prepend to builds
 	Comment          string   // The comment that will appear above the definition.
+
+	// Strict requires every build statement that uses the rule to declare all the files that its
+	// command refers to, so that the command can run in a sandbox or on a remote execution
+	// service.  See BuildParams.Strict.
+	Strict bool
 }
 
 // A BuildParams object contains the set of parameters that make up a Ninja
@@ -89,9 +122,44 @@ type BuildParams struct {
 	Inputs          []string          // The list of explicit input dependencies.
 	Implicits       []string          // The list of implicit input dependencies.
 	OrderOnly       []string          // The list of order-only dependencies.
-	Validations     []string          // The list of validations to run when this rule runs.
+	Validations     []string          // The list of validations to run when this rule runs, requires Ninja 1.11.
 	Args            map[string]string // The variable/value pairs to set.
 	Optional        bool              // Skip outputting a default statement
+
+	// Pool is the Ninja pool the build statement runs in, overriding the pool of the rule, for
+	// example Console for an interactive action or a pool with a small depth for an action that
+	// uses a lot of memory.  The pool must be visible in the scope of the build statement.
+	Pool Pool
+
+	// Rspfile and RspfileInputs describe a response file that is written by Ninja before the
+	// command runs, for commands whose list of inputs would exceed the command line length limit.
+	// RspfileInputs are written to the response file separated by spaces, each one escaped for
+	// the shell, and are added to the implicit dependencies of the build statement.  The rule
+	// must refer to the file with $rspfile and must not set Rspfile or RspfileContent itself, as
+	// the rule's values would take precedence over the ones set by the build statement.
+	Rspfile       string   // The response file.
+	RspfileInputs []string // The list of input dependencies to write to the response file.
+
+	// Dyndep is a file in Ninja's dyndep format, generated by another build statement, that lists
+	// inputs and outputs of this build statement that are only discovered at build time, for
+	// example the module interfaces imported by a Fortran or C++20 source file.  It is added to
+	// the order-only dependencies unless it is already one of the Inputs, Implicits or OrderOnly.
+	// Dyndep requires Ninja 1.10, and ninja_required_version is raised to 1.10.0 when it is used.
+	Dyndep string
+
+	// MsvcDepsPrefix overrides RuleParams.MsvcDepsPrefix for the build statement.  It requires
+	// Deps to be DepsMSVC, or DepsNone to use the Deps of the rule.
+	MsvcDepsPrefix string
+
+	// Strict requires the build statement to declare all the files that the command of its rule
+	// refers to, even if the rule doesn't set RuleParams.Strict.  After all the build actions are
+	// generated, each word of a strict build statement's command that contains a '/' is treated
+	// as a path, and must be one of the Inputs, Implicits, OrderOnly, Outputs, ImplicitOutputs,
+	// Depfile or Rspfile of the build statement, one of the CommandDeps or CommandOrderOnly of the
+	// rule, or a directory containing one of them.  Absolute paths are assumed to refer to the
+	// execution environment and are not checked.  Strict build statements are described by
+	// Context.WriteActionManifest.
+	Strict bool
 }
 
 // A poolDef describes a pool definition.  It does not include the name of the
@@ -136,6 +204,7 @@ type ruleDef struct {
 	Comment          string
 	Pool             Pool
 	Variables        map[string]ninjaString
+	Strict           bool
 }
 
 func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
@@ -145,6 +214,7 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		Comment:   params.Comment,
 		Pool:      params.Pool,
 		Variables: make(map[string]ninjaString),
+		Strict:    params.Strict,
 	}
 
 	if params.Command == "" {
@@ -152,6 +222,10 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 			"specified")
 	}
 
+	if err := validateDeps(params.Deps, params.Depfile, params.MsvcDepsPrefix, false); err != nil {
+		return nil, err
+	}
+
 	if r.Pool != nil && !scope.IsPoolVisible(r.Pool) {
 		return nil, fmt.Errorf("Pool %s is not visible in this scope", r.Pool)
 	}
@@ -174,6 +248,14 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["deps"] = simpleNinjaString(params.Deps.String())
 	}
 
+	if params.MsvcDepsPrefix != "" {
+		value, err = parseNinjaString(scope, params.MsvcDepsPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing MsvcDepsPrefix param: %s", err)
+		}
+		r.Variables["msvc_deps_prefix"] = value
+	}
+
 	if params.Description != "" {
 		value, err = parseNinjaString(scope, params.Description)
 		if err != nil {
@@ -272,7 +354,9 @@ type buildDef struct {
 	Validations     []ninjaString
 	Args            map[Variable]ninjaString
 	Variables       map[string]ninjaString
+	Pool            Pool
 	Optional        bool
+	Strict          bool
 }
 
 func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
@@ -333,6 +417,44 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 	}
 
 	b.Optional = params.Optional
+	b.Strict = params.Strict
+
+	if params.Pool != nil {
+		if !scope.IsPoolVisible(params.Pool) {
+			return nil, fmt.Errorf("Pool %s is not visible in this scope", params.Pool)
+		}
+		b.Pool = params.Pool
+	}
+
+	if len(params.RspfileInputs) > 0 {
+		if params.Rspfile == "" {
+			return nil, errors.New("RspfileInputs param requires the Rspfile param")
+		}
+
+		rspfileInputs, err := parseNinjaStrings(scope, params.RspfileInputs)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing RspfileInputs param: %s", err)
+		}
+		b.Implicits = append(b.Implicits, rspfileInputs...)
+
+		escapedInputs := make([]string, len(params.RspfileInputs))
+		for i, input := range params.RspfileInputs {
+			escapedInputs[i] = proptools.ShellEscapeIncludingSpaces(input)
+		}
+		value, err := parseNinjaString(scope, strings.Join(escapedInputs, " "))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing RspfileInputs param: %s", err)
+		}
+		setVariable("rspfile_content", value)
+	}
+
+	if params.Rspfile != "" {
+		value, err := parseNinjaString(scope, params.Rspfile)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Rspfile param: %s", err)
+		}
+		setVariable("rspfile", value)
+	}
 
 	if params.Depfile != "" {
 		value, err := parseNinjaString(scope, params.Depfile)
@@ -342,10 +464,43 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 		setVariable("depfile", value)
 	}
 
+	if params.Dyndep != "" {
+		value, err := parseNinjaString(scope, params.Dyndep)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Dyndep param: %s", err)
+		}
+		setVariable("dyndep", value)
+
+		// Ninja requires the dyndep file to be an input of the build statement.
+		isInput := false
+		for _, inputs := range [][]string{params.Inputs, params.Implicits, params.OrderOnly} {
+			for _, input := range inputs {
+				if input == params.Dyndep {
+					isInput = true
+				}
+			}
+		}
+		if !isInput {
+			b.OrderOnly = append(b.OrderOnly, value)
+		}
+	}
+
+	if err := validateDeps(params.Deps, params.Depfile, params.MsvcDepsPrefix, true); err != nil {
+		return nil, err
+	}
+
 	if params.Deps != DepsNone {
 		setVariable("deps", simpleNinjaString(params.Deps.String()))
 	}
 
+	if params.MsvcDepsPrefix != "" {
+		value, err := parseNinjaString(scope, params.MsvcDepsPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing MsvcDepsPrefix param: %s", err)
+		}
+		setVariable("msvc_deps_prefix", value)
+	}
+
 	if params.Description != "" {
 		value, err := parseNinjaString(scope, params.Description)
 		if err != nil {
@@ -401,8 +556,12 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*packageContext]string)
 	)
 
 	if b.RuleDef != nil {
-		implicitDeps = append(b.RuleDef.CommandDeps, implicitDeps...)
-		orderOnlyDeps = append(b.RuleDef.CommandOrderOnly, orderOnlyDeps...)
+		// The RuleDef may be shared with build definitions that are being written concurrently,
+		// so never append to its slices in place.
+		implicitDeps = append(b.RuleDef.CommandDeps[:len(b.RuleDef.CommandDeps):len(b.RuleDef.CommandDeps)],
+			implicitDeps...)
+		orderOnlyDeps = append(b.RuleDef.CommandOrderOnly[:len(b.RuleDef.CommandOrderOnly):len(b.RuleDef.CommandOrderOnly)],
+			orderOnlyDeps...)
 	}
 
 	err := nw.Build(comment, rule, outputs, implicitOuts, explicitDeps, implicitDeps, orderOnlyDeps, validations, pkgNames)
@@ -410,6 +569,13 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*packageContext]string)
 		return err
 	}
 
+	if b.Pool != nil {
+		err = nw.ScopedAssign("pool", b.Pool.fullName(pkgNames))
+		if err != nil {
+			return err
+		}
+	}
+
 	err = writeVariables(nw, b.Variables, pkgNames)
 	if err != nil {
 		return err
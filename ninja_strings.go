@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 )
 
 const eof = -1
@@ -59,6 +60,53 @@ func simpleNinjaString(str string) ninjaString {
 	return literalNinjaString(str)
 }
 
+const (
+	literalNinjaStringCacheShards = 16
+	literalNinjaStringCacheSlots  = 256
+)
+
+// A literalNinjaStringCache shares the ninjaStrings of the literals parsed by the build statements
+// of a Context.  The same literals, for example rule names, flags and common paths, are used by
+// many build statements, and sharing them lets all of those use one string and one interface value
+// instead of allocating new ones for every build statement.  Most literals, like the outputs of
+// build statements, are only parsed once though, so instead of keeping every literal the cache has
+// a fixed number of slots and a literal replaces the one in its slot: literals that repeat stay
+// cached and the memory used by the cache is bounded.
+type literalNinjaStringCache struct {
+	shards [literalNinjaStringCacheShards]literalNinjaStringCacheShard
+}
+
+type literalNinjaStringCacheShard struct {
+	sync.Mutex
+	slots [literalNinjaStringCacheSlots]ninjaString
+}
+
+func newLiteralNinjaStringCache() *literalNinjaStringCache {
+	return &literalNinjaStringCache{}
+}
+
+// get returns the ninjaString for a literal string, which is shared with earlier calls for the
+// same string if it is still in the cache.
+func (c *literalNinjaStringCache) get(str string) ninjaString {
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(str); i++ {
+		h ^= uint32(str[i])
+		h *= 16777619
+	}
+
+	shard := &c.shards[h%literalNinjaStringCacheShards]
+	slot := &shard.slots[(h/literalNinjaStringCacheShards)%literalNinjaStringCacheSlots]
+
+	shard.Lock()
+	defer shard.Unlock()
+	if s, ok := (*slot).(literalNinjaString); ok && string(s) == str {
+		return *slot
+	}
+	*slot = literalNinjaString(str)
+	return *slot
+}
+
 type parseState struct {
 	scope       scope
 	str         string
@@ -91,7 +139,8 @@ type stateFunc func(*parseState, int, rune) (stateFunc, error)
 
 // parseNinjaString parses an unescaped ninja string (i.e. all $<something>
 // occurrences are expected to be variables or $$) and returns a list of the
-// variable names that the string references.
+// variable names that the string references.  Strings without variables parsed in the scope
+// of a module or singleton context are shared through the literalNinjaStringCache of its Context.
 func parseNinjaString(scope scope, str string) (ninjaString, error) {
 	// naively pre-allocate slices by counting $ signs
 	n := strings.Count(str, "$")
@@ -99,6 +148,9 @@ func parseNinjaString(scope scope, str string) (ninjaString, error) {
 		if strings.HasPrefix(str, " ") {
 			str = "$" + str
 		}
+		if s, ok := scope.(*localScope); ok && s.literals != nil {
+			return s.literals.get(str), nil
+		}
 		return literalNinjaString(str), nil
 	}
 	result := &varNinjaString{
@@ -383,6 +435,10 @@ func toNinjaName(name string) string {
 
 var builtinRuleArgs = []string{"out", "in"}
 
+// builtinRuleVariables are Ninja variables that rule commands may refer to without declaring them
+// as arguments, because they are set by the build statement from the BuildParams.
+var builtinRuleVariables = []string{"rspfile"}
+
 func validateArgName(argName string) error {
 	err := validateNinjaName(argName)
 	if err != nil {
@@ -219,3 +219,47 @@ func BenchmarkNinjaString_Value(b *testing.B) {
 	})
 
 }
+
+func TestParseNinjaStringSharesLiterals(t *testing.T) {
+	shared := newLocalScope(nil, "")
+	shared.literals = newLiteralNinjaStringCache()
+
+	literals := make([]string, 2*literalNinjaStringCacheShards*literalNinjaStringCacheSlots)
+	for i := range literals {
+		literals[i] = "out/lib" + strconv.Itoa(i) + ".so"
+	}
+	// Parse more literals than fit in the cache to check that evicted slots are replaced
+	// correctly.
+	for _, literal := range literals {
+		for _, sc := range []scope{shared, nil} {
+			s, err := parseNinjaString(sc, literal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Value(nil); got != literal {
+				t.Errorf("expected %q, got %q", literal, got)
+			}
+		}
+	}
+
+	literal := literals[0]
+	parseNinjaString(shared, literal)
+	if allocs := testing.AllocsPerRun(100, func() { parseNinjaString(shared, literal) }); allocs != 0 {
+		t.Errorf("expected repeated literals to be shared, got %v allocations", allocs)
+	}
+}
+
+func BenchmarkParseNinjaString_Literal(b *testing.B) {
+	literals := make([]string, 100)
+	for i := range literals {
+		literals[i] = "out/soong/.intermediates/lib" + strconv.Itoa(i) + "/android_arm64/obj.o"
+	}
+	scope := newLocalScope(nil, "")
+	scope.literals = newLiteralNinjaStringCache()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, literal := range literals {
+			parseNinjaString(scope, literal)
+		}
+	}
+}
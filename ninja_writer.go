@@ -261,6 +261,11 @@ func (n *ninjaWriter) Subninja(file string) error {
 	return n.writeStatement("subninja", file)
 }
 
+func (n *ninjaWriter) Include(file string) error {
+	n.justDidBlankLine = false
+	return n.writeStatement("include", file)
+}
+
 func (n *ninjaWriter) BlankLine() (err error) {
 	// We don't output multiple blank lines in a row.
 	if !n.justDidBlankLine {
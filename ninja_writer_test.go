@@ -91,6 +91,12 @@ var ninjaWriterTestCases = []struct {
 		},
 		output: "subninja build.ninja\n",
 	},
+	{
+		input: func(w *ninjaWriter) {
+			ck(w.Include("build.ninja"))
+		},
+		output: "include build.ninja\n",
+	},
 	{
 		input: func(w *ninjaWriter) {
 			ck(w.BlankLine())
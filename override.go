@@ -0,0 +1,152 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// OverridingModule is implemented by modules that can replace other modules.  After all mutators
+// have run every dependency on a variant of a module named by Overrides is replaced with a
+// dependency on the variant of the overriding module that has the same variations, except for the
+// dependencies of the overriding module itself, which can use the module it overrides.  The
+// overridden module is still built, but nothing depends on it.
+//
+// Overrides are applied transitively: if A overrides B and B overrides C then dependencies on C
+// are replaced with dependencies on A.  A module can only be overridden by one other module.
+type OverridingModule interface {
+	Module
+
+	// Overrides returns the names of the modules that this module replaces.
+	Overrides() []string
+}
+
+// SimpleOverrides is an embeddable object that implements the Overrides method of
+// OverridingModule with an "overrides" property.  The module's factory must return
+// &SimpleOverrides.Properties as one of its property structs.
+type SimpleOverrides struct {
+	Properties struct {
+		// names of the modules that this module replaces in the dependencies of all other modules
+		Overrides []string
+	}
+}
+
+func (s *SimpleOverrides) Overrides() []string {
+	return s.Properties.Overrides
+}
+
+// OverrideConfig can be implemented by the config object passed to ResolveDependencies to choose
+// which of the overrides declared by modules are applied.  If the config does not implement it
+// all overrides are applied.
+type OverrideConfig interface {
+	// OverrideEnabled returns true if the module named overriding should replace the module
+	// named overridden.
+	OverrideEnabled(overriding, overridden string) bool
+}
+
+// applyOverrides replaces the dependencies on the modules that are overridden by an enabled
+// OverridingModule.  See OverridingModule.
+func (c *Context) applyOverrides(config interface{}) (errs []error) {
+	overrideConfig, _ := config.(OverrideConfig)
+
+	moduleErrorf := func(module *moduleInfo, format string, args ...interface{}) {
+		errs = append(errs, &ModuleError{
+			BlueprintError: BlueprintError{
+				Err: fmt.Errorf(format, args...),
+				Pos: module.pos,
+			},
+			module: module,
+		})
+	}
+
+	// Collect the overrides in a deterministic order so that conflicts are reported consistently.
+	replacements := make(map[*moduleInfo]*moduleInfo)
+	for _, module := range c.sortedModuleInfos() {
+		overriding, ok := module.logicModule.(OverridingModule)
+		if !ok || module.disabled {
+			continue
+		}
+		if d, ok := module.logicModule.(DisableableModule); ok && !d.BlueprintEnabled() {
+			continue
+		}
+
+		for _, name := range overriding.Overrides() {
+			if overrideConfig != nil && !overrideConfig.OverrideEnabled(module.Name(), name) {
+				continue
+			}
+
+			group := c.moduleGroupFromName(name, module.namespace())
+			if group == nil {
+				moduleErrorf(module, "overrides unknown module %q", name)
+				continue
+			}
+			if group == module.group {
+				moduleErrorf(module, "cannot override itself")
+				continue
+			}
+
+			var overridden *moduleInfo
+			for _, m := range group.modules {
+				if m := m.module(); m != nil && m.variant.variations.equal(module.variant.variations) {
+					overridden = m
+					break
+				}
+			}
+			if overridden == nil {
+				moduleErrorf(module, "overrides module %q, which has no variant %q",
+					name, module.variant.name)
+				continue
+			}
+
+			if existing, exists := replacements[overridden]; exists && existing != module {
+				moduleErrorf(module, "cannot override %q, which is already overridden by %q",
+					name, existing.Name())
+				continue
+			}
+			replacements[overridden] = module
+		}
+	}
+
+	if len(errs) > 0 || len(replacements) == 0 {
+		return errs
+	}
+
+	// replacement follows the chain of overrides from dep to the module that should be used in its
+	// place by module.  Overriding modules can depend on the modules they override, so the chain
+	// stops before module.  It returns nil if the overrides form a cycle.
+	replacement := func(module, dep *moduleInfo) *moduleInfo {
+		for i := 0; i <= len(replacements); i++ {
+			next, exists := replacements[dep]
+			if !exists || next.group == module.group {
+				return dep
+			}
+			dep = next
+		}
+		return nil
+	}
+
+	for _, module := range c.modulesSorted {
+		for i, dep := range module.directDeps {
+			newDep := replacement(module, dep.module)
+			if newDep == nil {
+				moduleErrorf(dep.module, "overrides form a cycle")
+				return errs
+			}
+			module.directDeps[i].module = newDep
+		}
+	}
+
+	return c.updateDependencies()
+}
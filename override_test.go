@@ -0,0 +1,207 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type overrideTestModule struct {
+	SimpleName
+	SimpleOverrides
+	properties struct {
+		Deps []string
+	}
+}
+
+func newOverrideTestModule() (Module, []interface{}) {
+	m := &overrideTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleOverrides.Properties}
+}
+
+func (m *overrideTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+func (m *overrideTestModule) Deps() []string {
+	return m.properties.Deps
+}
+
+func (m *overrideTestModule) IgnoreDeps() []string {
+	return nil
+}
+
+type overrideTestConfig map[string]bool
+
+func (c overrideTestConfig) OverrideEnabled(overriding, overridden string) bool {
+	return !c[overriding+":"+overridden]
+}
+
+func runOverrideTest(t *testing.T, bp string, config interface{}) (*Context, []error) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("override_module", newOverrideTestModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", config)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(config)
+	return ctx, errs
+}
+
+func overrideTestDeps(ctx *Context, name string) []string {
+	var deps []string
+	for _, dep := range ctx.moduleGroupFromName(name, nil).modules.firstModule().directDeps {
+		deps = append(deps, dep.module.Name())
+	}
+	return deps
+}
+
+func TestOverrides(t *testing.T) {
+	bp := `
+		override_module {
+			name: "user",
+			deps: ["lib", "other"],
+		}
+
+		override_module {
+			name: "lib",
+		}
+
+		override_module {
+			name: "vendor_lib",
+			overrides: ["lib"],
+			deps: ["lib"],
+		}
+
+		override_module {
+			name: "product_lib",
+			overrides: ["vendor_lib"],
+			deps: ["vendor_lib"],
+		}
+
+		override_module {
+			name: "other",
+		}
+	`
+
+	testCases := []struct {
+		name   string
+		config interface{}
+		want   map[string][]string
+	}{
+		{
+			name: "chain",
+			want: map[string][]string{
+				"user":        {"product_lib", "other"},
+				"vendor_lib":  {"lib"},
+				"product_lib": {"vendor_lib"},
+			},
+		},
+		{
+			name:   "disabled by config",
+			config: overrideTestConfig{"product_lib:vendor_lib": true},
+			want: map[string][]string{
+				"user":        {"vendor_lib", "other"},
+				"vendor_lib":  {"lib"},
+				"product_lib": {"vendor_lib"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, errs := runOverrideTest(t, bp, testCase.config)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			for name, want := range testCase.want {
+				if got := overrideTestDeps(ctx, name); !reflect.DeepEqual(got, want) {
+					t.Errorf("incorrect deps for %s\nwant: %q\n got: %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOverrideErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		want string
+	}{
+		{
+			name: "unknown",
+			bp: `
+				override_module {
+					name: "a",
+					overrides: ["missing"],
+				}
+			`,
+			want: `module "a": overrides unknown module "missing"`,
+		},
+		{
+			name: "twice",
+			bp: `
+				override_module {
+					name: "a",
+				}
+
+				override_module {
+					name: "b",
+					overrides: ["a"],
+				}
+
+				override_module {
+					name: "c",
+					overrides: ["a"],
+				}
+			`,
+			want: `module "c": cannot override "a", which is already overridden by "b"`,
+		},
+		{
+			name: "cycle",
+			bp: `
+				override_module {
+					name: "user",
+					deps: ["a"],
+				}
+
+				override_module {
+					name: "a",
+					overrides: ["b"],
+				}
+
+				override_module {
+					name: "b",
+					overrides: ["a"],
+				}
+			`,
+			want: `module "a": overrides form a cycle`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, errs := runOverrideTest(t, testCase.bp, nil)
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testCase.want) {
+				t.Errorf("expected error %q, got %v", testCase.want, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/proptools"
+)
+
+// packageModuleType is the module type of the package modules, which are handled by the Context
+// instead of a registered module factory.
+const packageModuleType = "package"
+
+// PackageDefaults are the defaults for the modules in a directory, set by a package module in one
+// of its Blueprints files:
+//
+//	package {
+//	    default_visibility: [":__subpackages__"],
+//	    default_applicable_licenses: ["my_license"],
+//	    default_owners: ["team@example.com"],
+//	}
+//
+// A directory can contain at most one package module, and it doesn't have a name.  A property
+// that isn't set by the package module of a directory is inherited from the package module of the
+// closest ancestor directory that sets it.  Module implementations can read the defaults that
+// apply to them with EarlyModuleContext.PackageDefaults.
+type PackageDefaults struct {
+	// Default_visibility is the visibility of the modules that don't have any visibility rules,
+	// see VisibilityModule.  The rules that are relative to the directory of the package module,
+	// for example ":__subpackages__", are converted to rules with an absolute path.
+	Default_visibility []string
+
+	// Default_applicable_licenses is the list of the names of the licenses that apply to the
+	// modules that don't list their licenses.
+	Default_applicable_licenses []string
+
+	// Default_owners is the list of the owners of the modules that don't list their owners.
+	Default_owners []string
+}
+
+type packageInfo struct {
+	def               *parser.Module
+	relBlueprintsFile string
+	dir               string
+	pos               scanner.Position
+	propertyPos       map[string]scanner.Position
+	defaults          PackageDefaults
+}
+
+// processPackageDef unpacks the properties of a package module defined in relBlueprintsFile, and
+// checks its default visibility rules.
+func processPackageDef(packageDef *parser.Module, relBlueprintsFile string) (*packageInfo, []error) {
+	pkg := &packageInfo{
+		def:               packageDef,
+		relBlueprintsFile: relBlueprintsFile,
+		dir:               path.Dir(relBlueprintsFile),
+		pos:               packageDef.TypePos,
+	}
+
+	propertyMap, errs := proptools.UnpackProperties(packageDef.Properties, &pkg.defaults)
+	if len(errs) > 0 {
+		for i, err := range errs {
+			if unpackErr, ok := err.(*proptools.UnpackError); ok {
+				errs[i] = &BlueprintError{
+					Err: unpackErr.Err,
+					Pos: unpackErr.Pos,
+				}
+			}
+		}
+		return nil, errs
+	}
+
+	pkg.propertyPos = make(map[string]scanner.Position)
+	for name, propertyDef := range propertyMap {
+		pkg.propertyPos[name] = propertyDef.ColonPos
+	}
+
+	rules := pkg.defaults.Default_visibility
+	for i, rule := range rules {
+		var err error
+		if (rule == visibilityPublic || rule == visibilityPrivate) && len(rules) > 1 {
+			err = fmt.Errorf("visibility rule %q cannot be combined with other rules", rule)
+		} else {
+			_, err = parseVisibilityRule(rule, pkg.dir)
+		}
+		if err != nil {
+			return nil, []error{&BlueprintError{
+				Err: err,
+				Pos: pkg.propertyPos["default_visibility"],
+			}}
+		}
+		// Make the rules relative to the package directory absolute, so that they keep their
+		// meaning when they are applied to modules in subdirectories.
+		if strings.HasPrefix(rule, ":") {
+			dir := pkg.dir
+			if dir == "." {
+				dir = ""
+			}
+			rules[i] = "//" + dir + rule
+		}
+	}
+
+	return pkg, nil
+}
+
+// addPackage adds the package module defined in relBlueprintsFile to the Context.
+func (c *Context) addPackage(packageDef *parser.Module, relBlueprintsFile string) []error {
+	pkg, errs := processPackageDef(packageDef, relBlueprintsFile)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	c.packagesLock.Lock()
+	defer c.packagesLock.Unlock()
+
+	if prev, exists := c.packages[pkg.dir]; exists {
+		return []error{&BlueprintError{
+			Err: fmt.Errorf("package module already defined in directory %q\n"+
+				"       %s <-- previous definition here", pkg.dir, prev.pos),
+			Pos: pkg.pos,
+		}}
+	}
+	if c.packages == nil {
+		c.packages = make(map[string]*packageInfo)
+	}
+	c.packages[pkg.dir] = pkg
+	return nil
+}
+
+// packageDefaults returns the package defaults that apply to the modules in dir, and the position
+// of the default_visibility property they inherit, if any.  While parsing it only returns the
+// defaults from the Blueprints files that have already been processed, which include the
+// Blueprints files of the ancestor directories and the package module of the same Blueprints file.
+func (c *Context) packageDefaults(dir string) (defaults PackageDefaults, visibilityPos scanner.Position) {
+	c.packagesLock.Lock()
+	defer c.packagesLock.Unlock()
+
+	inherited := make(map[string]bool)
+	inherit := func(property string, value *[]string, pkg *packageInfo, pkgValue []string) bool {
+		if _, set := pkg.propertyPos[property]; set && !inherited[property] {
+			inherited[property] = true
+			*value = pkgValue
+			return true
+		}
+		return false
+	}
+
+	for {
+		if pkg, ok := c.packages[dir]; ok {
+			if inherit("default_visibility", &defaults.Default_visibility, pkg, pkg.defaults.Default_visibility) {
+				visibilityPos = pkg.propertyPos["default_visibility"]
+			}
+			inherit("default_applicable_licenses", &defaults.Default_applicable_licenses, pkg,
+				pkg.defaults.Default_applicable_licenses)
+			inherit("default_owners", &defaults.Default_owners, pkg, pkg.defaults.Default_owners)
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	return defaults, visibilityPos
+}
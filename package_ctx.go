@@ -20,6 +20,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -61,6 +62,8 @@ type PackageContext interface {
 	StaticVariable(name, value string) Variable
 	VariableFunc(name string, f func(config interface{}) (string, error)) Variable
 	VariableConfigMethod(name string, method interface{}) Variable
+	IntVariableFunc(name string, f func(config interface{}) (int64, error)) Variable
+	BoolVariableFunc(name string, f func(config interface{}) (bool, error)) Variable
 
 	StaticPool(name string, params PoolParams) Pool
 	PoolFunc(name string, f func(interface{}) (PoolParams, error)) Pool
@@ -304,6 +307,10 @@ func (v *staticVariable) memoizeFullName(pkgNames map[*packageContext]string) {
 	v.fullName_ = v.fullName(pkgNames)
 }
 
+func (v *staticVariable) setFullName(name string) {
+	v.fullName_ = name
+}
+
 func (v *staticVariable) value(interface{}) (ninjaString, error) {
 	ninjaStr, err := parseNinjaString(v.pctx.scope, v.value_)
 	if err != nil {
@@ -358,9 +365,47 @@ func (p *packageContext) VariableFunc(name string,
 	return v
 }
 
+// IntVariableFunc returns a Variable whose value is the decimal representation
+// of the integer returned by a function that takes a config object as input.
+// It behaves like VariableFunc, but guarantees that all integer config values
+// are formatted the same way.
+func (p *packageContext) IntVariableFunc(name string,
+	f func(config interface{}) (int64, error)) Variable {
+
+	checkCalledFromInit()
+
+	return p.VariableFunc(name, func(config interface{}) (string, error) {
+		value, err := f(config)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(value, 10), nil
+	})
+}
+
+// BoolVariableFunc returns a Variable whose value is "true" or "false"
+// depending on the boolean returned by a function that takes a config object
+// as input.  It behaves like VariableFunc, but guarantees that all boolean
+// config values are formatted the same way, so rule commands can compare
+// against a single canonical spelling.
+func (p *packageContext) BoolVariableFunc(name string,
+	f func(config interface{}) (bool, error)) Variable {
+
+	checkCalledFromInit()
+
+	return p.VariableFunc(name, func(config interface{}) (string, error) {
+		value, err := f(config)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(value), nil
+	})
+}
+
 // VariableConfigMethod returns a Variable whose value is determined by calling
 // a method on the config object.  The method must take no arguments and return
-// a single string that will be the variable's value.  It may only be called
+// a single string, integer or bool that will be the variable's value.  Integers
+// are formatted in decimal and bools as "true" or "false".  It may only be called
 // during a Go package's initialization - either from the init() function or as
 // part of a package-scoped variable's initialization.
 //
@@ -384,8 +429,7 @@ func (p *packageContext) VariableConfigMethod(name string,
 
 	fun := func(config interface{}) (string, error) {
 		result := methodValue.Call([]reflect.Value{reflect.ValueOf(config)})
-		resultStr := result[0].Interface().(string)
-		return resultStr, nil
+		return formatVariableValue(result[0]), nil
 	}
 
 	v := &variableFunc{
@@ -420,6 +464,10 @@ func (v *variableFunc) memoizeFullName(pkgNames map[*packageContext]string) {
 	v.fullName_ = v.fullName(pkgNames)
 }
 
+func (v *variableFunc) setFullName(name string) {
+	v.fullName_ = name
+}
+
 func (v *variableFunc) value(config interface{}) (ninjaString, error) {
 	value, err := v.value_(config)
 	if err != nil {
@@ -453,9 +501,30 @@ func validateVariableMethod(name string, methodValue reflect.Value) {
 		panic(fmt.Errorf("method for variable %s has %d outputs (should be 1)",
 			name, n))
 	}
-	if kind := methodType.Out(0).Kind(); kind != reflect.String {
-		panic(fmt.Errorf("method for variable %s does not return a string",
-			name))
+	switch kind := methodType.Out(0).Kind(); kind {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		panic(fmt.Errorf("method for variable %s returns %s (should be a string, integer or bool)",
+			name, methodType.Out(0)))
+	}
+}
+
+// formatVariableValue converts a string, integer or bool value returned by a
+// variable config method into its canonical variable value.
+func formatVariableValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	default:
+		panic(fmt.Errorf("unsupported variable value type %s", v.Type()))
 	}
 }
 
@@ -548,6 +617,10 @@ func (p *staticPool) memoizeFullName(pkgNames map[*packageContext]string) {
 	p.fullName_ = p.fullName(pkgNames)
 }
 
+func (p *staticPool) setFullName(name string) {
+	p.fullName_ = name
+}
+
 func (p *staticPool) def(config interface{}) (*poolDef, error) {
 	def, err := parsePoolParams(p.pctx.scope, &p.params)
 	if err != nil {
@@ -619,6 +692,10 @@ func (p *poolFunc) memoizeFullName(pkgNames map[*packageContext]string) {
 	p.fullName_ = p.fullName(pkgNames)
 }
 
+func (p *poolFunc) setFullName(name string) {
+	p.fullName_ = name
+}
+
 func (p *poolFunc) def(config interface{}) (*poolDef, error) {
 	params, err := p.paramsFunc(config)
 	if err != nil {
@@ -754,6 +831,10 @@ func (r *staticRule) memoizeFullName(pkgNames map[*packageContext]string) {
 	r.fullName_ = r.fullName(pkgNames)
 }
 
+func (r *staticRule) setFullName(name string) {
+	r.fullName_ = name
+}
+
 func (r *staticRule) def(interface{}) (*ruleDef, error) {
 	def, err := parseRuleParams(r.scope(), &r.params)
 	if err != nil {
@@ -868,6 +949,10 @@ func (r *ruleFunc) memoizeFullName(pkgNames map[*packageContext]string) {
 	r.fullName_ = r.fullName(pkgNames)
 }
 
+func (r *ruleFunc) setFullName(name string) {
+	r.fullName_ = name
+}
+
 func (r *ruleFunc) def(config interface{}) (*ruleDef, error) {
 	params, err := r.paramsFunc(config)
 	if err != nil {
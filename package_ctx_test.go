@@ -0,0 +1,89 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type typedVariablesTestConfig struct {
+	jobs    int64
+	enabled bool
+}
+
+func (c typedVariablesTestConfig) Jobs() int      { return int(c.jobs) }
+func (c typedVariablesTestConfig) Enabled() bool  { return c.enabled }
+func (c typedVariablesTestConfig) Threads() uint8 { return 4 }
+func (c typedVariablesTestConfig) Name() string   { return "test" }
+func (c typedVariablesTestConfig) Ratio() float64 { return 0.5 }
+
+var (
+	typedVariablesTestPctx = NewPackageContext("github.com/google/blueprint/typed_variables_test")
+
+	typedVariablesTestIntFunc = typedVariablesTestPctx.IntVariableFunc("intFunc",
+		func(config interface{}) (int64, error) {
+			return config.(typedVariablesTestConfig).jobs, nil
+		})
+	typedVariablesTestBoolFunc = typedVariablesTestPctx.BoolVariableFunc("boolFunc",
+		func(config interface{}) (bool, error) {
+			return config.(typedVariablesTestConfig).enabled, nil
+		})
+	typedVariablesTestIntMethod = typedVariablesTestPctx.VariableConfigMethod("intMethod",
+		typedVariablesTestConfig.Jobs)
+	typedVariablesTestUintMethod = typedVariablesTestPctx.VariableConfigMethod("uintMethod",
+		typedVariablesTestConfig.Threads)
+	typedVariablesTestBoolMethod = typedVariablesTestPctx.VariableConfigMethod("boolMethod",
+		typedVariablesTestConfig.Enabled)
+	typedVariablesTestStringMethod = typedVariablesTestPctx.VariableConfigMethod("stringMethod",
+		typedVariablesTestConfig.Name)
+)
+
+func TestTypedVariables(t *testing.T) {
+	config := typedVariablesTestConfig{jobs: -12, enabled: false}
+
+	testCases := []struct {
+		variable Variable
+		want     string
+	}{
+		{typedVariablesTestIntFunc, "-12"},
+		{typedVariablesTestBoolFunc, "false"},
+		{typedVariablesTestIntMethod, "-12"},
+		{typedVariablesTestUintMethod, "4"},
+		{typedVariablesTestBoolMethod, "false"},
+		{typedVariablesTestStringMethod, "test"},
+	}
+
+	for _, testCase := range testCases {
+		value, err := testCase.variable.value(config)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.variable, err)
+			continue
+		}
+		if g := value.Value(nil); g != testCase.want {
+			t.Errorf("%s: want %q, got %q", testCase.variable, testCase.want, g)
+		}
+	}
+}
+
+func TestVariableConfigMethodInvalidType(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic for method returning float64")
+		}
+	}()
+
+	validateVariableMethod("ratio", reflect.ValueOf(typedVariablesTestConfig.Ratio))
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func parsePackageTestFiles(ctx *Context, files map[string]string) []error {
+	mockFS := make(map[string][]byte)
+	for name, contents := range files {
+		mockFS[name] = []byte(contents)
+	}
+	ctx.MockFileSystem(mockFS)
+	ctx.SetModuleListFile(MockModuleListFile)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	return errs
+}
+
+func TestPackageDefaults(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	var lock sync.Mutex
+	defaults := make(map[string]PackageDefaults)
+	ctx.RegisterBottomUpMutator("package_defaults", func(ctx BottomUpMutatorContext) {
+		lock.Lock()
+		defer lock.Unlock()
+		defaults[ctx.ModuleName()] = ctx.PackageDefaults()
+	})
+
+	errs := parsePackageTestFiles(ctx, map[string]string{
+		"Blueprints": `
+			package {
+				default_applicable_licenses: ["root_license"],
+				default_owners: ["root_owner"],
+			}
+			foo_module { name: "root" }
+		`,
+		"a/Blueprints": `
+			foo_module { name: "a" }
+			package {
+				default_owners: ["a_owner"],
+			}
+		`,
+		"a/b/Blueprints": `
+			foo_module { name: "b" }
+		`,
+		"c/Blueprints": `
+			package {
+				default_applicable_licenses: [],
+			}
+			foo_module { name: "c" }
+		`,
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	want := map[string]PackageDefaults{
+		"root": {
+			Default_applicable_licenses: []string{"root_license"},
+			Default_owners:              []string{"root_owner"},
+		},
+		"a": {
+			Default_applicable_licenses: []string{"root_license"},
+			Default_owners:              []string{"a_owner"},
+		},
+		"b": {
+			Default_applicable_licenses: []string{"root_license"},
+			Default_owners:              []string{"a_owner"},
+		},
+		"c": {
+			Default_applicable_licenses: []string{},
+			Default_owners:              []string{"root_owner"},
+		},
+	}
+	for name, w := range want {
+		if g := defaults[name]; !reflect.DeepEqual(g, w) {
+			t.Errorf("want package defaults %+v for %q, got %+v", w, name, g)
+		}
+	}
+}
+
+func TestPackageDefaultVisibility(t *testing.T) {
+	testCases := []struct {
+		name             string
+		visibility       string
+		moduleVisibility string
+		dependent        string
+		wantErr          string
+	}{
+		{
+			name:       "subpackages",
+			visibility: `[":__subpackages__"]`,
+			dependent:  "lib/sub",
+		},
+		{
+			name:       "subpackages excludes other directories",
+			visibility: `[":__subpackages__"]`,
+			dependent:  "other",
+			wantErr: `"libfoo" in directory "lib/sub" has visibility ["//lib:__subpackages__"]` +
+				"\n  default_visibility of the package set at lib/Blueprints:3:25",
+		},
+		{
+			name:       "private",
+			visibility: `["//visibility:private"]`,
+			dependent:  "lib",
+			wantErr:    `"user" in directory "lib" depends on "libfoo", which is not visible to it`,
+		},
+		{
+			name:             "overridden by module",
+			visibility:       `["//visibility:private"]`,
+			moduleVisibility: `["//other:__pkg__"]`,
+			dependent:        "other",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			visibility := ""
+			if testCase.moduleVisibility != "" {
+				visibility = "visibility: " + testCase.moduleVisibility + ","
+			}
+			ctx := NewContext()
+			ctx.RegisterModuleType("foo_module", newVisibilityTestModule)
+			ctx.RegisterBottomUpMutator("deps", depsMutator)
+			files := map[string]string{
+				"Blueprints": `subdirs = ["*"]`,
+				"lib/Blueprints": `
+					package {
+						default_visibility: ` + testCase.visibility + `,
+					}
+				`,
+				"lib/sub/Blueprints": `
+					foo_module {
+						name: "libfoo",
+						` + visibility + `
+					}
+				`,
+			}
+			files[testCase.dependent+"/Blueprints"] += `
+					foo_module {
+						name: "user",
+						deps: ["libfoo"],
+					}
+				`
+
+			errs := parsePackageTestFiles(ctx, files)
+			if testCase.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %q", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("want a single error %q, got %q", testCase.wantErr, errs)
+			}
+			if !strings.Contains(errs[0].Error(), testCase.wantErr) {
+				t.Errorf("want error containing %q, got %q", testCase.wantErr, errs[0])
+			}
+			if !errors.Is(errs[0], ErrNotVisible) {
+				t.Errorf("want error matching ErrNotVisible, got %q", errs[0])
+			}
+		})
+	}
+}
+
+func TestPackageErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "duplicate",
+			files: map[string]string{
+				"Blueprints": `
+					package {}
+					package {}
+				`,
+			},
+			wantErr: `Blueprints:3:6: package module already defined in directory "."`,
+		},
+		{
+			name: "unknown property",
+			files: map[string]string{
+				"Blueprints": `
+					package { name: "foo" }
+				`,
+			},
+			wantErr: `Blueprints:2:20: unrecognized property "name"`,
+		},
+		{
+			name: "invalid default visibility",
+			files: map[string]string{
+				"Blueprints": `
+					package { default_visibility: ["other"] }
+				`,
+			},
+			wantErr: `Blueprints:2:34: invalid visibility rule "other"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			errs := parsePackageTestFiles(ctx, testCase.files)
+			if len(errs) != 1 {
+				t.Fatalf("want a single error %q, got %q", testCase.wantErr, errs)
+			}
+			if !strings.Contains(errs[0].Error(), testCase.wantErr) {
+				t.Errorf("want error containing %q, got %q", testCase.wantErr, errs[0])
+			}
+		})
+	}
+
+	t.Run("reserved module type", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected a panic for registering a package module type")
+			}
+		}()
+		NewContext().RegisterModuleType("package", newFooModule)
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RegisterParallelSingletonType registers a singleton type like RegisterSingletonType, but the
+// singleton may be invoked concurrently with the other parallel singletons.  A parallel
+// singleton still runs after every singleton registered before it with RegisterSingletonType
+// and before every singleton registered after it with RegisterSingletonType, and
+// SingletonRunsAfter can order it after other parallel singletons.
+//
+// The GenerateBuildActions method of a parallel singleton must not modify state shared with
+// other singletons without synchronization.  The build actions, errors and Ninja file settings
+// of all singletons are merged in registration order, so the generated Ninja file doesn't depend
+// on the order in which parallel singletons finish.
+func (c *Context) RegisterParallelSingletonType(name string, factory SingletonFactory) {
+	c.checkRegistration("RegisterParallelSingletonType")
+	c.registerSingletonType(name, factory, true)
+}
+
+// SingletonRunsAfter declares that the singleton registered with the name singleton must not
+// start until the singleton registered with the name after has finished, for example because it
+// reads state that the other singleton computes.  Both singletons must already be registered.
+// If the declared orderings and the registration order of the non-parallel singletons form a
+// cycle then PrepareBuildActions returns an error.
+func (c *Context) SingletonRunsAfter(singleton, after string) {
+	c.checkRegistration("SingletonRunsAfter")
+
+	find := func(name string) *singletonInfo {
+		for _, info := range c.singletonInfo {
+			if info.name == name {
+				return info
+			}
+		}
+		panic(fmt.Errorf("singleton %q is not registered", name))
+	}
+
+	info, afterInfo := find(singleton), find(after)
+	if info == afterInfo {
+		panic(fmt.Errorf("singleton %q cannot run after itself", singleton))
+	}
+	info.after = append(info.after, afterInfo)
+}
+
+// singletonOrder returns, for each singleton, the indexes of the singletons that must finish
+// before it starts.  Every singleton that is not parallel waits for all the singletons before
+// it, and every singleton waits for the last non-parallel singleton before it.  It returns an
+// error if the orderings form a cycle.
+func singletonOrder(singletons []*singletonInfo) ([][]int, error) {
+	index := make(map[*singletonInfo]int, len(singletons))
+	for i, info := range singletons {
+		index[info] = i
+	}
+
+	waitFor := make([][]int, len(singletons))
+	lastSerial := -1
+	for i, info := range singletons {
+		if info.parallel {
+			if lastSerial >= 0 {
+				waitFor[i] = append(waitFor[i], lastSerial)
+			}
+		} else {
+			for j := lastSerial; j < i; j++ {
+				if j >= 0 {
+					waitFor[i] = append(waitFor[i], j)
+				}
+			}
+			lastSerial = i
+		}
+
+		for _, after := range info.after {
+			if j, ok := index[after]; ok {
+				waitFor[i] = append(waitFor[i], j)
+			}
+		}
+	}
+
+	// Check for cycles by visiting the singletons in an order that respects waitFor.
+	waiting, dependents := singletonDependents(waitFor)
+	var ready []int
+	for i := range singletons {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		visited++
+		for _, d := range dependents[i] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if visited < len(singletons) {
+		var names []string
+		for i, info := range singletons {
+			if waiting[i] > 0 {
+				names = append(names, fmt.Sprintf("%q", info.name))
+			}
+		}
+		return nil, fmt.Errorf("cycle in the ordering of singletons %s", strings.Join(names, ", "))
+	}
+
+	return waitFor, nil
+}
+
+// singletonDependents returns the number of singletons that each singleton waits for, and the
+// singletons that wait for each singleton.
+func singletonDependents(waitFor [][]int) (waiting []int, dependents [][]int) {
+	waiting = make([]int, len(waitFor))
+	dependents = make([][]int, len(waitFor))
+	for i, deps := range waitFor {
+		waiting[i] = len(deps)
+		for _, d := range deps {
+			dependents[d] = append(dependents[d], i)
+		}
+	}
+	return waiting, dependents
+}
+
+// runSingletons calls run for each singleton in its own goroutine as soon as all the singletons
+// in its waitFor list have finished.  run returns the number of errors reported by the
+// singleton, and once there are more than maxErrors no more singletons are started.
+func (c *Context) runSingletons(waitFor [][]int, run func(i int) int) {
+	type result struct {
+		i    int
+		errs int
+	}
+
+	waiting, dependents := singletonDependents(waitFor)
+	doneCh := make(chan result)
+	running := 0
+	start := func(i int) {
+		running++
+		go func() {
+			doneCh <- result{i, run(i)}
+		}()
+	}
+
+	for i := range waitFor {
+		if waiting[i] == 0 {
+			start(i)
+		}
+	}
+
+	numErrs := 0
+	for running > 0 {
+		r := <-doneCh
+		running--
+		numErrs += r.errs
+		if numErrs > maxErrors {
+			continue
+		}
+		for _, d := range dependents[r.i] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				start(d)
+			}
+		}
+	}
+}
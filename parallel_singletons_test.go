@@ -0,0 +1,174 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type parallelTestSingleton struct {
+	run func(ctx SingletonContext)
+}
+
+func (s *parallelTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	s.run(ctx)
+}
+
+func newParallelTestSingleton(run func(ctx SingletonContext)) SingletonFactory {
+	return func() Singleton { return &parallelTestSingleton{run: run} }
+}
+
+func runParallelSingletonsTest(t *testing.T, register func(ctx *Context)) (*Context, []error) {
+	t.Helper()
+	ctx := NewContext()
+	register(ctx)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": nil})
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %s", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected dependency errors: %s", errs)
+	}
+	_, errs = ctx.PrepareBuildActions(nil)
+	return ctx, errs
+}
+
+func TestParallelSingletons(t *testing.T) {
+	t.Run("concurrent", func(t *testing.T) {
+		// Each singleton waits for the other one to start, which only finishes if they run
+		// concurrently.
+		var wg sync.WaitGroup
+		wg.Add(2)
+		wait := func(ctx SingletonContext) {
+			wg.Done()
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				ctx.Errorf("timed out waiting for the other singleton")
+			}
+		}
+
+		_, errs := runParallelSingletonsTest(t, func(ctx *Context) {
+			ctx.RegisterParallelSingletonType("a", newParallelTestSingleton(wait))
+			ctx.RegisterParallelSingletonType("b", newParallelTestSingleton(wait))
+		})
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors: %s", errs)
+		}
+	})
+
+	t.Run("ordering", func(t *testing.T) {
+		var lock sync.Mutex
+		var order []string
+		record := func(name string) SingletonFactory {
+			return newParallelTestSingleton(func(ctx SingletonContext) {
+				lock.Lock()
+				defer lock.Unlock()
+				order = append(order, name)
+			})
+		}
+
+		_, errs := runParallelSingletonsTest(t, func(ctx *Context) {
+			ctx.RegisterSingletonType("first", record("first"))
+			ctx.RegisterParallelSingletonType("c", record("c"))
+			ctx.RegisterParallelSingletonType("b", record("b"))
+			ctx.RegisterParallelSingletonType("a", record("a"))
+			ctx.RegisterSingletonType("last", record("last"))
+			ctx.SingletonRunsAfter("c", "b")
+			ctx.SingletonRunsAfter("b", "a")
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %s", errs)
+		}
+
+		want := []string{"first", "a", "b", "c", "last"}
+		if !reflect.DeepEqual(order, want) {
+			t.Errorf("want order %q, got %q", want, order)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := runParallelSingletonsTest(t, func(ctx *Context) {
+			noop := newParallelTestSingleton(func(SingletonContext) {})
+			ctx.RegisterSingletonType("serial", noop)
+			ctx.RegisterParallelSingletonType("parallel", noop)
+			ctx.SingletonRunsAfter("serial", "parallel")
+		})
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), `cycle in the ordering of singletons "serial", "parallel"`) {
+			t.Errorf("expected a cycle error, got %q", errs)
+		}
+	})
+
+	t.Run("errors and panics", func(t *testing.T) {
+		_, errs := runParallelSingletonsTest(t, func(ctx *Context) {
+			ctx.RegisterParallelSingletonType("a", newParallelTestSingleton(func(ctx SingletonContext) {
+				time.Sleep(10 * time.Millisecond)
+				ctx.Errorf("error in a")
+			}))
+			ctx.RegisterParallelSingletonType("b", newParallelTestSingleton(func(ctx SingletonContext) {
+				panic("panic in b")
+			}))
+		})
+
+		// The errors are reported in registration order.
+		if len(errs) != 2 ||
+			!strings.Contains(errs[0].Error(), "error in a") ||
+			!strings.Contains(errs[1].Error(), "panic in GenerateBuildActions for singleton b") {
+			t.Errorf("unexpected errors: %q", errs)
+		}
+	})
+
+	t.Run("subninjas", func(t *testing.T) {
+		ctx, errs := runParallelSingletonsTest(t, func(ctx *Context) {
+			ctx.RegisterParallelSingletonType("a", newParallelTestSingleton(func(ctx SingletonContext) {
+				time.Sleep(10 * time.Millisecond)
+				ctx.AddSubninja("a.ninja")
+			}))
+			ctx.RegisterParallelSingletonType("b", newParallelTestSingleton(func(ctx SingletonContext) {
+				ctx.AddSubninja("b.ninja")
+			}))
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %s", errs)
+		}
+
+		if g, w := ctx.subninjas, []string{"a.ninja", "b.ninja"}; !reflect.DeepEqual(g, w) {
+			t.Errorf("want subninjas %q, got %q", w, g)
+		}
+	})
+}
+
+func TestSingletonRunsAfterUnknown(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected a panic")
+		}
+	}()
+
+	ctx := NewContext()
+	ctx.RegisterParallelSingletonType("a", newParallelTestSingleton(func(SingletonContext) {}))
+	ctx.SingletonRunsAfter("a", "missing")
+}
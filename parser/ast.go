@@ -27,7 +27,7 @@ type Node interface {
 	End() scanner.Position
 }
 
-// Definition is an Assignment or a Module at the top level of a Blueprints file
+// Definition is an Assignment, a Module or an Include at the top level of a Blueprints file
 type Definition interface {
 	Node
 	String() string
@@ -86,6 +86,22 @@ func (m *Module) definitionTag() {}
 func (m *Module) Pos() scanner.Position { return m.TypePos }
 func (m *Module) End() scanner.Position { return m.Map.End() }
 
+// An Include is an include directive at the top level of a Blueprints file, which splices the
+// variable assignments of another file into the scope of the file when it is evaluated.
+type Include struct {
+	IncludePos scanner.Position
+	Path       *String
+}
+
+func (i *Include) String() string {
+	return fmt.Sprintf("include@%s %s", i.IncludePos, i.Path)
+}
+
+func (i *Include) definitionTag() {}
+
+func (i *Include) Pos() scanner.Position { return i.IncludePos }
+func (i *Include) End() scanner.Position { return i.Path.End() }
+
 // A Property is a name: value pair within a Map, which may be a top level Module.
 type Property struct {
 	Name     string
@@ -108,8 +124,9 @@ func (p *Property) Pos() scanner.Position { return p.NamePos }
 func (p *Property) End() scanner.Position { return p.Value.End() }
 
 // An Expression is a Value in a Property or Assignment.  It can be a literal (String or Bool), a
-// Map, a List, an Operator that combines two expressions of the same type, or a Variable that
-// references and Assignment.
+// Map, a List, an Operator that combines two expressions of the same type, a Variable that
+// references and Assignment, a Select that chooses between expressions based on a condition, or a
+// Call to a builtin function.
 type Expression interface {
 	Node
 	// Copy returns a copy of the Expression that will not affect the original if mutated
@@ -240,6 +257,117 @@ func (x *Variable) String() string {
 
 func (x *Variable) Type() Type { return x.Value.Type() }
 
+// A Select is a conditional expression of the form:
+//
+//	select("condition", {
+//	    "value1": expression1,
+//	    "value2": expression2,
+//	    default: expression3,
+//	})
+//
+// When evaluated it takes the value of the case whose pattern matches the value of the condition
+// returned by the SelectEvaluator of the Scope, or the default case if no pattern matches or the
+// condition is not set.
+type Select struct {
+	KeywordPos scanner.Position
+	Condition  *String
+	LBracePos  scanner.Position
+	RBracePos  scanner.Position
+	RParenPos  scanner.Position
+	Cases      []*SelectCase
+	Value      Expression
+}
+
+// A SelectCase is a single case of a Select expression.  Pattern is nil for the default case.
+type SelectCase struct {
+	Pattern    *String
+	DefaultPos scanner.Position
+	ColonPos   scanner.Position
+	Value      Expression
+}
+
+func (x *Select) Pos() scanner.Position { return x.KeywordPos }
+func (x *Select) End() scanner.Position { return endPos(x.RParenPos, 1) }
+
+func (x *Select) Copy() Expression {
+	ret := *x
+	ret.Cases = make([]*SelectCase, len(x.Cases))
+	ret.Value = nil
+	for i, c := range x.Cases {
+		caseCopy := *c
+		caseCopy.Value = c.Value.Copy()
+		ret.Cases[i] = &caseCopy
+		// The evaluated value is the value of the matching case, point it at the copy of the
+		// case so that it isn't shared with the original.
+		if ret.Value == nil && x.Value == c.Value {
+			ret.Value = caseCopy.Value
+		}
+	}
+	if ret.Value == nil && x.Value != nil {
+		ret.Value = x.Value.Copy()
+	}
+	return &ret
+}
+
+func (x *Select) Eval() Expression {
+	return x.Value.Eval()
+}
+
+func (x *Select) Type() Type { return x.Value.Type() }
+
+func (x *Select) String() string {
+	caseStrings := make([]string, len(x.Cases))
+	for i, c := range x.Cases {
+		if c.Pattern == nil {
+			caseStrings[i] = "default: " + c.Value.String()
+		} else {
+			caseStrings[i] = c.Pattern.String() + ": " + c.Value.String()
+		}
+	}
+	return fmt.Sprintf("select(%s, {%s} = %s)@%s", x.Condition, strings.Join(caseStrings, ", "),
+		x.Value, x.KeywordPos)
+}
+
+// A Call is a call to one of the builtin functions, of the form name(arg1, arg2, ...).  Calls are
+// evaluated at parse time, and Value holds the result.
+type Call struct {
+	Name      string
+	NamePos   scanner.Position
+	LParenPos scanner.Position
+	RParenPos scanner.Position
+	Args      []Expression
+	Value     Expression
+}
+
+func (x *Call) Pos() scanner.Position { return x.NamePos }
+func (x *Call) End() scanner.Position { return endPos(x.RParenPos, 1) }
+
+func (x *Call) Copy() Expression {
+	ret := *x
+	ret.Args = make([]Expression, len(x.Args))
+	for i, arg := range x.Args {
+		ret.Args[i] = arg.Copy()
+	}
+	if x.Value != nil {
+		ret.Value = x.Value.Copy()
+	}
+	return &ret
+}
+
+func (x *Call) Eval() Expression {
+	return x.Value.Eval()
+}
+
+func (x *Call) Type() Type { return x.Value.Type() }
+
+func (x *Call) String() string {
+	argStrings := make([]string, len(x.Args))
+	for i, arg := range x.Args {
+		argStrings[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s) = %s@%s", x.Name, strings.Join(argStrings, ", "), x.Value, x.NamePos)
+}
+
 type Map struct {
 	LBracePos  scanner.Position
 	RBracePos  scanner.Position
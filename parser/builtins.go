@@ -0,0 +1,152 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/pathtools"
+)
+
+// A builtinFunction is a function that can be called from a Blueprints file.  Builtin functions
+// are pure: their result depends only on their arguments, which have already been evaluated and
+// checked against nargs.  Errors about a specific argument should be returned as a *ParseError
+// with the position of the argument, other errors are reported at the position of the call.
+type builtinFunction struct {
+	nargs int
+	call  func(call *Call, args []Expression) (Expression, error)
+}
+
+var builtinFunctions = map[string]builtinFunction{
+	// basename(path) returns the last element of path.
+	"basename": {1, func(call *Call, args []Expression) (Expression, error) {
+		return mapStringOrList(call, args[0], filepath.Base)
+	}},
+
+	// dirname(path) returns all but the last element of path.
+	"dirname": {1, func(call *Call, args []Expression) (Expression, error) {
+		return mapStringOrList(call, args[0], filepath.Dir)
+	}},
+
+	// replace(s, old, new) replaces all occurrences of old in s with new.  If s is a list
+	// the replacement is applied to each element.
+	"replace": {3, func(call *Call, args []Expression) (Expression, error) {
+		old, err := stringArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		new, err := stringArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return mapStringOrList(call, args[0], func(s string) string {
+			return strings.ReplaceAll(s, old, new)
+		})
+	}},
+
+	// glob_exclude(list, patterns) returns the elements of list that do not match any of
+	// the glob patterns, which may be a string or a list of strings and may contain **.
+	"glob_exclude": {2, func(call *Call, args []Expression) (Expression, error) {
+		list, err := stringListArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var patterns []string
+		if args[1].Type() == StringType {
+			pattern, _ := stringArg(args[1])
+			patterns = []string{pattern}
+		} else if patterns, err = stringListArg(args[1]); err != nil {
+			return nil, err
+		}
+
+		var ret []string
+	outer:
+		for _, s := range list {
+			for _, pattern := range patterns {
+				match, err := pathtools.Match(pattern, s)
+				if err != nil {
+					return nil, &ParseError{fmt.Errorf("invalid pattern %q: %s", pattern, err),
+						args[1].Pos()}
+				}
+				if match {
+					continue outer
+				}
+			}
+			ret = append(ret, s)
+		}
+		return newStringList(call.NamePos, ret), nil
+	}},
+}
+
+func stringArg(arg Expression) (string, error) {
+	s, ok := arg.Eval().(*String)
+	if !ok {
+		return "", &ParseError{fmt.Errorf("expected string argument, found %s", arg.Type()), arg.Pos()}
+	}
+	return s.Value, nil
+}
+
+func stringListArg(arg Expression) ([]string, error) {
+	list, ok := arg.Eval().(*List)
+	if !ok {
+		return nil, &ParseError{fmt.Errorf("expected list argument, found %s", arg.Type()), arg.Pos()}
+	}
+	ret := make([]string, len(list.Values))
+	for i, v := range list.Values {
+		s, ok := v.Eval().(*String)
+		if !ok {
+			return nil, &ParseError{fmt.Errorf("expected list of strings, found %s", v.Type()), v.Pos()}
+		}
+		ret[i] = s.Value
+	}
+	return ret, nil
+}
+
+// mapStringOrList applies f to arg if it is a string, or to each element of arg if it is a list of
+// strings.
+func mapStringOrList(call *Call, arg Expression, f func(string) string) (Expression, error) {
+	if arg.Type() == StringType {
+		s, _ := stringArg(arg)
+		return &String{LiteralPos: call.NamePos, Value: f(s)}, nil
+	}
+
+	if arg.Type() != ListType {
+		return nil, &ParseError{fmt.Errorf("expected string or list argument, found %s", arg.Type()),
+			arg.Pos()}
+	}
+	list, err := stringListArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i] = f(list[i])
+	}
+	return newStringList(call.NamePos, list), nil
+}
+
+func newStringList(pos scanner.Position, values []string) *List {
+	list := &List{
+		LBracePos: pos,
+		RBracePos: pos,
+		Values:    make([]Expression, len(values)),
+	}
+	for i, v := range values {
+		list.Values[i] = &String{LiteralPos: pos, Value: v}
+	}
+	return list
+}
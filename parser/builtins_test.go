@@ -0,0 +1,126 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBuiltinFunctions(t *testing.T) {
+	testCases := []struct {
+		expr string
+		want interface{}
+	}{
+		{`basename("a/b/c.c")`, "c.c"},
+		{`basename(["a/b.c", "c.c"])`, []string{"b.c", "c.c"}},
+		{`dirname("a/b/c.c")`, "a/b"},
+		{`dirname("c.c")`, "."},
+		{`replace("foo.c", ".c", ".o")`, "foo.o"},
+		{`replace(srcs, ".c", ".o")`, []string{"a.o", "b_test.o", "dir/c.o", "dir/sub/d_test.o"}},
+		{`glob_exclude(srcs, "*_test.c")`, []string{"a.c", "dir/c.c", "dir/sub/d_test.c"}},
+		{`glob_exclude(srcs, ["**/*_test.c", "dir/*"])`, []string{"a.c"}},
+		{`glob_exclude(srcs, [])`, []string{"a.c", "b_test.c", "dir/c.c", "dir/sub/d_test.c"}},
+		{`["x.c"] + basename(glob_exclude(srcs, "*_test.c"))`, []string{"x.c", "a.c", "c.c", "d_test.c"}},
+	}
+
+	for _, testCase := range testCases {
+		input := `
+			srcs = ["a.c", "b_test.c", "dir/c.c", "dir/sub/d_test.c"]
+			x = ` + testCase.expr + `
+		`
+		scope := NewScope(nil)
+		_, errs := ParseAndEval("", bytes.NewBufferString(input), scope)
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected errors: %v", testCase.expr, errs)
+			continue
+		}
+
+		x, _ := scope.Get("x")
+		var got interface{}
+		switch v := x.Value.Eval().(type) {
+		case *String:
+			got = v.Value
+		case *List:
+			var values []string
+			for _, s := range v.Values {
+				values = append(values, s.(*String).Value)
+			}
+			got = values
+		}
+		if !reflect.DeepEqual(got, testCase.want) {
+			t.Errorf("%s: want %q, got %q", testCase.expr, testCase.want, got)
+		}
+	}
+}
+
+func TestCallCopy(t *testing.T) {
+	scope := NewScope(nil)
+	file, errs := ParseAndEval("", bytes.NewBufferString(`x = basename(["a/b.c"])`), scope)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	call := file.Defs[0].(*Assignment).Value.(*Call)
+	callCopy := call.Copy().(*Call)
+	callCopy.Value.(*List).Values[0].(*String).Value = "changed.c"
+	if got := call.Value.(*List).Values[0].(*String).Value; got != "b.c" {
+		t.Errorf("expected changing the copy not to change the original, got %q", got)
+	}
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{
+			input: `x = frob("a")`,
+			err:   `<input>:1:5: unknown function "frob"`,
+		},
+		{
+			input: `x = basename("a", "b")`,
+			err:   `<input>:1:5: basename() expects 1 arguments, found 2`,
+		},
+		{
+			input: `x = basename(true)`,
+			err:   `<input>:1:14: expected string or list argument, found bool`,
+		},
+		{
+			input: `x = replace("a", "b", ["c"])`,
+			err:   `<input>:1:23: expected string argument, found list`,
+		},
+		{
+			input: `x = glob_exclude(["a", 1], "b")`,
+			err:   `<input>:1:24: expected list of strings, found int64`,
+		},
+		{
+			input: `x = glob_exclude(["a"], "[")`,
+			err:   `<input>:1:25: invalid pattern "[": syntax error in pattern`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := ParseAndEval("<input>", bytes.NewBufferString(testCase.input), NewScope(nil))
+		if len(errs) == 0 {
+			t.Errorf("%s: expected error %q", testCase.input, testCase.err)
+			continue
+		}
+		if errs[0].Error() != testCase.err {
+			t.Errorf("%s: want error %q, got %q", testCase.input, testCase.err, errs[0].Error())
+		}
+	}
+}
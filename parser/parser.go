@@ -15,6 +15,7 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -41,6 +42,11 @@ type File struct {
 	Name     string
 	Defs     []Definition
 	Comments []*CommentGroup
+
+	// Includes are the names of the files whose assignments were spliced into the file by
+	// include directives when it was parsed with ParseAndEval, including the files included by
+	// them, in the order they were read.
+	Includes []string
 }
 
 func (f *File) Pos() scanner.Position {
@@ -79,6 +85,7 @@ func parse(p *parser) (file *File, errs []error) {
 		Name:     p.scanner.Filename,
 		Defs:     defs,
 		Comments: comments,
+		Includes: p.includes,
 	}, errs
 
 }
@@ -105,6 +112,15 @@ type parser struct {
 	scope    *Scope
 	comments []*CommentGroup
 	eval     bool
+
+	// includeStack holds the names of the files that included the file being parsed, used to
+	// detect include cycles.  includes holds the names of the files included so far.
+	includeStack []string
+	includes     []string
+
+	// unresolvedIncludes is true if the file contains include directives that were not
+	// evaluated, so variables modified with "+=" may have been set by an included file.
+	unresolvedIncludes bool
 }
 
 func newParser(r io.Reader, scope *Scope) *parser {
@@ -125,6 +141,10 @@ func (p *parser) error(err error) {
 	if !pos.IsValid() {
 		pos = p.scanner.Pos()
 	}
+	p.errorAt(pos, err)
+}
+
+func (p *parser) errorAt(pos scanner.Position, err error) {
 	err = &ParseError{
 		Err: err,
 		Pos: pos,
@@ -180,6 +200,11 @@ func (p *parser) parseDefinitions() (defs []Definition) {
 
 			p.accept(scanner.Ident)
 
+			if ident == "include" && p.tok == scanner.String {
+				defs = append(defs, p.parseInclude(pos))
+				continue
+			}
+
 			switch p.tok {
 			case '+':
 				p.accept('+')
@@ -223,7 +248,9 @@ func (p *parser) parseAssignment(name string, namePos scanner.Position,
 	if p.scope != nil {
 		if assigner == "+=" {
 			if old, local := p.scope.Get(assignment.Name); old == nil {
-				p.errorf("modified non-existent variable %q with +=", assignment.Name)
+				if !p.unresolvedIncludes {
+					p.errorf("modified non-existent variable %q with +=", assignment.Name)
+				}
 			} else if !local {
 				p.errorf("modified non-local variable %q with +=", assignment.Name)
 			} else if old.Referenced {
@@ -247,6 +274,70 @@ func (p *parser) parseAssignment(name string, namePos scanner.Position,
 	return
 }
 
+func (p *parser) parseInclude(includePos scanner.Position) *Include {
+	include := &Include{
+		IncludePos: includePos,
+		Path:       p.parseStringValue(),
+	}
+
+	if p.eval && p.scope != nil {
+		p.include(include)
+	} else {
+		p.unresolvedIncludes = true
+	}
+
+	return include
+}
+
+// include parses the file included by an include directive and adds its assignments to the
+// scope.  The included file may include other files, but may not contain module definitions.
+func (p *parser) include(include *Include) {
+	if p.scope.includeResolver == nil {
+		p.errorAt(include.Path.LiteralPos,
+			fmt.Errorf("can't include %q: include directives are not supported", include.Path.Value))
+		return
+	}
+
+	from := p.scanner.Filename
+	name, contents, err := p.scope.includeResolver.ResolveInclude(from, include.Path.Value)
+	if err != nil {
+		p.errorAt(include.Path.LiteralPos, fmt.Errorf("can't include %q: %s", include.Path.Value, err))
+		return
+	}
+
+	stack := append(append([]string(nil), p.includeStack...), from)
+	for i, includer := range stack {
+		if includer == name {
+			cycle := append(append([]string(nil), stack[i:]...), name)
+			p.errorAt(include.Path.LiteralPos,
+				fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> ")))
+			return
+		}
+	}
+
+	sub := newParser(bytes.NewReader(contents), p.scope)
+	sub.eval = true
+	sub.scanner.Filename = name
+	sub.includeStack = stack
+	file, errs := parse(sub)
+
+	p.includes = append(p.includes, name)
+	p.includes = append(p.includes, sub.includes...)
+
+	p.errors = append(p.errors, errs...)
+	if len(p.errors) >= maxErrors {
+		panic(errTooManyErrors)
+	}
+
+	for _, def := range file.Defs {
+		if module, ok := def.(*Module); ok {
+			p.errorAt(module.TypePos,
+				fmt.Errorf("module definitions are not allowed in included file %s", name))
+			return
+		}
+	}
+}
+
 func (p *parser) parseModule(typ string, typPos scanner.Position) *Module {
 
 	compat := false
@@ -278,7 +369,9 @@ func (p *parser) parseModule(typ string, typPos scanner.Position) *Module {
 }
 
 func (p *parser) parsePropertyList(isModule, compat bool) (properties []*Property) {
-	for p.tok == scanner.Ident {
+	// Map literals may also use quoted strings as keys, which allows keys that are not valid
+	// identifiers.
+	for p.tok == scanner.Ident || (!isModule && p.tok == scanner.String) {
 		property := p.parseProperty(isModule, compat)
 		properties = append(properties, property)
 
@@ -298,7 +391,17 @@ func (p *parser) parseProperty(isModule, compat bool) (property *Property) {
 
 	name := p.scanner.TokenText()
 	namePos := p.scanner.Position
-	p.accept(scanner.Ident)
+	if p.tok == scanner.String {
+		var err error
+		name, err = strconv.Unquote(name)
+		if err != nil {
+			p.errorf("couldn't parse map key: %s", err)
+			return
+		}
+		p.accept(scanner.String)
+	} else {
+		p.accept(scanner.Ident)
+	}
 	pos := p.scanner.Position
 
 	if isModule {
@@ -448,6 +551,12 @@ func (p *parser) parseOperator(value1 Expression) *Operator {
 func (p *parser) parseValue() (value Expression) {
 	switch p.tok {
 	case scanner.Ident:
+		if p.scanner.Peek() == '(' {
+			if p.scanner.TokenText() == "select" {
+				return p.parseSelect()
+			}
+			return p.parseCall()
+		}
 		return p.parseVariable()
 	case '-', scanner.Int: // Integer might have '-' sign ahead ('+' is only treated as operator now)
 		return p.parseIntValue()
@@ -498,6 +607,183 @@ func (p *parser) parseVariable() Expression {
 	return value
 }
 
+func (p *parser) parseSelect() Expression {
+	sel := &Select{
+		KeywordPos: p.scanner.Position,
+	}
+	p.accept(scanner.Ident)
+	if !p.accept('(') {
+		return nil
+	}
+
+	if p.tok != scanner.String {
+		p.errorf("expected select condition string, found %s", scanner.TokenString(p.tok))
+		return nil
+	}
+	sel.Condition = p.parseStringValue()
+	if sel.Condition == nil || !p.accept(',') {
+		return nil
+	}
+
+	sel.LBracePos = p.scanner.Position
+	if !p.accept('{') {
+		return nil
+	}
+
+	patterns := make(map[string]bool)
+	hasDefault := false
+	for p.tok == scanner.String || (p.tok == scanner.Ident && p.scanner.TokenText() == "default") {
+		c := &SelectCase{}
+		if p.tok == scanner.String {
+			c.Pattern = p.parseStringValue()
+			if c.Pattern == nil {
+				return nil
+			}
+			if patterns[c.Pattern.Value] {
+				p.errorf("duplicate select case %q", c.Pattern.Value)
+			}
+			patterns[c.Pattern.Value] = true
+		} else {
+			if hasDefault {
+				p.errorf("duplicate select default case")
+			}
+			hasDefault = true
+			c.DefaultPos = p.scanner.Position
+			p.accept(scanner.Ident)
+		}
+
+		c.ColonPos = p.scanner.Position
+		if !p.accept(':') {
+			return nil
+		}
+		c.Value = p.parseExpression()
+		if c.Value == nil {
+			return nil
+		}
+		sel.Cases = append(sel.Cases, c)
+
+		if p.tok != ',' {
+			break
+		}
+		p.accept(',')
+	}
+
+	sel.RBracePos = p.scanner.Position
+	if !p.accept('}') {
+		return nil
+	}
+	sel.RParenPos = p.scanner.Position
+	if !p.accept(')') {
+		return nil
+	}
+
+	if len(sel.Cases) == 0 {
+		p.errorf("select must have at least one case")
+		return nil
+	}
+
+	if p.eval {
+		typ := sel.Cases[0].Value.Type()
+		for _, c := range sel.Cases[1:] {
+			if c.Value.Type() != typ {
+				p.errorAt(c.Value.Pos(), fmt.Errorf("mismatched types in select cases: %s != %s",
+					typ, c.Value.Type()))
+				return nil
+			}
+		}
+
+		value, set := "", false
+		if p.scope.selectEvaluator != nil {
+			value, set = p.scope.selectEvaluator.SelectValue(sel.Condition.Value)
+		}
+		var defaultCase *SelectCase
+		for _, c := range sel.Cases {
+			if c.Pattern == nil {
+				defaultCase = c
+			} else if set && c.Pattern.Value == value {
+				sel.Value = c.Value
+				break
+			}
+		}
+		if sel.Value == nil {
+			if defaultCase == nil {
+				if set {
+					p.errorAt(sel.KeywordPos, fmt.Errorf("no select case matches %q value %q",
+						sel.Condition.Value, value))
+				} else {
+					p.errorAt(sel.KeywordPos, fmt.Errorf("select condition %q is not set and there is no default case",
+						sel.Condition.Value))
+				}
+				return nil
+			}
+			sel.Value = defaultCase.Value
+		}
+	} else {
+		sel.Value = &NotEvaluated{}
+	}
+
+	return sel
+}
+
+func (p *parser) parseCall() Expression {
+	call := &Call{
+		Name:    p.scanner.TokenText(),
+		NamePos: p.scanner.Position,
+	}
+	p.accept(scanner.Ident)
+	call.LParenPos = p.scanner.Position
+	if !p.accept('(') {
+		return nil
+	}
+
+	for p.tok != ')' {
+		arg := p.parseExpression()
+		if arg == nil {
+			return nil
+		}
+		call.Args = append(call.Args, arg)
+
+		if p.tok != ',' {
+			break
+		}
+		p.accept(',')
+	}
+
+	call.RParenPos = p.scanner.Position
+	if !p.accept(')') {
+		return nil
+	}
+
+	if !p.eval {
+		call.Value = &NotEvaluated{}
+		return call
+	}
+
+	builtin, ok := builtinFunctions[call.Name]
+	if !ok {
+		p.errorAt(call.NamePos, fmt.Errorf("unknown function %q", call.Name))
+		return nil
+	}
+	if len(call.Args) != builtin.nargs {
+		p.errorAt(call.NamePos, fmt.Errorf("%s() expects %d arguments, found %d",
+			call.Name, builtin.nargs, len(call.Args)))
+		return nil
+	}
+
+	value, err := builtin.call(call, call.Args)
+	if err != nil {
+		if parseErr, ok := err.(*ParseError); ok {
+			p.errorAt(parseErr.Pos, parseErr.Err)
+		} else {
+			p.errorAt(call.NamePos, fmt.Errorf("%s(): %s", call.Name, err))
+		}
+		return nil
+	}
+	call.Value = value
+
+	return call
+}
+
 func (p *parser) parseStringValue() *String {
 	str, err := strconv.Unquote(p.scanner.TokenText())
 	if err != nil {
@@ -587,9 +873,40 @@ func (p *parser) parseMapValue() *Map {
 	}
 }
 
+// A SelectEvaluator provides the values of the conditions used by select expressions.
+type SelectEvaluator interface {
+	// SelectValue returns the value of the named condition, or false if the condition is not set.
+	SelectValue(condition string) (string, bool)
+}
+
+// An IncludeResolver reads the files included by include directives when parsing with
+// ParseAndEval.
+type IncludeResolver interface {
+	// ResolveInclude returns the name and the contents of the file included by an include
+	// directive with the given path in the file with the name from.  The returned name is used
+	// for errors and to detect include cycles.
+	ResolveInclude(from, path string) (name string, contents []byte, err error)
+}
+
 type Scope struct {
-	vars          map[string]*Assignment
-	inheritedVars map[string]*Assignment
+	vars            map[string]*Assignment
+	inheritedVars   map[string]*Assignment
+	selectEvaluator SelectEvaluator
+	includeResolver IncludeResolver
+}
+
+// SetSelectEvaluator sets the SelectEvaluator used to evaluate select expressions when parsing
+// with ParseAndEval.  It is inherited by scopes created from this one with NewScope.  If no
+// SelectEvaluator is set every condition is treated as unset.
+func (s *Scope) SetSelectEvaluator(e SelectEvaluator) {
+	s.selectEvaluator = e
+}
+
+// SetIncludeResolver sets the IncludeResolver used to read the files included by include
+// directives when parsing with ParseAndEval.  It is inherited by scopes created from this one with
+// NewScope.  If no IncludeResolver is set include directives are errors.
+func (s *Scope) SetIncludeResolver(r IncludeResolver) {
+	s.includeResolver = r
 }
 
 func NewScope(s *Scope) *Scope {
@@ -605,6 +922,8 @@ func NewScope(s *Scope) *Scope {
 		for k, v := range s.inheritedVars {
 			newScope.inheritedVars[k] = v
 		}
+		newScope.selectEvaluator = s.selectEvaluator
+		newScope.includeResolver = s.includeResolver
 	}
 
 	return newScope
@@ -641,6 +960,19 @@ func (s *Scope) Get(name string) (*Assignment, bool) {
 	return nil, false
 }
 
+// Names returns the names of all the variables in the scope, including inherited ones, in an
+// unspecified order.
+func (s *Scope) Names() []string {
+	names := make([]string, 0, len(s.vars)+len(s.inheritedVars))
+	for k := range s.vars {
+		names = append(names, k)
+	}
+	for k := range s.inheritedVars {
+		names = append(names, k)
+	}
+	return names
+}
+
 func (s *Scope) String() string {
 	vars := []string{}
 
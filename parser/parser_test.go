@@ -16,6 +16,7 @@ package parser
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
@@ -1295,3 +1296,278 @@ func TestParserNotEvaluated(t *testing.T) {
 		t.Errorf("Attempt to print FOO returned %s", s)
 	}
 }
+
+func TestParseQuotedMapKeys(t *testing.T) {
+	input := `
+		foo {
+			stuff: {
+				"with-dash": "x",
+				nested: {
+					"1st": ["y"],
+				},
+			},
+		}
+	`
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	stuff, _ := file.Defs[0].(*Module).GetProperty("stuff")
+	m := stuff.Value.(*Map)
+	if p, found := m.GetProperty("with-dash"); !found {
+		t.Errorf("missing property with-dash")
+	} else if p.NamePos.Column != 5 {
+		t.Errorf("expected with-dash at column 5, got %s", p.NamePos)
+	}
+	nested, _ := m.GetProperty("nested")
+	if _, found := nested.Value.(*Map).GetProperty("1st"); !found {
+		t.Errorf("missing property 1st")
+	}
+
+	// Module properties must still be identifiers.
+	_, errs = ParseAndEval("", bytes.NewBufferString(`foo { "name": "x" }`), NewScope(nil))
+	if len(errs) == 0 {
+		t.Errorf("expected error for quoted module property name")
+	}
+}
+
+type testSelectEvaluator map[string]string
+
+func (e testSelectEvaluator) SelectValue(condition string) (string, bool) {
+	value, ok := e[condition]
+	return value, ok
+}
+
+func TestParseSelect(t *testing.T) {
+	input := `
+		srcs = ["common.c"] + select("arch", {
+			"arm": ["arm.c"],
+			"x86": ["x86.c"],
+			default: [],
+		})
+		foo {
+			srcs: srcs,
+			enabled: select("os", {
+				"linux": true,
+				default: false,
+			}),
+		}
+	`
+
+	testCases := []struct {
+		evaluator testSelectEvaluator
+		srcs      []string
+		enabled   bool
+	}{
+		{nil, []string{"common.c"}, false},
+		{testSelectEvaluator{"arch": "arm", "os": "linux"}, []string{"common.c", "arm.c"}, true},
+		{testSelectEvaluator{"arch": "x86", "os": "darwin"}, []string{"common.c", "x86.c"}, false},
+		{testSelectEvaluator{"arch": "riscv"}, []string{"common.c"}, false},
+	}
+
+	for _, testCase := range testCases {
+		scope := NewScope(nil)
+		if testCase.evaluator != nil {
+			scope.SetSelectEvaluator(testCase.evaluator)
+		}
+		file, errs := ParseAndEval("", bytes.NewBufferString(input), scope)
+		if len(errs) > 0 {
+			t.Fatalf("%v: unexpected errors: %v", testCase.evaluator, errs)
+		}
+
+		module := file.Defs[1].(*Module)
+		srcs, _ := module.GetProperty("srcs")
+		var gotSrcs []string
+		for _, v := range srcs.Value.Eval().(*List).Values {
+			gotSrcs = append(gotSrcs, v.(*String).Value)
+		}
+		if !reflect.DeepEqual(gotSrcs, testCase.srcs) {
+			t.Errorf("%v: want srcs %q, got %q", testCase.evaluator, testCase.srcs, gotSrcs)
+		}
+
+		enabled, _ := module.GetProperty("enabled")
+		if got := enabled.Value.Eval().(*Bool).Value; got != testCase.enabled {
+			t.Errorf("%v: want enabled %v, got %v", testCase.evaluator, testCase.enabled, got)
+		}
+	}
+}
+
+func TestSelectCopy(t *testing.T) {
+	scope := NewScope(nil)
+	scope.SetSelectEvaluator(testSelectEvaluator{"arch": "arm"})
+	file, errs := ParseAndEval("", bytes.NewBufferString(`
+		srcs = select("arch", {
+			"arm": ["arm.c"],
+			default: [],
+		})
+	`), scope)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	sel := file.Defs[0].(*Assignment).Value.(*Select)
+	selCopy := sel.Copy().(*Select)
+	if selCopy.Value != selCopy.Cases[0].Value {
+		t.Errorf("expected the value of the copy to be its copy of the matching case")
+	}
+
+	selCopy.Value.(*List).Values[0].(*String).Value = "changed.c"
+	if got := sel.Value.(*List).Values[0].(*String).Value; got != "arm.c" {
+		t.Errorf("expected changing the copy not to change the original, got %q", got)
+	}
+}
+
+func TestParseSelectErrors(t *testing.T) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{
+			input: `foo { a: select("arch", { "arm": "x" }) }`,
+			err:   `<input>:1:10: no select case matches "arch" value "x86"`,
+		},
+		{
+			input: `foo { a: select("os", { "linux": "x" }) }`,
+			err:   `<input>:1:10: select condition "os" is not set and there is no default case`,
+		},
+		{
+			input: `foo { a: select("arch", { "arm": "x", default: ["y"] }) }`,
+			err:   `<input>:1:48: mismatched types in select cases: string != list`,
+		},
+		{
+			input: `foo { a: select("arch", { "arm": "x", "arm": "y" }) }`,
+			err:   `<input>:1:44: duplicate select case "arm"`,
+		},
+		{
+			input: `foo { a: select("arch", { default: "x", default: "y" }) }`,
+			err:   `<input>:1:41: duplicate select default case`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		scope := NewScope(nil)
+		scope.SetSelectEvaluator(testSelectEvaluator{"arch": "x86"})
+		_, errs := ParseAndEval("<input>", bytes.NewBufferString(testCase.input), scope)
+		if len(errs) == 0 {
+			t.Errorf("%s: expected error %q", testCase.input, testCase.err)
+			continue
+		}
+		if errs[0].Error() != testCase.err {
+			t.Errorf("%s: want error %q, got %q", testCase.input, testCase.err, errs[0].Error())
+		}
+	}
+}
+
+// testIncludeResolver resolves included paths to files in a map, ignoring the including file.
+type testIncludeResolver map[string]string
+
+func (r testIncludeResolver) ResolveInclude(from, path string) (string, []byte, error) {
+	contents, ok := r[path]
+	if !ok {
+		return "", nil, fmt.Errorf("file not found")
+	}
+	return path, []byte(contents), nil
+}
+
+func TestParseInclude(t *testing.T) {
+	resolver := testIncludeResolver{
+		"defs.bpi": `
+			cflags = ["-Wall"]
+			include "more.bpi"
+		`,
+		"more.bpi": `
+			name = "foo"
+		`,
+	}
+
+	input := `
+		include "defs.bpi"
+		cflags += ["-O2"]
+
+		foo {
+			name: name,
+			cflags: cflags,
+		}
+	`
+
+	scope := NewScope(nil)
+	scope.SetIncludeResolver(resolver)
+	file, errs := ParseAndEval("Blueprints", bytes.NewBufferString(input), scope)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if include, ok := file.Defs[0].(*Include); !ok || include.Path.Value != "defs.bpi" {
+		t.Errorf("expected an include of defs.bpi, got %s", file.Defs[0])
+	}
+	if want := []string{"defs.bpi", "more.bpi"}; !reflect.DeepEqual(file.Includes, want) {
+		t.Errorf("want includes %q, got %q", want, file.Includes)
+	}
+
+	module := file.Defs[2].(*Module)
+	name, _ := module.GetProperty("name")
+	if got := name.Value.Eval().(*String).Value; got != "foo" {
+		t.Errorf("want name %q, got %q", "foo", got)
+	}
+	cflags, _ := module.GetProperty("cflags")
+	var gotCflags []string
+	for _, v := range cflags.Value.Eval().(*List).Values {
+		gotCflags = append(gotCflags, v.(*String).Value)
+	}
+	if want := []string{"-Wall", "-O2"}; !reflect.DeepEqual(gotCflags, want) {
+		t.Errorf("want cflags %q, got %q", want, gotCflags)
+	}
+}
+
+func TestParseIncludeErrors(t *testing.T) {
+	resolver := testIncludeResolver{
+		"a.bpi":      `include "b.bpi"`,
+		"b.bpi":      `include "a.bpi"`,
+		"module.bpi": "x = 1\nfoo {}",
+		"broken.bpi": `x = `,
+	}
+
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{
+			input: `include "a.bpi"`,
+			err:   `b.bpi:1:9: include cycle: a.bpi -> b.bpi -> a.bpi`,
+		},
+		{
+			input: `include "module.bpi"`,
+			err:   `module.bpi:2:1: module definitions are not allowed in included file module.bpi`,
+		},
+		{
+			input: `include "broken.bpi"`,
+			err:   `broken.bpi:1:5: expected bool, list, or string value; found EOF`,
+		},
+		{
+			input: `include "missing.bpi"`,
+			err:   `<input>:1:9: can't include "missing.bpi": file not found`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		scope := NewScope(nil)
+		scope.SetIncludeResolver(resolver)
+		_, errs := ParseAndEval("<input>", bytes.NewBufferString(testCase.input), scope)
+		if len(errs) == 0 {
+			t.Errorf("%s: expected error %q", testCase.input, testCase.err)
+			continue
+		}
+		if errs[0].Error() != testCase.err {
+			t.Errorf("%s: want error %q, got %q", testCase.input, testCase.err, errs[0].Error())
+		}
+	}
+
+	// Include directives are only errors when they are evaluated.
+	if _, errs := ParseAndEval("<input>", bytes.NewBufferString(`include "a.bpi"`), NewScope(nil)); len(errs) == 0 {
+		t.Errorf("expected an error for an include without an IncludeResolver")
+	}
+	if _, errs := Parse("<input>", bytes.NewBufferString(`include "a.bpi"`), NewScope(nil)); len(errs) > 0 {
+		t.Errorf("unexpected errors parsing an include without evaluating it: %v", errs)
+	}
+}
@@ -15,6 +15,8 @@
 package parser
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -89,6 +91,8 @@ func (p *printer) printDef(def Definition) {
 		p.printAssignment(assignment)
 	} else if module, ok := def.(*Module); ok {
 		p.printModule(module)
+	} else if include, ok := def.(*Include); ok {
+		p.printInclude(include)
 	} else {
 		panic("Unknown definition")
 	}
@@ -103,8 +107,16 @@ func (p *printer) printAssignment(assignment *Assignment) {
 	p.requestNewline()
 }
 
+func (p *printer) printInclude(include *Include) {
+	p.printToken("include", include.IncludePos)
+	p.requestSpace()
+	p.printExpression(include.Path)
+	p.requestNewline()
+}
+
 func (p *printer) printModule(module *Module) {
 	p.printToken(module.Type, module.TypePos)
+	p.requestSpace()
 	p.printMap(&module.Map)
 	p.requestDoubleNewline()
 }
@@ -131,13 +143,16 @@ func (p *printer) printExpression(value Expression) {
 		p.printList(v.Values, v.LBracePos, v.RBracePos)
 	case *Map:
 		p.printMap(v)
+	case *Select:
+		p.printSelect(v)
+	case *Call:
+		p.printCall(v)
 	default:
 		panic(fmt.Errorf("bad property type: %s", value.Type()))
 	}
 }
 
 func (p *printer) printList(list []Expression, pos, endPos scanner.Position) {
-	p.requestSpace()
 	p.printToken("[", pos)
 	if len(list) > 1 || pos.Line != endPos.Line {
 		p.requestNewline()
@@ -157,7 +172,6 @@ func (p *printer) printList(list []Expression, pos, endPos scanner.Position) {
 }
 
 func (p *printer) printMap(m *Map) {
-	p.requestSpace()
 	p.printToken("{", m.LBracePos)
 	if len(m.Properties) > 0 || m.LBracePos.Line != m.RBracePos.Line {
 		p.requestNewline()
@@ -172,6 +186,45 @@ func (p *printer) printMap(m *Map) {
 	p.printToken("}", m.RBracePos)
 }
 
+func (p *printer) printSelect(s *Select) {
+	p.printToken("select", s.KeywordPos)
+	p.printToken("(", noPos)
+	p.printToken(strconv.Quote(s.Condition.Value), s.Condition.LiteralPos)
+	p.printToken(",", noPos)
+	p.requestSpace()
+	p.printToken("{", s.LBracePos)
+	p.requestNewline()
+	p.indent(p.curIndent() + 4)
+	for _, c := range s.Cases {
+		if c.Pattern == nil {
+			p.printToken("default", c.DefaultPos)
+		} else {
+			p.printToken(strconv.Quote(c.Pattern.Value), c.Pattern.LiteralPos)
+		}
+		p.printToken(":", c.ColonPos)
+		p.requestSpace()
+		p.printExpression(c.Value)
+		p.printToken(",", noPos)
+		p.requestNewline()
+	}
+	p.unindent(s.RBracePos)
+	p.printToken("}", s.RBracePos)
+	p.printToken(")", s.RParenPos)
+}
+
+func (p *printer) printCall(c *Call) {
+	p.printToken(c.Name, c.NamePos)
+	p.printToken("(", c.LParenPos)
+	for i, arg := range c.Args {
+		if i > 0 {
+			p.printToken(",", noPos)
+			p.requestSpace()
+		}
+		p.printExpression(arg)
+	}
+	p.printToken(")", c.RParenPos)
+}
+
 func (p *printer) printOperator(operator *Operator) {
 	p.printOperatorInternal(operator, true)
 }
@@ -204,7 +257,11 @@ func (p *printer) printOperatorInternal(operator *Operator, allowIndent bool) {
 }
 
 func (p *printer) printProperty(property *Property) {
-	p.printToken(property.Name, property.NamePos)
+	name := property.Name
+	if !isIdentifier(name) {
+		name = strconv.Quote(name)
+	}
+	p.printToken(name, property.NamePos)
 	p.printToken(":", property.ColonPos)
 	p.requestSpace()
 	p.printExpression(property.Value)
@@ -392,3 +449,140 @@ func max(a, b int) int {
 		return b
 	}
 }
+
+// isIdentifier returns true if s can be printed as a property name without quoting it.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c))) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckFormat parses the contents of a Blueprints file and compares them to the canonical
+// formatting produced by Print, the same formatting that bpfmt applies without any flags.  It
+// returns a *ParseError for each block of lines that differs from the canonical formatting,
+// positioned at the first line of the block in contents.  If contents can't be parsed the
+// parse errors are returned instead.
+func CheckFormat(filename string, contents []byte) []error {
+	file, errs := Parse(filename, bytes.NewReader(contents), NewScope(nil))
+	if len(errs) > 0 {
+		return errs
+	}
+
+	canonical, err := Print(file)
+	if err != nil {
+		return []error{err}
+	}
+	if bytes.Equal(contents, canonical) {
+		return nil
+	}
+
+	got, want := splitLines(contents), splitLines(canonical)
+	for _, hunk := range diffLines(got, want) {
+		pos := scanner.Position{Filename: filename, Line: hunk.gotStart + 1, Column: 1}
+		if hunk.gotStart == len(got) {
+			// Lines are missing at the end of the file, point at the last line.
+			pos.Line = max(len(got), 1)
+		}
+		if hunk.wantStart < hunk.wantEnd {
+			err = fmt.Errorf("formatting differs from bpfmt, expected %q", want[hunk.wantStart])
+		} else {
+			err = fmt.Errorf("formatting differs from bpfmt, unexpected %q", got[hunk.gotStart])
+		}
+		errs = append(errs, &ParseError{Err: err, Pos: pos})
+	}
+	if len(errs) == 0 {
+		// The lines are the same, only the newline at the end of the file differs.
+		pos := scanner.Position{Filename: filename, Line: max(len(got), 1), Column: 1}
+		errs = append(errs, &ParseError{Err: errors.New("formatting differs from bpfmt, missing newline at end of file"), Pos: pos})
+	}
+	return errs
+}
+
+func splitLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\n")
+	}
+	return lines
+}
+
+// lineHunk is a block of lines got[gotStart:gotEnd] that should be replaced with
+// want[wantStart:wantEnd].
+type lineHunk struct {
+	gotStart, gotEnd   int
+	wantStart, wantEnd int
+}
+
+// maxDiffCells limits the size of the table used to find the longest common subsequence of the
+// lines that differ, beyond it all the differing lines are reported as a single hunk.
+const maxDiffCells = 1 << 20
+
+// diffLines returns the hunks that turn got into want.
+func diffLines(got, want []string) []lineHunk {
+	// Skip the common prefix and suffix, formatting changes are usually local.
+	prefix := 0
+	for prefix < len(got) && prefix < len(want) && got[prefix] == want[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(got)-prefix && suffix < len(want)-prefix &&
+		got[len(got)-1-suffix] == want[len(want)-1-suffix] {
+		suffix++
+	}
+	a, b := got[prefix:len(got)-suffix], want[prefix:len(want)-suffix]
+
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return []lineHunk{{prefix, prefix + len(a), prefix, prefix + len(b)}}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var hunks []lineHunk
+	var cur *lineHunk
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			cur = nil
+			i++
+			j++
+			continue
+		}
+		if cur == nil {
+			hunks = append(hunks, lineHunk{prefix + i, prefix + i, prefix + j, prefix + j})
+			cur = &hunks[len(hunks)-1]
+		}
+		if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+			cur.gotEnd = prefix + i
+		} else {
+			j++
+			cur.wantEnd = prefix + j
+		}
+	}
+	return hunks
+}
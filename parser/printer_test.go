@@ -16,6 +16,7 @@ package parser
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -122,6 +123,46 @@ foo {
         num: 4,
     },
 }
+`,
+	},
+	{
+		input: `
+		foo {
+			stuff: {
+				"arm64": { cflags: ["-a"] },
+				"with-dash": "x",
+				"quoted": true,
+			}
+		}
+		`,
+		output: `
+foo {
+    stuff: {
+        arm64: {
+            cflags: ["-a"],
+        },
+        "with-dash": "x",
+        quoted: true,
+    },
+}
+`,
+	},
+	{
+		input: `
+		foo {
+			configs: { debug: { cflags: ["-O0"], enabled: true }, release: {} },
+		}
+		`,
+		output: `
+foo {
+    configs: {
+        debug: {
+            cflags: ["-O0"],
+            enabled: true,
+        },
+        release: {},
+    },
+}
 `,
 	},
 	{
@@ -426,6 +467,68 @@ stuff {
         ],
     ],
 }
+`,
+	},
+	{
+		input: `
+srcs = ["common.c"] + select("arch", {"arm": ["arm.c"], default: []})
+stuff {
+    enabled: select("os", {
+        "linux": true,
+        default: false
+    }),
+}
+`,
+		output: `
+srcs = ["common.c"] + select("arch", {
+    "arm": ["arm.c"],
+    default: [],
+})
+stuff {
+    enabled: select("os", {
+        "linux": true,
+        default: false,
+    }),
+}
+`,
+	},
+	{
+		input: `
+srcs = glob_exclude(["a.c", "b_test.c"],"*_test.c")
+stuff {
+    name: basename( "x/y.c" ),
+    srcs: replace(srcs, ".c", ".cpp") + ["z.c"],
+}
+`,
+		output: `
+srcs = glob_exclude([
+    "a.c",
+    "b_test.c",
+], "*_test.c")
+stuff {
+    name: basename("x/y.c"),
+    srcs: replace(srcs, ".c", ".cpp") + ["z.c"],
+}
+`,
+	},
+	{
+		input: `
+// shared variables
+include   "build/defs.bpi"
+include "other.bpi"
+cflags += ["-O2"]
+foo {
+    cflags: cflags,
+}
+`,
+		output: `
+// shared variables
+include "build/defs.bpi"
+include "other.bpi"
+cflags += ["-O2"]
+foo {
+    cflags: cflags,
+}
 `,
 	},
 }
@@ -462,3 +565,84 @@ func TestPrinter(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckFormat(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name: "formatted",
+			input: `
+foo {
+    name: "a",
+}
+`,
+		},
+		{
+			name: "indentation and spacing",
+			input: `
+foo {
+  name: "a",
+}
+
+bar {
+    name: "b",
+    srcs: ["b.c"  ],
+}
+`,
+			want: []string{
+				`Blueprints:2:1: formatting differs from bpfmt, expected "    name: \"a\","`,
+				`Blueprints:7:1: formatting differs from bpfmt, expected "    srcs: [\"b.c\"],"`,
+			},
+		},
+		{
+			name: "extra blank lines",
+			input: `
+foo {
+    name: "a",
+}
+
+
+bar {
+    name: "b",
+}
+`,
+			want: []string{
+				`Blueprints:5:1: formatting differs from bpfmt, unexpected ""`,
+			},
+		},
+		{
+			name: "missing newline",
+			input: `
+foo {
+    name: "a",
+}`,
+			want: []string{
+				`Blueprints:3:1: formatting differs from bpfmt, missing newline at end of file`,
+			},
+		},
+		{
+			name: "parse error",
+			input: `
+foo {
+`,
+			want: []string{
+				`Blueprints:2:1: expected "}", found EOF`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var got []string
+			for _, err := range CheckFormat("Blueprints", []byte(testCase.input[1:])) {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("incorrect errors\nwant: %q\n got: %q", testCase.want, got)
+			}
+		})
+	}
+}
@@ -183,6 +183,10 @@ func sortListsInValue(value Expression, file *File) {
 		for _, p := range v.Properties {
 			sortListsInValue(p.Value, file)
 		}
+	case *Select:
+		for _, c := range v.Cases {
+			sortListsInValue(c.Value, file)
+		}
 	case *List:
 		SortList(file, v)
 	}
@@ -122,6 +122,87 @@ type FileSystem interface {
 	Readlink(name string) (string, error)
 }
 
+// IgnoreSymlinkLoops returns a FileSystem that behaves like fs, except that when following
+// symlinks while listing directories recursively it silently skips symlinks that lead back to a
+// directory that is already being listed, instead of returning a SymlinkLoopError.
+func IgnoreSymlinkLoops(fs FileSystem) FileSystem {
+	if _, ok := fs.(ignoreSymlinkLoopsFs); ok {
+		return fs
+	}
+	return ignoreSymlinkLoopsFs{fs}
+}
+
+// ignoreSymlinkLoopsFs wraps a FileSystem to skip symlink loops, see IgnoreSymlinkLoops.  It
+// passes itself to startGlob and listDirsRecursive, which check for it with a type assertion.
+type ignoreSymlinkLoopsFs struct {
+	FileSystem
+}
+
+func (fs ignoreSymlinkLoopsFs) Glob(pattern string, excludes []string, follow ShouldFollowSymlinks) (GlobResult, error) {
+	return startGlob(fs, pattern, excludes, follow)
+}
+
+func (fs ignoreSymlinkLoopsFs) ListDirsRecursive(name string, follow ShouldFollowSymlinks) ([]string, error) {
+	return listDirsRecursive(fs, name, follow)
+}
+
+// A SymlinkLoopError is returned when following symlinks leads back to a path that is already
+// being followed.
+type SymlinkLoopError struct {
+	// Path lists the paths that form the loop, in the order they were followed.  The first and
+	// last entries are the same path.
+	Path []string
+}
+
+func (e *SymlinkLoopError) Error() string {
+	return fmt.Sprintf("symlink loop: %s", strings.Join(e.Path, " -> "))
+}
+
+// EvalSymlinks returns the path name after the evaluation of any symlinks in it, like
+// filepath.EvalSymlinks, using fs.  It returns a *SymlinkLoopError if the symlinks form a loop.
+func EvalSymlinks(fs FileSystem, path string) (string, error) {
+	return evalSymlinks(fs, filepath.Clean(path), nil)
+}
+
+// evalSymlinks resolves the directory of path and then path itself.  links is the list of
+// symlinks that are being followed to reach path, and is used to detect loops.
+func evalSymlinks(fs FileSystem, path string, links []string) (string, error) {
+	dir, file := saneSplit(path)
+	if dir != "." && dir != "/" {
+		var err error
+		dir, err = evalSymlinks(fs, dir, links)
+		if err != nil {
+			return "", err
+		}
+	}
+	path = filepath.Join(dir, file)
+
+	info, err := fs.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+
+	for i, link := range links {
+		if link == path {
+			loop := append([]string(nil), links[i:]...)
+			return "", &SymlinkLoopError{Path: append(loop, path)}
+		}
+	}
+	links = append(links[:len(links):len(links)], path)
+
+	to, err := fs.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(to) {
+		to = filepath.Join(dir, to)
+	}
+	return evalSymlinks(fs, filepath.Clean(to), links)
+}
+
 // osFs implements FileSystem using the local disk.
 type osFs struct {
 	srcDir string
@@ -510,7 +591,16 @@ func listDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks)
 
 	dirs := []string{name}
 
-	subDirs, err := listDirsRecursiveRelative(fs, name, follow, 0)
+	var ancestors []listDirsAncestor
+	if follow == FollowSymlinks {
+		realName, err := EvalSymlinks(fs, name)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = []listDirsAncestor{{name, realName}}
+	}
+
+	subDirs, err := listDirsRecursiveRelative(fs, name, follow, ancestors, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -522,7 +612,34 @@ func listDirsRecursive(fs FileSystem, name string, follow ShouldFollowSymlinks)
 	return dirs, nil
 }
 
-func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSymlinks, depth int) ([]string, error) {
+// listDirsAncestor is a directory that is being listed by listDirsRecursive when following
+// symlinks, along with its path after evaluating symlinks.
+type listDirsAncestor struct {
+	path, realPath string
+}
+
+// symlinkLoop returns a *SymlinkLoopError if the symlink dir points to one of the directories
+// that are currently being listed.
+func symlinkLoop(fs FileSystem, dir string, ancestors []listDirsAncestor) error {
+	realDir, err := EvalSymlinks(fs, dir)
+	if err != nil {
+		return err
+	}
+	for i, ancestor := range ancestors {
+		if ancestor.realPath == realDir {
+			var loop []string
+			for _, a := range ancestors[i:] {
+				loop = append(loop, a.path)
+			}
+			return &SymlinkLoopError{Path: append(loop, dir, ancestor.path)}
+		}
+	}
+	return nil
+}
+
+func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSymlinks,
+	ancestors []listDirsAncestor, depth int) ([]string, error) {
+
 	depth++
 	if depth > 255 {
 		return nil, fmt.Errorf("too many symlinks")
@@ -554,8 +671,28 @@ func listDirsRecursiveRelative(fs FileSystem, name string, follow ShouldFollowSy
 			}
 		}
 		if info.IsDir() {
+			var subAncestors []listDirsAncestor
+			if follow == FollowSymlinks {
+				realPath := filepath.Join(ancestors[len(ancestors)-1].realPath, filepath.Base(f))
+				if isSymlink, _ := fs.IsSymlink(f); isSymlink {
+					if err := symlinkLoop(fs, f, ancestors); err != nil {
+						_, isLoop := err.(*SymlinkLoopError)
+						if _, ignoreLoops := fs.(ignoreSymlinkLoopsFs); isLoop && ignoreLoops {
+							continue
+						}
+						return nil, err
+					}
+					realPath, err = EvalSymlinks(fs, f)
+					if err != nil {
+						return nil, err
+					}
+				}
+				subAncestors = append(ancestors[:len(ancestors):len(ancestors)],
+					listDirsAncestor{f, realPath})
+			}
+
 			dirs = append(dirs, f)
-			subDirs, err := listDirsRecursiveRelative(fs, f, follow, depth)
+			subDirs, err := listDirsRecursiveRelative(fs, f, follow, subAncestors, depth)
 			if err != nil {
 				return nil, err
 			}
@@ -291,6 +291,80 @@ func TestFs_ListDirsRecursiveDontFollowSymlinks(t *testing.T) {
 	})
 }
 
+func symlinkLoopMockFs() FileSystem {
+	return MockFs(map[string][]byte{
+		"loop/a/f":        nil,
+		"loop/a/up -> ..": nil,
+		"x -> y":          nil,
+		"y -> x":          nil,
+	})
+}
+
+func TestMockFs_ListDirsRecursiveSymlinkLoop(t *testing.T) {
+	fs := symlinkLoopMockFs()
+
+	_, err := fs.ListDirsRecursive("loop", FollowSymlinks)
+	loopErr, ok := err.(*SymlinkLoopError)
+	if !ok {
+		t.Fatalf("expected a *SymlinkLoopError, got %#v", err)
+	}
+	wantLoop := []string{"loop", "loop/a", "loop/a/up", "loop"}
+	if !reflect.DeepEqual(loopErr.Path, wantLoop) {
+		t.Errorf("incorrect loop\nwant: %q\n got: %q", wantLoop, loopErr.Path)
+	}
+	if want := "symlink loop: loop -> loop/a -> loop/a/up -> loop"; err.Error() != want {
+		t.Errorf("incorrect error\nwant: %q\n got: %q", want, err.Error())
+	}
+
+	got, err := IgnoreSymlinkLoops(fs).ListDirsRecursive("loop", FollowSymlinks)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"loop", "loop/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got %v", want, got)
+	}
+
+	if _, err := fs.Glob("loop/**/f", nil, FollowSymlinks); err == nil {
+		t.Errorf("expected an error globbing through a symlink loop")
+	}
+	result, err := IgnoreSymlinkLoops(fs).Glob("loop/**/f", nil, FollowSymlinks)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"loop/a/f"}; !reflect.DeepEqual(result.Matches, want) {
+		t.Errorf("want matches: %v, got %v", want, result.Matches)
+	}
+
+	got, err = fs.ListDirsRecursive("loop", DontFollowSymlinks)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"loop", "loop/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got %v", want, got)
+	}
+}
+
+func TestEvalSymlinks(t *testing.T) {
+	fs := symlinkLoopMockFs()
+
+	got, err := EvalSymlinks(fs, "loop/a/up")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != "loop" {
+		t.Errorf("want: %q, got %q", "loop", got)
+	}
+
+	_, err = EvalSymlinks(fs, "x")
+	loopErr, ok := err.(*SymlinkLoopError)
+	if !ok {
+		t.Fatalf("expected a *SymlinkLoopError, got %#v", err)
+	}
+	if want := []string{"x", "y", "x"}; !reflect.DeepEqual(loopErr.Path, want) {
+		t.Errorf("incorrect loop\nwant: %q\n got: %q", want, loopErr.Path)
+	}
+}
+
 func TestFs_Readlink(t *testing.T) {
 	testCases := []struct {
 		from, to string
@@ -28,11 +28,12 @@ import (
 // has changed but soong_build hasn't had a chance to rerun yet to update build-globs.ninja.
 // Increment it manually when changing the bpglob argument format.  It is located here because
 // pathtools is the only package that is shared between bpglob and bootstrap.
-const BPGlobArgumentVersion = 2
+const BPGlobArgumentVersion = 3
 
 var GlobMultipleRecursiveErr = errors.New("pattern contains multiple '**'")
 var GlobLastRecursiveErr = errors.New("pattern has '**' as last path element")
 var GlobInvalidRecursiveErr = errors.New("pattern contains other characters between '**' and path separator")
+var GlobUnbalancedBraceErr = errors.New("pattern contains unbalanced '{' or '}'")
 
 // GlobResult is a container holding the results of a call to Glob.
 type GlobResult struct {
@@ -83,8 +84,15 @@ func (results MultipleGlobResults) Deps() []string {
 // directories and other dependencies that were searched to construct the file
 // list.  The supported glob and exclude patterns are equivalent to
 // filepath.Glob, with an extension that recursive glob (** matching zero or
-// more complete path entries) is supported. Any directories in the matches
-// list will have a '/' suffix.
+// more complete path entries) is supported, and that brace expressions
+// ({a,b,c}) are expanded into one pattern per alternative before globbing.
+// Any directories in the matches list will have a '/' suffix.
+//
+// The excludes are applied in order.  An exclude that starts with '!' is a
+// negative pattern that restores matches removed by an earlier exclude, so
+// that excludes of ["**/*_test.go", "!foo/*_test.go"] remove all tests except
+// the ones in foo.  A leading '!' can be escaped as '\!' to exclude names
+// that start with '!'.
 //
 // In general ModuleContext.GlobWithDeps or SingletonContext.GlobWithDeps
 // should be used instead, as they will automatically set up dependencies
@@ -96,32 +104,49 @@ func Glob(pattern string, excludes []string, follow ShouldFollowSymlinks) (GlobR
 func startGlob(fs FileSystem, pattern string, excludes []string,
 	follow ShouldFollowSymlinks) (GlobResult, error) {
 
-	if filepath.Base(pattern) == "**" {
-		return GlobResult{}, GlobLastRecursiveErr
-	}
-
-	matches, deps, err := glob(fs, pattern, false, follow)
-
+	patterns, err := expandBraces(pattern)
 	if err != nil {
 		return GlobResult{}, err
 	}
 
-	matches, err = filterExcludes(matches, excludes)
-	if err != nil {
-		return GlobResult{}, err
+	var matches, deps []string
+	for _, pattern := range patterns {
+		if filepath.Base(pattern) == "**" {
+			return GlobResult{}, GlobLastRecursiveErr
+		}
+
+		patternMatches, patternDeps, err := glob(fs, pattern, false, follow)
+		if err != nil {
+			return GlobResult{}, err
+		}
+
+		patternMatches, err = filterExcludes(patternMatches, excludes)
+		if err != nil {
+			return GlobResult{}, err
+		}
+
+		// If the pattern has wildcards, we added dependencies on the
+		// containing directories to know about changes.
+		//
+		// If the pattern didn't have wildcards, and didn't find matches, the
+		// most specific found directories were added.
+		//
+		// But if it didn't have wildcards, and did find a match, no
+		// dependencies were added, so add the match itself to detect when it
+		// is removed.
+		if !isWild(pattern) {
+			patternDeps = append(patternDeps, patternMatches...)
+		}
+
+		matches = append(matches, patternMatches...)
+		deps = append(deps, patternDeps...)
 	}
 
-	// If the pattern has wildcards, we added dependencies on the
-	// containing directories to know about changes.
-	//
-	// If the pattern didn't have wildcards, and didn't find matches, the
-	// most specific found directories were added.
-	//
-	// But if it didn't have wildcards, and did find a match, no
-	// dependencies were added, so add the match itself to detect when it
-	// is removed.
-	if !isWild(pattern) {
-		deps = append(deps, matches...)
+	if len(patterns) > 1 {
+		// The alternatives of a brace expression may overlap, and usually search the same
+		// directories.
+		matches = firstUnique(matches)
+		deps = firstUnique(deps)
 	}
 
 	for i, match := range matches {
@@ -252,26 +277,108 @@ func isWild(pattern string) bool {
 	return strings.ContainsAny(pattern, "*?[")
 }
 
+// expandBraces returns the patterns produced by expanding the brace expressions in pattern, for
+// example "a/{b,c}/*.{h,cpp}" expands to "a/b/*.h", "a/b/*.cpp", "a/c/*.h" and "a/c/*.cpp".  Brace
+// expressions may be nested, and braces and commas escaped with '\' are not expanded.
+func expandBraces(pattern string) ([]string, error) {
+	start := -1
+	depth := 0
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+				commas = commas[:0]
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				return nil, GlobUnbalancedBraceErr
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			// Expand the rest of the pattern once, and then each of the alternatives, which
+			// may contain nested brace expressions.
+			suffixes, err := expandBraces(suffix)
+			if err != nil {
+				return nil, err
+			}
+			var ret []string
+			prev := start
+			for _, end := range append(commas, i) {
+				alternatives, err := expandBraces(pattern[prev+1 : end])
+				if err != nil {
+					return nil, err
+				}
+				for _, alternative := range alternatives {
+					for _, s := range suffixes {
+						ret = append(ret, prefix+alternative+s)
+					}
+				}
+				prev = end
+			}
+			return ret, nil
+		}
+	}
+
+	if depth != 0 {
+		return nil, GlobUnbalancedBraceErr
+	}
+	return []string{pattern}, nil
+}
+
+// firstUnique returns the elements of list with duplicates removed, keeping the first occurrence
+// of each.
+func firstUnique(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	ret := list[:0]
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
 // Filters the strings in matches based on the glob patterns in excludes.  Hierarchical (a/*) and
-// recursive (**) glob patterns are supported.
+// recursive (**) glob patterns are supported.  Excludes are applied in order, and an exclude that
+// starts with '!' restores the matches that were removed by the excludes before it.
 func filterExcludes(matches []string, excludes []string) ([]string, error) {
 	if len(excludes) == 0 {
 		return matches, nil
 	}
 
 	var ret []string
-matchLoop:
 	for _, m := range matches {
+		excluded := false
 		for _, e := range excludes {
-			exclude, err := Match(e, m)
+			negated := strings.HasPrefix(e, "!")
+			if negated {
+				e = e[1:]
+			}
+			match, err := Match(e, m)
 			if err != nil {
 				return nil, err
 			}
-			if exclude {
-				continue matchLoop
+			if match {
+				excluded = !negated
 			}
 		}
-		ret = append(ret, m)
+		if !excluded {
+			ret = append(ret, m)
+		}
 	}
 
 	return ret, nil
@@ -293,8 +400,22 @@ func filterDotFiles(matches []string) []string {
 }
 
 // Match returns true if name matches pattern using the same rules as filepath.Match, but supporting
-// recursive globs (**).
+// recursive globs (**) and brace expressions ({a,b}).
 func Match(pattern, name string) (bool, error) {
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range patterns {
+		if match, err := matchPattern(pattern, name); err != nil || match {
+			return match, err
+		}
+	}
+	return false, nil
+}
+
+// matchPattern returns true if name matches pattern, which must not contain brace expressions.
+func matchPattern(pattern, name string) (bool, error) {
 	if filepath.Base(pattern) == "**" {
 		return false, GlobLastRecursiveErr
 	}
@@ -352,12 +473,12 @@ func Match(pattern, name string) (bool, error) {
 	}
 }
 
-// IsGlob returns true if the pattern contains any glob characters (*, ?, or [).
+// IsGlob returns true if the pattern contains any glob characters (*, ?, [, or {).
 func IsGlob(pattern string) bool {
-	return strings.IndexAny(pattern, "*?[") >= 0
+	return strings.IndexAny(pattern, "*?[{") >= 0
 }
 
-// HasGlob returns true if any string in the list contains any glob characters (*, ?, or [).
+// HasGlob returns true if any string in the list contains any glob characters (*, ?, [, or {).
 func HasGlob(in []string) bool {
 	for _, s := range in {
 		if IsGlob(s) {
@@ -426,6 +547,8 @@ var matchEscaper = strings.NewReplacer(
 	`?`, `\?`,
 	`[`, `\[`,
 	`]`, `\]`,
+	`{`, `\{`,
+	`}`, `\}`,
 )
 
 // MatchEscape returns its inputs with characters that would be interpreted by
@@ -469,6 +469,55 @@ var globTestCases = []globTestCase{
 		matches: []string{".test/", ".testing"},
 		deps:    []string{"."},
 	},
+
+	// brace tests
+	{
+		pattern: "{a,b}/*",
+		matches: []string{"a/a/", "a/b/", "b/a"},
+		deps:    []string{"a", "b"},
+	},
+	{
+		pattern: "*.{ext,foo}",
+		matches: []string{"d.ext", "e.ext"},
+		deps:    []string{"."},
+	},
+	{
+		pattern: "c/{f,g}/*.ext",
+		matches: []string{"c/f/f.ext", "c/g/g.ext"},
+		deps:    []string{"c/f", "c/g"},
+	},
+	{
+		pattern: "{a/{a,b},c/h}/*",
+		matches: []string{"a/a/a", "a/b/b", "c/h/h"},
+		deps:    []string{"a/a", "a/b", "c/h"},
+	},
+	{
+		pattern: "{*,d}.ext",
+		matches: []string{"d.ext", "e.ext"},
+		deps:    []string{".", "d.ext"},
+	},
+	{
+		pattern: "{a,b/*",
+		err:     GlobUnbalancedBraceErr,
+	},
+	{
+		pattern: "a}/*",
+		err:     GlobUnbalancedBraceErr,
+	},
+
+	// negative exclude tests
+	{
+		pattern:  "*/*",
+		excludes: []string{"*/a", "!b/a"},
+		matches:  []string{"a/b/", "b/a", "c/c", "c/f/", "c/g/", "c/h/"},
+		deps:     []string{".", "a", "b", "c"},
+	},
+	{
+		pattern:  "**/*.ext",
+		excludes: []string{"**/*", "!c/**/*", "c/g/*"},
+		matches:  []string{"c/f/f.ext"},
+		deps:     []string{".", "a", "a/a", "a/b", "b", "c", "c/f", "c/g", "c/h"},
+	},
 }
 
 func TestMockGlob(t *testing.T) {
@@ -871,6 +920,13 @@ func TestMatch(t *testing.T) {
 		{"/**/*/", "/a/b/", true},
 		{"/**/*/", "/a/b/c", false},
 
+		{`a/{b,c}`, `a/c`, true},
+		{`a/{b,c}`, `a/d`, false},
+		{`{a,b}/**/*.{c,h}`, `b/x/y.h`, true},
+		{`{a,b}/**/*.{c,h}`, `c/x/y.h`, false},
+		{`a/\{b,c\}`, `a/{b,c}`, true},
+		{`a/\{b,c\}`, `a/b`, false},
+
 		{`a`, `/a`, false},
 		{`/a`, `a`, false},
 		{`*`, `/a`, false},
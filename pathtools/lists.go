@@ -15,6 +15,7 @@
 package pathtools
 
 import (
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -47,3 +48,10 @@ func ReplaceExtension(path string, extension string) string {
 	}
 	return path + "." + extension
 }
+
+// NormalizePath returns p as a clean slash-separated path in the path.Clean sense, treating both
+// '/' and '\' as separators regardless of the host platform, so that paths built on Windows and
+// on other platforms compare equal.  Like path.Clean it returns "." for an empty path.
+func NormalizePath(p string) string {
+	return path.Clean(strings.ReplaceAll(p, `\`, "/"))
+}
@@ -39,3 +39,29 @@ func TestLists_ReplaceExtension(t *testing.T) {
 		})
 	}
 }
+
+func TestLists_NormalizePath(t *testing.T) {
+	testCases := []struct {
+		from, to string
+	}{
+		{"", "."},
+		{"a/b/c", "a/b/c"},
+		{`a\b\c`, "a/b/c"},
+		{`a\b/c\`, "a/b/c"},
+		{`.\a\..\b\.\c`, "b/c"},
+		{`a\\b`, "a/b"},
+		{`..\a`, "../a"},
+		{`C:\src\a.c`, "C:/src/a.c"},
+		{`\\server\share`, "/server/share"},
+		{"/out//a/", "/out/a"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.from, func(t *testing.T) {
+			got := NormalizePath(test.from)
+			if got != test.to {
+				t.Errorf("NormalizePath(%q) = %q; want: %q", test.from, got, test.to)
+			}
+		})
+	}
+}
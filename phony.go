@@ -0,0 +1,226 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+var (
+	phonyPctx = NewPackageContext("github.com/google/blueprint")
+
+	// phonyHelp prints the list of phony targets and their descriptions.
+	phonyHelp = phonyPctx.StaticRule("phony_help",
+		RuleParams{
+			Command:     "printf '%s\\n' $lines",
+			Description: "help",
+			Pool:        Console,
+		},
+		"lines")
+)
+
+// phonyDecl is a call to Phony or DescribePhony by a module or a singleton.
+type phonyDecl struct {
+	name        string
+	deps        []string
+	description string
+}
+
+// phonyTarget is the merged declarations of a phony target by all modules and singletons.
+type phonyTarget struct {
+	name        string
+	deps        []string
+	description string
+
+	// describedBy is the module or singleton that set the description.
+	describedBy string
+}
+
+// SetPhonyHelpTarget sets the name of a phony target that prints the names of all of the phony
+// targets created with ModuleContext.Phony and SingletonContext.Phony, with the descriptions set
+// by DescribePhony.  No help target is created if name is empty, which is the default.
+func (c *Context) SetPhonyHelpTarget(name string) {
+	c.phonyHelpTarget = name
+}
+
+// collectPhonyTargets merges the phony targets declared by all modules and singletons, sorted by
+// name.  A target declared multiple times depends on the deps of every declaration, but it is an
+// error to give it different descriptions, or to declare a phony target with the name of an
+// output of a build statement.
+func (c *Context) collectPhonyTargets() ([]*phonyTarget, []error) {
+	var errs []error
+	targets := make(map[string]*phonyTarget)
+
+	add := func(owner string, decls []phonyDecl) {
+		for _, decl := range decls {
+			target := targets[decl.name]
+			if target == nil {
+				target = &phonyTarget{name: decl.name}
+				targets[decl.name] = target
+			}
+			target.deps = append(target.deps, decl.deps...)
+			if decl.description == "" || decl.description == target.description {
+				continue
+			}
+			if target.description != "" {
+				errs = append(errs, fmt.Errorf("phony target %q has conflicting descriptions %q from %s and %q from %s",
+					decl.name, target.description, target.describedBy, decl.description, owner))
+				continue
+			}
+			target.description = decl.description
+			target.describedBy = owner
+		}
+	}
+
+	for _, module := range c.modulesSorted {
+		add(module.String(), module.phonies)
+	}
+	for _, info := range c.singletonInfo {
+		add(fmt.Sprintf("singleton %q", info.name), info.phonies)
+	}
+
+	if len(targets) == 0 {
+		return nil, errs
+	}
+
+	checkOutputs := func(owner string, buildDefs []*buildDef) {
+		for _, def := range buildDefs {
+			for _, outputs := range [][]ninjaString{def.Outputs, def.ImplicitOutputs} {
+				for _, output := range outputs {
+					if literal, ok := output.(literalNinjaString); ok && targets[string(literal)] != nil {
+						errs = append(errs, fmt.Errorf("phony target %q conflicts with an output of a build statement in %s",
+							string(literal), owner))
+					}
+				}
+			}
+		}
+	}
+	for _, module := range c.modulesSorted {
+		checkOutputs(module.String(), module.actionDefs.buildDefs)
+	}
+	for _, info := range c.singletonInfo {
+		checkOutputs(fmt.Sprintf("singleton %q", info.name), info.actionDefs.buildDefs)
+	}
+
+	ret := make([]*phonyTarget, 0, len(targets))
+	for _, target := range targets {
+		target.deps = sortedUniqueStrings(target.deps)
+		ret = append(ret, target)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+
+	return ret, errs
+}
+
+// sortedUniqueStrings returns a sorted copy of list without duplicates.
+func sortedUniqueStrings(list []string) []string {
+	ret := append([]string(nil), list...)
+	sort.Strings(ret)
+	for i := len(ret) - 1; i > 0; i-- {
+		if ret[i] == ret[i-1] {
+			ret = append(ret[:i], ret[i+1:]...)
+		}
+	}
+	return ret
+}
+
+// phonySingleton writes the phony targets declared by all modules and singletons, and the help
+// target set by SetPhonyHelpTarget.  It is run by the Context after the registered singletons.
+type phonySingleton struct {
+	targets []*phonyTarget
+}
+
+func (s *phonySingleton) GenerateBuildActions(ctx SingletonContext) {
+	for _, target := range s.targets {
+		ctx.Build(phonyPctx, BuildParams{
+			Rule:     Phony,
+			Outputs:  []string{target.name},
+			Inputs:   target.deps,
+			Optional: true,
+		})
+	}
+
+	helpTarget := ctx.(*singletonContext).context.phonyHelpTarget
+	if helpTarget == "" {
+		return
+	}
+
+	var lines []string
+	for _, target := range s.targets {
+		line := target.name
+		if target.description != "" {
+			line += ": " + target.description
+		}
+		lines = append(lines, proptools.NinjaEscape(proptools.ShellEscape(line)))
+	}
+	ctx.Build(phonyPctx, BuildParams{
+		Rule:     phonyHelp,
+		Outputs:  []string{helpTarget},
+		Optional: true,
+		Args: map[string]string{
+			"lines": strings.Join(lines, " "),
+		},
+	})
+}
+
+// generatePhonyBuildActions runs the phonySingleton for the phony targets declared by all modules
+// and singletons.
+func (c *Context) generatePhonyBuildActions(config interface{},
+	liveGlobals *liveTracker) ([]string, []error) {
+
+	c.phonyInfo = nil
+
+	targets, errs := c.collectPhonyTargets()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(targets) == 0 && c.phonyHelpTarget == "" {
+		return nil, nil
+	}
+
+	c.phonyInfo = &singletonInfo{
+		factory:   newPhonySingleton,
+		singleton: &phonySingleton{targets: targets},
+		name:      "phony_targets",
+	}
+
+	sctx, deps, errs := c.generateOneSingletonBuildActions(config, c.phonyInfo, liveGlobals)
+	sctx.applyNinjaSettings()
+	return deps, errs
+}
+
+func newPhonySingleton() Singleton {
+	return &phonySingleton{}
+}
+
+func (m *moduleContext) Phony(name string, deps ...string) {
+	m.module.phonies = append(m.module.phonies, phonyDecl{name: name, deps: deps})
+}
+
+func (m *moduleContext) DescribePhony(name, description string) {
+	m.module.phonies = append(m.module.phonies, phonyDecl{name: name, description: description})
+}
+
+func (s *singletonContext) Phony(name string, deps ...string) {
+	s.phonies = append(s.phonies, phonyDecl{name: name, deps: deps})
+}
+
+func (s *singletonContext) DescribePhony(name, description string) {
+	s.phonies = append(s.phonies, phonyDecl{name: name, description: description})
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type phonyTestModule struct {
+	SimpleName
+	properties struct {
+		Phony       string
+		Phony_deps  []string
+		Description string
+		Output      string
+	}
+}
+
+func newPhonyTestModule() (Module, []interface{}) {
+	m := &phonyTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *phonyTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if m.properties.Output != "" {
+		ctx.Build(strictTestPctx, BuildParams{
+			Rule:    Phony,
+			Outputs: []string{m.properties.Output},
+		})
+	}
+	if m.properties.Phony != "" {
+		ctx.Phony(m.properties.Phony, m.properties.Phony_deps...)
+	}
+	if m.properties.Description != "" {
+		ctx.DescribePhony(m.properties.Phony, m.properties.Description)
+	}
+}
+
+type phonyTestSingleton struct{}
+
+func (phonyTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Phony("all", "singleton_out")
+	ctx.DescribePhony("all", "build everything")
+}
+
+func TestPhony(t *testing.T) {
+	run := func(t *testing.T, bp string) (string, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("phony_module", newPhonyTestModule)
+		ctx.RegisterSingletonType("phony_test", func() Singleton { return phonyTestSingleton{} })
+		ctx.SetPhonyHelpTarget("help")
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			return "", errs
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), nil
+	}
+
+	out, errs := run(t, `
+		phony_module {
+			name: "a",
+			phony: "all",
+			phony_deps: ["a.out", "common.out"],
+		}
+
+		phony_module {
+			name: "b",
+			phony: "all",
+			phony_deps: ["b.out", "common.out"],
+			description: "build everything",
+		}
+
+		phony_module {
+			name: "c",
+			phony: "tests",
+			phony_deps: ["c_test"],
+		}
+	`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	for _, want := range []string{
+		"build all: phony a.out b.out common.out singleton_out\n",
+		"build tests: phony c_test\n",
+		"build help: g.blueprint.phony_help\n    lines = 'all: build everything' tests\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in Ninja file:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "default all") || strings.Contains(out, "default help") {
+		t.Errorf("expected phony targets not to be built by default:\n%s", out)
+	}
+
+	t.Run("conflicting descriptions", func(t *testing.T) {
+		_, errs := run(t, `
+			phony_module {
+				name: "a",
+				phony: "all",
+				description: "something else",
+			}
+		`)
+		want := `phony target "all" has conflicting descriptions "something else" from module "a" and "build everything" from singleton "phony_test"`
+		if len(errs) != 1 || errs[0].Error() != want {
+			t.Errorf("want error %q, got %q", want, errs)
+		}
+	})
+
+	t.Run("conflicting output", func(t *testing.T) {
+		_, errs := run(t, `
+			phony_module {
+				name: "a",
+				output: "all",
+			}
+		`)
+		want := `phony target "all" conflicts with an output of a build statement in module "a"`
+		if len(errs) != 1 || errs[0].Error() != want {
+			t.Errorf("want error %q, got %q", want, errs)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sync"
+	"text/scanner"
+)
+
+// A PostMutator validates a module variant after all mutators have run and the dependency graph
+// is final, but before GenerateBuildActions is called on any module.  It can inspect the module,
+// its variants and its dependencies through the PostMutatorContext and report errors, but it
+// cannot modify the graph.
+type PostMutator func(ctx PostMutatorContext)
+
+// PostMutatorContext is the read-only context passed to a PostMutator.
+type PostMutatorContext interface {
+	// Module returns the current module as a Module.
+	Module() Module
+
+	// ModuleName returns the name of the module.
+	ModuleName() string
+
+	// ModuleDir returns the path to the directory that contains the definition of the module.
+	ModuleDir() string
+
+	// ModuleType returns the name of the module type that was used to create the module.
+	ModuleType() string
+
+	// ModuleSubDir returns the unique name for this variant of the module.
+	ModuleSubDir() string
+
+	// BlueprintsFile returns the name of the blueprint file that contains the definition of this
+	// module.
+	BlueprintsFile() string
+
+	// Config returns the config object that was passed to Context.ResolveDependencies.
+	Config() interface{}
+
+	// ContainsProperty returns true if the specified property name was set in the module definition.
+	ContainsProperty(name string) bool
+
+	// Errorf reports an error at the specified position of the module definition file.
+	Errorf(pos scanner.Position, fmt string, args ...interface{})
+
+	// ModuleErrorf reports an error at the line number of the module type in the module definition.
+	ModuleErrorf(fmt string, args ...interface{})
+
+	// PropertyErrorf reports an error at the line number of a property in the module definition.
+	PropertyErrorf(property, fmt string, args ...interface{})
+
+	// OtherModuleErrorf reports an error at the line number of the module type in the module
+	// definition of another module.
+	OtherModuleErrorf(m Module, fmt string, args ...interface{})
+
+	// Warningf reports a warning at the line number of the module type in the module definition.
+	Warningf(fmt string, args ...interface{})
+
+	// PropertyWarningf reports a warning at the line number of a property in the module
+	// definition.
+	PropertyWarningf(property, fmt string, args ...interface{})
+
+	// Failed returns true if any errors have been reported.
+	Failed() bool
+
+	// GetDirectDepWithTag returns the Module the direct dependency with the specified name and
+	// dependency tag, or nil if there is no such dependency.
+	GetDirectDepWithTag(name string, tag DependencyTag) Module
+
+	// GetDirectDep returns the Module and DependencyTag for the direct dependency with the
+	// specified name, or nil if there is no such dependency.
+	GetDirectDep(name string) (Module, DependencyTag)
+
+	// VisitDirectDeps calls visit for each direct dependency.  If there are multiple direct
+	// dependencies on the same module visit will be called multiple times on that module.
+	VisitDirectDeps(visit func(Module))
+
+	// VisitDirectDepsIf calls pred for each direct dependency, and if pred returns true calls
+	// visit.
+	VisitDirectDepsIf(pred func(Module) bool, visit func(Module))
+
+	// VisitDepsDepthFirst calls visit for each transitive dependency, traversing the dependency
+	// tree in depth first order.  visit will only be called once for any given module, even if
+	// there are multiple paths through the dependency tree to the module or multiple direct
+	// dependencies with different tags.
+	VisitDepsDepthFirst(visit func(Module))
+
+	// VisitDepsDepthFirstIf calls pred for each transitive dependency, and if pred returns true
+	// calls visit, traversing the dependency tree in depth first order.
+	VisitDepsDepthFirstIf(pred func(Module) bool, visit func(Module))
+
+	// WalkDeps calls visit for each transitive dependency, traversing the dependency tree in top
+	// down order.  visit may be called multiple times for the same (child, parent) pair if there
+	// are multiple direct dependencies between the child and parent with different tags.  If visit
+	// returns false WalkDeps will not continue recursing down to child.
+	WalkDeps(visit func(Module, Module) bool)
+
+	// PrimaryModule returns the first variant of the current module.
+	PrimaryModule() Module
+
+	// FinalModule returns the last variant of the current module.
+	FinalModule() Module
+
+	// VisitAllModuleVariants calls visit for each variant of the current module.
+	VisitAllModuleVariants(visit func(Module))
+
+	// OtherModuleName returns the name of another Module.
+	OtherModuleName(m Module) string
+
+	// OtherModuleDir returns the directory of another Module.
+	OtherModuleDir(m Module) string
+
+	// OtherModuleSubDir returns the unique subdirectory name of another Module.
+	OtherModuleSubDir(m Module) string
+
+	// OtherModuleType returns the type of another Module.
+	OtherModuleType(m Module) string
+
+	// OtherModuleDependencyTag returns the dependency tag used to depend on a module, or nil if
+	// there is no dependency.  It must only be called from the visit function of one of the
+	// visitation methods.
+	OtherModuleDependencyTag(m Module) DependencyTag
+
+	// OtherModuleProvider returns the value for a provider for the given module.
+	OtherModuleProvider(m Module, provider ProviderKey) interface{}
+
+	// OtherModuleHasProvider returns true if the provider for the given module has been set.
+	OtherModuleHasProvider(m Module, provider ProviderKey) bool
+
+	// Provider returns the value for a provider for the current module.
+	Provider(provider ProviderKey) interface{}
+
+	// HasProvider returns true if the provider for the current module has been set.
+	HasProvider(provider ProviderKey) bool
+
+	// DirectoryMetadata returns the metadata from the per-directory metadata files with the given
+	// name that applies to the directory of the module.  See Context.RegisterDirectoryMetadata.
+	DirectoryMetadata(filename string) interface{}
+}
+
+var _ PostMutatorContext = (*postMutatorContext)(nil)
+
+type postMutatorContext struct {
+	baseModuleContext
+}
+
+func (p *postMutatorContext) ModuleSubDir() string {
+	return p.module.variant.name
+}
+
+type postMutatorInfo struct {
+	name    string
+	mutator PostMutator
+}
+
+// RegisterPostMutator registers a PostMutator that is called on every enabled module variant
+// after all mutators have run, at the end of ResolveDependencies.  Post mutators run in parallel
+// across modules, and in registration order for each module.  Any errors they report are returned
+// from ResolveDependencies.
+func (c *Context) RegisterPostMutator(name string, mutator PostMutator) {
+	c.checkRegistration("RegisterPostMutator")
+
+	for _, m := range c.postMutatorInfo {
+		if m.name == name {
+			panic(fmt.Errorf("post mutator name %s is already registered", name))
+		}
+	}
+
+	c.postMutatorInfo = append(c.postMutatorInfo, &postMutatorInfo{
+		name:    name,
+		mutator: mutator,
+	})
+}
+
+func (c *Context) runPostMutators(config interface{}) ([]string, []error) {
+	if len(c.postMutatorInfo) == 0 {
+		return nil, nil
+	}
+
+	var deps []string
+	var errs []error
+	var lock sync.Mutex
+
+	visitErrs := parallelVisit(c.Context, c.modulesSorted, unorderedVisitorImpl{}, c.visitLimit(),
+		func(module *moduleInfo, pause chan<- pauseSpec) bool {
+			if module.disabled {
+				return false
+			}
+
+			for _, info := range c.postMutatorInfo {
+				pctx := &postMutatorContext{
+					baseModuleContext: baseModuleContext{
+						context: c,
+						config:  config,
+						module:  module,
+					},
+				}
+
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							in := fmt.Sprintf("post mutator %q for %s", info.name, module)
+							if err, ok := r.(panicError); ok {
+								err.addIn(in)
+								pctx.error(err)
+							} else {
+								pctx.error(newPanicErrorf(r, in))
+							}
+						}
+					}()
+					info.mutator(pctx)
+				}()
+
+				lock.Lock()
+				deps = append(deps, pctx.ninjaFileDeps...)
+				errs = append(errs, pctx.errs...)
+				lock.Unlock()
+			}
+			return false
+		})
+
+	return deps, append(errs, visitErrs...)
+}
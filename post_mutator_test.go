@@ -0,0 +1,82 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPostMutator(t *testing.T) {
+	var visited []string
+	var visitedLock sync.Mutex
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterBottomUpMutator("variants", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("x", "y")
+	})
+	ctx.RegisterPostMutator("no_test_deps", func(ctx PostMutatorContext) {
+		visitedLock.Lock()
+		visited = append(visited, ctx.ModuleName()+":"+ctx.ModuleSubDir())
+		visitedLock.Unlock()
+
+		if strings.HasPrefix(ctx.ModuleName(), "test_") || ctx.ModuleSubDir() != "x" {
+			return
+		}
+		ctx.VisitDirectDeps(func(dep Module) {
+			if name := ctx.OtherModuleName(dep); strings.HasPrefix(name, "test_") {
+				ctx.ModuleErrorf("depends on test only module %s", name)
+			}
+		})
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["test_B"],
+			}
+
+			foo_module {
+				name: "test_B",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{`Blueprints:2:4: module "A" variant "x": depends on test only module test_B`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+
+	sort.Strings(visited)
+	wantVisited := []string{"A:x", "A:y", "test_B:x", "test_B:y"}
+	if !reflect.DeepEqual(visited, wantVisited) {
+		t.Errorf("incorrect visited modules\nwant: %q\n got: %q", wantVisited, visited)
+	}
+}
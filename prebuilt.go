@@ -0,0 +1,213 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// PrebuiltModule is implemented by modules that provide a prebuilt version of another module, the
+// source module.  After all mutators have run, each variant of a prebuilt module is compared with
+// the variant of the source module that has the same variations, and one of them is chosen: the
+// prebuilt if Prefer returns true, otherwise the source.  The config passed to
+// ResolveDependencies can change the choice by implementing PrebuiltConfig.  Every dependency on
+// the variant that was not chosen is replaced with a dependency on the chosen one, and the variant
+// that was not chosen is disabled.
+//
+// If there is no module with the name returned by PrebuiltOf then the prebuilt module is always
+// used, and dependencies on the name of the source module resolve to it.  This only applies to
+// prebuilt modules that exist before the mutators run.
+type PrebuiltModule interface {
+	Module
+
+	// PrebuiltOf returns the name of the source module that this module is a prebuilt of.
+	PrebuiltOf() string
+
+	// Prefer returns true if this module should be used instead of the source module.
+	Prefer() bool
+}
+
+// SimplePrebuilt is an embeddable object that implements the PrebuiltOf and Prefer methods of
+// PrebuiltModule with "prebuilt_of" and "prefer" properties.  The module's factory must return
+// &SimplePrebuilt.Properties as one of its property structs.
+type SimplePrebuilt struct {
+	Properties struct {
+		// name of the source module that this module is a prebuilt of
+		Prebuilt_of string
+
+		// use this module instead of the source module
+		Prefer *bool
+	}
+}
+
+func (s *SimplePrebuilt) PrebuiltOf() string {
+	return s.Properties.Prebuilt_of
+}
+
+func (s *SimplePrebuilt) Prefer() bool {
+	return s.Properties.Prefer != nil && *s.Properties.Prefer
+}
+
+// PrebuiltConfig can be implemented by the config object passed to ResolveDependencies to choose
+// between prebuilt modules and their source modules.  If the config does not implement it the
+// choice is made by PrebuiltModule.Prefer.
+type PrebuiltConfig interface {
+	// PreferPrebuilt returns true if the module named prebuilt should be used instead of the
+	// module named source.  prefer is the value returned by PrebuiltModule.Prefer.
+	PreferPrebuilt(prebuilt, source string, prefer bool) bool
+}
+
+// findPrebuiltsWithoutSource records the prebuilt modules whose source module doesn't exist, so
+// that dependencies on the name of the source module can be resolved to them.
+func (c *Context) findPrebuiltsWithoutSource() (errs []error) {
+	c.prebuiltsWithoutSource = nil
+	for _, group := range c.sortedModuleGroups() {
+		module := group.modules.firstModule()
+		if module == nil {
+			continue
+		}
+		prebuilt, ok := module.logicModule.(PrebuiltModule)
+		if !ok || prebuilt.PrebuiltOf() == "" {
+			continue
+		}
+		source := prebuilt.PrebuiltOf()
+		if c.moduleGroupFromName(source, module.namespace()) != nil {
+			continue
+		}
+
+		if existing, exists := c.prebuiltsWithoutSource[source]; exists {
+			errs = append(errs, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf("module %q has multiple prebuilts and no source module: %q and %q",
+						source, existing.name, group.name),
+					Pos: module.pos,
+				},
+				module: module,
+			})
+			continue
+		}
+
+		if c.prebuiltsWithoutSource == nil {
+			c.prebuiltsWithoutSource = make(map[string]*moduleGroup)
+		}
+		c.prebuiltsWithoutSource[source] = group
+	}
+	return errs
+}
+
+// dependencyGroupFromName is like moduleGroupFromName, but if no module has the given name it
+// returns the prebuilt of the missing module, if there is one that is visible in namespace.
+func (c *Context) dependencyGroupFromName(name string, namespace Namespace) *moduleGroup {
+	if group := c.moduleGroupFromName(name, namespace); group != nil {
+		return group
+	}
+	if group, exists := c.prebuiltsWithoutSource[name]; exists &&
+		c.moduleGroupFromName(group.name, namespace) == group {
+		return group
+	}
+	return nil
+}
+
+// applyPrebuilts chooses between each variant of a prebuilt module and the matching variant of
+// its source module, replaces the dependencies on the one that was not chosen and disables it.
+// See PrebuiltModule.
+func (c *Context) applyPrebuilts(config interface{}) (errs []error) {
+	prebuiltConfig, _ := config.(PrebuiltConfig)
+
+	moduleErrorf := func(module *moduleInfo, format string, args ...interface{}) {
+		errs = append(errs, &ModuleError{
+			BlueprintError: BlueprintError{
+				Err: fmt.Errorf(format, args...),
+				Pos: module.pos,
+			},
+			module: module,
+		})
+	}
+
+	// Collect the replacements in a deterministic order so that conflicts are reported
+	// consistently.
+	replacements := make(map[*moduleInfo]*moduleInfo)
+	for _, module := range c.sortedModuleInfos() {
+		prebuilt, ok := module.logicModule.(PrebuiltModule)
+		if !ok || module.disabled || prebuilt.PrebuiltOf() == "" {
+			continue
+		}
+		if d, ok := module.logicModule.(DisableableModule); ok && !d.BlueprintEnabled() {
+			continue
+		}
+
+		name := prebuilt.PrebuiltOf()
+		group := c.moduleGroupFromName(name, module.namespace())
+		if group == nil {
+			// There is no source module, dependencies on its name already use the prebuilt.
+			continue
+		}
+		if group == module.group {
+			moduleErrorf(module, "cannot be a prebuilt of itself")
+			continue
+		}
+
+		var source *moduleInfo
+		for _, m := range group.modules {
+			if m := m.module(); m != nil && m.variant.variations.equal(module.variant.variations) {
+				source = m
+				break
+			}
+		}
+		if source == nil {
+			moduleErrorf(module, "prebuilt of module %q, which has no variant %q",
+				name, module.variant.name)
+			continue
+		}
+
+		prefer := prebuilt.Prefer()
+		if prebuiltConfig != nil {
+			prefer = prebuiltConfig.PreferPrebuilt(module.Name(), name, prefer)
+		}
+		if d, ok := source.logicModule.(DisableableModule); source.disabled || (ok && !d.BlueprintEnabled()) {
+			prefer = true
+		}
+
+		used, unused := source, module
+		if prefer {
+			used, unused = module, source
+		}
+
+		if existing, exists := replacements[unused]; exists && existing != used {
+			moduleErrorf(module, "cannot replace %q, which is already replaced by %q",
+				unused.Name(), existing.Name())
+			continue
+		}
+		replacements[unused] = used
+	}
+
+	if len(errs) > 0 || len(replacements) == 0 {
+		return errs
+	}
+
+	for _, module := range c.modulesSorted {
+		for i, dep := range module.directDeps {
+			if used, exists := replacements[dep.module]; exists && used.group != module.group {
+				module.directDeps[i].module = used
+			}
+		}
+	}
+
+	for unused := range replacements {
+		unused.disabled = true
+	}
+
+	return c.updateDependencies()
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type prebuiltTestModule struct {
+	SimpleName
+	SimplePrebuilt
+	properties struct {
+		Deps []string
+	}
+}
+
+func newPrebuiltTestModule() (Module, []interface{}) {
+	m := &prebuiltTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimplePrebuilt.Properties}
+}
+
+func (m *prebuiltTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+func (m *prebuiltTestModule) Deps() []string {
+	return m.properties.Deps
+}
+
+func (m *prebuiltTestModule) IgnoreDeps() []string {
+	return nil
+}
+
+type prebuiltTestConfig map[string]bool
+
+func (c prebuiltTestConfig) PreferPrebuilt(prebuilt, source string, prefer bool) bool {
+	if p, ok := c[prebuilt]; ok {
+		return p
+	}
+	return prefer
+}
+
+func runPrebuiltTest(t *testing.T, bp string, config interface{}) (*Context, []error) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("prebuilt_module", newPrebuiltTestModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", config)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(config)
+	return ctx, errs
+}
+
+func TestPrebuilts(t *testing.T) {
+	bp := `
+		prebuilt_module {
+			name: "user",
+			deps: ["lib", "tool"],
+		}
+
+		prebuilt_module {
+			name: "lib",
+		}
+
+		prebuilt_module {
+			name: "prebuilt_lib",
+			prebuilt_of: "lib",
+		}
+
+		prebuilt_module {
+			name: "prebuilt_tool",
+			prebuilt_of: "tool",
+		}
+	`
+
+	testCases := []struct {
+		name     string
+		config   interface{}
+		want     []string
+		disabled []string
+	}{
+		{
+			name:     "source",
+			want:     []string{"lib", "prebuilt_tool"},
+			disabled: []string{"prebuilt_lib"},
+		},
+		{
+			name:     "prefer prebuilt",
+			config:   prebuiltTestConfig{"prebuilt_lib": true},
+			want:     []string{"prebuilt_lib", "prebuilt_tool"},
+			disabled: []string{"lib"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, errs := runPrebuiltTest(t, bp, testCase.config)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			if got := overrideTestDeps(ctx, "user"); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("incorrect deps\nwant: %q\n got: %q", testCase.want, got)
+			}
+			var disabled []string
+			ctx.VisitAllModules(func(m Module) {
+				if !ctx.ModuleEnabled(m) {
+					disabled = append(disabled, m.Name())
+				}
+			})
+			if !reflect.DeepEqual(disabled, testCase.disabled) {
+				t.Errorf("incorrect disabled modules\nwant: %q\n got: %q", testCase.disabled, disabled)
+			}
+		})
+	}
+}
+
+func TestPrebuiltPreferProperty(t *testing.T) {
+	bp := `
+		prebuilt_module {
+			name: "user",
+			deps: ["lib"],
+		}
+
+		prebuilt_module {
+			name: "lib",
+		}
+
+		prebuilt_module {
+			name: "prebuilt_lib",
+			prebuilt_of: "lib",
+			prefer: true,
+		}
+	`
+
+	ctx, errs := runPrebuiltTest(t, bp, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got, want := overrideTestDeps(ctx, "user"), []string{"prebuilt_lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect deps\nwant: %q\n got: %q", want, got)
+	}
+
+	ctx, errs = runPrebuiltTest(t, bp, prebuiltTestConfig{"prebuilt_lib": false})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got, want := overrideTestDeps(ctx, "user"), []string{"lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect deps with config\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestPrebuiltErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		want string
+	}{
+		{
+			name: "itself",
+			bp: `
+				prebuilt_module {
+					name: "a",
+					prebuilt_of: "a",
+				}
+			`,
+			want: `module "a": cannot be a prebuilt of itself`,
+		},
+		{
+			name: "twice preferred",
+			bp: `
+				prebuilt_module {
+					name: "a",
+				}
+
+				prebuilt_module {
+					name: "b",
+					prebuilt_of: "a",
+					prefer: true,
+				}
+
+				prebuilt_module {
+					name: "c",
+					prebuilt_of: "a",
+					prefer: true,
+				}
+			`,
+			want: `module "c": cannot replace "a", which is already replaced by "b"`,
+		},
+		{
+			name: "multiple without source",
+			bp: `
+				prebuilt_module {
+					name: "b",
+					prebuilt_of: "a",
+				}
+
+				prebuilt_module {
+					name: "c",
+					prebuilt_of: "a",
+				}
+			`,
+			want: `module "c": module "a" has multiple prebuilts and no source module: "b" and "c"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, errs := runPrebuiltTest(t, testCase.bp, nil)
+			if len(errs) != 1 {
+				t.Fatalf("want 1 error, got %v", errs)
+			}
+			if !strings.Contains(errs[0].Error(), testCase.want) {
+				t.Errorf("want error %q, got %q", testCase.want, errs[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/proptools"
+)
+
+// A PropertyOverride changes the value of a property of a module from outside of the Blueprints
+// files, for example to patch a module for a local build without editing its definition.  See
+// Context.AddPropertyOverrides.
+type PropertyOverride struct {
+	// Module is the name of the module to change.
+	Module string
+
+	// Property is the name of the property to change, using "." to separate the names of nested
+	// properties, for example "arch.arm.cflags".
+	Property string
+
+	// Value is the new value of the property, as decoded by encoding/json: a bool, a string, a
+	// number that is an integer, or a []interface{} or map[string]interface{} of those values.  A
+	// []string and int or int64 values are also accepted.
+	Value interface{}
+
+	// Append appends Value to the current value of the property in the same way as a "+="
+	// assignment in a Blueprints file, instead of replacing it.
+	Append bool
+
+	// Pos is the location the override came from, which is used in errors about the override and
+	// in errors reported by the module for the property.
+	Pos scanner.Position
+}
+
+// AddPropertyOverrides adds property overrides to apply to the modules.  The overrides are
+// applied in the order they were added, after defaults modules have been applied and before any
+// mutators run.  After a property is overridden, property errors reported by the module for it
+// point to the position of the override.  It is an error to override a property of a module
+// that doesn't exist or a property that the module doesn't have.
+func (c *Context) AddPropertyOverrides(overrides ...PropertyOverride) {
+	c.propertyOverrides = append(c.propertyOverrides, overrides...)
+}
+
+type jsonPropertyOverride struct {
+	Module   string      `json:"module"`
+	Property string      `json:"property"`
+	Value    interface{} `json:"value"`
+	Append   bool        `json:"append"`
+}
+
+// ParsePropertyOverrides parses a JSON list of property overrides, for example a file passed to
+// the primary builder, to pass to Context.AddPropertyOverrides.  Each entry of the list is an
+// object with "module", "property" and "value" fields, and an optional "append" field:
+//
+//	[
+//	    {"module": "libfoo", "property": "cflags", "value": ["-O0"], "append": true},
+//	    {"module": "libfoo", "property": "enabled", "value": false}
+//	]
+//
+// The Pos of each override is set to the location of its entry in filename.
+func ParsePropertyOverrides(filename string, r io.Reader) ([]PropertyOverride, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	position := func(offset int64) scanner.Position {
+		pos := scanner.Position{Filename: filename, Offset: int(offset), Line: 1, Column: 1}
+		for _, c := range data[:offset] {
+			if c == '\n' {
+				pos.Line++
+				pos.Column = 1
+			} else {
+				pos.Column++
+			}
+		}
+		return pos
+	}
+
+	// entryOffset returns the offset of the next entry of the list, skipping the whitespace and
+	// comma that follow the previous entry.
+	entryOffset := func(offset int64) int64 {
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) != -1 {
+			offset++
+		}
+		return offset
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decodeError := func(err error) error {
+		offset := decoder.InputOffset()
+		if syntaxErr, ok := err.(*json.SyntaxError); ok && syntaxErr.Offset > 0 {
+			// The offset of a syntax error is after the invalid character.
+			offset = syntaxErr.Offset - 1
+		}
+		return &BlueprintError{
+			Err: fmt.Errorf("failed to parse property overrides: %s", err),
+			Pos: position(offset),
+		}
+	}
+
+	if token, err := decoder.Token(); err != nil {
+		return nil, decodeError(err)
+	} else if token != json.Delim('[') {
+		return nil, &BlueprintError{
+			Err: fmt.Errorf("failed to parse property overrides: expected a list"),
+			Pos: position(0),
+		}
+	}
+
+	var overrides []PropertyOverride
+	for decoder.More() {
+		pos := position(entryOffset(decoder.InputOffset()))
+		var entry jsonPropertyOverride
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, decodeError(err)
+		}
+		if entry.Module == "" || entry.Property == "" || entry.Value == nil {
+			return nil, &BlueprintError{
+				Err: fmt.Errorf("property override must set module, property and value"),
+				Pos: pos,
+			}
+		}
+		overrides = append(overrides, PropertyOverride{
+			Module:   entry.Module,
+			Property: entry.Property,
+			Value:    entry.Value,
+			Append:   entry.Append,
+			Pos:      pos,
+		})
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, decodeError(err)
+	}
+
+	return overrides, nil
+}
+
+// applyPropertyOverrides applies the overrides added by AddPropertyOverrides to the modules.
+func (c *Context) applyPropertyOverrides() (errs []error) {
+	for _, override := range c.propertyOverrides {
+		group := c.moduleGroupFromName(override.Module, nil)
+		if group == nil {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("property override for unknown module %q", override.Module),
+				Pos: override.Pos,
+			})
+			continue
+		}
+		for _, moduleOrAlias := range group.modules {
+			if module := moduleOrAlias.module(); module != nil {
+				if err := c.applyPropertyOverride(module, override); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func (c *Context) applyPropertyOverride(module *moduleInfo, override PropertyOverride) error {
+	propertyError := func(err error) error {
+		return &PropertyError{
+			ModuleError: ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf("invalid property override: %s", err),
+					Pos: override.Pos,
+				},
+				module: module,
+			},
+			property: override.Property,
+		}
+	}
+
+	value, err := propertyOverrideExpression(override.Value, override.Pos)
+	if err != nil {
+		return propertyError(err)
+	}
+
+	// Build a property definition as if it was written in a Blueprints file, with nested
+	// properties as maps, and unpack it into empty copies of the property structs.
+	path := strings.Split(override.Property, ".")
+	property := &parser.Property{
+		Name:     path[len(path)-1],
+		NamePos:  override.Pos,
+		ColonPos: override.Pos,
+		Value:    value,
+	}
+	for i := len(path) - 2; i >= 0; i-- {
+		property = &parser.Property{
+			Name:     path[i],
+			NamePos:  override.Pos,
+			ColonPos: override.Pos,
+			Value: &parser.Map{
+				LBracePos:  override.Pos,
+				RBracePos:  override.Pos,
+				Properties: []*parser.Property{property},
+			},
+		}
+	}
+
+	overrideProperties := make([]interface{}, len(module.properties))
+	for i, p := range module.properties {
+		overrideProperties[i] = proptools.CloneEmptyProperties(reflect.ValueOf(p)).Interface()
+	}
+	_, errs := proptools.UnpackPropertiesIgnoringRequired([]*parser.Property{property}, nil,
+		overrideProperties...)
+	if len(errs) > 0 {
+		switch err := errs[0].(type) {
+		case *proptools.UnpackError:
+			return propertyError(err.Err)
+		case *proptools.PropertyConstraintError:
+			return propertyError(err.Err)
+		}
+		return propertyError(errs[0])
+	}
+
+	for i, p := range module.properties {
+		if override.Append {
+			err = proptools.ExtendProperties(p, overrideProperties[i], nil, proptools.OrderAppend)
+			if err != nil {
+				if extendErr, ok := err.(*proptools.ExtendPropertyError); ok {
+					err = extendErr.Err
+				}
+				return propertyError(err)
+			}
+		} else {
+			setPropertyOverride(reflect.ValueOf(p).Elem(), reflect.ValueOf(overrideProperties[i]).Elem(),
+				path)
+		}
+	}
+
+	// Copy the positions before changing them, the map may be shared with the parse cache.
+	propertyPos := make(map[string]scanner.Position, len(module.propertyPos)+1)
+	for name, pos := range module.propertyPos {
+		propertyPos[name] = pos
+	}
+	propertyPos[override.Property] = override.Pos
+	module.propertyPos = propertyPos
+
+	c.recordPropertyProvenance(module, PropertySourceOverride, override.Pos.Filename, nil)
+
+	return nil
+}
+
+// setPropertyOverride replaces the value of the property at path in the property struct dst with
+// its value in the property struct src, if dst has the property.  Nested property structs that
+// are nil in dst are created.
+func setPropertyOverride(dst, src reflect.Value, path []string) {
+	for i, name := range path {
+		fieldName := proptools.FieldNameForProperty(name)
+		dstField, srcField := dst.FieldByName(fieldName), src.FieldByName(fieldName)
+		if !dstField.IsValid() || !srcField.IsValid() {
+			return
+		}
+		if i == len(path)-1 {
+			dstField.Set(srcField)
+			return
+		}
+
+		if srcField.Kind() == reflect.Interface {
+			if srcField.IsNil() || dstField.IsNil() {
+				return
+			}
+			srcField, dstField = srcField.Elem(), dstField.Elem()
+		}
+		if srcField.Kind() == reflect.Ptr {
+			if srcField.IsNil() {
+				return
+			}
+			if dstField.IsNil() {
+				dstField.Set(reflect.New(dstField.Type().Elem()))
+			}
+			srcField, dstField = srcField.Elem(), dstField.Elem()
+		}
+		if srcField.Kind() != reflect.Struct {
+			return
+		}
+		dst, src = dstField, srcField
+	}
+}
+
+// propertyOverrideExpression returns the Blueprints expression for the value of a property
+// override.
+func propertyOverrideExpression(value interface{}, pos scanner.Position) (parser.Expression, error) {
+	switch v := value.(type) {
+	case bool:
+		return &parser.Bool{LiteralPos: pos, Value: v, Token: fmt.Sprint(v)}, nil
+	case string:
+		return &parser.String{LiteralPos: pos, Value: v}, nil
+	case int:
+		return &parser.Int64{LiteralPos: pos, Value: int64(v), Token: fmt.Sprint(v)}, nil
+	case int64:
+		return &parser.Int64{LiteralPos: pos, Value: v, Token: fmt.Sprint(v)}, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v > math.MaxInt64 {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return &parser.Int64{LiteralPos: pos, Value: int64(v), Token: fmt.Sprint(int64(v))}, nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an integer", v)
+		}
+		return &parser.Int64{LiteralPos: pos, Value: i, Token: v.String()}, nil
+	case []string:
+		list := &parser.List{LBracePos: pos, RBracePos: pos}
+		for _, s := range v {
+			list.Values = append(list.Values, &parser.String{LiteralPos: pos, Value: s})
+		}
+		return list, nil
+	case []interface{}:
+		list := &parser.List{LBracePos: pos, RBracePos: pos}
+		for _, elem := range v {
+			expr, err := propertyOverrideExpression(elem, pos)
+			if err != nil {
+				return nil, err
+			}
+			list.Values = append(list.Values, expr)
+		}
+		return list, nil
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		m := &parser.Map{LBracePos: pos, RBracePos: pos}
+		for _, name := range names {
+			expr, err := propertyOverrideExpression(v[name], pos)
+			if err != nil {
+				return nil, err
+			}
+			m.Properties = append(m.Properties, &parser.Property{
+				Name:     name,
+				NamePos:  pos,
+				ColonPos: pos,
+				Value:    expr,
+			})
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+type propertyOverrideTestProperties struct {
+	Srcs    []string
+	Cflag   *string
+	Enabled *bool
+	Arch    struct {
+		Arm struct {
+			Cflags []string
+		}
+	}
+}
+
+type propertyOverrideTestModule struct {
+	SimpleName
+	properties propertyOverrideTestProperties
+}
+
+func newPropertyOverrideTestModule() (Module, []interface{}) {
+	m := &propertyOverrideTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *propertyOverrideTestModule) GenerateBuildActions(ModuleContext) {}
+
+const propertyOverridesTestBlueprints = `
+	property_override_test_module {
+		name: "m",
+		srcs: ["m.c"],
+		cflag: "-O2",
+	}
+`
+
+func runPropertyOverridesTest(t *testing.T, overrides string,
+	mutator BottomUpMutator) (*propertyOverrideTestModule, []error) {
+
+	t.Helper()
+	parsed, err := ParsePropertyOverrides("overrides.json", strings.NewReader(overrides))
+	if err != nil {
+		t.Fatalf("unexpected error parsing overrides: %s", err)
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("property_override_test_module", newPropertyOverrideTestModule)
+	if mutator != nil {
+		ctx.RegisterBottomUpMutator("check", mutator)
+	}
+	ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(propertyOverridesTestBlueprints)})
+	ctx.AddPropertyOverrides(parsed...)
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	return ctx.moduleGroupFromName("m", nil).modules.firstModule().logicModule.(*propertyOverrideTestModule), errs
+}
+
+func TestPropertyOverrides(t *testing.T) {
+	m, errs := runPropertyOverridesTest(t, `[
+		{"module": "m", "property": "srcs", "value": ["extra.c"], "append": true},
+		{"module": "m", "property": "cflag", "value": "-O0"},
+		{"module": "m", "property": "enabled", "value": false},
+		{"module": "m", "property": "arch.arm.cflags", "value": ["-DARM"]}
+	]`, func(ctx BottomUpMutatorContext) {
+		ctx.PropertyErrorf("cflag", "bad cflag")
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %q", errs)
+	}
+	if want := `overrides.json:3:3: module "m": cflag: bad cflag`; errs[0].Error() != want {
+		t.Errorf("want error %q, got %q", want, errs[0])
+	}
+
+	if want := []string{"m.c", "extra.c"}; !reflect.DeepEqual(m.properties.Srcs, want) {
+		t.Errorf("want srcs %q, got %q", want, m.properties.Srcs)
+	}
+	if g, w := proptools.String(m.properties.Cflag), "-O0"; g != w {
+		t.Errorf("want cflag %q, got %q", w, g)
+	}
+	if m.properties.Enabled == nil || *m.properties.Enabled {
+		t.Errorf("want enabled false, got %v", m.properties.Enabled)
+	}
+	if want := []string{"-DARM"}; !reflect.DeepEqual(m.properties.Arch.Arm.Cflags, want) {
+		t.Errorf("want arch.arm.cflags %q, got %q", want, m.properties.Arch.Arm.Cflags)
+	}
+}
+
+func TestPropertyOverridesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides string
+		err       string
+	}{
+		{
+			name:      "unknown module",
+			overrides: `[{"module": "missing", "property": "srcs", "value": []}]`,
+			err:       `overrides.json:1:2: property override for unknown module "missing"`,
+		},
+		{
+			name:      "unknown property",
+			overrides: `[{"module": "m", "property": "arch.x86.cflags", "value": []}]`,
+			err:       `overrides.json:1:2: module "m": arch.x86.cflags: invalid property override: unrecognized property "arch.x86"`,
+		},
+		{
+			name:      "wrong type",
+			overrides: `[{"module": "m", "property": "cflag", "value": true}]`,
+			err:       `overrides.json:1:2: module "m": cflag: invalid property override: can't assign bool value to string property "cflag"`,
+		},
+		{
+			name:      "not an integer",
+			overrides: `[{"module": "m", "property": "cflag", "value": 1.5}]`,
+			err:       `overrides.json:1:2: module "m": cflag: invalid property override: 1.5 is not an integer`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, errs := runPropertyOverridesTest(t, test.overrides, nil)
+			if len(errs) != 1 || errs[0].Error() != test.err {
+				t.Errorf("want error %q, got %q", test.err, errs)
+			}
+		})
+	}
+}
+
+func TestParsePropertyOverrides(t *testing.T) {
+	overrides, err := ParsePropertyOverrides("overrides.json", strings.NewReader(`[
+		{"module": "a", "property": "srcs", "value": ["a.c"], "append": true},
+		{"module": "b", "property": "count", "value": 3}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 2 {
+		t.Fatalf("want 2 overrides, got %d", len(overrides))
+	}
+	if g := overrides[0]; g.Module != "a" || g.Property != "srcs" || !g.Append ||
+		g.Pos.String() != "overrides.json:2:3" {
+		t.Errorf("unexpected first override %+v", g)
+	}
+	if g := overrides[1]; g.Module != "b" || g.Append || g.Pos.String() != "overrides.json:3:3" {
+		t.Errorf("unexpected second override %+v", g)
+	}
+
+	errTests := []struct {
+		overrides string
+		err       string
+	}{
+		{`{}`, `overrides.json:1:1: failed to parse property overrides: expected a list`},
+		{`[{"module": "a", "property": "srcs"}]`, `overrides.json:1:2: property override must set module, property and value`},
+		{"[\n{\"module\": }]", `overrides.json:2:12: failed to parse property overrides: invalid character '}' looking for beginning of value`},
+	}
+	for _, test := range errTests {
+		_, err := ParsePropertyOverrides("overrides.json", strings.NewReader(test.overrides))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("want error %q, got %v", test.err, err)
+		}
+	}
+}
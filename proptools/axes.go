@@ -0,0 +1,201 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// An AxisSelector returns the values of an axis that apply to the module variant whose properties
+// are being squashed, in the order they should be merged.  For example a primary builder could
+// return []string{"arm", "armv8_a"} for an "arch" axis when squashing the properties of an arm64
+// variant.  Each value is the property name of a field of the axis struct, or a "."-separated path
+// of property names to select a value of an axis nested inside another value, for example
+// "arm.neon".  Values that have no properties set are skipped.
+type AxisSelector func(axis string) []string
+
+// SquashAxisProperties merges the properties set for the selected values of each axis of src into
+// the property structs in dst.  An axis is a struct property of src, for example "arch", whose
+// fields are property structs that only apply to some variants, for example "arm" and "x86":
+//
+//	arch: {
+//	    arm: { cflags: ["-DARM"] },
+//	    x86: { cflags: ["-DX86"] },
+//	}
+//
+// The axes are processed in the given order, and for each one selector is called to choose the
+// values whose properties are merged.  The properties of each selected value are merged into dst
+// like ExtendMatchingProperties, so every property of a value must exist in at least one of the
+// dst property structs, except for struct properties that don't exist in any of them, which are
+// treated as nested axes and skipped.  src may be one of the dst property structs.
+//
+// The order function is called with the full name of each property, for example
+// "arch.arm.cflags", to determine whether it is appended or prepended.  Passing nil for order
+// appends all properties.  An error that applies to a specific property will be an
+// *ExtendPropertyError, with the full name of the property.
+func SquashAxisProperties(dst []interface{}, src interface{}, axes []string, selector AxisSelector,
+	order ExtendPropertyOrderFunc) error {
+
+	srcValue, err := getStruct(src)
+	if err != nil {
+		if _, ok := err.(getStructEmptyError); ok {
+			return nil
+		}
+		return err
+	}
+
+	dstValues := make([]reflect.Value, len(dst))
+	for i := range dst {
+		dstValues[i], err = getOrCreateStruct(dst[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	if order == nil {
+		order = OrderAppend
+	}
+
+	for _, axis := range axes {
+		axisValue, err := axisPropertyStruct(srcValue, "", axis)
+		if err != nil {
+			return err
+		}
+		if !axisValue.IsValid() {
+			continue
+		}
+
+		for _, value := range selector(axis) {
+			valueStruct, err := axisPropertyStruct(axisValue, axis+".", value)
+			if err != nil {
+				return err
+			}
+			if !valueStruct.IsValid() {
+				continue
+			}
+
+			valueStruct = withoutNestedAxes(valueStruct, dstValues)
+			err = extendPropertiesRecursive(dstValues, valueStruct, axis+"."+value+".", nil, false,
+				order)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// axisPropertyStruct returns the struct property of v at the "."-separated path of property
+// names, or an invalid value if a pointer or interface along the path is nil.
+func axisPropertyStruct(v reflect.Value, prefix, path string) (reflect.Value, error) {
+	name := prefix
+	for i, part := range strings.Split(path, ".") {
+		if i > 0 {
+			name += "."
+		}
+		name += part
+
+		field := v.FieldByName(FieldNameForProperty(part))
+		if !field.IsValid() {
+			return reflect.Value{}, extendPropertyErrorf(name, "no such property")
+		}
+		if field.Kind() == reflect.Interface {
+			if field.IsNil() {
+				return reflect.Value{}, nil
+			}
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				return reflect.Value{}, nil
+			}
+			field = field.Elem()
+		}
+		if field.Kind() != reflect.Struct {
+			return reflect.Value{}, extendPropertyErrorf(name, "not a struct property but %s",
+				field.Kind())
+		}
+		v = field
+	}
+	return v, nil
+}
+
+type nestedAxesKey struct {
+	typ     reflect.Type
+	removed string
+}
+
+// nestedAxesTypes caches the types created by withoutNestedAxes, keyed by the original type and
+// the names of the removed fields.
+var nestedAxesTypes sync.Map
+
+// withoutNestedAxes returns a copy of the struct v without the struct fields that don't exist in
+// any of dstValues, or v itself if there are none.
+func withoutNestedAxes(v reflect.Value, dstValues []reflect.Value) reflect.Value {
+	isNestedAxis := func(field reflect.StructField) bool {
+		switch field.Type.Kind() {
+		case reflect.Struct, reflect.Interface:
+		case reflect.Ptr:
+			if field.Type.Elem().Kind() != reflect.Struct {
+				return false
+			}
+		default:
+			return false
+		}
+		for _, dstValue := range dstValues {
+			if _, ok := dstValue.Type().FieldByName(field.Name); ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	typ := v.Type()
+	var kept []int
+	var removed []string
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.PkgPath == "" && isNestedAxis(field) {
+			removed = append(removed, field.Name)
+		} else {
+			kept = append(kept, i)
+		}
+	}
+	if len(removed) == 0 {
+		return v
+	}
+
+	key := nestedAxesKey{typ, strings.Join(removed, ",")}
+	newType, ok := nestedAxesTypes.Load(key)
+	if !ok {
+		fields := make([]reflect.StructField, 0, len(kept))
+		for _, i := range kept {
+			if field := typ.Field(i); field.PkgPath == "" {
+				fields = append(fields, field)
+			}
+		}
+		newType, _ = nestedAxesTypes.LoadOrStore(key, reflect.StructOf(fields))
+	}
+
+	ret := reflect.New(newType.(reflect.Type)).Elem()
+	for _, i := range kept {
+		if field := typ.Field(i); field.PkgPath == "" {
+			ret.FieldByName(field.Name).Set(v.Field(i))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type axisTestValue struct {
+	Cflags  []string
+	Enabled *bool
+}
+
+type axisTestArmValue struct {
+	Cflags []string
+	Neon   struct {
+		Cflags []string
+	}
+}
+
+type axisTestProps struct {
+	Cflags  []string
+	Enabled *bool
+
+	Arch struct {
+		Arm axisTestArmValue
+		X86 *axisTestValue
+	}
+
+	Target struct {
+		Android interface{}
+		Host    interface{}
+	}
+}
+
+func TestSquashAxisProperties(t *testing.T) {
+	newProps := func() *axisTestProps {
+		props := &axisTestProps{Cflags: []string{"-O2"}}
+		props.Arch.Arm.Cflags = []string{"-DARM"}
+		props.Arch.Arm.Neon.Cflags = []string{"-DNEON"}
+		props.Arch.X86 = &axisTestValue{Cflags: []string{"-DX86"}, Enabled: BoolPtr(false)}
+		props.Target.Android = &axisTestValue{Cflags: []string{"-DANDROID"}}
+		return props
+	}
+
+	tests := []struct {
+		name     string
+		selected map[string][]string
+		order    ExtendPropertyOrderFunc
+
+		cflags  []string
+		enabled *bool
+		err     string
+	}{
+		{
+			name:     "nothing selected",
+			selected: nil,
+			cflags:   []string{"-O2"},
+		},
+		{
+			name: "append in order",
+			selected: map[string][]string{
+				"arch":   {"arm", "arm.neon"},
+				"target": {"android"},
+			},
+			cflags: []string{"-O2", "-DARM", "-DNEON", "-DANDROID"},
+		},
+		{
+			name: "prepend",
+			selected: map[string][]string{
+				"arch": {"arm", "arm.neon"},
+			},
+			order:  OrderPrepend,
+			cflags: []string{"-DNEON", "-DARM", "-O2"},
+		},
+		{
+			name: "pointer value",
+			selected: map[string][]string{
+				"arch": {"x86"},
+			},
+			cflags:  []string{"-O2", "-DX86"},
+			enabled: BoolPtr(false),
+		},
+		{
+			name: "nil interface value",
+			selected: map[string][]string{
+				"target": {"host"},
+			},
+			cflags: []string{"-O2"},
+		},
+		{
+			name: "unknown value",
+			selected: map[string][]string{
+				"arch": {"mips"},
+			},
+			err: `can't extend property "arch.mips": no such property`,
+		},
+		{
+			name: "order error",
+			selected: map[string][]string{
+				"arch": {"arm"},
+			},
+			order: func(property string, _, _ reflect.StructField, _, _ interface{}) (Order, error) {
+				return Append, fmt.Errorf("unsupported")
+			},
+			err: `can't extend property "arch.arm.cflags": unsupported`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			props := newProps()
+			err := SquashAxisProperties([]interface{}{props}, props, []string{"arch", "target"},
+				func(axis string) []string { return test.selected[axis] }, test.order)
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(props.Cflags, test.cflags) {
+				t.Errorf("want cflags %q, got %q", test.cflags, props.Cflags)
+			}
+			if !reflect.DeepEqual(props.Enabled, test.enabled) {
+				t.Errorf("want enabled %v, got %v", test.enabled, props.Enabled)
+			}
+		})
+	}
+}
+
+func TestSquashAxisPropertiesSeparateStructs(t *testing.T) {
+	type generic struct {
+		Srcs []string
+	}
+	type other struct {
+		Cflags []string
+	}
+	type archProps struct {
+		Arch struct {
+			Arm struct {
+				Srcs   []string
+				Cflags []string
+			}
+		}
+	}
+
+	g, o := &generic{Srcs: []string{"a.c"}}, &other{}
+	src := &archProps{}
+	src.Arch.Arm.Srcs = []string{"arm.c"}
+	src.Arch.Arm.Cflags = []string{"-DARM"}
+
+	err := SquashAxisProperties([]interface{}{g, o}, src, []string{"arch"},
+		func(string) []string { return []string{"arm"} }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.c", "arm.c"}; !reflect.DeepEqual(g.Srcs, want) {
+		t.Errorf("want srcs %q, got %q", want, g.Srcs)
+	}
+	if want := []string{"-DARM"}; !reflect.DeepEqual(o.Cflags, want) {
+		t.Errorf("want cflags %q, got %q", want, o.Cflags)
+	}
+}
@@ -63,10 +63,23 @@ func copyProperties(dstValue, srcValue reflect.Value) {
 		origDstFieldValue := dstFieldValue
 
 		switch srcFieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Int64, reflect.Uint:
 			dstFieldValue.Set(srcFieldValue)
 		case reflect.Struct:
 			copyProperties(dstFieldValue, srcFieldValue)
+		case reflect.Map:
+			if !srcFieldValue.IsNil() {
+				if srcFieldValue != dstFieldValue {
+					newMap := reflect.MakeMapWithSize(field.Type, srcFieldValue.Len())
+					iter := srcFieldValue.MapRange()
+					for iter.Next() {
+						newMap.SetMapIndex(iter.Key(), cloneMapValue(iter.Value()))
+					}
+					dstFieldValue.Set(newMap)
+				}
+			} else {
+				dstFieldValue.Set(srcFieldValue)
+			}
 		case reflect.Slice:
 			if !srcFieldValue.IsNil() {
 				if srcFieldValue != dstFieldValue {
@@ -137,6 +150,24 @@ func copyProperties(dstValue, srcValue reflect.Value) {
 	}
 }
 
+// cloneMapValue returns a copy of a value of a map property, recursively copying structs and
+// pointers to structs so that the copy does not share them with the original.
+func cloneMapValue(value reflect.Value) reflect.Value {
+	switch {
+	case isStructPtr(value.Type()):
+		if value.IsNil() {
+			return value
+		}
+		return CloneProperties(value)
+	case isStruct(value.Type()):
+		ret := reflect.New(value.Type()).Elem()
+		copyProperties(ret, value)
+		return ret
+	default:
+		return value
+	}
+}
+
 // ZeroProperties takes a reflect.Value of a pointer to a struct and replaces all of its fields
 // with zero values, recursing into struct, pointer to struct and interface fields.
 func ZeroProperties(structValue reflect.Value) {
@@ -158,7 +189,7 @@ func zeroProperties(structValue reflect.Value) {
 		fieldValue := structValue.Field(i)
 
 		switch fieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Int64, reflect.Uint:
 			fieldValue.Set(reflect.Zero(fieldValue.Type()))
 		case reflect.Interface:
 			if fieldValue.IsNil() {
@@ -220,7 +251,7 @@ func cloneEmptyProperties(dstValue, srcValue reflect.Value) {
 		dstFieldInterfaceValue := reflect.Value{}
 
 		switch srcFieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Int64, reflect.Uint:
 			// Nothing
 		case reflect.Struct:
 			cloneEmptyProperties(dstFieldValue, srcFieldValue)
@@ -112,6 +112,33 @@ var clonePropertiesTestCases = []struct {
 			S: Int64Ptr(5),
 		},
 	},
+	{
+		// Clone int64
+		in: &struct{ I int64 }{
+			I: 5,
+		},
+		out: &struct{ I int64 }{
+			I: 5,
+		},
+	},
+	{
+		// Clone map
+		in: &struct{ M map[string]string }{
+			M: map[string]string{"a": "1"},
+		},
+		out: &struct{ M map[string]string }{
+			M: map[string]string{"a": "1"},
+		},
+	},
+	{
+		// Clone nil map
+		in: &struct{ M map[string]string }{
+			M: nil,
+		},
+		out: &struct{ M map[string]string }{
+			M: nil,
+		},
+	},
 	{
 		// Clone struct
 		in: &struct{ S struct{ S string } }{
@@ -288,6 +315,23 @@ func TestCloneProperties(t *testing.T) {
 	}
 }
 
+func TestClonePropertiesMapOfStructs(t *testing.T) {
+	type inner struct{ S []string }
+	in := &struct {
+		M map[string]*inner
+	}{
+		M: map[string]*inner{"a": {S: []string{"1"}}},
+	}
+
+	out := CloneProperties(reflect.ValueOf(in)).Interface().(*struct{ M map[string]*inner })
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %#v, got %#v", in, out)
+	}
+	if in.M["a"] == out.M["a"] {
+		t.Errorf("expected map values to be copied")
+	}
+}
+
 var cloneEmptyPropertiesTestCases = []struct {
 	in  interface{}
 	out interface{}
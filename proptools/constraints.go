@@ -0,0 +1,223 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// PropertyConstraints are the constraints on the value of a property that are declared in the
+// `blueprint` struct tag of its field and enforced by UnpackProperties:
+//
+//	`blueprint:"required"`            the property must be set in the Blueprints file if the
+//	                                  map that contains it is set
+//	`blueprint:"enum:shared,static"`  each string value of the property must be one of the
+//	                                  listed values.  enum must be the last option in the tag.
+//	`blueprint:"path"`                each string value of the property must be a path relative
+//	                                  to the module directory that does not leave it
+//
+// enum and path can only be used on string, *string and []string fields.
+type PropertyConstraints struct {
+	Required bool
+	Enum     []string
+	Path     bool
+}
+
+// HasConstraints returns true if any constraint is set.
+func (c PropertyConstraints) HasConstraints() bool {
+	return c.Required || len(c.Enum) > 0 || c.Path
+}
+
+// PropertyConstraintsForTag returns the constraints declared in the `blueprint` key of a struct tag.
+func PropertyConstraintsForTag(tag reflect.StructTag) PropertyConstraints {
+	var constraints PropertyConstraints
+	options := strings.Split(tag.Get("blueprint"), ",")
+	for i, option := range options {
+		switch {
+		case option == "required":
+			constraints.Required = true
+		case option == "path":
+			constraints.Path = true
+		case strings.HasPrefix(option, "enum:"):
+			constraints.Enum = append([]string{strings.TrimPrefix(option, "enum:")}, options[i+1:]...)
+			return constraints
+		}
+	}
+	return constraints
+}
+
+// ErrRequiredPropertyNotSet is the Err of the PropertyConstraintError returned by UnpackProperties
+// for a required property that was not set.
+var ErrRequiredPropertyNotSet = errors.New("required property is not set")
+
+// A PropertyConstraintError is returned by UnpackProperties when the value of a property violates
+// a constraint declared in the struct tag of its field.  If Err is ErrRequiredPropertyNotSet Pos is
+// not valid.
+type PropertyConstraintError struct {
+	Property string
+	Err      error
+	Pos      scanner.Position
+}
+
+func (e *PropertyConstraintError) Error() string {
+	if !e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Property, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Pos, e.Property, e.Err)
+}
+
+// checkConstraintsType panics if the constraints can't be applied to a field of the given type.
+func checkConstraintsType(propertyName string, constraints PropertyConstraints, t reflect.Type) {
+	if len(constraints.Enum) == 0 && !constraints.Path {
+		return
+	}
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.String {
+		panic(fmt.Errorf(`field %s with blueprint:"enum" or blueprint:"path" tag must be a string or list of strings`,
+			propertyName))
+	}
+}
+
+// checkConstraints returns errors for each string value of the property that violates the enum
+// or path constraints.
+func checkConstraints(propertyName string, constraints PropertyConstraints,
+	property *parser.Property) []error {
+
+	if len(constraints.Enum) == 0 && !constraints.Path {
+		return nil
+	}
+
+	var values []*parser.String
+	switch v := property.Value.Eval().(type) {
+	case *parser.String:
+		values = append(values, v)
+	case *parser.List:
+		for _, item := range v.Values {
+			if s, ok := item.Eval().(*parser.String); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var errs []error
+	for _, value := range values {
+		var err error
+		if len(constraints.Enum) > 0 && !inList(value.Value, constraints.Enum) {
+			err = fmt.Errorf("invalid value %q, must be one of %s", value.Value,
+				quotedList(constraints.Enum))
+		} else if constraints.Path && !isModuleRelativePath(value.Value) {
+			err = fmt.Errorf("invalid path %q, must be relative to the module directory and not leave it",
+				value.Value)
+		}
+		if err != nil {
+			errs = append(errs, &PropertyConstraintError{
+				Property: propertyName,
+				Err:      err,
+				Pos:      value.Pos(),
+			})
+		}
+	}
+	return errs
+}
+
+func isModuleRelativePath(path string) bool {
+	if path == "" || filepath.IsAbs(path) {
+		return false
+	}
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if s == l {
+			return true
+		}
+	}
+	return false
+}
+
+func quotedList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// RequiredPropertiesNotSet returns the names of the properties of the property structs that are
+// tagged `blueprint:"required"` and for which isSet returns false.  Like UnpackProperties, a
+// required property in a nested struct is only reported if the property for the nested struct is
+// set.  Properties in lists of structs are not checked.
+func RequiredPropertiesNotSet(isSet func(property string) bool, objects ...interface{}) []string {
+	var missing []string
+
+	var walk func(prefix string, structValue reflect.Value)
+	walk = func(prefix string, structValue reflect.Value) {
+		structType := structValue.Type()
+		for i := 0; i < structValue.NumField(); i++ {
+			field := structType.Field(i)
+			fieldValue := structValue.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			anonymous := field.Anonymous || field.Name == "BlueprintEmbed"
+			propertyName := prefix
+			if !anonymous {
+				propertyName = fieldPath(prefix, PropertyNameForField(field.Name))
+			}
+
+			if fieldValue.Kind() == reflect.Interface {
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+				if fieldValue.IsNil() {
+					fieldValue = reflect.New(fieldValue.Type().Elem())
+				}
+				fieldValue = fieldValue.Elem()
+			}
+
+			if anonymous {
+				if fieldValue.Kind() == reflect.Struct {
+					walk(propertyName, fieldValue)
+				}
+			} else if !isSet(propertyName) {
+				if PropertyConstraintsForTag(field.Tag).Required {
+					missing = append(missing, propertyName)
+				}
+			} else if fieldValue.Kind() == reflect.Struct {
+				walk(propertyName, fieldValue)
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		walk("", reflect.ValueOf(obj).Elem())
+	}
+	return missing
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/scanner"
+)
+
+// A PropertyDeprecation is declared in the `blueprint` struct tag of a field to mark its property
+// as deprecated:
+//
+//	`blueprint:"deprecated=use srcs instead"`                     the property is deprecated,
+//	                                                              with a message for its users
+//	`blueprint:"deprecated=use srcs instead,removal=2022-06-30"`  the property will be removed
+//	                                                              after the given date
+//
+// The message can't contain commas.  Like the other options, deprecated and removal must come
+// before an enum option in the same tag.
+type PropertyDeprecation struct {
+	// Message tells the users of the property what to do instead.
+	Message string
+
+	// Removal is the date after which the property may be removed, or empty if it isn't planned.
+	Removal string
+}
+
+// PropertyDeprecationForTag returns the deprecation declared in the `blueprint` key of a struct
+// tag, or false if the property is not deprecated.
+func PropertyDeprecationForTag(tag reflect.StructTag) (PropertyDeprecation, bool) {
+	var deprecation PropertyDeprecation
+	deprecated := false
+	for _, option := range strings.Split(tag.Get("blueprint"), ",") {
+		switch {
+		case strings.HasPrefix(option, "deprecated="):
+			deprecation.Message = strings.TrimPrefix(option, "deprecated=")
+			deprecated = true
+		case option == "deprecated":
+			deprecated = true
+		case strings.HasPrefix(option, "removal="):
+			deprecation.Removal = strings.TrimPrefix(option, "removal=")
+		case strings.HasPrefix(option, "enum:"):
+			return deprecation, deprecated
+		}
+	}
+	return deprecation, deprecated
+}
+
+// A DeprecatedPropertyWarning is returned by DeprecatedPropertiesSet for a deprecated property
+// that is set.
+type DeprecatedPropertyWarning struct {
+	Property string
+	PropertyDeprecation
+	Pos scanner.Position
+}
+
+func (w *DeprecatedPropertyWarning) Error() string {
+	return fmt.Sprintf("%s: %s: %s", w.Pos, w.Property, w.Description())
+}
+
+// Description returns the warning without the position and name of the property.
+func (w *DeprecatedPropertyWarning) Description() string {
+	msg := "property is deprecated"
+	if w.Removal != "" {
+		msg += fmt.Sprintf(" and will be removed after %s", w.Removal)
+	}
+	if w.Message != "" {
+		msg += ": " + w.Message
+	}
+	return msg
+}
+
+// DeprecatedPropertiesSet returns a warning for each property of the property structs that is
+// tagged as deprecated and for which setAt returns true, with the position returned by setAt.
+// A deprecated property in a nested struct is only reported if the property for the nested
+// struct is set.  Properties in lists of structs are not checked.
+func DeprecatedPropertiesSet(setAt func(property string) (scanner.Position, bool),
+	objects ...interface{}) []*DeprecatedPropertyWarning {
+
+	var warnings []*DeprecatedPropertyWarning
+	reported := make(map[string]bool)
+
+	var walk func(prefix string, structValue reflect.Value)
+	walk = func(prefix string, structValue reflect.Value) {
+		structType := structValue.Type()
+		for i := 0; i < structValue.NumField(); i++ {
+			field := structType.Field(i)
+			fieldValue := structValue.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			anonymous := field.Anonymous || field.Name == "BlueprintEmbed"
+			propertyName := prefix
+			if !anonymous {
+				propertyName = fieldPath(prefix, PropertyNameForField(field.Name))
+			}
+
+			if fieldValue.Kind() == reflect.Interface {
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+				if fieldValue.IsNil() {
+					fieldValue = reflect.New(fieldValue.Type().Elem())
+				}
+				fieldValue = fieldValue.Elem()
+			}
+
+			if anonymous {
+				if fieldValue.Kind() == reflect.Struct {
+					walk(propertyName, fieldValue)
+				}
+				continue
+			}
+
+			pos, set := setAt(propertyName)
+			if !set {
+				continue
+			}
+			if deprecation, ok := PropertyDeprecationForTag(field.Tag); ok && !reported[propertyName] {
+				reported[propertyName] = true
+				warnings = append(warnings, &DeprecatedPropertyWarning{
+					Property:            propertyName,
+					PropertyDeprecation: deprecation,
+					Pos:                 pos,
+				})
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				walk(propertyName, fieldValue)
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		walk("", reflect.ValueOf(obj).Elem())
+	}
+	return warnings
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+	"text/scanner"
+)
+
+func TestPropertyDeprecationForTag(t *testing.T) {
+	tests := []struct {
+		tag        reflect.StructTag
+		want       PropertyDeprecation
+		deprecated bool
+	}{
+		{``, PropertyDeprecation{}, false},
+		{`blueprint:"required"`, PropertyDeprecation{}, false},
+		{`blueprint:"deprecated"`, PropertyDeprecation{}, true},
+		{`blueprint:"deprecated=use_foo_instead"`, PropertyDeprecation{Message: "use_foo_instead"}, true},
+		{`blueprint:"required,deprecated=use foo,removal=2022-06-30"`,
+			PropertyDeprecation{Message: "use foo", Removal: "2022-06-30"}, true},
+		{`blueprint:"enum:a,deprecated=b"`, PropertyDeprecation{}, false},
+	}
+	for _, test := range tests {
+		got, deprecated := PropertyDeprecationForTag(test.tag)
+		if got != test.want || deprecated != test.deprecated {
+			t.Errorf("%s: want %+v, %v, got %+v, %v", test.tag, test.want, test.deprecated, got,
+				deprecated)
+		}
+	}
+}
+
+func TestDeprecatedPropertiesSet(t *testing.T) {
+	type nested struct {
+		Old *string `blueprint:"deprecated=use new"`
+	}
+	props := &struct {
+		Old    *string `blueprint:"deprecated=use new,removal=2022-06-30"`
+		Unset  *string `blueprint:"deprecated"`
+		Nested *nested
+	}{}
+
+	// Properties that are in more than one property struct are only reported once.
+	set := map[string]int{"old": 1, "nested": 2, "nested.old": 3}
+	warnings := DeprecatedPropertiesSet(func(property string) (scanner.Position, bool) {
+		line, ok := set[property]
+		return scanner.Position{Filename: "Blueprints", Line: line, Column: 1}, ok
+	}, props, props)
+
+	var got []string
+	for _, warning := range warnings {
+		got = append(got, warning.Error())
+	}
+	want := []string{
+		"Blueprints:1:1: old: property is deprecated and will be removed after 2022-06-30: use new",
+		"Blueprints:3:1: nested.old: property is deprecated: use new",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want warnings %q, got %q", want, got)
+	}
+}
@@ -145,6 +145,34 @@ func ExtendMatchingProperties(dst []interface{}, src interface{},
 	return extendMatchingProperties(dst, src, filter, order)
 }
 
+// ApplyDefaults prepends the values of the properties in each of the property structs in defaults
+// to every property struct in dst that has the same type, so that values set in dst take priority
+// over values set in defaults.  dst and defaults must be slices of pointers to structs.  Property
+// structs in defaults that have no matching type in dst are ignored, as are property structs in dst
+// with no matching type in defaults.
+//
+// The filter function can prevent individual properties from being applied by returning false, or
+// abort ApplyDefaults with an error by returning an error.  Passing nil for filter will apply all
+// properties.
+//
+// An error returned by ApplyDefaults that applies to a specific property will be an
+// *ExtendPropertyError, and can have the property name and error extracted from it.
+func ApplyDefaults(dst []interface{}, defaults []interface{}, filter ExtendPropertyFilterFunc) error {
+	for _, src := range defaults {
+		srcType := reflect.TypeOf(src)
+		for _, d := range dst {
+			if reflect.TypeOf(d) != srcType {
+				continue
+			}
+			err := extendProperties(d, src, filter, OrderPrepend)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 type Order int
 
 const (
@@ -342,7 +370,7 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 				// Recursively extend the struct's fields.
 				recurse = append(recurse, dstFieldValue)
 				continue
-			case reflect.Bool, reflect.String, reflect.Slice:
+			case reflect.Bool, reflect.Int64, reflect.String, reflect.Slice, reflect.Map:
 				if srcFieldValue.Type() != dstFieldValue.Type() {
 					return extendPropertyErrorf(propertyName, "mismatched types %s and %s",
 						dstFieldValue.Type(), srcFieldValue.Type())
@@ -410,6 +438,40 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 	return nil
 }
 
+// extendMapStructValue returns a copy of the struct or pointer to struct value dstElem of a map
+// extended by the value srcElem for the same key, so that maps of property structs merge like
+// nested property structs.
+func extendMapStructValue(dstElem, srcElem reflect.Value, order Order) reflect.Value {
+	isPtr := dstElem.Kind() == reflect.Ptr
+	if isPtr && dstElem.IsNil() {
+		return srcElem
+	} else if isPtr && srcElem.IsNil() {
+		return dstElem
+	}
+
+	if !isPtr {
+		dstPtr, srcPtr := reflect.New(dstElem.Type()), reflect.New(srcElem.Type())
+		dstPtr.Elem().Set(dstElem)
+		srcPtr.Elem().Set(srcElem)
+		dstElem, srcElem = dstPtr, srcPtr
+	}
+
+	ret := CloneProperties(dstElem)
+	err := extendPropertiesRecursive([]reflect.Value{ret.Elem()}, srcElem.Elem(), "", nil, true,
+		func(string, reflect.StructField, reflect.StructField, interface{}, interface{}) (Order, error) {
+			return order, nil
+		})
+	if err != nil {
+		// The values have the same type, so extending can't fail.
+		panic(err)
+	}
+
+	if isPtr {
+		return ret
+	}
+	return ret.Elem()
+}
+
 func ExtendBasicType(dstFieldValue, srcFieldValue reflect.Value, order Order) {
 	prepend := order == Prepend
 
@@ -417,6 +479,49 @@ func ExtendBasicType(dstFieldValue, srcFieldValue reflect.Value, order Order) {
 	case reflect.Bool:
 		// Boolean OR
 		dstFieldValue.Set(reflect.ValueOf(srcFieldValue.Bool() || dstFieldValue.Bool()))
+	case reflect.Int64:
+		// For append and replace, replace the original value.  For prepend, only replace the
+		// original value if it is zero, since there is no way to tell if an int64 was set.
+		if !prepend || dstFieldValue.Int() == 0 {
+			dstFieldValue.SetInt(srcFieldValue.Int())
+		}
+	case reflect.Map:
+		if srcFieldValue.IsNil() {
+			break
+		}
+
+		// Merge the maps, with keys from src replacing keys from dst for append and keys from
+		// dst taking precedence for prepend.  Struct values for keys that are in both maps are
+		// extended instead, see extendMapStructValue.
+		newMap := reflect.MakeMapWithSize(srcFieldValue.Type(),
+			dstFieldValue.Len()+srcFieldValue.Len())
+		var first, second reflect.Value
+		if prepend {
+			first, second = srcFieldValue, dstFieldValue
+		} else if order == Append {
+			first, second = dstFieldValue, srcFieldValue
+		} else {
+			// replace
+			second = srcFieldValue
+		}
+		for _, m := range []reflect.Value{first, second} {
+			if !m.IsValid() {
+				continue
+			}
+			iter := m.MapRange()
+			for iter.Next() {
+				newMap.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		if elemType := srcFieldValue.Type().Elem(); order != Replace && (isStruct(elemType) || isStructPtr(elemType)) {
+			iter := srcFieldValue.MapRange()
+			for iter.Next() {
+				if dstElem := dstFieldValue.MapIndex(iter.Key()); dstElem.IsValid() {
+					newMap.SetMapIndex(iter.Key(), extendMapStructValue(dstElem, iter.Value(), order))
+				}
+			}
+		}
+		dstFieldValue.Set(newMap)
 	case reflect.String:
 		if prepend {
 			dstFieldValue.SetString(srcFieldValue.String() +
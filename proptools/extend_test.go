@@ -288,6 +288,123 @@ func appendPropertiesTestCases() []appendPropertyTestCase {
 			},
 			order: Prepend,
 		},
+		{
+			// Append int64
+			in1: &struct{ I1, I2 int64 }{
+				I1: 1,
+				I2: 0,
+			},
+			in2: &struct{ I1, I2 int64 }{
+				I1: 2,
+				I2: 3,
+			},
+			out: &struct{ I1, I2 int64 }{
+				I1: 2,
+				I2: 3,
+			},
+		},
+		{
+			// Prepend int64
+			in1: &struct{ I1, I2 int64 }{
+				I1: 1,
+				I2: 0,
+			},
+			in2: &struct{ I1, I2 int64 }{
+				I1: 2,
+				I2: 3,
+			},
+			out: &struct{ I1, I2 int64 }{
+				I1: 1,
+				I2: 3,
+			},
+			order: Prepend,
+		},
+		{
+			// Append map
+			in1: &struct{ M1, M2 map[string]string }{
+				M1: map[string]string{"a": "1", "b": "1"},
+				M2: map[string]string{"a": "1"},
+			},
+			in2: &struct{ M1, M2 map[string]string }{
+				M1: map[string]string{"b": "2", "c": "2"},
+				M2: nil,
+			},
+			out: &struct{ M1, M2 map[string]string }{
+				M1: map[string]string{"a": "1", "b": "2", "c": "2"},
+				M2: map[string]string{"a": "1"},
+			},
+		},
+		{
+			// Prepend map
+			in1: &struct{ M map[string]string }{
+				M: map[string]string{"a": "1", "b": "1"},
+			},
+			in2: &struct{ M map[string]string }{
+				M: map[string]string{"b": "2", "c": "2"},
+			},
+			out: &struct{ M map[string]string }{
+				M: map[string]string{"a": "1", "b": "1", "c": "2"},
+			},
+			order: Prepend,
+		},
+		{
+			// Replace map
+			in1: &struct{ M map[string]string }{
+				M: map[string]string{"a": "1", "b": "1"},
+			},
+			in2: &struct{ M map[string]string }{
+				M: map[string]string{"b": "2", "c": "2"},
+			},
+			out: &struct{ M map[string]string }{
+				M: map[string]string{"b": "2", "c": "2"},
+			},
+			order: Replace,
+		},
+		{
+			// Append map of structs
+			in1: &struct {
+				M map[string]struct{ S []string }
+			}{
+				M: map[string]struct{ S []string }{"a": {S: []string{"1"}}, "b": {S: []string{"1"}}},
+			},
+			in2: &struct {
+				M map[string]struct{ S []string }
+			}{
+				M: map[string]struct{ S []string }{"b": {S: []string{"2"}}, "c": {S: []string{"2"}}},
+			},
+			out: &struct {
+				M map[string]struct{ S []string }
+			}{
+				M: map[string]struct{ S []string }{
+					"a": {S: []string{"1"}},
+					"b": {S: []string{"1", "2"}},
+					"c": {S: []string{"2"}},
+				},
+			},
+		},
+		{
+			// Prepend map of struct pointers
+			in1: &struct {
+				M map[string]*struct{ S []string }
+			}{
+				M: map[string]*struct{ S []string }{"a": {S: []string{"1"}}, "b": {S: []string{"1"}}},
+			},
+			in2: &struct {
+				M map[string]*struct{ S []string }
+			}{
+				M: map[string]*struct{ S []string }{"b": {S: []string{"2"}}, "c": {S: []string{"2"}}},
+			},
+			out: &struct {
+				M map[string]*struct{ S []string }
+			}{
+				M: map[string]*struct{ S []string }{
+					"a": {S: []string{"1"}},
+					"b": {S: []string{"2", "1"}},
+					"c": {S: []string{"2"}},
+				},
+			},
+			order: Prepend,
+		},
 		{
 			// Replace slice
 			in1: &struct{ S []string }{
@@ -841,16 +958,16 @@ func appendPropertiesTestCases() []appendPropertyTestCase {
 		},
 		{
 			// Unsupported kind
-			in1: &struct{ I int64 }{
+			in1: &struct{ I int32 }{
 				I: 1,
 			},
-			in2: &struct{ I int64 }{
+			in2: &struct{ I int32 }{
 				I: 2,
 			},
-			out: &struct{ I int64 }{
+			out: &struct{ I int32 }{
 				I: 1,
 			},
-			err: extendPropertyErrorf("i", "unsupported kind int64"),
+			err: extendPropertyErrorf("i", "unsupported kind int32"),
 		},
 		{
 			// Interface nilitude mismatch
@@ -1448,6 +1565,46 @@ func TestExtendMatchingProperties(t *testing.T) {
 	}
 }
 
+func TestApplyDefaults(t *testing.T) {
+	type props struct {
+		S  []string
+		B  *bool
+		St *string
+	}
+	type otherProps struct {
+		I *int64
+	}
+
+	dst := []interface{}{
+		&props{
+			S:  []string{"module"},
+			St: StringPtr("module"),
+		},
+		&otherProps{},
+	}
+	defaults := []interface{}{
+		&props{
+			S:  []string{"defaults"},
+			B:  BoolPtr(true),
+			St: StringPtr("defaults"),
+		},
+		&struct{ Unmatched string }{"x"},
+	}
+
+	err := ApplyDefaults(dst, defaults, nil)
+
+	expected := []interface{}{
+		&props{
+			S:  []string{"defaults", "module"},
+			B:  BoolPtr(true),
+			St: StringPtr("module"),
+		},
+		&otherProps{},
+	}
+
+	check(t, "apply defaults", p(dst), dst, err, expected, nil)
+}
+
 func check(t *testing.T, testType, testString string,
 	got interface{}, err error,
 	expected interface{}, expectedErr error) {
@@ -0,0 +1,146 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// WriteCanonicalProperties writes a canonical serialization of the values in one or more property
+// structs to w.  The serialization only depends on the types and values of the exported fields,
+// so two sets of property structs with equal values always produce the same bytes, regardless of
+// map iteration order or pointer identity.  Nil pointers, slices and maps are distinguished from
+// pointers to zero values, empty slices and empty maps.
+func WriteCanonicalProperties(w io.Writer, propertyStructs ...interface{}) error {
+	buf := bufio.NewWriter(w)
+	for _, propertyStruct := range propertyStructs {
+		value := reflect.ValueOf(propertyStruct)
+		if !isStructPtr(value.Type()) {
+			return fmt.Errorf("expected pointer to struct, got %s", value.Type())
+		}
+		if err := writeCanonicalValue(buf, value, ""); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// HashProperties returns a hex encoded SHA-256 hash of the canonical serialization of one or more
+// property structs, as written by WriteCanonicalProperties.  It is stable across runs and
+// processes, and can be used as a cache key for the property values.
+func HashProperties(propertyStructs ...interface{}) (string, error) {
+	h := sha256.New()
+	if err := WriteCanonicalProperties(h, propertyStructs...); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeCanonicalValue(w *bufio.Writer, value reflect.Value, propertyName string) error {
+	switch value.Kind() {
+	case reflect.Bool:
+		if value.Bool() {
+			w.WriteString("t")
+		} else {
+			w.WriteString("f")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.WriteString("i" + strconv.FormatInt(value.Int(), 10) + ";")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		w.WriteString("u" + strconv.FormatUint(value.Uint(), 10) + ";")
+	case reflect.String:
+		writeCanonicalString(w, value.String())
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			w.WriteString("n")
+			break
+		}
+		if value.Kind() == reflect.Interface {
+			// Include the dynamic type so that different property structs with the same fields
+			// in an interface don't hash the same.
+			w.WriteString("I")
+			writeCanonicalString(w, value.Elem().Type().String())
+		} else {
+			w.WriteString("p")
+		}
+		return writeCanonicalValue(w, value.Elem(), propertyName)
+	case reflect.Slice:
+		if value.IsNil() {
+			w.WriteString("n")
+			break
+		}
+		w.WriteString("[" + strconv.Itoa(value.Len()) + ";")
+		for i := 0; i < value.Len(); i++ {
+			if err := writeCanonicalValue(w, value.Index(i), propertyName); err != nil {
+				return err
+			}
+		}
+		w.WriteString("]")
+	case reflect.Map:
+		if value.IsNil() {
+			w.WriteString("n")
+			break
+		}
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("property %q: unsupported map key kind %s", propertyName,
+				value.Type().Key().Kind())
+		}
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		w.WriteString("{" + strconv.Itoa(len(keys)) + ";")
+		for _, key := range keys {
+			writeCanonicalString(w, key.String())
+			if err := writeCanonicalValue(w, value.MapIndex(key), propertyName); err != nil {
+				return err
+			}
+		}
+		w.WriteString("}")
+	case reflect.Struct:
+		w.WriteString("(")
+		for i, field := range typeFields(value.Type()) {
+			if field.PkgPath != "" {
+				// The field is not exported so just skip it.
+				continue
+			}
+			name := PropertyNameForField(field.Name)
+			if propertyName != "" {
+				name = propertyName + "." + name
+			}
+			writeCanonicalString(w, field.Name)
+			if err := writeCanonicalValue(w, value.Field(i), name); err != nil {
+				return err
+			}
+		}
+		w.WriteString(")")
+	default:
+		return fmt.Errorf("property %q: unsupported kind %s", propertyName, value.Kind())
+	}
+	return nil
+}
+
+// writeCanonicalString writes a length prefixed string so that the boundaries between strings
+// are unambiguous.
+func writeCanonicalString(w *bufio.Writer, s string) {
+	w.WriteString(strconv.Itoa(len(s)))
+	w.WriteString(":")
+	w.WriteString(s)
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"testing"
+)
+
+type hashTestProps struct {
+	S   *string
+	L   []string
+	M   map[string]string
+	I   int64
+	N   struct{ B bool }
+	Ptr interface{}
+
+	unexported string
+}
+
+func TestHashProperties(t *testing.T) {
+	hash := func(props ...interface{}) string {
+		t.Helper()
+		h, err := HashProperties(props...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := func() *hashTestProps {
+		return &hashTestProps{
+			S:   StringPtr("s"),
+			L:   []string{"a", "b"},
+			M:   map[string]string{"x": "1", "y": "2", "z": "3"},
+			I:   1,
+			Ptr: &struct{ S string }{"p"},
+		}
+	}
+
+	want := hash(base())
+	for i := 0; i < 10; i++ {
+		// Map iteration order must not affect the hash.
+		if got := hash(base()); got != want {
+			t.Fatalf("hash is not stable: %s != %s", got, want)
+		}
+	}
+
+	unexported := base()
+	unexported.unexported = "ignored"
+	if got := hash(unexported); got != want {
+		t.Errorf("unexported field changed hash")
+	}
+
+	modifications := map[string]func(p *hashTestProps){
+		"nil string":      func(p *hashTestProps) { p.S = nil },
+		"empty string":    func(p *hashTestProps) { p.S = StringPtr("") },
+		"list order":      func(p *hashTestProps) { p.L = []string{"b", "a"} },
+		"list boundaries": func(p *hashTestProps) { p.L = []string{"ab"} },
+		"nil list":        func(p *hashTestProps) { p.L = nil },
+		"map value":       func(p *hashTestProps) { p.M["x"] = "2" },
+		"nil map":         func(p *hashTestProps) { p.M = nil },
+		"int":             func(p *hashTestProps) { p.I = 2 },
+		"nested":          func(p *hashTestProps) { p.N.B = true },
+		"interface value": func(p *hashTestProps) { p.Ptr = &struct{ S string }{"q"} },
+		"interface type":  func(p *hashTestProps) { p.Ptr = &struct{ T string }{"p"} },
+		"nil interface":   func(p *hashTestProps) { p.Ptr = nil },
+	}
+	seen := map[string]string{want: "base"}
+	for name, modify := range modifications {
+		p := base()
+		modify(p)
+		got := hash(p)
+		if other, exists := seen[got]; exists {
+			t.Errorf("%s: hash is the same as %s", name, other)
+		}
+		seen[got] = name
+	}
+
+	if hash(base(), &struct{ B bool }{}) == want {
+		t.Errorf("extra property struct did not change hash")
+	}
+
+	if _, err := HashProperties(&struct{ F func() }{}); err == nil {
+		t.Errorf("expected error for unsupported kind")
+	}
+}
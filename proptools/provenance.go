@@ -0,0 +1,191 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"sort"
+	"text/scanner"
+)
+
+// A PropertySource describes a change to the value of a property, see Provenance.
+type PropertySource struct {
+	// Kind is the kind of source that changed the property, for example "file", "defaults" or
+	// "mutator".
+	Kind string
+
+	// Name identifies the source within its kind, for example the name of the defaults module or
+	// of the mutator.
+	Name string
+
+	// Pos is the position in a Blueprints file responsible for the change, if known.
+	Pos scanner.Position
+
+	// Value is a copy of the value of the property after the change.
+	Value interface{}
+}
+
+// Provenance records the sequence of sources that changed each property of a set of property
+// structs.  Record compares the current values of the properties to the values they had when
+// Record or NewProvenance was last called, and attributes every change to the given source.
+// Properties are identified by their dotted property names, for example "nested.foo".
+type Provenance struct {
+	values  map[string]interface{}
+	sources map[string][]PropertySource
+}
+
+// NewProvenance returns a Provenance that records changes to the given property structs, starting
+// from their current values.
+func NewProvenance(propertyStructs ...interface{}) *Provenance {
+	return &Provenance{
+		values:  propertyValues(propertyStructs),
+		sources: make(map[string][]PropertySource),
+	}
+}
+
+// Record attributes every property of the given property structs whose value has changed since
+// the previous call to Record or NewProvenance to the source returned by source for the name of
+// the property.  It returns the names of the changed properties, sorted.
+func (p *Provenance) Record(source func(property string) PropertySource,
+	propertyStructs ...interface{}) []string {
+
+	values := propertyValues(propertyStructs)
+
+	var changed []string
+	for name, value := range values {
+		if old, ok := p.values[name]; !ok || !reflect.DeepEqual(old, value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range p.values {
+		if _, ok := values[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	for _, name := range changed {
+		s := source(name)
+		s.Value = values[name]
+		p.sources[name] = append(p.sources[name], s)
+	}
+	p.values = values
+
+	return changed
+}
+
+// Sources returns the sources that changed the given property, oldest first.
+func (p *Provenance) Sources(property string) []PropertySource {
+	return p.sources[property]
+}
+
+// Properties returns the names of the properties that have been changed by at least one source,
+// sorted.
+func (p *Provenance) Properties() []string {
+	properties := make([]string, 0, len(p.sources))
+	for property := range p.sources {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+	return properties
+}
+
+// Clone returns a copy of the Provenance that records changes independently, for use when the
+// property structs it tracks are copied.
+func (p *Provenance) Clone() *Provenance {
+	ret := &Provenance{
+		values:  p.values,
+		sources: make(map[string][]PropertySource, len(p.sources)),
+	}
+	for property, sources := range p.sources {
+		ret.sources[property] = sources[:len(sources):len(sources)]
+	}
+	return ret
+}
+
+// propertyValues returns copies of the values of the leaf properties of the property structs,
+// keyed by property name.
+func propertyValues(propertyStructs []interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, propertyStruct := range propertyStructs {
+		v := reflect.ValueOf(propertyStruct)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			structPropertyValues(values, "", v)
+		}
+	}
+	return values
+}
+
+func structPropertyValues(values map[string]interface{}, prefix string, structValue reflect.Value) {
+	structType := structValue.Type()
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			// Unexported fields are not properties.
+			continue
+		}
+
+		name := prefix
+		if !field.Anonymous {
+			name = prefix + PropertyNameForField(field.Name)
+		}
+
+		fieldValue := structValue.Field(i)
+		if fieldValue.Kind() == reflect.Interface {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		}
+
+		switch {
+		case fieldValue.Kind() == reflect.Struct:
+			structPropertyValues(values, nestedPrefix(name, field.Anonymous), fieldValue)
+		case isStructPtr(fieldValue.Type()):
+			if !fieldValue.IsNil() {
+				structPropertyValues(values, nestedPrefix(name, field.Anonymous), fieldValue.Elem())
+			}
+		case fieldValue.Kind() == reflect.Ptr:
+			if fieldValue.IsNil() {
+				values[name] = nil
+			} else {
+				values[name] = fieldValue.Elem().Interface()
+			}
+		case fieldValue.Kind() == reflect.Slice:
+			if fieldValue.IsNil() {
+				values[name] = nil
+			} else {
+				copied := reflect.MakeSlice(fieldValue.Type(), fieldValue.Len(), fieldValue.Len())
+				reflect.Copy(copied, fieldValue)
+				values[name] = copied.Interface()
+			}
+		default:
+			values[name] = fieldValue.Interface()
+		}
+	}
+}
+
+func nestedPrefix(name string, anonymous bool) string {
+	if anonymous {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	type Embedded struct {
+		Embedded_list []string
+	}
+	props := &struct {
+		Embedded
+		S      *string
+		List   []string
+		Nested struct {
+			B bool
+		}
+		Arch interface{}
+	}{
+		Arch: &struct{ X int64 }{},
+	}
+
+	source := func(kind, name string) func(string) PropertySource {
+		return func(string) PropertySource {
+			return PropertySource{Kind: kind, Name: name}
+		}
+	}
+
+	p := NewProvenance(props)
+
+	props.S = StringPtr("a")
+	props.List = append(props.List, "x")
+	if changed, want := p.Record(source("file", "Blueprints"), props), []string{"list", "s"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("want changed %q, got %q", want, changed)
+	}
+
+	props.List = append(props.List, "y")
+	props.Nested.B = true
+	props.Embedded_list = []string{"e"}
+	props.Arch.(*struct{ X int64 }).X = 1
+	if changed, want := p.Record(source("mutator", "m"), props), []string{"arch.x", "embedded_list", "list", "nested.b"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("want changed %q, got %q", want, changed)
+	}
+
+	// A clone records independently of the original.
+	clone := p.Clone()
+	props.S = StringPtr("b")
+	clone.Record(source("mutator", "clone"), props)
+
+	want := []PropertySource{
+		{Kind: "file", Name: "Blueprints", Value: []string{"x"}},
+		{Kind: "mutator", Name: "m", Value: []string{"x", "y"}},
+	}
+	if got := p.Sources("list"); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect sources for list:\nwant %#v\n got %#v", want, got)
+	}
+
+	if got, want := len(p.Sources("s")), 1; got != want {
+		t.Errorf("want %d sources for s in original, got %d", want, got)
+	}
+	if got, want := len(clone.Sources("s")), 2; got != want {
+		t.Errorf("want %d sources for s in clone, got %d", want, got)
+	}
+
+	wantProperties := []string{"arch.x", "embedded_list", "list", "nested.b", "s"}
+	if got := p.Properties(); !reflect.DeepEqual(got, wantProperties) {
+		t.Errorf("want properties %q, got %q", wantProperties, got)
+	}
+}
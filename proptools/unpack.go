@@ -47,8 +47,23 @@ type packedProperty struct {
 type unpackContext struct {
 	propertyMap map[string]*packedProperty
 	errs        []error
+
+	tagHandlers    map[string]PropertyTagHandler
+	tagHandlerKeys []string
+
+	// ignoreRequired disables reporting required properties that are not set, except in lists of
+	// structs.
+	ignoreRequired bool
 }
 
+// A PropertyTagHandler is called by UnpackPropertiesWithTagHandlers after a property that was set
+// in a Blueprints file has been unpacked into a struct field that has the struct tag key the
+// handler was registered for.  It receives the full name of the property, the struct field, the
+// value of the tag and the value of the field.  The handler may modify the field value.  An
+// error returned by the handler is reported as an UnpackError at the property.
+type PropertyTagHandler func(propertyName string, field reflect.StructField, tagValue string,
+	fieldValue reflect.Value) error
+
 // UnpackProperties populates the list of runtime values ("property structs") from the parsed properties.
 // If a property a.b.c has a value, a field with the matching name in each runtime value is initialized
 // from it. See PropertyNameForField for field and property name matching.
@@ -59,16 +74,50 @@ type unpackContext struct {
 // then v.Foo will be set to "abc" and v.Bar will be set to 1
 // (cf. unpack_test.go for further examples)
 //
-// The type of a receiving field has to match the property type, i.e., a bool/int/string field
+// The type of a receiving field has to match the property type, i.e., a bool/int64/string field
 // can be set from a property with bool/int/string value, a struct can be set from a map (only the
-// matching fields are set), and an slice can be set from a list.
+// matching fields are set), a map with string keys can be set from a map (every key is set), and a
+// slice can be set from a list.
 // If a field of a runtime value has been already set prior to the UnpackProperties, the new value
 // is appended to it (see somewhat inappropriately named ExtendBasicType).
 // The same property can initialize fields in multiple runtime values. It is an error if any property
 // value was not used to initialize at least one field.
 func UnpackProperties(properties []*parser.Property, objects ...interface{}) (map[string]*parser.Property, []error) {
+	return UnpackPropertiesWithTagHandlers(properties, nil, objects...)
+}
+
+// UnpackPropertiesWithTagHandlers is like UnpackProperties, but also calls the handler registered
+// in tagHandlers for each struct tag key found on a field that is set from a property, which
+// allows tag driven conventions like `android:"arch_variant"` to be implemented without
+// modifying proptools.  When a field has multiple handled tags the handlers are called in order
+// of the tag keys.
+func UnpackPropertiesWithTagHandlers(properties []*parser.Property,
+	tagHandlers map[string]PropertyTagHandler, objects ...interface{}) (map[string]*parser.Property, []error) {
+
+	return unpackProperties(properties, tagHandlers, false, objects...)
+}
+
+// UnpackPropertiesIgnoringRequired is like UnpackPropertiesWithTagHandlers, but does not report
+// properties tagged `blueprint:"required"` that are not set, except in lists of structs.  It is
+// used by callers that merge properties from other sources, for example defaults, and then check
+// them with RequiredPropertiesNotSet.
+func UnpackPropertiesIgnoringRequired(properties []*parser.Property,
+	tagHandlers map[string]PropertyTagHandler, objects ...interface{}) (map[string]*parser.Property, []error) {
+
+	return unpackProperties(properties, tagHandlers, true, objects...)
+}
+
+func unpackProperties(properties []*parser.Property, tagHandlers map[string]PropertyTagHandler,
+	ignoreRequired bool, objects ...interface{}) (map[string]*parser.Property, []error) {
+
 	var unpackContext unpackContext
 	unpackContext.propertyMap = make(map[string]*packedProperty)
+	unpackContext.ignoreRequired = ignoreRequired
+	unpackContext.tagHandlers = tagHandlers
+	for key := range tagHandlers {
+		unpackContext.tagHandlerKeys = append(unpackContext.tagHandlerKeys, key)
+	}
+	sort.Strings(unpackContext.tagHandlerKeys)
 	if !unpackContext.buildPropertyMap("", properties) {
 		return nil, unpackContext.errs
 	}
@@ -217,8 +266,22 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 		// TODO(ccross): we don't validate types inside nil struct pointers
 		// Move type validation to a function that runs on each factory once
 		switch kind := fieldValue.Kind(); kind {
-		case reflect.Bool, reflect.String, reflect.Struct, reflect.Slice:
+		case reflect.Bool, reflect.Int64, reflect.String, reflect.Struct, reflect.Slice:
 			// Do nothing
+		case reflect.Map:
+			if keyKind := fieldValue.Type().Key().Kind(); keyKind != reflect.String {
+				panic(fmt.Errorf("field %s is a map with %s keys", propertyName, keyKind))
+			}
+			switch elemType := fieldValue.Type().Elem(); elemType.Kind() {
+			case reflect.Bool, reflect.Int64, reflect.String, reflect.Struct:
+				// Nothing
+			case reflect.Ptr:
+				if !isStruct(elemType.Elem()) {
+					panic(fmt.Errorf("field %s is a map of pointers to %s", propertyName, elemType.Elem().Kind()))
+				}
+			default:
+				panic(fmt.Errorf("field %s is a map of %s", propertyName, elemType.Kind()))
+			}
 		case reflect.Interface:
 			if fieldValue.IsNil() {
 				panic(fmt.Errorf("field %s contains a nil interface", propertyName))
@@ -260,8 +323,19 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 			continue
 		}
 
+		constraints := PropertyConstraintsForTag(field.Tag)
+		checkConstraintsType(propertyName, constraints, fieldValue.Type())
+
 		if !propertyIsSet {
 			// This property wasn't specified.
+			if constraints.Required && !(ctx.ignoreRequired && !strings.Contains(propertyName, "[")) {
+				if !ctx.addError(&PropertyConstraintError{
+					Property: propertyName,
+					Err:      ErrRequiredPropertyNotSet,
+				}) {
+					return
+				}
+			}
 			continue
 		}
 
@@ -300,6 +374,14 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 				return
 			}
 
+		} else if fieldValue.Kind() == reflect.Map {
+			if unpackedValue, ok := ctx.unpackToMap(propertyName, property, fieldValue.Type()); ok {
+				ExtendBasicType(fieldValue, unpackedValue, Append)
+			}
+			if len(ctx.errs) >= maxUnpackErrors {
+				return
+			}
+
 		} else {
 			unpackedValue, err := propertyToValue(fieldValue.Type(), property)
 			if err != nil && !ctx.addError(err) {
@@ -307,9 +389,38 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 			}
 			ExtendBasicType(fieldValue, unpackedValue, Append)
 		}
+
+		for _, err := range checkConstraints(propertyName, constraints, property) {
+			if !ctx.addError(err) {
+				return
+			}
+		}
+
+		if !ctx.handleTags(propertyName, field, origFieldValue, property) {
+			return
+		}
 	}
 }
 
+// handleTags calls the tag handlers for each of the handled tags on the field.  It returns false
+// if the maximum number of errors has been reached.
+func (ctx *unpackContext) handleTags(propertyName string, field reflect.StructField,
+	fieldValue reflect.Value, property *parser.Property) bool {
+
+	for _, key := range ctx.tagHandlerKeys {
+		tagValue, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if err := ctx.tagHandlers[key](propertyName, field, tagValue, fieldValue); err != nil {
+			if !ctx.addError(&UnpackError{err, property.ColonPos}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // unpackSlice creates a value of a given slice type from the property which should be a list
 func (ctx *unpackContext) unpackToSlice(
 	sliceName string, property *parser.Property, sliceType reflect.Type) (reflect.Value, bool) {
@@ -383,6 +494,69 @@ func (ctx *unpackContext) unpackToSlice(
 	return value, true
 }
 
+// unpackToMap creates a value of a given map type from the property, which should be a map.  Each
+// property of the map becomes a key in the map.  Maps of structs or pointers to structs are
+// unpacked as if each key were a nested property struct.
+func (ctx *unpackContext) unpackToMap(
+	mapName string, property *parser.Property, mapType reflect.Type) (reflect.Value, bool) {
+	propValueAsMap, ok := property.Value.Eval().(*parser.Map)
+	if !ok {
+		ctx.addError(&UnpackError{
+			fmt.Errorf("can't assign %s value to map property %q",
+				property.Value.Type(), property.Name),
+			property.Value.Pos(),
+		})
+		return reflect.MakeMap(mapType), false
+	}
+
+	value := reflect.MakeMapWithSize(mapType, len(propValueAsMap.Properties))
+	ok = true
+	elemType := mapType.Elem()
+	for _, itemProperty := range propValueAsMap.Properties {
+		itemName := fieldPath(mapName, itemProperty.Name)
+		if packedProperty, found := ctx.propertyMap[itemName]; found {
+			packedProperty.used = true
+		}
+		key := reflect.ValueOf(itemProperty.Name).Convert(mapType.Key())
+
+		if isStruct(elemType) || isStructPtr(elemType) {
+			if itemProperty.Value.Eval().Type() != parser.MapType {
+				ctx.addError(&UnpackError{
+					fmt.Errorf("can't assign %s value to map property %q",
+						itemProperty.Value.Type(), itemName),
+					itemProperty.Value.Pos(),
+				})
+				ok = false
+				continue
+			}
+			var itemValue reflect.Value
+			if isStructPtr(elemType) {
+				itemValue = reflect.New(elemType.Elem())
+				ctx.unpackToStruct(itemName, itemValue.Elem())
+			} else {
+				itemValue = reflect.New(elemType).Elem()
+				ctx.unpackToStruct(itemName, itemValue)
+			}
+			value.SetMapIndex(key, itemValue)
+			continue
+		}
+
+		itemValue, err := propertyToValue(elemType, &parser.Property{
+			Name:     itemName,
+			NamePos:  itemProperty.NamePos,
+			ColonPos: itemProperty.ColonPos,
+			Value:    itemProperty.Value,
+		})
+		if err != nil {
+			ctx.addError(err)
+			ok = false
+			continue
+		}
+		value.SetMapIndex(key, itemValue)
+	}
+	return value, ok
+}
+
 // propertyToValue creates a value of a given value type from the property.
 func propertyToValue(typ reflect.Type, property *parser.Property) (reflect.Value, error) {
 	var value reflect.Value
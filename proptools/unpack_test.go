@@ -16,6 +16,7 @@ package proptools
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 
 	"testing"
@@ -707,6 +708,118 @@ var validUnpackTestCases = []struct {
 			},
 		},
 	},
+	{
+		name: "int64",
+		input: `
+			m {
+				i: 5,
+				neg: -3,
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				I   int64
+				Neg int64
+			}{
+				I:   5,
+				Neg: -3,
+			},
+		},
+	},
+
+	{
+		name: "map",
+		input: `
+			m {
+				env: {
+					FOO: "foo",
+					"BAR-BAZ": "bar",
+				},
+				flags: {
+					x: true,
+				},
+				empty: {},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Env   map[string]string
+				Flags map[string]bool
+				Empty map[string]string
+				Unset map[string]string
+			}{
+				Env:   map[string]string{"FOO": "foo", "BAR-BAZ": "bar"},
+				Flags: map[string]bool{"x": true},
+				Empty: map[string]string{},
+			},
+		},
+	},
+
+	{
+		name: "map in multiple structs",
+		input: `
+			m {
+				env: {
+					FOO: "foo",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Env map[string]string
+			}{
+				Env: map[string]string{"FOO": "foo"},
+			},
+			&struct {
+				Env map[string]string
+			}{
+				Env: map[string]string{"FOO": "foo"},
+			},
+		},
+	},
+
+	{
+		name: "map of structs",
+		input: `
+			m {
+				configs: {
+					debug: {
+						cflags: ["-O0"],
+						enabled: true,
+					},
+					release: {
+						cflags: ["-O2"],
+					},
+				},
+				ptrs: {
+					a: {
+						s: "a",
+					},
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Configs map[string]struct {
+					Cflags  []string
+					Enabled *bool
+				}
+				Ptrs map[string]*struct{ S string }
+			}{
+				Configs: map[string]struct {
+					Cflags  []string
+					Enabled *bool
+				}{
+					"debug":   {Cflags: []string{"-O0"}, Enabled: BoolPtr(true)},
+					"release": {Cflags: []string{"-O2"}},
+				},
+				Ptrs: map[string]*struct{ S string }{
+					"a": {S: "a"},
+				},
+			},
+		},
+	},
+
 	// Captitalized property
 	{
 		input: `
@@ -910,6 +1023,84 @@ func TestUnpackErrors(t *testing.T) {
 				`<input>:3:11: can't assign string value to int64 property "int"`,
 			},
 		},
+		{
+			name: "wrong type for map value",
+			input: `
+				m {
+					env: {
+						FOO: "foo",
+						BAR: 1,
+					},
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Env map[string]string
+				}{},
+			},
+			errors: []string{
+				`<input>:5:12: can't assign int64 value to string property "env.BAR"`,
+			},
+		},
+		{
+			name: "unknown property in map of structs",
+			input: `
+				m {
+					configs: {
+						debug: {
+							cflags: "-O0",
+							ldflags: "-g",
+						},
+					},
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Configs map[string]struct {
+						Cflags string
+					}
+				}{},
+			},
+			errors: []string{
+				`<input>:6:15: unrecognized property "configs.debug.ldflags"`,
+			},
+		},
+		{
+			name: "wrong type for map of structs",
+			input: `
+				m {
+					configs: {
+						debug: "-O0",
+					},
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Configs map[string]struct {
+						Cflags string
+					}
+				}{},
+			},
+			errors: []string{
+				`<input>:4:14: can't assign string value to map property "configs.debug"`,
+			},
+		},
+		{
+			name: "wrong type for map of strings",
+			input: `
+				m {
+					env: ["foo"],
+				}
+			`,
+			output: []interface{}{
+				&struct {
+					Env map[string]string
+				}{},
+			},
+			errors: []string{
+				`<input>:3:11: can't assign list value to map property "env"`,
+			},
+		},
 		{
 			name: "wrong type for map",
 			input: `
@@ -1196,3 +1387,150 @@ func BenchmarkUnpackProperties(b *testing.B) {
 		run(b, props, bp)
 	})
 }
+
+func TestUnpackPropertiesWithTagHandlers(t *testing.T) {
+	input := `
+		m {
+			arch: ["arm"],
+			nested: {
+				arch: ["x86"],
+				plain: "x",
+			},
+			bad: "y",
+		}
+	`
+	file, errs := parser.ParseAndEval("", bytes.NewBufferString(input), parser.NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	output := &struct {
+		Arch   []string `test:"variant"`
+		Unset  []string `test:"variant"`
+		Nested struct {
+			Arch  []string `test:"variant"`
+			Plain string
+		}
+		Bad string `test:"reject"`
+	}{}
+
+	var handled []string
+	handlers := map[string]PropertyTagHandler{
+		"test": func(propertyName string, field reflect.StructField, tagValue string, fieldValue reflect.Value) error {
+			if tagValue == "reject" {
+				return fmt.Errorf("property %s is rejected", propertyName)
+			}
+			handled = append(handled, propertyName+"="+tagValue)
+			fieldValue.Set(reflect.Append(fieldValue, reflect.ValueOf("handled")))
+			return nil
+		},
+	}
+
+	module := file.Defs[0].(*parser.Module)
+	_, errs = UnpackPropertiesWithTagHandlers(module.Properties, handlers, output)
+
+	wantErrs := []string{`<input>:8:7: property bad is rejected`}
+	var gotErrs []string
+	for _, err := range errs {
+		gotErrs = append(gotErrs, err.Error())
+	}
+	if !reflect.DeepEqual(gotErrs, wantErrs) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", wantErrs, gotErrs)
+	}
+
+	if want := []string{"arch=variant", "nested.arch=variant"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("incorrect handled properties\nwant: %q\n got: %q", want, handled)
+	}
+	if want := []string{"arm", "handled"}; !reflect.DeepEqual(output.Arch, want) {
+		t.Errorf("incorrect arch\nwant: %q\n got: %q", want, output.Arch)
+	}
+	if want := []string{"x86", "handled"}; !reflect.DeepEqual(output.Nested.Arch, want) {
+		t.Errorf("incorrect nested.arch\nwant: %q\n got: %q", want, output.Nested.Arch)
+	}
+	if output.Unset != nil {
+		t.Errorf("expected unset property to be left alone, got %q", output.Unset)
+	}
+}
+
+func TestUnpackPropertyConstraints(t *testing.T) {
+	input := `
+		m {
+			link: "dynamic",
+			srcs: ["a.c", "../b.c", "/c.c"],
+			nested: {
+				stem: "foo",
+			},
+			items: [{}],
+		}
+	`
+	file, errs := parser.ParseAndEval("", bytes.NewBufferString(input), parser.NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	output := &struct {
+		Name   *string  `blueprint:"required"`
+		Link   *string  `blueprint:"enum:shared,static"`
+		Srcs   []string `blueprint:"path"`
+		Nested struct {
+			Stem string `blueprint:"required"`
+			Type string `blueprint:"required"`
+		}
+		Unset struct {
+			Type string `blueprint:"required"`
+		}
+		Items []struct {
+			Name string `blueprint:"required"`
+		}
+	}{}
+
+	module := file.Defs[0].(*parser.Module)
+	_, errs = UnpackProperties(module.Properties, output)
+
+	wantErrs := []string{
+		`name: required property is not set`,
+		`<input>:3:10: link: invalid value "dynamic", must be one of "shared", "static"`,
+		`<input>:4:18: srcs: invalid path "../b.c", must be relative to the module directory and not leave it`,
+		`<input>:4:28: srcs: invalid path "/c.c", must be relative to the module directory and not leave it`,
+		`nested.type: required property is not set`,
+		`items[0].name: required property is not set`,
+	}
+	var gotErrs []string
+	for _, err := range errs {
+		gotErrs = append(gotErrs, err.Error())
+	}
+	if !reflect.DeepEqual(gotErrs, wantErrs) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", wantErrs, gotErrs)
+	}
+
+	_, errs = UnpackPropertiesIgnoringRequired(module.Properties, nil, output)
+	if len(errs) != 4 || errs[3].Error() != `items[0].name: required property is not set` {
+		t.Errorf("expected only enum, path and list item errors, got %q", errs)
+	}
+
+	isSet := func(property string) bool {
+		return property == "nested" || property == "nested.stem"
+	}
+	missing := RequiredPropertiesNotSet(isSet, output)
+	if want := []string{"name", "nested.type"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("incorrect missing required properties\nwant: %q\n got: %q", want, missing)
+	}
+}
+
+func TestPropertyConstraintsForTag(t *testing.T) {
+	testCases := []struct {
+		tag  reflect.StructTag
+		want PropertyConstraints
+	}{
+		{``, PropertyConstraints{}},
+		{`blueprint:"mutated"`, PropertyConstraints{}},
+		{`blueprint:"required,path"`, PropertyConstraints{Required: true, Path: true}},
+		{`blueprint:"required,enum:a,b,required"`,
+			PropertyConstraints{Required: true, Enum: []string{"a", "b", "required"}}},
+	}
+	for _, testCase := range testCases {
+		if got := PropertyConstraintsForTag(testCase.tag); !reflect.DeepEqual(got, testCase.want) {
+			t.Errorf("tag %s: want %+v, got %+v", testCase.tag, testCase.want, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Version is a parsed version-like property value, either a numeric API level like "30" or a
+// semantic version like "1.2.3-beta.1+build.5".
+type Version struct {
+	Major, Minor, Patch int64
+
+	// Components is the number of numeric components in the original string, from 1 to 3.
+	Components int
+
+	// Prerelease and Build are the optional dot separated identifiers after the '-' and '+'
+	// separators.  Build is ignored when comparing versions.
+	Prerelease string
+	Build      string
+}
+
+// A VersionError is returned when a version string cannot be parsed.
+type VersionError struct {
+	Version string
+	Err     error
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("invalid version %q: %s", e.Version, e.Err)
+}
+
+func (e *VersionError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	ErrVersionEmpty             = errors.New("empty version")
+	ErrVersionTooManyComponents = errors.New("too many components")
+	ErrVersionNotNumeric        = errors.New("component is not a non-negative integer")
+	ErrVersionLeadingZero       = errors.New("component has a leading zero")
+	ErrVersionOverflow          = errors.New("component is out of range")
+	ErrVersionBadIdentifier     = errors.New("invalid prerelease or build identifier")
+	ErrVersionNotApiLevel       = errors.New("not a numeric API level")
+)
+
+// ParseVersion parses a version string with one to three dot separated numeric components, an
+// optional prerelease suffix starting with '-' and an optional build suffix starting with '+'.
+// Missing numeric components are treated as zero.  Parse failures return a *VersionError that
+// wraps one of the ErrVersion* errors.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	fail := func(err error) (Version, error) {
+		return Version{}, &VersionError{s, err}
+	}
+
+	rest := s
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validVersionIdentifiers(v.Build) {
+			return fail(ErrVersionBadIdentifier)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if !validVersionIdentifiers(v.Prerelease) {
+			return fail(ErrVersionBadIdentifier)
+		}
+	}
+
+	if rest == "" {
+		return fail(ErrVersionEmpty)
+	}
+
+	components := strings.Split(rest, ".")
+	if len(components) > 3 {
+		return fail(ErrVersionTooManyComponents)
+	}
+	v.Components = len(components)
+
+	fields := []*int64{&v.Major, &v.Minor, &v.Patch}
+	for i, c := range components {
+		n, err := parseVersionNumber(c)
+		if err != nil {
+			return fail(err)
+		}
+		*fields[i] = n
+	}
+
+	return v, nil
+}
+
+// ParseApiLevel parses a numeric API level like "30".  Anything other than a single non-negative
+// integer component returns a *VersionError.
+func ParseApiLevel(s string) (int64, error) {
+	v, err := ParseVersion(s)
+	if err != nil {
+		return 0, err
+	}
+	if v.Components != 1 || v.Prerelease != "" || v.Build != "" {
+		return 0, &VersionError{s, ErrVersionNotApiLevel}
+	}
+	return v.Major, nil
+}
+
+// CompareVersions parses two version strings and returns -1, 0 or 1 if a is less than, equal to or
+// greater than b.
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than other, using semantic
+// versioning precedence rules: numeric components are compared numerically, a version with a
+// prerelease is less than the same version without one, and build metadata is ignored.
+func (v Version) Compare(other Version) int {
+	if c := compareInt64(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInt64(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt64(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	a := strings.Split(v.Prerelease, ".")
+	b := strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, aErr := strconv.ParseInt(a[i], 10, 64)
+		bn, bErr := strconv.ParseInt(b[i], 10, 64)
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInt64(an, bn)
+		case aErr == nil:
+			// Numeric identifiers have lower precedence than alphanumeric ones.
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(a)), int64(len(b)))
+}
+
+// String returns the canonical form of the version, with the same number of numeric components
+// that it was parsed with.
+func (v Version) String() string {
+	s := strconv.FormatInt(v.Major, 10)
+	if v.Components > 1 {
+		s += "." + strconv.FormatInt(v.Minor, 10)
+	}
+	if v.Components > 2 {
+		s += "." + strconv.FormatInt(v.Patch, 10)
+	}
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+func parseVersionNumber(s string) (int64, error) {
+	if s == "" {
+		return 0, ErrVersionNotNumeric
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, ErrVersionNotNumeric
+		}
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, ErrVersionLeadingZero
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrVersionOverflow
+	}
+	return n, nil
+}
+
+func validVersionIdentifiers(s string) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, c := range id {
+			if !(c == '-' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
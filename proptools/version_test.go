@@ -0,0 +1,122 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want Version
+		err  error
+	}{
+		{in: "30", want: Version{Major: 30, Components: 1}},
+		{in: "1.2", want: Version{Major: 1, Minor: 2, Components: 2}},
+		{in: "1.2.3", want: Version{Major: 1, Minor: 2, Patch: 3, Components: 3}},
+		{in: "0.1.0-beta.1+build-5", want: Version{Minor: 1, Components: 3, Prerelease: "beta.1", Build: "build-5"}},
+		{in: "1.0.0-alpha-1", want: Version{Major: 1, Components: 3, Prerelease: "alpha-1"}},
+		{in: "", err: ErrVersionEmpty},
+		{in: "-beta", err: ErrVersionEmpty},
+		{in: "1.2.3.4", err: ErrVersionTooManyComponents},
+		{in: "1..2", err: ErrVersionNotNumeric},
+		{in: "1.x", err: ErrVersionNotNumeric},
+		{in: "-1", err: ErrVersionEmpty},
+		{in: "01", err: ErrVersionLeadingZero},
+		{in: "99999999999999999999", err: ErrVersionOverflow},
+		{in: "1.0-", err: ErrVersionBadIdentifier},
+		{in: "1.0+a..b", err: ErrVersionBadIdentifier},
+		{in: "1.0-a_b", err: ErrVersionBadIdentifier},
+	}
+
+	for _, testCase := range testCases {
+		got, err := ParseVersion(testCase.in)
+		if testCase.err != nil {
+			var versionErr *VersionError
+			if !errors.As(err, &versionErr) || versionErr.Version != testCase.in {
+				t.Errorf("%q: expected *VersionError, got %v", testCase.in, err)
+			}
+			if !errors.Is(err, testCase.err) {
+				t.Errorf("%q: expected error %q, got %v", testCase.in, testCase.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", testCase.in, err)
+			continue
+		}
+		if got != testCase.want {
+			t.Errorf("%q: want %+v, got %+v", testCase.in, testCase.want, got)
+		}
+		if got.String() != testCase.in {
+			t.Errorf("%q: String() returned %q", testCase.in, got.String())
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	// Each group of equal versions is less than the groups after it.
+	ordered := [][]string{
+		{"0.9"},
+		{"1.0.0-alpha"},
+		{"1.0.0-alpha.1"},
+		{"1.0.0-alpha.beta"},
+		{"1.0.0-beta"},
+		{"1.0.0-beta.2"},
+		{"1.0.0-beta.11"},
+		{"1.0.0-rc.1"},
+		{"1", "1.0", "1.0.0", "1.0.0+build.1"},
+		{"1.2"},
+		{"2"},
+		{"10"},
+	}
+
+	for i, group := range ordered {
+		for j, other := range ordered {
+			for _, a := range group {
+				for _, b := range other {
+					want := compareInt64(int64(i), int64(j))
+					got, err := CompareVersions(a, b)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != want {
+						t.Errorf("CompareVersions(%q, %q): want %d, got %d", a, b, want, got)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := CompareVersions("1.0", "x"); err == nil {
+		t.Errorf("expected error comparing invalid version")
+	}
+}
+
+func TestParseApiLevel(t *testing.T) {
+	if level, err := ParseApiLevel("30"); err != nil || level != 30 {
+		t.Errorf("want 30, got %d, %v", level, err)
+	}
+	for _, s := range []string{"30.1", "30-beta", "S"} {
+		if _, err := ParseApiLevel(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+	if _, err := ParseApiLevel("30.1"); !errors.Is(err, ErrVersionNotApiLevel) {
+		t.Errorf("expected ErrVersionNotApiLevel, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// The kinds of proptools.PropertySource recorded when property provenance is tracked, see
+// Context.SetTrackPropertyProvenance.
+const (
+	// PropertySourceFile is a property set in a Blueprints file.  The name of the source is the
+	// path of the Blueprints file.
+	PropertySourceFile = "file"
+
+	// PropertySourceLoadHook is a property changed by the load hooks of the module.
+	PropertySourceLoadHook = "load hook"
+
+	// PropertySourceCreated is a property set by the CreateModule call that created the module.
+	// The name of the source is the name of the module that called CreateModule.
+	PropertySourceCreated = "created"
+
+	// PropertySourceDefaults is a property inherited from a defaults module.  The name of the
+	// source is the name of the defaults module.
+	PropertySourceDefaults = "defaults"
+
+	// PropertySourceMutator is a property changed by a mutator.  The name of the source is the
+	// name of the mutator.
+	PropertySourceMutator = "mutator"
+
+	// PropertySourceOverride is a property set by a property override, see
+	// Context.AddPropertyOverrides.  The name of the source is the file the override was parsed
+	// from.
+	PropertySourceOverride = "override"
+)
+
+// SetTrackPropertyProvenance sets whether the Context records, for every property of every
+// module, the sequence of sources that changed its value: the Blueprints file, load hooks,
+// defaults modules, property overrides and mutators.  The sources can be retrieved with
+// BaseModuleContext.PropertyProvenance or written out with WritePropertyProvenance.  Properties
+// are compared after every step, so tracking is slow and is disabled by default.  It must be
+// called before the Blueprints files are parsed.
+func (c *Context) SetTrackPropertyProvenance(track bool) {
+	c.checkRegistration("SetTrackPropertyProvenance")
+
+	c.trackPropertyProvenance = track
+}
+
+// newPropertyProvenance returns a proptools.Provenance that tracks the properties of a module
+// created by factory starting from the values set by the factory.
+func newPropertyProvenance(factory ModuleFactory) *proptools.Provenance {
+	logicModule, properties := factory()
+	// The module is only used for its initial property values, drop any load hooks the factory
+	// registered for it.
+	pendingHooks.Delete(logicModule)
+	return proptools.NewProvenance(properties...)
+}
+
+// recordPropertyProvenance attributes the properties of the module that changed since the last
+// call to a source of the given kind and name.  If posModule is not nil, the position of each
+// property in posModule is recorded with it.
+func (c *Context) recordPropertyProvenance(module *moduleInfo, kind, name string, posModule *moduleInfo) {
+	if module.provenance == nil {
+		return
+	}
+	module.provenance.Record(func(property string) proptools.PropertySource {
+		source := proptools.PropertySource{Kind: kind, Name: name}
+		if posModule != nil {
+			if pos, ok := posModule.propertyPos[property]; ok {
+				source.Pos = pos
+			} else {
+				source.Pos = posModule.pos
+			}
+		}
+		return source
+	}, module.properties...)
+}
+
+func (c *Context) propertyProvenance(module *moduleInfo, property string) []proptools.PropertySource {
+	if module.provenance == nil {
+		return nil
+	}
+	return module.provenance.Sources(property)
+}
+
+type jsonPropertySource struct {
+	Kind  string      `json:"kind"`
+	Name  string      `json:"name,omitempty"`
+	Pos   string      `json:"pos,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+type jsonPropertyProvenanceModule struct {
+	Name       string                          `json:"name"`
+	Variant    string                          `json:"variant,omitempty"`
+	Type       string                          `json:"type"`
+	Properties map[string][]jsonPropertySource `json:"properties"`
+}
+
+type jsonPropertyProvenance struct {
+	Modules []jsonPropertyProvenanceModule `json:"modules"`
+}
+
+// WritePropertyProvenance writes a JSON description of the sources of the properties of every
+// module to w, see SetTrackPropertyProvenance.  Modules are sorted by name and variant, and only
+// properties that were changed by at least one source are included.
+func (c *Context) WritePropertyProvenance(w io.Writer) error {
+	provenance := jsonPropertyProvenance{Modules: []jsonPropertyProvenanceModule{}}
+	for _, group := range c.sortedModuleGroups() {
+		for _, moduleOrAlias := range group.modules {
+			module := moduleOrAlias.module()
+			if module == nil || module.provenance == nil {
+				continue
+			}
+			jsonModule := jsonPropertyProvenanceModule{
+				Name:       module.Name(),
+				Variant:    module.variant.name,
+				Type:       module.typeName,
+				Properties: make(map[string][]jsonPropertySource),
+			}
+			for _, property := range module.provenance.Properties() {
+				for _, source := range module.provenance.Sources(property) {
+					jsonSource := jsonPropertySource{
+						Kind:  source.Kind,
+						Name:  source.Name,
+						Value: source.Value,
+					}
+					if source.Pos.IsValid() {
+						jsonSource.Pos = source.Pos.String()
+					}
+					jsonModule.Properties[property] = append(jsonModule.Properties[property], jsonSource)
+				}
+			}
+			provenance.Modules = append(provenance.Modules, jsonModule)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(provenance)
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func newProvenanceTestModule() (Module, []interface{}) {
+	module, properties := newDefaultableTestModule()
+	m := module.(*defaultableTestModule)
+	AddLoadHook(m, func(ctx LoadHookContext) {
+		if ctx.ModuleName() == "m" {
+			m.properties.Srcs = append(m.properties.Srcs, "hook.c")
+		}
+	})
+	return module, properties
+}
+
+func TestPropertyProvenance(t *testing.T) {
+	var sources []proptools.PropertySource
+
+	ctx := NewContext()
+	ctx.SetTrackPropertyProvenance(true)
+	ctx.RegisterModuleType("test_module", newProvenanceTestModule)
+	ctx.RegisterDefaultsModuleType("test_defaults", newDefaultableTestModule)
+	ctx.RegisterBottomUpMutator("append", func(ctx BottomUpMutatorContext) {
+		if m, ok := ctx.Module().(*defaultableTestModule); ok && ctx.ModuleName() == "m" {
+			m.properties.Srcs = append(m.properties.Srcs, "mutator.c")
+		}
+	})
+	ctx.RegisterBottomUpMutator("query", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "m" {
+			sources = ctx.PropertyProvenance("srcs")
+		}
+	})
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+test_defaults {
+    name: "d",
+    srcs: ["d.c"],
+}
+
+test_module {
+    name: "m",
+    defaults: ["d"],
+    srcs: ["m.c"],
+}
+`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	type source struct {
+		Kind, Name, Pos string
+		Value           []string
+	}
+	var got []source
+	for _, s := range sources {
+		pos := ""
+		if s.Pos.IsValid() {
+			pos = s.Pos.String()
+		}
+		got = append(got, source{s.Kind, s.Name, pos, s.Value.([]string)})
+	}
+	want := []source{
+		{PropertySourceFile, "Blueprints", "Blueprints:10:9", []string{"m.c"}},
+		{PropertySourceLoadHook, "", "", []string{"m.c", "hook.c"}},
+		{PropertySourceDefaults, "d", "Blueprints:4:9", []string{"d.c", "m.c", "hook.c"}},
+		{PropertySourceMutator, "append", "", []string{"d.c", "m.c", "hook.c", "mutator.c"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect sources:\nwant %q\n got %q", want, got)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WritePropertyProvenance(buf); err != nil {
+		t.Fatal(err)
+	}
+	var provenance jsonPropertyProvenance
+	if err := json.Unmarshal(buf.Bytes(), &provenance); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, buf.String())
+	}
+	var modules []string
+	var kinds []string
+	for _, module := range provenance.Modules {
+		modules = append(modules, module.Name)
+		if module.Name == "m" {
+			for _, s := range module.Properties["srcs"] {
+				kinds = append(kinds, s.Kind)
+			}
+		}
+	}
+	if want := []string{"d", "m"}; !reflect.DeepEqual(modules, want) {
+		t.Errorf("want modules %q, got %q", want, modules)
+	}
+	wantKinds := []string{PropertySourceFile, PropertySourceLoadHook, PropertySourceDefaults, PropertySourceMutator}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Errorf("want srcs source kinds %q, got %q", wantKinds, kinds)
+	}
+}
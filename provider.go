@@ -47,6 +47,9 @@ type provider struct {
 	typ     reflect.Type
 	zero    interface{}
 	mutator string
+
+	// set by SetProviderClone, used to copy the value into new variants
+	clone func(interface{}) interface{}
 }
 
 type ProviderKey *provider
@@ -60,8 +63,7 @@ var providerRegistry []ProviderKey
 // inside GenerateBuildActions for the module, and to get the value from GenerateBuildActions from
 // any module later in the build graph.
 //
-// Once Go has generics the exampleValue parameter will not be necessary:
-// NewProvider(type T)() ProviderKey(T)
+// NewTypedProvider returns a key that checks the type of the value at compile time instead.
 func NewProvider(exampleValue interface{}) ProviderKey {
 	return NewMutatorProvider(exampleValue, "")
 }
@@ -74,12 +76,14 @@ func NewProvider(exampleValue interface{}) ProviderKey {
 // module later in the build graph in the same mutator, or any module in a later mutator or during
 // GenerateBuildActions.
 //
-// Once Go has generics the exampleValue parameter will not be necessary:
-// NewMutatorProvider(type T)(mutator string) ProviderKey(T)
+// NewTypedMutatorProvider returns a key that checks the type of the value at compile time
+// instead.
 func NewMutatorProvider(exampleValue interface{}, mutator string) ProviderKey {
 	checkCalledFromInit()
+	return newProvider(reflect.TypeOf(exampleValue), mutator)
+}
 
-	typ := reflect.TypeOf(exampleValue)
+func newProvider(typ reflect.Type, mutator string) ProviderKey {
 	zero := reflect.Zero(typ).Interface()
 
 	provider := &provider{
@@ -94,6 +98,91 @@ func NewMutatorProvider(exampleValue interface{}, mutator string) ProviderKey {
 	return provider
 }
 
+// A TypedProviderKey is a ProviderKey for values of type T.  It is used with the SetProvider and
+// ModuleProvider functions, which check the type of the value at compile time instead of requiring
+// a type assertion by the caller.
+type TypedProviderKey[T any] struct {
+	key ProviderKey
+}
+
+// Key returns the untyped ProviderKey, which can be passed to the methods of the contexts that
+// take a ProviderKey.
+func (k TypedProviderKey[T]) Key() ProviderKey {
+	return k.key
+}
+
+// NewTypedProvider returns a TypedProviderKey for values of type T that can be set during
+// GenerateBuildActions.  See NewProvider.
+func NewTypedProvider[T any]() TypedProviderKey[T] {
+	checkCalledFromInit()
+	return TypedProviderKey[T]{newProvider(reflect.TypeOf((*T)(nil)).Elem(), "")}
+}
+
+// NewTypedMutatorProvider returns a TypedProviderKey for values of type T that can be set during
+// the given mutator.  See NewMutatorProvider.
+func NewTypedMutatorProvider[T any](mutator string) TypedProviderKey[T] {
+	checkCalledFromInit()
+	return TypedProviderKey[T]{newProvider(reflect.TypeOf((*T)(nil)).Elem(), mutator)}
+}
+
+// SetProviderClone registers a function that returns a deep copy of a value of the provider.
+// When a mutator splits a module into variants after the provider was set, each new variant other
+// than the first receives a copy made by clone instead of sharing the value, so that modifying the
+// value of one variant doesn't affect the others.  It must be called from an init function.
+func SetProviderClone(provider ProviderKey, clone func(interface{}) interface{}) {
+	checkCalledFromInit()
+	provider.clone = clone
+}
+
+// WithClone registers a function that returns a deep copy of a value of the provider, see
+// SetProviderClone, and returns the key.  It must be called from an init function, usually as
+// part of the initialization of the variable that holds the key.
+func (k TypedProviderKey[T]) WithClone(clone func(T) T) TypedProviderKey[T] {
+	checkCalledFromInit()
+	k.key.clone = func(value interface{}) interface{} {
+		return clone(value.(T))
+	}
+	return k
+}
+
+// OtherModuleProviderContext is implemented by the contexts that can read the providers of other
+// modules: BaseModuleContext, SingletonContext and Context.
+type OtherModuleProviderContext interface {
+	otherModuleProvider(m Module, provider ProviderKey) (interface{}, bool)
+}
+
+var _ OtherModuleProviderContext = BaseModuleContext(nil)
+var _ OtherModuleProviderContext = SingletonContext(nil)
+var _ OtherModuleProviderContext = (*Context)(nil)
+
+// SetProviderContext is implemented by the contexts that can set the providers of the current
+// module: BaseModuleContext and its extensions.
+type SetProviderContext interface {
+	SetProvider(provider ProviderKey, value interface{})
+}
+
+var _ SetProviderContext = BaseModuleContext(nil)
+
+// ModuleProvider returns the value, if any, of the provider for a module.  The second return value
+// is false and the first is the zero value of T if the provider was not set.  It has the same
+// restrictions on when it can be called as BaseModuleContext.OtherModuleProvider, and the return
+// value should always be considered read-only.
+func ModuleProvider[T any](ctx OtherModuleProviderContext, module Module, key TypedProviderKey[T]) (T, bool) {
+	value, ok := ctx.otherModuleProvider(module, key.key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return value.(T), true
+}
+
+// SetProvider sets the value of the provider for the current module.  It has the same
+// restrictions on when it can be called as BaseModuleContext.SetProvider, and the value should
+// not be modified after it is set.
+func SetProvider[T any](ctx SetProviderContext, key TypedProviderKey[T], value T) {
+	ctx.SetProvider(key.key, value)
+}
+
 // initProviders fills c.providerMutators with the *mutatorInfo associated with each provider ID,
 // if any.
 func (c *Context) initProviders() {
@@ -110,9 +199,6 @@ func (c *Context) initProviders() {
 // setProvider sets the value for a provider on a moduleInfo.  Verifies that it is called during the
 // appropriate mutator or GenerateBuildActions pass for the provider, and that the value is of the
 // appropriate type.  The value should not be modified after being passed to setProvider.
-//
-// Once Go has generics the value parameter can be typed:
-// setProvider(type T)(m *moduleInfo, provider ProviderKey(T), value T)
 func (c *Context) setProvider(m *moduleInfo, provider ProviderKey, value interface{}) {
 	if provider.mutator == "" {
 		if !m.startedGenerateBuildActions {
@@ -127,6 +213,11 @@ func (c *Context) setProvider(m *moduleInfo, provider ProviderKey, value interfa
 		if expectedMutator == nil {
 			panic(fmt.Sprintf("Can't set value of provider %s associated with unregistered mutator %s",
 				provider.typ, provider.mutator))
+		} else if m.splitModules != nil {
+			// The variants already received a copy of the providers of the module, so a value set
+			// on the original module would be silently dropped.
+			panic(fmt.Sprintf("Can't set value of provider %s on %s after it was split into variants, "+
+				"use SetVariationProvider to set it on the new variants", provider.typ, m))
 		} else if c.mutatorFinishedForModule(expectedMutator, m) {
 			panic(fmt.Sprintf("Can't set value of provider %s after mutator %s finished",
 				provider.typ, provider.mutator))
@@ -136,7 +227,8 @@ func (c *Context) setProvider(m *moduleInfo, provider ProviderKey, value interfa
 		}
 	}
 
-	if typ := reflect.TypeOf(value); typ != provider.typ {
+	if typ := reflect.TypeOf(value); typ != provider.typ &&
+		!(provider.typ.Kind() == reflect.Interface && typ != nil && typ.Implements(provider.typ)) {
 		panic(fmt.Sprintf("Value for provider has incorrect type, wanted %s, got %s",
 			provider.typ, typ))
 	}
@@ -152,14 +244,24 @@ func (c *Context) setProvider(m *moduleInfo, provider ProviderKey, value interfa
 	m.providers[provider.id] = value
 }
 
+// providerModule returns the moduleInfo of a Module passed to one of the methods that read the
+// provider values of other modules.  It panics if the Module was not created by this Context,
+// for example if it was allocated directly by the caller instead of being obtained from one of
+// the visit methods, which would otherwise fail later with a less useful nil dereference.
+func (c *Context) providerModule(logicModule Module, provider ProviderKey) *moduleInfo {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		panic(fmt.Sprintf("Can't get value of provider %s of %T, which is not a module of this Context",
+			provider.typ, logicModule))
+	}
+	return module
+}
+
 // provider returns the value, if any, for a given provider for a module.  Verifies that it is
 // called after the appropriate mutator or GenerateBuildActions pass for the provider on the module.
 // If the value for the provider was not set it returns the zero value of the type of the provider,
 // which means the return value can always be type-asserted to the type of the provider.  The return
 // value should always be considered read-only.
-//
-// Once Go has generics the return value can be typed and the type assert by callers can be dropped:
-// provider(type T)(m *moduleInfo, provider ProviderKey(T)) T
 func (c *Context) provider(m *moduleInfo, provider ProviderKey) (interface{}, bool) {
 	if provider.mutator == "" {
 		if !m.finishedGenerateBuildActions {
@@ -183,6 +285,85 @@ func (c *Context) provider(m *moduleInfo, provider ProviderKey) (interface{}, bo
 	return provider.zero, false
 }
 
+// copyProviders returns the provider values of a new variant of a module.  If clone is true the
+// values of providers that have a clone function are deep copied.
+func copyProviders(providers []interface{}, clone bool) []interface{} {
+	providers = append([]interface{}(nil), providers...)
+	if clone {
+		for id, value := range providers {
+			if value != nil && providerRegistry[id].clone != nil {
+				providers[id] = providerRegistry[id].clone(value)
+			}
+		}
+	}
+	return providers
+}
+
+// checkSharedProviders reports a warning for each provider value of a module that was just split
+// into variants that shares mutable state between the variants because the provider has no clone
+// function.  See SetDetectSharedProviders.
+func (c *Context) checkSharedProviders(module *moduleInfo, mutatorName string) {
+	for id, value := range module.providers {
+		if value == nil || providerRegistry[id].clone != nil {
+			continue
+		}
+		if path := mutableStatePath(reflect.ValueOf(value), ""); path != "" {
+			c.addWarning(WarningClassSharedProvider, &ModuleError{
+				BlueprintError: BlueprintError{
+					Err: fmt.Errorf("value of provider %s shares %s between the variants created "+
+						"by mutator %s, register a clone function with SetProviderClone",
+						providerRegistry[id].typ, path, mutatorName),
+					Pos: module.pos,
+				},
+				module: module,
+			})
+		}
+	}
+}
+
+// mutableStatePath returns a description of the first pointer, map or non-empty slice found in v,
+// or an empty string if v doesn't contain any references that would be shared by a shallow copy.
+func mutableStatePath(v reflect.Value, path string) string {
+	describe := func() string {
+		if path == "" {
+			return v.Type().String()
+		}
+		return fmt.Sprintf("%s (%s)", path, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan:
+		if !v.IsNil() {
+			return describe()
+		}
+	case reflect.Slice:
+		if v.Cap() > 0 {
+			return describe()
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			return mutableStatePath(v.Elem(), path)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fieldPath := v.Type().Field(i).Name
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			if found := mutableStatePath(v.Field(i), fieldPath); found != "" {
+				return found
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if found := mutableStatePath(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
+
 func (c *Context) mutatorFinishedForModule(mutator *mutatorInfo, m *moduleInfo) bool {
 	if c.finishedMutators[mutator] {
 		// mutator pass finished for all modules
@@ -188,6 +188,8 @@ type invalidProviderUsageTestModule struct {
 		Early_module_get_of_build_actions_provider  bool
 
 		Duplicate_set bool
+
+		Get_of_unknown_module bool
 	}
 }
 
@@ -266,6 +268,11 @@ func (i *invalidProviderUsageTestModule) GenerateBuildActions(ctx ModuleContext)
 		ctx.SetProvider(invalidProviderUsageGenerateBuildActionsInfoProvider, invalidProviderUsageGenerateBuildActionsInfo(""))
 		ctx.SetProvider(invalidProviderUsageGenerateBuildActionsInfoProvider, invalidProviderUsageGenerateBuildActionsInfo(""))
 	}
+	if i.properties.Get_of_unknown_module {
+		// A GenerateBuildActions trying to get the value of a provider on a module that wasn't
+		// created by the Context.
+		_ = ctx.OtherModuleProvider(&invalidProviderUsageTestModule{}, invalidProviderUsageGenerateBuildActionsInfoProvider)
+	}
 }
 
 func TestInvalidProvidersUsage(t *testing.T) {
@@ -410,6 +417,11 @@ func TestInvalidProvidersUsage(t *testing.T) {
 			module:   "module_under_test",
 			panicMsg: "Value of provider blueprint.invalidProviderUsageGenerateBuildActionsInfo is already set",
 		},
+		{
+			prop:     "get_of_unknown_module",
+			module:   "module_under_test",
+			panicMsg: "Can't get value of provider blueprint.invalidProviderUsageGenerateBuildActionsInfo of *blueprint.invalidProviderUsageTestModule, which is not a module of this Context",
+		},
 	}
 
 	for _, tt := range tests {
@@ -418,3 +430,246 @@ func TestInvalidProvidersUsage(t *testing.T) {
 		})
 	}
 }
+
+var splitProviderTestProvider = NewMutatorProvider("", "split_provider")
+
+func TestSetProviderAfterSplit(t *testing.T) {
+	run := func(t *testing.T, mutator func(ctx BottomUpMutatorContext)) []error {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("test_module", newModuleCtxTestModule)
+		ctx.RegisterBottomUpMutator("split_provider", mutator)
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`test_module { name: "a" }`),
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		return errs
+	}
+
+	t.Run("variations", func(t *testing.T) {
+		errs := run(t, func(ctx BottomUpMutatorContext) {
+			for _, variant := range ctx.CreateVariations("x", "y") {
+				ctx.SetVariationProvider(variant, splitProviderTestProvider, "value")
+			}
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %s", errs)
+		}
+	})
+
+	t.Run("original module", func(t *testing.T) {
+		errs := run(t, func(ctx BottomUpMutatorContext) {
+			ctx.CreateVariations("x", "y")
+			ctx.SetProvider(splitProviderTestProvider, "value")
+		})
+		want := `Can't set value of provider string on module "a" after it was split into variants, ` +
+			"use SetVariationProvider to set it on the new variants"
+		if len(errs) != 1 {
+			t.Fatalf("expected a single error, got %q", errs)
+		}
+		if panicErr, ok := errs[0].(panicError); !ok || panicErr.panic != want {
+			t.Errorf("expected panic %q, got %q", want, errs[0])
+		}
+	})
+}
+
+type typedProviderTestInfo struct {
+	Srcs []string
+}
+
+type typedProviderTestInterface interface {
+	Name() string
+}
+
+type typedProviderTestName string
+
+func (n typedProviderTestName) Name() string { return string(n) }
+
+var typedProviderTestInfoProvider = NewTypedProvider[typedProviderTestInfo]()
+var typedProviderTestMutatorProvider = NewTypedMutatorProvider[[]string]("typed_provider_mutator")
+var typedProviderTestInterfaceProvider = NewTypedProvider[typedProviderTestInterface]()
+
+type typedProviderTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+	}
+	depSrcs []string
+}
+
+func newTypedProviderTestModule() (Module, []interface{}) {
+	m := &typedProviderTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *typedProviderTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.VisitDirectDeps(func(dep Module) {
+		info, _ := ModuleProvider(ctx, dep, typedProviderTestInfoProvider)
+		m.depSrcs = append(m.depSrcs, info.Srcs...)
+	})
+
+	mutatorSrcs, _ := ModuleProvider(ctx, ctx.Module(), typedProviderTestMutatorProvider)
+	SetProvider(ctx, typedProviderTestInfoProvider, typedProviderTestInfo{Srcs: mutatorSrcs})
+	SetProvider[typedProviderTestInterface](ctx, typedProviderTestInterfaceProvider, typedProviderTestName(ctx.ModuleName()))
+}
+
+func (m *typedProviderTestModule) DynamicDependencies(ctx DynamicDependerModuleContext) []string {
+	return m.properties.Deps
+}
+
+func typedProviderTestMutator(ctx BottomUpMutatorContext) {
+	SetProvider(ctx, typedProviderTestMutatorProvider, []string{ctx.ModuleName() + ".c"})
+}
+
+func TestTypedProviders(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("typed_provider_module", newTypedProviderTestModule)
+	ctx.RegisterBottomUpMutator("typed_provider_mutator", typedProviderTestMutator)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			typed_provider_module {
+				name: "A",
+				deps: ["B", "C"],
+			}
+
+			typed_provider_module {
+				name: "B",
+			}
+
+			typed_provider_module {
+				name: "C",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	a := ctx.moduleGroupFromName("A", nil).moduleByVariantName("").logicModule.(*typedProviderTestModule)
+	if g, w := a.depSrcs, []string{"B.c", "C.c"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected A.depSrcs %q, got %q", w, g)
+	}
+
+	info, ok := ModuleProvider(ctx, a, typedProviderTestInfoProvider)
+	if !ok || !reflect.DeepEqual(info.Srcs, []string{"A.c"}) {
+		t.Errorf("expected A info %q, got %q, %v", []string{"A.c"}, info.Srcs, ok)
+	}
+
+	name, ok := ModuleProvider(ctx, a, typedProviderTestInterfaceProvider)
+	if !ok || name.Name() != "A" {
+		t.Errorf("expected A interface provider to be set")
+	}
+
+	if got := ctx.ModuleProvider(a, typedProviderTestInfoProvider.Key()).(typedProviderTestInfo); !reflect.DeepEqual(got, info) {
+		t.Errorf("untyped ModuleProvider returned %v, want %v", got, info)
+	}
+}
+
+type cloneProviderTestInfo struct {
+	Values []string
+}
+
+var cloneProviderTestClonedProvider = NewTypedMutatorProvider[*cloneProviderTestInfo]("clone_provider_set").
+	WithClone(func(info *cloneProviderTestInfo) *cloneProviderTestInfo {
+		return &cloneProviderTestInfo{Values: append([]string(nil), info.Values...)}
+	})
+var cloneProviderTestSharedProvider = NewTypedMutatorProvider[*cloneProviderTestInfo]("clone_provider_set")
+
+func TestProviderClone(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("clone_provider_set", func(ctx BottomUpMutatorContext) {
+		SetProvider(ctx, cloneProviderTestClonedProvider, &cloneProviderTestInfo{Values: []string{"x"}})
+		SetProvider(ctx, cloneProviderTestSharedProvider, &cloneProviderTestInfo{Values: []string{"x"}})
+	})
+	ctx.RegisterBottomUpMutator("clone_provider_split", func(ctx BottomUpMutatorContext) {
+		ctx.CreateVariations("a", "b")
+	})
+	ctx.SetDetectSharedProviders(true)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`foo_module { name: "A" }`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	group := ctx.moduleGroupFromName("A", nil)
+	a := group.moduleByVariantName("a").logicModule
+	b := group.moduleByVariantName("b").logicModule
+
+	clonedA, _ := ModuleProvider(ctx, a, cloneProviderTestClonedProvider)
+	clonedB, _ := ModuleProvider(ctx, b, cloneProviderTestClonedProvider)
+	if clonedA == clonedB {
+		t.Errorf("expected the variants to have separate copies of the provider with a clone function")
+	}
+	if !reflect.DeepEqual(clonedA, clonedB) {
+		t.Errorf("expected the copies to be equal, got %v and %v", clonedA, clonedB)
+	}
+
+	sharedA, _ := ModuleProvider(ctx, a, cloneProviderTestSharedProvider)
+	sharedB, _ := ModuleProvider(ctx, b, cloneProviderTestSharedProvider)
+	if sharedA != sharedB {
+		t.Errorf("expected the variants to share the provider without a clone function")
+	}
+
+	var warnings []string
+	for _, warning := range ctx.Warnings() {
+		warnings = append(warnings, warning.Error())
+	}
+	want := []string{`Blueprints:1:1: module "A": value of provider *blueprint.cloneProviderTestInfo shares ` +
+		`*blueprint.cloneProviderTestInfo between the variants created by mutator clone_provider_split, ` +
+		`register a clone function with SetProviderClone`}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("incorrect warnings\nwant: %q\n got: %q", want, warnings)
+	}
+}
+
+func TestMutableStatePath(t *testing.T) {
+	type inner struct {
+		Name string
+		Deps map[string]bool
+	}
+	type outer struct {
+		Count int
+		Inner inner
+		List  [2][]string
+	}
+
+	testCases := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"string", "foo", ""},
+		{"nil pointer", (*outer)(nil), ""},
+		{"empty struct", outer{}, ""},
+		{"pointer", &outer{}, "*blueprint.outer"},
+		{"map field", outer{Inner: inner{Deps: map[string]bool{}}}, "Inner.Deps (map[string]bool)"},
+		{"array of slices", outer{List: [2][]string{nil, {"a"}}}, "List[1] ([]string)"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := mutableStatePath(reflect.ValueOf(testCase.value), ""); got != testCase.want {
+				t.Errorf("want %q, got %q", testCase.want, got)
+			}
+		})
+	}
+}
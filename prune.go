@@ -0,0 +1,103 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+// A RootModulePredicate returns true if a variant of a module is a root of the build, see
+// Context.SetRootModulePredicate.  variant is the name of the variant, as returned by
+// Context.ModuleSubDir.
+type RootModulePredicate func(module Module, variant string) bool
+
+// PruneStats describes the result of pruning the module variants that are not reachable from any
+// root, see Context.SetRootModulePredicate.
+type PruneStats struct {
+	// Roots is the number of variants that the RootModulePredicate selected.
+	Roots int
+
+	// Reachable is the number of variants that are reachable from the roots, including the roots.
+	Reachable int
+
+	// Pruned is the number of variants that were pruned.  Disabled variants are not counted.
+	Pruned int
+}
+
+// SetRootModulePredicate enables pruning of the module variants that no root depends on, directly
+// or transitively.  After all mutators have run, predicate is called on every enabled variant to
+// select the roots, and the variants that are not reachable from them are pruned:
+// GenerateBuildActions is not called on them and they produce no Ninja output.  Pruned variants
+// are still visited by singletons; use ModulePruned to tell them apart.  Pruning is disabled by
+// default, or if predicate is nil.  It must be called before ResolveDependencies.
+func (c *Context) SetRootModulePredicate(predicate RootModulePredicate) {
+	c.rootModulePredicate = predicate
+}
+
+// ModulePruned returns true if the module variant was pruned because it is not reachable from any
+// root, see SetRootModulePredicate.
+func (c *Context) ModulePruned(logicModule Module) bool {
+	return c.moduleInfo[logicModule].pruned
+}
+
+// PruneStats returns the number of roots, reachable variants and pruned variants found when the
+// unreachable variants were pruned, see SetRootModulePredicate.  It returns the zero PruneStats
+// if pruning is disabled or ResolveDependencies has not completed.
+func (c *Context) PruneStats() PruneStats {
+	return c.pruneStats
+}
+
+// pruneUnreachableVariants marks the enabled variants that are not reachable from the variants
+// selected by the RootModulePredicate as pruned.
+func (c *Context) pruneUnreachableVariants() {
+	c.pruneStats = PruneStats{}
+	if c.rootModulePredicate == nil {
+		return
+	}
+
+	start := c.metrics.begin()
+
+	var queue []*moduleInfo
+	reachable := make(map[*moduleInfo]bool)
+	for _, module := range c.modulesSorted {
+		module.pruned = false
+		if !module.disabled && c.rootModulePredicate(module.logicModule, module.variant.name) {
+			reachable[module] = true
+			queue = append(queue, module)
+		}
+	}
+	c.pruneStats.Roots = len(queue)
+
+	for len(queue) > 0 {
+		module := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for _, dep := range module.directDeps {
+			if !reachable[dep.module] {
+				reachable[dep.module] = true
+				queue = append(queue, dep.module)
+			}
+		}
+	}
+	c.pruneStats.Reachable = len(reachable)
+
+	for _, module := range c.modulesSorted {
+		if !module.disabled && !reachable[module] {
+			module.pruned = true
+			c.pruneStats.Pruned++
+		}
+	}
+
+	c.metrics.end(metricsPhase, "PruneUnreachableVariants", start, map[string]interface{}{
+		"roots":     c.pruneStats.Roots,
+		"reachable": c.pruneStats.Reachable,
+		"pruned":    c.pruneStats.Pruned,
+	})
+}
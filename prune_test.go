@@ -0,0 +1,128 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var pruneTestPctx = NewPackageContext("github.com/google/blueprint/prune_test")
+
+type pruneTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+	}
+	generated bool
+}
+
+func newPruneTestModule() (Module, []interface{}) {
+	m := &pruneTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *pruneTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated = true
+	ctx.Build(pruneTestPctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{ctx.ModuleName() + "_" + ctx.ModuleSubDir()},
+	})
+}
+
+func pruneTestDepsMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*pruneTestModule); ok {
+		ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+	}
+}
+
+func TestPruneUnreachableVariants(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test_module", newPruneTestModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", pruneTestDepsMutator)
+	ctx.SetRootModulePredicate(func(module Module, variant string) bool {
+		name := module.Name()
+		return name == "A" || (name == "B" && variant == "x")
+	})
+
+	// A -> C, B (x, y) -> D, E
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test_module {
+				name: "A",
+				deps: ["C"],
+			}
+
+			test_module {
+				name: "B",
+				deps: ["D"],
+			}
+
+			test_module {
+				name: "C",
+			}
+
+			test_module {
+				name: "D",
+			}
+
+			test_module {
+				name: "E",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var generated, pruned []string
+	ctx.VisitAllModules(func(module Module) {
+		id := module.Name() + ":" + ctx.ModuleSubDir(module)
+		if module.(*pruneTestModule).generated {
+			generated = append(generated, id)
+		}
+		if ctx.ModulePruned(module) {
+			pruned = append(pruned, id)
+		}
+	})
+	if want := []string{"A:", "B:x", "C:", "D:"}; !reflect.DeepEqual(generated, want) {
+		t.Errorf("want generated %q, got %q", want, generated)
+	}
+	if want := []string{"B:y", "E:"}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("want pruned %q, got %q", want, pruned)
+	}
+	if got, want := ctx.PruneStats(), (PruneStats{Roots: 2, Reachable: 4, Pruned: 2}); got != want {
+		t.Errorf("want stats %+v, got %+v", want, got)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "B_y") || strings.Contains(buf.String(), "E_") {
+		t.Errorf("unexpected output for pruned variants:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "B_x") {
+		t.Errorf("missing output for B:x:\n%s", buf.String())
+	}
+}
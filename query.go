@@ -0,0 +1,253 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// QueryDeps returns every variant of every module that the variants of the named modules depend
+// on, directly or transitively, sorted so that each module appears after all of its dependencies.
+// The variants of the named modules are not included unless they depend on each other.  If this
+// is called before PrepareBuildActions successfully completes then ErrBuildActionsNotReady is
+// returned.
+func (c *Context) QueryDeps(names ...string) ([]*GraphModule, error) {
+	return c.queryTransitive(names, func(m *GraphModule) []*GraphModule {
+		deps := make([]*GraphModule, len(m.Deps))
+		for i, dep := range m.Deps {
+			deps[i] = dep.Module
+		}
+		return deps
+	})
+}
+
+// QueryReverseDeps returns every variant of every module that depends on a variant of the named
+// modules, directly or transitively, sorted so that each module appears after all of its
+// dependencies.  If this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) QueryReverseDeps(names ...string) ([]*GraphModule, error) {
+	return c.queryTransitive(names, func(m *GraphModule) []*GraphModule {
+		return m.ReverseDeps
+	})
+}
+
+func (c *Context) queryTransitive(names []string,
+	next func(*GraphModule) []*GraphModule) ([]*GraphModule, error) {
+
+	graph, err := c.Graph()
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := queryRoots(graph, names)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[*GraphModule]bool)
+	var walk func(m *GraphModule)
+	walk = func(m *GraphModule) {
+		for _, n := range next(m) {
+			if !found[n] {
+				found[n] = true
+				walk(n)
+			}
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+
+	return filterGraph(graph, func(m *GraphModule) bool { return found[m] }), nil
+}
+
+// QuerySomePath returns one of the shortest dependency paths from a variant of the module named
+// from to a variant of the module named to, starting with the variant of from and ending with the
+// variant of to, or nil if no variant of from depends on a variant of to.  If this is called
+// before PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) QuerySomePath(from, to string) ([]*GraphModule, error) {
+	graph, err := c.Graph()
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := queryRoots(graph, []string{from})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := queryRoots(graph, []string{to}); err != nil {
+		return nil, err
+	}
+
+	// Breadth first search from all the variants of from, so that the first variant of to that is
+	// reached is at the end of a shortest path.
+	prev := make(map[*GraphModule]*GraphModule)
+	queue := append([]*GraphModule(nil), roots...)
+	for _, root := range roots {
+		prev[root] = nil
+	}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		if m.Name == to {
+			var path []*GraphModule
+			for ; m != nil; m = prev[m] {
+				path = append([]*GraphModule{m}, path...)
+			}
+			return path, nil
+		}
+		for _, dep := range m.Deps {
+			if _, seen := prev[dep.Module]; !seen {
+				prev[dep.Module] = m
+				queue = append(queue, dep.Module)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// QueryFilter selects modules in Context.QueryModules.
+type QueryFilter struct {
+	// ModuleTypes, if not empty, limits the result to modules with one of the listed module types.
+	ModuleTypes []string
+
+	// Properties, if not empty, limits the result to modules that have all of the listed
+	// properties set to the given values.  Properties in nested structs are named with dots, for
+	// example "target.host.enabled".  A list of strings property matches if it contains the value,
+	// other properties match if their value formatted as in a Blueprints file without quotes is
+	// equal to the value.
+	Properties map[string]string
+}
+
+// QueryModules returns every variant of every module that matches the filter, sorted so that each
+// module appears after all of its dependencies.  If this is called before PrepareBuildActions
+// successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) QueryModules(filter QueryFilter) ([]*GraphModule, error) {
+	graph, err := c.Graph()
+	if err != nil {
+		return nil, err
+	}
+
+	moduleTypes := make(map[string]bool, len(filter.ModuleTypes))
+	for _, t := range filter.ModuleTypes {
+		moduleTypes[t] = true
+	}
+
+	return filterGraph(graph, func(m *GraphModule) bool {
+		if len(moduleTypes) > 0 && !moduleTypes[m.Type] {
+			return false
+		}
+		for property, value := range filter.Properties {
+			if !propertyMatches(m.info.properties, property, value) {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// queryRoots returns all the variants of the named modules.
+func queryRoots(graph []*GraphModule, names []string) ([]*GraphModule, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	roots := filterGraph(graph, func(m *GraphModule) bool { return wanted[m.Name] })
+
+	for _, root := range roots {
+		delete(wanted, root.Name)
+	}
+	for _, name := range names {
+		if wanted[name] {
+			return nil, fmt.Errorf("module %q not found", name)
+		}
+	}
+
+	return roots, nil
+}
+
+func filterGraph(graph []*GraphModule, include func(*GraphModule) bool) []*GraphModule {
+	var ret []*GraphModule
+	for _, m := range graph {
+		if include(m) {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// propertyMatches returns true if one of the property structs has the named property set to value.
+func propertyMatches(properties []interface{}, property, value string) bool {
+	path := strings.Split(property, ".")
+
+	var match func(v reflect.Value, path []string) bool
+	match = func(v reflect.Value, path []string) bool {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return false
+			}
+			v = v.Elem()
+		}
+
+		if len(path) == 0 {
+			switch v.Kind() {
+			case reflect.String:
+				return v.String() == value
+			case reflect.Bool, reflect.Int, reflect.Int64:
+				return fmt.Sprint(v.Interface()) == value
+			case reflect.Slice:
+				for i := 0; i < v.Len(); i++ {
+					if item := v.Index(i); item.Kind() == reflect.String && item.String() == value {
+						return true
+					}
+				}
+			}
+			return false
+		}
+
+		if v.Kind() != reflect.Struct {
+			return false
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Anonymous || field.Name == "BlueprintEmbed" {
+				if match(v.Field(i), path) {
+					return true
+				}
+			} else if proptools.PropertyNameForField(field.Name) == path[0] {
+				if match(v.Field(i), path[1:]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, p := range properties {
+		if match(reflect.ValueOf(p), path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	// A -> B -> C -> D, A -> D, E
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "A",
+				deps: ["B", "D"],
+			}
+
+			provider_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "C",
+				deps: ["D"],
+			}
+
+			provider_module {
+				name: "D",
+			}
+
+			foo_module {
+				name: "E",
+				foo: "bar",
+				deps: ["x"],
+			}
+		`),
+	})
+
+	if _, err := ctx.QueryDeps("A"); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+
+	ctx.SetAllowMissingDependencies(true)
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	ids := func(modules []*GraphModule, sorted bool) []string {
+		var ret []string
+		for _, m := range modules {
+			ret = append(ret, m.Name+":"+m.Variant)
+		}
+		if sorted {
+			sort.Strings(ret)
+		}
+		return ret
+	}
+
+	check := func(name string, modules []*GraphModule, err error, sorted bool, want []string) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			return
+		}
+		if g := ids(modules, sorted); !reflect.DeepEqual(g, want) {
+			t.Errorf("%s: want %q, got %q", name, want, g)
+		}
+	}
+
+	modules, err := ctx.QueryDeps("A")
+	check("deps(A)", modules, err, true, []string{"B:", "C:", "D:"})
+
+	modules, err = ctx.QueryDeps("C")
+	check("deps(C)", modules, err, false, []string{"D:"})
+
+	modules, err = ctx.QueryReverseDeps("C")
+	check("rdeps(C)", modules, err, true, []string{"A:", "B:"})
+
+	modules, err = ctx.QuerySomePath("A", "D")
+	check("somepath(A, D)", modules, err, false, []string{"A:", "D:"})
+
+	modules, err = ctx.QuerySomePath("B", "D")
+	check("somepath(B, D)", modules, err, false, []string{"B:", "C:", "D:"})
+
+	modules, err = ctx.QuerySomePath("D", "A")
+	check("somepath(D, A)", modules, err, false, nil)
+
+	modules, err = ctx.QueryModules(QueryFilter{ModuleTypes: []string{"foo_module"}})
+	check("filter(type=foo_module)", modules, err, false, []string{"E:"})
+
+	modules, err = ctx.QueryModules(QueryFilter{Properties: map[string]string{"deps": "D"}})
+	check("filter(deps=D)", modules, err, true, []string{"A:", "C:"})
+
+	modules, err = ctx.QueryModules(QueryFilter{Properties: map[string]string{"foo": "bar", "deps": "x"}})
+	check("filter(foo=bar, deps=x)", modules, err, false, []string{"E:"})
+
+	if _, err := ctx.QueryDeps("missing"); err == nil || err.Error() != `module "missing" not found` {
+		t.Errorf("expected module not found error, got %v", err)
+	}
+}
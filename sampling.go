@@ -0,0 +1,84 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// SampleModules returns k module variants chosen deterministically from the resolved dependency
+// graph, so that checks that are too expensive to run on every module, for example expanding
+// and verifying every command, can audit a different part of a large tree on each run.  The
+// choice only depends on seed and on the names and variants of the modules: the same seed always
+// returns the same modules, and changing it, for example to a build number, selects an
+// unrelated sample.  Adding or removing modules only changes the sample where the new modules
+// are chosen instead of, or the removed modules are replaced by, other modules.
+//
+// The modules are returned sorted by name and then by variant.  All modules are returned if there are no more than k of them.  SampleModules returns nil if it
+// is called before ResolveDependencies.
+func (c *Context) SampleModules(seed string, k int) []Module {
+	if k <= 0 || len(c.modulesSorted) == 0 {
+		return nil
+	}
+
+	type candidate struct {
+		module *moduleInfo
+		hash   uint64
+	}
+	candidates := make([]candidate, len(c.modulesSorted))
+	for i, m := range c.modulesSorted {
+		candidates[i] = candidate{m, sampleHash(seed, m)}
+	}
+
+	if k < len(candidates) {
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].hash != candidates[j].hash {
+				return candidates[i].hash < candidates[j].hash
+			}
+			return sampleLess(candidates[i].module, candidates[j].module)
+		})
+		candidates = candidates[:k]
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return sampleLess(candidates[i].module, candidates[j].module)
+	})
+
+	modules := make([]Module, len(candidates))
+	for i, candidate := range candidates {
+		modules[i] = candidate.module.logicModule
+	}
+	return modules
+}
+
+// sampleHash returns the rank of a module in the sample for seed, the k modules with the lowest
+// ranks are chosen.
+func sampleHash(seed string, m *moduleInfo) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	h.Write([]byte{0})
+	h.Write([]byte(m.Name()))
+	h.Write([]byte{0})
+	h.Write([]byte(m.variant.name))
+	return h.Sum64()
+}
+
+func sampleLess(a, b *moduleInfo) bool {
+	if a.Name() != b.Name() {
+		return a.Name() < b.Name()
+	}
+	return a.variant.name < b.variant.name
+}
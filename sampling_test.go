@@ -0,0 +1,93 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSampleModules(t *testing.T) {
+	run := func(t *testing.T, n int) *Context {
+		t.Helper()
+		bp := &strings.Builder{}
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(bp, "foo_module { name: \"m%d\" }\n", i)
+		}
+
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp.String())})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+		return ctx
+	}
+
+	names := func(ctx *Context, modules []Module) []string {
+		var names []string
+		for _, m := range modules {
+			names = append(names, ctx.ModuleName(m))
+		}
+		return names
+	}
+
+	ctx := run(t, 100)
+	first := names(ctx, ctx.SampleModules("run1", 5))
+	if len(first) != 5 {
+		t.Fatalf("expected 5 modules, got %q", first)
+	}
+
+	if g := names(ctx, ctx.SampleModules("run1", 5)); !reflect.DeepEqual(g, first) {
+		t.Errorf("sample changed for the same seed\nwant: %q\n got: %q", first, g)
+	}
+	if other := run(t, 100); !reflect.DeepEqual(names(other, other.SampleModules("run1", 5)), first) {
+		t.Errorf("sample changed in a new context")
+	}
+	if g := names(ctx, ctx.SampleModules("run2", 5)); reflect.DeepEqual(g, first) {
+		t.Errorf("sample did not change for a different seed: %q", g)
+	}
+
+	// Adding a module changes at most one of the sampled modules.
+	bigger := run(t, 101)
+	second := names(bigger, bigger.SampleModules("run1", 5))
+	kept := 0
+	for _, name := range second {
+		for _, prev := range first {
+			if name == prev {
+				kept++
+			}
+		}
+	}
+	if kept < 4 {
+		t.Errorf("adding a module changed the sample from %q to %q", first, second)
+	}
+
+	if g := len(ctx.SampleModules("run1", 1000)); g != 100 {
+		t.Errorf("expected all 100 modules, got %d", g)
+	}
+	if g := ctx.SampleModules("run1", 0); g != nil {
+		t.Errorf("expected no modules for k == 0, got %q", names(ctx, g))
+	}
+	if g := NewContext().SampleModules("run1", 5); g != nil {
+		t.Errorf("expected no modules before ResolveDependencies, got %v", g)
+	}
+}
@@ -100,6 +100,14 @@ func makeRuleScope(parent *basicScope, argNames map[string]bool) *basicScope {
 		}
 	}
 
+	// The response file variables may be set by build statements, but unlike $in and $out they
+	// have not always been reserved, so a rule that declares them as arguments keeps working.
+	for _, builtin := range builtinRuleVariables {
+		if _, exists := scope.variables[builtin]; !exists {
+			scope.variables[builtin] = &argVariable{builtin}
+		}
+	}
+
 	return scope
 }
 
@@ -250,6 +258,9 @@ func (s *basicScope) AddRule(r Rule) error {
 type localScope struct {
 	namePrefix string
 	scope      *basicScope
+
+	// set by the Context for the scopes of module and singleton contexts, see parseNinjaString
+	literals *literalNinjaStringCache
 }
 
 func newLocalScope(parent *basicScope, namePrefix string) *localScope {
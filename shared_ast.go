@@ -0,0 +1,269 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// sharedAST is the result of parsing and evaluating a Blueprints file, which can be reused for
+// any other Blueprints file with the same contents that is evaluated in an equivalent scope.
+type sharedAST struct {
+	filename string
+	file     *parser.File
+}
+
+// parseAndEvalShared is like parser.ParseAndEval, but reuses the AST of a previously parsed
+// Blueprints file with identical contents if all the variables the file could reference have the
+// same values.  The reused AST is copied with its positions moved to filename, so that errors
+// are reported in the right file, and its variables are added to scope.  The returned file is
+// named relBlueprintsFile.  The original AST is shared with other goroutines once it is cached,
+// so it is named before it is cached and must not be modified afterwards, only the copies can be.
+func (c *Context) parseAndEvalShared(relBlueprintsFile, filename string, r io.Reader,
+	scope *parser.Scope) (*parser.File, []error) {
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	key := sharedASTKey(contents, scope)
+
+	c.sharedASTLock.Lock()
+	shared := c.sharedASTs[key]
+	c.sharedASTLock.Unlock()
+
+	if shared != nil {
+		file := copyASTToFile(shared.file, shared.filename, filename)
+		file.Name = relBlueprintsFile
+
+		// Top level "=" assignments hold the final value of each local variable after any "+="
+		// assignments were applied, add them to the scope.
+		for _, def := range file.Defs {
+			if assignment, ok := def.(*parser.Assignment); ok && assignment.Assigner == "=" {
+				if err := scope.Add(assignment); err != nil {
+					return nil, []error{err}
+				}
+			}
+		}
+
+		// Point references to variables at the values in this file's scope.
+		resolveVariables(reflect.ValueOf(file), scope)
+
+		return file, nil
+	}
+
+	file, errs := parser.ParseAndEval(filename, bytes.NewReader(contents), scope)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	file.Name = relBlueprintsFile
+
+	if len(file.Includes) > 0 {
+		// The key doesn't cover the contents of the included files.
+		return file, nil
+	}
+
+	c.sharedASTLock.Lock()
+	if c.sharedASTs == nil {
+		c.sharedASTs = make(map[string]*sharedAST)
+	}
+	if _, exists := c.sharedASTs[key]; !exists {
+		c.sharedASTs[key] = &sharedAST{filename: filename, file: file}
+	}
+	c.sharedASTLock.Unlock()
+
+	return file, nil
+}
+
+// sharedASTKey returns a key that is equal for two Blueprints files if they have the same contents
+// and all the variables in their scopes that they could reference have the same values.
+func sharedASTKey(contents []byte, scope *parser.Scope) string {
+	hash := sha256.New()
+	hash.Write(contents)
+
+	// Only variables whose names appear in the file can be referenced by it.
+	var names []string
+	for _, name := range scope.Names() {
+		if bytes.Contains(contents, []byte(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		assignment, local := scope.Get(name)
+		fmt.Fprintf(hash, "\x00%s %t ", name, local)
+		writeExpressionValue(hash, assignment.Value)
+	}
+
+	return string(hash.Sum(nil))
+}
+
+// writeExpressionValue writes the value of an evaluated expression without its positions.
+func writeExpressionValue(w io.Writer, expression parser.Expression) {
+	switch e := expression.Eval().(type) {
+	case *parser.String:
+		fmt.Fprintf(w, "%q", e.Value)
+	case *parser.Int64:
+		fmt.Fprintf(w, "%d", e.Value)
+	case *parser.Bool:
+		fmt.Fprintf(w, "%t", e.Value)
+	case *parser.List:
+		io.WriteString(w, "[")
+		for _, value := range e.Values {
+			writeExpressionValue(w, value)
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, "]")
+	case *parser.Map:
+		io.WriteString(w, "{")
+		for _, property := range e.Properties {
+			fmt.Fprintf(w, "%s:", property.Name)
+			writeExpressionValue(w, property.Value)
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, "}")
+	default:
+		// Other expressions include their positions, which prevents sharing but is always correct.
+		io.WriteString(w, e.String())
+	}
+}
+
+var positionType = reflect.TypeOf(scanner.Position{})
+
+// copyASTToFile returns a deep copy of a parsed Blueprints file in which every position in the
+// file from is moved to the file to.  Nodes that were shared within the original are shared
+// within the copy.
+func copyASTToFile(file *parser.File, from, to string) *parser.File {
+	type copiedKey struct {
+		ptr uintptr
+		typ reflect.Type
+	}
+	copied := make(map[copiedKey]reflect.Value)
+
+	var deepCopy func(v reflect.Value) reflect.Value
+	deepCopy = func(v reflect.Value) reflect.Value {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if v.IsNil() {
+				return v
+			}
+			key := copiedKey{v.Pointer(), v.Type()}
+			if c, ok := copied[key]; ok {
+				return c
+			}
+			c := reflect.New(v.Type().Elem())
+			copied[key] = c
+			c.Elem().Set(deepCopy(v.Elem()))
+			return c
+		case reflect.Interface:
+			if v.IsNil() {
+				return v
+			}
+			c := reflect.New(v.Type()).Elem()
+			c.Set(deepCopy(v.Elem()))
+			return c
+		case reflect.Slice:
+			if v.IsNil() {
+				return v
+			}
+			c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				c.Index(i).Set(deepCopy(v.Index(i)))
+			}
+			return c
+		case reflect.Array:
+			c := reflect.New(v.Type()).Elem()
+			for i := 0; i < v.Len(); i++ {
+				c.Index(i).Set(deepCopy(v.Index(i)))
+			}
+			return c
+		case reflect.Struct:
+			c := reflect.New(v.Type()).Elem()
+			c.Set(v)
+			if v.Type() == positionType {
+				if pos := v.Interface().(scanner.Position); pos.Filename == from {
+					pos.Filename = to
+					c.Set(reflect.ValueOf(pos))
+				}
+				return c
+			}
+			for i := 0; i < v.NumField(); i++ {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+			return c
+		default:
+			return v
+		}
+	}
+
+	return deepCopy(reflect.ValueOf(file)).Interface().(*parser.File)
+}
+
+// resolveVariables sets the value of every variable reference in a copied AST to the value of the
+// variable in scope.
+func resolveVariables(v reflect.Value, scope *parser.Scope) {
+	type visitedKey struct {
+		ptr uintptr
+		typ reflect.Type
+	}
+	visited := make(map[visitedKey]bool)
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			key := visitedKey{v.Pointer(), v.Type()}
+			if v.IsNil() || visited[key] {
+				return
+			}
+			visited[key] = true
+			if variable, ok := v.Interface().(*parser.Variable); ok {
+				if assignment, _ := scope.Get(variable.Name); assignment != nil {
+					variable.Value = assignment.Value
+				}
+				return
+			}
+			walk(v.Elem())
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Struct:
+			if v.Type() == positionType {
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i))
+			}
+		}
+	}
+
+	walk(v)
+}
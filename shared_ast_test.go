@@ -0,0 +1,126 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint/parser"
+)
+
+func TestParseAndEvalShared(t *testing.T) {
+	ctx := NewContext()
+
+	parentScope := func(filename, value string) *parser.Scope {
+		t.Helper()
+		scope := parser.NewScope(nil)
+		_, errs := parser.ParseAndEval(filename, strings.NewReader("x = ["+value+"]\n"), scope)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+		return parser.NewScope(scope)
+	}
+
+	const contents = "y = x + [\"2\"]\nz = y\n"
+
+	parse := func(filename string, scope *parser.Scope) *parser.File {
+		t.Helper()
+		file, errs := ctx.parseAndEvalShared(filename, filename, strings.NewReader(contents), scope)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+		return file
+	}
+
+	scopeA := parentScope("Blueprints", `"1"`)
+	fileA := parse("a/Blueprints", scopeA)
+
+	scopeB := parentScope("other/Blueprints", `"1"`)
+	fileB := parse("b/Blueprints", scopeB)
+
+	if len(ctx.sharedASTs) != 1 {
+		t.Errorf("expected b/Blueprints to reuse the AST of a/Blueprints, got %d ASTs", len(ctx.sharedASTs))
+	}
+
+	assignmentA := fileA.Defs[0].(*parser.Assignment)
+	assignmentB := fileB.Defs[0].(*parser.Assignment)
+	if assignmentA == assignmentB {
+		t.Fatalf("expected a copy of the AST")
+	}
+	if g, w := assignmentB.NamePos.Filename, "b/Blueprints"; g != w {
+		t.Errorf("expected position in %q, got %q", w, g)
+	}
+	var values []string
+	for _, value := range assignmentB.Value.Eval().(*parser.List).Values {
+		values = append(values, value.(*parser.String).Value)
+	}
+	if g, w := values, []string{"1", "2"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected value %q, got %q", w, g)
+	}
+
+	// Variable references point at the values in the file's own scope.
+	x := assignmentB.OrigValue.(*parser.Operator).Args[0].(*parser.Variable)
+	if xAssignment, _ := scopeB.Get("x"); x.Value != xAssignment.Value {
+		t.Errorf("expected x to refer to the value in the scope of b/Blueprints")
+	}
+	y := fileB.Defs[1].(*parser.Assignment).Value.(*parser.Variable)
+	if y.Value != assignmentB.Value {
+		t.Errorf("expected y to refer to the value in b/Blueprints")
+	}
+	if yAssignment, local := scopeB.Get("y"); !local || yAssignment != assignmentB {
+		t.Errorf("expected y to be added to the scope of b/Blueprints")
+	}
+
+	// A different value of a referenced variable prevents sharing.
+	parse("c/Blueprints", parentScope("Blueprints", `"3"`))
+	if len(ctx.sharedASTs) != 2 {
+		t.Errorf("expected c/Blueprints to be parsed, got %d ASTs", len(ctx.sharedASTs))
+	}
+}
+
+func TestSharedASTErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	const contents = `
+		foo_module {
+			name: "foo",
+			unknown: true,
+		}
+	`
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints":   []byte(`subdirs = ["*"]`),
+		"a/Blueprints": []byte(contents),
+		"b/Blueprints": []byte(contents),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`a/Blueprints:4:11: unrecognized property "unknown"`,
+		`b/Blueprints:4:11: unrecognized property "unknown"`,
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+	}
+}
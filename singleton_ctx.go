@@ -57,12 +57,18 @@ type SingletonContext interface {
 	// ModuleHasProvider returns true if the provider for the given module has been set.
 	ModuleHasProvider(m Module, provider ProviderKey) bool
 
+	otherModuleProvider(m Module, provider ProviderKey) (interface{}, bool)
+
 	// ModuleErrorf reports an error at the line number of the module type in the module definition.
 	ModuleErrorf(module Module, format string, args ...interface{})
 
 	// Errorf reports an error at the specified position of the module definition file.
 	Errorf(format string, args ...interface{})
 
+	// Warningf reports a warning that is not associated with a module.  Warnings do not cause the
+	// build to fail, and are returned by Context.Warnings.
+	Warningf(format string, args ...interface{})
+
 	// Failed returns true if any errors have been reported.  In most cases the singleton can continue with generating
 	// build rules after an error, allowing it to report additional errors in a single run, but in cases where the error
 	// has prevented the singleton from creating necessary data it can return early when Failed returns true.
@@ -79,6 +85,13 @@ type SingletonContext interface {
 	// Build creates a new ninja build statement.
 	Build(pctx PackageContext, params BuildParams)
 
+	// Phony declares a phony target with the given name that depends on deps, see
+	// ModuleContext.Phony.
+	Phony(name string, deps ...string)
+
+	// DescribePhony sets the description of a phony target, see ModuleContext.DescribePhony.
+	DescribePhony(name, description string)
+
 	// RequireNinjaVersion sets the generated ninja manifest to require at least the specified version of ninja.
 	RequireNinjaVersion(major, minor, micro int)
 
@@ -103,6 +116,10 @@ type SingletonContext interface {
 	// true calls visit.
 	VisitAllModulesIf(pred func(Module) bool, visit func(Module))
 
+	// VisitAllModuleGroups calls visit for each module with a description of all of its variants
+	// and aliases, in order of module name.
+	VisitAllModuleGroups(visit func(ModuleGroupInfo))
+
 	// VisitDirectDeps calls visit for each direct dependency of the Module.  If there are
 	// multiple direct dependencies on the same module visit will be called multiple times on
 	// that module and OtherModuleDependencyTag will return a different tag for each.
@@ -142,6 +159,19 @@ type SingletonContext interface {
 	// singleton actions that are only done once for all variants of a module.
 	FinalModule(module Module) Module
 
+	// IsDefaultTarget returns true if the given variant is a default target, see
+	// BaseMutatorContext.MarkDefaultTarget.  Singletons that create phony targets aggregating
+	// modules should only include the default targets.
+	IsDefaultTarget(module Module) bool
+
+	// HasDefaultTargets returns true if any variant of the given module was marked as a default
+	// target, see BaseMutatorContext.MarkDefaultTarget.
+	HasDefaultTargets(module Module) bool
+
+	// IsDistTarget returns true if the given variant was marked as a dist target, see
+	// BaseMutatorContext.MarkDistTarget.
+	IsDistTarget(module Module) bool
+
 	// AddNinjaFileDeps adds dependencies on the specified files to the rule that creates the ninja manifest.  The
 	// primary builder will be rerun whenever the specified files are modified.
 	AddNinjaFileDeps(deps ...string)
@@ -172,6 +202,27 @@ type singletonContext struct {
 	errs          []error
 
 	actionDefs localBuildActions
+
+	// set by Phony and DescribePhony
+	phonies []phonyDecl
+
+	// Set by RequireNinjaVersion, SetNinjaBuildDir and AddSubninja, and copied into the Context
+	// by applyNinjaSettings so that parallel singletons don't modify the Context concurrently.
+	requiredNinjaVersions [][3]int
+	ninjaBuildDir         ninjaString
+	subninjas             []string
+}
+
+// applyNinjaSettings copies the settings of the Ninja file made by the singleton into the
+// Context.
+func (s *singletonContext) applyNinjaSettings() {
+	for _, v := range s.requiredNinjaVersions {
+		s.context.requireNinjaVersion(v[0], v[1], v[2])
+	}
+	if s.ninjaBuildDir != nil {
+		s.context.setNinjaBuildDir(s.ninjaBuildDir)
+	}
+	s.context.subninjas = append(s.context.subninjas, s.subninjas...)
 }
 
 func (s *singletonContext) Config() interface{} {
@@ -207,6 +258,10 @@ func (s *singletonContext) ModuleHasProvider(logicModule Module, provider Provid
 	return s.context.ModuleHasProvider(logicModule, provider)
 }
 
+func (s *singletonContext) otherModuleProvider(logicModule Module, provider ProviderKey) (interface{}, bool) {
+	return s.context.otherModuleProvider(logicModule, provider)
+}
+
 func (s *singletonContext) BlueprintFile(logicModule Module) string {
 	return s.context.BlueprintFile(logicModule)
 }
@@ -228,6 +283,10 @@ func (s *singletonContext) Errorf(format string, args ...interface{}) {
 	s.error(fmt.Errorf(format, args...))
 }
 
+func (s *singletonContext) Warningf(format string, args ...interface{}) {
+	s.context.addWarning(WarningClassSingleton, fmt.Errorf("singleton %q: %s", s.name, fmt.Sprintf(format, args...)))
+}
+
 func (s *singletonContext) Failed() bool {
 	return len(s.errs) > 0
 }
@@ -266,6 +325,12 @@ func (s *singletonContext) Build(pctx PackageContext, params BuildParams) {
 		panic(err)
 	}
 
+	s.context.recordNinjaFeatures(&params)
+
+	if s.context.annotateBuildStatements {
+		annotateBuildDef(def, fmt.Sprintf("singleton %q", s.name), 1)
+	}
+
 	s.actionDefs.buildDefs = append(s.actionDefs.buildDefs, def)
 }
 
@@ -277,6 +342,9 @@ func (s *singletonContext) Eval(pctx PackageContext, str string) (string, error)
 		return "", err
 	}
 
+	s.globals.Lock()
+	defer s.globals.Unlock()
+
 	err = s.globals.addNinjaStringDeps(ninjaStr)
 	if err != nil {
 		return "", err
@@ -286,7 +354,10 @@ func (s *singletonContext) Eval(pctx PackageContext, str string) (string, error)
 }
 
 func (s *singletonContext) RequireNinjaVersion(major, minor, micro int) {
-	s.context.requireNinjaVersion(major, minor, micro)
+	if major != 1 {
+		panic("ninja version with major version != 1 not supported")
+	}
+	s.requiredNinjaVersions = append(s.requiredNinjaVersions, [3]int{major, minor, micro})
 }
 
 func (s *singletonContext) SetNinjaBuildDir(pctx PackageContext, value string) {
@@ -297,11 +368,13 @@ func (s *singletonContext) SetNinjaBuildDir(pctx PackageContext, value string) {
 		panic(err)
 	}
 
-	s.context.setNinjaBuildDir(ninjaValue)
+	if s.ninjaBuildDir == nil {
+		s.ninjaBuildDir = ninjaValue
+	}
 }
 
 func (s *singletonContext) AddSubninja(file string) {
-	s.context.subninjas = append(s.context.subninjas, file)
+	s.subninjas = append(s.subninjas, file)
 }
 
 func (s *singletonContext) VisitAllModules(visit func(Module)) {
@@ -325,6 +398,10 @@ func (s *singletonContext) VisitAllModulesIf(pred func(Module) bool,
 	s.context.VisitAllModulesIf(pred, visit)
 }
 
+func (s *singletonContext) VisitAllModuleGroups(visit func(ModuleGroupInfo)) {
+	s.context.VisitAllModuleGroups(visit)
+}
+
 func (s *singletonContext) VisitDirectDeps(module Module, visit func(Module)) {
 	s.context.VisitDirectDeps(module, visit)
 }
@@ -353,6 +430,18 @@ func (s *singletonContext) FinalModule(module Module) Module {
 	return s.context.FinalModule(module)
 }
 
+func (s *singletonContext) IsDefaultTarget(module Module) bool {
+	return s.context.IsDefaultTarget(module)
+}
+
+func (s *singletonContext) HasDefaultTargets(module Module) bool {
+	return s.context.HasDefaultTargets(module)
+}
+
+func (s *singletonContext) IsDistTarget(module Module) bool {
+	return s.context.IsDistTarget(module)
+}
+
 func (s *singletonContext) VisitAllModuleVariants(module Module, visit func(Module)) {
 	s.context.VisitAllModuleVariants(module, visit)
 }
@@ -0,0 +1,174 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stableNameHashLength is the number of hex digits of the hash used in stable names.
+const stableNameHashLength = 8
+
+// SetStableNinjaNames sets whether the global variables, pools and rules defined with a
+// PackageContext are named in the Ninja file by a hash of their definitions, for example
+// "g.3f2a9c1b.cc", instead of by the name of the Go package that defined them, for example
+// "g.cc.cc".  Moving or renaming the Go packages of the primary builder then only changes the
+// names of the definitions that actually changed, instead of every build statement that uses a
+// rule from the package.  The hash of a definition includes the names of the variables and pools
+// it refers to, so a change to a variable also renames the rules that use it.  Identical
+// definitions with the same name from different packages share a single definition in the Ninja
+// file.
+func (c *Context) SetStableNinjaNames(stable bool) {
+	c.stableNinjaNames = stable
+}
+
+// fullNameSetter is implemented by the variables, pools and rules defined with a PackageContext.
+type fullNameSetter interface {
+	setFullName(name string)
+}
+
+// packageEntity is a global variable, pool or rule.
+type packageEntity interface {
+	packageContext() *packageContext
+	name() string
+}
+
+// clearFullNames forgets the memoized full names of the live global variables, pools and rules.
+func clearFullNames(liveGlobals *liveTracker) {
+	for v := range liveGlobals.variables {
+		if setter, ok := v.(fullNameSetter); ok {
+			setter.setFullName("")
+		}
+	}
+	for r := range liveGlobals.rules {
+		if setter, ok := r.(fullNameSetter); ok {
+			setter.setFullName("")
+		}
+	}
+	for p := range liveGlobals.pools {
+		if setter, ok := p.(fullNameSetter); ok {
+			setter.setFullName("")
+		}
+	}
+}
+
+// memoizeStableNames replaces the memoized full names of the live global variables, pools and
+// rules with names derived from a hash of their definitions.  Pools are named first and then
+// variables in dependency order, so that the definitions of variables and rules that refer to
+// them are hashed with their stable names.
+func (c *Context) memoizeStableNames(liveGlobals *liveTracker, pkgNames map[*packageContext]string) {
+	// defs maps the names that have been assigned to the definitions they were assigned to.
+	defs := make(map[string]string)
+
+	// setName sets the stable name of an entity from the hash of its definition.  Entities with
+	// identical definitions share a name.  In the unlikely case of a hash collision between
+	// different definitions a numeric suffix is added to the later one, in the order of their
+	// package paths.
+	setName := func(entity packageEntity, def string) {
+		setter, ok := entity.(fullNameSetter)
+		if !ok || entity.packageContext() == nil {
+			return
+		}
+		hash := sha256.Sum256([]byte(def))
+		base := packageNamespacePrefix(hex.EncodeToString(hash[:])[:stableNameHashLength]) + entity.name()
+		name := base
+		for i := 2; ; i++ {
+			if existing, ok := defs[name]; !ok || existing == def {
+				break
+			}
+			name = base + "_" + strconv.Itoa(i)
+		}
+		defs[name] = def
+		setter.setFullName(name)
+	}
+
+	render := func(write func(nw *ninjaWriter) error) string {
+		buf := &strings.Builder{}
+		if err := write(newNinjaWriter(buf)); err != nil {
+			// Writing to a strings.Builder never fails.
+			panic(err)
+		}
+		return buf.String()
+	}
+
+	var pools []packageEntity
+	for pool := range liveGlobals.pools {
+		pools = append(pools, pool)
+	}
+	for _, entity := range sortedByPackage(pools) {
+		pool := entity.(Pool)
+		def := liveGlobals.pools[pool]
+		setName(pool, render(func(nw *ninjaWriter) error {
+			return def.WriteTo(nw, pool.name())
+		}))
+	}
+
+	var variables []packageEntity
+	for variable := range liveGlobals.variables {
+		variables = append(variables, variable)
+	}
+	visited := make(map[Variable]bool)
+	var visit func(v Variable)
+	visit = func(v Variable) {
+		visited[v] = true
+		value := liveGlobals.variables[v]
+		for _, dep := range value.Variables() {
+			if _, live := liveGlobals.variables[dep]; live && !visited[dep] {
+				visit(dep)
+			}
+		}
+		setName(v, render(func(nw *ninjaWriter) error {
+			return nw.Assign(v.name(), value.Value(pkgNames))
+		}))
+	}
+	for _, entity := range sortedByPackage(variables) {
+		if v := entity.(Variable); !visited[v] {
+			visit(v)
+		}
+	}
+
+	var rules []packageEntity
+	for rule := range liveGlobals.rules {
+		rules = append(rules, rule)
+	}
+	for _, entity := range sortedByPackage(rules) {
+		rule := entity.(Rule)
+		def := liveGlobals.rules[rule]
+		setName(rule, render(func(nw *ninjaWriter) error {
+			return def.WriteTo(nw, rule.name(), pkgNames)
+		}))
+	}
+}
+
+// sortedByPackage sorts global entities by the path of their package and then by their name.
+func sortedByPackage(entities []packageEntity) []packageEntity {
+	pkgPath := func(entity packageEntity) string {
+		if pctx := entity.packageContext(); pctx != nil {
+			return pctx.pkgPath
+		}
+		return ""
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if a, b := pkgPath(entities[i]), pkgPath(entities[j]); a != b {
+			return a < b
+		}
+		return entities[i].name() < entities[j].name()
+	})
+	return entities
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	stableNamesPctxA = NewPackageContext("github.com/google/blueprint/stable_names_test_a")
+	stableNamesPctxB = NewPackageContext("github.com/google/blueprint/stable_names_test/b")
+
+	// The two packages define the same variable and rule, but package B's rule uses a pool.
+	_            = stableNamesPctxA.StaticVariable("flags", "-O2")
+	_            = stableNamesPctxB.StaticVariable("flags", "-O2")
+	stablePoolB  = stableNamesPctxB.StaticPool("pool", PoolParams{Depth: 1})
+	stableRuleA  = stableNamesPctxA.StaticRule("cc", RuleParams{Command: "cc $flags $in -o $out"})
+	stableRuleB  = stableNamesPctxB.StaticRule("cc", RuleParams{Command: "cc $flags $in -o $out", Pool: stablePoolB})
+	stableRuleB2 = stableNamesPctxB.StaticRule("cp", RuleParams{Command: "cp $in $out"})
+)
+
+type stableNamesTestModule struct {
+	SimpleName
+}
+
+func newStableNamesTestModule() (Module, []interface{}) {
+	m := &stableNamesTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties}
+}
+
+func (m *stableNamesTestModule) GenerateBuildActions(ctx ModuleContext) {
+	for i, rule := range []Rule{stableRuleA, stableRuleB, stableRuleB2} {
+		pctx := stableNamesPctxB
+		if rule == stableRuleA {
+			pctx = stableNamesPctxA
+		}
+		ctx.Build(pctx, BuildParams{
+			Rule:    rule,
+			Inputs:  []string{"in"},
+			Outputs: []string{"out" + string(rune('a'+i))},
+		})
+	}
+}
+
+func TestStableNinjaNames(t *testing.T) {
+	run := func(stable bool) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.SetStableNinjaNames(stable)
+		ctx.RegisterModuleType("test_module", newStableNamesTestModule)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(`test_module { name: "m" }`)})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	manifest := run(true)
+	if strings.Contains(manifest, "g.stable_names_test") {
+		t.Errorf("unexpected package name in manifest:\n%s", manifest)
+	}
+
+	find := func(re string) []string {
+		t.Helper()
+		match := regexp.MustCompile(re).FindStringSubmatch(manifest)
+		if match == nil {
+			t.Fatalf("no match for %q in manifest:\n%s", re, manifest)
+		}
+		return match[1:]
+	}
+
+	// The identical variables share a single definition.
+	flags := find(`(?m)^(g\.[0-9a-f]{8}\.flags) = -O2$`)
+	if n := strings.Count(manifest, "flags = -O2"); n != 1 {
+		t.Errorf("expected identical variables to be defined once, got %d definitions:\n%s", n, manifest)
+	}
+
+	// The rules differ by their pool, so they have different hashes.
+	ruleA := find(`(?m)^rule (g\.[0-9a-f]{8}\.cc)\n    command = cc \$\{` + regexp.QuoteMeta(flags[0]) + `\} `)[0]
+	ruleB := find(`(?m)^rule (g\.[0-9a-f]{8}\.cc)\n    pool = g\.[0-9a-f]{8}\.pool\n`)[0]
+	if ruleA == ruleB {
+		t.Errorf("expected rules with different pools to have different names, got %q", ruleA)
+	}
+	find(`(?m)^build outa: ` + regexp.QuoteMeta(ruleA) + ` in$`)
+	find(`(?m)^build outb: ` + regexp.QuoteMeta(ruleB) + ` in$`)
+
+	// The names are deterministic.
+	if again := run(true); again != manifest {
+		t.Errorf("stable names are not deterministic:\n%s\n---\n%s", manifest, again)
+	}
+
+	if manifest := run(false); !strings.Contains(manifest, "rule g.stable_names_test_a.cc\n") {
+		t.Errorf("expected package names without stable names, got:\n%s", manifest)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// strictAction is the description of a strict build statement written to the action manifest.
+type strictAction struct {
+	Module    string `json:"module,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	Singleton string `json:"singleton,omitempty"`
+
+	Rule      string   `json:"rule"`
+	Command   string   `json:"command"`
+	Inputs    []string `json:"inputs"`
+	Tools     []string `json:"tools,omitempty"`
+	OrderOnly []string `json:"order_only,omitempty"`
+	Outputs   []string `json:"outputs"`
+	Depfile   string   `json:"depfile,omitempty"`
+	Rspfile   string   `json:"rspfile,omitempty"`
+}
+
+type actionManifest struct {
+	Actions []*strictAction `json:"actions"`
+}
+
+// actionEvaluator evaluates the Ninja strings of a build statement the way Ninja would, including
+// the built-in $in and $out variables and the arguments of the rule.
+type actionEvaluator struct {
+	context  *Context
+	buildDef *buildDef
+}
+
+func (e *actionEvaluator) eval(s ninjaString) (string, error) {
+	variables := s.Variables()
+	if len(variables) == 0 {
+		return s.Eval(nil)
+	}
+
+	values := make(map[Variable]ninjaString, len(variables))
+	for _, v := range variables {
+		value, err := e.variable(v)
+		if err != nil {
+			return "", err
+		}
+		values[v] = simpleNinjaString(value)
+	}
+	return s.Eval(values)
+}
+
+func (e *actionEvaluator) evalList(list []ninjaString) ([]string, error) {
+	ret := make([]string, 0, len(list))
+	for _, s := range list {
+		value, err := e.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, value)
+	}
+	return ret, nil
+}
+
+func (e *actionEvaluator) variable(v Variable) (string, error) {
+	b := e.buildDef
+	switch v := v.(type) {
+	case *localVariable:
+		return e.eval(v.value_)
+	case *argVariable:
+		switch v.name_ {
+		case "in":
+			inputs, err := e.evalList(b.Inputs)
+			return strings.Join(inputs, " "), err
+		case "out":
+			outputs, err := e.evalList(b.Outputs)
+			return strings.Join(outputs, " "), err
+		}
+		if value, ok := b.Variables[v.name_]; ok {
+			return e.eval(value)
+		}
+		for argVar, value := range b.Args {
+			if argVar.name() == v.name_ {
+				return e.eval(value)
+			}
+		}
+		if b.RuleDef != nil {
+			if value, ok := b.RuleDef.Variables[v.name_]; ok {
+				return e.eval(value)
+			}
+		}
+		// Ninja expands unset variables to an empty string.
+		return "", nil
+	default:
+		value, ok := e.context.globalVariables[v]
+		if !ok {
+			return "", fmt.Errorf("no such global variable: %s", v)
+		}
+		return e.eval(value)
+	}
+}
+
+// strictAction evaluates a strict build statement into the paths it declares.
+func (c *Context) strictAction(b *buildDef) (*strictAction, error) {
+	e := &actionEvaluator{context: c, buildDef: b}
+	action := &strictAction{
+		Rule: b.Rule.fullName(c.pkgNames),
+	}
+
+	var err error
+	if action.Command, err = e.eval(b.RuleDef.Variables["command"]); err != nil {
+		return nil, err
+	}
+
+	evalLists := func(lists ...[]ninjaString) []string {
+		ret := []string{}
+		for _, list := range lists {
+			if err != nil {
+				return nil
+			}
+			var values []string
+			values, err = e.evalList(list)
+			ret = append(ret, values...)
+		}
+		return ret
+	}
+	action.Inputs = evalLists(b.Inputs, b.Implicits)
+	action.Tools = evalLists(b.RuleDef.CommandDeps)
+	action.OrderOnly = evalLists(b.OrderOnly, b.RuleDef.CommandOrderOnly)
+	action.Outputs = evalLists(b.Outputs, b.ImplicitOutputs)
+	if err != nil {
+		return nil, err
+	}
+
+	if action.Depfile, err = e.variable(&argVariable{"depfile"}); err != nil {
+		return nil, err
+	}
+	if action.Rspfile, err = e.variable(&argVariable{"rspfile"}); err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+// undeclaredPaths returns the paths referred to by the command of a strict action that the action
+// doesn't declare, in the order they appear in the command.
+func (a *strictAction) undeclaredPaths() []string {
+	declared := make(map[string]bool)
+	dirs := make(map[string]bool)
+	declare := func(paths ...string) {
+		for _, p := range paths {
+			if p == "" {
+				continue
+			}
+			p = path.Clean(p)
+			declared[p] = true
+			for dir := path.Dir(p); dir != "." && dir != "/" && !dirs[dir]; dir = path.Dir(dir) {
+				dirs[dir] = true
+			}
+		}
+	}
+	declare(a.Inputs...)
+	declare(a.Tools...)
+	declare(a.OrderOnly...)
+	declare(a.Outputs...)
+	declare(a.Depfile, a.Rspfile)
+
+	var undeclared []string
+	for _, p := range commandPaths(a.Command) {
+		if path.IsAbs(p) {
+			continue
+		}
+		if clean := path.Clean(p); !declared[clean] && !dirs[clean] {
+			undeclared = append(undeclared, p)
+		}
+	}
+	return undeclared
+}
+
+// commandPaths returns the words of a shell command that look like paths: the words, the values
+// of flags like --flag=value and the arguments of flags like -Idir that contain a '/'.
+func commandPaths(command string) []string {
+	words := strings.FieldsFunc(command, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(";&|()<>'\"`", r)
+	})
+
+	var paths []string
+	for _, word := range words {
+		for _, part := range strings.FieldsFunc(word, func(r rune) bool { return r == '=' || r == ',' }) {
+			if strings.HasPrefix(part, "-") && !strings.HasPrefix(part, "--") && len(part) > 2 {
+				part = part[2:]
+			}
+			if strings.Contains(part, "/") && !strings.HasPrefix(part, "-") &&
+				!strings.Contains(part, "://") && !strings.ContainsAny(part, "$*?") {
+				paths = append(paths, part)
+			}
+		}
+	}
+	return paths
+}
+
+// checkStrictActions returns an error for each path referred to by the command of a strict build
+// statement that the build statement doesn't declare.  See BuildParams.Strict.
+func (c *Context) checkStrictActions() []error {
+	var errs []error
+	c.visitStrictActions(func(action *strictAction, module *moduleInfo, singleton *singletonInfo, err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		for _, p := range action.undeclaredPaths() {
+			err := fmt.Errorf("command of rule %q refers to %q, which is not a declared input, "+
+				"output or tool of the strict build statement", action.Rule, p)
+			if module != nil {
+				errs = append(errs, &ModuleError{
+					BlueprintError: BlueprintError{
+						Err: err,
+						Pos: module.pos,
+					},
+					module: module,
+				})
+			} else {
+				errs = append(errs, fmt.Errorf("singleton %q: %s", singleton.name, err))
+			}
+		}
+	})
+	return errs
+}
+
+// visitStrictActions calls visit for each strict build statement, with the module or singleton
+// that generated it.  Modules are visited in sorted order followed by singletons in registration
+// order.
+func (c *Context) visitStrictActions(visit func(action *strictAction, module *moduleInfo,
+	singleton *singletonInfo, err error)) {
+
+	isStrict := func(b *buildDef) bool {
+		return b.RuleDef != nil && (b.Strict || b.RuleDef.Strict)
+	}
+
+	for _, module := range c.sortedModuleInfos() {
+		for _, b := range module.actionDefs.buildDefs {
+			if isStrict(b) {
+				action, err := c.strictAction(b)
+				if action != nil {
+					action.Module = module.Name()
+					action.Variant = module.variant.name
+				}
+				visit(action, module, nil, err)
+			}
+		}
+	}
+
+	for _, info := range c.singletonInfo {
+		for _, b := range info.actionDefs.buildDefs {
+			if isStrict(b) {
+				action, err := c.strictAction(b)
+				if action != nil {
+					action.Singleton = info.name
+				}
+				visit(action, nil, info, err)
+			}
+		}
+	}
+}
+
+// WriteActionManifest writes a JSON description of every strict build statement (see
+// BuildParams.Strict) to w, with the command and the complete sets of inputs, tools and outputs
+// of each one, for use by remote execution tools.  If this is called before PrepareBuildActions
+// successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) WriteActionManifest(w io.Writer) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
+
+	manifest := actionManifest{Actions: []*strictAction{}}
+	var err error
+	c.visitStrictActions(func(action *strictAction, _ *moduleInfo, _ *singletonInfo, actionErr error) {
+		if actionErr != nil {
+			if err == nil {
+				err = actionErr
+			}
+			return
+		}
+		sort.Strings(action.Inputs)
+		sort.Strings(action.Tools)
+		sort.Strings(action.OrderOnly)
+		manifest.Actions = append(manifest.Actions, action)
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
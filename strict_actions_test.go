@@ -0,0 +1,163 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	strictTestPctx = NewPackageContext("github.com/google/blueprint/strict_test")
+
+	_ = strictTestPctx.StaticVariable("cc", "tools/cc")
+
+	strictTestRule = strictTestPctx.StaticRule("strict_cc", RuleParams{
+		Command:     "${cc} -Iinclude/common -o $out $in $flags && touch /tmp/stamp",
+		CommandDeps: []string{"${cc}"},
+		Strict:      true,
+	}, "flags")
+
+	looseTestRule = strictTestPctx.StaticRule("loose_cc", RuleParams{
+		Command: "${cc} -o $out $in $flags",
+	}, "flags")
+)
+
+type strictTestModule struct {
+	SimpleName
+	properties struct {
+		Flags     string
+		Implicits []string
+		Loose     bool
+		Strict    bool
+	}
+}
+
+func newStrictTestModule() (Module, []interface{}) {
+	m := &strictTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *strictTestModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := strictTestRule
+	if m.properties.Loose {
+		rule = looseTestRule
+	}
+	ctx.Build(strictTestPctx, BuildParams{
+		Rule:      rule,
+		Outputs:   []string{"out/" + ctx.ModuleName() + ".o"},
+		Inputs:    []string{"src/" + ctx.ModuleName() + ".c"},
+		Implicits: append([]string{"include/common/a.h"}, m.properties.Implicits...),
+		Args:      map[string]string{"flags": m.properties.Flags},
+		Strict:    m.properties.Strict,
+	})
+}
+
+func TestStrictActions(t *testing.T) {
+	run := func(t *testing.T, bp string) (*Context, []error) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("strict_module", newStrictTestModule)
+		ctx.MockFileSystem(map[string][]byte{"Blueprints": []byte(bp)})
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		return ctx, errs
+	}
+
+	t.Run("declared", func(t *testing.T) {
+		ctx, errs := run(t, `
+			strict_module {
+				name: "a",
+				flags: "--config=cfg/a.cfg",
+				implicits: ["cfg/a.cfg"],
+			}
+
+			strict_module {
+				name: "b",
+				flags: "--config=cfg/b.cfg",
+				loose: true,
+			}
+		`)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteActionManifest(buf); err != nil {
+			t.Fatal(err)
+		}
+		want := `{
+  "actions": [
+    {
+      "module": "a",
+      "rule": "g.strict_test.strict_cc",
+      "command": "tools/cc -Iinclude/common -o out/a.o src/a.c --config=cfg/a.cfg && touch /tmp/stamp",
+      "inputs": [
+        "cfg/a.cfg",
+        "include/common/a.h",
+        "src/a.c"
+      ],
+      "tools": [
+        "tools/cc"
+      ],
+      "outputs": [
+        "out/a.o"
+      ]
+    }
+  ]
+}
+`
+		if g := buf.String(); g != want {
+			t.Errorf("incorrect action manifest\nwant:\n%s\ngot:\n%s", want, g)
+		}
+	})
+
+	t.Run("undeclared", func(t *testing.T) {
+		_, errs := run(t, `
+			strict_module {
+				name: "a",
+				flags: "--config=cfg/a.cfg",
+			}
+
+			strict_module {
+				name: "b",
+				flags: "-include ./cfg/b.h",
+				loose: true,
+				strict: true,
+			}
+		`)
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		want := []string{
+			`Blueprints:2:4: module "a": command of rule "g.strict_test.strict_cc" refers to "cfg/a.cfg", which is not a declared input, output or tool of the strict build statement`,
+			`Blueprints:7:4: module "b": command of rule "g.strict_test.loose_cc" refers to "tools/cc", which is not a declared input, output or tool of the strict build statement`,
+			`Blueprints:7:4: module "b": command of rule "g.strict_test.loose_cc" refers to "./cfg/b.h", which is not a declared input, output or tool of the strict build statement`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+		}
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		if err := NewContext().WriteActionManifest(&bytes.Buffer{}); err != ErrBuildActionsNotReady {
+			t.Errorf("expected ErrBuildActionsNotReady, got %v", err)
+		}
+	})
+}
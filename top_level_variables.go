@@ -0,0 +1,178 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// A TopLevelVariable is the value of a variable registered with RegisterTopLevelVariable that was
+// assigned in a Blueprints file.
+type TopLevelVariable struct {
+	// Name is the name of the variable.
+	Name string
+
+	// Value is the value of the variable, a string, []string, bool or int64 depending on the type
+	// the variable was registered with.
+	Value interface{}
+
+	// Pos is the position of the assignment to the variable.
+	Pos scanner.Position
+}
+
+// reservedTopLevelVariables are the top-level variables interpreted by the Context itself.
+var reservedTopLevelVariables = map[string]bool{
+	"build":            true,
+	"optional_subdirs": true,
+	"subdirs":          true,
+	"subname":          true,
+}
+
+// RegisterTopLevelVariable registers a top-level variable that is read from each Blueprints file
+// by the primary builder, for example to configure all the modules in a directory without
+// defining a fake module.  Like the build variable, the variable is not inherited by the Blueprints
+// files in subdirectories, each file assigns its own value.  The value must have the given type,
+// which must be a string, list of strings, bool or int64, or an error is reported at the assignment.
+// The values are returned by Context.TopLevelVariable and by the TopLevelVariable method of the
+// contexts of the modules defined in each file.
+func (c *Context) RegisterTopLevelVariable(name string, typ parser.Type) {
+	c.checkRegistration("RegisterTopLevelVariable")
+
+	if reservedTopLevelVariables[name] {
+		panic(fmt.Errorf("top-level variable %q is reserved", name))
+	}
+	if _, exists := c.topLevelVariableTypes[name]; exists {
+		panic(fmt.Errorf("top-level variable %q is already registered", name))
+	}
+	switch typ {
+	case parser.StringType, parser.ListType, parser.BoolType, parser.Int64Type:
+	default:
+		panic(fmt.Errorf("top-level variable %q has unsupported type %s", name, typ))
+	}
+
+	if c.topLevelVariableTypes == nil {
+		c.topLevelVariableTypes = make(map[string]parser.Type)
+	}
+	c.topLevelVariableTypes[name] = typ
+}
+
+// removeTopLevelVariables removes the registered top-level variables inherited from the parent
+// Blueprints file from the scope of a Blueprints file before it is parsed.
+func (c *Context) removeTopLevelVariables(scope *parser.Scope) {
+	for name := range c.topLevelVariableTypes {
+		scope.Remove(name)
+	}
+}
+
+// recordTopLevelVariables records the values of the registered top-level variables assigned in
+// a Blueprints file after it was parsed.
+func (c *Context) recordTopLevelVariables(relBlueprintsFile string, scope *parser.Scope) []error {
+	var errs []error
+	variables := make(map[string]TopLevelVariable)
+
+	names := make([]string, 0, len(c.topLevelVariableTypes))
+	for name := range c.topLevelVariableTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		typ := c.topLevelVariableTypes[name]
+		assignment, local := scope.Get(name)
+		if assignment == nil || !local {
+			continue
+		}
+
+		value, err := topLevelVariableValue(assignment.Value.Eval(), typ)
+		if err != nil {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("%q %s", name, err),
+				Pos: assignment.EqualsPos,
+			})
+			continue
+		}
+
+		variables[name] = TopLevelVariable{
+			Name:  name,
+			Value: value,
+			Pos:   assignment.EqualsPos,
+		}
+	}
+
+	if len(variables) > 0 {
+		c.topLevelVariablesLock.Lock()
+		if c.topLevelVariables == nil {
+			c.topLevelVariables = make(map[string]map[string]TopLevelVariable)
+		}
+		c.topLevelVariables[relBlueprintsFile] = variables
+		c.topLevelVariablesLock.Unlock()
+	}
+
+	return errs
+}
+
+func topLevelVariableValue(value parser.Expression, typ parser.Type) (interface{}, error) {
+	switch typ {
+	case parser.StringType:
+		if s, ok := value.(*parser.String); ok {
+			return s.Value, nil
+		}
+		return nil, fmt.Errorf("must be a string")
+	case parser.ListType:
+		if list, ok := value.(*parser.List); ok {
+			ret := make([]string, 0, len(list.Values))
+			for _, item := range list.Values {
+				s, ok := item.Eval().(*parser.String)
+				if !ok {
+					return nil, fmt.Errorf("must be a list of strings")
+				}
+				ret = append(ret, s.Value)
+			}
+			return ret, nil
+		}
+		return nil, fmt.Errorf("must be a list of strings")
+	case parser.BoolType:
+		if b, ok := value.(*parser.Bool); ok {
+			return b.Value, nil
+		}
+		return nil, fmt.Errorf("must be a bool")
+	case parser.Int64Type:
+		if i, ok := value.(*parser.Int64); ok {
+			return i.Value, nil
+		}
+		return nil, fmt.Errorf("must be an integer")
+	default:
+		panic(fmt.Errorf("unsupported top-level variable type %s", typ))
+	}
+}
+
+// TopLevelVariable returns the value of a top-level variable registered with
+// RegisterTopLevelVariable in the Blueprints file with the given path relative to the root
+// directory, and false if the file does not assign the variable.
+func (c *Context) TopLevelVariable(blueprintsFile, name string) (TopLevelVariable, bool) {
+	if _, registered := c.topLevelVariableTypes[name]; !registered {
+		panic(fmt.Errorf("top-level variable %q is not registered", name))
+	}
+
+	c.topLevelVariablesLock.Lock()
+	defer c.topLevelVariablesLock.Unlock()
+
+	variable, ok := c.topLevelVariables[blueprintsFile][name]
+	return variable, ok
+}
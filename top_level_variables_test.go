@@ -0,0 +1,120 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/blueprint/parser"
+)
+
+func TestTopLevelVariables(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterTopLevelVariable("team", parser.StringType)
+	ctx.RegisterTopLevelVariable("owners", parser.ListType)
+	ctx.RegisterTopLevelVariable("vendor", parser.BoolType)
+
+	var got []string
+	ctx.RegisterBottomUpMutator("read_variables", func(ctx BottomUpMutatorContext) {
+		if team, ok := ctx.TopLevelVariable("team"); ok {
+			got = append(got, ctx.ModuleName()+": "+team.Value.(string))
+		} else {
+			got = append(got, ctx.ModuleName()+": none")
+		}
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			subdirs = ["*"]
+			team = "root"
+			owners = ["a"]
+			owners += ["b"]
+
+			foo_module {
+				name: "root",
+			}
+		`),
+		"a/Blueprints": []byte(`
+			team = "a"
+
+			foo_module {
+				name: "a",
+			}
+		`),
+		"b/Blueprints": []byte(`
+			foo_module {
+				name: "b",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	sort.Strings(got)
+	want := []string{"a: a", "b: none", "root: root"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect variables\nwant: %q\n got: %q", want, got)
+	}
+
+	owners, ok := ctx.TopLevelVariable("Blueprints", "owners")
+	if !ok {
+		t.Fatalf("missing owners in Blueprints")
+	}
+	if g, w := owners.Value, []string{"a", "b"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected owners %q, got %q", w, g)
+	}
+	if g, w := owners.Pos.String(), "Blueprints:4:11"; g != w {
+		t.Errorf("expected owners at %s, got %s", w, g)
+	}
+	if _, ok := ctx.TopLevelVariable("Blueprints", "vendor"); ok {
+		t.Errorf("expected vendor to not be set in Blueprints")
+	}
+}
+
+func TestTopLevelVariableErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterTopLevelVariable("team", parser.StringType)
+	ctx.RegisterTopLevelVariable("owners", parser.ListType)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			team = ["a"]
+			owners = "b"
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`Blueprints:3:11: "owners" must be a list of strings`,
+		`Blueprints:2:9: "team" must be a string`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// A ModuleTrace explains why a variant of a module exists, see Context.TraceModule.
+type ModuleTrace struct {
+	// Module is the traced variant.
+	Module *GraphModule
+
+	// Pos is the position of the module definition in its Blueprints file.  For modules created
+	// by CreateModule it is the position of the module that created it.
+	Pos scanner.Position
+
+	// CreatedBy is the name of the module whose load hook or mutator created the module with
+	// CreateModule, or empty if the module was defined in a Blueprints file.
+	CreatedBy string
+
+	// Splits lists the mutators that split the module into variants in the order they ran, along
+	// with the variation of each mutator that led to this variant.
+	Splits []TraceSplit
+
+	// Dependents lists the variants that depend directly on this variant, with the tags of their
+	// dependencies on it, sorted by name and variant.
+	Dependents []TraceStep
+
+	// Path is one of the shortest dependency paths from a variant of one of the roots to this
+	// variant, starting with the root and ending with this variant.  The Tag of each step is the
+	// tag of the dependency from the previous step.  Path is nil if this variant is not reachable
+	// from the roots.
+	Path []TraceStep
+}
+
+// A TraceSplit is a mutator that split a module into variants, see ModuleTrace.Splits.
+type TraceSplit struct {
+	Mutator   string
+	Variation string
+}
+
+// A TraceStep is a module variant reached through a dependency with the tag Tag.
+type TraceStep struct {
+	Module *GraphModule
+	Tag    DependencyTag
+}
+
+// TraceModule explains why the variants of the named module exist: where the module was defined,
+// which mutators split it into the variant, which variants depend on it directly, and one of the
+// shortest dependency paths that reach it from the variants of the roots.  If variant is empty
+// every variant of the module is traced, otherwise only the variant with that name.  If no roots
+// are given the modules that no other module depends on are used.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) TraceModule(name, variant string, roots ...string) ([]*ModuleTrace, error) {
+	graph, err := c.Graph()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*GraphModule
+	var variants []string
+	for _, m := range graph {
+		if m.Name != name {
+			continue
+		}
+		variants = append(variants, fmt.Sprintf("%q", m.Variant))
+		if variant == "" || m.Variant == variant {
+			targets = append(targets, m)
+		}
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("module %q not found", name)
+	} else if len(targets) == 0 {
+		return nil, fmt.Errorf("module %q has no variant %q, variants are %s", name, variant,
+			strings.Join(variants, ", "))
+	}
+
+	var rootModules []*GraphModule
+	if len(roots) > 0 {
+		rootModules, err = queryRoots(graph, roots)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rootModules = filterGraph(graph, func(m *GraphModule) bool { return len(m.ReverseDeps) == 0 })
+	}
+
+	// Breadth first search from all the roots, so that the path to each variant that is recorded
+	// is one of the shortest.
+	prev := make(map[*GraphModule]TraceStep)
+	queue := append([]*GraphModule(nil), rootModules...)
+	for _, root := range rootModules {
+		prev[root] = TraceStep{}
+	}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		for _, dep := range m.Deps {
+			if _, seen := prev[dep.Module]; !seen {
+				prev[dep.Module] = TraceStep{Module: m, Tag: dep.Tag}
+				queue = append(queue, dep.Module)
+			}
+		}
+	}
+
+	traces := make([]*ModuleTrace, 0, len(targets))
+	for _, m := range targets {
+		trace := &ModuleTrace{
+			Module: m,
+			Pos:    m.info.pos,
+		}
+		if m.info.createdBy != nil {
+			trace.CreatedBy = m.info.createdBy.Name()
+		}
+
+		for _, mutator := range c.variantMutatorNames {
+			if variation, ok := m.Variations[mutator]; ok {
+				trace.Splits = append(trace.Splits, TraceSplit{Mutator: mutator, Variation: variation})
+			}
+		}
+
+		seen := make(map[*GraphModule]bool)
+		for _, rdep := range m.ReverseDeps {
+			// ReverseDeps contains a variant once for each of its dependencies on this variant.
+			if seen[rdep] {
+				continue
+			}
+			seen[rdep] = true
+			for _, dep := range rdep.Deps {
+				if dep.Module == m {
+					trace.Dependents = append(trace.Dependents, TraceStep{Module: rdep, Tag: dep.Tag})
+				}
+			}
+		}
+		sort.SliceStable(trace.Dependents, func(i, j int) bool {
+			a, b := trace.Dependents[i].Module, trace.Dependents[j].Module
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Variant < b.Variant
+		})
+
+		if _, reachable := prev[m]; reachable {
+			for step := m; step != nil; step = prev[step].Module {
+				trace.Path = append([]TraceStep{{Module: step, Tag: prev[step].Tag}}, trace.Path...)
+			}
+		}
+
+		traces = append(traces, trace)
+	}
+
+	return traces, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTraceModule(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	ctx.RegisterBottomUpMutator("split", graphTestSplitMutator)
+	ctx.RegisterBottomUpMutator("deps", graphTestDepsMutator)
+
+	// A -> C, B (x, y) -> C, D
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			provider_module {
+				name: "A",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			provider_module {
+				name: "C",
+			}
+
+			provider_module {
+				name: "D",
+			}
+		`),
+	})
+
+	if _, err := ctx.TraceModule("C", ""); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	ids := func(steps []TraceStep) []string {
+		var ret []string
+		for _, step := range steps {
+			id := step.Module.Name + ":" + step.Module.Variant
+			if step.Tag != nil {
+				id += "<" + step.Tag.(graphTestDepTag).name + ">"
+			}
+			ret = append(ret, id)
+		}
+		return ret
+	}
+
+	traces, err := ctx.TraceModule("B", "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 {
+		t.Fatalf("want 1 trace, got %d", len(traces))
+	}
+	trace := traces[0]
+	if got, want := trace.Pos.String(), "Blueprints:7:4"; got != want {
+		t.Errorf("want position %q, got %q", want, got)
+	}
+	if want := []TraceSplit{{Mutator: "split", Variation: "y"}}; !reflect.DeepEqual(trace.Splits, want) {
+		t.Errorf("want splits %v, got %v", want, trace.Splits)
+	}
+	if trace.Dependents != nil {
+		t.Errorf("want no dependents, got %q", ids(trace.Dependents))
+	}
+	if got, want := ids(trace.Path), []string{"B:y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want path %q, got %q", want, got)
+	}
+
+	// Tracing every variant of C from the root A.
+	traces, err = ctx.TraceModule("C", "", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 {
+		t.Fatalf("want 1 trace, got %d", len(traces))
+	}
+	if got, want := ids(traces[0].Dependents), []string{"A:<dep>", "B:x<dep>", "B:y<dep>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want dependents %q, got %q", want, got)
+	}
+	if got, want := ids(traces[0].Path), []string{"A:", "C:<dep>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want path %q, got %q", want, got)
+	}
+
+	// A variant that isn't reachable from the roots has no path.
+	traces, err = ctx.TraceModule("C", "", "D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if traces[0].Path != nil {
+		t.Errorf("want no path, got %q", ids(traces[0].Path))
+	}
+
+	if _, err := ctx.TraceModule("B", "z"); err == nil || err.Error() != `module "B" has no variant "z", variants are "x", "y"` {
+		t.Errorf("unexpected error for missing variant: %v", err)
+	}
+	if _, err := ctx.TraceModule("E", ""); err == nil {
+		t.Errorf("expected error for missing module")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// TransitionMutator implements a mutator that creates the variants of a module based on the
+// variants of the modules that depend on it, instead of requiring every module to be split into
+// every variation and then relying on dependencies to pick the right one.
+//
+// The variations of a module are computed in a top down pass.  Split is called on each module to
+// get the variations it needs for itself, and then for each of those variations and each direct
+// dependency OutgoingTransition is called to get the variation the module wants from the
+// dependency, followed by IncomingTransition on the dependency to get the variation the dependency
+// will actually provide.  Every variation requested by a depending module is added to the
+// variations returned by Split for the dependency, except that a "" returned by Split is dropped
+// when any variation is requested, so modules are only split into the variations that are used.
+//
+// A bottom up pass then creates the variants and connects each one to the variants of its
+// dependencies that were requested for it, and finally Mutate is called on each new variant.
+//
+// A module whose only variation is "" is not split.
+//
+// The variation names of a transition mutator are also dependency variations, so
+// AddVariationDependencies can select them by the name the mutator was registered with.
+type TransitionMutator interface {
+	// Split returns the set of variations that should be created for a module no matter who
+	// depends on it.  It must return at least one variation.  Returning []string{""} requests that
+	// the module is not split unless a dependency on it requests another variation.
+	Split(ctx BaseModuleContext) []string
+
+	// OutgoingTransition is called on a module to determine which variation of a dependency it
+	// wants.  sourceVariation is the variation of the depending module.
+	OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string
+
+	// IncomingTransition is called on a dependency to determine which of its variations should be
+	// used to satisfy a request for incomingVariation from a depending module.
+	IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string
+
+	// Mutate is called on each variant after the variants have been created, and can be used to
+	// modify the properties of the variant for the given variation.
+	Mutate(ctx BottomUpMutatorContext, variation string)
+}
+
+type transitionContext interface {
+	// DepTag returns the dependency tag of the dependency that is being transitioned.
+	DepTag() DependencyTag
+
+	// Config returns the config object that was passed to Context.PrepareBuildActions.
+	Config() interface{}
+}
+
+// OutgoingTransitionContext is the context passed to TransitionMutator.OutgoingTransition.
+type OutgoingTransitionContext interface {
+	transitionContext
+
+	// Module returns the module that has the dependency.
+	Module() Module
+}
+
+// IncomingTransitionContext is the context passed to TransitionMutator.IncomingTransition.
+type IncomingTransitionContext interface {
+	transitionContext
+
+	// Module returns the module that is being depended on.
+	Module() Module
+}
+
+type transitionContextImpl struct {
+	source Module
+	dep    Module
+	depTag DependencyTag
+	config interface{}
+}
+
+func (c *transitionContextImpl) DepTag() DependencyTag {
+	return c.depTag
+}
+
+func (c *transitionContextImpl) Config() interface{} {
+	return c.config
+}
+
+type outgoingTransitionContextImpl struct {
+	transitionContextImpl
+}
+
+func (c *outgoingTransitionContextImpl) Module() Module {
+	return c.source
+}
+
+type incomingTransitionContextImpl struct {
+	transitionContextImpl
+}
+
+func (c *incomingTransitionContextImpl) Module() Module {
+	return c.dep
+}
+
+// transitionFunc returns the variation of dep that the sourceVariation variant of source depends
+// on through the dependency with the given tag.
+type transitionFunc func(source Module, sourceVariation string, dep Module, depTag DependencyTag) string
+
+type transitionMutatorImpl struct {
+	name    string
+	mutator TransitionMutator
+
+	// protects transitionVariations of modules while depending modules add to them in parallel
+	lock sync.Mutex
+}
+
+// RegisterTransitionMutator registers a TransitionMutator.  Like other mutators it runs in
+// registration order, and the variations it creates are named after it.  It is implemented
+// with a parallel top down mutator named name + "_deps" that computes the variations of each
+// module, a parallel bottom up mutator named name that creates them, and a parallel bottom up
+// mutator named name + "_mutate" that calls Mutate on each variant.
+func (c *Context) RegisterTransitionMutator(name string, mutator TransitionMutator) {
+	c.checkRegistration("RegisterTransitionMutator")
+
+	impl := &transitionMutatorImpl{name: name, mutator: mutator}
+
+	c.RegisterTopDownMutator(name+"_deps", impl.topDownMutator).Parallel()
+	c.RegisterBottomUpMutator(name, impl.bottomUpMutator).Parallel()
+	c.RegisterBottomUpMutator(name+"_mutate", impl.mutateMutator).Parallel()
+}
+
+func (t *transitionMutatorImpl) transition(config interface{}) transitionFunc {
+	return func(source Module, sourceVariation string, dep Module, depTag DependencyTag) string {
+		tc := transitionContextImpl{source: source, dep: dep, depTag: depTag, config: config}
+		outgoingVariation := t.mutator.OutgoingTransition(&outgoingTransitionContextImpl{tc}, sourceVariation)
+		return t.mutator.IncomingTransition(&incomingTransitionContextImpl{tc}, outgoingVariation)
+	}
+}
+
+func (t *transitionMutatorImpl) addRequiredVariation(module *moduleInfo, variation string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	module.transitionVariations = addToStringListIfNotPresent(module.transitionVariations, variation)
+}
+
+func (t *transitionMutatorImpl) topDownMutator(mctx TopDownMutatorContext) {
+	module := mctx.(*mutatorContext).module
+
+	splits := t.mutator.Split(mctx)
+	if len(splits) == 0 {
+		panic(fmt.Errorf("transition mutator %s returned no splits for module %s",
+			t.name, mctx.ModuleName()))
+	}
+
+	// All of the modules that depend on this module have already been visited by this top down
+	// mutator, so no other goroutine can be adding to its variations.
+	variations := module.transitionVariations
+	if len(variations) == 0 || len(splits) != 1 || splits[0] != "" {
+		// A module that doesn't ask to be split only needs the variations that were requested
+		// by the modules that depend on it.
+		variations = addToStringListIfNotPresent(variations, splits...)
+	}
+	sort.Strings(variations)
+	module.transitionVariations = variations
+
+	transition := t.transition(mctx.Config())
+	for _, variation := range variations {
+		for _, dep := range module.directDeps {
+			depVariation := transition(module.logicModule, variation, dep.module.logicModule, dep.tag)
+			t.addRequiredVariation(dep.module, depVariation)
+		}
+	}
+}
+
+func (t *transitionMutatorImpl) bottomUpMutator(mctx BottomUpMutatorContext) {
+	mc := mctx.(*mutatorContext)
+
+	variations := mc.module.transitionVariations
+	mc.module.transitionVariations = nil
+
+	if len(variations) == 0 {
+		panic(fmt.Errorf("no variations found for module %s by transition mutator %s",
+			mctx.ModuleName(), t.name))
+	}
+
+	transition := t.transition(mctx.Config())
+	if len(variations) == 1 && variations[0] == "" {
+		// The module is not split, but its dependencies may have been.
+		errs := mc.context.convertDepsToVariation(mc.module, "", chooseDepByTransition(t.name, transition))
+		mc.errs = append(mc.errs, errs...)
+	} else {
+		mc.createVariationsWithTransition(transition, variations...)
+	}
+}
+
+func (t *transitionMutatorImpl) mutateMutator(mctx BottomUpMutatorContext) {
+	module := mctx.(*mutatorContext).module
+	t.mutator.Mutate(mctx, module.variant.variations.get(t.name))
+}
+
+// chooseDepByTransition returns a depChooser that picks the variant of the dependency that the
+// transition selects for the variation of the depending module.
+func chooseDepByTransition(mutatorName string, transition transitionFunc) depChooser {
+	return func(source *moduleInfo, variationName string, dep depInfo) (*moduleInfo, string) {
+		// The dependency has already been split, use the first variant to represent it.  All of
+		// the variants have the same properties until Mutate is called on them.
+		depModule := dep.module.splitModules.firstModule().logicModule
+		depVariation := transition(source.logicModule, variationName, depModule, dep.tag)
+		return chooseDep(dep.module.splitModules, mutatorName, depVariation, nil)
+	}
+}
+
+// addToStringListIfNotPresent appends each item to list if it is not already in it.
+func addToStringListIfNotPresent(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTransitionBp = `
+	foo_module {
+		name: "A",
+		deps: ["B", "C"],
+	}
+
+	foo_module {
+		name: "B",
+		deps: ["D"],
+	}
+
+	foo_module {
+		name: "C",
+	}
+
+	foo_module {
+		name: "D",
+	}
+`
+
+// testTransitionMutator splits A into "a" and "b", leaves C unsplit, and redirects requests for
+// the "b" variation of B to a "c" variation.
+type testTransitionMutator struct{}
+
+func (testTransitionMutator) Split(ctx BaseModuleContext) []string {
+	if ctx.ModuleName() == "A" {
+		return []string{"a", "b"}
+	}
+	return []string{""}
+}
+
+func (testTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	return sourceVariation
+}
+
+func (testTransitionMutator) IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string {
+	switch ctx.Module().Name() {
+	case "C":
+		return ""
+	case "B":
+		if incomingVariation == "b" {
+			return "c"
+		}
+	}
+	return incomingVariation
+}
+
+func (testTransitionMutator) Mutate(ctx BottomUpMutatorContext, variation string) {
+	ctx.Module().(*fooModule).properties.Foo = variation
+}
+
+func runTestTransition(t *testing.T, bp string) *Context {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", testTransitionMutator{})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(bp),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	return ctx
+}
+
+// transitionVariants returns a description of each variant of a module, its Foo property and
+// the variants of its direct dependencies.
+func transitionVariants(ctx *Context, name string) []string {
+	var ret []string
+	for _, moduleOrAlias := range ctx.moduleGroupFromName(name, nil).modules {
+		module := moduleOrAlias.module()
+		if module == nil {
+			continue
+		}
+		var deps []string
+		for _, dep := range module.directDeps {
+			deps = append(deps, fmt.Sprintf("%s(%s)", dep.module.Name(),
+				dep.module.variant.variations.get("transition")))
+		}
+		ret = append(ret, fmt.Sprintf("%s: foo=%q deps=[%s]",
+			module.variant.variations.get("transition"),
+			module.logicModule.(*fooModule).properties.Foo,
+			strings.Join(deps, " ")))
+	}
+	return ret
+}
+
+func TestTransitionMutator(t *testing.T) {
+	ctx := runTestTransition(t, testTransitionBp)
+
+	testCases := []struct {
+		module string
+		want   []string
+	}{
+		{
+			module: "A",
+			want: []string{
+				`a: foo="a" deps=[B(a) C()]`,
+				`b: foo="b" deps=[B(c) C()]`,
+			},
+		},
+		{
+			module: "B",
+			want: []string{
+				`a: foo="a" deps=[D(a)]`,
+				`c: foo="c" deps=[D(c)]`,
+			},
+		},
+		{
+			module: "C",
+			want: []string{
+				`: foo="" deps=[]`,
+			},
+		},
+		{
+			module: "D",
+			want: []string{
+				`a: foo="a" deps=[]`,
+				`c: foo="c" deps=[]`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.module, func(t *testing.T) {
+			got := transitionVariants(ctx, testCase.module)
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("incorrect variants\nwant: %q\n got: %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+func TestTransitionMutatorVariationDependency(t *testing.T) {
+	ctx := runTestTransition(t, testTransitionBp)
+
+	// The variations created by a transition mutator can be requested by later mutators.
+	group := ctx.moduleGroupFromName("D", nil)
+	found, _ := ctx.findVariant(ctx.moduleGroupFromName("A", nil).modules.firstModule(), group,
+		[]Variation{{"transition", "c"}}, true, false)
+	if found == nil {
+		t.Fatalf("expected to find the c variant of D")
+	}
+	if got := found.variant.variations.get("transition"); got != "c" {
+		t.Errorf("expected the c variant of D, got %q", got)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/proptools"
+)
+
+// A VariableExpander returns the value of a variable referenced as $(name) in a string or list of
+// strings property set in a Blueprints file.  It returns false if the variable is unknown, which
+// is reported as an error at the position of the string in the Blueprints file.  An expander that
+// wants to leave a reference for a later stage, for example $(location) in a command, can return
+// the reference itself.  Blueprints files are parsed in parallel, so the expander may be called
+// from multiple goroutines at once.
+type VariableExpander func(ctx PropertyTagContext, name string) (value string, ok bool)
+
+// SetVariableExpander sets the VariableExpander that is used to expand $(name) references in the
+// string and list of strings properties of modules after they are unpacked from Blueprints files.
+// A literal $ can be written as $$ in a property value.  Properties are not expanded if no
+// VariableExpander is set.
+func (c *Context) SetVariableExpander(expander VariableExpander) {
+	c.checkRegistration("SetVariableExpander")
+	c.variableExpander = expander
+}
+
+// expandModuleVariables expands variable references in the properties of a module that were set
+// from the property definitions in propertyMap.
+func expandModuleVariables(module *moduleInfo, propertyMap map[string]*parser.Property,
+	expander VariableExpander) []error {
+
+	var errs []error
+
+	expand := func(propertyName string, s string, pos scanner.Position) string {
+		ctx := &propertyTagContext{module: module, propertyName: propertyName}
+		expanded, err := expandVariables(s, func(name string) (string, bool) {
+			return expander(ctx, name)
+		})
+		if err != nil {
+			errs = append(errs, &PropertyError{
+				ModuleError: ModuleError{
+					BlueprintError: BlueprintError{
+						Err: err,
+						Pos: pos,
+					},
+					module: module,
+				},
+				property: propertyName,
+			})
+		}
+		return expanded
+	}
+
+	var walk func(prefix string, structValue reflect.Value)
+	walk = func(prefix string, structValue reflect.Value) {
+		structType := structValue.Type()
+		for i := 0; i < structValue.NumField(); i++ {
+			field := structType.Field(i)
+			fieldValue := structValue.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			propertyName := prefix
+			if !field.Anonymous && field.Name != "BlueprintEmbed" {
+				propertyName = prefix + proptools.PropertyNameForField(field.Name)
+			}
+
+			if fieldValue.Kind() == reflect.Interface {
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+			}
+
+			if fieldValue.Kind() == reflect.Struct {
+				if propertyName == prefix {
+					walk(prefix, fieldValue)
+				} else {
+					walk(propertyName+".", fieldValue)
+				}
+				continue
+			}
+
+			property := propertyMap[propertyName]
+			if property == nil {
+				continue
+			}
+
+			switch {
+			case fieldValue.Kind() == reflect.String:
+				fieldValue.SetString(expand(propertyName, fieldValue.String(), stringPos(property, -1)))
+			case fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.String:
+				if !fieldValue.IsNil() {
+					expanded := expand(propertyName, fieldValue.Elem().String(), stringPos(property, -1))
+					fieldValue.Set(reflect.ValueOf(&expanded))
+				}
+			case fieldValue.Kind() == reflect.Slice && fieldValue.Type().Elem().Kind() == reflect.String:
+				for j := 0; j < fieldValue.Len(); j++ {
+					item := fieldValue.Index(j)
+					item.SetString(expand(propertyName, item.String(), stringPos(property, j)))
+				}
+			}
+		}
+	}
+
+	for _, properties := range module.properties {
+		walk("", reflect.ValueOf(properties).Elem())
+	}
+
+	return errs
+}
+
+// stringPos returns the position of the string value of a property, or of item i of its list
+// value if i is not negative, falling back to the position of the property.
+func stringPos(property *parser.Property, i int) scanner.Position {
+	value := property.Value.Eval()
+	if list, ok := value.(*parser.List); ok && i >= 0 && i < len(list.Values) {
+		value = list.Values[i].Eval()
+	}
+	if s, ok := value.(*parser.String); ok {
+		return s.Pos()
+	}
+	return property.ColonPos
+}
+
+// expandVariables replaces each $(name) in s with the value returned by lookup, and each $$ with $.
+func expandVariables(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+		case '(':
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				return s, fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			name := s[i+2 : i+2+end]
+			if name == "" {
+				return s, fmt.Errorf("empty variable reference in %q", s)
+			}
+			value, ok := lookup(name)
+			if !ok {
+				return s, fmt.Errorf("unknown variable %q", name)
+			}
+			sb.WriteString(value)
+			i += 2 + end
+		default:
+			sb.WriteByte('$')
+		}
+	}
+	return sb.String(), nil
+}
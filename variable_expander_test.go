@@ -0,0 +1,158 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type expanderTestModule struct {
+	SimpleName
+	properties struct {
+		Stem   string
+		Suffix *string
+		Srcs   []string
+		Target struct {
+			Host struct {
+				Cflags []string
+			}
+		}
+	}
+}
+
+func newExpanderTestModule() (Module, []interface{}) {
+	m := &expanderTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *expanderTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+func testVariableExpander(ctx PropertyTagContext, name string) (string, bool) {
+	switch name {
+	case "arch":
+		return "arm64", true
+	case "module":
+		return ctx.Module().Name(), true
+	case "property":
+		return ctx.PropertyName(), true
+	}
+	return "", false
+}
+
+func TestVariableExpander(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newExpanderTestModule)
+	ctx.SetVariableExpander(testVariableExpander)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "foo",
+				stem: "$(module)_$(arch)",
+				suffix: "$$(arch)",
+				srcs: ["$(arch)/a.c", "b.c"],
+				target: {
+					host: {
+						cflags: ["-D$(property)"],
+					},
+				},
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	foo := ctx.moduleGroupFromName("foo", nil).modules.firstModule().logicModule.(*expanderTestModule)
+	if g, w := foo.properties.Stem, "foo_arm64"; g != w {
+		t.Errorf("expected stem %q, got %q", w, g)
+	}
+	if g, w := *foo.properties.Suffix, "$(arch)"; g != w {
+		t.Errorf("expected suffix %q, got %q", w, g)
+	}
+	if g, w := foo.properties.Srcs, []string{"arm64/a.c", "b.c"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected srcs %q, got %q", w, g)
+	}
+	if g, w := foo.properties.Target.Host.Cflags, []string{"-Dtarget.host.cflags"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected cflags %q, got %q", w, g)
+	}
+}
+
+func TestVariableExpanderErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test", newExpanderTestModule)
+	ctx.SetVariableExpander(testVariableExpander)
+	ctx.MockFileSystem(map[string][]byte{
+		"Blueprints": []byte(`
+			test {
+				name: "foo",
+				stem: "$(unknown)",
+				srcs: ["a.c", "$(arch"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`Blueprints:4:11: module "foo": stem: unknown variable "unknown"`,
+		`Blueprints:5:19: module "foo": srcs: unterminated variable reference in "$(arch"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect errors:\nwant: %q\n got: %q", want, got)
+	}
+}
+
+func TestExpandVariables(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "x" {
+			return "1", true
+		}
+		return "", false
+	}
+
+	testCases := []struct {
+		in, out, err string
+	}{
+		{in: "abc", out: "abc"},
+		{in: "$(x)", out: "1"},
+		{in: "a$(x)b$(x)c", out: "a1b1c"},
+		{in: "$$(x)", out: "$(x)"},
+		{in: "$x $", out: "$x $"},
+		{in: "$(y)", err: `unknown variable "y"`},
+		{in: "$()", err: `empty variable reference in "$()"`},
+		{in: "$(x", err: `unterminated variable reference in "$(x"`},
+	}
+
+	for _, testCase := range testCases {
+		out, err := expandVariables(testCase.in, lookup)
+		if testCase.err != "" {
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("%q: expected error %q, got %v", testCase.in, testCase.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error %s", testCase.in, err)
+		} else if out != testCase.out {
+			t.Errorf("%q: expected %q, got %q", testCase.in, testCase.out, out)
+		}
+	}
+}
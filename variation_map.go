@@ -0,0 +1,182 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A variationMap stores a map of Mutator to Variation to specify a variant of a module.
+//
+// A large tree has millions of variants but only a few thousand distinct sets of variations, so
+// variationMaps are immutable and interned by the variationMapInterner of their Context: the
+// entries are stored once in a slice sorted by mutator name that is shared by every variationMap
+// with the same entries, and set returns a new variationMap instead of modifying the existing
+// one.  Two variationMaps from the same variationMapInterner are equal if and only if they share
+// the same entries, variationMaps from different interners must not be compared.  The zero value
+// is an empty variationMap.
+type variationMap struct {
+	interned *internedVariations
+}
+
+type variationEntry struct {
+	mutator   string
+	variation string
+}
+
+type internedVariations struct {
+	entries []variationEntry
+}
+
+// A variationMapInterner holds every distinct set of variations of the variants of a Context,
+// keyed by variationsKey.  It is owned by the Context so that the variations are released along
+// with the rest of the module graph instead of growing for the lifetime of the process.
+type variationMapInterner struct {
+	sync.RWMutex
+	m map[string]*internedVariations
+}
+
+func newVariationMapInterner() *variationMapInterner {
+	return &variationMapInterner{m: make(map[string]*internedVariations)}
+}
+
+// variationsKey returns the key of a sorted list of entries in a variationMapInterner.
+func variationsKey(entries []variationEntry) string {
+	sb := strings.Builder{}
+	for _, e := range entries {
+		sb.WriteString(e.mutator)
+		sb.WriteByte(0)
+		sb.WriteString(e.variation)
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// intern returns the variationMap for a sorted list of entries, which must not be modified
+// afterwards.
+func (vi *variationMapInterner) intern(entries []variationEntry) variationMap {
+	if len(entries) == 0 {
+		return variationMap{}
+	}
+	key := variationsKey(entries)
+
+	vi.RLock()
+	interned, ok := vi.m[key]
+	vi.RUnlock()
+	if ok {
+		return variationMap{interned}
+	}
+
+	vi.Lock()
+	defer vi.Unlock()
+	if interned, ok := vi.m[key]; ok {
+		return variationMap{interned}
+	}
+	interned = &internedVariations{entries}
+	vi.m[key] = interned
+	return variationMap{interned}
+}
+
+// newVariationMap returns a variationMap with the entries of m.
+func (vi *variationMapInterner) newVariationMap(m map[string]string) variationMap {
+	entries := make([]variationEntry, 0, len(m))
+	for mutator, variation := range m {
+		entries = append(entries, variationEntry{mutator, variation})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mutator < entries[j].mutator })
+	return vi.intern(entries)
+}
+
+// set returns a variationMap with the entries of vm and mutator set to variation.
+func (vi *variationMapInterner) set(vm variationMap, mutator, variation string) variationMap {
+	entries := vm.entries()
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].mutator >= mutator })
+	if i < len(entries) && entries[i].mutator == mutator {
+		if entries[i].variation == variation {
+			return vm
+		}
+		newEntries := append([]variationEntry(nil), entries...)
+		newEntries[i].variation = variation
+		return vi.intern(newEntries)
+	}
+
+	newEntries := make([]variationEntry, 0, len(entries)+1)
+	newEntries = append(newEntries, entries[:i]...)
+	newEntries = append(newEntries, variationEntry{mutator, variation})
+	newEntries = append(newEntries, entries[i:]...)
+	return vi.intern(newEntries)
+}
+
+// entries returns the entries of the variationMap sorted by mutator name.  The returned slice
+// must not be modified.
+func (vm variationMap) entries() []variationEntry {
+	if vm.interned == nil {
+		return nil
+	}
+	return vm.interned.entries
+}
+
+func (vm variationMap) len() int {
+	return len(vm.entries())
+}
+
+// lookup returns the variation for mutator, and whether the variationMap has an entry for it.
+func (vm variationMap) lookup(mutator string) (string, bool) {
+	entries := vm.entries()
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].mutator >= mutator })
+	if i < len(entries) && entries[i].mutator == mutator {
+		return entries[i].variation, true
+	}
+	return "", false
+}
+
+// get returns the variation for mutator, or "" if the variationMap has no entry for it.
+func (vm variationMap) get(mutator string) string {
+	variation, _ := vm.lookup(mutator)
+	return variation
+}
+
+// Compare this variationMap to another one.  Returns true if the every entry in this map
+// exists and has the same value in the other map.
+func (vm variationMap) subsetOf(other variationMap) bool {
+	if vm.interned == other.interned {
+		return true
+	}
+	for _, e := range vm.entries() {
+		if v, ok := other.lookup(e.mutator); !ok || v != e.variation {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm variationMap) equal(other variationMap) bool {
+	return vm.interned == other.interned
+}
+
+// toMap returns a copy of the entries as a map, or nil if the variationMap is empty.
+func (vm variationMap) toMap() map[string]string {
+	entries := vm.entries()
+	if len(entries) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(entries))
+	for _, e := range entries {
+		m[e.mutator] = e.variation
+	}
+	return m
+}
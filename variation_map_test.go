@@ -0,0 +1,96 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestVariationMap(t *testing.T) {
+	vi := newVariationMapInterner()
+
+	var empty variationMap
+	if empty.len() != 0 || empty.get("arch") != "" || empty.toMap() != nil {
+		t.Errorf("expected zero variationMap to be empty")
+	}
+
+	a := vi.set(vi.set(empty, "link", "shared"), "arch", "arm64")
+	b := vi.newVariationMap(map[string]string{"arch": "arm64", "link": "shared"})
+	if !a.equal(b) {
+		t.Errorf("expected %v to equal %v", a.toMap(), b.toMap())
+	}
+	if got, want := a.entries(), []variationEntry{{"arch", "arm64"}, {"link", "shared"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected entries %q, got %q", want, got)
+	}
+
+	if v, ok := a.lookup("arch"); !ok || v != "arm64" {
+		t.Errorf("expected arch to be arm64, got %q, %v", v, ok)
+	}
+	if _, ok := a.lookup("os"); ok {
+		t.Errorf("expected os to be missing")
+	}
+
+	c := vi.set(a, "arch", "arm")
+	if c.equal(a) || a.get("arch") != "arm64" || c.get("arch") != "arm" {
+		t.Errorf("expected set to return a new variationMap without modifying the old one")
+	}
+	if vi.set(a, "arch", "arm64") != a {
+		t.Errorf("expected set with an existing value to return the same variationMap")
+	}
+
+	arch := vi.set(empty, "arch", "arm64")
+	if !arch.subsetOf(a) || a.subsetOf(arch) || !empty.subsetOf(a) || arch.subsetOf(c) {
+		t.Errorf("incorrect subsetOf")
+	}
+}
+
+func BenchmarkVariationMap(b *testing.B) {
+	archs := []string{"arm", "arm64", "x86", "x86_64"}
+	links := []string{"shared", "static"}
+
+	vi := newVariationMapInterner()
+
+	b.Run("set", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			for _, arch := range archs {
+				vm := vi.set(vi.set(variationMap{}, "os", "android"), "arch", arch)
+				for _, link := range links {
+					vi.set(vm, "link", link)
+				}
+			}
+		}
+	})
+
+	b.Run("equal", func(b *testing.B) {
+		var vms []variationMap
+		for i := 0; i < 100; i++ {
+			vm := vi.set(variationMap{}, "os", "android")
+			vm = vi.set(vm, "arch", archs[i%len(archs)])
+			vm = vi.set(vm, "link", links[i%len(links)])
+			vms = append(vms, vi.set(vm, "apex", "apex"+strconv.Itoa(i%10)))
+		}
+		target := vms[len(vms)-1]
+		b.ReportAllocs()
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			for _, vm := range vms {
+				vm.equal(target)
+			}
+		}
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// The version of the blueprint library, following semantic versioning: the minor version is
+// incremented when features are added, and the major version when existing APIs change
+// incompatibly.
+const (
+	VersionMajor = 1
+	VersionMinor = 0
+	VersionPatch = 0
+)
+
+// Version returns the version of the blueprint library, in the form "major.minor.patch".
+func Version() string {
+	return fmt.Sprintf("%d.%d.%d", VersionMajor, VersionMinor, VersionPatch)
+}
+
+// CheckVersion returns an error if the version of the blueprint library is older than minimum,
+// which is a version string like "1.2" or "1.2.3".  Primary builders and plugin libraries can call
+// it at startup to fail with a clear message instead of misbehaving.
+func CheckVersion(minimum string) error {
+	cmp, err := proptools.CompareVersions(Version(), minimum)
+	if err != nil {
+		return fmt.Errorf("minimum blueprint version: %w", err)
+	}
+	if cmp < 0 {
+		return fmt.Errorf("blueprint version %s is older than the required version %s", Version(), minimum)
+	}
+	return nil
+}
+
+// A Capability is a feature of the blueprint library that embedders can test for at runtime with
+// HasCapability, so that they can adapt to the version of blueprint they are linked against.
+type Capability string
+
+const (
+	// CapabilityProviders is support for providers, see NewProvider.
+	CapabilityProviders Capability = "providers"
+
+	// CapabilityAliases is support for variant aliases, see BottomUpMutatorContext.AliasVariation
+	// and BottomUpMutatorContext.CreateAliasVariation.
+	CapabilityAliases Capability = "aliases"
+
+	// CapabilityTransitions is support for transition mutators, see TransitionMutator.
+	CapabilityTransitions Capability = "transitions"
+
+	// CapabilityLazyVariants is support for variants created on demand, see
+	// BottomUpMutatorContext.CreateLazyVariations.
+	CapabilityLazyVariants Capability = "lazy-variants"
+
+	// CapabilityDefaults is support for defaults modules, see Context.RegisterDefaultsModuleType.
+	CapabilityDefaults Capability = "defaults"
+
+	// CapabilityOverrides is support for modules that replace other modules, see
+	// OverridingModule.
+	CapabilityOverrides Capability = "overrides"
+
+	// CapabilityPostMutators is support for post mutators, see Context.RegisterPostMutator.
+	CapabilityPostMutators Capability = "post-mutators"
+
+	// CapabilityFinalChecks is support for final checks, see Context.RegisterFinalCheck.
+	CapabilityFinalChecks Capability = "final-checks"
+
+	// CapabilitySelects is support for select expressions in Blueprints files, see
+	// Context.SetSelectEvaluator.
+	CapabilitySelects Capability = "selects"
+
+	// CapabilityNamespaces is support for hierarchical namespaces, see NamespaceNameInterface.
+	CapabilityNamespaces Capability = "namespaces"
+
+	// CapabilityStrictActions is support for strict build statements and the action manifest,
+	// see BuildParams.Strict.
+	CapabilityStrictActions Capability = "strict-actions"
+
+	// CapabilityWarningClasses is support for classified warnings that can be promoted to errors,
+	// see Context.SetWarningsAsErrors.
+	CapabilityWarningClasses Capability = "warning-classes"
+
+	// CapabilityDefaultTargets is support for marking variants as default targets, see
+	// BaseMutatorContext.MarkDefaultTarget.
+	CapabilityDefaultTargets Capability = "default-targets"
+
+	// CapabilityHeavyModules is support for running expensive modules in a separate pool, see
+	// HeavyModule.
+	CapabilityHeavyModules Capability = "heavy-modules"
+
+	// CapabilityPropertyProvenance is support for tracking the sources of property values, see
+	// Context.SetTrackPropertyProvenance.
+	CapabilityPropertyProvenance Capability = "property-provenance"
+
+	// CapabilityFixtures is support for extracting modules into a standalone tree, see
+	// Context.ExtractFixture.
+	CapabilityFixtures Capability = "fixtures"
+
+	// CapabilityVisibility is support for restricting which modules can depend on a module, see
+	// VisibilityModule.
+	CapabilityVisibility Capability = "visibility"
+
+	// CapabilityStableNinjaNames is support for naming global Ninja definitions by a hash of their
+	// contents, see Context.SetStableNinjaNames.
+	CapabilityStableNinjaNames Capability = "stable-ninja-names"
+
+	// CapabilityVariantPruning is support for skipping the module variants that are not reachable
+	// from any root, see Context.SetRootModulePredicate.
+	CapabilityVariantPruning Capability = "variant-pruning"
+
+	// CapabilityFileParsers is support for module definitions in alternate file formats, see
+	// Context.RegisterFileParser.
+	CapabilityFileParsers Capability = "file-parsers"
+
+	// CapabilityPrebuilts is support for choosing between prebuilt and source modules, see
+	// PrebuiltModule.
+	CapabilityPrebuilts Capability = "prebuilts"
+
+	// CapabilityDiagnostics is support for collecting the errors of every phase as structured
+	// diagnostics, see Context.SetCollectDiagnostics.
+	CapabilityDiagnostics Capability = "diagnostics"
+
+	// CapabilityParallelSingletons is support for running singletons concurrently, see
+	// Context.RegisterParallelSingletonType.
+	CapabilityParallelSingletons Capability = "parallel-singletons"
+
+	// CapabilityActionDigests is support for writing a digest of every build statement, see
+	// Context.WriteActionDigests.
+	CapabilityActionDigests Capability = "action-digests"
+
+	// CapabilityOptionalDependencies is support for dependencies that are skipped if the module
+	// doesn't exist, see BottomUpMutatorContext.AddOptionalDependency.
+	CapabilityOptionalDependencies Capability = "optional-dependencies"
+
+	// CapabilityPhonyTargets is support for phony targets declared by multiple modules and
+	// singletons, see ModuleContext.Phony.
+	CapabilityPhonyTargets Capability = "phony-targets"
+
+	// CapabilityDependencyCycles is support for reporting every dependency cycle of a deadlocked
+	// parallel mutator, see Context.DependencyCycles.
+	CapabilityDependencyCycles Capability = "dependency-cycles"
+
+	// CapabilityPropertyOverrides is support for changing properties of modules from outside of
+	// the Blueprints files, see Context.AddPropertyOverrides.
+	CapabilityPropertyOverrides Capability = "property-overrides"
+
+	// CapabilityBuildActionsCache is support for skipping GenerateBuildActions for modules whose
+	// inputs haven't changed, see CacheableModule and Context.SetBuildActionsCacheFile.
+	CapabilityBuildActionsCache Capability = "build-actions-cache"
+
+	// CapabilityNameSuggestions is support for suggesting similar module names when a dependency
+	// doesn't exist, see NameSuggester.
+	CapabilityNameSuggestions Capability = "name-suggestions"
+
+	// CapabilityDeprecatedProperties is support for warning about properties tagged
+	// `blueprint:"deprecated=..."`, see WarningClassDeprecatedProperty.
+	CapabilityDeprecatedProperties Capability = "deprecated-properties"
+
+	// CapabilityModuleTypeAliases is support for renaming module types while keeping the old
+	// names, see Context.RegisterModuleTypeAlias.
+	CapabilityModuleTypeAliases Capability = "module-type-aliases"
+
+	// CapabilityPackageDefaults is support for package modules that set the defaults of the
+	// modules in a directory and its subdirectories, see PackageDefaults.
+	CapabilityPackageDefaults Capability = "package-defaults"
+
+	// CapabilityModuleDiscovery is support for finding the Blueprints files by walking the source
+	// tree, see Context.SetModuleDiscovery.
+	CapabilityModuleDiscovery Capability = "module-discovery"
+
+	// CapabilityModuleMetadata is support for ModuleContext.RecordMetadata and
+	// Context.SetMetadataFile.
+	CapabilityModuleMetadata Capability = "module-metadata"
+)
+
+var capabilities = map[Capability]bool{
+	CapabilityProviders:            true,
+	CapabilityAliases:              true,
+	CapabilityTransitions:          true,
+	CapabilityLazyVariants:         true,
+	CapabilityDefaults:             true,
+	CapabilityOverrides:            true,
+	CapabilityPostMutators:         true,
+	CapabilityFinalChecks:          true,
+	CapabilitySelects:              true,
+	CapabilityNamespaces:           true,
+	CapabilityStrictActions:        true,
+	CapabilityWarningClasses:       true,
+	CapabilityDefaultTargets:       true,
+	CapabilityHeavyModules:         true,
+	CapabilityPropertyProvenance:   true,
+	CapabilityFixtures:             true,
+	CapabilityVisibility:           true,
+	CapabilityStableNinjaNames:     true,
+	CapabilityVariantPruning:       true,
+	CapabilityFileParsers:          true,
+	CapabilityPrebuilts:            true,
+	CapabilityDiagnostics:          true,
+	CapabilityParallelSingletons:   true,
+	CapabilityActionDigests:        true,
+	CapabilityOptionalDependencies: true,
+	CapabilityPhonyTargets:         true,
+	CapabilityDependencyCycles:     true,
+	CapabilityPropertyOverrides:    true,
+	CapabilityBuildActionsCache:    true,
+	CapabilityNameSuggestions:      true,
+	CapabilityDeprecatedProperties: true,
+	CapabilityModuleTypeAliases:    true,
+	CapabilityPackageDefaults:      true,
+	CapabilityModuleDiscovery:      true,
+	CapabilityModuleMetadata:       true,
+}
+
+// HasCapability returns true if the blueprint library supports the given capability.  Unknown
+// capabilities, for example ones added by a newer version of blueprint, return false.
+func HasCapability(capability Capability) bool {
+	return capabilities[capability]
+}
+
+// Capabilities returns all the capabilities supported by the blueprint library, sorted.
+func Capabilities() []Capability {
+	ret := make([]Capability, 0, len(capabilities))
+	for capability := range capabilities {
+		ret = append(ret, capability)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
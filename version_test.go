@@ -0,0 +1,61 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCheckVersion(t *testing.T) {
+	testCases := []struct {
+		minimum string
+		wantErr bool
+	}{
+		{minimum: "0.9"},
+		{minimum: Version()},
+		{minimum: "1"},
+		{minimum: "1.0.0"},
+		{minimum: "1.1", wantErr: true},
+		{minimum: "2.0.0", wantErr: true},
+		{minimum: "not.a.version", wantErr: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.minimum, func(t *testing.T) {
+			err := CheckVersion(testCase.minimum)
+			if gotErr := err != nil; gotErr != testCase.wantErr {
+				t.Errorf("CheckVersion(%q) returned error %v, want error %v", testCase.minimum, err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	capabilities := Capabilities()
+	if !sort.SliceIsSorted(capabilities, func(i, j int) bool { return capabilities[i] < capabilities[j] }) {
+		t.Errorf("capabilities are not sorted: %q", capabilities)
+	}
+	for _, capability := range capabilities {
+		if !HasCapability(capability) {
+			t.Errorf("HasCapability(%q) = false for a listed capability", capability)
+		}
+	}
+	if !HasCapability(CapabilityProviders) {
+		t.Errorf("expected capability %q", CapabilityProviders)
+	}
+	if HasCapability("unknown") {
+		t.Errorf("unexpected capability %q", "unknown")
+	}
+}
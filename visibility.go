@@ -0,0 +1,205 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A VisibilityModule is a Module that restricts which modules may depend on it.  Its Visibility
+// method returns a list of rules, each of which allows the modules in some directories to depend
+// on it:
+//
+//	"//visibility:public"        any module, the same as an empty list of rules
+//	"//visibility:private"       only modules in the same directory
+//	"//path/to:__pkg__"          modules in the directory path/to
+//	"//path/to:__subpackages__"  modules in the directory path/to and its subdirectories
+//	":__subpackages__"           modules in the same directory and its subdirectories
+//
+// Directories are relative to the root of the source tree, "//:__subpackages__" is the whole
+// tree.  A module without visibility rules uses the default visibility of its package, see
+// PackageDefaults.  Modules in the same directory can always depend on each other.  The rules are checked
+// when a dependency is added by a mutator, and a dependency on a module that is not visible to the
+// depending module is reported as an error matching ErrNotVisible.
+type VisibilityModule interface {
+	Module
+
+	// Visibility returns the visibility rules of the module.
+	Visibility() []string
+}
+
+// SimpleVisibility is an embeddable object to implement the VisibilityModule interface for a
+// module type with a visibility property.  The Properties field must be included in the property
+// structs returned by the module factory.
+type SimpleVisibility struct {
+	Properties struct {
+		Visibility []string
+	}
+}
+
+func (s *SimpleVisibility) Visibility() []string {
+	return s.Properties.Visibility
+}
+
+const (
+	visibilityPublic  = "//visibility:public"
+	visibilityPrivate = "//visibility:private"
+)
+
+// A visibilityRule allows modules in dir, and also in its subdirectories if subpackages is true,
+// or all modules if public is true.
+type visibilityRule struct {
+	public      bool
+	dir         string
+	subpackages bool
+}
+
+// parseVisibilityRule parses a visibility rule of a module in moduleDir.
+func parseVisibilityRule(rule, moduleDir string) (visibilityRule, error) {
+	switch rule {
+	case visibilityPublic:
+		return visibilityRule{public: true}, nil
+	case visibilityPrivate:
+		return visibilityRule{dir: moduleDir}, nil
+	}
+
+	invalid := func() (visibilityRule, error) {
+		return visibilityRule{}, fmt.Errorf("invalid visibility rule %q, expected %q, %q, "+
+			`"//path:__pkg__" or "//path:__subpackages__"`, rule, visibilityPublic, visibilityPrivate)
+	}
+
+	i := strings.LastIndexByte(rule, ':')
+	if i < 0 {
+		return invalid()
+	}
+	dir := moduleDir
+	if i > 0 {
+		if !strings.HasPrefix(rule, "//") {
+			return invalid()
+		}
+		dir = strings.TrimSuffix(rule[2:i], "/")
+		if dir == "" {
+			dir = "."
+		}
+	}
+	switch rule[i+1:] {
+	case "__pkg__":
+		return visibilityRule{dir: dir}, nil
+	case "__subpackages__":
+		return visibilityRule{dir: dir, subpackages: true}, nil
+	default:
+		return invalid()
+	}
+}
+
+func (r visibilityRule) allows(dir string) bool {
+	switch {
+	case r.public || r.dir == dir:
+		return true
+	case r.subpackages:
+		return r.dir == "." || strings.HasPrefix(dir, r.dir+"/")
+	default:
+		return false
+	}
+}
+
+// moduleVisibility returns the visibility rules of a module, or nil if it doesn't restrict
+// visibility.
+func moduleVisibility(module *moduleInfo) []string {
+	if v, ok := module.logicModule.(VisibilityModule); ok {
+		return v.Visibility()
+	}
+	return nil
+}
+
+// checkVisibilityRules reports invalid visibility rules as errors in the modules that declare
+// them.
+func (c *Context) checkVisibilityRules() (errs []error) {
+	for _, module := range c.modulesSorted {
+		rules := moduleVisibility(module)
+		for _, rule := range rules {
+			var err error
+			if (rule == visibilityPublic || rule == visibilityPrivate) && len(rules) > 1 {
+				err = fmt.Errorf("visibility rule %q cannot be combined with other rules", rule)
+			} else {
+				_, err = parseVisibilityRule(rule, module.dir())
+			}
+			if err != nil {
+				pos, ok := module.propertyPos["visibility"]
+				if !ok {
+					pos = module.pos
+				}
+				errs = append(errs, &PropertyError{
+					ModuleError: ModuleError{
+						BlueprintError: BlueprintError{Err: err, Pos: pos},
+						module:         module,
+					},
+					property: "visibility",
+				})
+				break
+			}
+		}
+		if len(errs) > maxErrors {
+			return errs
+		}
+	}
+	return errs
+}
+
+// checkVisibility returns an error if module is not allowed to depend on dep by the visibility
+// rules of dep, or by the default visibility of its package if it doesn't have any.
+func (c *Context) checkVisibility(module, dep *moduleInfo) error {
+	dir := module.dir()
+	if dep.dir() == dir {
+		return nil
+	}
+
+	rules := moduleVisibility(dep)
+	pos, posOk := dep.propertyPos["visibility"]
+	setBy := "visibility"
+	if len(rules) == 0 {
+		var defaults PackageDefaults
+		defaults, pos = c.packageDefaults(dep.dir())
+		rules = defaults.Default_visibility
+		posOk = pos.IsValid()
+		setBy = "default_visibility of the package"
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	for _, rule := range rules {
+		// Invalid rules are reported by checkVisibilityRules, and never allow anything.
+		if r, err := parseVisibilityRule(rule, dep.dir()); err == nil && r.allows(dir) {
+			return nil
+		}
+	}
+
+	quoted := make([]string, len(rules))
+	for i, rule := range rules {
+		quoted[i] = fmt.Sprintf("%q", rule)
+	}
+	msg := fmt.Sprintf("%q in directory %q depends on %q, which is not visible to it\n"+
+		"  %q in directory %q has visibility [%s]",
+		module.Name(), dir, dep.Name(), dep.Name(), dep.dir(), strings.Join(quoted, ", "))
+	if posOk {
+		msg += fmt.Sprintf("\n  %s set at %s", setBy, pos)
+	}
+	return &BlueprintError{
+		Err: errorWithKind(ErrNotVisible, errors.New(msg)),
+		Pos: module.pos,
+	}
+}
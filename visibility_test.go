@@ -0,0 +1,163 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type visibilityTestModule struct {
+	fooModule
+	SimpleVisibility
+}
+
+func newVisibilityTestModule() (Module, []interface{}) {
+	m := &visibilityTestModule{}
+	return m, []interface{}{&m.fooModule.properties, &m.SimpleName.Properties, &m.SimpleVisibility.Properties}
+}
+
+func TestVisibility(t *testing.T) {
+	testCases := []struct {
+		name       string
+		visibility string
+		dependent  string
+		wantErr    string
+		notVisible bool
+	}{
+		{
+			name:      "default public",
+			dependent: "other",
+		},
+		{
+			name:       "public",
+			visibility: `["//visibility:public"]`,
+			dependent:  "other",
+		},
+		{
+			name:       "private same directory",
+			visibility: `["//visibility:private"]`,
+			dependent:  "lib",
+		},
+		{
+			name:       "private",
+			visibility: `["//visibility:private"]`,
+			dependent:  "other",
+			wantErr:    `"user" in directory "other" depends on "libfoo", which is not visible to it`,
+			notVisible: true,
+		},
+		{
+			name:       "pkg",
+			visibility: `["//other:__pkg__"]`,
+			dependent:  "other",
+		},
+		{
+			name:       "pkg excludes subdirectories",
+			visibility: `["//other:__pkg__"]`,
+			dependent:  "other/sub",
+			wantErr:    `"libfoo" in directory "lib" has visibility ["//other:__pkg__"]`,
+			notVisible: true,
+		},
+		{
+			name:       "subpackages",
+			visibility: `["//other:__subpackages__"]`,
+			dependent:  "other/sub",
+		},
+		{
+			name:       "subpackages excludes siblings",
+			visibility: `["//other:__subpackages__"]`,
+			dependent:  "otherwise",
+			wantErr:    `visibility set at lib/Blueprints:4:17`,
+			notVisible: true,
+		},
+		{
+			name:       "own subpackages",
+			visibility: `[":__subpackages__"]`,
+			dependent:  "lib/sub",
+		},
+		{
+			name:       "root subpackages",
+			visibility: `["//:__subpackages__"]`,
+			dependent:  "other",
+		},
+		{
+			name:       "invalid rule",
+			visibility: `["other"]`,
+			dependent:  "lib",
+			wantErr:    `lib/Blueprints:4:17: module "libfoo": visibility: invalid visibility rule "other"`,
+		},
+		{
+			name:       "public combined",
+			visibility: `["//visibility:public", "//other:__pkg__"]`,
+			dependent:  "lib",
+			wantErr:    `visibility rule "//visibility:public" cannot be combined with other rules`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			visibility := ""
+			if testCase.visibility != "" {
+				visibility = "visibility: " + testCase.visibility + ","
+			}
+			ctx := NewContext()
+			ctx.RegisterModuleType("foo_module", newVisibilityTestModule)
+			ctx.RegisterBottomUpMutator("deps", depsMutator)
+			files := map[string]string{
+				"Blueprints": `subdirs = ["*"]`,
+				"lib/Blueprints": `
+					foo_module {
+						name: "libfoo",
+						` + visibility + `
+					}
+				`,
+			}
+			files[testCase.dependent+"/Blueprints"] += `
+					foo_module {
+						name: "user",
+						deps: ["libfoo"],
+					}
+				`
+			mockFS := make(map[string][]byte)
+			for name, contents := range files {
+				mockFS[name] = []byte(contents)
+			}
+			ctx.MockFileSystem(mockFS)
+			ctx.SetModuleListFile(MockModuleListFile)
+
+			_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+			if len(errs) == 0 {
+				_, errs = ctx.ResolveDependencies(nil)
+			}
+
+			if testCase.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %q", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("want a single error %q, got %q", testCase.wantErr, errs)
+			}
+			if !strings.Contains(errs[0].Error(), testCase.wantErr) {
+				t.Errorf("want error containing %q, got %q", testCase.wantErr, errs[0])
+			}
+			if errors.Is(errs[0], ErrNotVisible) != testCase.notVisible {
+				t.Errorf("want error matching ErrNotVisible %v, got %q", testCase.notVisible, errs[0])
+			}
+		})
+	}
+}
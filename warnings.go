@@ -0,0 +1,107 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+// A WarningClass identifies the source of a warning, so that a class of warnings can be
+// promoted to errors with Context.SetWarningsAsErrors.
+type WarningClass string
+
+const (
+	// WarningClassModule is the class of the warnings reported by the Warningf and
+	// PropertyWarningf methods of module, mutator and post mutator contexts.
+	WarningClassModule WarningClass = "module"
+
+	// WarningClassSingleton is the class of the warnings reported by SingletonContext.Warningf.
+	WarningClassSingleton WarningClass = "singleton"
+
+	// WarningClassSharedProvider is the class of the warnings enabled by
+	// Context.SetDetectSharedProviders.
+	WarningClassSharedProvider WarningClass = "shared-provider"
+
+	// WarningClassFormat is the class of the warnings enabled by Context.SetFormatCheck with
+	// FormatCheckWarning.
+	WarningClassFormat WarningClass = "format"
+
+	// WarningClassDeprecatedProperty is the class of the warnings for properties tagged
+	// `blueprint:"deprecated=..."` that are set in Blueprints files.  Promote it to errors with
+	// SetWarningsAsErrors to forbid using deprecated properties.
+	WarningClassDeprecatedProperty WarningClass = "deprecated-property"
+
+	// WarningClassModuleTypeAlias is the class of the warnings for modules defined with an alias
+	// registered with Context.RegisterModuleTypeAlias instead of the name of their module type.
+	WarningClassModuleTypeAlias WarningClass = "module-type-alias"
+)
+
+// A Warning is a diagnostic that doesn't cause a phase of the Context to fail, returned by
+// Context.Warnings.  Use errors.As on a Warning to get the *BlueprintError, *ModuleError or
+// *PropertyError that holds its location, the same way as for errors.
+type Warning struct {
+	// Class is the source of the warning.
+	Class WarningClass
+
+	// Err describes the warning, with the same message and location that an error reported by
+	// the same code would have.
+	Err error
+}
+
+func (w *Warning) Error() string {
+	return w.Err.Error()
+}
+
+func (w *Warning) Unwrap() error {
+	return w.Err
+}
+
+// SetWarningsAsErrors promotes the warnings of the given classes to errors.  A promoted warning
+// is returned as an error by the ParseBlueprintsFiles, ResolveDependencies or
+// PrepareBuildActions call during which it was reported, instead of by Warnings.  The returned
+// error is the *Warning, so its class can still be found with errors.As.
+func (c *Context) SetWarningsAsErrors(classes ...WarningClass) {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	c.warningsAsErrors = make(map[WarningClass]bool, len(classes))
+	for _, class := range classes {
+		c.warningsAsErrors[class] = true
+	}
+}
+
+func (c *Context) addWarning(class WarningClass, err error) {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	warning := &Warning{Class: class, Err: err}
+	if c.warningsAsErrors[class] {
+		c.promotedWarnings = append(c.promotedWarnings, warning)
+	} else {
+		c.warnings = append(c.warnings, warning)
+	}
+}
+
+// endPhaseWithWarnings ends a phase started with startPhase, and adds the warnings promoted to
+// errors during the phase to errs.
+func (c *Context) endPhaseWithWarnings(errs *[]error) {
+	c.warningsLock.Lock()
+	promoted := c.promotedWarnings
+	c.promotedWarnings = nil
+	c.warningsLock.Unlock()
+
+	for _, warning := range promoted {
+		*errs = append(*errs, warning)
+	}
+	c.recordDiagnostics(c.runningPhase, *errs)
+	c.recordDependencyCycles(*errs)
+	c.endPhase()
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWarningClasses(t *testing.T) {
+	run := func(promote ...WarningClass) (*Context, []error) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("warn", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "A" {
+				ctx.PropertyWarningf("deps", "%s has suspicious deps", ctx.ModuleName())
+			}
+		})
+		ctx.RegisterSingletonType("warn", func() Singleton { return &warningTestSingleton{} })
+		ctx.SetFormatCheck(FormatCheckWarning)
+		ctx.SetWarningsAsErrors(promote...)
+		ctx.MockFileSystem(map[string][]byte{
+			"Blueprints": []byte(`foo_module {
+    name: "A",
+    deps: [ "B"],
+}
+
+foo_module {
+    name: "B",
+}
+`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Blueprints", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		return ctx, errs
+	}
+
+	classes := func(diagnostics []error) []WarningClass {
+		var classes []WarningClass
+		for _, d := range diagnostics {
+			var warning *Warning
+			if !errors.As(d, &warning) {
+				t.Fatalf("%q is not a *Warning", d)
+			}
+			classes = append(classes, warning.Class)
+		}
+		return classes
+	}
+
+	t.Run("warnings", func(t *testing.T) {
+		ctx, errs := run()
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %q", errs)
+		}
+
+		var got []string
+		for _, warning := range ctx.Warnings() {
+			got = append(got, warning.Error())
+		}
+		want := []string{
+			`singleton "warn": checked 2 modules`,
+			`Blueprints:3:1: formatting differs from bpfmt, expected "    deps: [\"B\"],"`,
+			`Blueprints:3:9: module "A": deps: A has suspicious deps`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect warnings\nwant: %q\n got: %q", want, got)
+		}
+
+		wantClasses := []WarningClass{WarningClassSingleton, WarningClassFormat, WarningClassModule}
+		if g := classes(ctx.Warnings()); !reflect.DeepEqual(g, wantClasses) {
+			t.Errorf("incorrect warning classes\nwant: %q\n got: %q", wantClasses, g)
+		}
+
+		var propertyErr *PropertyError
+		if !errors.As(ctx.Warnings()[2], &propertyErr) {
+			t.Errorf("errors.As found no PropertyError in %q", ctx.Warnings()[2])
+		}
+	})
+
+	t.Run("promoted", func(t *testing.T) {
+		ctx, errs := run(WarningClassModule)
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		want := []string{`Blueprints:3:9: module "A": deps: A has suspicious deps`}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("incorrect errors\nwant: %q\n got: %q", want, got)
+		}
+		if g, w := classes(errs), []WarningClass{WarningClassModule}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect error classes\nwant: %q\n got: %q", w, g)
+		}
+
+		if g, w := classes(ctx.Warnings()), []WarningClass{WarningClassFormat}; !reflect.DeepEqual(g, w) {
+			t.Errorf("incorrect warning classes\nwant: %q\n got: %q", w, g)
+		}
+	})
+}